@@ -0,0 +1,378 @@
+package mybase
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AdminAuthFunc is a function which determines whether a request to an
+// AdminServer is permitted, returning a non-nil error if not. See
+// AdminServer.Auth.
+type AdminAuthFunc func(r *http.Request) error
+
+// AdminTokenAuth returns an AdminAuthFunc which only permits requests with an
+// "Authorization: Bearer <token>" header matching the supplied token. Requests
+// supplying the token without the "Bearer " prefix are rejected.
+func AdminTokenAuth(token string) AdminAuthFunc {
+	return func(r *http.Request) error {
+		header := r.Header.Get("Authorization")
+		supplied := strings.TrimPrefix(header, "Bearer ")
+		if token == "" || supplied == header || subtle.ConstantTimeCompare([]byte(supplied), []byte(token)) != 1 {
+			return errors.New("invalid or missing bearer token")
+		}
+		return nil
+	}
+}
+
+// AdminServer is an http.Handler which exposes the effective configuration of a
+// Config, and optionally accepts runtime overrides of option values. It is
+// intended for long-running daemons, and should only be served on a loopback
+// interface; see ListenAndServe. Requests are rejected unless their Host header
+// refers to a loopback host, and the port being served by ListenAndServe if
+// any, which prevents web pages from reaching the endpoints via DNS
+// rebinding. The following endpoints are supported:
+//
+//   - GET /config returns a JSON array describing the effective value and
+//     source of every option, in the same manner as Config.Explain. Values of
+//     options marked with Option.Sensitive are redacted.
+//   - GET /overrides returns a JSON object of the runtime overrides currently
+//     in effect, with sensitive values redacted.
+//   - POST /overrides accepts a JSON object of option names and values, in the
+//     same format as Config.ApplyJSON, and adds them to the runtime overrides.
+//     The request must have a Content-Type of application/json, which browsers
+//     cannot send cross-origin without a preflight request, and a body of at
+//     most 1 MB.
+//   - DELETE /overrides removes all runtime overrides, or only the option named
+//     by the "name" query parameter if supplied.
+//
+// Requests which modify runtime overrides are only permitted if Auth is set,
+// and ReadOnly is false. Note that override values are subject to variable
+// expansion in the same manner as values from option files, if enabled for the
+// Config, but never to command substitution; see
+// Option.AllowCommandSubstitution.
+//
+// Runtime overrides take precedence over the command's option defaults and all
+// sources added to the Config before NewAdminServer was called, but not over
+// the command-line, or any sources added afterwards; see NewAdminServer. They
+// cause any callbacks registered via Config.Watch to run. A request which
+// would result in an error from Config.CheckValues for any overridden option,
+// such as a change to an Immutable option, is rejected without changing
+// anything. Responses for errors are JSON objects with a single "error"
+// member.
+type AdminServer struct {
+	ReadOnly   bool          // if true, requests which modify runtime overrides are rejected; always treated as true if Auth is nil
+	Auth       AdminAuthFunc // if non-nil, requests are rejected unless this returns nil
+	cfg        *Config
+	overrides  *runtimeSource
+	mu         sync.Mutex   // serializes requests which modify overrides
+	listenAddr atomic.Value // address being served by ListenAndServe, if any
+}
+
+// adminMaxBodySize is the maximum size of a request body accepted by an
+// AdminServer.
+const adminMaxBodySize = 1 << 20
+
+// NewAdminServer returns an AdminServer for cfg. A source for runtime overrides
+// is added to cfg immediately, so any sources added to cfg afterwards take
+// precedence over runtime overrides.
+func NewAdminServer(cfg *Config) *AdminServer {
+	as := &AdminServer{
+		cfg:       cfg,
+		overrides: &runtimeSource{values: make(map[string]string)},
+	}
+	cfg.AddSource(as.overrides)
+	return as
+}
+
+// ListenAndServe serves as on addr until ctx is done, at which point the server
+// is shut down gracefully, waiting up to 5 seconds for active requests. Since
+// the endpoints expose configuration and may permit changing it, addr must
+// have a loopback host, such as "127.0.0.1:8081" or "localhost:8081";
+// otherwise an error is returned without listening. A nil error is returned
+// if the server was shut down due to ctx.
+func (as *AdminServer) ListenAndServe(ctx context.Context, addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("Admin endpoint address %s is not a loopback address", addr)
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	as.listenAddr.Store(ln.Addr().String())
+	server := &http.Server{Addr: addr, Handler: as}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			server.Shutdown(shutdownCtx)
+		case <-done:
+		}
+	}()
+	if err := server.Serve(ln); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// adminValue describes the effective value of an option in the response to a
+// GET /config request.
+type adminValue struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// ServeHTTP satisfies the http.Handler interface.
+func (as *AdminServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !as.permittedHost(r.Host) {
+		adminError(w, http.StatusForbidden, fmt.Errorf("host %s not permitted", r.Host))
+		return
+	}
+	if as.Auth != nil {
+		if err := as.Auth(r); err != nil {
+			adminError(w, http.StatusUnauthorized, err)
+			return
+		}
+	}
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	switch {
+	case path == "/config" && r.Method == http.MethodGet:
+		adminJSON(w, as.effectiveValues())
+	case path == "/overrides" && r.Method == http.MethodGet:
+		adminJSON(w, as.overrideValues())
+	case path == "/overrides" && (r.Method == http.MethodPost || r.Method == http.MethodDelete):
+		if as.ReadOnly || as.Auth == nil {
+			adminError(w, http.StatusForbidden, errors.New("admin endpoint is read-only"))
+			return
+		}
+		var err error
+		if r.Method == http.MethodPost {
+			err = as.applyOverrides(w, r)
+		} else {
+			err = as.removeOverrides(r.URL.Query().Get("name"))
+		}
+		if err != nil {
+			adminError(w, http.StatusBadRequest, err)
+			return
+		}
+		adminJSON(w, as.overrideValues())
+	case path == "/config" || path == "/overrides":
+		adminError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not permitted for %s", r.Method, path))
+	default:
+		adminError(w, http.StatusNotFound, fmt.Errorf("unknown endpoint %s", r.URL.Path))
+	}
+}
+
+// permittedHost returns true if host, the Host header of a request, refers to
+// a loopback host, and to the port being served by ListenAndServe if any.
+func (as *AdminServer) permittedHost(host string) bool {
+	name, port, err := net.SplitHostPort(host)
+	if err != nil {
+		name, port = strings.Trim(host, "[]"), ""
+	}
+	if ip := net.ParseIP(name); !strings.EqualFold(name, "localhost") && (ip == nil || !ip.IsLoopback()) {
+		return false
+	}
+	if listenAddr, ok := as.listenAddr.Load().(string); ok {
+		_, listenPort, _ := net.SplitHostPort(listenAddr)
+		return port == listenPort
+	}
+	return true
+}
+
+// effectiveValues returns the value and source of every option of the Config,
+// ordered by name, with sensitive values redacted.
+func (as *AdminServer) effectiveValues() []adminValue {
+	options := as.cfg.CLI.Command.Options()
+	names := make([]string, 0, len(options))
+	for name := range options {
+		if name != "help" && name != "help-all" && name != "version" && !as.cfg.CLI.Command.HasArg(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	result := make([]adminValue, len(names))
+	for n, name := range names {
+		opt := options[name]
+		value := as.cfg.Get(name)
+		if opt.Type == OptionTypeBool {
+			value = fmt.Sprint(BoolValue(value))
+		}
+		result[n] = adminValue{Name: name, Value: opt.displayValue(value), Source: as.cfg.explainSource(opt)}
+	}
+	return result
+}
+
+// overrideValues returns the current runtime overrides, with sensitive values
+// redacted.
+func (as *AdminServer) overrideValues() map[string]string {
+	values := as.overrides.snapshot()
+	for name, value := range values {
+		if opt := as.cfg.FindOption(name); opt != nil {
+			values[name] = opt.displayValue(unquote(value))
+		}
+	}
+	return values
+}
+
+// applyOverrides adds the option values in the body of r to the runtime
+// overrides.
+func (as *AdminServer) applyOverrides(w http.ResponseWriter, r *http.Request) error {
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		return errors.New("request must have Content-Type application/json")
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, adminMaxBodySize))
+	if err != nil {
+		return err
+	}
+	incoming, err := as.cfg.parseJSONValues(string(body), as.overrides.String())
+	if err != nil {
+		return err
+	}
+	return as.changeOverrides(func(values map[string]string) {
+		for name, value := range incoming {
+			values[name] = value
+		}
+	})
+}
+
+// removeOverrides removes the named option from the runtime overrides, or all
+// options if name is empty.
+func (as *AdminServer) removeOverrides(name string) error {
+	if name != "" {
+		opt := as.cfg.FindOption(name)
+		if opt == nil {
+			return OptionNotDefinedError{Name: name, Source: as.overrides.String()}
+		}
+		name = opt.Name
+	}
+	return as.changeOverrides(func(values map[string]string) {
+		for existing := range values {
+			if name == "" || existing == name {
+				delete(values, existing)
+			}
+		}
+	})
+}
+
+// changeOverrides applies change to a copy of the runtime overrides, and then
+// activates the copy, unless it causes Config.CheckValues to return an error
+// for any option whose override was changed. Watch callbacks are run for any
+// options whose values changed.
+func (as *AdminServer) changeOverrides(change func(values map[string]string)) error {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	watched, oldValues := as.cfg.watchedValues()
+	previous := as.overrides.snapshot()
+	values := as.overrides.snapshot()
+	change(values)
+	changed := make(map[string]bool)
+	for name, value := range values {
+		if prev, ok := previous[name]; !ok || prev != value {
+			changed[name] = true
+		}
+	}
+	for name := range previous {
+		if _, ok := values[name]; !ok {
+			changed[name] = true
+		}
+	}
+
+	as.overrides.replace(values)
+	if err := as.cfg.CheckValues(); err != nil {
+		problems, ok := err.(ParseErrors)
+		if !ok {
+			problems = ParseErrors{err}
+		}
+		for _, problem := range problems {
+			if pe, ok := problem.(ParseError); ok && changed[pe.OptionName()] {
+				as.overrides.replace(previous)
+				return problem
+			}
+		}
+	}
+	as.cfg.runWatchers(watched, oldValues)
+	return nil
+}
+
+// adminJSON writes v to w as a JSON response.
+func adminJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// adminError writes err to w as a JSON response with the supplied status code.
+func adminError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+// runtimeSource is an OptionValuer storing runtime overrides supplied to an
+// AdminServer, keyed by canonical option name. Unlike most sources, it may be
+// modified after being added to a Config, so it implements editTracker.
+type runtimeSource struct {
+	// generation is incremented whenever values change. It is accessed
+	// atomically, so it must remain the first field, to guarantee 64-bit
+	// alignment on 32-bit platforms.
+	generation uint64
+
+	mu     sync.RWMutex
+	values map[string]string
+}
+
+func (src *runtimeSource) OptionValue(optionName string) (string, bool) {
+	src.mu.RLock()
+	defer src.mu.RUnlock()
+	value, ok := src.values[optionName]
+	return value, ok
+}
+
+func (src *runtimeSource) String() string {
+	return "admin override"
+}
+
+// editGeneration satisfies the editTracker interface.
+func (src *runtimeSource) editGeneration() uint64 {
+	return atomic.LoadUint64(&src.generation)
+}
+
+// snapshot returns a copy of the source's values.
+func (src *runtimeSource) snapshot() map[string]string {
+	src.mu.RLock()
+	defer src.mu.RUnlock()
+	values := make(map[string]string, len(src.values))
+	for name, value := range src.values {
+		values[name] = value
+	}
+	return values
+}
+
+// replace changes all of the source's values.
+func (src *runtimeSource) replace(values map[string]string) {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	src.values = values
+	atomic.AddUint64(&src.generation, 1)
+}
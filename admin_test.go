@@ -0,0 +1,163 @@
+package mybase
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminServer(t *testing.T) {
+	cmd := simpleCommand()
+	cmd.AddOption(StringOption("password", 0, "", "dummy description").Sensitive())
+	cmd.AddOption(StringOption("ssl-mode", 0, "preferred", "dummy description").Immutable())
+	cfg := ParseFakeCLI(t, cmd, "mycommand --hasshort=cli arg1")
+	f, err := getParsedFile(cfg, false, "visible=file\npassword=s3cr3t\nssl-mode=required\n")
+	if err != nil {
+		t.Fatalf("Unexpected error from getParsedFile: %v", err)
+	}
+	cfg.AddSource(f)
+	cfg.ProtectSource(f)
+	as := NewAdminServer(cfg)
+	as.Auth = AdminTokenAuth("tok")
+	var watched []string
+	cfg.Watch("visible", func(oldValue, newValue string) {
+		watched = append(watched, oldValue+"->"+newValue)
+	})
+
+	request := func(method, target, body string, expectStatus int, result interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Host = "127.0.0.1:8081"
+		req.Header.Set("Authorization", "Bearer tok")
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		rec := httptest.NewRecorder()
+		as.ServeHTTP(rec, req)
+		if rec.Code != expectStatus {
+			t.Errorf("%s %s: expected status %d, instead found %d: %s", method, target, expectStatus, rec.Code, rec.Body.String())
+		} else if result != nil {
+			if err := json.Unmarshal(rec.Body.Bytes(), result); err != nil {
+				t.Errorf("%s %s: unable to decode response: %v", method, target, err)
+			}
+		}
+	}
+	getValue := func(name string) adminValue {
+		t.Helper()
+		var values []adminValue
+		request("GET", "/config", "", http.StatusOK, &values)
+		for _, v := range values {
+			if v.Name == name {
+				return v
+			}
+		}
+		t.Fatalf("Option %s missing from GET /config", name)
+		return adminValue{}
+	}
+
+	// Effective config is exposed, with redaction
+	if v := getValue("password"); v.Value == "s3cr3t" || v.Source == "" {
+		t.Errorf("Unexpected value for password: %+v", v)
+	}
+	if v := getValue("bool1"); v.Value != "false" || v.Source != "default value" {
+		t.Errorf("Unexpected value for bool1: %+v", v)
+	}
+
+	// Overrides take precedence over files but not the CLI, and trigger watchers
+	var overrides map[string]string
+	request("POST", "/overrides", `{"visible": "admin", "hasshort": "admin", "password": "hunter2"}`, http.StatusOK, &overrides)
+	if len(overrides) != 3 || overrides["password"] == "hunter2" {
+		t.Errorf("Unexpected overrides in response: %v", overrides)
+	}
+	if cfg.Get("visible") != "admin" || cfg.Get("hasshort") != "cli" || cfg.Get("password") != "hunter2" {
+		t.Errorf("Unexpected values after override: visible=%q hasshort=%q", cfg.Get("visible"), cfg.Get("hasshort"))
+	}
+	if v := getValue("visible"); v.Value != "admin" || v.Source != "admin override" {
+		t.Errorf("Unexpected value for visible: %+v", v)
+	}
+	if len(watched) != 1 || watched[0] != "file->admin" {
+		t.Errorf("Unexpected watcher calls: %v", watched)
+	}
+
+	// Invalid or immutable overrides are rejected without changes
+	request("POST", "/overrides", `{"nope": 1}`, http.StatusBadRequest, nil)
+	request("POST", "/overrides", `{"ssl-mode": "disabled", "visible": "other"}`, http.StatusBadRequest, nil)
+	if cfg.Get("ssl-mode") != "required" || cfg.Get("visible") != "admin" {
+		t.Errorf("Expected rejected override to have no effect; ssl-mode=%q visible=%q", cfg.Get("ssl-mode"), cfg.Get("visible"))
+	}
+
+	// Overrides may be removed individually or all at once
+	overrides = nil
+	request("DELETE", "/overrides?name=visible", "", http.StatusOK, &overrides)
+	if _, ok := overrides["visible"]; ok || len(overrides) != 2 || cfg.Get("visible") != "file" {
+		t.Errorf("Unexpected overrides after DELETE: %v", overrides)
+	}
+	overrides = nil
+	request("DELETE", "/overrides", "", http.StatusOK, &overrides)
+	if len(overrides) != 0 || cfg.Get("password") != "s3cr3t" {
+		t.Errorf("Unexpected overrides after DELETE: %v", overrides)
+	}
+
+	// Cross-site requests are rejected: non-JSON content types, hosts other than
+	// loopback, and oversized bodies
+	post := func(host, contentType, body string, expectStatus int) {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/overrides", strings.NewReader(body))
+		req.Host = host
+		req.Header.Set("Authorization", "Bearer tok")
+		req.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		as.ServeHTTP(rec, req)
+		if rec.Code != expectStatus {
+			t.Errorf("POST with host %q content type %q: expected status %d, instead found %d: %s", host, contentType, expectStatus, rec.Code, rec.Body.String())
+		}
+	}
+	post("localhost:8081", "application/json; charset=utf-8", `{"visible": "json"}`, http.StatusOK)
+	post("127.0.0.1:8081", "text/plain", `{"visible": "plain"}`, http.StatusBadRequest)
+	post("evil.example.com:8081", "application/json", `{"visible": "rebound"}`, http.StatusForbidden)
+	post("[::1]:8081", "application/json", `{"visible": "`+strings.Repeat("x", adminMaxBodySize)+`"}`, http.StatusBadRequest)
+	if cfg.Get("visible") != "json" {
+		t.Errorf("Expected only permitted request to have an effect; visible=%q", cfg.Get("visible"))
+	}
+	request("DELETE", "/overrides", "", http.StatusOK, nil)
+
+	// Misc errors
+	request("PUT", "/config", "", http.StatusMethodNotAllowed, nil)
+	request("GET", "/nope", "", http.StatusNotFound, nil)
+	as.ReadOnly = true
+	request("POST", "/overrides", `{"visible": "admin"}`, http.StatusForbidden, nil)
+	request("GET", "/overrides/", "", http.StatusOK, nil)
+	as.ReadOnly = false
+	as.Auth = nil
+	request("DELETE", "/overrides", "", http.StatusForbidden, nil)
+	as.Auth = AdminTokenAuth("other")
+	request("GET", "/config", "", http.StatusUnauthorized, nil)
+
+	// The token must be supplied with the "Bearer " prefix
+	auth := AdminTokenAuth("tok")
+	for header, permitted := range map[string]bool{"Bearer tok": true, "tok": false, "": false, "Basic tok": false, "Bearer ": false} {
+		req := httptest.NewRequest("GET", "/config", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		if err := auth(req); (err == nil) != permitted {
+			t.Errorf("Authorization header %q: expected permitted=%t, instead found err=%v", header, permitted, err)
+		}
+	}
+
+	// Non-loopback addresses are rejected
+	if err := as.ListenAndServe(context.Background(), "0.0.0.0:0"); err == nil {
+		t.Error("Expected error from ListenAndServe with non-loopback address, but err is nil")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := as.ListenAndServe(ctx, "127.0.0.1:0"); err != nil {
+		t.Errorf("Unexpected error from ListenAndServe: %v", err)
+	}
+	if as.permittedHost("127.0.0.1:8081") {
+		t.Error("Expected Host header with a port other than the listening port to be rejected")
+	}
+}
@@ -0,0 +1,277 @@
+package mybase
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// NodeKind identifies the type of line represented by an ASTNode.
+type NodeKind int
+
+// Constants representing different NodeKind enumerated values.
+const (
+	NodeBlank     NodeKind = iota // Line containing only whitespace
+	NodeComment                   // Full-line comment, beginning with "#" or ";"
+	NodeSection                   // Section header, e.g. "[production]"
+	NodeOption                    // Option, with or without a value, e.g. "port=3306" or "skip-networking"
+	NodeDirective                 // Directive, e.g. "!include /etc/myapp/extra.cnf"
+	NodeInvalid                   // Malformed line; see ASTNode.Err
+)
+
+// String returns a lowercase description of the kind.
+func (kind NodeKind) String() string {
+	switch kind {
+	case NodeBlank:
+		return "blank"
+	case NodeComment:
+		return "comment"
+	case NodeSection:
+		return "section"
+	case NodeOption:
+		return "option"
+	case NodeDirective:
+		return "directive"
+	default:
+		return "invalid"
+	}
+}
+
+// Position identifies a location within a file's contents.
+type Position struct {
+	Offset int // byte offset from the start of the contents, starting at 0
+	Line   int // line number, starting at 1
+	Column int // byte offset within the line, starting at 1
+}
+
+// String returns the position in "line:column" form.
+func (pos Position) String() string {
+	return fmt.Sprintf("%d:%d", pos.Line, pos.Column)
+}
+
+// Token is a component of an ASTNode, such as an option name or value. Its
+// Text is exactly the contents between Start (inclusive) and End (exclusive).
+// A component which is not present on the line has a zero-value Token, while
+// one which is present but empty, such as the value of "foo=", has an empty
+// Text positioned where the component would begin.
+type Token struct {
+	Text  string
+	Start Position
+	End   Position
+}
+
+// ASTNode represents one logical line of an ini-style option file. A value
+// which spans multiple physical lines, by ending each line but the last with a
+// backslash, is a single ASTNode; in this case its Value Token includes the
+// backslashes, line breaks, and leading whitespace of continuation lines,
+// exactly as they appear in the file.
+//
+// The meaning of the Tokens depends on the Kind:
+//   - NodeComment: Comment is the text after the "#" or ";".
+//   - NodeSection: Key is the section name, excluding the brackets.
+//   - NodeOption: Key is the option name exactly as written, including any
+//     prefix such as "loose-" or "skip-"; NormalizeOptionName converts it to
+//     canonical form. Value is the value as written, including any quotes.
+//   - NodeDirective: Key is the directive name, excluding the "!", and Value is
+//     its argument.
+//
+// Section, option, and directive nodes may also have a Comment, which is the
+// text after the "#" of an inline comment.
+type ASTNode struct {
+	Kind     NodeKind
+	Start    Position // start of the line, including any leading whitespace
+	End      Position // end of the logical line, excluding its line terminator
+	Key      Token
+	Value    Token
+	Comment  Token
+	HasValue bool   // for NodeOption, true if the line contains "=", even if the value is empty
+	Section  string // name of the section containing the line, or "" for the default section; a NodeSection contains itself
+	Err      error  // problem with a NodeInvalid line
+}
+
+// AST returns the syntax tree of the file's contents, with one ASTNode per
+// logical line, in order. Unlike Parse, AST retains the exact position of
+// every component of every line, including comments and blank lines, for use
+// by tooling such as formatters, editors, or language servers. Malformed lines
+// are returned as NodeInvalid nodes, rather than causing an error, so that
+// the remainder of the file may still be examined.
+//
+// The file does not need to have been parsed. If the file's contents were not
+// already loaded via Read, they are read from disk, and any problem doing so is
+// returned as an error. Only ini-style option files are supported; an error
+// is returned for other formats. Directives are not followed, so the contents
+// of included files are not part of the tree.
+func (f *File) AST() ([]ASTNode, error) {
+	if f.syntax() != FileFormatINI {
+		return nil, fmt.Errorf("Unable to build syntax tree of %s: only ini-style option files are supported", f.Path())
+	}
+	r, err := f.rawContents()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	contents, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return parseAST(string(contents)), nil
+}
+
+// parseAST returns the syntax tree of contents; see File.AST.
+func parseAST(contents string) []ASTNode {
+	// Determine the offset at which each physical line starts
+	lineStarts := []int{0}
+	for n := 0; n < len(contents); n++ {
+		if contents[n] == '\n' && n+1 < len(contents) {
+			lineStarts = append(lineStarts, n+1)
+		}
+	}
+	position := func(offset int) Position {
+		line := sort.Search(len(lineStarts), func(i int) bool { return lineStarts[i] > offset })
+		return Position{Offset: offset, Line: line, Column: offset - lineStarts[line-1] + 1}
+	}
+	lineEnd := func(line int) int { // offset of the end of a physical line, excluding its terminator
+		end := len(contents)
+		if line < len(lineStarts) {
+			end = lineStarts[line] - 1
+		} else if end > lineStarts[line-1] && contents[end-1] == '\n' {
+			end--
+		}
+		if end > lineStarts[line-1] && contents[end-1] == '\r' {
+			end--
+		}
+		return end
+	}
+
+	var nodes []ASTNode
+	var section string
+	for line := 1; line <= len(lineStarts); line++ {
+		if line == len(lineStarts) && lineStarts[line-1] == len(contents) {
+			break // no final line after a trailing line terminator
+		}
+
+		// Build the logical line, joining any continuation lines in the same manner
+		// as lineScanner, while tracking the offset of each byte of the logical line
+		start := lineStarts[line-1]
+		text := contents[start:lineEnd(line)]
+		offsets := make([]int, len(text), len(text)+1)
+		for n := range offsets {
+			offsets[n] = start + n
+		}
+		for continuesLine(text) && line < len(lineStarts) {
+			text, offsets = text[:len(text)-1], offsets[:len(offsets)-1]
+			line++
+			next := contents[lineStarts[line-1]:lineEnd(line)]
+			trimmed := strings.TrimLeftFunc(next, unicode.IsSpace)
+			nextStart := lineStarts[line-1] + len(next) - len(trimmed)
+			for n := range trimmed {
+				offsets = append(offsets, nextStart+n)
+			}
+			text += trimmed
+		}
+		end := lineEnd(line)
+		offsets = append(offsets, end)
+		token := func(from, to int) Token { // Token for text[from:to], mapped to the raw contents
+			startOffset, endOffset := offsets[from], offsets[from]
+			if to > from {
+				endOffset = offsets[to-1] + 1
+			}
+			return Token{Text: contents[startOffset:endOffset], Start: position(startOffset), End: position(endOffset)}
+		}
+
+		node := ASTNode{Start: position(start), End: position(end), Section: section}
+		parsed, err := parseLine(text)
+		lead := len(text) - len(strings.TrimLeftFunc(text, unicode.IsSpace))
+		switch {
+		case err != nil:
+			node.Kind, node.Err = NodeInvalid, err
+		case parsed.kind == lineTypeBlank:
+			node.Kind = NodeBlank
+		case parsed.kind == lineTypeComment:
+			node.Kind = NodeComment
+			node.Comment = token(lead+1, len(text))
+		case parsed.kind == lineTypeSectionHeader:
+			node.Kind = NodeSection
+			section, node.Section = parsed.sectionName, parsed.sectionName
+			open := 1
+			if parsed.repeated {
+				open = 2 // "[["
+			}
+			node.Key = token(lead+open, lead+open+len(parsed.sectionName))
+			if hashIndex := strings.IndexByte(text, '#'); hashIndex > -1 {
+				node.Comment = token(hashIndex+1, len(text))
+			}
+		case parsed.kind == lineTypeDirective:
+			node.Kind = NodeDirective
+			nameStart := lead + 1 + len(text[lead+1:]) - len(strings.TrimLeftFunc(text[lead+1:], unicode.IsSpace))
+			nameEnd := nameStart + strings.IndexFunc(text[nameStart:]+" ", unicode.IsSpace)
+			node.Key = token(nameStart, nameEnd)
+			node.Value = trimmedToken(text, nameEnd, len(text), token)
+		default:
+			node.Kind = NodeOption
+			commentIndex := inlineCommentIndex(text)
+			body := len(text)
+			if commentIndex > -1 {
+				node.Comment = token(commentIndex+1, len(text))
+				body = commentIndex
+			}
+			if eq := strings.IndexByte(text[:body], '='); eq > -1 {
+				node.HasValue = true
+				node.Key = trimmedToken(text, lead, eq, token)
+				node.Value = trimmedToken(text, eq+1, body, token)
+			} else {
+				node.Key = trimmedToken(text, lead, body, token)
+			}
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// trimmedToken returns the Token for text[from:to] minus any leading or
+// trailing whitespace, using the supplied func to construct Tokens. If only
+// whitespace remains, the Token is positioned where the whitespace begins.
+func trimmedToken(text string, from, to int, token func(from, to int) Token) Token {
+	segment := text[from:to]
+	trimmedLeft := strings.TrimLeftFunc(segment, unicode.IsSpace)
+	trimmed := strings.TrimRightFunc(trimmedLeft, unicode.IsSpace)
+	if trimmed == "" {
+		return token(from, from)
+	}
+	start := from + len(segment) - len(trimmedLeft)
+	return token(start, start+len(trimmed))
+}
+
+// inlineCommentIndex returns the index of the "#" beginning an inline comment
+// in an option line, or -1 if there is none. Hashes which are escaped or
+// inside of a quoted value do not begin a comment, consistent with parseLine.
+func inlineCommentIndex(line string) int {
+	var inValue, escapeNext bool
+	var inQuote rune
+	for n, c := range line {
+		if escapeNext {
+			escapeNext = false
+			continue
+		}
+		if c == '#' && inQuote == 0 {
+			return n
+		}
+		if !inValue {
+			inValue = (c == '=')
+			continue
+		}
+		switch c {
+		case '\'', '"', '`':
+			if c == inQuote {
+				inQuote = 0
+			} else if inQuote == 0 {
+				inQuote = c
+			}
+		case '\\':
+			escapeNext = true
+		}
+	}
+	return -1
+}
@@ -0,0 +1,99 @@
+package mybase
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestFileAST(t *testing.T) {
+	contents := "# top comment\r\n" +
+		"visible = foo # inline\r\n" +
+		"\r\n" +
+		"[prod] # section comment\n" +
+		"  loose-hasshort='a#b'\n" +
+		"bool1\n" +
+		"hidden=one,\\\n" +
+		"    two\n" +
+		"!include /etc/extra.cnf\n" +
+		"empty=\n" +
+		"[broken\n" +
+		"last=1"
+	f := NewFile("/tmp/fake.cnf")
+	f.contents, f.read = contents, true
+	nodes, err := f.AST()
+	if err != nil {
+		t.Fatalf("Unexpected error from AST: %v", err)
+	}
+
+	// Each token's text must match the contents at its offsets, and its line and
+	// column must be consistent with its offset
+	checkToken := func(tok Token) {
+		t.Helper()
+		if tok == (Token{}) {
+			return
+		}
+		if actual := contents[tok.Start.Offset:tok.End.Offset]; actual != tok.Text {
+			t.Errorf("Token text %q does not match contents %q at its offsets", tok.Text, actual)
+		}
+		for _, pos := range []Position{tok.Start, tok.End} {
+			lines := strings.Split(contents, "\n")
+			var offset int
+			for _, line := range lines[:pos.Line-1] {
+				offset += len(line) + 1
+			}
+			if offset+pos.Column-1 != pos.Offset {
+				t.Errorf("Position %s inconsistent with offset %d", pos, pos.Offset)
+			}
+		}
+	}
+	var summary []string
+	for _, node := range nodes {
+		checkToken(node.Key)
+		checkToken(node.Value)
+		checkToken(node.Comment)
+		summary = append(summary, fmt.Sprintf("%s@%s[%s] %q=%q #%q", node.Kind, node.Start, node.Section, node.Key.Text, node.Value.Text, node.Comment.Text))
+	}
+	expected := []string{
+		`comment@1:1[] ""="" #" top comment"`,
+		`option@2:1[] "visible"="foo" #" inline"`,
+		`blank@3:1[] ""="" #""`,
+		`section@4:1[prod] "prod"="" #" section comment"`,
+		`option@5:1[prod] "loose-hasshort"="'a#b'" #""`,
+		`option@6:1[prod] "bool1"="" #""`,
+		"option@7:1[prod] \"hidden\"=\"one,\\\\\\n    two\" #\"\"",
+		`directive@9:1[prod] "include"="/etc/extra.cnf" #""`,
+		`option@10:1[prod] "empty"="" #""`,
+		`invalid@11:1[prod] ""="" #""`,
+		`option@12:1[prod] "last"="1" #""`,
+	}
+	if len(summary) != len(expected) {
+		t.Fatalf("Expected %d nodes, instead found %d:\n%s", len(expected), len(summary), strings.Join(summary, "\n"))
+	}
+	for n := range expected {
+		if summary[n] != expected[n] {
+			t.Errorf("nodes[%d]: expected %s, found %s", n, expected[n], summary[n])
+		}
+	}
+
+	// Spot-check specific positions and flags
+	if node := nodes[6]; node.End.Line != 8 || node.Value.End.Line != 8 || node.Value.End.Column != 8 {
+		t.Errorf("Unexpected end positions for continued value: node ends %s, value ends %s", node.End, node.Value.End)
+	}
+	if node := nodes[1]; node.End.Column != 23 || node.Value.Start.Column != 11 {
+		t.Errorf("Expected CR to be excluded from line; node ends %s, value starts %s", node.End, node.Value.Start)
+	}
+	if !nodes[8].HasValue || nodes[8].Value.Start.Column != 7 || nodes[5].HasValue {
+		t.Errorf("Unexpected HasValue or empty value position: %+v %+v", nodes[8], nodes[5])
+	}
+	if nodes[9].Err == nil {
+		t.Error("Expected invalid node to have an error")
+	}
+
+	// Non-ini formats are not supported
+	f = NewFile("/tmp/fake.json")
+	f.contents, f.read = "{}", true
+	if _, err := f.AST(); err == nil {
+		t.Error("Expected error from AST of JSON file, but err is nil")
+	}
+}
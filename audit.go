@@ -0,0 +1,166 @@
+package mybase
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// AuditRecord is a structured record of the effective configuration used by
+// an execution of a command, as written by Config.WriteAuditRecord. Each
+// record is written as a single line of JSON.
+type AuditRecord struct {
+	Time      string       `json:"time"`                // Time the record was written, in RFC 3339 format with nanoseconds, in UTC
+	Command   string       `json:"command"`             // Command name, prefixed by the names of any parent commands
+	Version   string       `json:"version"`             // Version of the program, from the top-level command's Summary
+	Args      []AuditValue `json:"args,omitempty"`      // Positional args, in order
+	Options   []AuditValue `json:"options"`             // Options, sorted by name; the built-in help and version options are omitted
+	Signature string       `json:"signature,omitempty"` // HMAC-SHA256 of the rest of the record, if a key was supplied
+}
+
+// AuditValue describes the effective value of an option or positional arg in
+// an AuditRecord. Exactly one of Value or Hash is populated.
+type AuditValue struct {
+	Name   string  `json:"name"`
+	Value  *string `json:"value,omitempty"` // Value as returned by Config.Get; boolean options are "true" or "false"; nil for Sensitive options
+	Hash   string  `json:"hash,omitempty"`  // Hash of the value of a Sensitive option, prefixed by the algorithm, for example "sha256:..."
+	Source string  `json:"source"`          // Description of the source supplying the value, as shown by Config.Explain
+}
+
+// SetAuditLog configures cmd to write an AuditRecord to w prior to each
+// execution of the handler of cmd, or of any of its descendant subcommands
+// which do not have their own audit log. The record is written before any
+// pre-run hooks, and is not written in the same situations in which pre-run
+// hooks do not run; see AddPreRunHook. If writing the record fails, the handler
+// is not run, and the error is returned by Config.HandleCommand, so that a
+// command never runs without a record of its configuration.
+//
+// If key is non-empty, each record is signed using HMAC-SHA256 with key, and
+// values of Sensitive options are hashed using HMAC-SHA256 with key rather
+// than plain SHA-256, which prevents guessing low-entropy values from their
+// hashes. Records may be verified using VerifyAuditRecord. Supplying a nil w
+// reverts to the audit log of cmd's parent command, if any.
+//
+// Each record is written using a single call to w.Write, but w must be safe
+// for concurrent use if multiple commands may run concurrently.
+func (cmd *Command) SetAuditLog(w io.Writer, key []byte) {
+	cmd.auditLog = w
+	cmd.auditKey = key
+}
+
+// activeAuditLog returns the audit log and key of cmd, or of its closest
+// ancestor with an audit log, or a nil writer if there is none.
+func (cmd *Command) activeAuditLog() (io.Writer, []byte) {
+	for current := cmd; current != nil; current = current.ParentCommand {
+		if current.auditLog != nil {
+			return current.auditLog, current.auditKey
+		}
+	}
+	return nil, nil
+}
+
+// WriteAuditRecord writes an AuditRecord describing the effective value of
+// every option and positional arg of cfg's command, along with the source of
+// each value, to w as a single line of JSON. Values of options marked with
+// Option.Sensitive are hashed rather than written. If key is non-empty, the
+// record is signed and sensitive values are hashed using HMAC-SHA256 with key;
+// see Command.SetAuditLog.
+func (cfg *Config) WriteAuditRecord(w io.Writer, key []byte) error {
+	cmd := cfg.CLI.Command
+	root := cmd
+	for root.ParentCommand != nil {
+		root = root.ParentCommand
+	}
+	record := AuditRecord{
+		Time:    time.Now().UTC().Format(time.RFC3339Nano),
+		Command: cmd.fullName(),
+		Version: root.Summary,
+		Options: []AuditValue{},
+	}
+	for _, arg := range cmd.args {
+		record.Args = append(record.Args, cfg.auditValue(arg, key))
+	}
+	options := cmd.Options()
+	names := make([]string, 0, len(options))
+	for name := range options {
+		if name != "help" && name != "help-all" && name != "version" && !cmd.HasArg(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		record.Options = append(record.Options, cfg.auditValue(options[name], key))
+	}
+
+	if len(key) > 0 {
+		unsigned, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		record.Signature = auditHash(key, unsigned)
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// auditValue returns an AuditValue describing the effective value of opt.
+func (cfg *Config) auditValue(opt *Option, key []byte) AuditValue {
+	value := cfg.Get(opt.Name)
+	if opt.Type == OptionTypeBool {
+		value = fmt.Sprint(BoolValue(value))
+	}
+	av := AuditValue{Name: opt.Name, Source: cfg.explainSource(opt)}
+	if opt.sensitive {
+		av.Hash = auditHash(key, []byte(value))
+	} else {
+		av.Value = &value
+	}
+	return av
+}
+
+// auditHash returns a hash of data, prefixed by the algorithm used:
+// HMAC-SHA256 if key is non-empty, or plain SHA-256 otherwise.
+func auditHash(key, data []byte) string {
+	if len(key) == 0 {
+		sum := sha256.Sum256(data)
+		return "sha256:" + hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyAuditRecord parses line, a single record written by WriteAuditRecord,
+// and verifies its signature using key. An error is returned if line cannot be
+// parsed, is unsigned, or has an incorrect signature, which indicates that the
+// record was modified or signed with a different key.
+func VerifyAuditRecord(line []byte, key []byte) (*AuditRecord, error) {
+	var record AuditRecord
+	if err := json.Unmarshal(line, &record); err != nil {
+		return nil, err
+	}
+	if record.Signature == "" {
+		return nil, errors.New("Audit record is not signed")
+	}
+	signature := record.Signature
+	record.Signature = ""
+	unsigned, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal([]byte(signature), []byte(auditHash(key, unsigned))) {
+		return nil, errors.New("Audit record signature does not match")
+	}
+	record.Signature = signature
+	return &record, nil
+}
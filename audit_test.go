@@ -0,0 +1,90 @@
+package mybase
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestAuditLog(t *testing.T) {
+	var buf bytes.Buffer
+	var handled bool
+	cmd := simpleCommand()
+	cmd.AddOption(StringOption("password", 'p', "", "dummy description").Sensitive())
+	cmd.Handler = func(cfg *Config) error {
+		handled = true
+		return nil
+	}
+	key := []byte("secret key")
+	cmd.SetAuditLog(&buf, key)
+
+	cfg := ParseFakeCLI(t, cmd, "mycommand --password=hunter2 -b arg1", SimpleSource{"visible": "fromsource"})
+	if err := cfg.HandleCommand(); err != nil {
+		t.Fatalf("Unexpected error from HandleCommand: %v", err)
+	}
+	if !handled {
+		t.Error("Expected handler to run")
+	}
+	line := buf.Bytes()
+	if bytes.Count(line, []byte("\n")) != 1 || bytes.Contains(line, []byte("hunter2")) {
+		t.Fatalf("Unexpected audit log contents: %s", line)
+	}
+	record, err := VerifyAuditRecord(line, key)
+	if err != nil {
+		t.Fatalf("Unexpected error from VerifyAuditRecord: %v", err)
+	}
+	if record.Command != "mycommand" || record.Version != "summary" || len(record.Args) != 2 || *record.Args[0].Value != "arg1" {
+		t.Errorf("Unexpected audit record: %+v", record)
+	}
+	values := make(map[string]AuditValue)
+	for _, av := range record.Options {
+		values[av.Name] = av
+	}
+	if _, ok := values["help"]; ok {
+		t.Error("Expected help option to be omitted from audit record")
+	}
+	if av := values["bool1"]; *av.Value != "true" || av.Source != "command line" {
+		t.Errorf("Unexpected audit value for bool1: %+v", av)
+	}
+	if av := values["visible"]; *av.Value != "fromsource" {
+		t.Errorf("Unexpected audit value for visible: %+v", av)
+	}
+	if av := values["password"]; av.Value != nil || av.Hash != auditHash(key, []byte("hunter2")) || !strings.HasPrefix(av.Hash, "hmac-sha256:") {
+		t.Errorf("Unexpected audit value for password: %+v", av)
+	}
+
+	// Tampering or an incorrect key is detected
+	if _, err := VerifyAuditRecord(bytes.Replace(line, []byte("fromsource"), []byte("tampered"), 1), key); err == nil {
+		t.Error("Expected error verifying tampered record, but received nil")
+	}
+	if _, err := VerifyAuditRecord(line, []byte("wrong key")); err == nil {
+		t.Error("Expected error verifying record with wrong key, but received nil")
+	}
+
+	// Without a key, records are unsigned and sensitive values use plain SHA-256
+	buf.Reset()
+	if err := cfg.WriteAuditRecord(&buf, nil); err != nil {
+		t.Fatalf("Unexpected error from WriteAuditRecord: %v", err)
+	}
+	if _, err := VerifyAuditRecord(buf.Bytes(), key); err == nil {
+		t.Error("Expected error verifying unsigned record, but received nil")
+	}
+	if !strings.Contains(buf.String(), `"hash":"sha256:`) {
+		t.Errorf("Expected unkeyed hash of sensitive value, instead found %s", buf.String())
+	}
+
+	// Failure to write the record prevents the handler from running
+	handled = false
+	cmd.SetAuditLog(failingWriter{}, nil)
+	cfg = ParseFakeCLI(t, cmd, "mycommand arg1")
+	if err := cfg.HandleCommand(); err == nil || handled {
+		t.Errorf("Expected audit log failure to prevent handler, instead err=%v handled=%t", err, handled)
+	}
+}
@@ -0,0 +1,480 @@
+// Package aws provides an option source for github.com/skeema/mybase which
+// obtains option values from AWS Systems Manager Parameter Store or AWS Secrets
+// Manager.
+package aws
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skeema/mybase"
+)
+
+// Service identifies the AWS service from which a Source obtains option
+// values.
+type Service int
+
+// Constants for the services supported by Source
+const (
+	ParameterStore Service = iota // AWS Systems Manager Parameter Store
+	SecretsManager                // AWS Secrets Manager
+)
+
+// Source is an option source which obtains option values from AWS Systems
+// Manager Parameter Store or AWS Secrets Manager. Option names are mapped by
+// convention, based on Name:
+//
+//   - With ParameterStore, Name is a path prefix such as "/myapp/prod".
+//     Each parameter directly beneath that path supplies the option of the
+//     same name, so for example parameter "/myapp/prod/password" supplies the
+//     password option. SecureString parameters are decrypted.
+//   - With SecretsManager, Name is the name or ARN of a secret, which must
+//     contain a JSON object. Each key of the object supplies the option of the
+//     same name.
+//
+// In both cases, underscores may be used in place of dashes, and parameters or
+// keys which do not correspond to an option of the Config passed to Load are
+// ignored, as are parameters in any deeper paths.
+//
+// Requests are signed using credentials from the AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY, and AWS_SESSION_TOKEN environment variables, or else
+// from the AWS shared credentials file (~/.aws/credentials, or the path in
+// AWS_SHARED_CREDENTIALS_FILE). The profile used from the shared credentials
+// file is the value of ProfileOption, or the AWS_PROFILE environment variable,
+// or "default"; if ProfileOption has a value, the environment variables are
+// not used. Other credential providers, such as EC2 instance roles, are not
+// supported.
+//
+// The region is obtained from the Region field, or the value of RegionOption,
+// or the AWS_REGION or AWS_DEFAULT_REGION environment variables, or the
+// profile's region setting in the AWS config file (~/.aws/config, or the path
+// in AWS_CONFIG_FILE), in that order.
+type Source struct {
+	Service       Service       // service to obtain values from
+	Name          string        // path prefix of parameters, or name or ARN of secret
+	Region        string        // AWS region, e.g. "us-east-1"; see type documentation if empty
+	RegionOption  string        // name of an option whose value is an AWS region
+	ProfileOption string        // name of an option whose value is a profile name in the AWS shared config files
+	Endpoint      string        // base URL of the service, e.g. for a VPC endpoint; derived from the service and region if empty
+	CacheTTL      time.Duration // duration after a successful fetch during which Load does not re-fetch
+	Timeout       time.Duration // maximum duration of each request to AWS
+	Client        *http.Client  // HTTP client to use; a default client is used if nil
+	mu            sync.RWMutex  // protects all unexported fields below
+	values        map[string]string
+	fetchedAt     time.Time
+	version       uint64
+}
+
+// NewSource returns a Source which obtains option values from the supplied
+// service, using name as described in the Source documentation. The source
+// must be loaded via Load prior to use.
+func NewSource(service Service, name string) *Source {
+	return &Source{
+		Service: service,
+		Name:    name,
+	}
+}
+
+// Load fetches the parameters or secret from AWS, using cfg to obtain the
+// values of RegionOption and ProfileOption, and to determine which option
+// names are valid. If the values were successfully fetched within the past
+// CacheTTL, Load does nothing.
+//
+// If the request fails and values were previously fetched successfully, the
+// previous values continue to be used, and a warning is reported via cfg.Warn.
+// Otherwise an error is returned. If the source was already added to a Config,
+// the Config automatically reflects any changed values after a successful
+// Load.
+func (src *Source) Load(cfg *mybase.Config) error {
+	src.mu.RLock()
+	previous, fetchedAt := src.values, src.fetchedAt
+	src.mu.RUnlock()
+	if previous != nil && src.CacheTTL > 0 && time.Since(fetchedAt) < src.CacheTTL {
+		return nil
+	}
+
+	fetched, err := src.fetch(cfg)
+	if err != nil {
+		if previous == nil {
+			return err
+		}
+		cfg.Warn("Unable to fetch options from %s, using previously-fetched values instead: %s", src, err)
+		return nil
+	}
+	values := make(map[string]string, len(fetched))
+	for name, value := range fetched {
+		if opt := cfg.FindOption(name); opt != nil {
+			values[opt.Name] = value
+		}
+	}
+
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	if src.values == nil || !reflect.DeepEqual(values, src.values) {
+		src.version++
+	}
+	src.values = values
+	src.fetchedAt = time.Now()
+	return nil
+}
+
+// OptionValue satisfies the OptionValuer interface. Values are quoted if
+// necessary, so that they are returned verbatim by Config getters. Panics if
+// the source has not been loaded yet, since this is indicative of programmer
+// error.
+func (src *Source) OptionValue(optionName string) (string, bool) {
+	src.mu.RLock()
+	defer src.mu.RUnlock()
+	if src.values == nil {
+		panic(fmt.Errorf("Call to OptionValue(\"%s\") on %s which has not been loaded", optionName, src))
+	}
+	value, ok := src.values[optionName]
+	if !ok {
+		return "", false
+	}
+	return mybase.QuoteValue(value), true
+}
+
+// OptionValuesVersion satisfies the mybase.VersionedOptionValuer interface.
+// The version changes whenever a Load results in different values.
+func (src *Source) OptionValuesVersion() uint64 {
+	src.mu.RLock()
+	defer src.mu.RUnlock()
+	return src.version
+}
+
+func (src *Source) String() string {
+	if src.Service == SecretsManager {
+		return fmt.Sprintf("AWS Secrets Manager secret %s", src.Name)
+	}
+	return fmt.Sprintf("AWS Parameter Store path %s", src.Name)
+}
+
+// fetch requests the parameters or secret from AWS, returning a map of option
+// names to values. Option names are not canonicalized or validated.
+func (src *Source) fetch(cfg *mybase.Config) (map[string]string, error) {
+	var profile string
+	if src.ProfileOption != "" {
+		profile = cfg.Get(src.ProfileOption)
+	}
+	creds, err := loadCredentials(profile)
+	if err != nil {
+		return nil, err
+	}
+	region := src.Region
+	if region == "" && src.RegionOption != "" {
+		region = cfg.Get(src.RegionOption)
+	}
+	if region == "" {
+		if region, err = loadRegion(profile); err != nil {
+			return nil, err
+		}
+	}
+
+	result := make(map[string]string)
+	if src.Service == SecretsManager {
+		var resp struct {
+			SecretString string
+		}
+		if err := src.request(creds, region, "GetSecretValue", map[string]interface{}{"SecretId": src.Name}, &resp); err != nil {
+			return nil, err
+		}
+		var data map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(resp.SecretString), &data); err != nil {
+			return nil, fmt.Errorf("%s does not contain a JSON object: %s", src, err)
+		}
+		for key, raw := range data {
+			var s string
+			if err := json.Unmarshal(raw, &s); err == nil {
+				result[key] = s
+			} else {
+				result[key] = string(raw)
+			}
+		}
+		return result, nil
+	}
+
+	path := "/" + strings.Trim(src.Name, "/")
+	body := map[string]interface{}{"Path": path, "Recursive": false, "WithDecryption": true}
+	for {
+		var resp struct {
+			Parameters []struct {
+				Name  string
+				Value string
+			}
+			NextToken string
+		}
+		if err := src.request(creds, region, "GetParametersByPath", body, &resp); err != nil {
+			return nil, err
+		}
+		for _, param := range resp.Parameters {
+			name := strings.TrimPrefix(strings.TrimPrefix(param.Name, path), "/")
+			if name != "" && !strings.Contains(name, "/") {
+				result[name] = param.Value
+			}
+		}
+		if resp.NextToken == "" {
+			return result, nil
+		}
+		body["NextToken"] = resp.NextToken
+	}
+}
+
+// request sends a signed request for the supplied action to the AWS JSON API
+// of the source's service, decoding the JSON response into dest.
+func (src *Source) request(creds credentials, region, action string, params map[string]interface{}, dest interface{}) error {
+	service, target := "ssm", "AmazonSSM."+action
+	if src.Service == SecretsManager {
+		service, target = "secretsmanager", "secretsmanager."+action
+	}
+	endpoint := src.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.%s.amazonaws.com", service, region)
+	}
+	ctx := context.Background()
+	if src.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, src.Timeout)
+		defer cancel()
+	}
+	body, _ := json.Marshal(params)
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(endpoint, "/")+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", target)
+	signRequest(req, body, creds, region, service, time.Now())
+
+	client := src.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// AWS JSON APIs describe errors with a "__type" field, e.g.
+		// "ParameterNotFound" or "AccessDeniedException"
+		var awsErr struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&awsErr)
+		if awsErr.Type != "" {
+			return fmt.Errorf("Unable to fetch %s: %s %s", src, awsErr.Type, awsErr.Message)
+		}
+		return fmt.Errorf("Unexpected HTTP response status %s from %s", resp.Status, req.URL)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(dest); err != nil {
+		return fmt.Errorf("Unexpected response format from %s: %s", req.URL, err)
+	}
+	return nil
+}
+
+// credentials holds the credentials used to sign requests to AWS.
+type credentials struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+}
+
+// loadCredentials returns credentials from the environment, or from the
+// named profile of the AWS shared credentials file. If profile is "", the
+// environment is checked first, followed by the profile named by AWS_PROFILE
+// or "default".
+func loadCredentials(profile string) (credentials, error) {
+	if profile == "" {
+		if creds := (credentials{
+			accessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			secretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			sessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}); creds.accessKeyID != "" && creds.secretAccessKey != "" {
+			return creds, nil
+		}
+		if profile = os.Getenv("AWS_PROFILE"); profile == "" {
+			profile = "default"
+		}
+	}
+	path := sharedFilePath("AWS_SHARED_CREDENTIALS_FILE", "credentials")
+	values, err := sharedFileSection(path, profile)
+	if err != nil {
+		return credentials{}, err
+	}
+	creds := credentials{
+		accessKeyID:     values["aws_access_key_id"],
+		secretAccessKey: values["aws_secret_access_key"],
+		sessionToken:    values["aws_session_token"],
+	}
+	if creds.accessKeyID == "" || creds.secretAccessKey == "" {
+		return creds, fmt.Errorf("No AWS credentials found in environment or in profile %q of %s", profile, path)
+	}
+	return creds, nil
+}
+
+// loadRegion returns the region from the environment, or from the named
+// profile of the AWS config file. If profile is "", the profile named by
+// AWS_PROFILE or "default" is used.
+func loadRegion(profile string) (string, error) {
+	for _, name := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if region := os.Getenv(name); region != "" {
+			return region, nil
+		}
+	}
+	if profile == "" {
+		if profile = os.Getenv("AWS_PROFILE"); profile == "" {
+			profile = "default"
+		}
+	}
+	// Unlike the credentials file, the config file prefixes non-default
+	// profile names with "profile "
+	section := profile
+	if profile != "default" {
+		section = "profile " + profile
+	}
+	path := sharedFilePath("AWS_CONFIG_FILE", "config")
+	values, err := sharedFileSection(path, section)
+	if err != nil {
+		return "", err
+	} else if values["region"] == "" {
+		return "", errors.New("No AWS region configured")
+	}
+	return values["region"], nil
+}
+
+// sharedFilePath returns the path of an AWS shared config file, which may be
+// overridden by the named environment variable.
+func sharedFilePath(envVar, fileName string) string {
+	if path := os.Getenv(envVar); path != "" {
+		return path
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".aws", fileName)
+}
+
+// sharedFileSection returns the values in the named section of an AWS shared
+// config file, keyed by lowercase setting name. If the file does not exist, an
+// empty map is returned.
+func sharedFileSection(path, sectionName string) (map[string]string, error) {
+	values := make(map[string]string)
+	osFile, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return values, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer osFile.Close()
+	var inSection bool
+	scanner := bufio.NewScanner(osFile)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		} else if line[0] == '[' && line[len(line)-1] == ']' {
+			inSection = (strings.TrimSpace(line[1:len(line)-1]) == sectionName)
+		} else if eq := strings.IndexByte(line, '='); inSection && eq > 0 {
+			values[strings.ToLower(strings.TrimSpace(line[:eq]))] = strings.TrimSpace(line[eq+1:])
+		}
+	}
+	return values, scanner.Err()
+}
+
+// signRequest adds the headers needed to authenticate req using AWS
+// Signature Version 4. The request's body must be supplied separately, since
+// it cannot be read from req without consuming it.
+func signRequest(req *http.Request, body []byte, creds credentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	uri := req.URL.EscapedPath()
+	if uri == "" {
+		uri = "/"
+	}
+	bodyHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uri,
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+	signature := hex.EncodeToString(hmacSHA256(signingKey(creds.secretAccessKey, date, region, service), stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", creds.accessKeyID, scope, signedHeaders, signature))
+}
+
+// signingKey derives the key used to sign requests with AWS Signature
+// Version 4.
+func signingKey(secretAccessKey, date, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+// canonicalQuery returns the canonical form of a query string for AWS
+// Signature Version 4: sorted by key and then value, with strict escaping.
+func canonicalQuery(query url.Values) string {
+	var pairs []string
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, uriEscape(key)+"="+uriEscape(value))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// uriEscape percent-encodes s in the manner required by AWS Signature Version
+// 4, which differs from url.QueryEscape in its handling of spaces and tildes.
+func uriEscape(s string) string {
+	return strings.Replace(strings.Replace(url.QueryEscape(s), "+", "%20", -1), "%7E", "~", -1)
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data using key.
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,177 @@
+package aws
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/skeema/mybase"
+)
+
+func TestSignRequest(t *testing.T) {
+	// Test vectors from AWS's Signature Version 4 documentation and test suite
+	secret := "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	if key := hex.EncodeToString(signingKey(secret, "20120215", "us-east-1", "iam")); key != "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d" {
+		t.Errorf("Unexpected signing key %s", key)
+	}
+	req, err := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error from NewRequest: %v", err)
+	}
+	now, _ := time.Parse("20060102T150405Z", "20150830T123600Z")
+	signRequest(req, nil, credentials{accessKeyID: "AKIDEXAMPLE", secretAccessKey: secret}, "us-east-1", "service", now)
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if actual := req.Header.Get("Authorization"); actual != expected {
+		t.Errorf("Unexpected Authorization header:\n%s\nexpected:\n%s", actual, expected)
+	}
+}
+
+func TestSource(t *testing.T) {
+	var requests, unavailable int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&unavailable) > 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if auth := r.Header.Get("Authorization"); !strings.Contains(auth, "Credential=AKIDFILE/") || !strings.Contains(auth, "/us-west-2/") {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"__type": "UnrecognizedClientException", "message": "bad credentials"}`)
+			return
+		}
+		atomic.AddInt32(&requests, 1)
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		switch r.Header.Get("X-Amz-Target") {
+		case "AmazonSSM.GetParametersByPath":
+			if body["Path"] != "/myapp/prod" || body["WithDecryption"] != true {
+				w.WriteHeader(http.StatusBadRequest)
+			} else if body["NextToken"] == nil {
+				fmt.Fprint(w, `{"Parameters": [{"Name": "/myapp/prod/visible", "Value": "from ssm"}, {"Name": "/myapp/prod/unknown", "Value": "x"}, {"Name": "/myapp/prod/db_password", "Value": "pw"}, {"Name": "/myapp/prod/deeper/visible", "Value": "x"}], "NextToken": "page2"}`)
+			} else {
+				fmt.Fprint(w, `{"Parameters": [{"Name": "/myapp/prod/hasshort", "Value": "it's #2"}]}`)
+			}
+		case "secretsmanager.GetSecretValue":
+			if body["SecretId"] != "myapp/creds" {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"__type": "ResourceNotFoundException", "message": "not found"}`)
+				return
+			}
+			fmt.Fprint(w, `{"SecretString": "{\"visible\": \"from sm\", \"hidden\": 42}"}`)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	// Credentials and region come from a profile in the shared config files
+	dir, err := ioutil.TempDir("", "mybasetest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	credsPath, configPath := filepath.Join(dir, "credentials"), filepath.Join(dir, "config")
+	ioutil.WriteFile(credsPath, []byte("[default]\naws_access_key_id = AKIDDEFAULT\naws_secret_access_key = x\n\n[ops]\naws_access_key_id = AKIDFILE\naws_secret_access_key = secret/key+1\n"), 0600)
+	ioutil.WriteFile(configPath, []byte("[default]\nregion = us-east-1\n[profile ops]\nregion = us-west-2\n"), 0600)
+	for name, value := range map[string]string{"AWS_SHARED_CREDENTIALS_FILE": credsPath, "AWS_CONFIG_FILE": configPath, "AWS_ACCESS_KEY_ID": "AKIDENV", "AWS_SECRET_ACCESS_KEY": "y", "AWS_REGION": "", "AWS_DEFAULT_REGION": "", "AWS_PROFILE": ""} {
+		defer os.Setenv(name, os.Getenv(name))
+		os.Setenv(name, value)
+	}
+
+	cmd := mybase.NewCommand("mycommand", "summary", "description", nil)
+	cmd.AddOption(mybase.StringOption("visible", 0, "", "dummy"))
+	cmd.AddOption(mybase.StringOption("hidden", 0, "somedefault", "dummy").Hidden())
+	cmd.AddOption(mybase.StringOption("hasshort", 's', "", "dummy"))
+	cmd.AddOption(mybase.BoolOption("bool1", 'b', false, "dummy"))
+	cmd.AddArg("required", "", true)
+	cmd.AddOption(mybase.StringOption("aws-profile", 0, "", "dummy"))
+	cmd.AddOption(mybase.StringOption("db-password", 0, "", "dummy"))
+	var warnings []string
+	cfg := mybase.ParseFakeCLI(t, cmd, "mycommand --aws-profile=ops arg1")
+	cfg.WarningHandler = func(message string) {
+		warnings = append(warnings, message)
+	}
+
+	src := NewSource(ParameterStore, "/myapp/prod/")
+	src.Endpoint = server.URL
+	src.ProfileOption = "aws-profile"
+	src.CacheTTL = time.Hour
+	if err := src.Load(cfg); err != nil {
+		t.Fatalf("Unexpected error from Load: %v", err)
+	}
+	cfg.AddSource(src)
+	if actual := cfg.Get("visible"); actual != "from ssm" {
+		t.Errorf("Unexpected value for visible: %q", actual)
+	}
+	if actual := cfg.Get("hasshort"); actual != "it's #2" {
+		t.Errorf("Unexpected value for hasshort: %q", actual)
+	}
+	if actual := cfg.Get("db-password"); actual != "pw" {
+		t.Errorf("Unexpected value for db-password: %q", actual)
+	}
+	if source := cfg.Source("visible"); source != src {
+		t.Errorf("Expected value to come from Source, instead found %v", source)
+	}
+	if atomic.LoadInt32(&requests) != 2 {
+		t.Errorf("Expected 2 requests for 2 pages of parameters, instead found %d", requests)
+	}
+
+	// Within CacheTTL, Load should not make any requests
+	if err := src.Load(cfg); err != nil || atomic.LoadInt32(&requests) != 2 {
+		t.Errorf("Expected cached Load to succeed without requests; err=%v requests=%d", err, requests)
+	}
+
+	// Upon failure, previous values should be retained with a warning
+	src.CacheTTL = 0
+	atomic.StoreInt32(&unavailable, 1)
+	if err := src.Load(cfg); err != nil {
+		t.Errorf("Expected Load to fall back to previous values, instead got error %v", err)
+	} else if len(warnings) != 1 || cfg.Get("visible") != "from ssm" {
+		t.Errorf("Unexpected warnings %v or value %q", warnings, cfg.Get("visible"))
+	}
+	atomic.StoreInt32(&unavailable, 0)
+
+	// Secrets Manager, with an explicit region
+	sm := NewSource(SecretsManager, "myapp/creds")
+	sm.Endpoint = server.URL
+	sm.ProfileOption = "aws-profile"
+	sm.Region = "us-west-2"
+	if err := sm.Load(cfg); err != nil {
+		t.Fatalf("Unexpected error from Load: %v", err)
+	}
+	cfg.AddSource(sm)
+	if cfg.Get("visible") != "from sm" || cfg.Get("hidden") != "42" {
+		t.Errorf("Unexpected values from Secrets Manager: visible=%q hidden=%q", cfg.Get("visible"), cfg.Get("hidden"))
+	}
+	if _, ok := sm.OptionValue("bool1"); ok {
+		t.Error("Expected option not in secret to be absent")
+	}
+
+	// Errors from the service should be described, and credentials from the
+	// environment take effect if no profile is selected via ProfileOption
+	sm = NewSource(SecretsManager, "nonexistent")
+	sm.Endpoint = server.URL
+	sm.ProfileOption = "aws-profile"
+	if err := sm.Load(cfg); err == nil || !strings.Contains(err.Error(), "ResourceNotFoundException") {
+		t.Errorf("Expected error describing missing secret, instead found %v", err)
+	}
+	sm = NewSource(SecretsManager, "myapp/creds")
+	sm.Endpoint = server.URL
+	if err := sm.Load(cfg); err == nil || !strings.Contains(err.Error(), "UnrecognizedClientException") {
+		t.Errorf("Expected error from environment credentials, instead found %v", err)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected OptionValue on unloaded source to panic, but it did not")
+		}
+	}()
+	sm.OptionValue("visible")
+}
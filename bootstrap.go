@@ -0,0 +1,83 @@
+package mybase
+
+import "errors"
+
+// Bootstrap marks an Option as a meta-option: one which controls how the rest
+// of the program's configuration is assembled, such as which option files are
+// read, rather than configuring the program's behavior directly. Meta-options
+// are resolved by BootstrapCLI before the command-line is fully parsed. The
+// options added by Command.AddDefaultsFileOptions and Command.AddProfileOption
+// are already marked in this manner.
+func (opt *Option) Bootstrap() *Option {
+	opt.bootstrap = true
+	return opt
+}
+
+// BootstrapCLI performs a preliminary pass over args, resolving only the
+// options of cmd (or the subcommand named in args) which have been marked with
+// Option.Bootstrap. This permits the choice of option files, login paths,
+// profiles, or color output to depend on the command-line, before the main
+// pass via ParseCLI, which still receives the full args and parses all options
+// including meta-options as usual.
+//
+// The first pass is lenient: unknown options, invalid values of options other
+// than meta-options, positional args, and any args after "--" are all ignored,
+// since these are reported by ParseCLI instead. An error is only returned if a
+// meta-option is missing a required value or has an invalid value. In the
+// returned Config, only meta-options may be supplied by the command-line; all
+// other options have their default values.
+func BootstrapCLI(cmd *Command, args []string) (*Config, error) {
+	if len(args) == 0 {
+		return nil, errors.New("BootstrapCLI: No command-line supplied")
+	}
+	scratch := &CommandLine{
+		Command:      cmd,
+		InvokedAs:    args[0],
+		OptionValues: make(map[string]string),
+		spellings:    make(map[string]string),
+	}
+	args = args[1:]
+
+	longOptionIndex, shortOptionIndex := cliOptionIndexes(cmd)
+	for len(args) > 0 {
+		arg := args[0]
+		args = args[1:]
+		var err error
+		switch {
+		case arg == "--":
+			args = nil
+		case len(arg) > 2 && arg[0:2] == "--":
+			err = scratch.parseLongArg(arg[2:], &args, longOptionIndex)
+		case len(arg) > 1 && arg[0] == '-' && !(isNegativeNumber(arg) && shortOptionIndex[rune(arg[1])] == nil):
+			err = scratch.parseShortArgs(arg[1:], &args, shortOptionIndex)
+		case scratch.Command.SubCommands[arg] != nil:
+			scratch.Command = scratch.Command.SubCommands[arg]
+			longOptionIndex, shortOptionIndex = cliOptionIndexes(scratch.Command)
+			if scratch.Command.rawArgs {
+				args = nil
+			}
+		case len(scratch.Command.SubCommands) == 0 && scratch.Command.effectiveArgOrder() == ArgOrderOptionsFirst:
+			args = nil // positional arg ends option parsing
+		}
+		if perr, ok := err.(ParseError); ok {
+			if opt := longOptionIndex[perr.OptionName()]; opt != nil && opt.bootstrap {
+				return nil, err
+			}
+		}
+	}
+
+	cli := &CommandLine{
+		Command:      scratch.Command,
+		InvokedAs:    scratch.InvokedAs,
+		OptionValues: make(map[string]string),
+		ArgValues:    make([]string, 0),
+		spellings:    make(map[string]string),
+	}
+	for name, value := range scratch.OptionValues {
+		if opt := longOptionIndex[name]; opt != nil && opt.bootstrap {
+			cli.OptionValues[name] = value
+			cli.spellings[name] = scratch.spellings[name]
+		}
+	}
+	return NewConfig(cli), nil
+}
@@ -0,0 +1,57 @@
+package mybase
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBootstrapCLI(t *testing.T) {
+	cmd := simpleCommandSuite()
+	cmd.AddDefaultsFileOptions()
+	cmd.AddProfileOption()
+	cmd.AddOption(BoolOption("no-color", 0, false, "Disable color output").Bootstrap())
+	one := cmd.SubCommands["one"]
+
+	// Meta-options are resolved from anywhere on the command-line, including
+	// after the subcommand name, ignoring unknown options and invalid values of
+	// other options
+	boot, err := BootstrapCLI(cmd, strings.Fields("mycommand --profile staging --bogus one -n x --hasshort --defaults-file=/tmp/foo.cnf -z --no-color arg1 arg2 arg3"))
+	if err != nil {
+		t.Fatalf("Unexpected error from BootstrapCLI: %v", err)
+	}
+	if boot.CLI.Command != one {
+		t.Errorf("Expected command to be %s, instead found %s", one.Name, boot.CLI.Command.Name)
+	}
+	if boot.Get("profile") != "staging" || boot.Get("defaults-file") != "/tmp/foo.cnf" || !boot.GetBool("no-color") {
+		t.Errorf("Unexpected meta-option values: %v", boot.CLI.OptionValues)
+	}
+	if boot.Supplied("newopt") || boot.Get("newopt") != "" || len(boot.CLI.OptionValues) != 3 {
+		t.Errorf("Expected only meta-options to be supplied, instead found %v", boot.CLI.OptionValues)
+	}
+
+	// Args after the option terminator are not options
+	boot, err = BootstrapCLI(cmd, strings.Fields("mycommand one -- --profile=staging"))
+	if err != nil {
+		t.Fatalf("Unexpected error from BootstrapCLI: %v", err)
+	}
+	if boot.Supplied("profile") {
+		t.Error("Expected profile after option terminator to be ignored")
+	}
+
+	// Problems with meta-options are errors
+	if _, err := BootstrapCLI(cmd, strings.Fields("mycommand one --defaults-file")); err == nil {
+		t.Error("Expected error for meta-option missing its value, but err was nil")
+	}
+	if _, err := BootstrapCLI(cmd, nil); err == nil {
+		t.Error("Expected error for empty command-line, but err was nil")
+	}
+
+	// The bootstrap Config can drive file selection for the full parse
+	boot, err = BootstrapCLI(cmd, strings.Fields("mycommand --no-defaults one arg1"))
+	if err != nil {
+		t.Fatalf("Unexpected error from BootstrapCLI: %v", err)
+	}
+	if files, err := boot.LoadFileChain(NewFile("/does/not/matter.cnf")); err != nil || len(files) != 0 {
+		t.Errorf("Unexpected result from LoadFileChain: %v, %v", files, err)
+	}
+}
@@ -0,0 +1,174 @@
+package mybase
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+)
+
+// ParentConfigEnvVar is the environment variable used by Config.ChildCommand to
+// pass the effective configuration to a child process when using
+// TransportEnv. Its value is a JSON object in the format accepted by
+// Config.ApplyJSON.
+const ParentConfigEnvVar = "MYBASE_PARENT_CONFIG"
+
+// ConfigTransport controls how Config.ChildCommand passes the effective
+// configuration to a child process.
+type ConfigTransport int
+
+// Constants for how to pass configuration to a child process
+const (
+	TransportEnv          ConfigTransport = iota // JSON object in the ParentConfigEnvVar environment variable; child must call Config.UseParentConfig
+	TransportArgs                                // command-line args such as "--name=value", appended to the child's args
+	TransportDefaultsFile                        // temporary option file, supplied via a "--defaults-file" arg appended to the child's args
+)
+
+// ChildCommand returns an *exec.Cmd which runs a child process with the
+// effective configuration of cfg, so that the child resolves the same option
+// values as the parent without the caller marshaling each option by hand. The
+// child may be the same program, or any other program built with this package
+// which has the same options. If name is "", the child runs the current
+// executable. The supplied args, such as a subcommand name, are passed to the
+// child as-is.
+//
+// The value of every option is passed, except for hidden options, the help and
+// version options, and options marked with Option.Bootstrap. If any filter
+// funcs are supplied, options are only passed if all filters return true for
+// the option name; this is useful when the child only supports a subset of the
+// parent's options. Positional args are never passed.
+//
+// With TransportEnv, the values are only used if the child calls
+// UseParentConfig. With TransportArgs, values are passed as command-line args,
+// which other users may be able to see in the process list, so this transport
+// should be avoided if any Sensitive options are set. With
+// TransportDefaultsFile, the child must load option files via
+// Config.LoadFileChain, and its command must have the options added by
+// Command.AddDefaultsFileOptions.
+//
+// The returned cleanup function must be called after the child process exits,
+// to remove any temporary file. It is never nil, even if an error is returned.
+func (cfg *Config) ChildCommand(ctx context.Context, transport ConfigTransport, name string, args []string, filters ...func(optionName string) bool) (*exec.Cmd, func(), error) {
+	cleanup := func() {}
+	if name == "" {
+		executable, err := os.Executable()
+		if err != nil {
+			return nil, cleanup, err
+		}
+		name = executable
+	}
+	args = append([]string(nil), args...)
+	env := os.Environ()
+	filters = append(append([]func(string) bool(nil), filters...), cfg.childOption)
+
+	switch transport {
+	case TransportEnv:
+		data, err := json.Marshal(cfg.childValues(filters))
+		if err != nil {
+			return nil, cleanup, err
+		}
+		env = append(env, ParentConfigEnvVar+"="+string(data))
+	case TransportArgs:
+		values := cfg.childValues(filters)
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			switch value := values[name].(type) {
+			case bool:
+				if value {
+					args = append(args, "--"+name)
+				} else {
+					args = append(args, "--skip-"+name)
+				}
+			case string:
+				args = append(args, "--"+name+"="+fileSafeValue(value))
+			}
+		}
+	case TransportDefaultsFile:
+		tmp, err := ioutil.TempFile("", "mybase-*.cnf")
+		if err != nil {
+			return nil, cleanup, err
+		}
+		tmp.Close()
+		cleanup = func() { os.Remove(tmp.Name()) }
+		f := NewFile(tmp.Name())
+		f.SetPermissions(0600)
+		if err := cfg.ExportToFile(f, "", false, filters...); err != nil {
+			return nil, cleanup, err
+		} else if err := f.Write(true); err != nil {
+			return nil, cleanup, err
+		}
+		args = append(args, "--defaults-file="+tmp.Name())
+	default:
+		panic(fmt.Errorf("ChildCommand: invalid ConfigTransport %d", transport))
+	}
+
+	child := exec.CommandContext(ctx, name, args...)
+	child.Env = env
+	child.Stdin, child.Stdout, child.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return child, cleanup, nil
+}
+
+// childOption returns true if the named option should be passed to a child
+// process by ChildCommand. This is in addition to the options excluded by
+// ExportToFile.
+func (cfg *Config) childOption(name string) bool {
+	opt := cfg.FindOption(name)
+	return opt != nil && !opt.bootstrap
+}
+
+// childValues returns the effective values of options which should be passed
+// to a child process by ChildCommand, keyed by option name. Values of boolean
+// options are bools, and all other values are unquoted strings.
+func (cfg *Config) childValues(filters []func(optionName string) bool) map[string]interface{} {
+	values := make(map[string]interface{})
+	for name, opt := range cfg.CLI.Command.Options() {
+		if opt.HiddenOnCLI || name == "help" || name == "version" {
+			continue
+		}
+		var filtered bool
+		for _, filter := range filters {
+			if !filter(name) {
+				filtered = true
+				break
+			}
+		}
+		if filtered {
+			continue
+		}
+		if opt.Type == OptionTypeBool {
+			values[name] = cfg.GetBool(name)
+		} else {
+			values[name] = unquote(cfg.GetRaw(name))
+		}
+	}
+	return values
+}
+
+// UseParentConfig applies the configuration passed by a parent process which
+// called Config.ChildCommand with TransportEnv, if any. The values override
+// all previously-added sources, but not the command-line, so this should be
+// called after all option files have been added to cfg as sources. The
+// environment variable is then unset, so that it is not inherited by any
+// further processes. If the variable is not set, UseParentConfig does
+// nothing. An error is returned if any of the values are not valid for cfg's
+// command; see ApplyJSON.
+func (cfg *Config) UseParentConfig() error {
+	data, ok := os.LookupEnv(ParentConfigEnvVar)
+	if !ok {
+		return nil
+	}
+	source, err := cfg.parseJSONValues(data, "$"+ParentConfigEnvVar)
+	if err != nil {
+		return err
+	}
+	os.Unsetenv(ParentConfigEnvVar)
+	cfg.AddSource(source)
+	return nil
+}
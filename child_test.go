@@ -0,0 +1,98 @@
+package mybase
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestChildCommand(t *testing.T) {
+	newCommand := func() *Command {
+		cmd := simpleCommand()
+		cmd.AddDefaultsFileOptions()
+		cmd.AddOption(StringOption("password", 0, "", "dummy").Sensitive())
+		return cmd
+	}
+	cfg := ParseFakeCLI(t, newCommand(), "mycommand --skip-truthybool arg1", SimpleSource(map[string]string{
+		"visible":  "it's #1",
+		"bool1":    "1",
+		"password": "'s3cret'",
+	}))
+	noPassword := func(name string) bool { return name != "password" }
+
+	// Each transport should permit the child to resolve the same values, with
+	// the child's own args taking effect as well
+	check := func(transport ConfigTransport, childCfg func(args []string, env []string) *Config) {
+		t.Helper()
+		child, cleanup, err := cfg.ChildCommand(context.Background(), transport, "/bin/fake", []string{"childarg"}, noPassword)
+		defer cleanup()
+		if err != nil {
+			t.Fatalf("Unexpected error from ChildCommand: %v", err)
+		}
+		if child.Path != "/bin/fake" || child.Args[1] != "childarg" {
+			t.Errorf("Unexpected child path %q or args %v", child.Path, child.Args)
+		}
+		ccfg := childCfg(child.Args, child.Env)
+		for _, name := range []string{"bool1", "bool2", "truthybool"} {
+			if cfg.GetBool(name) != ccfg.GetBool(name) {
+				t.Errorf("Transport %d: expected %s=%t in child", transport, name, cfg.GetBool(name))
+			}
+		}
+		for _, name := range []string{"visible", "hidden", "hasshort", "required", "password"} {
+			expected := cfg.Get(name)
+			if name == "required" {
+				expected = "childarg"
+			} else if name == "password" {
+				expected = ""
+			}
+			if actual := ccfg.Get(name); actual != expected {
+				t.Errorf("Transport %d: expected %s=%q in child, instead found %q", transport, name, expected, actual)
+			}
+		}
+	}
+
+	check(TransportArgs, func(args, env []string) *Config {
+		for _, arg := range args {
+			if strings.Contains(arg, "defaults-file") {
+				t.Errorf("Bootstrap option unexpectedly passed: %s", arg)
+			}
+		}
+		ccfg, err := ParseCLI(newCommand(), args)
+		if err != nil {
+			t.Fatalf("Unexpected error parsing child args %v: %v", args, err)
+		}
+		return ccfg
+	})
+
+	check(TransportDefaultsFile, func(args, env []string) *Config {
+		ccfg, err := ParseCLI(newCommand(), args)
+		if err != nil {
+			t.Fatalf("Unexpected error parsing child args %v: %v", args, err)
+		}
+		if _, err := ccfg.LoadFileChain(); err != nil {
+			t.Fatalf("Unexpected error from LoadFileChain: %v", err)
+		}
+		return ccfg
+	})
+
+	check(TransportEnv, func(args, env []string) *Config {
+		defer os.Setenv(ParentConfigEnvVar, os.Getenv(ParentConfigEnvVar))
+		for _, kv := range env {
+			if strings.HasPrefix(kv, ParentConfigEnvVar+"=") {
+				os.Setenv(ParentConfigEnvVar, strings.TrimPrefix(kv, ParentConfigEnvVar+"="))
+			}
+		}
+		ccfg, err := ParseCLI(newCommand(), args)
+		if err != nil {
+			t.Fatalf("Unexpected error parsing child args %v: %v", args, err)
+		}
+		if err := ccfg.UseParentConfig(); err != nil {
+			t.Fatalf("Unexpected error from UseParentConfig: %v", err)
+		}
+		if _, ok := os.LookupEnv(ParentConfigEnvVar); ok {
+			t.Errorf("Expected UseParentConfig to unset %s", ParentConfigEnvVar)
+		}
+		return ccfg
+	})
+}
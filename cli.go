@@ -3,6 +3,7 @@ package mybase
 import (
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 )
 
@@ -12,6 +13,7 @@ type CommandLine struct {
 	Command      *Command          // Which command (or subcommand) is being executed
 	OptionValues map[string]string // Option values parsed from the command-line
 	ArgValues    []string          // Positional arg values (does not include InvokedAs or Command.Name)
+	spellings    map[string]string // Option name => which alias or long name was used to supply it
 }
 
 // OptionValue returns the value for the requested option if it was specified
@@ -25,11 +27,20 @@ func (cli *CommandLine) OptionValue(optionName string) (string, bool) {
 func (cli *CommandLine) parseLongArg(arg string, args *[]string, longOptionIndex map[string]*Option) error {
 	key, value, hasValue, loose := NormalizeOptionToken(arg)
 	opt, found := longOptionIndex[key]
+	if !found && cli.Command.Root().OptionPrefixes {
+		var candidates []string
+		if opt, candidates = optionPrefixMatch(key, longOptionIndex); opt != nil {
+			key, found = opt.Name, true // treat prefix as a spelling of the canonical name
+		} else if len(candidates) > 0 && !loose {
+			return OptionAmbiguousError{Name: key, Candidates: candidates, Source: "CLI"}
+		}
+	}
 	if !found {
 		if loose {
 			return nil
 		}
-		return OptionNotDefinedError{key, "CLI"}
+		typed := typedOptionName(arg)
+		return OptionNotDefinedError{Name: typed, Source: "CLI", Suggestion: optionSuggestion(typed, longOptionIndex)}
 	}
 
 	// Use returned hasValue boolean instead of comparing value to "", since "" may
@@ -39,13 +50,15 @@ func (cli *CommandLine) parseLongArg(arg string, args *[]string, longOptionIndex
 		if opt.RequireValue {
 			// Value required: slurp next arg to allow format "--foo bar" in addition to "--foo=bar"
 			if len(*args) == 0 || strings.HasPrefix((*args)[0], "-") {
-				return OptionMissingValueError{opt.Name, "CLI"}
+				return OptionMissingValueError{Name: opt.Name, Source: "CLI"}
 			}
 			value = (*args)[0]
 			*args = (*args)[1:]
 		} else if opt.Type == OptionTypeBool {
 			// Boolean without value is treated as true
 			value = "1"
+		} else if opt.Type == OptionTypeCount {
+			value = cli.incrementedCount(opt)
 		}
 	} else if value == "" && opt.Type == OptionTypeString {
 		// Convert empty strings into quote-wrapped empty strings, so that callers
@@ -53,12 +66,41 @@ func (cli *CommandLine) parseLongArg(arg string, args *[]string, longOptionIndex
 		// Config.GetRaw(). Meanwhile Config.Get and most other getters strip
 		// surrounding quotes, so this does not break anything.
 		value = "''"
+	} else if value == "" && opt.Type == OptionTypeCount {
+		value = "0" // "--skip-foo" resets a count
 	}
 
-	cli.OptionValues[opt.Name] = value
+	return cli.setOptionValue(opt, key, value)
+}
+
+// setOptionValue stores the value for an option, also tracking which name was
+// used on the command-line to supply it. An error is returned if the option
+// was previously supplied with a different value using a different spelling
+// (e.g. an alias vs the canonical name), unless the top-level command has
+// LastAliasWins enabled, in which case the last value wins, as with any other
+// option supplied more than once.
+func (cli *CommandLine) setOptionValue(opt *Option, spelling, value string) error {
+	if cli.spellings == nil {
+		cli.spellings = make(map[string]string)
+	}
+	if prev, seen := cli.spellings[opt.Name]; seen && prev != spelling && cli.OptionValues[opt.Name] != value && opt.Type != OptionTypeCount && !opt.accumulates() && !cli.Command.Root().LastAliasWins {
+		return OptionAliasConflictError{Name: opt.Name, Spellings: [2]string{prev, spelling}, Source: "CLI"}
+	}
+	if err := opt.checkValue(value); err != nil {
+		return OptionValueError{Name: opt.Name, Value: opt.displayValue(unquote(value)), Problem: err.Error(), Source: "CLI"}
+	}
+	cli.spellings[opt.Name] = spelling
+	cli.OptionValues[opt.Name] = opt.accumulate(cli.OptionValues[opt.Name], value)
 	return nil
 }
 
+// incrementedCount returns the value of a count-type option after another
+// valueless use on the command-line.
+func (cli *CommandLine) incrementedCount(opt *Option) string {
+	count, _ := strconv.ParseUint(cli.OptionValues[opt.Name], 10, 64)
+	return strconv.FormatUint(count+1, 10)
+}
+
 func (cli *CommandLine) parseShortArgs(arg string, args *[]string, shortOptionIndex map[rune]*Option) error {
 	runeList := []rune(arg)
 	var done bool
@@ -68,12 +110,12 @@ func (cli *CommandLine) parseShortArgs(arg string, args *[]string, shortOptionIn
 		var value string
 		opt, found := shortOptionIndex[short]
 		if !found {
-			return OptionNotDefinedError{string(short), "CLI"}
+			return OptionNotDefinedError{Name: string(short), Source: "CLI"}
 		}
 
 		// Consume value. Depending on the option, value may be supplied as chars immediately following
 		// this one, or after a space as next arg on CLI.
-		if len(runeList) > 0 && opt.Type != OptionTypeBool { // "-xvalue", only supported for non-bools
+		if len(runeList) > 0 && opt.Type != OptionTypeBool && opt.Type != OptionTypeCount { // "-xvalue", only supported for non-bools and non-counts
 			value = string(runeList)
 			done = true
 		} else if opt.RequireValue { // "-x value", only supported if opt requires a value
@@ -81,15 +123,19 @@ func (cli *CommandLine) parseShortArgs(arg string, args *[]string, shortOptionIn
 				value = (*args)[0]
 				*args = (*args)[1:]
 			} else {
-				return OptionMissingValueError{opt.Name, "CLI"}
+				return OptionMissingValueError{Name: opt.Name, Source: "CLI"}
 			}
 		} else { // "-xyz", parse x as a valueless option and loop again to parse y (and possibly z) as separate shorthand options
 			if opt.Type == OptionTypeBool {
 				value = "1" // booleans handle lack of value as being true, whereas other types keep it as empty string
+			} else if opt.Type == OptionTypeCount {
+				value = cli.incrementedCount(opt) // "-vvv" increments three times
 			}
 		}
 
-		cli.OptionValues[opt.Name] = value
+		if err := cli.setOptionValue(opt, opt.Name, value); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -109,27 +155,35 @@ func (cli *CommandLine) String() string {
 //
 // The supplied args should match format of os.Args; i.e. args[0]
 // should contain the program name.
+//
+// If args[1] is "__complete", the remaining args are not parsed, and the
+// returned Config instead serves requests for dynamic completions from the
+// scripts generated by Command.GenerateCompletion; see Option.SetCompletion.
 func ParseCLI(cmd *Command, args []string) (*Config, error) {
 	if len(args) == 0 {
 		return nil, errors.New("ParseCLI: No command-line supplied")
 	}
+	if len(args) > 1 && args[1] == completeCommandName {
+		return completeConfig(cmd, args), nil
+	}
+	return parseCLI(cmd, args, false)
+}
 
+// parseCLI implements ParseCLI. If partial is true, args may be an incomplete
+// command-line, such as one which is in the process of being typed: missing
+// required positional args are not an error, and a command suite without a
+// subcommand is not redirected to its help subcommand.
+func parseCLI(cmd *Command, args []string, partial bool) (*Config, error) {
 	cli := &CommandLine{
 		Command:      cmd,
 		InvokedAs:    args[0],
 		OptionValues: make(map[string]string),
 		ArgValues:    make([]string, 0),
+		spellings:    make(map[string]string),
 	}
 	args = args[1:]
 
-	// Index options by shorthand
-	longOptionIndex := cmd.Options()
-	shortOptionIndex := make(map[rune]*Option, len(longOptionIndex))
-	for name, opt := range longOptionIndex {
-		if opt.Shorthand != 0 {
-			shortOptionIndex[opt.Shorthand] = longOptionIndex[name]
-		}
-	}
+	longOptionIndex, shortOptionIndex := cliOptionIndexes(cmd)
 
 	var noMoreOptions bool
 
@@ -138,8 +192,9 @@ func ParseCLI(cmd *Command, args []string) (*Config, error) {
 		arg := args[0]
 		args = args[1:]
 		switch {
-		// option terminator
-		case arg == "--":
+		// option terminator; any subsequent args are positional, even if they begin
+		// with a dash
+		case arg == "--" && !noMoreOptions:
 			noMoreOptions = true
 
 		// long option
@@ -148,8 +203,10 @@ func ParseCLI(cmd *Command, args []string) (*Config, error) {
 				return nil, err
 			}
 
-		// short option(s) -- multiple bools may be combined into one
-		case len(arg) > 1 && arg[0] == '-' && !noMoreOptions:
+		// short option(s) -- multiple bools may be combined into one. A negative
+		// number is treated as a positional arg instead, unless a short option
+		// matches its first digit.
+		case len(arg) > 1 && arg[0] == '-' && !noMoreOptions && !(isNegativeNumber(arg) && shortOptionIndex[rune(arg[1])] == nil):
 			if err := cli.parseShortArgs(arg[1:], &args, shortOptionIndex); err != nil {
 				return nil, err
 			}
@@ -158,18 +215,23 @@ func ParseCLI(cmd *Command, args []string) (*Config, error) {
 		case len(cli.Command.SubCommands) > 0:
 			command, validCommand := cli.Command.SubCommands[arg]
 			if !validCommand {
-				return nil, fmt.Errorf("Unknown command \"%s\"", arg)
+				return nil, UsageError{Command: cli.Command, Problem: unknownCommandProblem(cli.Command, arg)}
 			}
 			cli.Command = command
 
-			// Add the options of the new command into our maps. Any name conflicts
-			// intentionally override parent versions.
-			for name, opt := range command.options {
-				longOptionIndex[name] = command.options[name]
-				if opt.Shorthand != 0 {
-					shortOptionIndex[opt.Shorthand] = command.options[name]
+			// Switch to the options of the new command, which override any parent
+			// versions and exclude local options of the parent. Any local options
+			// supplied before the subcommand name are not permitted.
+			longOptionIndex, shortOptionIndex = cliOptionIndexes(command)
+			for name := range cli.OptionValues {
+				if _, ok := longOptionIndex[name]; !ok {
+					return nil, UsageError{Command: command, Problem: fmt.Sprintf("Option --%s does not apply to command %s", name, command.fullName())}
 				}
 			}
+			if command.rawArgs {
+				cli.ArgValues = append(cli.ArgValues, args...)
+				args = nil
+			}
 
 		// supplying help or version as first positional arg to a non-command-suite:
 		// treat as if supplied as option instead
@@ -179,23 +241,71 @@ func ParseCLI(cmd *Command, args []string) (*Config, error) {
 			}
 
 		// superfluous positional arg
-		case len(cli.ArgValues) >= len(cli.Command.args):
-			return nil, fmt.Errorf("Extra command-line arg \"%s\" supplied; command %s takes a max of %d args", arg, cli.Command.Name, len(cli.Command.args))
+		case cli.Command.argAt(len(cli.ArgValues)) == nil:
+			return nil, UsageError{Command: cli.Command, Problem: fmt.Sprintf("Extra command-line arg \"%s\" supplied; command %s takes a max of %d args", arg, cli.Command.Name, len(cli.Command.args))}
 
 		// positional arg
 		default:
+			argOpt := cli.Command.argAt(len(cli.ArgValues))
+			if err := argOpt.checkValue(arg); err != nil {
+				return nil, ArgValueError{Name: argOpt.Name, Position: len(cli.ArgValues) + 1, Value: argOpt.displayValue(unquote(arg)), Problem: err.Error()}
+			}
 			cli.ArgValues = append(cli.ArgValues, arg)
+			noMoreOptions = noMoreOptions || cli.Command.effectiveArgOrder() == ArgOrderOptionsFirst
 		}
 	}
 
-	if _, helpWanted := cli.OptionValues["help"]; !helpWanted && len(cli.ArgValues) < cli.Command.minArgs() {
-		return nil, fmt.Errorf("Too few positional args supplied on command line; command %s requires at least %d args", cli.Command.Name, cli.Command.minArgs())
+	_, helpWanted := cli.OptionValues["help"]
+	helpWanted = helpWanted || cli.OptionValues["help-all"] == "1" || cli.OptionValues["generate-config"] == "1" || cli.OptionValues["print-defaults"] == "1"
+	if !helpWanted && !partial && len(cli.ArgValues) < cli.Command.minArgs() {
+		return nil, UsageError{Command: cli.Command, Problem: fmt.Sprintf("Too few positional args supplied on command line; command %s requires at least %d args", cli.Command.Name, cli.Command.minArgs())}
 	}
 
 	// If no command supplied on a command suite, redirect to help subcommand
-	if len(cli.Command.SubCommands) > 0 {
+	if len(cli.Command.SubCommands) > 0 && !partial {
 		cli.Command = cli.Command.SubCommands["help"]
+	} else if !helpWanted && !partial && cli.OptionValues["version"] != "1" && !cli.Command.isBuiltin() {
+		if err := cli.checkMandatoryCLI(); err != nil {
+			return nil, err
+		}
 	}
 
 	return NewConfig(cli), nil
 }
+
+// cliOptionIndexes returns the options of cmd indexed by long name (including
+// aliases) and by shorthand, for use in parsing the command-line. If options of
+// cmd and an ancestor share a shorthand, the one closest to cmd wins.
+func cliOptionIndexes(cmd *Command) (longOptionIndex map[string]*Option, shortOptionIndex map[rune]*Option) {
+	options := cmd.Options()
+	longOptionIndex = make(map[string]*Option, len(options))
+	for name, opt := range options {
+		longOptionIndex[name] = opt
+	}
+	for alias, opt := range optionAliasIndex(options) {
+		longOptionIndex[alias] = opt
+	}
+	shortOptionIndex = make(map[rune]*Option)
+	var chain []*Command // cmd and its ancestors, from root to cmd
+	for current := cmd; current != nil; current = current.ParentCommand {
+		chain = append([]*Command{current}, chain...)
+	}
+	for _, current := range chain {
+		for name, opt := range current.options {
+			if opt.Shorthand != 0 && options[name] == opt {
+				shortOptionIndex[opt.Shorthand] = opt
+			}
+		}
+	}
+	return longOptionIndex, shortOptionIndex
+}
+
+// isNegativeNumber returns true if arg is a negative integer or decimal
+// number, for example "-5", "-1.5", or "-.5".
+func isNegativeNumber(arg string) bool {
+	if len(arg) < 2 || arg[0] != '-' || (arg[1] != '.' && (arg[1] < '0' || arg[1] > '9')) {
+		return false
+	}
+	_, err := strconv.ParseFloat(arg, 64)
+	return err == nil
+}
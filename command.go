@@ -2,13 +2,13 @@ package mybase
 
 import (
 	"fmt"
+	"io"
 	"os"
-	"runtime"
 	"sort"
 	"strings"
-
-	"github.com/mitchellh/go-wordwrap"
-	terminal "golang.org/x/term"
+	"sync/atomic"
+	"text/template"
+	"time"
 )
 
 // CommandHandler is a function that can be associated with a Command as a
@@ -19,15 +19,45 @@ type CommandHandler func(*Config) error
 // subcommand of another command suite, a stand-alone program without
 // subcommands, or an arbitrarily nested command suite.
 type Command struct {
-	Name          string              // Command name, as used in CLI
-	Summary       string              // Short description text. If ParentCommand is nil, represents version instead.
-	Description   string              // Long (multi-line) description/help text
-	WebDocURL     string              // Optional URL for online documentation for this specific command
-	SubCommands   map[string]*Command // Index of sub-commands
-	ParentCommand *Command            // What command this is a sub-command of, or nil if this is the top level
-	Handler       CommandHandler      // Callback for processing command. Ignored if len(SubCommands) > 0.
-	options       map[string]*Option  // Command-specific options
-	args          []*Option           // command-speciifc positional args. Ignored if len(SubCommands) > 0.
+	Name            string                // Command name, as used in CLI
+	Summary         string                // Short description text. If ParentCommand is nil, represents version instead.
+	Description     string                // Long (multi-line) description/help text
+	WebDocURL       string                // Optional URL for online documentation for this specific command
+	SubCommands     map[string]*Command   // Index of sub-commands
+	ParentCommand   *Command              // What command this is a sub-command of, or nil if this is the top level
+	Handler         CommandHandler        // Callback for processing command. Ignored if len(SubCommands) > 0.
+	CancelOnSignal  bool                  // If true, RunContext cancels its context upon SIGINT or SIGTERM. Only checked on the top-level command.
+	ShutdownGrace   time.Duration         // If positive, max time RunContext waits for the handler after a signal. Only checked on the top-level command.
+	HelpPager       bool                  // If true, long help output is piped through $PAGER when STDOUT is a terminal. Only checked on the top-level command.
+	OptionPrefixes  bool                  // If true, unambiguous prefixes of long option names are accepted, like MySQL clients. Only checked on the top-level command.
+	LastAliasWins   bool                  // If true, conflicting values for one option via both its name and an alias in one source let the last one win, instead of being an error. Only checked on the top-level command.
+	options         map[string]*Option    // Command-specific options
+	args            []*Option             // command-speciifc positional args. Ignored if len(SubCommands) > 0.
+	helpTemplate    *template.Template    // custom template for usage instructions, if any
+	contextHandler  CommandHandlerContext // context-aware callback, if set via SetContextHandler
+	groupOrder      []string              // names of option groups added via AddOptionGroup, in order added
+	preRunHooks     []PreRunHook          // hooks added via AddPreRunHook
+	postRunHooks    []PostRunHook         // hooks added via AddPostRunHook
+	validationRules []ValidationRule      // rules added via AddValidationRule
+	examples        []Example             // sample invocations added via AddExample
+	longHelp        string                // extended help text set via SetLongHelp
+	colorScheme     *ColorScheme          // formatting of help output and errors, if set via SetColorScheme
+	versionCommit   string                // build metadata supplied via SetVersion; only used on top-level command
+	versionDate     string                // build metadata supplied via SetVersion; only used on top-level command
+	versionTemplate *template.Template    // custom template for version output, if any; only used on top-level command
+	category        string                // category of this subcommand in its parent's help output, if set via SetCategory
+	subCommandOrder SubCommandOrder       // order of subcommands in help output, set via SetSubCommandOrder
+	subCommandNames []string              // names of subcommands added via AddSubCommand, in order added
+	categoryOrder   []string              // categories listed first in help output, set via SetCategoryOrder
+	rawArgs         bool                  // if true, all args after this command's name are positional; used by external subcommands
+	lookupCache     atomic.Value          // *optionLookup caching the result of optionLookup
+	signalCodes     map[os.Signal]int     // exit codes set via SetSignalExitCode; only used on top-level command
+	exitCodes       []errorExitCode       // exit codes for errors, set via SetExitCode
+	defaults        OptionValuer          // command-level default values, set via SetDefaults
+	collector       Collector             // receives telemetry about executions, if set via SetCollector
+	argOrder        ArgOrder              // whether options may follow positional args, set via SetArgOrder
+	auditLog        io.Writer             // receives an AuditRecord prior to each execution, if set via SetAuditLog
+	auditKey        []byte                // key for signing audit records, set via SetAuditLog
 }
 
 // NewCommand creates a standalone command, ie one that does not take sub-
@@ -44,6 +74,7 @@ func NewCommand(name, summary, description string, handler CommandHandler) *Comm
 
 	cmd.AddOptions("global",
 		StringOption("help", '?', "", "Display usage information for the specified command").ValueOptional(),
+		BoolOption("help-all", 0, false, "Display usage information, including hidden options").Hidden(),
 		BoolOption("version", 0, false, "Display program version"),
 	)
 
@@ -69,7 +100,7 @@ func NewCommandSuite(name, summary, description string) *Command {
 		Summary:     `Display usage information`,
 		Handler:     helpHandler,
 	}
-	helpCmd.AddArg("command", "", false)
+	helpCmd.AddVariadicArg("command", false)
 
 	// Add version subcommand, and equivalently as an option
 	versionCmd := &Command{
@@ -84,6 +115,7 @@ func NewCommandSuite(name, summary, description string) *Command {
 	cmd.AddOptions("global",
 		BoolOption("version", 0, false, "Display program version"),
 		StringOption("help", '?', "", "Display usage information for the specified command").ValueOptional(),
+		BoolOption("help-all", 0, false, "Display usage information, including hidden options").Hidden(),
 	)
 
 	return cmd
@@ -95,21 +127,162 @@ func (cmd *Command) AddSubCommand(subCmd *Command) {
 		panic(fmt.Errorf("AddSubCommand: Parent command %s was not created as a CommandSuite", cmd.Name))
 	}
 	subCmd.ParentCommand = cmd
+	atomic.AddUint64(&optionEdits, 1)
+	if _, exists := cmd.SubCommands[subCmd.Name]; !exists {
+		cmd.subCommandNames = append(cmd.subCommandNames, subCmd.Name)
+	}
 	cmd.SubCommands[subCmd.Name] = subCmd
 	delete(subCmd.SubCommands, "version") // non-top-level command suites don't need version as command
 }
 
+// SubCommandOrder is an enum controlling the order in which a command suite's
+// subcommands are listed in help output.
+type SubCommandOrder int
+
+// Constants representing different SubCommandOrder enumerated values.
+const (
+	SubCommandsAlphabetical SubCommandOrder = iota // Sorted by name; this is the default
+	SubCommandsDeclared                            // In the order added via AddSubCommand, followed by the automatic help and version subcommands
+)
+
+// SetSubCommandOrder controls the order in which the subcommands of cmd are
+// listed in help output, as well as in documentation generated by
+// GenerateDocs. If any subcommands have a category, this order applies within
+// each category; see SetCategory.
+func (cmd *Command) SetSubCommandOrder(order SubCommandOrder) {
+	cmd.subCommandOrder = order
+}
+
+// ArgOrder is an enum controlling whether options may appear after positional
+// args on the command-line.
+type ArgOrder int
+
+// Constants representing different ArgOrder enumerated values.
+const (
+	ArgOrderInherit      ArgOrder = iota // Same as the parent command, or ArgOrderIntermixed for a top-level command; this is the default
+	ArgOrderIntermixed                   // GNU-style: options may appear before, between, or after positional args
+	ArgOrderOptionsFirst                 // POSIX-style: the first positional arg ends option parsing, so all subsequent args are positional
+)
+
+// SetArgOrder controls whether options may appear after positional args when
+// parsing the command-line of cmd, or of any of its descendant subcommands
+// which use ArgOrderInherit. With ArgOrderOptionsFirst, the first positional
+// arg is treated as if it were preceded by "--": any subsequent args are
+// positional, even if they begin with a dash. The name of a subcommand does
+// not count as a positional arg for this purpose, so options may follow it
+// regardless of the command suite's ArgOrder.
+func (cmd *Command) SetArgOrder(order ArgOrder) {
+	cmd.argOrder = order
+}
+
+// effectiveArgOrder returns the ArgOrder of cmd, or of its closest ancestor
+// which does not use ArgOrderInherit, or ArgOrderIntermixed if there is none.
+func (cmd *Command) effectiveArgOrder() ArgOrder {
+	for current := cmd; current != nil; current = current.ParentCommand {
+		if current.argOrder != ArgOrderInherit {
+			return current.argOrder
+		}
+	}
+	return ArgOrderIntermixed
+}
+
+// SetCategory places cmd into the named category in its parent command's help
+// output, for example "Common commands" or "Advanced commands". If any of a
+// command suite's subcommands have a category, its help output lists each
+// category under a separate heading, rather than one list of commands.
+// Subcommands without a category, including the automatic help and version
+// subcommands, are listed last under the heading "Other commands".
+func (cmd *Command) SetCategory(name string) {
+	cmd.category = name
+}
+
+// SetCategoryOrder supplies the order in which subcommand categories of cmd are
+// listed in help output. Any categories which are not supplied are listed
+// afterwards: in order of first use if the subcommand order is
+// SubCommandsDeclared, or alphabetically otherwise.
+func (cmd *Command) SetCategoryOrder(names ...string) {
+	cmd.categoryOrder = names
+}
+
+// orderedSubCommands returns the subcommands of cmd in the order set via
+// SetSubCommandOrder. Subcommands which were added directly to the SubCommands
+// map, rather than via AddSubCommand, are treated as added last.
+func (cmd *Command) orderedSubCommands() []*Command {
+	names := make([]string, 0, len(cmd.SubCommands))
+	for name := range cmd.SubCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if cmd.subCommandOrder == SubCommandsDeclared {
+		position := make(map[string]int, len(cmd.subCommandNames))
+		for n, name := range cmd.subCommandNames {
+			position[name] = n
+		}
+		rank := func(name string) int {
+			if cmd.SubCommands[name].isBuiltin() {
+				return len(position) + 1
+			} else if pos, ok := position[name]; ok {
+				return pos
+			}
+			return len(position)
+		}
+		sort.SliceStable(names, func(i, j int) bool {
+			return rank(names[i]) < rank(names[j])
+		})
+	}
+	subs := make([]*Command, len(names))
+	for n, name := range names {
+		subs[n] = cmd.SubCommands[name]
+	}
+	return subs
+}
+
+// subCommandCategories returns the subcommands of cmd grouped by category, with
+// the categories in the order described by SetCategoryOrder, and uncategorized
+// subcommands last. The category names are returned in the same order.
+func (cmd *Command) subCommandCategories() (names []string, members map[string][]*Command) {
+	members = make(map[string][]*Command)
+	var firstUse []string
+	for _, sub := range cmd.orderedSubCommands() {
+		if _, seen := members[sub.category]; !seen && sub.category != "" {
+			firstUse = append(firstUse, sub.category)
+		}
+		members[sub.category] = append(members[sub.category], sub)
+	}
+	if cmd.subCommandOrder != SubCommandsDeclared {
+		sort.Strings(firstUse)
+	}
+	listed := make(map[string]bool)
+	for _, name := range append(append([]string{}, cmd.categoryOrder...), firstUse...) {
+		if _, ok := members[name]; ok && name != "" && !listed[name] {
+			names = append(names, name)
+			listed[name] = true
+		}
+	}
+	if _, ok := members[""]; ok {
+		names = append(names, "")
+	}
+	return names, members
+}
+
 // AddArg adds a positional arg to a Command. If requireValue is false, this arg
-// is considered optional and its defaultValue will be used if omitted.
-func (cmd *Command) AddArg(name, defaultValue string, requireValue bool) {
+// is considered optional and its defaultValue will be used if omitted. The
+// returned Option may be further configured using Describe, SetType, or
+// SetValidator.
+func (cmd *Command) AddArg(name, defaultValue string, requireValue bool) *Option {
 	// Validate the arg. Panic if there's a problem, since this is indicative of
 	// programmer error.
 	for _, arg := range cmd.args {
-		// Cannot add two args with same name (TODO: add support for arg slurping into a slice)
+		// Cannot add two args with same name
 		if arg.Name == name {
 			panic(fmt.Errorf("Cannot add arg %s to command %s: prior arg already has that name", name, cmd.Name))
 		}
 
+		// Cannot add any arg after a variadic arg
+		if arg.variadic {
+			panic(fmt.Errorf("Cannot add arg %s to command %s: prior arg %s is variadic", name, cmd.Name, arg.Name))
+		}
+
 		// Cannot add a required arg if optional args are already present
 		if requireValue && !arg.RequireValue {
 			panic(fmt.Errorf("Cannot add required arg %s to command %s: prior arg %s is optional", name, cmd.Name, arg.Name))
@@ -126,15 +299,60 @@ func (cmd *Command) AddArg(name, defaultValue string, requireValue bool) {
 		RequireValue: requireValue,
 	}
 	cmd.args = append(cmd.args, arg)
+	return arg
+}
+
+// AddVariadicArg adds a positional arg to a Command which consumes all
+// remaining positional values on the command-line. It must be the final arg
+// added to cmd. If requireValue is true, at least one value must be supplied.
+// The values are obtained via Config.GetSlice(name, ',', false); each value is
+// quote-wrapped as needed, so values containing commas or quotes are
+// preserved intact.
+func (cmd *Command) AddVariadicArg(name string, requireValue bool) *Option {
+	arg := cmd.AddArg(name, "", requireValue)
+	arg.variadic = true
+	return arg
 }
 
 // AddOption adds an Option to a Command. Options represent flags/settings
-// which can be supplied via the command-line or an options file.
+// which can be supplied via the command-line or an options file. Unless the
+// Option is marked as Local, it is inherited by all descendant subcommands of
+// cmd.
+// Panics if the Option's name or aliases conflict with the name or aliases of
+// a different Option already present in cmd.
 func (cmd *Command) AddOption(opt *Option) {
 	if cmd.options == nil {
 		cmd.options = make(map[string]*Option)
 	}
+
+	// Option names are matched case-insensitively, with underscores equivalent
+	// to dashes, so they are stored in canonical form. Defining two options that
+	// only differ in case or separator is not permitted.
+	if opt.definedAs == "" {
+		opt.definedAs = opt.Name
+	}
+	opt.Name = canonicalOptionName(opt.Name)
+	for _, other := range cmd.Options() {
+		if other.Name == opt.Name && other.definedAs != opt.definedAs {
+			panic(fmt.Errorf("Cannot add option %s to command %s: option names are case-insensitive and treat underscores as dashes, so it conflicts with option %s", opt.definedAs, cmd.Name, other.definedAs))
+		}
+	}
+
+	for name, other := range cmd.options {
+		if name == opt.Name {
+			continue // intentionally replacing a prior Option of same name
+		}
+		if other.HasAlias(opt.Name) {
+			panic(fmt.Errorf("Cannot add option %s to command %s: option %s already has that name as an alias", opt.Name, cmd.Name, other.Name))
+		}
+		for _, alias := range opt.Aliases {
+			if alias == other.Name || other.HasAlias(alias) {
+				panic(fmt.Errorf("Cannot add option %s to command %s: alias %s conflicts with option %s", opt.Name, cmd.Name, alias, other.Name))
+			}
+		}
+	}
 	cmd.options[opt.Name] = opt
+	atomic.AddUint64(&optionEdits, 1)
 }
 
 // AddOptions adds any number of Options to a Command, also setting the Group
@@ -146,23 +364,109 @@ func (cmd *Command) AddOptions(group string, opts ...*Option) {
 	}
 }
 
+// AddOptionGroup is like AddOptions, but also causes the group to be listed in
+// help output in the order that groups were added via AddOptionGroup, rather
+// than alphabetically. Groups added via AddOptionGroup are listed after the
+// unnamed group, but before any groups added only via AddOptions, and before
+// the "global" group.
+func (cmd *Command) AddOptionGroup(name string, opts ...*Option) {
+	cmd.AddOptions(name, opts...)
+	for _, existing := range cmd.groupOrder {
+		if existing == name {
+			return
+		}
+	}
+	cmd.groupOrder = append(cmd.groupOrder, name)
+}
+
+// RegisterOptions adds Options to a Command, after first confirming that no
+// option with the same name or alias already exists anywhere in the command
+// tree. This is intended for use by plugins or other independently-developed
+// code which contributes options to an application, possibly after the
+// Command has otherwise been fully set up. The declarer string should identify
+// the plugin, and is used in error messages. If any conflict is found, an
+// error is returned and none of the options are added.
+//
+// If any Configs have already been created for this command tree, call
+// Config.ReevaluateUnknowns afterwards so that the new options are visible.
+func (cmd *Command) RegisterOptions(declarer string, opts ...*Option) error {
+	root := cmd.Root()
+	pending := make(map[string]*Option, len(opts))
+	describe := func(opt *Option, owner *Command) string {
+		if opt.declarer != "" {
+			return opt.declarer
+		} else if owner != nil {
+			return fmt.Sprintf("command %s", owner.Name)
+		}
+		return declarer
+	}
+	for _, opt := range opts {
+		for _, name := range append([]string{opt.Name}, opt.Aliases...) {
+			name = canonicalOptionName(name)
+			existing, owner := root.findOptionInTree(name)
+			if existing == nil {
+				existing = pending[name]
+			}
+			if existing != nil {
+				return fmt.Errorf("Cannot register option %s from %s: name %s conflicts with option %s declared by %s", opt.Name, declarer, name, existing.Name, describe(existing, owner))
+			}
+			pending[name] = opt
+		}
+	}
+	for _, opt := range opts {
+		opt.declarer = declarer
+		cmd.AddOption(opt)
+	}
+	return nil
+}
+
+// findOptionInTree searches cmd and all of its descendants for an option with
+// the supplied name or alias, returning the Option and the Command which has
+// it, or nil and nil if not found.
+func (cmd *Command) findOptionInTree(name string) (*Option, *Command) {
+	if opt, ok := cmd.options[name]; ok {
+		return opt, cmd
+	}
+	if opt, ok := optionAliasIndex(cmd.options)[name]; ok {
+		return opt, cmd
+	}
+	for _, sub := range cmd.SubCommands {
+		if opt, owner := sub.findOptionInTree(name); opt != nil {
+			return opt, owner
+		}
+	}
+	return nil, nil
+}
+
 // Options returns a map of options for this command, recursively merged with
-// its parent command. In cases of conflicts, sub-command options override their
-// parents / grandparents / etc. The returned map is always a copy, so
-// modifications to the map itself will not affect the original cmd.options.
-// This method does not include positional args in its return value.
+// the persistent (non-local) options of its parent command. In cases of
+// conflicts, sub-command options override their parents / grandparents / etc.
+// The returned map is always a copy, so modifications to the map itself will
+// not affect the original cmd.options. This method does not include
+// positional args in its return value.
 func (cmd *Command) Options() (optMap map[string]*Option) {
-	if cmd.ParentCommand == nil {
-		optMap = make(map[string]*Option, len(cmd.options))
-	} else {
-		optMap = cmd.ParentCommand.Options()
-	}
+	optMap = cmd.inheritedOptions()
 	for name := range cmd.options {
 		optMap[name] = cmd.options[name]
 	}
 	return optMap
 }
 
+// inheritedOptions returns a map of the options which cmd inherits from its
+// ancestors, i.e. their options which were not marked as Local.
+func (cmd *Command) inheritedOptions() (optMap map[string]*Option) {
+	if cmd.ParentCommand == nil {
+		return make(map[string]*Option, len(cmd.options))
+	}
+	optMap = cmd.ParentCommand.inheritedOptions()
+	for name, opt := range cmd.ParentCommand.options {
+		if !opt.local {
+			optMap[name] = opt
+		}
+	}
+	return optMap
+}
+
 // OptionValue returns the default value of the option with name optionName.
 // This is satisfies the OptionValuer interface, and allows a Config to use
 // a Command as the lowest-priority option provider in order to return an
@@ -170,6 +474,12 @@ func (cmd *Command) Options() (optMap map[string]*Option) {
 func (cmd *Command) OptionValue(optionName string) (string, bool) {
 	options := cmd.Options()
 	opt, ok := options[optionName]
+	if !ok {
+		opt, ok = options[canonicalOptionName(optionName)]
+	}
+	if !ok {
+		opt, ok = optionAliasIndex(options)[canonicalOptionName(optionName)]
+	}
 	if !ok {
 		// See if the optionName actually refers to a positional arg, and if so,
 		// return the proper default. This is needed for patterns like
@@ -182,87 +492,78 @@ func (cmd *Command) OptionValue(optionName string) (string, bool) {
 		}
 		return "", false
 	}
+	if value, _, ok := cmd.commandDefault(opt); ok {
+		return value, true
+	}
 	return opt.Default, true
 }
 
-// Usage returns help instructions for a Command.
-func (cmd *Command) Usage() {
-	fmt.Printf("\nUsage:  %s\n\n", cmd.Invocation())
-	lineLen := 80
-	if stdinFd := int(os.Stderr.Fd()); terminal.IsTerminal(stdinFd) {
-		lineLen, _, _ = terminal.GetSize(stdinFd)
-		if lineLen < 80 {
-			lineLen = 80
-		} else if lineLen > 180 {
-			lineLen = 160
-		} else if lineLen > 120 {
-			lineLen -= 20
-		}
-	}
-	// Avoid extra blank lines on Windows when output matches full line length
-	if runtime.GOOS == "windows" {
-		lineLen--
-	}
-	fmt.Printf("%s\n", wordwrap.WrapString(cmd.Description, uint(lineLen)))
+// SetDefaults registers a source of default values for cmd. These override the
+// declared default values of options, including those supplied via
+// Option.SetDefaultFunc, when cmd or any of its subcommands is invoked. This
+// permits an option shared by several commands to have a different default in
+// each. Options inherited from a parent command may be given new defaults, and
+// if both a command and one of its ancestors supply a default for the same
+// option, the command nearest to the invoked command takes precedence. The
+// source is typically created via SimpleSource, but any OptionValuer may be
+// used; it is not consulted for options which cmd does not have. Use
+// Config.DefaultOrigin to determine where an option's default comes from.
+func (cmd *Command) SetDefaults(source OptionValuer) {
+	cmd.defaults = source
+}
 
-	if len(cmd.SubCommands) > 0 {
-		fmt.Println("\nCommands:")
-		var maxLen int
-		names := make([]string, 0, len(cmd.SubCommands))
-		for name := range cmd.SubCommands {
-			names = append(names, name)
-			if len(name) > maxLen {
-				maxLen = len(name)
+// commandDefault returns the default value of opt supplied via SetDefaults on
+// cmd or its nearest ancestor which supplies one, along with that Command. The
+// ok return value is false if no such command supplies a default for opt.
+func (cmd *Command) commandDefault(opt *Option) (value string, from *Command, ok bool) {
+	for from = cmd; from != nil; from = from.ParentCommand {
+		if from.defaults != nil {
+			if value, ok = optionValueOrAlias(from.defaults, opt); ok {
+				return value, from, true
 			}
 		}
-		sort.Strings(names)
-		for _, name := range names {
-			fmt.Printf("      %*s  %s\n", -1*maxLen, name, cmd.SubCommands[name].Summary)
-		}
-	}
-
-	allOptions := cmd.Options()
-	var maxLen int
-	for _, opt := range allOptions {
-		if nameLen := len(opt.usageName()); nameLen > maxLen {
-			maxLen = nameLen
-		}
-	}
-	for _, grp := range cmd.OptionGroups() {
-		groupName := grp.Name
-		if groupName == "" && cmd.ParentCommand != nil {
-			groupName = cmd.Name
-		}
-		title := fmt.Sprintf("%s Options", strings.Title(groupName))
-		fmt.Printf("\n%s:\n", strings.TrimSpace(title))
-		for _, opt := range grp.Options {
-			fmt.Print(opt.Usage(maxLen))
-		}
 	}
+	return "", nil, false
+}
 
-	if webDocs := cmd.WebDocText(); webDocs != "" {
-		fmt.Printf("\n%s\n\n", wordwrap.WrapString(webDocs, uint(lineLen)))
-	}
+// Usage displays help instructions for a Command on STDOUT. See WriteUsage
+// for information on customizing the output.
+func (cmd *Command) Usage() {
+	cmd.WriteUsage(os.Stdout)
 }
 
 // Invocation returns command-line help for invoking a command with its args.
 func (cmd *Command) Invocation() string {
-	invocation := cmd.Name
-	current := cmd
-	for current.ParentCommand != nil {
-		current = current.ParentCommand
-		invocation = fmt.Sprintf("%s %s", current.Name, invocation)
+	return fmt.Sprintf("%s [<options>]%s", cmd.fullName(), cmd.argUsage())
+}
+
+// fullName returns the command's name, prefixed by the names of any parent
+// commands.
+func (cmd *Command) fullName() string {
+	name := cmd.Name
+	for current := cmd.ParentCommand; current != nil; current = current.ParentCommand {
+		name = fmt.Sprintf("%s %s", current.Name, name)
 	}
-	return fmt.Sprintf("%s [<options>]%s", invocation, cmd.argUsage())
+	return name
 }
 
 // OptionGroups is a helper to return a pre-sorted list of groups of options.
 // The groups are ordered such that the unnamed group is first, and globals are
-// last; any additional groups are in the middle, in alphabetical order. The
+// last; any additional groups are in the middle, starting with groups added
+// via AddOptionGroup in the order they were added (with this command's groups
+// before its parent's), followed by all others in alphabetical order. The
 // options within each group are also sorted in alphabetical order. Hidden
 // options are omitted, since OptionGroup values are intended only for
 // generation of usage/help text.
 func (cmd *Command) OptionGroups() []OptionGroup {
+	return cmd.optionGroups(false)
+}
+
+// optionGroups implements OptionGroups. If includeHidden is true, hidden
+// options are included as well; in this case, the returned groups contain
+// copies of the hidden options with HiddenOnCLI set to false, so that their
+// usage information may be displayed.
+func (cmd *Command) optionGroups(includeHidden bool) []OptionGroup {
 	nameless := []*Option{}
 	global := []*Option{}
 	others := make(map[string][]*Option)
@@ -270,7 +571,12 @@ func (cmd *Command) OptionGroups() []OptionGroup {
 	allOptions := cmd.Options()
 	for _, opt := range allOptions {
 		if opt.HiddenOnCLI {
-			continue
+			if !includeHidden {
+				continue
+			}
+			shown := *opt
+			shown.HiddenOnCLI = false
+			opt = &shown
 		}
 		if opt.Group == "" {
 			nameless = append(nameless, opt)
@@ -289,6 +595,14 @@ func (cmd *Command) OptionGroups() []OptionGroup {
 	if len(nameless) > 0 {
 		ret = append(ret, *newOptionGroup("", nameless))
 	}
+	for current := cmd; current != nil; current = current.ParentCommand {
+		for _, groupName := range current.groupOrder {
+			if opts, ok := others[groupName]; ok {
+				ret = append(ret, *newOptionGroup(groupName, opts))
+				delete(others, groupName)
+			}
+		}
+	}
 	otherNames := make([]string, 0, len(others))
 	for groupName := range others {
 		otherNames = append(otherNames, groupName)
@@ -347,6 +661,55 @@ func (cmd *Command) HasArg(name string) bool {
 	return false
 }
 
+// optionAliasIndex returns a map of alias name => Option, for all aliases of
+// the supplied options. Aliases which are shadowed by the canonical name of
+// another Option are excluded.
+func optionAliasIndex(options map[string]*Option) map[string]*Option {
+	index := make(map[string]*Option)
+	for _, opt := range options {
+		for _, alias := range opt.Aliases {
+			if _, shadowed := options[alias]; !shadowed {
+				index[alias] = opt
+			}
+		}
+	}
+	return index
+}
+
+// optionEdits is incremented whenever a change could affect the options
+// available to any Command, or their aliases. Command.optionLookup uses it to
+// detect when its cached result is out of date.
+var optionEdits uint64
+
+// optionLookup is an index of option name or alias => Option, for all options
+// available to a Command.
+type optionLookup struct {
+	index map[string]*Option
+	edits uint64 // value of optionEdits when index was built
+}
+
+// optionLookup returns a map of option name or alias => Option, for all
+// options available to cmd, including inherited ones. The result is cached
+// until any command's options change, and must not be modified by the caller.
+// Parsing option files uses this to avoid recomputing cmd.Options() and its
+// alias index for every file.
+func (cmd *Command) optionLookup() map[string]*Option {
+	edits := atomic.LoadUint64(&optionEdits)
+	if cached, ok := cmd.lookupCache.Load().(*optionLookup); ok && cached.edits == edits {
+		return cached.index
+	}
+	options := cmd.Options()
+	index := make(map[string]*Option, 2*len(options))
+	for alias, opt := range optionAliasIndex(options) {
+		index[alias] = opt
+	}
+	for name, opt := range options {
+		index[name] = opt
+	}
+	cmd.lookupCache.Store(&optionLookup{index: index, edits: edits})
+	return index
+}
+
 func (cmd *Command) minArgs() int {
 	// If we hit an optional arg at slice position n, this means there
 	// were n required args prior to the optional arg.
@@ -359,6 +722,43 @@ func (cmd *Command) minArgs() int {
 	return len(cmd.args)
 }
 
+// argAt returns the positional arg which receives the value at 0-based
+// position pos on the command-line, or nil if cmd does not accept that many
+// args. All positions beyond the last arg are received by that arg if it is
+// variadic.
+func (cmd *Command) argAt(pos int) *Option {
+	if pos < len(cmd.args) {
+		return cmd.args[pos]
+	} else if len(cmd.args) > 0 && cmd.args[len(cmd.args)-1].variadic {
+		return cmd.args[len(cmd.args)-1]
+	}
+	return nil
+}
+
+var argValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `'`, `\'`, "`", "\\`")
+
+// joinArgValues returns the value of a positional arg, given the command-line
+// values it received. For a variadic arg, the values are unquoted and then
+// re-quoted as needed and comma-separated, in a form suitable for
+// Config.GetSlice. Otherwise, only one value should be supplied, and it is
+// returned as-is.
+func joinArgValues(values []string, variadic bool) string {
+	if !variadic {
+		return values[0]
+	}
+	quoted := make([]string, len(values))
+	for n, value := range values {
+		value = unquote(value)
+		if strings.ContainsAny(value, ",'\"`\\") || value != strings.TrimSpace(value) {
+			// GetSlice does not distinguish between quote characters when tracking
+			// quoted tokens, so escape all of them
+			value = `"` + argValueEscaper.Replace(value) + `"`
+		}
+		quoted[n] = value
+	}
+	return strings.Join(quoted, ",")
+}
+
 func (cmd *Command) argUsage() string {
 	if len(cmd.SubCommands) > 0 {
 		return " <command>"
@@ -367,10 +767,14 @@ func (cmd *Command) argUsage() string {
 	var usage string
 	var optionalArgs int
 	for _, arg := range cmd.args {
+		var ellipsis string
+		if arg.variadic {
+			ellipsis = "..."
+		}
 		if arg.RequireValue {
-			usage += fmt.Sprintf(" <%s>", arg.Name)
+			usage += fmt.Sprintf(" <%s>%s", arg.Name, ellipsis)
 		} else {
-			usage += fmt.Sprintf(" [<%s>", arg.Name)
+			usage += fmt.Sprintf(" [<%s>%s", arg.Name, ellipsis)
 			optionalArgs++
 		}
 	}
@@ -378,30 +782,30 @@ func (cmd *Command) argUsage() string {
 }
 
 func helpHandler(cfg *Config) error {
+	showHidden := cfg.CLI.OptionValues["help-all"] == "1"
 	forCommand := cfg.CLI.Command
 	if forCommand.Name == "help" && forCommand.ParentCommand != nil {
 		forCommand = forCommand.ParentCommand
 	}
-	var forCommandName string
-	if len(cfg.CLI.ArgValues) > 0 {
-		forCommandName = unquote(cfg.CLI.ArgValues[0])
-	}
-	if len(forCommand.SubCommands) > 0 && forCommandName != "" {
-		var ok bool
-		if forCommand, ok = forCommand.SubCommands[forCommandName]; !ok {
-			return fmt.Errorf("Unknown command \"%s\"", forCommandName)
+	// Resolve each supplied name as a subcommand of the previous one, allowing
+	// "help foo bar" to display help for nested subcommand "foo bar"
+	for _, arg := range cfg.CLI.ArgValues {
+		forCommandName := unquote(arg)
+		if len(forCommand.SubCommands) == 0 || forCommandName == "" {
+			break
 		}
+		subCommand, ok := forCommand.SubCommands[forCommandName]
+		if !ok {
+			return UsageError{Command: forCommand, Problem: unknownCommandProblem(forCommand, forCommandName)}
+		}
+		forCommand = subCommand
 	}
-	forCommand.Usage()
-	return nil
-}
-
-func versionHandler(cfg *Config) error {
-	cmd := cfg.CLI.Command.Root()
-	version := cmd.Summary
-	if version == "" {
-		version = "not specified"
+	if !forCommand.Root().HelpPager {
+		return forCommand.writeUsage(os.Stdout, showHidden)
 	}
-	fmt.Println(cmd.Name, "version", version)
-	return nil
+	var b strings.Builder
+	if err := forCommand.renderUsage(&b, showHidden, colorEnabled(os.Stdout)); err != nil {
+		return err
+	}
+	return writePaged(os.Stdout, b.String())
 }
@@ -1,9 +1,19 @@
 package mybase
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"text/template"
+	"time"
+
+	terminal "golang.org/x/term"
 )
 
 func TestCommandInvocation(t *testing.T) {
@@ -42,10 +52,22 @@ func TestCommandOptionGroups(t *testing.T) {
 		StringOption("weight", 0, "", "dummy description"),
 		StringOption("size", 0, "", "dummy description"),
 	)
+	cmd.AddOptionGroup("output",
+		StringOption("format", 0, "", "dummy description"),
+	)
+	cmd.AddOptionGroup("connection",
+		StringOption("user", 0, "", "dummy description"),
+		StringOption("host", 0, "", "dummy description"),
+	)
+	cmd.AddOptionGroup("output",
+		StringOption("color", 0, "", "dummy description"),
+	)
 	actual := cmd.OptionGroups()
-	expectedGroupNames := []string{"", "widgets", "global"}
+	expectedGroupNames := []string{"", "output", "connection", "widgets", "global"}
 	expectedOptionNames := [][]string{
 		{"bool1", "bool2", "hasshort", "truthybool", "visible"},
+		{"color", "format"},
+		{"host", "user"},
 		{"size", "weight"},
 		{"another", "help", "version"},
 	}
@@ -68,6 +90,100 @@ func TestCommandOptionGroups(t *testing.T) {
 	}
 }
 
+func TestLocalOptions(t *testing.T) {
+	suite := simpleCommandSuite()
+	suite.AddOption(BoolOption("verbose", 'v', false, "dummy description"))
+	suite.AddOption(StringOption("suite-only", 0, "", "dummy description").Local())
+	suite.SubCommands["one"].AddOption(StringOption("owned", 'v', "", "dummy description"))
+
+	// Persistent options may be supplied before or after the subcommand name
+	for _, commandLine := range []string{"mycommand --bool1 two", "mycommand two --bool1"} {
+		cfg := ParseFakeCLI(t, suite, commandLine)
+		if cfg.CLI.Command.Name != "two" || !cfg.GetBool("bool1") {
+			t.Errorf("Unexpected result from %q: command=%s bool1=%t", commandLine, cfg.CLI.Command.Name, cfg.GetBool("bool1"))
+		}
+	}
+
+	// Local options are unavailable to subcommands, from any position
+	if _, ok := suite.SubCommands["two"].Options()["suite-only"]; ok {
+		t.Error("Expected local option to not be inherited by subcommand")
+	}
+	if _, ok := suite.Options()["suite-only"]; !ok {
+		t.Error("Expected local option to be present on its own command")
+	}
+	for _, commandLine := range []string{"mycommand --suite-only=x two", "mycommand two --suite-only=x"} {
+		if _, err := ParseCLI(suite, strings.Fields(commandLine)); err == nil {
+			t.Errorf("Expected error from %q, but err is nil", commandLine)
+		} else if _, ok := err.(UsageError); !ok && !strings.Contains(commandLine, "two --") {
+			t.Errorf("Expected UsageError from %q, instead found %T", commandLine, err)
+		}
+	}
+
+	// A subcommand's shorthand takes precedence over its parent's, but only once
+	// the subcommand name has been supplied
+	if _, err := ParseCLI(suite, strings.Fields("mycommand -v hi one")); err == nil {
+		t.Error("Expected -v before subcommand to be parsed as parent's bool option, but err is nil")
+	}
+	cfg := ParseFakeCLI(t, suite, "mycommand one -v hi")
+	if cfg.Get("owned") != "hi" || cfg.GetBool("verbose") {
+		t.Errorf("Unexpected values: owned=%q verbose=%t", cfg.Get("owned"), cfg.GetBool("verbose"))
+	}
+
+	// Help distinguishes local options from inherited ones
+	data := suite.SubCommands["one"].HelpData()
+	if len(data.OptionGroups) < 2 || data.OptionGroups[0].Title != "One Options" || data.OptionGroups[1].Title != "Inherited Options" {
+		t.Fatalf("Unexpected option groups: %+v", data.OptionGroups)
+	}
+	for _, opt := range data.OptionGroups[1].Options {
+		if !opt.Inherited || opt.Name == "suite-only" {
+			t.Errorf("Unexpected option in inherited group: %+v", opt)
+		}
+	}
+}
+
+func TestCommandDefaults(t *testing.T) {
+	suite := simpleCommandSuite()
+	suite.AddOption(StringOption("timeout", 0, "", "dummy description").SetDefaultFunc(func(cfg *Config) string {
+		return "computed"
+	}))
+	suite.SetDefaults(SimpleSource(map[string]string{"hasshort": "suite", "bool1": "1"}))
+	suite.SubCommands["two"].SetDefaults(SimpleSource(map[string]string{"hasshort": "two", "timeout": "'5s'"}))
+
+	// Defaults of the nearest command take precedence, then ancestors, then the
+	// option's own declared default
+	cfg := ParseFakeCLI(t, suite, "mycommand two")
+	if cfg.Get("hasshort") != "two" || cfg.Get("timeout") != "5s" || !cfg.GetBool("bool1") || cfg.Get("hidden") != "somedefault" {
+		t.Errorf("Unexpected values: hasshort=%q timeout=%q bool1=%t hidden=%q", cfg.Get("hasshort"), cfg.Get("timeout"), cfg.GetBool("bool1"), cfg.Get("hidden"))
+	}
+	if cfg.Supplied("hasshort") || cfg.Changed("hasshort") || cfg.Changed("timeout") {
+		t.Error("Expected command-level defaults to be neither supplied nor changed")
+	}
+	if origin, from := cfg.DefaultOrigin("hasshort"); origin != DefaultFromCommand || from != suite.SubCommands["two"] {
+		t.Errorf("Unexpected DefaultOrigin for hasshort: %v %v", origin, from)
+	}
+	if origin, from := cfg.DefaultOrigin("bool1"); origin != DefaultFromCommand || from != suite {
+		t.Errorf("Unexpected DefaultOrigin for bool1: %v %v", origin, from)
+	}
+	if origin, from := cfg.DefaultOrigin("hidden"); origin != DefaultFromOption || from != nil {
+		t.Errorf("Unexpected DefaultOrigin for hidden: %v %v", origin, from)
+	}
+
+	cfg = ParseFakeCLI(t, suite, "mycommand one --hasshort=cli")
+	if cfg.Get("hasshort") != "cli" || !cfg.Changed("hasshort") || cfg.Get("timeout") != "computed" {
+		t.Errorf("Unexpected values: hasshort=%q timeout=%q", cfg.Get("hasshort"), cfg.Get("timeout"))
+	}
+	if origin, _ := cfg.DefaultOrigin("hasshort"); origin != DefaultFromCommand {
+		t.Errorf("Expected DefaultOrigin to describe the default even when overridden, instead found %v", origin)
+	}
+
+	// Help output reflects the command's defaults
+	var buf bytes.Buffer
+	suite.SubCommands["two"].WriteUsage(&buf)
+	if !strings.Contains(buf.String(), `(default "two")`) {
+		t.Errorf("Expected help to show command-level default, instead found:\n%s", buf.String())
+	}
+}
+
 func TestWebDocText(t *testing.T) {
 	single := simpleCommand()
 	actual := single.WebDocText()
@@ -100,6 +216,510 @@ func TestWebDocText(t *testing.T) {
 	}
 }
 
+func TestWriteUsage(t *testing.T) {
+	if terminal.IsTerminal(int(os.Stderr.Fd())) {
+		t.Skip("Skipping test since STDERR is a terminal, so output width is unpredictable")
+	}
+	defer os.Setenv("COLUMNS", os.Getenv("COLUMNS"))
+	os.Unsetenv("COLUMNS")
+
+	cmd := simpleCommand()
+	var buf bytes.Buffer
+	if err := cmd.WriteUsage(&buf); err != nil {
+		t.Fatalf("Unexpected error from WriteUsage: %v", err)
+	}
+	expected := `
+Usage:  mycommand [<options>] <required> [<optional>]
+
+description
+
+Options:
+  -b, --bool1              dummy description
+  -B, --bool2              dummy description
+  -s, --hasshort value     dummy description
+      --[skip-]truthybool  dummy description (enabled by default; disable with --skip-truthybool)
+      --visible value      dummy description
+
+Global Options:
+  -?, --help[=value]       Display usage information for the specified command
+      --version            Display program version
+
+Complete documentation for this command is available online:
+https://www.indexhint.com/test/cmddoc
+
+`
+	if buf.String() != expected {
+		t.Errorf("Unexpected output from WriteUsage: expected\n%s\ninstead found\n%s", expected, buf.String())
+	}
+
+	// Extended help and examples are rendered in their own sections, after
+	// options and before the online documentation text
+	cmd = simpleCommand()
+	cmd.SetLongHelp("Some details.")
+	cmd.AddExample("mycommand --visible=x foo", "Runs with a visible value")
+	buf.Reset()
+	if err := cmd.WriteUsage(&buf); err != nil {
+		t.Fatalf("Unexpected error from WriteUsage: %v", err)
+	}
+	expectedSections := "      --version            Display program version\n\nDetails:\nSome details.\n\nExamples:\n  mycommand --visible=x foo\n      Runs with a visible value\n\nComplete documentation"
+	if !strings.Contains(buf.String(), expectedSections) {
+		t.Errorf("Expected output to contain details and examples sections, instead found:\n%s", buf.String())
+	}
+
+	// COLUMNS should cause option descriptions to wrap and align, subject to a
+	// minimum width
+	os.Setenv("COLUMNS", "60")
+	buf.Reset()
+	if err := cmd.WriteUsage(&buf); err != nil {
+		t.Fatalf("Unexpected error from WriteUsage: %v", err)
+	}
+	if !strings.Contains(buf.String(), "\n                           default; disable with\n") {
+		t.Errorf("Expected option description to be wrapped and aligned, instead found:\n%s", buf.String())
+	}
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if len(line) > 60 {
+			t.Errorf("Expected lines to be no longer than 60, instead found %q", line)
+		}
+	}
+	os.Setenv("COLUMNS", "10")
+	if data := cmd.HelpData(); data.Width != minTerminalWidth {
+		t.Errorf("Expected width to be floored at %d, instead found %d", minTerminalWidth, data.Width)
+	}
+
+	// Custom templates are inherited by subcommands
+	suite := simpleCommandSuite()
+	tmpl := template.Must(template.New("custom").Parse(`{{.Name}} | {{.ArgSynopsis}} | {{range .SubCommands}}{{.Name}},{{end}} | {{range .OptionGroups}}{{.Title}}: {{range .Options}}{{.Shorthand}}{{.Name}}{{.Default}};{{end}} {{end}}`))
+	suite.SetHelpTemplate(tmpl)
+	buf.Reset()
+	if err := suite.SubCommands["one"].WriteUsage(&buf); err != nil {
+		t.Fatalf("Unexpected error from WriteUsage: %v", err)
+	}
+	if actual := buf.String(); !strings.HasPrefix(actual, "mycommand one |  |  | One Options: hidden (default \"somedefault\");nnewopt;visible (default \"newdefault\"); Inherited Options: bbool1;Bbool2;shasshort;") {
+		t.Errorf("Unexpected output from custom template: %q", actual)
+	}
+	buf.Reset()
+	if err := suite.WriteUsage(&buf); err != nil {
+		t.Fatalf("Unexpected error from WriteUsage: %v", err)
+	}
+	if actual := buf.String(); !strings.HasPrefix(actual, "mycommand | <command> | help,one,two,version, | Options: ") {
+		t.Errorf("Unexpected output from custom template: %q", actual)
+	}
+
+	// A global default template applies to commands lacking their own template,
+	// and may use the helper funcs and underlying metadata
+	global, err := ParseHelpTemplate(`{{.Command.Name}}|{{range .Args}}{{.Arg.Name}}{{end}}|{{range .OptionGroups}}{{range .Options}}{{if eq .Name "hidden"}}{{.Option.Default}}{{end}}{{if eq .Name "hasshort"}}{{pad 10 .Name}}|{{.Description | wrap 8 | indent 2}}{{end}}{{end}}{{end}}`)
+	if err != nil {
+		t.Fatalf("Unexpected error from ParseHelpTemplate: %v", err)
+	}
+	SetDefaultHelpTemplate(global)
+	defer SetDefaultHelpTemplate(nil)
+	cmd = simpleCommand()
+	cmd.args[0].Description = "first arg"
+	buf.Reset()
+	if err := cmd.WriteUsageAll(&buf); err != nil {
+		t.Fatalf("Unexpected error from WriteUsage: %v", err)
+	}
+	if actual, expected := buf.String(), "mycommand|requiredoptional|hasshort  |  dummy\n  descriptionsomedefault"; actual != expected {
+		t.Errorf("Unexpected output from global template: %q, expected %q", actual, expected)
+	}
+	buf.Reset()
+	if err := suite.WriteUsage(&buf); err != nil {
+		t.Fatalf("Unexpected error from WriteUsage: %v", err)
+	} else if !strings.HasPrefix(buf.String(), "mycommand | <command> |") {
+		t.Errorf("Expected per-command template to take precedence over global template, instead found %q", buf.String())
+	}
+	SetDefaultHelpTemplate(nil)
+	buf.Reset()
+	if err := cmd.WriteUsage(&buf); err != nil {
+		t.Fatalf("Unexpected error from WriteUsage: %v", err)
+	} else if !strings.Contains(buf.String(), "Usage:") {
+		t.Errorf("Expected SetDefaultHelpTemplate(nil) to restore default template, instead found %q", buf.String())
+	}
+}
+
+func TestSubCommandOrder(t *testing.T) {
+	suite := simpleCommandSuite()
+	suite.AddSubCommand(NewCommand("apply", "summary", "description", nil))
+	subCommandNames := func() (names string) {
+		for _, sub := range suite.HelpData().SubCommands {
+			names += sub.Name + ","
+		}
+		return names
+	}
+	if actual := subCommandNames(); actual != "apply,help,one,two,version," {
+		t.Errorf("Unexpected default subcommand order: %s", actual)
+	}
+	suite.SetSubCommandOrder(SubCommandsDeclared)
+	if actual := subCommandNames(); actual != "one,two,apply,help,version," {
+		t.Errorf("Unexpected declared subcommand order: %s", actual)
+	}
+	if docs := docCommands(suite); len(docs) != 4 || docs[1].Name != "one" || docs[3].Name != "apply" {
+		t.Errorf("Unexpected order of commands in docs: %v", docs)
+	}
+
+	// Categories are listed in the order supplied, followed by any others in
+	// order of first use, with uncategorized commands last
+	suite.SubCommands["two"].SetCategory("Common commands")
+	suite.SubCommands["apply"].SetCategory("Common commands")
+	suite.SubCommands["one"].SetCategory("Advanced commands")
+	var buf bytes.Buffer
+	if err := suite.WriteUsage(&buf); err != nil {
+		t.Fatalf("Unexpected error from WriteUsage: %v", err)
+	}
+	usage := buf.String()
+	if strings.Contains(usage, "\nCommands:") {
+		t.Errorf("Expected categorized usage to not have a plain Commands heading:\n%s", usage)
+	}
+	expectOrder := func(expected ...string) {
+		t.Helper()
+		prevPos := -1
+		for _, text := range expected {
+			pos := strings.Index(usage, text)
+			if pos <= prevPos {
+				t.Errorf("Expected %q to appear after %q in usage:\n%s", text, expected, usage)
+				return
+			}
+			prevPos = pos
+		}
+	}
+	expectOrder("Advanced commands:", "  one ", "Common commands:", "  two ", "  apply ", "Other commands:", "  help ", "  version ")
+	suite.SetCategoryOrder("Common commands")
+	buf.Reset()
+	suite.WriteUsage(&buf)
+	usage = buf.String()
+	expectOrder("Common commands:", "  two ", "  apply ", "Advanced commands:", "  one ", "Other commands:")
+	if data := suite.HelpData(); len(data.Categories) != 3 || data.SubCommands[0].Category != "Common commands" {
+		t.Errorf("Unexpected categories in HelpData: %+v", data.Categories)
+	}
+}
+
+func TestCommandArgs(t *testing.T) {
+	cmd := NewCommand("mycommand", "summary", "description", nil)
+	cmd.AddArg("mode", "", true).SetType(OptionTypeEnum, "fast", "safe").Describe("how to run")
+	cmd.AddArg("timeout", "30s", false).SetType(OptionTypeDuration)
+	cmd.AddVariadicArg("files", false).Describe("files to process")
+	if actual, expected := cmd.Invocation(), "mycommand [<options>] <mode> [<timeout> [<files>...]]"; actual != expected {
+		t.Errorf("Unexpected invocation: expected %q, found %q", expected, actual)
+	}
+
+	cfg := ParseFakeCLI(t, cmd, "mycommand SAFE")
+	if cfg.Get("timeout") != "30s" || len(cfg.GetSlice("files", ',', false)) != 0 {
+		t.Errorf("Unexpected values: timeout=%q files=%q", cfg.Get("timeout"), cfg.GetRaw("files"))
+	}
+	cfg = ParseFakeCLI(t, cmd, "mycommand fast 5 a.txt 'b,c.txt' \"it's.txt\" d.txt")
+	expectedFiles := []string{"a.txt", "b,c.txt", "it's.txt", "d.txt"}
+	if actual := cfg.GetSlice("files", ',', false); !reflect.DeepEqual(actual, expectedFiles) {
+		t.Errorf("Unexpected values for variadic arg: expected %q, found %q", expectedFiles, actual)
+	}
+	if d, err := cfg.GetDuration("timeout"); err != nil || d != 5*time.Second {
+		t.Errorf("Unexpected value for timeout: %q", cfg.Get("timeout"))
+	}
+
+	// Malformed positional args should identify the arg
+	for commandLine, expectedName := range map[string]string{
+		"mycommand slow":          "mode",
+		"mycommand fast 5minutes": "timeout",
+	} {
+		_, err := ParseCLI(cmd, strings.Fields(commandLine))
+		if ave, ok := err.(ArgValueError); !ok || ave.Name != expectedName {
+			t.Errorf("Expected ArgValueError for %s from %q, instead found %v", expectedName, commandLine, err)
+		} else if ExitCode(err) != ExitCodeUsage {
+			t.Errorf("Expected ArgValueError to result in usage exit code, instead found %d", ExitCode(err))
+		}
+	}
+
+	// Validators are applied to each value of a variadic arg
+	cmd.args[2].SetValidator(func(name, value string) error {
+		if !strings.HasSuffix(value, ".txt") {
+			return fmt.Errorf("not a text file")
+		}
+		return nil
+	})
+	cfg = ParseFakeCLI(t, cmd, "mycommand fast 5 a.txt b.csv")
+	if err := cfg.CheckValues(); err == nil {
+		t.Error("Expected validation error, but err is nil")
+	}
+
+	// Help output lists described args
+	data := cmd.HelpData()
+	if len(data.Args) != 3 || !data.Args[0].Required || !data.Args[2].Variadic {
+		t.Errorf("Unexpected args in HelpData: %+v", data.Args)
+	} else if !strings.Contains(data.Args[1].Line, `(default "30s")`) {
+		t.Errorf("Expected default to be shown in help line, instead found %q", data.Args[1].Line)
+	}
+
+	// Args cannot follow a variadic arg, and default values must match the type
+	expectPanic := func(f func()) {
+		t.Helper()
+		defer func() {
+			if recover() == nil {
+				t.Error("Expected panic, but none occurred")
+			}
+		}()
+		f()
+	}
+	expectPanic(func() { cmd.AddArg("extra", "", false) })
+	expectPanic(func() { cmd.args[1].SetType(OptionTypeSize) })
+	expectPanic(func() { cmd.args[0].SetType(OptionTypeEnum) })
+}
+
+func TestNegativeNumberArgs(t *testing.T) {
+	cmd := NewCommand("mycommand", "summary", "description", nil)
+	cmd.AddOption(BoolOption("bool1", 'b', false, "dummy description"))
+	cmd.AddOption(BoolOption("five", '5', false, "dummy description"))
+	cmd.AddArg("first", "", true)
+	cmd.AddVariadicArg("rest", false)
+
+	cases := map[string][]string{
+		"mycommand -3 -1.5 -.25": {"-3", "-1.5", "-.25"},
+		"mycommand -b -- -b --x": {"-b", "--x"},
+		"mycommand -5 -- -- -5":  {"--", "-5"}, // -5 matches a short option before terminator
+		"mycommand -42 -b -1e3":  {"-42", "-1e3"},
+		"mycommand x -0":         {"x", "-0"},
+		"mycommand -- -bool1 --": {"-bool1", "--"},
+	}
+	for commandLine, expected := range cases {
+		cfg, err := ParseCLI(cmd, strings.Fields(commandLine))
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %v", commandLine, err)
+		} else if !reflect.DeepEqual(cfg.CLI.ArgValues, expected) {
+			t.Errorf("Unexpected positional args parsing %q: expected %q, found %q", commandLine, expected, cfg.CLI.ArgValues)
+		}
+	}
+	if cfg, err := ParseCLI(cmd, strings.Fields("mycommand -5 -b -3")); err != nil || !cfg.GetBool("five") || !cfg.GetBool("bool1") {
+		t.Errorf("Expected -5 to be treated as short option when one matches, instead err=%v", err)
+	}
+
+	// Non-numeric values beginning with a dash are still treated as options
+	for _, commandLine := range []string{"mycommand -inf", "mycommand -3x", "mycommand -1-2"} {
+		if _, err := ParseCLI(cmd, strings.Fields(commandLine)); err == nil {
+			t.Errorf("Expected error parsing %q, but err is nil", commandLine)
+		}
+	}
+}
+
+func TestArgOrder(t *testing.T) {
+	suite := NewCommandSuite("mycommand", "summary", "description")
+	suite.AddOption(BoolOption("bool1", 'b', false, "dummy description"))
+	run := NewCommand("run", "summary", "description", nil)
+	run.AddOption(StringOption("visible", 'v', "", "dummy description"))
+	run.AddArg("first", "", true)
+	run.AddVariadicArg("rest", false)
+	suite.AddSubCommand(run)
+
+	assertParse := func(commandLine string, expectBool1 bool, expectVisible string, expectArgs ...string) {
+		t.Helper()
+		cfg, err := ParseCLI(suite, strings.Fields(commandLine))
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %v", commandLine, err)
+		} else if cfg.GetBool("bool1") != expectBool1 || cfg.Get("visible") != expectVisible || !reflect.DeepEqual(cfg.CLI.ArgValues, expectArgs) {
+			t.Errorf("Unexpected result parsing %q: bool1=%t visible=%q args=%q", commandLine, cfg.GetBool("bool1"), cfg.Get("visible"), cfg.CLI.ArgValues)
+		}
+	}
+
+	// Default is intermixed
+	assertParse("mycommand run a -b --visible=x b", true, "x", "a", "b")
+
+	// Options-first, inherited from the suite; options may still follow the
+	// subcommand name
+	suite.SetArgOrder(ArgOrderOptionsFirst)
+	assertParse("mycommand -b run -v x a -b --visible=y", true, "x", "a", "-b", "--visible=y")
+	assertParse("mycommand run a -- -b", false, "", "a", "--", "-b")
+
+	// Subcommand may override the suite's setting
+	run.SetArgOrder(ArgOrderIntermixed)
+	assertParse("mycommand run a -b --visible=x b", true, "x", "a", "b")
+	run.SetArgOrder(ArgOrderInherit)
+
+	// BootstrapCLI also stops parsing options at the first positional arg
+	suite.AddOption(StringOption("profile", 0, "", "dummy description").Bootstrap())
+	cfg, err := BootstrapCLI(suite, strings.Fields("mycommand run a --profile=x"))
+	if err != nil {
+		t.Fatalf("Unexpected error from BootstrapCLI: %v", err)
+	} else if cfg.Get("profile") != "" {
+		t.Errorf("Expected --profile after positional arg to be ignored, instead found %q", cfg.Get("profile"))
+	}
+}
+
+func TestWriteUsageAll(t *testing.T) {
+	if terminal.IsTerminal(int(os.Stderr.Fd())) {
+		t.Skip("Skipping test since STDERR is a terminal, so output width is unpredictable")
+	}
+	defer os.Setenv("COLUMNS", os.Getenv("COLUMNS"))
+	os.Unsetenv("COLUMNS")
+
+	cmd := simpleCommand()
+	var buf bytes.Buffer
+	if err := cmd.WriteUsageAll(&buf); err != nil {
+		t.Fatalf("Unexpected error from WriteUsageAll: %v", err)
+	}
+	for _, expected := range []string{
+		"      --hidden value       dummy description (default \"somedefault\")\n",
+		"      --help-all           Display usage information, including hidden options\n",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("Expected output of WriteUsageAll to contain %q, but it did not:\n%s", expected, buf.String())
+		}
+	}
+	if opt := cmd.Options()["hidden"]; !opt.HiddenOnCLI {
+		t.Error("Expected WriteUsageAll to leave HiddenOnCLI unchanged")
+	}
+	buf.Reset()
+	cmd.WriteUsage(&buf)
+	if strings.Contains(buf.String(), "hidden") {
+		t.Errorf("Expected WriteUsage to omit hidden options, but it did not:\n%s", buf.String())
+	}
+
+	// --help-all should bypass the check for required args, like --help
+	if _, err := ParseCLI(cmd, []string{"mycommand", "--help-all"}); err != nil {
+		t.Errorf("Unexpected error from ParseCLI with --help-all: %v", err)
+	}
+}
+
+func TestAssertUsageGolden(t *testing.T) {
+	AssertUsageGolden(t, simpleCommand(), filepath.Join("testdata", "usage", "simple.txt"))
+	if fixedTerminalWidth != 0 {
+		t.Errorf("Expected AssertUsageGolden to restore terminal width, instead found %d", fixedTerminalWidth)
+	}
+}
+
+func TestUsageColorAndPager(t *testing.T) {
+	cmd := simpleCommand()
+	var buf bytes.Buffer
+	if err := cmd.renderUsage(&buf, false, true); err != nil {
+		t.Fatalf("Unexpected error from renderUsage: %v", err)
+	}
+	for _, expected := range []string{"\x1b[1mUsage:\x1b[0m  mycommand", "\n\x1b[1mGlobal Options:\x1b[0m\n"} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("Expected colorized output to contain %q, but it did not:\n%s", expected, buf.String())
+		}
+	}
+	buf.Reset()
+	cmd.WriteUsage(&buf) // not a terminal, so no color
+	if strings.Contains(buf.String(), "\x1b") || !strings.Contains(buf.String(), "\nGlobal Options:\n") {
+		t.Errorf("Unexpected output from WriteUsage to non-terminal:\n%s", buf.String())
+	}
+
+	// Custom color schemes are inherited by subcommands, and apply to command
+	// names, option flags, defaults, and errors
+	suite := simpleCommandSuite()
+	suite.SetColorScheme(&ColorScheme{Heading: "4", CommandName: "36", OptionFlag: "1", Default: "2", Error: "31"})
+	buf.Reset()
+	if err := suite.renderUsage(&buf, false, true); err != nil {
+		t.Fatalf("Unexpected error from renderUsage: %v", err)
+	}
+	for _, expected := range []string{
+		"\x1b[4mUsage:\x1b[0m  \x1b[36mmycommand\x1b[0m [<options>] <command>",
+		"\n      \x1b[36mone\x1b[0m  ",
+		"\x1b[1m-s, --hasshort value\x1b[0m     dummy description\n",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("Expected colorized output to contain %q, but it did not:\n%s", expected, buf.String())
+		}
+	}
+	buf.Reset()
+	if err := suite.SubCommands["one"].renderUsage(&buf, false, true); err != nil {
+		t.Fatalf("Unexpected error from renderUsage: %v", err)
+	}
+	if expected := "\x1b[2m(default \"newdefault\")\x1b[0m"; !strings.Contains(buf.String(), expected) {
+		t.Errorf("Expected colorized output to contain %q, but it did not:\n%s", expected, buf.String())
+	}
+	buf.Reset()
+	writeCommandError(&buf, suite, errors.New("something broke"), true)
+	if expected := "\x1b[31mError: something broke\x1b[0m\n"; buf.String() != expected {
+		t.Errorf("Expected error output %q, instead found %q", expected, buf.String())
+	}
+	suite.SetColorScheme(nil)
+	if suite.SubCommands["one"].colors() != DefaultColorScheme {
+		t.Error("Expected SetColorScheme(nil) to revert to DefaultColorScheme")
+	}
+
+	// Output to a non-terminal is never paged
+	f, err := ioutil.TempFile("", "mybasetest")
+	if err != nil {
+		t.Fatalf("Unable to create temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	defer os.Setenv("PAGER", os.Getenv("PAGER"))
+	os.Setenv("PAGER", "false")
+	if colorEnabled(f) {
+		t.Error("Expected colorEnabled to return false for a regular file")
+	}
+	text := strings.Repeat("line\n", 500)
+	if err := writePaged(f, text); err != nil {
+		t.Fatalf("Unexpected error from writePaged: %v", err)
+	}
+	if contents, _ := ioutil.ReadFile(f.Name()); string(contents) != text {
+		t.Errorf("Unexpected contents written by writePaged: %d bytes", len(contents))
+	}
+}
+
+func TestMySQLStyleShortOptions(t *testing.T) {
+	cmd := NewCommand("mycommand", "summary", "description", nil)
+	cmd.AddOption(CountOption("verbose", 'v', "dummy description").AddAlias("debug"))
+	cmd.AddOption(BoolOption("bool1", 'b', false, "dummy description"))
+	cmd.AddOption(StringOption("password", 'p', "", "dummy description").ValueOptional().PromptIfMissing(""))
+	cmd.AddOption(StringOption("user", 'u', "", "dummy description"))
+
+	counts := map[string]int{
+		"mycommand":                              0,
+		"mycommand -v":                           1,
+		"mycommand -vvv":                         3,
+		"mycommand -v -bv --verbose":             3,
+		"mycommand --debug -v":                   2,
+		"mycommand --verbose=5 -vv":              7,
+		"mycommand -vvv --skip-verbose -v":       1,
+		"mycommand -vvv --verbose=0":             0,
+		"mycommand -vbvuroot -v":                 3,
+		"mycommand --enable-verbose --verbose=2": 2,
+	}
+	for commandLine, expected := range counts {
+		cfg := ParseFakeCLI(t, cmd, commandLine)
+		if actual, err := cfg.GetInt("verbose"); err != nil || actual != expected {
+			t.Errorf("Expected %q to result in verbose=%d, instead found %d (err=%v)", commandLine, expected, actual, err)
+		}
+	}
+	if _, err := ParseCLI(cmd, []string{"mycommand", "--verbose=lots"}); err == nil {
+		t.Error("Expected error for non-numeric count value, but err is nil")
+	}
+	if usage := cmd.Options()["verbose"].Usage(20); !strings.Contains(usage, "dummy description (may be repeated)") {
+		t.Errorf("Expected usage of count option to mention repetition, instead found %q", usage)
+	}
+	if cfg := ParseFakeCLI(t, cmd, "mycommand -vbvuroot"); !cfg.GetBool("bool1") || cfg.Get("user") != "root" {
+		t.Errorf("Unexpected values from clustered short options: bool1=%t user=%q", cfg.GetBool("bool1"), cfg.Get("user"))
+	}
+
+	// In an option file, a bare count option name means 1
+	cfg := ParseFakeCLI(t, cmd, "mycommand")
+	file := NewFile("/etc/myconfigs", "my.cnf")
+	file.contents = "verbose\n"
+	file.read = true
+	if err := file.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error from Parse: %v", err)
+	}
+	cfg.AddSource(file)
+	if actual, _ := cfg.GetInt("verbose"); actual != 1 {
+		t.Errorf("Expected bare verbose in option file to mean 1, instead found %d", actual)
+	}
+
+	// -p with attached value supplies a password, but bare -p prompts for one
+	cfg = ParseFakeCLI(t, cmd, "mycommand -psecret -v")
+	cfg.PromptInput = strings.NewReader("")
+	if err := cfg.PromptMissing(); err != nil || cfg.Get("password") != "secret" {
+		t.Errorf("Expected -psecret to supply password without prompting, instead err=%v password=%q", err, cfg.Get("password"))
+	}
+	cfg = ParseFakeCLI(t, cmd, "mycommand -p -v")
+	var output bytes.Buffer
+	cfg.PromptInput, cfg.PromptOutput = strings.NewReader("hunter2\n"), &output
+	if err := cfg.PromptMissing(); err != nil || cfg.Get("password") != "hunter2" || output.Len() == 0 {
+		t.Errorf("Expected bare -p to prompt for password, instead err=%v password=%q", err, cfg.Get("password"))
+	}
+	if actual, _ := cfg.GetInt("verbose"); actual != 1 {
+		t.Errorf("Expected -v after bare -p to be parsed as an option, instead verbose=%d", actual)
+	}
+}
+
 // simpleCommand returns a standalone command for testing purposes
 func simpleCommand() *Command {
 	cmd := NewCommand("mycommand", "summary", "description", nil)
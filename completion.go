@@ -0,0 +1,534 @@
+package mybase
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// completeCommandName is the name of the hidden command which the scripts
+// generated by GenerateCompletion call to obtain dynamic completions.
+const completeCommandName = "__complete"
+
+// GenerateCompletion returns a shell completion script for the program that
+// cmd belongs to. The supported shells are "bash", "zsh", and "fish". The
+// script completes subcommand names; the long and short names of all options
+// which are not hidden, including aliases and the skip- and disable- forms of
+// boolean options; and the allowed values of enum options. Values of options
+// and positional args with a CompletionFunc are completed dynamically, by
+// running the program with a hidden "__complete" command; see
+// Option.SetCompletion. Other positional args fall back to the shell's default
+// filename completion.
+//
+// The script covers the full command tree, starting from cmd's top-level
+// command, regardless of which Command in the tree it is called on. Typically
+// applications expose this via a subcommand or hidden option, so that users
+// can install the output in the location expected by their shell.
+func (cmd *Command) GenerateCompletion(shell string) (string, error) {
+	root := cmd.Root()
+	cc := &completionContext{
+		progName: root.Name,
+		funcName: "_" + nonIdentifierChars.ReplaceAllString(root.Name, "_"),
+	}
+	cc.addCommand(root, "")
+
+	var b strings.Builder
+	switch strings.ToLower(shell) {
+	case "bash":
+		cc.writeBash(&b)
+	case "zsh":
+		cc.writeZsh(&b)
+	case "fish":
+		cc.writeFish(&b)
+	default:
+		return "", fmt.Errorf("Unable to generate completion script for shell %q: supported shells are bash, zsh, and fish", shell)
+	}
+	return b.String(), nil
+}
+
+var nonIdentifierChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// CompletionFunc returns candidates for completing the value of an option or
+// positional arg in a shell, such as schema names obtained by querying a
+// database server. toComplete is the partially-typed value, which may be
+// empty; candidates which are empty or do not begin with it are discarded. See
+// Option.SetCompletion.
+type CompletionFunc func(cfg *Config, toComplete string) []string
+
+// SetCompletion supplies a function which dynamically completes the Option's
+// value in the scripts generated by Command.GenerateCompletion. This may be
+// used on options as well as positional args, i.e. the Options returned by
+// Command.AddArg. When the user requests completion of the value, the script
+// runs the program with a hidden "__complete" command, which is handled
+// automatically by ParseCLI and Config.HandleCommand, and which calls fn.
+//
+// The Config supplied to fn reflects the partially-typed command-line, but
+// has no other sources, since the program's handlers do not run. If fn needs
+// values from option files, such as server connection options, it should add
+// these sources to the Config before reading any values. Errors should not be
+// reported from fn, since any output would interfere with the shell; it
+// should simply return no candidates instead.
+func (opt *Option) SetCompletion(fn CompletionFunc) *Option {
+	opt.completer = fn
+	return opt
+}
+
+// completeConfig returns a Config for a program invoked with the hidden
+// "__complete" command, as args[1]. The remaining args are the words of the
+// command-line being completed, excluding the program name.
+func completeConfig(cmd *Command, args []string) *Config {
+	completeCmd := &Command{
+		Name:          completeCommandName,
+		ParentCommand: cmd,
+		Handler:       completeHandler,
+	}
+	cli := &CommandLine{
+		Command:      completeCmd,
+		InvokedAs:    args[0],
+		OptionValues: make(map[string]string),
+		ArgValues:    args[2:],
+	}
+	return NewConfig(cli)
+}
+
+// completeHandler prints the dynamic completions for the command-line supplied
+// to the hidden "__complete" command, one per line.
+func completeHandler(cfg *Config) error {
+	for _, candidate := range completions(cfg.CLI.Command.ParentCommand, cfg.CLI.ArgValues) {
+		fmt.Println(candidate)
+	}
+	return nil
+}
+
+// completions returns the dynamic completions for the last of the supplied
+// command-line words, which are parsed starting from cmd. The last word may
+// be empty, or a partially-typed value.
+func completions(cmd *Command, words []string) []string {
+	cfg, opt, toComplete := completionTarget(cmd, words)
+	if opt == nil || opt.completer == nil {
+		return nil
+	}
+	var candidates []string
+	for _, candidate := range opt.completer(cfg, toComplete) {
+		if candidate != "" && strings.HasPrefix(candidate, toComplete) {
+			candidates = append(candidates, candidate)
+		}
+	}
+	return candidates
+}
+
+// completionTarget determines which option or positional arg is being
+// completed by the last of the supplied command-line words, returning it along
+// with a Config parsed from the preceding words, and the partially-typed
+// value. A nil Option is returned if the last word is a subcommand or flag
+// name, or if the preceding words cannot be parsed.
+func completionTarget(cmd *Command, words []string) (*Config, *Option, string) {
+	words = joinEqualsWords(words)
+	if len(words) == 0 {
+		return nil, nil, ""
+	}
+	prior, toComplete := words[:len(words)-1], words[len(words)-1]
+
+	// Determine if the value of an option is being completed, either in the same
+	// word as its flag, or in the word following the flag
+	var flag string
+	var sameWord bool
+	if strings.HasPrefix(toComplete, "-") {
+		eq := strings.IndexByte(toComplete, '=')
+		if !strings.HasPrefix(toComplete, "--") || eq < 0 {
+			return nil, nil, "" // flag names are completed statically
+		}
+		flag, toComplete, sameWord = toComplete[:eq], toComplete[eq+1:], true
+	} else if n := len(prior); n > 0 && strings.HasPrefix(prior[n-1], "-") && prior[n-1] != "--" && !strings.Contains(prior[n-1], "=") {
+		flag, prior = prior[n-1], prior[:n-1]
+	}
+
+	args := append([]string{cmd.Name}, prior...)
+	cfg, err := parseCLI(cmd, args, true)
+	if err != nil {
+		return nil, nil, ""
+	}
+	if flag != "" {
+		opt, hasValue := completionFlagOption(cfg.CLI.Command, flag)
+		if opt != nil && opt.Type != OptionTypeBool && opt.Type != OptionTypeCount && (sameWord || (opt.RequireValue && !hasValue)) {
+			return cfg, opt, toComplete
+		} else if sameWord {
+			return nil, nil, ""
+		}
+		// The previous word was a flag which does not consume the word being
+		// completed, so reparse with it included
+		if cfg, err = parseCLI(cmd, append(args, flag), true); err != nil {
+			return nil, nil, ""
+		}
+	}
+	return cfg, cfg.CLI.Command.argAt(len(cfg.CLI.ArgValues)), toComplete
+}
+
+// joinEqualsWords returns words with any standalone "=" combined with the
+// preceding long flag and the following word, if any. This undoes the word
+// splitting of some shells, for example bash splits "--foo=bar" into "--foo",
+// "=", and "bar" by default.
+func joinEqualsWords(words []string) []string {
+	joined := make([]string, 0, len(words))
+	for n := 0; n < len(words); n++ {
+		if last := len(joined) - 1; words[n] == "=" && last >= 0 && strings.HasPrefix(joined[last], "--") && !strings.Contains(joined[last], "=") {
+			joined[last] += "="
+			if n+1 < len(words) {
+				n++
+				joined[last] += words[n]
+			}
+			continue
+		}
+		joined = append(joined, words[n])
+	}
+	return joined
+}
+
+// completionFlagOption returns the Option of cmd corresponding to a flag on
+// the command-line, which may be a long or short flag, without a value. For
+// combined short flags, the last one is used. hasValue is true if the flag
+// supplies a value by itself, such as "--skip-foo".
+func completionFlagOption(cmd *Command, flag string) (opt *Option, hasValue bool) {
+	options := cmd.Options()
+	if strings.HasPrefix(flag, "--") {
+		key, _, hasValue, _ := NormalizeOptionToken(flag[2:])
+		if opt = options[key]; opt == nil {
+			opt = optionAliasIndex(options)[key]
+		}
+		return opt, hasValue
+	}
+	shorthands := []rune(flag[1:])
+	if len(shorthands) == 0 {
+		return nil, false
+	}
+	for _, candidate := range options {
+		if candidate.Shorthand == shorthands[len(shorthands)-1] {
+			return candidate, false
+		}
+	}
+	return nil, false
+}
+
+// completionContext contains the information about a command tree needed to
+// generate completion scripts.
+type completionContext struct {
+	progName string
+	funcName string
+	commands []completionCommand
+}
+
+// completionCommand describes one command in the tree. Its path consists of
+// the space-separated names of subcommands leading to it from the top-level
+// command, which has an empty path.
+type completionCommand struct {
+	path        string
+	parentPath  string
+	name        string
+	summary     string
+	subCommands []string
+	options     []*Option
+	hasArgs     bool
+	dynamicArgs bool // true if any positional args have a CompletionFunc
+}
+
+// addCommand recursively adds cmd and its subcommands to cc, in a depth-first
+// traversal ordered by subcommand name.
+func (cc *completionContext) addCommand(cmd *Command, parentPath string) {
+	cmdPath := cmd.Name
+	if cmd.ParentCommand == nil {
+		cmdPath = ""
+	} else if parentPath != "" {
+		cmdPath = parentPath + " " + cmd.Name
+	}
+	info := completionCommand{
+		path:       cmdPath,
+		parentPath: parentPath,
+		name:       cmd.Name,
+		summary:    cmd.Summary,
+		hasArgs:    len(cmd.args) > 0 && len(cmd.SubCommands) == 0,
+	}
+	for _, arg := range cmd.args {
+		info.dynamicArgs = info.dynamicArgs || (info.hasArgs && arg.completer != nil)
+	}
+	options := cmd.Options()
+	names := make([]string, 0, len(options))
+	for name, opt := range options {
+		if !opt.HiddenOnCLI {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		info.options = append(info.options, options[name])
+	}
+	for name := range cmd.SubCommands {
+		info.subCommands = append(info.subCommands, name)
+	}
+	sort.Strings(info.subCommands)
+	cc.commands = append(cc.commands, info)
+	for _, name := range info.subCommands {
+		cc.addCommand(cmd.SubCommands[name], cmdPath)
+	}
+}
+
+// completionFlags returns the command-line flags which may be used to supply
+// opt.
+func completionFlags(opt *Option) []string {
+	names := append([]string{opt.Name}, opt.Aliases...)
+	var flags []string
+	for _, name := range names {
+		flags = append(flags, "--"+name)
+		if opt.Type == OptionTypeBool {
+			flags = append(flags, "--skip-"+name, "--disable-"+name)
+		}
+	}
+	if opt.Shorthand > 0 {
+		flags = append(flags, "-"+string(opt.Shorthand))
+	}
+	return flags
+}
+
+// completionWords returns all words that may be completed for a command when
+// not completing an option value: subcommand names followed by flags.
+func (cc *completionCommand) completionWords() []string {
+	words := append([]string{}, cc.subCommands...)
+	for _, opt := range cc.options {
+		words = append(words, completionFlags(opt)...)
+	}
+	return words
+}
+
+// valueOptions returns the options of cc which take a value, since these need
+// special handling when completing the word after the flag.
+func (cc *completionCommand) valueOptions() (opts []*Option) {
+	for _, opt := range cc.options {
+		if opt.Type != OptionTypeBool && opt.Type != OptionTypeCount {
+			opts = append(opts, opt)
+		}
+	}
+	return opts
+}
+
+// valuePatterns returns shell case patterns matching the flag preceding a value
+// for opt, where the value is being completed, in the form used by the bash
+// and zsh scripts: "path:--flag=" for values supplied with an equals sign, or
+// "path:--flag" for values supplied as a separate word.
+func (cc *completionCommand) valuePatterns(opt *Option) []string {
+	var patterns []string
+	for _, name := range append([]string{opt.Name}, opt.Aliases...) {
+		patterns = append(patterns, shellQuote(cc.path+":--"+name+"="))
+		if opt.RequireValue {
+			patterns = append(patterns, shellQuote(cc.path+":--"+name))
+		}
+	}
+	if opt.Shorthand > 0 && opt.RequireValue {
+		patterns = append(patterns, shellQuote(cc.path+":-"+string(opt.Shorthand)))
+	}
+	return patterns
+}
+
+// shellQuote wraps s in single quotes for use in a POSIX-style shell script.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// Commands which the bash and zsh scripts use to obtain dynamic completions,
+// supplying the words of the command-line up to and including the current
+// word. Any error output is discarded, since it would interfere with the
+// shell.
+const (
+	bashCompleteCommand = `"${COMP_WORDS[0]}" ` + completeCommandName + ` "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null`
+	zshCompleteCommand  = `"${words[1]}" ` + completeCommandName + ` "${(@)words[2,CURRENT]}" 2>/dev/null`
+)
+
+// fishCompleteCommand returns a command substitution which the fish script
+// uses to obtain dynamic completions.
+func (cc *completionContext) fishCompleteCommand() string {
+	return fmt.Sprintf("(%s %s (commandline -opc)[2..-1] (commandline -ct) 2>/dev/null)", cc.progName, completeCommandName)
+}
+
+// writeCommandPathCase writes a case statement which updates the cmdpath
+// shell variable based on the current word, for use by the bash and zsh
+// scripts.
+func (cc *completionContext) writeCommandPathCase(b *strings.Builder, indent string) {
+	fmt.Fprintf(b, "%scase \"$cmdpath:$word\" in\n", indent)
+	for _, c := range cc.commands[1:] {
+		fmt.Fprintf(b, "%s\t%s) cmdpath=%s ;;\n", indent, shellQuote(c.parentPath+":"+c.name), shellQuote(c.path))
+	}
+	fmt.Fprintf(b, "%sesac\n", indent)
+}
+
+func (cc *completionContext) writeBash(b *strings.Builder) {
+	fn := cc.funcName + "_completion"
+	fmt.Fprintf(b, "# bash completion for %s\n", cc.progName)
+	fmt.Fprintf(b, "%s() {\n", fn)
+	b.WriteString("\tlocal cur opt word i prefix=\"\" cmdpath=\"\"\n")
+	b.WriteString("\tcur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+	b.WriteString("\tfor ((i=1; i<COMP_CWORD; i++)); do\n")
+	b.WriteString("\t\tword=\"${COMP_WORDS[i]}\"\n")
+	cc.writeCommandPathCase(b, "\t\t")
+	b.WriteString("\tdone\n\n")
+
+	b.WriteString("\topt=\"${COMP_WORDS[COMP_CWORD-1]}\"\n")
+	b.WriteString("\tif [[ \"$opt\" == \"=\" ]]; then\n")
+	b.WriteString("\t\topt=\"${COMP_WORDS[COMP_CWORD-2]}=\"\n")
+	b.WriteString("\telif [[ \"$cur\" == --*=* ]]; then\n")
+	b.WriteString("\t\topt=\"${cur%%=*}=\"\n")
+	b.WriteString("\t\tprefix=\"$opt\"\n")
+	b.WriteString("\t\tcur=\"${cur#*=}\"\n")
+	b.WriteString("\tfi\n")
+	b.WriteString("\tcase \"$cmdpath:$opt\" in\n")
+	for _, c := range cc.commands {
+		for _, opt := range c.valueOptions() {
+			action := "return"
+			if opt.completer != nil {
+				action = "COMPREPLY=($(compgen -P \"$prefix\" -W \"$(" + bashCompleteCommand + ")\" -- \"$cur\")); return"
+			} else if len(opt.AllowedValues) > 0 {
+				action = fmt.Sprintf("COMPREPLY=($(compgen -P \"$prefix\" -W %s -- \"$cur\")); return", shellQuote(strings.Join(opt.AllowedValues, " ")))
+			}
+			fmt.Fprintf(b, "\t\t%s) %s ;;\n", strings.Join(c.valuePatterns(opt), "|"), action)
+		}
+	}
+	b.WriteString("\tesac\n\n")
+
+	b.WriteString("\tcase \"$cmdpath\" in\n")
+	for _, c := range cc.commands {
+		var dynamic string
+		if c.dynamicArgs {
+			dynamic = " $(compgen -W \"$(" + bashCompleteCommand + ")\" -- \"$cur\")"
+		}
+		fmt.Fprintf(b, "\t\t%s) COMPREPLY=($(compgen -W %s -- \"$cur\")%s) ;;\n", shellQuote(c.path), shellQuote(strings.Join(c.completionWords(), " ")), dynamic)
+	}
+	b.WriteString("\tesac\n")
+	b.WriteString("}\n")
+	fmt.Fprintf(b, "complete -o default -F %s %s\n", fn, cc.progName)
+}
+
+func (cc *completionContext) writeZsh(b *strings.Builder) {
+	fn := cc.funcName
+	fmt.Fprintf(b, "#compdef %s\n\n", cc.progName)
+	fmt.Fprintf(b, "%s() {\n", fn)
+	b.WriteString("\tlocal cur opt word i cmdpath=\"\"\n")
+	b.WriteString("\tcur=\"${words[CURRENT]}\"\n")
+	b.WriteString("\tfor ((i=2; i<CURRENT; i++)); do\n")
+	b.WriteString("\t\tword=\"${words[i]}\"\n")
+	cc.writeCommandPathCase(b, "\t\t")
+	b.WriteString("\tdone\n\n")
+
+	b.WriteString("\topt=\"${words[CURRENT-1]}\"\n")
+	b.WriteString("\tif [[ \"$cur\" == --*=* ]]; then\n")
+	b.WriteString("\t\topt=\"${cur%%=*}=\"\n")
+	b.WriteString("\t\tcompset -P '*='\n")
+	b.WriteString("\tfi\n")
+	b.WriteString("\tcase \"$cmdpath:$opt\" in\n")
+	for _, c := range cc.commands {
+		for _, opt := range c.valueOptions() {
+			action := "_files; return"
+			if opt.completer != nil {
+				action = "compadd -- ${(f)\"$(" + zshCompleteCommand + ")\"}; return"
+			} else if len(opt.AllowedValues) > 0 {
+				quoted := make([]string, len(opt.AllowedValues))
+				for n, value := range opt.AllowedValues {
+					quoted[n] = shellQuote(value)
+				}
+				action = fmt.Sprintf("compadd -- %s; return", strings.Join(quoted, " "))
+			}
+			fmt.Fprintf(b, "\t\t%s) %s ;;\n", strings.Join(c.valuePatterns(opt), "|"), action)
+		}
+	}
+	b.WriteString("\tesac\n\n")
+
+	b.WriteString("\tcase \"$cmdpath\" in\n")
+	for _, c := range cc.commands {
+		words := c.completionWords()
+		for n, word := range words {
+			words[n] = shellQuote(word)
+		}
+		action := fmt.Sprintf("compadd -- %s", strings.Join(words, " "))
+		if c.dynamicArgs {
+			action += "; compadd -- ${(f)\"$(" + zshCompleteCommand + ")\"}"
+		}
+		if c.hasArgs {
+			action += "; _files"
+		}
+		fmt.Fprintf(b, "\t\t%s) %s ;;\n", shellQuote(c.path), action)
+	}
+	b.WriteString("\tesac\n")
+	b.WriteString("}\n\n")
+	fmt.Fprintf(b, "if [ \"$funcstack[1]\" = \"%s\" ]; then\n", fn)
+	fmt.Fprintf(b, "\t%s \"$@\"\n", fn)
+	b.WriteString("else\n")
+	fmt.Fprintf(b, "\tcompdef %s %s\n", fn, cc.progName)
+	b.WriteString("fi\n")
+}
+
+func (cc *completionContext) writeFish(b *strings.Builder) {
+	fn := "_" + cc.funcName + "_cmdpath"
+	fmt.Fprintf(b, "# fish completion for %s\n", cc.progName)
+	fmt.Fprintf(b, "function %s\n", fn)
+	b.WriteString("\tset -l cmdpath \"\"\n")
+	b.WriteString("\tfor word in (commandline -opc)[2..-1]\n")
+	b.WriteString("\t\tswitch \"$cmdpath:$word\"\n")
+	for _, c := range cc.commands[1:] {
+		fmt.Fprintf(b, "\t\t\tcase %s\n", fishQuote(c.parentPath+":"+c.name))
+		fmt.Fprintf(b, "\t\t\t\tset cmdpath %s\n", fishQuote(c.path))
+	}
+	b.WriteString("\t\tend\n")
+	b.WriteString("\tend\n")
+	b.WriteString("\techo $cmdpath\n")
+	b.WriteString("end\n\n")
+
+	fmt.Fprintf(b, "complete -c %s -f\n", cc.progName)
+	for _, c := range cc.commands {
+		prefix := fmt.Sprintf("complete -c %s -n %s", cc.progName, fishQuote(fmt.Sprintf("test (%s) = %s", fn, fishQuote(c.path))))
+		for _, name := range c.subCommands {
+			var summary string
+			for _, sub := range cc.commands {
+				if sub.parentPath == c.path && sub.name == name && sub.path != "" {
+					summary = sub.summary
+					break
+				}
+			}
+			fmt.Fprintf(b, "%s -a %s -d %s\n", prefix, fishQuote(name), fishQuote(summary))
+		}
+		if c.dynamicArgs {
+			fmt.Fprintf(b, "%s -a %s\n", prefix, fishQuote(cc.fishCompleteCommand()))
+		}
+		if c.hasArgs {
+			fmt.Fprintf(b, "%s -F\n", prefix)
+		}
+		for _, opt := range c.options {
+			desc := fishQuote(strings.Join(strings.Fields(opt.Description), " "))
+			var short string
+			if opt.Shorthand > 0 {
+				short = " -s " + fishQuote(string(opt.Shorthand))
+			}
+			for n, name := range append([]string{opt.Name}, opt.Aliases...) {
+				if n > 0 {
+					short = ""
+				}
+				switch {
+				case opt.Type == OptionTypeBool:
+					fmt.Fprintf(b, "%s%s -l %s -d %s\n", prefix, short, fishQuote(name), desc)
+					fmt.Fprintf(b, "%s -l %s -d %s\n", prefix, fishQuote("skip-"+name), desc)
+					fmt.Fprintf(b, "%s -l %s -d %s\n", prefix, fishQuote("disable-"+name), desc)
+				case opt.completer != nil:
+					fmt.Fprintf(b, "%s%s -l %s -x -a %s -d %s\n", prefix, short, fishQuote(name), fishQuote(cc.fishCompleteCommand()), desc)
+				case len(opt.AllowedValues) > 0:
+					fmt.Fprintf(b, "%s%s -l %s -x -a %s -d %s\n", prefix, short, fishQuote(name), fishQuote(strings.Join(opt.AllowedValues, " ")), desc)
+				case opt.RequireValue:
+					fmt.Fprintf(b, "%s%s -l %s -r -d %s\n", prefix, short, fishQuote(name), desc)
+				default:
+					fmt.Fprintf(b, "%s%s -l %s -d %s\n", prefix, short, fishQuote(name), desc)
+				}
+			}
+		}
+	}
+}
+
+// fishQuote wraps s in single quotes for use in a fish script.
+func fishQuote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	return "'" + strings.Replace(s, "'", `\'`, -1) + "'"
+}
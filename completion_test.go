@@ -0,0 +1,154 @@
+package mybase
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestGenerateCompletion(t *testing.T) {
+	suite := simpleCommandSuite()
+	suite.AddOption(EnumOption("format", 0, "table", []string{"table", "json", "csv"}, "dummy description"))
+	suite.AddOption(StringOption("secret", 0, "", "dummy description").Hidden())
+	suite.SubCommands["one"].AddOption(BoolOption("newbool", 0, false, "dummy description").AddAlias("nb"))
+
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		script, err := suite.SubCommands["one"].GenerateCompletion(shell)
+		if err != nil {
+			t.Fatalf("Unexpected error generating %s completion: %v", shell, err)
+		}
+		for _, expected := range []string{"mycommand", "one", "two", "visible", "skip-newbool", "disable-nb", "json"} {
+			if !strings.Contains(script, expected) {
+				t.Errorf("Expected %s completion script to contain %q, but it does not", shell, expected)
+			}
+		}
+		if strings.Contains(script, "secret") {
+			t.Errorf("Expected %s completion script to omit hidden option, but it does not", shell)
+		}
+	}
+	if _, err := suite.GenerateCompletion("tcsh"); err == nil {
+		t.Error("Expected error for unsupported shell, but err is nil")
+	}
+
+	// If bash is available, confirm the bash completion script actually works
+	bashPath, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not available; skipping execution of completion script")
+	}
+	script, _ := suite.GenerateCompletion("bash")
+	cases := map[string]string{
+		"mycommand ":                  "help one two version --bool1 --skip-bool1 --disable-bool1 -b --bool2 --skip-bool2 --disable-bool2 -B --format --hasshort -s --help -? --truthybool --skip-truthybool --disable-truthybool --version --skip-version --disable-version --visible",
+		"mycommand t":                 "two --",
+		"mycommand one --new":         "--newbool --newopt",
+		"mycommand one --skip-n":      "--skip-newbool --skip-nb",
+		"mycommand --format ":         "table json csv",
+		"mycommand one --format=j":    "--format=json",
+		"mycommand two --format = c":  "csv",
+		"mycommand one --visible ":    "",
+		"mycommand two --truthybool ": "--bool1 --skip-bool1 --disable-bool1 -b --bool2 --skip-bool2 --disable-bool2 -B --format --hasshort -s --help -? --truthybool --skip-truthybool --disable-truthybool --version --skip-version --disable-version --visible",
+	}
+	for line, expected := range cases {
+		words := strings.Split(line, " ")
+		quoted := make([]string, len(words))
+		for n, word := range words {
+			quoted[n] = shellQuote(word)
+		}
+		cmd := exec.Command(bashPath, "-c", script+"\nCOMP_WORDS=("+strings.Join(quoted, " ")+")\nCOMP_CWORD=$((${#COMP_WORDS[@]}-1))\n_mycommand_completion\necho \"${COMPREPLY[*]}\"\n")
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("Unexpected error running bash completion for %q: %v\n%s", line, err, output)
+		}
+		actual := strings.TrimSpace(string(output))
+		if expected == "two --" {
+			if actual != "two" {
+				t.Errorf("Unexpected completion for %q: %q", line, actual)
+			}
+		} else if actual != expected {
+			t.Errorf("Unexpected completion for %q:\n  expected %q\n  found    %q", line, expected, actual)
+		}
+	}
+}
+
+func TestCompletions(t *testing.T) {
+	schemas := func(cfg *Config, toComplete string) []string {
+		if cfg.Get("visible") == "nope" {
+			return nil
+		}
+		return []string{"analytics", "app", "app_archive", cfg.Get("hasshort")}
+	}
+	suite := simpleCommandSuite()
+	suite.AddOption(StringOption("schema", 'S', "", "dummy description").SetCompletion(schemas))
+	suite.AddOption(StringOption("optional-schema", 0, "", "dummy description").ValueOptional().SetCompletion(schemas))
+	suite.SubCommands["two"].args[0].SetCompletion(schemas)
+
+	cases := map[string]string{
+		"two ":                             "analytics app app_archive",
+		"two ap":                           "app app_archive",
+		"two --hasshort=audit a":           "analytics app app_archive audit",
+		"two -s audit au":                  "audit",
+		"two --visible=nope a":             "",
+		"two app ":                         "",
+		"two --bool1 a":                    "analytics app app_archive",
+		"--schema a":                       "analytics app app_archive",
+		"--schema=app":                     "app app_archive",
+		"--schema = app_":                  "app_archive",
+		"--schema =":                       "analytics app app_archive",
+		"-bS an":                           "analytics",
+		"--optional-schema an":             "",
+		"--optional-schema=an":             "analytics",
+		"--skip-schema a":                  "",
+		"--sch":                            "",
+		"--visible ":                       "",
+		"one ":                             "",
+		"t":                                "",
+		"two --bogus a":                    "",
+		"two --hasshort=audit -- --schema": "",
+	}
+	for line, expected := range cases {
+		actual := strings.Join(completions(suite, strings.Split(line, " ")), " ")
+		if actual != expected {
+			t.Errorf("Unexpected completions for %q: expected %q, found %q", line, expected, actual)
+		}
+	}
+
+	// ParseCLI and HandleCommand handle the hidden command without running any
+	// handlers or hooks
+	suite.AddPreRunHook(func(ctx context.Context, cfg *Config) error {
+		return errors.New("hook should not run")
+	})
+	cfg, err := ParseCLI(suite, []string{"mycommand", "__complete", "two", "--help", ""})
+	if err != nil {
+		t.Fatalf("Unexpected error from ParseCLI: %v", err)
+	}
+	defer func(stdout *os.File) {
+		os.Stdout = stdout
+	}(os.Stdout)
+	if os.Stdout, err = os.Open(os.DevNull); err != nil {
+		t.Fatalf("Unable to open %s: %v", os.DevNull, err)
+	}
+	if err := cfg.HandleCommand(); err != nil {
+		t.Errorf("Unexpected error from HandleCommand: %v", err)
+	}
+
+	// Confirm the generated scripts call the hidden command as expected
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		script, _ := suite.GenerateCompletion(shell)
+		if strings.Count(script, "__complete") < 3 {
+			t.Errorf("Expected %s completion script to use __complete for each dynamic option and arg, but it does not", shell)
+		}
+	}
+	bashPath, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not available; skipping execution of completion script")
+	}
+	script, _ := suite.GenerateCompletion("bash")
+	fake := "mycommand() { [[ \"$*\" == \"__complete two --visible = x a\" ]] && printf 'app\\nanalytics\\nbad\\n'; }\n"
+	cmd := exec.Command(bashPath, "-c", fake+script+"\nCOMP_WORDS=(mycommand two --visible = x a)\nCOMP_CWORD=5\n_mycommand_completion\necho \"${COMPREPLY[*]}\"\n")
+	output, err := cmd.CombinedOutput()
+	if actual := strings.TrimSpace(string(output)); err != nil || actual != "app analytics" {
+		t.Errorf("Unexpected result from bash completion: %q, err=%v", actual, err)
+	}
+}
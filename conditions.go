@@ -0,0 +1,167 @@
+package mybase
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// ConditionFunc evaluates the condition of an "!if" or "!elif" directive in an
+// option file, returning true if the lines which follow should be parsed. The
+// supplied expression is the remainder of the directive line, trimmed of
+// whitespace. See File.Conditions.
+type ConditionFunc func(expr string) (bool, error)
+
+// DefaultCondition is the ConditionFunc used by a File whose Conditions field
+// is nil. It supports the following expressions:
+//
+//	os == linux         true if runtime.GOOS is "linux"; "!=" is also permitted
+//	arch == amd64       true if runtime.GOARCH is "amd64"; "!=" is also permitted
+//	env(CI)             true if environment variable CI is set to a non-empty value
+//	env(CI) == value    true if environment variable CI is "value"; "!=" is also permitted
+//
+// Any expression may be negated by prefixing it with "!". Values may
+// optionally be quoted. An error is returned for any other expression.
+func DefaultCondition(expr string) (bool, error) {
+	if strings.HasPrefix(expr, "!") && !strings.HasPrefix(expr, "!=") {
+		result, err := DefaultCondition(strings.TrimSpace(expr[1:]))
+		return !result, err
+	}
+	subject, op, want := expr, "", ""
+	for _, candidate := range []string{"==", "!="} {
+		if pos := strings.Index(expr, candidate); pos > -1 {
+			subject, op, want = strings.TrimSpace(expr[:pos]), candidate, unquote(strings.TrimSpace(expr[pos+2:]))
+			break
+		}
+	}
+
+	var actual string
+	switch {
+	case subject == "os":
+		actual = runtime.GOOS
+	case subject == "arch":
+		actual = runtime.GOARCH
+	case strings.HasPrefix(subject, "env(") && strings.HasSuffix(subject, ")"):
+		name := strings.TrimSpace(subject[4 : len(subject)-1])
+		if name == "" {
+			return false, errors.New("env() requires a variable name")
+		}
+		actual = os.Getenv(name)
+		if op == "" {
+			return actual != "", nil
+		}
+	case subject == "":
+		return false, errors.New("missing condition")
+	default:
+		return false, fmt.Errorf("unknown condition subject %q", subject)
+	}
+	if op == "" {
+		return false, fmt.Errorf("condition on %s requires a comparison such as \"%s == value\"", subject, subject)
+	}
+	return (actual == want) == (op == "=="), nil
+}
+
+// conditions returns the ConditionFunc used to evaluate f's conditional
+// directives.
+func (f *File) conditions() ConditionFunc {
+	if f.Conditions != nil {
+		return f.Conditions
+	}
+	return DefaultCondition
+}
+
+// isConditionalDirective returns true if the directive name is one which
+// begins, continues, or ends a conditional block.
+func isConditionalDirective(name string) bool {
+	switch name {
+	case "if", "elif", "else", "endif":
+		return true
+	}
+	return false
+}
+
+// conditionBlock tracks the state of one "!if" ... "!endif" block.
+type conditionBlock struct {
+	active     bool // true if lines in the current branch are parsed
+	taken      bool // true if any branch so far has been active
+	outer      bool // true if the enclosing block, if any, is active
+	seenElse   bool // true once the "!else" branch has begun
+	lineNumber int  // line number of the "!if" directive
+}
+
+// conditionStack tracks nested conditional blocks while scanning the lines of
+// one file. The zero value is ready to use.
+type conditionStack []conditionBlock
+
+// active returns true if lines at the current position should be parsed.
+func (s conditionStack) active() bool {
+	return len(s) == 0 || s[len(s)-1].active
+}
+
+// apply updates the stack for a conditional directive. Conditions are only
+// evaluated when they could affect which lines are parsed, so that a condition
+// nested within an inactive branch cannot cause an error.
+func (s *conditionStack) apply(directive, expr string, eval ConditionFunc, lineNumber int) (err error) {
+	evaluate := func() bool {
+		if expr == "" {
+			err = fmt.Errorf("!%s directive missing condition", directive)
+			return false
+		}
+		var result bool
+		if result, err = eval(expr); err != nil {
+			err = fmt.Errorf("invalid condition in !%s directive: %s", directive, err)
+		}
+		return result
+	}
+
+	if directive == "if" {
+		block := conditionBlock{outer: s.active(), lineNumber: lineNumber}
+		if block.outer {
+			block.active = evaluate()
+			block.taken = block.active
+		}
+		*s = append(*s, block)
+		return err
+	}
+	if len(*s) == 0 {
+		return fmt.Errorf("!%s directive without preceding !if", directive)
+	}
+	block := &(*s)[len(*s)-1]
+	switch directive {
+	case "elif":
+		if block.seenElse {
+			return errors.New("!elif directive after !else")
+		}
+		block.active = false
+		if block.outer && !block.taken {
+			block.active = evaluate()
+			block.taken = block.active
+		}
+	case "else":
+		if block.seenElse {
+			return errors.New("duplicate !else directive")
+		} else if expr != "" {
+			return errors.New("extra characters after !else directive")
+		}
+		block.seenElse = true
+		block.active = block.outer && !block.taken
+		block.taken = true
+	case "endif":
+		if expr != "" {
+			return errors.New("extra characters after !endif directive")
+		}
+		*s = (*s)[:len(*s)-1]
+	}
+	return err
+}
+
+// unterminated returns an error if any conditional block lacks its "!endif"
+// directive, along with the line number of the block's "!if" directive.
+func (s conditionStack) unterminated() (int, error) {
+	if len(s) == 0 {
+		return 0, nil
+	}
+	return s[len(s)-1].lineNumber, errors.New("!if directive without matching !endif")
+}
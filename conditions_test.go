@@ -0,0 +1,170 @@
+package mybase
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestDefaultCondition(t *testing.T) {
+	defer os.Setenv("MYBASE_TEST_COND", os.Getenv("MYBASE_TEST_COND"))
+	os.Setenv("MYBASE_TEST_COND", "yes")
+	defer os.Setenv("MYBASE_TEST_UNSET", os.Getenv("MYBASE_TEST_UNSET"))
+	os.Unsetenv("MYBASE_TEST_UNSET")
+
+	cases := map[string]bool{
+		"os == " + runtime.GOOS:            true,
+		"os != " + runtime.GOOS:            false,
+		"os=='" + runtime.GOOS + "'":       true,
+		"arch == " + runtime.GOARCH:        true,
+		"arch == nonexistent":              false,
+		"env(MYBASE_TEST_COND)":            true,
+		"env(MYBASE_TEST_UNSET)":           false,
+		"!env(MYBASE_TEST_UNSET)":          true,
+		"env(MYBASE_TEST_COND) == yes":     true,
+		"env(MYBASE_TEST_COND) != \"yes\"": false,
+		"! os == " + runtime.GOOS:          false,
+	}
+	for expr, expected := range cases {
+		if actual, err := DefaultCondition(expr); err != nil || actual != expected {
+			t.Errorf("Unexpected result from DefaultCondition(%q): %t %v", expr, actual, err)
+		}
+	}
+	for _, expr := range []string{"", "os", "env() == x", "hostname == foo", "!"} {
+		if _, err := DefaultCondition(expr); err == nil {
+			t.Errorf("Expected DefaultCondition(%q) to return an error, but it did not", expr)
+		}
+	}
+}
+
+func TestParseConditionals(t *testing.T) {
+	cmd := NewCommand("test", "1.0", "this is for testing", nil)
+	cmd.AddOption(StringOption("host", 0, "", ""))
+	cmd.AddOption(StringOption("port", 0, "", ""))
+	cfg := NewConfig(&CommandLine{Command: cmd})
+	conditions := func(expr string) (bool, error) {
+		switch expr {
+		case "yes":
+			return true, nil
+		case "no":
+			return false, nil
+		}
+		return false, errors.New("unsupported")
+	}
+
+	contents := `port=1
+!if no
+port=2
+[skipped]
+host=skipped
+!elif yes
+port=3
+!if no
+host=a
+!else
+host=b
+!endif
+!elif yes
+port=4
+!else
+port=5
+!endif
+[server]
+!if no
+!if bogus
+host=c
+!endif
+!else
+host=d
+!endif
+`
+	parse := func(contents string) (*File, error) {
+		f := NewFile("/tmp/fake.cnf")
+		f.Conditions = conditions
+		f.contents, f.read = contents, true
+		return f, f.Parse(cfg)
+	}
+	f, err := parse(contents)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing file: %v", err)
+	}
+	assertFileValue := func(section, name, expected string) {
+		t.Helper()
+		if actual := f.SectionValues(section)[name]; actual != expected {
+			t.Errorf("Expected [%s] %s=%q, instead found %q", section, name, expected, actual)
+		}
+	}
+	assertFileValue("", "port", "3")
+	assertFileValue("", "host", "b")
+	assertFileValue("server", "host", "d")
+	if f.HasSection("skipped") {
+		t.Error("Expected section header in inactive branch to be ignored")
+	}
+
+	assertParseError := func(contents string, expectLine int, expectSubstring string) {
+		t.Helper()
+		_, err := parse(contents)
+		if fpf, ok := err.(FileParseFormatError); !ok {
+			t.Errorf("Expected FileParseFormatError, instead found %T %v", err, err)
+		} else if fpf.LineNumber != expectLine || !strings.Contains(fpf.Problem, expectSubstring) {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+	assertParseError("port=1\n!if yes\nport=2\n", 2, "without matching !endif")
+	assertParseError("port=1\n!endif\n", 2, "without preceding !if")
+	assertParseError("!if yes\n!else\n!elif no\n!endif\n", 3, "after !else")
+	assertParseError("!if yes\n!else\n!else\n!endif\n", 3, "duplicate !else")
+	assertParseError("!if\n!endif\n", 1, "missing condition")
+	assertParseError("!if bogus\n!endif\n", 1, "unsupported")
+
+	// Lint skips inactive branches, but reports malformed blocks. The active
+	// value on line 7 overrides line 1, but values on lines 3, 14, and 16 do not.
+	f.contents, f.read = contents+"!if yes\n", true
+	problems, err := f.Lint(cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error from Lint: %v", err)
+	}
+	if len(problems) != 2 || problems[0].LineNumber != 7 || problems[1].LineNumber != 26 || !strings.Contains(problems[1].Message, "!endif") {
+		t.Errorf("Unexpected problems from Lint: %+v", problems)
+	}
+
+	// Format retains the position of conditional directives
+	f.contents = "port=1\n!if yes\n!inherit x\nport=2\n!else\nport=3\n!endif\n"
+	var buf bytes.Buffer
+	if err := f.Format(&buf, FormatStyle{SortOptions: true}); err != nil {
+		t.Fatalf("Unexpected error from Format: %v", err)
+	} else if buf.String() != f.contents {
+		t.Errorf("Unexpected output from Format: %q", buf.String())
+	}
+
+	// Write with PreserveFormatting only modifies lines in active branches
+	dir, err := ioutil.TempDir("", "mybasetest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.cnf")
+	contents = "!if no\nport=1\n!else\nport=2\n!endif\n"
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("Unable to write file: %v", err)
+	}
+	f = NewFile(path)
+	f.Conditions = conditions
+	f.PreserveFormatting = true
+	if err := f.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error from Parse: %v", err)
+	}
+	f.SetOptionValue("", "port", "3")
+	if err := f.Write(true); err != nil {
+		t.Fatalf("Unexpected error from Write: %v", err)
+	}
+	expected := "!if no\nport=1\n!else\nport=3\n!endif\n"
+	if actual, _ := ioutil.ReadFile(path); string(actual) != expected {
+		t.Errorf("Unexpected file contents after Write: %q", actual)
+	}
+}
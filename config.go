@@ -1,10 +1,18 @@
 package mybase
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 	"unicode/utf8"
 )
 
@@ -13,21 +21,106 @@ import (
 // to the given optionName, it should return the value along with a true value
 // for ok. If the struct does not have a value for the given optionName, it
 // should return "", false.
+//
+// An OptionValuer only takes effect once added to a Config via NewConfig or
+// Config.AddSource. Its position in the list of sources determines its
+// precedence: later sources override earlier ones.
 type OptionValuer interface {
 	OptionValue(optionName string) (value string, ok bool)
 }
 
+// editTracker is implemented by OptionValuers which can be modified after
+// being added to a Config, such as File. The returned generation must change
+// whenever the values supplied by the OptionValuer change, allowing a Config
+// to detect that its caches are stale.
+type editTracker interface {
+	editGeneration() uint64
+}
+
+// ExpiringOptionValuer may be implemented by OptionValuers whose values are
+// only valid for a limited time, such as dynamically-generated database
+// credentials which are rotated periodically. After a Config obtains an
+// option's value from such a source, it calls OptionValueTTL with the option's
+// name; if the returned duration is positive, the Config re-queries all of its
+// sources once that duration has elapsed, upon the next lookup of any option.
+// A duration of zero or less indicates the value does not expire. The source
+// is responsible for returning a fresh value from OptionValue once the
+// previous one has expired.
+type ExpiringOptionValuer interface {
+	OptionValuer
+	OptionValueTTL(optionName string) time.Duration
+}
+
+// VersionedOptionValuer may be implemented by OptionValuers whose values can
+// change after being added to a Config, such as sources which periodically
+// re-fetch values from a remote service. OptionValuesVersion must return a
+// different number whenever the values supplied by the source change, so that
+// a Config can detect that its caches are stale upon the next lookup.
+type VersionedOptionValuer interface {
+	OptionValuer
+	OptionValuesVersion() uint64
+}
+
+// versionTracker adapts a VersionedOptionValuer to the editTracker interface.
+type versionTracker struct {
+	VersionedOptionValuer
+}
+
+func (vt versionTracker) editGeneration() uint64 {
+	return vt.OptionValuesVersion()
+}
+
 // Config represents a list of sources for option values -- the command-line
 // plus zero or more option files, or any other source implementing the
 // OptionValuer interface.
+//
+// Config is safe for concurrent use by multiple goroutines: option lookups may
+// be interleaved with calls to AddSource or MarkDirty, as well as calls which
+// modify File sources, such as File.SetOptionValue or File.UseSection; the
+// Config automatically notices such modifications. However, the exported
+// fields should not be modified once the Config is shared between goroutines.
 type Config struct {
-	CLI              *CommandLine            // Parsed command-line
-	IsTest           bool                    // true if Config generated from test logic, false otherwise
-	LooseFileOptions bool                    // enable to ignore unknown options in all Files
-	sources          []OptionValuer          // Sources of option values, excluding CLI or Command; higher indexes override lower indexes
-	unifiedValues    map[string]string       // Precomputed cache of option name => value
-	unifiedSources   map[string]OptionValuer // Precomputed cache of option name => which source supplied it
-	dirty            bool                    // true if source list has changed, meaning next access needs to recompute caches
+	CLI                 *CommandLine            // Parsed command-line
+	IsTest              bool                    // true if Config generated from test logic, false otherwise
+	LooseFileOptions    bool                    // enable to ignore unknown options in all Files
+	DeferUnknownOptions bool                    // enable to defer errors for unknown options in all Files until ReevaluateUnknowns is called
+	ExpandVariables     bool                    // enable to expand ${name} and ${ENV:NAME} references in option values; see Get
+	LenientFiles        bool                    // enable to continue parsing all Files past problems with their contents; see File.Problems
+	PromptInput         io.Reader               // source of user input for Confirm, PromptValue, and PromptMissing; os.Stdin if nil
+	PromptOutput        io.Writer               // destination for prompt text from Confirm, PromptValue, and PromptMissing; os.Stdout if nil
+	WarningHandler      func(message string)    // receives non-fatal warnings, such as use of a stale cached source; uses the package Logger if nil
+	mu                  sync.RWMutex            // protects all unexported fields below
+	sources             []OptionValuer          // Sources of option values, excluding CLI or Command; higher indexes override lower indexes
+	unifiedValues       map[string]string       // Precomputed cache of option name => value
+	unifiedSources      map[string]OptionValuer // Precomputed cache of option name => which source supplied it
+	unifiedAliases      map[string]string       // Precomputed cache of option alias => canonical option name
+	unifiedTransformed  map[string]string       // Precomputed cache of option name => value after transform, for options with a transform
+	transformErrors     map[string]error        // Errors from option transforms, keyed by option name
+	validationErrors    map[string]error        // Errors from option validators, keyed by option name
+	dirty               bool                    // true if source list has changed, meaning next access needs to recompute caches
+	generations         []trackedGeneration     // generation of each editTracker source as of the last rebuild
+	expiresAt           time.Time               // earliest expiration of a value from an ExpiringOptionValuer as of the last rebuild; zero value if none
+	lazyOptions         map[string]*Option      // Precomputed cache of option name => Option, for options with a DefaultFunc
+	confirmOption       string                  // name of bool option which causes Confirm to automatically answer yes
+	watchers            map[string][]watchFunc  // Callbacks registered via Watch, keyed by option name
+	deprecationWarned   map[string]bool         // Deprecated option warnings already reported, keyed by option name and source
+	pendingWarnings     []string                // Warnings generated while rebuilding caches, to be reported once the lock is released
+	pendingHooks        []pendingHook           // Calls to Option hooks queued while rebuilding caches, to be made once the lock is released
+	hookStates          map[string]hookState    // Value and source of each option with hooks, as of the last run of its hooks
+	prompted            promptAnswers           // Values obtained by PromptMissing, which override all sources except overrides
+	overrides           overrideSource          // Values supplied to CloneWithOverrides, which override all other sources
+	lazyDefaults        map[string]string       // Results of Option.SetDefaultFunc functions, keyed by option name; cleared by rebuild
+	commands            commandCache            // Results of commands run for Option.AllowCommandSubstitution
+	unresolved          map[string]*Option      // Commands needed by the last rebuild which had not completed, with an option supplying each; see resolveCommands
+	unresolvedOptions   map[string]bool         // Names of options whose values depend on commands in unresolved
+	protected           []OptionValuer          // Sources whose values for Immutable options cannot be overridden; see ProtectSource
+	tracer              *tracer                 // Records calls to Get, if enabled via StartTrace
+	templates           templateCache           // Templates parsed by GetTemplate, keyed by option name
+	reloadCallbacks     []func(err error)       // Callbacks registered via OnReload
+	noHooks             bool                    // true for a temporary copy used by ReloadValidated, which must not run Option hooks
+	sealed              bool                    // true once Seal has been called, preventing further changes
+	lookupCount         uint32                  // number of calls to lookup, for telemetry; accessed atomically
+	rebuildCount        uint32                  // number of calls to rebuild, for telemetry; accessed atomically
 }
 
 // NewConfig creates a Config object, given a CommandLine and any arbitrary
@@ -48,34 +141,228 @@ func NewConfig(cli *CommandLine, sources ...OptionValuer) *Config {
 // slice, meaning that a caller can add sources without impacting the original
 // Config's source list.
 func (cfg *Config) Clone() *Config {
+	cfg.mu.RLock()
+	defer cfg.mu.RUnlock()
 	sourcesCopy := make([]OptionValuer, len(cfg.sources))
 	copy(sourcesCopy, cfg.sources)
+	var promptedCopy promptAnswers
+	if cfg.prompted != nil {
+		promptedCopy = make(promptAnswers, len(cfg.prompted))
+		for name, value := range cfg.prompted {
+			promptedCopy[name] = value
+		}
+	}
 	return &Config{
-		CLI:              cfg.CLI,
-		IsTest:           cfg.IsTest,
-		LooseFileOptions: cfg.LooseFileOptions,
-		sources:          sourcesCopy,
-		dirty:            true,
+		CLI:                 cfg.CLI,
+		IsTest:              cfg.IsTest,
+		LooseFileOptions:    cfg.LooseFileOptions,
+		DeferUnknownOptions: cfg.DeferUnknownOptions,
+		ExpandVariables:     cfg.ExpandVariables,
+		LenientFiles:        cfg.LenientFiles,
+		PromptInput:         cfg.PromptInput,
+		PromptOutput:        cfg.PromptOutput,
+		WarningHandler:      cfg.WarningHandler,
+		sources:             sourcesCopy,
+		dirty:               true,
+		confirmOption:       cfg.confirmOption,
+		prompted:            promptedCopy,
+		overrides:           cfg.overrides, // never mutated, so safe to share
+		protected:           append([]OptionValuer(nil), cfg.protected...),
 	}
 }
 
+// CloneWithOverrides returns a copy of cfg in the same manner as Clone, with
+// the supplied option values taking precedence over all sources, including the
+// command-line. This permits a single parsed Config to be fanned out into
+// independent per-target Configs, for example one per database instance,
+// without mutating shared state. Override values are interpreted the same way
+// as values in an option file, so they may be quote-wrapped. Any overrides
+// already present in cfg are retained unless replaced. Override keys may be
+// option names or aliases; panics if any key is not a known option, since this
+// is indicative of programmer error.
+func (cfg *Config) CloneWithOverrides(overrides map[string]string) *Config {
+	clone := cfg.Clone()
+	merged := make(overrideSource, len(clone.overrides)+len(overrides))
+	for name, value := range clone.overrides {
+		merged[name] = value
+	}
+	for name, value := range overrides {
+		opt := cfg.FindOption(name)
+		if opt == nil {
+			panic(fmt.Errorf("Assertion failed: CloneWithOverrides called with unknown option %s", name))
+		}
+		merged[opt.Name] = value
+	}
+	clone.overrides = merged
+	return clone
+}
+
+// overrideSource is an OptionValuer storing values that were supplied to
+// Config.CloneWithOverrides. Once created, it is never modified.
+type overrideSource map[string]string
+
+func (src overrideSource) OptionValue(optionName string) (string, bool) {
+	value, ok := src[optionName]
+	return value, ok
+}
+
+func (src overrideSource) String() string {
+	return "override"
+}
+
 // AddSource adds a new OptionValuer to cfg. It will override previously-added
 // sources, with the exception of the CommandLine, which always takes
 // precedence.
 func (cfg *Config) AddSource(source OptionValuer) {
+	cfg.assertUnsealed("AddSource")
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
 	cfg.sources = append(cfg.sources, source)
 	cfg.dirty = true
 }
 
+// InsertSourceBefore adds a new OptionValuer to cfg, with lower precedence than
+// existing, but higher precedence than any source which existing overrides.
+// This permits a source to be positioned anywhere in cfg's precedence order,
+// rather than only above all previously-added sources as with AddSource. If
+// existing is cfg.CLI, this is equivalent to AddSource. Panics if existing is
+// not a source of cfg, since this is indicative of programmer error.
+func (cfg *Config) InsertSourceBefore(existing, source OptionValuer) {
+	cfg.assertUnsealed("InsertSourceBefore")
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	if sameSource(existing, cfg.CLI) {
+		cfg.insertSourceAt(len(cfg.sources), source)
+		return
+	}
+	cfg.insertSourceAt(cfg.sourceIndex(existing), source)
+}
+
+// InsertSourceAfter adds a new OptionValuer to cfg, with higher precedence
+// than existing, but lower precedence than any source which overrides
+// existing. If existing is cfg.CLI.Command, which supplies default values, the
+// new source has lower precedence than all other sources. Panics if existing is
+// cfg.CLI, since the CommandLine always takes precedence, or if existing is not
+// a source of cfg, since these are indicative of programmer error.
+func (cfg *Config) InsertSourceAfter(existing, source OptionValuer) {
+	cfg.assertUnsealed("InsertSourceAfter")
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	if sameSource(existing, cfg.CLI.Command) {
+		cfg.insertSourceAt(0, source)
+		return
+	}
+	cfg.insertSourceAt(cfg.sourceIndex(existing)+1, source)
+}
+
+// Sources returns the OptionValuers which have been added to cfg, ordered from
+// lowest to highest precedence. The Command and CommandLine are not included.
+func (cfg *Config) Sources() []OptionValuer {
+	cfg.mu.RLock()
+	defer cfg.mu.RUnlock()
+	return append([]OptionValuer{}, cfg.sources...)
+}
+
+// insertSourceAt inserts source into cfg.sources at position n. The caller
+// must hold a write lock on cfg.mu.
+func (cfg *Config) insertSourceAt(n int, source OptionValuer) {
+	cfg.sources = append(cfg.sources, nil)
+	copy(cfg.sources[n+1:], cfg.sources[n:])
+	cfg.sources[n] = source
+	cfg.dirty = true
+}
+
+// sourceIndex returns the position of existing in cfg.sources. Panics if it is
+// not present. The caller must hold a lock on cfg.mu.
+func (cfg *Config) sourceIndex(existing OptionValuer) int {
+	for n, source := range cfg.sources {
+		if sameSource(source, existing) {
+			return n
+		}
+	}
+	panic(fmt.Errorf("Assertion failed: %v is not a source of this Config", existing))
+}
+
+// sameSource returns true if a and b are the same OptionValuer. Unlike the ==
+// operator, this does not panic for sources of non-comparable types, such as
+// SimpleSource; these are considered the same if they refer to the same
+// underlying map.
+func sameSource(a, b OptionValuer) bool {
+	typ := reflect.TypeOf(a)
+	if typ != reflect.TypeOf(b) {
+		return false
+	} else if typ == nil || typ.Comparable() {
+		return a == b
+	}
+	switch typ.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Func:
+		return reflect.ValueOf(a).Pointer() == reflect.ValueOf(b).Pointer()
+	}
+	return false
+}
+
+// ReevaluateUnknowns should be called after options are added to the command
+// tree after cfg was created, for example via Command.RegisterOptions. Any
+// lines of cfg's File sources that previously referred to unknown options are
+// re-evaluated, so that values for newly-registered options become available.
+// The caches of cfg are also marked dirty.
+//
+// If cfg.DeferUnknownOptions was enabled when Files were parsed, an error is
+// returned for any option file lines that still refer to unknown options. If
+// there are multiple such errors, they are returned as ParseErrors. Lines with
+// a "loose-" prefix, or lines that were ignorable via File.IgnoreUnknownOptions
+// or cfg.LooseFileOptions, never result in errors.
+func (cfg *Config) ReevaluateUnknowns() error {
+	cfg.mu.RLock()
+	sources := make([]OptionValuer, len(cfg.sources))
+	copy(sources, cfg.sources)
+	cfg.mu.RUnlock()
+
+	var problems ParseErrors
+	for _, source := range sources {
+		if f, ok := source.(*File); ok {
+			problems = append(problems, f.reevaluateUnknowns(cfg)...)
+		}
+	}
+	cfg.MarkDirty()
+	if len(problems) == 1 {
+		return problems[0]
+	} else if len(problems) > 1 {
+		return problems
+	}
+	return nil
+}
+
+// Warn reports a non-fatal problem to cfg.WarningHandler, or to the package's
+// Logger (see SetLogger) if no handler has been set. The message is formatted
+// in the manner of fmt.Sprintf.
+func (cfg *Config) Warn(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	if cfg.WarningHandler != nil {
+		cfg.WarningHandler(message)
+	} else {
+		currentLogger().Warn(message)
+	}
+}
+
 // HandleCommand executes the CommandHandler callback associated with the
-// Command that was parsed on the CommandLine.
+// Command that was parsed on the CommandLine, along with any hooks added via
+// AddPreRunHook or AddPostRunHook to that Command or its ancestors.
 func (cfg *Config) HandleCommand() error {
+	return cfg.HandleCommandContext(context.Background())
+}
+
+// HandleCommandContext is like HandleCommand, but supplies ctx to the Command's
+// handler if it was set via SetContextHandler. Handlers with the older
+// CommandHandler signature do not receive ctx.
+func (cfg *Config) HandleCommandContext(ctx context.Context) error {
 	// Handle --help if supplied as an option instead of as a subcommand
 	// (Note that format "command help [<subcommand>]" is already parsed properly into help command)
-	if forCommandName, helpWanted := cfg.CLI.OptionValues["help"]; helpWanted {
+	if forCommandName, helpWanted := cfg.CLI.OptionValues["help"]; helpWanted || cfg.CLI.OptionValues["help-all"] == "1" {
 		// command --help displays help for command
 		// vs
 		// command --help <subcommand> displays help for subcommand
+		// command --help-all displays help for command, including hidden options
 		cfg.CLI.ArgValues = []string{forCommandName}
 		return helpHandler(cfg)
 	}
@@ -85,36 +372,110 @@ func (cfg *Config) HandleCommand() error {
 		return versionHandler(cfg)
 	}
 
-	return cfg.CLI.Command.Handler(cfg)
+	// Handle --generate-config or --print-defaults, if the command has them
+	if cfg.CLI.OptionValues["generate-config"] == "1" {
+		return configTemplateHandler(cfg)
+	} else if cfg.CLI.OptionValues["print-defaults"] == "1" {
+		return printDefaultsHandler(cfg)
+	}
+
+	// Handle requests for dynamic completions from shell completion scripts
+	if cfg.CLI.Command.isBuiltin() && cfg.CLI.Command.Name == completeCommandName {
+		return completeHandler(cfg)
+	}
+
+	if err := cfg.PromptMissing(); err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	return cfg.CLI.Command.runHandler(ctx, cfg)
 }
 
 // rebuild iterates over all sources, to construct a single cached key-value
 // lookup map. This improves performance of subsequent option value lookups.
+// The caller must hold a write lock on cfg.mu.
 func (cfg *Config) rebuild() {
-	allSources := make([]OptionValuer, 1, len(cfg.sources)+2)
-
-	// Lowest-priority source is the current command, which returns default values
-	// for any valid option
-	allSources[0] = cfg.CLI.Command
-
-	// Next come cfg.sources, which are already ordered from lowest priority to highest priority
-	allSources = append(allSources, cfg.sources...)
-
-	// Finally, at highest priority is options provided on the command-line
-	allSources = append(allSources, cfg.CLI)
+	atomic.AddUint32(&cfg.rebuildCount, 1)
+	allSources := cfg.orderedSources()
+	currentLogger().Debug("Resolving option values", "command", cfg.CLI.Command.fullName(), "sources", len(allSources))
+	cfg.lazyDefaults = nil
+	cfg.expiresAt = time.Time{}
+	cfg.generations = cfg.generations[:0]
+	for _, source := range allSources {
+		tracker, ok := source.(editTracker)
+		if versioned, isVersioned := source.(VersionedOptionValuer); !ok && isVersioned {
+			tracker, ok = versionTracker{versioned}, true
+		}
+		if ok {
+			cfg.generations = append(cfg.generations, trackedGeneration{tracker: tracker, generation: tracker.editGeneration()})
+		}
+	}
 
 	options := cfg.CLI.Command.Options()
+	cfg.lazyOptions = make(map[string]*Option)
+	for name, opt := range options {
+		if _, _, ok := cfg.CLI.Command.commandDefault(opt); opt.defaultFunc != nil && !ok {
+			cfg.lazyOptions[name] = opt
+		}
+	}
 	cfg.unifiedValues = make(map[string]string, len(options)+len(cfg.CLI.Command.args))
 	cfg.unifiedSources = make(map[string]OptionValuer, len(options)+len(cfg.CLI.Command.args))
+	cfg.unifiedAliases = make(map[string]string)
+	cfg.unifiedTransformed = make(map[string]string)
+	cfg.transformErrors = make(map[string]error)
+	cfg.unresolved = make(map[string]*Option)
+	cfg.unresolvedOptions = make(map[string]bool)
+	cfg.validationErrors = make(map[string]error)
+	for alias, opt := range optionAliasIndex(options) {
+		cfg.unifiedAliases[alias] = opt.Name
+	}
+
+	// Deprecated options may supply values for their replacements. Warnings for
+	// them are generated in a deterministic order, and only once per source.
+	replacedBy := make(map[string][]*Option)
+	var deprecatedNames []string
+	for name, opt := range options {
+		if opt.Deprecation != "" {
+			deprecatedNames = append(deprecatedNames, name)
+		}
+	}
+	sort.Strings(deprecatedNames)
+	for _, name := range deprecatedNames {
+		opt := options[name]
+		if opt.Replacement != "" {
+			replacement := opt.Replacement
+			if canonical, isAlias := cfg.unifiedAliases[replacement]; isAlias {
+				replacement = canonical
+			}
+			replacedBy[replacement] = append(replacedBy[replacement], opt)
+		}
+		for _, source := range allSources[1:] {
+			if _, ok := optionValueOrAlias(source, opt); ok {
+				cfg.warnDeprecated(opt, source)
+			}
+		}
+	}
 
 	// Iterate over positional CLI args. These have highest precedence of all, and
 	// are treated as a special-case (not placed in sources and work differently
 	// than normal options, since they cannot appear in option files)
 	for pos, arg := range cfg.CLI.Command.args {
 		if pos < len(cfg.CLI.ArgValues) { // supplied on CLI
+			values := cfg.CLI.ArgValues[pos : pos+1]
+			if arg.variadic {
+				values = cfg.CLI.ArgValues[pos:]
+			}
 			cfg.unifiedSources[arg.Name] = cfg.CLI
-			cfg.unifiedValues[arg.Name] = cfg.CLI.ArgValues[pos]
+			cfg.unifiedValues[arg.Name] = joinArgValues(values, arg.variadic)
 			delete(options, arg.Name) // shadow any normal option that has same name
+			for _, value := range values {
+				if err := applyValidator(arg, unquote(value), cfg.CLI); err != nil {
+					cfg.validationErrors[arg.Name] = err
+					break
+				}
+			}
 		} else { // not supplied on CLI - using default value
 			// In this case we intentionally DON'T shadow any normal option with same
 			// name, since a supplied option should override an unsupplied arg default.
@@ -125,36 +486,273 @@ func (cfg *Config) rebuild() {
 
 	// Iterate over all options, and set them in our maps for tracking values and sources.
 	// We go in reverse order to start at highest priority and break early when a value is found.
-	for name := range options {
+	immutableErrors := make(map[string]error)
+	for name, opt := range options {
 		var found bool
 		for n := len(allSources) - 1; n >= 0 && !found; n-- {
 			source := allSources[n]
-			if value, ok := source.OptionValue(name); ok {
+			value, ok := optionValueOrAlias(source, opt)
+			for _, deprecated := range replacedBy[name] {
+				if ok || n == 0 {
+					break
+				}
+				value, ok = optionValueOrAlias(source, deprecated)
+			}
+			if ok {
 				cfg.unifiedValues[name] = value
 				cfg.unifiedSources[name] = source
 				found = true
+				if expiring, ok := source.(ExpiringOptionValuer); ok {
+					if ttl := expiring.OptionValueTTL(name); ttl > 0 {
+						if expiresAt := time.Now().Add(ttl); cfg.expiresAt.IsZero() || expiresAt.Before(cfg.expiresAt) {
+							cfg.expiresAt = expiresAt
+						}
+					}
+				}
 			}
 		}
 		if !found {
 			// If not even the Command provides a value, something is horribly wrong.
 			panic(fmt.Errorf("Assertion failed: Iterated over option %s not provided by command %s", name, cfg.CLI.Command.Name))
 		}
+		if opt.immutable && len(cfg.protected) > 0 {
+			if err := cfg.enforceImmutable(opt, allSources); err != nil {
+				immutableErrors[name] = err
+			}
+		}
+	}
+
+	// Now that all values are known, expand variable references if enabled, and
+	// then apply transforms and validators.
+	expanded := make(map[string]string)
+	for name, opt := range options {
+		value, source := cfg.unifiedValues[name], cfg.unifiedSources[name]
+		var wasExpanded bool
+		if command, ok := substitutionCommand(value); ok && opt.cmdTimeout > 0 {
+			if !substitutionPermitted(source) {
+				sourceName, filePath := describeSource(source)
+				cfg.transformErrors[name] = OptionTransformError{
+					Name:     name,
+					Value:    opt.displayValue(value),
+					Source:   sourceName,
+					FilePath: filePath,
+					Err:      errors.New("command substitution is not permitted for values from this source"),
+				}
+				continue
+			}
+			result, completed := cfg.commands.result(command)
+			if !completed {
+				// The command must run without holding the lock; see resolveCommands
+				cfg.unresolved[command] = opt
+				cfg.unresolvedOptions[name] = true
+				continue
+			} else if err := result.err; err != nil {
+				sourceName, filePath := describeSource(source)
+				cfg.transformErrors[name] = OptionTransformError{
+					Name:     name,
+					Value:    opt.displayValue(value),
+					Source:   sourceName,
+					FilePath: filePath,
+					Err:      err,
+				}
+				continue
+			}
+			value, wasExpanded = QuoteValue(result.output), true
+		} else if cfg.ExpandVariables && strings.Contains(value, "${") {
+			expandedValue, err := cfg.expandedValue(name, nil, expanded)
+			if err != nil {
+				sourceName, filePath := describeSource(source)
+				cfg.transformErrors[name] = OptionTransformError{
+					Name:     name,
+					Value:    opt.displayValue(unquote(value)),
+					Source:   sourceName,
+					FilePath: filePath,
+					Err:      err,
+				}
+				continue
+			}
+			value, wasExpanded = QuoteValue(expandedValue), true
+		}
+		transformed, ok, err := applyTransform(opt, value, source)
+		if err != nil {
+			cfg.transformErrors[name] = err
+			continue
+		}
+		if !ok {
+			transformed = unquote(value)
+		}
+		if ok || wasExpanded {
+			cfg.unifiedTransformed[name] = transformed
+		}
+		if err := applyValidator(opt, transformed, source); err != nil {
+			cfg.validationErrors[name] = err
+		}
+	}
+	for name, err := range immutableErrors {
+		cfg.validationErrors[name] = err
 	}
+	cfg.queueHooks(options)
 
 	cfg.dirty = false
 }
 
-func (cfg *Config) rebuildIfDirty() {
-	if cfg.dirty {
-		cfg.rebuild()
+// orderedSources returns every source of option values, ordered from lowest
+// to highest priority, including the Command and CommandLine. The caller must
+// hold a lock on cfg.mu.
+func (cfg *Config) orderedSources() []OptionValuer {
+	allSources := make([]OptionValuer, 1, len(cfg.sources)+4)
+
+	// Lowest-priority source is the current command, which returns default values
+	// for any valid option
+	allSources[0] = cfg.CLI.Command
+
+	// Next come cfg.sources, which are already ordered from lowest priority to highest priority
+	allSources = append(allSources, cfg.sources...)
+
+	// Next is options provided on the command-line
+	allSources = append(allSources, cfg.CLI)
+
+	// Next are values obtained by prompting. These only exist for options which
+	// were otherwise missing, or supplied on the command-line without a value.
+	if len(cfg.prompted) > 0 {
+		allSources = append(allSources, cfg.prompted)
+	}
+
+	// Finally, at highest priority are values from CloneWithOverrides
+	if len(cfg.overrides) > 0 {
+		allSources = append(allSources, cfg.overrides)
+	}
+	return allSources
+}
+
+// warnDeprecated queues a warning about use of a deprecated option in source,
+// unless one was already generated. The caller must hold a write lock on
+// cfg.mu.
+func (cfg *Config) warnDeprecated(opt *Option, source OptionValuer) {
+	sourceName, _ := describeSource(source)
+	key := opt.Name + "\x00" + sourceName
+	if cfg.deprecationWarned[key] {
+		return
+	}
+	if cfg.deprecationWarned == nil {
+		cfg.deprecationWarned = make(map[string]bool)
+	}
+	cfg.deprecationWarned[key] = true
+	cfg.pendingWarnings = append(cfg.pendingWarnings, fmt.Sprintf("%s: Option %s is deprecated: %s", sourceName, opt.Name, opt.Deprecation))
+}
+
+// flushPending reports any warnings generated while rebuilding the caches, and
+// then runs any Option hooks queued while rebuilding. The caller must NOT hold
+// a lock on cfg.mu, since the WarningHandler and hooks may themselves access
+// cfg.
+func (cfg *Config) flushPending() {
+	cfg.mu.Lock()
+	warnings := cfg.pendingWarnings
+	cfg.pendingWarnings = nil
+	cfg.mu.Unlock()
+	for _, warning := range warnings {
+		cfg.Warn("%s", warning)
+	}
+	cfg.runHooks()
+}
+
+// optionValueOrAlias queries source for the value of opt, first by its
+// canonical name and then by each of its aliases.
+func optionValueOrAlias(source OptionValuer, opt *Option) (value string, ok bool) {
+	if value, ok = source.OptionValue(opt.Name); ok {
+		return value, ok
 	}
+	for _, alias := range opt.Aliases {
+		if value, ok = source.OptionValue(alias); ok {
+			return value, ok
+		}
+	}
+	return "", false
+}
+
+// lookup returns the cached value and source for the supplied option name,
+// rebuilding the caches first if needed. The ok return value is false if the
+// name does not correspond to any option or positional arg.
+func (cfg *Config) lookup(name string) (value string, source OptionValuer, ok bool) {
+	atomic.AddUint32(&cfg.lookupCount, 1)
+	cfg.mu.RLock()
+	if !cfg.stale() && !cfg.unresolvedOptions[cfg.resolveName(name)] {
+		value, source, ok = cfg.cached(name)
+		cfg.mu.RUnlock()
+		return value, source, ok
+	}
+	cfg.mu.RUnlock()
+
+	// Caches need to be rebuilt, or the value depends on a command which has not
+	// run yet, which requires an exclusive lock. Another goroutine may have
+	// already done so by the time we obtain the lock.
+	cfg.mu.Lock()
+	cfg.resolveCommands()
+	value, source, ok = cfg.cached(name)
+	cfg.mu.Unlock()
+	cfg.flushPending()
+	return value, source, ok
+}
+
+// stale returns true if the caches need to be rebuilt, either because cfg has
+// been marked dirty, because a source has been modified since the last
+// rebuild, or because a value from an ExpiringOptionValuer has expired. A
+// sealed Config is never stale. The caller must hold a read lock on cfg.mu.
+func (cfg *Config) stale() bool {
+	if cfg.sealed {
+		return false
+	} else if cfg.dirty {
+		return true
+	} else if !cfg.expiresAt.IsZero() && !time.Now().Before(cfg.expiresAt) {
+		return true
+	}
+	for _, tg := range cfg.generations {
+		if tg.tracker.editGeneration() != tg.generation {
+			return true
+		}
+	}
+	return false
+}
+
+// trackedGeneration records the generation of an editTracker source as of the
+// last rebuild of a Config's caches.
+type trackedGeneration struct {
+	tracker    editTracker
+	generation uint64
+}
+
+// cached returns the value and source for the supplied option name or alias
+// from the caches. The name may use any case, and underscores in place of
+// dashes. The caller must hold a read lock on cfg.mu, and the caches must not
+// be dirty.
+func (cfg *Config) cached(name string) (value string, source OptionValuer, ok bool) {
+	name = cfg.resolveName(name)
+	value, ok = cfg.unifiedValues[name]
+	return value, cfg.unifiedSources[name], ok
+}
+
+// resolveName returns the key used in the caches for the supplied option name
+// or alias. The caller must hold a read lock on cfg.mu.
+func (cfg *Config) resolveName(name string) string {
+	if _, ok := cfg.unifiedValues[name]; ok {
+		return name
+	}
+	name = canonicalOptionName(name)
+	if canonical, isAlias := cfg.unifiedAliases[name]; isAlias {
+		return canonical
+	}
+	return name
 }
 
 // MarkDirty causes the config to rebuild itself on next option lookup. This
 // is only needed in situations where a source is known to have changed since
-// the previous lookup.
+// the previous lookup. It has no effect once cfg has been sealed.
 func (cfg *Config) MarkDirty() {
-	cfg.dirty = true
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	if !cfg.sealed {
+		cfg.dirty = true
+	}
 }
 
 // Changed returns true if the specified option name has been set, and its
@@ -166,7 +764,13 @@ func (cfg *Config) Changed(name string) bool {
 	opt := cfg.FindOption(name)
 	// Note that opt cannot be nil here, so no need to check. If the name didn't
 	// correspond to an existing option, the previous call to Supplied panics.
-	return (unquote(cfg.unifiedValues[name]) != opt.Default)
+	defaultValue := opt.Default
+	if value, _, ok := cfg.CLI.Command.commandDefault(opt); ok {
+		defaultValue = unquote(value)
+	} else if opt.defaultFunc != nil {
+		defaultValue = unquote(cfg.lazyDefault(opt))
+	}
+	return (unquote(cfg.GetRaw(name)) != defaultValue)
 }
 
 // Supplied returns true if the specified option name has been set by some
@@ -178,6 +782,10 @@ func (cfg *Config) Changed(name string) bool {
 // an example, imagine that one source sets an option to a non-default value,
 // but some other higher-priority source explicitly sets it back to its default
 // value. In this case, Supplied returns true but Changed returns false.
+//
+// To determine which source supplied the value, use Source, which returns the
+// Command itself if the option fell back to its default value. SuppliedBy also
+// includes sources whose values are overridden by higher-priority sources.
 func (cfg *Config) Supplied(name string) bool {
 	source := cfg.Source(name)
 	switch source.(type) {
@@ -216,23 +824,105 @@ func (cfg *Config) OnCLI(name string) bool {
 // Source returns the OptionValuer that provided the specified option. If the
 // option does not exist, panics to indicate programmer error.
 func (cfg *Config) Source(name string) OptionValuer {
-	cfg.rebuildIfDirty()
-	source, ok := cfg.unifiedSources[name]
+	_, source, ok := cfg.lookup(name)
 	if !ok {
 		panic(fmt.Errorf("Assertion failed: option %s does not exist", name))
 	}
 	return source
 }
 
-// FindOption returns an Option by name. It first searches the current command
+// DefaultOrigin describes where an option's default value was declared; see
+// Config.DefaultOrigin.
+type DefaultOrigin int
+
+// Constants for the origin of a default value
+const (
+	DefaultFromOption  DefaultOrigin = iota // Option's declared default, or its SetDefaultFunc
+	DefaultFromCommand                      // source supplied to Command.SetDefaults
+)
+
+// DefaultOrigin reports where the default value of the specified option comes
+// from, for the command being run. If the origin is DefaultFromCommand, the
+// Command whose SetDefaults source supplies the default is also returned;
+// otherwise it is nil. This describes the default value regardless of whether
+// any source overrides it, which can be checked using Supplied. If the option
+// does not exist, panics to indicate programmer error.
+func (cfg *Config) DefaultOrigin(name string) (DefaultOrigin, *Command) {
+	opt := cfg.FindOption(name)
+	if opt == nil {
+		panic(fmt.Errorf("Assertion failed: option %s does not exist", name))
+	}
+	if _, from, ok := cfg.CLI.Command.commandDefault(opt); ok {
+		return DefaultFromCommand, from
+	}
+	return DefaultFromOption, nil
+}
+
+// SuppliedBy returns all sources that explicitly provide a value for the
+// specified option name, ordered from highest priority (the CommandLine, if
+// applicable) to lowest priority. Unlike Source, this includes sources whose
+// value is overridden by a higher-priority source. The Command's default
+// value is never included, so an empty slice means the option was not
+// mentioned by any source. Sources setting the option to a value equal to its
+// default are still included. If the option does not exist, panics to
+// indicate programmer error.
+func (cfg *Config) SuppliedBy(name string) []OptionValuer {
+	source := cfg.Source(name) // panics if option does not exist
+	result := make([]OptionValuer, 0)
+
+	// Positional args can only be supplied by the CLI, and shadow any normal
+	// option of the same name when supplied
+	if source == cfg.CLI && cfg.CLI.Command.HasArg(name) {
+		if _, isOption := cfg.CLI.OptionValue(name); !isOption {
+			return append(result, source)
+		}
+	}
+	opt := cfg.FindOption(name)
+	if _, ok := optionValueOrAlias(cfg.CLI, opt); ok {
+		result = append(result, cfg.CLI)
+	}
+	cfg.mu.RLock()
+	sources := make([]OptionValuer, len(cfg.sources))
+	copy(sources, cfg.sources)
+	cfg.mu.RUnlock()
+	for n := len(sources) - 1; n >= 0; n-- {
+		if _, ok := optionValueOrAlias(sources[n], opt); ok {
+			result = append(result, sources[n])
+		}
+	}
+	return result
+}
+
+// SetInFile returns true if the specified option name is set by at least one
+// File among cfg's sources, even if that File's value is overridden by a
+// higher-priority source, and even if the File sets the option to a value
+// equal to its default. Only the currently-selected sections of each File are
+// considered. If the option does not exist, panics to indicate programmer
+// error.
+func (cfg *Config) SetInFile(name string) bool {
+	for _, source := range cfg.SuppliedBy(name) {
+		if _, isFile := source.(*File); isFile {
+			return true
+		}
+	}
+	return false
+}
+
+// FindOption returns an Option by name or alias. It first searches the current command
 // hierarchy, but if it fails to find the option there, it then searches all
 // other command hierarchies as well. This makes it suitable for use in parsing
 // option files, which may refer to options that aren't relevant to the current
 // command but exist in some other command.
+// Option names are matched case-insensitively, and underscores are treated as
+// equivalent to dashes.
 // Returns nil if no option with that name can be found anywhere.
 func (cfg *Config) FindOption(name string) *Option {
+	canonical := canonicalOptionName(name)
 	myOptions := cfg.CLI.Command.Options()
-	if opt, ok := myOptions[name]; ok {
+	if opt, ok := myOptions[canonical]; ok {
+		return opt
+	}
+	if opt, ok := optionAliasIndex(myOptions)[canonical]; ok {
 		return opt
 	}
 	for _, arg := range cfg.CLI.Command.args { // args are option-like, but stored differently
@@ -243,7 +933,10 @@ func (cfg *Config) FindOption(name string) *Option {
 
 	var helper func(*Command) *Option
 	helper = func(cmd *Command) *Option {
-		if opt, ok := cmd.options[name]; ok {
+		if opt, ok := cmd.options[canonical]; ok {
+			return opt
+		}
+		if opt, ok := optionAliasIndex(cmd.options)[canonical]; ok {
 			return opt
 		}
 		for _, arg := range cmd.args {
@@ -263,28 +956,146 @@ func (cfg *Config) FindOption(name string) *Option {
 }
 
 // GetRaw returns an option's value as-is as a string. If the option is not set,
-// its default value will be returned. Panics if the option does not exist,
-// since this is indicative of programmer error, not runtime error.
+// its default value will be returned. Any transform function associated with
+// the option is bypassed. Panics if the option does not exist, since this is
+// indicative of programmer error, not runtime error.
 func (cfg *Config) GetRaw(name string) string {
-	cfg.rebuildIfDirty()
-	value, ok := cfg.unifiedValues[name]
+	value, _ := cfg.getRaw(name)
+	return value
+}
+
+// getRaw implements GetRaw. If the value was computed by a function supplied
+// to Option.SetDefaultFunc, the corresponding Option is also returned.
+func (cfg *Config) getRaw(name string) (string, *Option) {
+	value, source, ok := cfg.lookup(name)
 	if !ok {
 		panic(fmt.Errorf("Assertion failed: called Get on unknown option %s", name))
 	}
+	if source == cfg.CLI.Command {
+		cfg.mu.RLock()
+		opt := cfg.lazyOptions[cfg.resolveName(name)]
+		cfg.mu.RUnlock()
+		if opt != nil {
+			return cfg.lazyDefault(opt), opt
+		}
+	}
+	return value, nil
+}
+
+// lazyDefault returns the result of opt's DefaultFunc, calling it only if no
+// result has been cached since the caches were last rebuilt. The caller must
+// NOT hold a lock on cfg.mu, since the function may itself access cfg.
+func (cfg *Config) lazyDefault(opt *Option) string {
+	cfg.mu.RLock()
+	value, ok := cfg.lazyDefaults[opt.Name]
+	cfg.mu.RUnlock()
+	if ok {
+		return value
+	}
+	value = opt.defaultFunc(cfg)
+	cfg.mu.Lock()
+	if cfg.lazyDefaults == nil {
+		cfg.lazyDefaults = make(map[string]string)
+	}
+	cfg.lazyDefaults[opt.Name] = value
+	cfg.mu.Unlock()
 	return value
 }
 
 // Get returns an option's value as a string. If the entire value is wrapped
 // in quotes (single, double, or backticks) they will be stripped, and
 // escaped quotes or backslashes within the string will be unescaped. If the
-// option is not set, its default value will be returned. Panics if the option
-// does not exist, since this is indicative of programmer error, not runtime
-// error.
+// option is not set, its default value will be returned. If the option has a
+// transform function, the transformed value is returned instead, unless the
+// transform failed; see CheckValues. Panics if the option does not exist,
+// since this is indicative of programmer error, not runtime error.
+//
+// If cfg.ExpandVariables is enabled, each "${name}" in the value is replaced
+// with the value of the named option or positional arg (after its own
+// references are expanded, but prior to any transform), and each
+// "${ENV:NAME}" is replaced with the value of the named environment variable.
+// A literal "${" may be written as "$${". Expansion occurs before the option's
+// transform function is applied. References to unknown options, and circular
+// references, cause the raw value to be returned instead; the problem is
+// reported by CheckValues as an OptionTransformError.
+//
+// If tracing has been enabled via StartTrace, each call is recorded.
 func (cfg *Config) Get(name string) string {
-	value := cfg.GetRaw(name)
+	value := cfg.get(name)
+	cfg.mu.RLock()
+	tracer := cfg.tracer
+	cfg.mu.RUnlock()
+	if tracer != nil {
+		cfg.traceGet(tracer, name, value)
+	}
+	return value
+}
+
+// get implements Get, without any tracing.
+func (cfg *Config) get(name string) string {
+	value, lazyOpt := cfg.getRaw(name) // also rebuilds caches if needed
+	if lazyOpt != nil {
+		if transformed, ok, err := applyTransform(lazyOpt, value, cfg.CLI.Command); ok && err == nil {
+			return transformed
+		}
+		return unquote(value)
+	}
+	cfg.mu.RLock()
+	transformed, ok := cfg.unifiedTransformed[cfg.resolveName(name)]
+	cfg.mu.RUnlock()
+	if ok {
+		return transformed
+	}
 	return unquote(value)
 }
 
+// GetMulti returns the values of a multi-valued option, which was created by
+// MultiOption, as a slice of strings. Values are split on the option's
+// delimiter, following the same rules as GetSlice with unwrapFullValue false.
+// For options of other types, the value is split on commas. Panics if the
+// option does not exist.
+func (cfg *Config) GetMulti(name string) []string {
+	delimiter := ','
+	if opt := cfg.FindOption(name); opt != nil && opt.Type == OptionTypeMulti {
+		delimiter = opt.delimiter
+	}
+	return cfg.GetSlice(name, delimiter, false)
+}
+
+// GetMap returns the value of a map-valued option, which was created by
+// MapOption, as a map of keys to values. Each entry is split on the option's
+// delimiter, following the same rules as GetSlice with unwrapFullValue true,
+// and then split into key and value on the first equals sign. Whitespace
+// surrounding keys and values is stripped, as are quotes wrapping a value. If
+// a key occurs multiple times, the last value takes precedence. For options of
+// other types, entries are split on commas, and entries lacking an equals sign
+// are ignored. Panics if the option does not exist.
+func (cfg *Config) GetMap(name string) map[string]string {
+	delimiter := ','
+	if opt := cfg.FindOption(name); opt != nil && opt.Type == OptionTypeMap {
+		delimiter = opt.delimiter
+	}
+	result := make(map[string]string)
+	for _, entry := range cfg.GetSlice(name, delimiter, true) {
+		if key, value, ok := splitMapEntry(entry); ok {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+// splitMapEntry splits a "key=value" entry of a map-valued option. The
+// returned bool is false if the entry lacks an equals sign or a key.
+func splitMapEntry(entry string) (key, value string, ok bool) {
+	pos := strings.IndexByte(entry, '=')
+	if pos < 0 {
+		return "", "", false
+	}
+	key = strings.TrimSpace(entry[:pos])
+	value = unquote(strings.TrimSpace(entry[pos+1:]))
+	return key, value, key != ""
+}
+
 // GetSlice returns an option's value as a slice of strings, splitting on
 // the provided delimiter. Delimiters contained inside quoted values have no
 // effect, nor do backslash-escaped delimiters. Quote-wrapped tokens will have
@@ -302,7 +1113,12 @@ func (cfg *Config) GetSlice(name string, delimiter rune, unwrapFullValue bool) [
 	} else {
 		value = cfg.GetRaw(name)
 	}
+	return splitValue(value, delimiter)
+}
 
+// splitValue splits value on delimiter, following the rules described in
+// GetSlice.
+func splitValue(value string, delimiter rune) []string {
 	tokens := make([]string, 0)
 	var startToken int
 	var inQuote rune
@@ -341,15 +1157,25 @@ func (cfg *Config) GetBool(name string) bool {
 }
 
 // GetInt returns an option's value as an int. If an error occurs in parsing
-// the value as an int, it is returned as the second return value. Panics if
-// the option does not exist.
+// the value as an int, it is returned as the second return value. If the
+// option has a range set via Option.SetNumericRange or Option.SetNumericStep
+// which the value violates, the value is returned along with an
+// OptionValueError. Panics if the option does not exist.
 func (cfg *Config) GetInt(name string) (int, error) {
-	return strconv.Atoi(cfg.Get(name))
+	raw := cfg.Get(name)
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		return value, err
+	}
+	if err := cfg.FindOption(name).checkRange(raw); err != nil {
+		return value, cfg.valueError(name, raw, err)
+	}
+	return value, nil
 }
 
 // GetIntOrDefault is like GetInt, but returns the option's default value if
-// parsing the supplied value as an int fails. Panics if the option does not
-// exist.
+// GetInt returns an error, for example if the supplied value cannot be parsed
+// as an int. Panics if the option does not exist.
 func (cfg *Config) GetIntOrDefault(name string) int {
 	value, err := cfg.GetInt(name)
 	if err != nil {
@@ -362,12 +1188,37 @@ func (cfg *Config) GetIntOrDefault(name string) int {
 	return value
 }
 
+// GetFloat returns an option's value as a float64. An empty value is treated
+// as 0. Values are parsed in the same manner as for FloatOption, regardless of
+// the option's type or the system locale. If the value cannot be parsed, or
+// violates a range set via Option.SetNumericRange or Option.SetNumericStep, an
+// OptionValueError is returned. Panics if the option does not exist.
+func (cfg *Config) GetFloat(name string) (float64, error) {
+	raw := cfg.Get(name)
+	value, err := parseFloat(raw)
+	if err == nil {
+		err = cfg.FindOption(name).checkRange(raw)
+	}
+	if err != nil {
+		return value, cfg.valueError(name, raw, err)
+	}
+	return value, nil
+}
+
 // GetEnum returns an option's value as a string if it matches one of the
 // supplied allowed values, or its default value (which need not be supplied).
 // Otherwise an error is returned. Matching is case-insensitive, but the
 // returned value will always be of the same case as it was supplied in
-// allowedValues. Panics if the option does not exist.
+// allowedValues. If no allowedValues are supplied, the option's own
+// AllowedValues are used; see EnumOption and Option.SetChoices. Errors are of
+// type OptionValueError.
+// Panics if the option does not exist.
 func (cfg *Config) GetEnum(name string, allowedValues ...string) (string, error) {
+	if len(allowedValues) == 0 {
+		if opt := cfg.FindOption(name); opt != nil {
+			allowedValues = append([]string{}, opt.AllowedValues...)
+		}
+	}
 	value := strings.ToLower(cfg.Get(name))
 	defaultValue, _ := cfg.CLI.Command.OptionValue(name)
 	var seenDefaultInAllowed bool
@@ -389,46 +1240,107 @@ func (cfg *Config) GetEnum(name string, allowedValues ...string) (string, error)
 		allowedValues[n] = fmt.Sprintf(`"%s"`, allowedValues[n])
 	}
 	allAllowed := strings.Join(allowedValues, ", ")
-	return "", fmt.Errorf("Option %s can only be set to one of these values: %s", name, allAllowed)
+	return "", cfg.valueError(name, cfg.Get(name), fmt.Errorf("must be one of these values: %s", allAllowed))
 }
 
 // GetBytes returns an option's value as a uint64 representing a number of bytes.
-// If the value was supplied with a suffix of K, M, or G (upper or lower case)
-// the returned value will automatically be multiplied by 1024, 1024^2, or
-// 1024^3 respectively. Suffixes may also be expressed with a trailing 'B',
-// e.g. 'KB' and 'K' are equivalent.
+// If the value was supplied with a suffix of K, M, G, or T (upper or lower
+// case) the returned value will automatically be multiplied by 1024, 1024^2,
+// 1024^3, or 1024^4 respectively. Suffixes may also be expressed with a
+// trailing 'B', e.g. 'KB' and 'K' are equivalent. The number may have a
+// fractional part, e.g. '1.5G', as long as the result is a whole number of
+// bytes.
 // A blank string will be returned as 0, with no error. Aside from that case,
-// an error will be returned if the value cannot be parsed as a byte size.
+// an error will be returned if the value cannot be parsed as a byte size, or
+// violates a range set via Option.SetNumericRange or Option.SetNumericStep.
 // Panics if the option does not exist.
 func (cfg *Config) GetBytes(name string) (uint64, error) {
-	var multiplier uint64 = 1
-	value := strings.ToLower(cfg.Get(name))
-	if value == "" {
-		return 0, nil
+	value := cfg.Get(name)
+	size, err := parseSize(value)
+	if err == nil {
+		err = cfg.FindOption(name).checkRange(value)
 	}
-	if value[len(value)-1] == 'b' {
-		value = value[0 : len(value)-1]
+	if err != nil {
+		return 0, cfg.valueError(name, value, err)
 	}
+	return size, nil
+}
 
-	if strings.LastIndexAny(value, "kmg") == len(value)-1 {
-		multipliers := map[byte]uint64{
-			'k': 1024,
-			'm': 1024 * 1024,
-			'g': 1024 * 1024 * 1024,
-		}
-		suffix := value[len(value)-1]
-		value = value[0 : len(value)-1]
-		multiplier = multipliers[suffix]
+// GetSize is equivalent to GetBytes. It is typically used with options
+// created by SizeOption, whose values have already been validated during
+// parsing, but it may be used with any option.
+func (cfg *Config) GetSize(name string) (uint64, error) {
+	return cfg.GetBytes(name)
+}
+
+// GetDuration returns an option's value as a time.Duration. The value may be
+// in any format accepted by time.ParseDuration, or a bare integer number of
+// seconds. A blank string will be returned as 0, with no error. Aside from
+// that case, an error will be returned if the value cannot be parsed as a
+// non-negative duration, or violates a range set via Option.SetNumericRange or
+// Option.SetNumericStep. Panics if the option does not exist.
+func (cfg *Config) GetDuration(name string) (time.Duration, error) {
+	value := cfg.Get(name)
+	d, err := parseDuration(value)
+	if err == nil {
+		err = cfg.FindOption(name).checkRange(value)
+	}
+	if err != nil {
+		return 0, cfg.valueError(name, value, err)
 	}
+	return d, nil
+}
+
+// GetMillis returns an option's value as an int64 number of milliseconds. The
+// value is parsed in the same manner as GetDuration, so "250ms", "1.5s", "2h",
+// and a bare integer number of seconds are all accepted. A blank string will
+// be returned as 0, with no error. Aside from that case, an error will be
+// returned if the value cannot be parsed as a non-negative duration, is not a
+// whole number of milliseconds, or violates a range set via
+// Option.SetNumericRange or Option.SetNumericStep. Panics if the option does
+// not exist.
+func (cfg *Config) GetMillis(name string) (int64, error) {
+	d, err := cfg.GetDuration(name)
+	if err == nil && d%time.Millisecond != 0 {
+		return 0, cfg.valueError(name, cfg.Get(name), errors.New("must be a whole number of milliseconds"))
+	}
+	return int64(d / time.Millisecond), err
+}
 
-	numVal, err := strconv.ParseUint(value, 10, 64)
-	return numVal * multiplier, err
+// GetTime returns an option's value as a time.Time. The value must be in RFC
+// 3339 format, or in one of the layouts set via Option.SetTimeLayouts. Values
+// lacking a time zone are interpreted in the location set via
+// Option.SetTimeLocation, or UTC by default; values with an offset retain it,
+// so callers wanting a particular location should call In on the result. A
+// blank string will be returned as the zero time, with no error. Aside from
+// that case, an OptionValueError will be returned if the value cannot be
+// parsed. Panics if the option does not exist.
+func (cfg *Config) GetTime(name string) (time.Time, error) {
+	value := cfg.Get(name)
+	t, err := cfg.FindOption(name).parseTime(value)
+	if err != nil {
+		return time.Time{}, cfg.valueError(name, value, err)
+	}
+	return t, nil
+}
+
+// valueError returns an OptionValueError describing a problem with the value
+// of the named option, identifying the source that supplied the value.
+func (cfg *Config) valueError(name, value string, problem error) error {
+	sourceName, filePath := describeSource(cfg.Source(name))
+	return OptionValueError{
+		Name:     name,
+		Value:    cfg.FindOption(name).displayValue(value),
+		Problem:  problem.Error(),
+		Source:   sourceName,
+		FilePath: filePath,
+	}
 }
 
 // GetRegexp returns an option's value as a compiled *regexp.Regexp. If the
 // option value isn't set (empty string), returns nil,nil. If the option value
 // is set but cannot be compiled as a valid regular expression, returns nil and
-// an error value. Panics if the named option does not exist.
+// an OptionValueError. Panics if the named option does not exist.
 func (cfg *Config) GetRegexp(name string) (*regexp.Regexp, error) {
 	value := cfg.Get(name)
 	if value == "" {
@@ -436,7 +1348,7 @@ func (cfg *Config) GetRegexp(name string) (*regexp.Regexp, error) {
 	}
 	re, err := regexp.Compile(value)
 	if err != nil {
-		return nil, fmt.Errorf("Invalid regexp for option %s: %s", name, value)
+		return nil, cfg.valueError(name, value, err)
 	}
 	return re, nil
 }
@@ -444,8 +1356,8 @@ func (cfg *Config) GetRegexp(name string) (*regexp.Regexp, error) {
 // Unquote takes a string, trims whitespace on both ends, and then examines
 // whether the entire string is wrapped in quotes. If it isn't, the string
 // is returned as-is after the whitespace is trimmed. Otherwise, the string
-// will have its wrapped quotes removed, and escaped values within the string
-// will be un-escaped.
+// will have its wrapped quotes removed, and escape sequences within the string
+// will be interpreted; see escapeSequences.
 func unquote(input string) string {
 	input = strings.TrimSpace(input)
 	if utf8.RuneCountInString(input) < 2 { // too short to possibly be quoted
@@ -457,30 +1369,70 @@ func unquote(input string) string {
 		return input
 	}
 
-	// Do a pass through the string. Store each rune in a buffer, unescaping
-	// escaped values in the process. If we hit a terminating quote midway thru
-	// the string, return the original value. (We don't unquote or unescape
-	// anything unless the *entire* value is quoted.)
+	// Do a pass through the string, to confirm that we don't hit a terminating
+	// quote midway thru the string. If so, return the original value. (We don't
+	// unquote or unescape anything unless the *entire* value is quoted.)
+	inner := input[1 : len(input)-1]
 	var escapeNext bool
-	var runeTmp [utf8.UTFMax]byte
-	buf := make([]byte, 0, len(input)-2)
-	for _, r := range input[1 : len(input)-1] {
+	for _, r := range inner {
 		if r == quote && !escapeNext {
 			// we hit an unescaped terminating quote midway in the string, meaning the
 			// entire input is not quote-wrapped
 			return input
 		}
-		if r == '\\' && !escapeNext {
+		escapeNext = (r == '\\' && !escapeNext)
+	}
+	return unescape(inner, false)
+}
+
+// escapeSequences maps each character which may follow a backslash in an
+// option value to the character represented by that escape sequence. These
+// follow MySQL's option file conventions, along with escaped hashes and quote
+// characters.
+var escapeSequences = map[rune]rune{
+	'b':  '\b',
+	't':  '\t',
+	'n':  '\n',
+	'r':  '\r',
+	's':  ' ',
+	'\\': '\\',
+	'#':  '#',
+	'\'': '\'',
+	'"':  '"',
+	'`':  '`',
+}
+
+// unescape returns s with its escape sequences interpreted; see
+// escapeSequences. A backslash followed by any other character is removed,
+// unless keepUnknown is true, in which case it is retained as-is. (Unquoted
+// values in option files use keepUnknown, so that values such as Windows paths
+// do not require escaping, consistent with MySQL.)
+func unescape(s string, keepUnknown bool) string {
+	if !strings.ContainsRune(s, '\\') {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	var escapeNext bool
+	for _, r := range s {
+		if escapeNext {
+			escapeNext = false
+			if replacement, ok := escapeSequences[r]; ok {
+				b.WriteRune(replacement)
+			} else {
+				if keepUnknown {
+					b.WriteRune('\\')
+				}
+				b.WriteRune(r)
+			}
+		} else if r == '\\' {
 			escapeNext = true
-			continue
-		}
-		escapeNext = false
-		if r >= utf8.RuneSelf { // multibyte character
-			byteCount := utf8.EncodeRune(runeTmp[:], r)
-			buf = append(buf, runeTmp[0:byteCount]...)
-		} else { // single-byte character
-			buf = append(buf, byte(r))
+		} else {
+			b.WriteRune(r)
 		}
 	}
-	return string(buf)
+	if escapeNext && keepUnknown { // trailing lone backslash
+		b.WriteRune('\\')
+	}
+	return b.String()
 }
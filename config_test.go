@@ -1,9 +1,15 @@
 package mybase
 
 import (
+	"bytes"
+	"errors"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestOptionStatus(t *testing.T) {
@@ -86,6 +92,352 @@ func TestSuppliedWithValue(t *testing.T) {
 	assertSuppliedWithValue(cfg, "optional2", true)
 }
 
+func TestSuppliedBy(t *testing.T) {
+	cmd := simpleCommand()
+	cmd.AddOption(StringOption("algorithm", 0, "default", "dummy description"))
+	cfg := ParseFakeCLI(t, cmd, "mycommand --algorithm=default -s hello arg1")
+	file := NewFile("/tmp/fake.cnf")
+	file.contents = "hasshort=world\n[foo]\nvisible=yes\ntruthybool=1\n"
+	file.read = true
+	if err := file.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error from Parse: %v", err)
+	}
+	file.UseSection("foo")
+	env := &SimpleSource{"hasshort": "env"}
+	cfg.AddSource(file)
+	cfg.AddSource(env)
+
+	assertSuppliedBy := func(name string, expected ...OptionValuer) {
+		t.Helper()
+		actual := cfg.SuppliedBy(name)
+		if len(actual) != len(expected) {
+			t.Errorf("Expected SuppliedBy(%q) to return %d sources, instead found %d: %v", name, len(expected), len(actual), actual)
+			return
+		}
+		for n := range expected {
+			if actual[n] != expected[n] {
+				t.Errorf("Unexpected SuppliedBy(%q)[%d]: expected %v, found %v", name, n, expected[n], actual[n])
+			}
+		}
+	}
+	assertSuppliedBy("algorithm", cfg.CLI)
+	assertSuppliedBy("hasshort", cfg.CLI, env, file)
+	assertSuppliedBy("visible", file)
+	assertSuppliedBy("bool1")
+	assertSuppliedBy("required", cfg.CLI)
+	assertSuppliedBy("optional")
+
+	// Option explicitly set to its default value: supplied, but not changed
+	if cfg.Changed("algorithm") || !cfg.Supplied("algorithm") || !cfg.OnCLI("algorithm") || cfg.SetInFile("algorithm") {
+		t.Error("Unexpected status for option explicitly set to its default value on CLI")
+	}
+	if cfg.Changed("truthybool") || !cfg.SetInFile("truthybool") || cfg.OnCLI("truthybool") {
+		t.Error("Unexpected status for option explicitly set to its default value in file")
+	}
+	if !cfg.SetInFile("hasshort") || cfg.SetInFile("bool1") {
+		t.Error("Unexpected return value from SetInFile")
+	}
+	if !file.SectionHasOption("foo", "truthybool") || file.SectionHasOption("", "truthybool") || file.SectionHasOption("doesnt-exist", "visible") {
+		t.Error("Unexpected return value from File.SectionHasOption")
+	}
+}
+
+func TestInsertSource(t *testing.T) {
+	cmd := simpleCommand()
+	cfg := ParseFakeCLI(t, cmd, "mycommand --hasshort=cli arg1")
+	low := SimpleSource{"visible": "low", "hidden": "low"}
+	high := SimpleSource{"visible": "high"}
+	cfg.AddSource(low)
+	cfg.AddSource(high)
+
+	// Insert between low and high
+	middle := SimpleSource{"visible": "middle", "hidden": "middle", "hasshort": "middle"}
+	cfg.InsertSourceBefore(high, middle)
+	if cfg.Get("visible") != "high" || cfg.Get("hidden") != "middle" || cfg.Get("hasshort") != "cli" {
+		t.Errorf("Unexpected values after InsertSourceBefore: visible=%q hidden=%q hasshort=%q", cfg.Get("visible"), cfg.Get("hidden"), cfg.Get("hasshort"))
+	}
+	lowest := SimpleSource{"bool1": "1", "hidden": "lowest"}
+	cfg.InsertSourceAfter(cmd, lowest)
+	highest := SimpleSource{"visible": "highest"}
+	cfg.InsertSourceBefore(cfg.CLI, highest)
+	cfg.InsertSourceAfter(low, SimpleSource{"hidden": "above-low"})
+	if cfg.Get("visible") != "highest" || cfg.Get("hidden") != "middle" || !cfg.GetBool("bool1") {
+		t.Errorf("Unexpected values after InsertSourceAfter: visible=%q hidden=%q bool1=%t", cfg.Get("visible"), cfg.Get("hidden"), cfg.GetBool("bool1"))
+	}
+	sources := cfg.Sources()
+	if len(sources) != 6 || !sameSource(sources[0], lowest) || !sameSource(sources[1], low) || !sameSource(sources[3], middle) || !sameSource(sources[5], highest) {
+		t.Errorf("Unexpected result from Sources: %v", sources)
+	}
+
+	for _, existing := range []OptionValuer{SimpleSource{"visible": "high"}, cfg.CLI} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected InsertSourceAfter to panic with source %v, but it did not", existing)
+				}
+			}()
+			cfg.InsertSourceAfter(existing, SimpleSource{})
+		}()
+	}
+}
+
+func TestOptionAliases(t *testing.T) {
+	cmd := simpleCommand()
+	cmd.AddOption(StringOption("database", 'D', "", "dummy description").AddAlias("schema", "db_name"))
+	cmd.AddOption(BoolOption("safe", 0, true, "dummy description").AddAlias("safe-mode"))
+
+	cfg := ParseFakeCLI(t, cmd, "mycommand --schema=foo --skip-safe-mode arg1")
+	for _, name := range []string{"database", "schema", "db-name"} {
+		if value := cfg.Get(name); value != "foo" {
+			t.Errorf("Expected Get(%q) to return %q, instead found %q", name, "foo", value)
+		}
+		if !cfg.OnCLI(name) || !cfg.Changed(name) {
+			t.Errorf("Unexpected status for option %q", name)
+		}
+		if opt := cfg.FindOption(name); opt == nil || opt.Name != "database" {
+			t.Errorf("Expected FindOption(%q) to return canonical option, instead found %+v", name, opt)
+		}
+	}
+	if cfg.GetBool("safe") || cfg.GetBool("safe-mode") {
+		t.Error("Expected --skip-safe-mode to disable option safe, but it did not")
+	}
+	if _, ok := cfg.CLI.OptionValues["schema"]; ok {
+		t.Error("Expected CLI to store value under canonical name, but found alias")
+	}
+
+	// Same value via multiple spellings is fine; last wins for same spelling
+	cfg = ParseFakeCLI(t, cmd, "mycommand -D foo --database=bar --schema=bar arg1")
+	if value := cfg.Get("schema"); value != "bar" {
+		t.Errorf("Expected value %q, instead found %q", "bar", value)
+	}
+	// Conflicting values via different spellings: an error by default, or last
+	// wins with LastAliasWins
+	if _, err := ParseCLI(cmd, []string{"mycommand", "--database=foo", "--schema=bar", "arg1"}); err == nil {
+		t.Error("Expected conflicting values via alias to return an error, but it did not")
+	} else if acErr, ok := err.(OptionAliasConflictError); !ok || acErr.Spellings != [2]string{"database", "schema"} {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	cmd.LastAliasWins = true
+	cfg = ParseFakeCLI(t, cmd, "mycommand --database=foo --schema=bar arg1")
+	if value := cfg.Get("database"); value != "bar" {
+		t.Errorf("Expected value %q, instead found %q", "bar", value)
+	}
+	cmd.LastAliasWins = false
+
+	// Option files and other sources may use aliases too
+	cfg = ParseFakeCLI(t, cmd, "mycommand arg1", SimpleSource{"safe-mode": "0"})
+	f, err := getParsedFile(cfg, false, "schema=hello\n[conflict]\ndb_name=hello\ndatabase=hello\n")
+	if err != nil {
+		t.Fatalf("Unexpected error parsing file: %v", err)
+	}
+	cfg.AddSource(f)
+	if value := cfg.Get("db-name"); value != "hello" || !cfg.SetInFile("schema") {
+		t.Errorf("Expected value %q from file, instead found %q", "hello", value)
+	}
+	if cfg.GetBool("safe") {
+		t.Error("Expected SimpleSource value via alias to disable option safe, but it did not")
+	}
+	for _, name := range []string{"database", "schema", "DB_NAME"} {
+		if sections := f.SectionsWithOption(name); !reflect.DeepEqual(sections, []string{"", "conflict"}) {
+			t.Errorf("Unexpected result from SectionsWithOption(%q): %v", name, sections)
+		}
+		if !f.SomeSectionHasOption(name) || !f.SectionHasOption("conflict", name) {
+			t.Errorf("Expected option %q to be found via SomeSectionHasOption and SectionHasOption, but it was not", name)
+		}
+	}
+	if f.SomeSectionHasOption("safe-mode") {
+		t.Error("Expected SomeSectionHasOption to return false for option not set in file")
+	}
+	if _, err := getParsedFile(cfg, false, "schema=hello\ndatabase=goodbye\n"); err == nil {
+		t.Error("Expected conflicting values via alias in file to return an error, but it did not")
+	}
+	cmd.LastAliasWins = true
+	if f, err := getParsedFile(cfg, false, "schema=hello\ndatabase=goodbye\n"); err != nil {
+		t.Errorf("Unexpected error from conflicting values via alias in file: %v", err)
+	} else if value, _ := f.OptionValue("database"); value != "goodbye" {
+		t.Errorf("Expected last value to win, instead found %q", value)
+	}
+	cmd.LastAliasWins = false
+
+	// Aliases are shown in help
+	if usage := cfg.FindOption("database").Usage(20); !strings.Contains(usage, "--database, --schema, --db-name value") {
+		t.Errorf("Expected usage to include aliases, instead found %q", usage)
+	}
+
+	// Alias conflicting with another option is a programmer error
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected AddOption to panic due to alias conflict, but it did not")
+		}
+	}()
+	cmd.AddOption(StringOption("other", 0, "", "dummy description").AddAlias("db-name"))
+}
+
+func TestDeprecatedOption(t *testing.T) {
+	cmd := simpleCommand()
+	cmd.AddOptions("",
+		StringOption("new-name", 0, "new-default", "dummy description"),
+		StringOption("old-name", 0, "", "dummy description").Deprecated("new_name", ""),
+		BoolOption("legacy", 0, false, "dummy description").Deprecated("", "legacy mode is always enabled now"),
+	)
+	if usage := cmd.Options()["old-name"].Usage(20); !strings.Contains(usage, "[DEPRECATED: use option new-name instead]") {
+		t.Errorf("Expected usage to mention deprecation, instead found %q", usage)
+	}
+
+	cfg := ParseFakeCLI(t, cmd, "mycommand --old-name=from-cli arg1")
+	var warnings []string
+	cfg.WarningHandler = func(message string) {
+		warnings = append(warnings, message)
+	}
+	file, err := getParsedFile(cfg, false, "old-name=from-file\nlegacy\n")
+	if err != nil {
+		t.Fatalf("Unexpected error parsing file: %v", err)
+	}
+	cfg.AddSource(file)
+
+	// Value of deprecated option should be used for its replacement, with the
+	// same precedence as the source which supplied it
+	if value := cfg.Get("new-name"); value != "from-cli" {
+		t.Errorf("Expected new-name to have value from CLI, instead found %q", value)
+	}
+	if source := cfg.Source("new-name"); source != cfg.CLI {
+		t.Errorf("Expected new-name to have source CLI, instead found %v", source)
+	}
+	if value := cfg.Get("old-name"); value != "from-cli" {
+		t.Errorf("Expected old-name to retain its value, instead found %q", value)
+	}
+	expected := []string{
+		"command line: Option old-name is deprecated: use option new-name instead",
+		file.Path() + ": Option legacy is deprecated: legacy mode is always enabled now",
+		file.Path() + ": Option old-name is deprecated: use option new-name instead",
+	}
+	sort.Strings(warnings)
+	sort.Strings(expected)
+	if !reflect.DeepEqual(warnings, expected) {
+		t.Errorf("Unexpected warnings: %v", warnings)
+	}
+
+	// Warnings should not be repeated once caches are rebuilt, and the
+	// replacement should take precedence when supplied by the same source
+	warnings = nil
+	cfg = ParseFakeCLI(t, cmd, "mycommand --old-name=from-cli --new-name=newer arg1")
+	cfg.WarningHandler = func(message string) {
+		warnings = append(warnings, message)
+	}
+	if value := cfg.Get("new-name"); value != "newer" {
+		t.Errorf("Expected new-name to have its own value, instead found %q", value)
+	}
+	cfg.MarkDirty()
+	if value := cfg.Get("new-name"); value != "newer" || len(warnings) != 1 {
+		t.Errorf("Unexpected state after rebuild: new-name=%q warnings=%v", value, warnings)
+	}
+
+	// Deprecating an option in favor of itself should panic
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected Deprecated to panic, but it did not")
+		}
+	}()
+	StringOption("foo", 0, "", "dummy description").Deprecated("foo", "")
+}
+
+func TestOptionNameCanonicalization(t *testing.T) {
+	cmd := NewCommand("mycommand", "summary", "description", nil)
+	cmd.AddOption(StringOption("my-option", 0, "", "dummy description"))
+	cmd.AddOption(StringOption("Other_Option", 0, "", "dummy description"))
+	cmd.AddOption(StringOption("unused", 0, "", "dummy description").AddAlias("Old_Name"))
+
+	file := NewFile("/tmp/fake.cnf")
+	file.contents = "My_Option=fromfile\nOTHER-OPTION=alsofromfile\nold_NAME=aliased\n"
+	file.read = true
+	cfg := ParseFakeCLI(t, cmd, "mycommand --MY_OPTION=fromcli")
+	if err := file.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error from Parse: %v", err)
+	}
+	cfg.AddSource(file)
+
+	for _, name := range []string{"my-option", "my_option", "My-Option"} {
+		if value := cfg.Get(name); value != "fromcli" {
+			t.Errorf("Expected Get(%q) to return %q, instead found %q", name, "fromcli", value)
+		}
+		if value, _ := file.OptionValue(name); value != "fromfile" {
+			t.Errorf("Expected File.OptionValue(%q) to return %q, instead found %q", name, "fromfile", value)
+		}
+	}
+	if value := cfg.Get("other_option"); value != "alsofromfile" {
+		t.Errorf("Expected %q, instead found %q", "alsofromfile", value)
+	}
+	if value := cfg.Get("unused"); value != "aliased" {
+		t.Errorf("Expected %q, instead found %q", "aliased", value)
+	}
+	if value := SimpleConfig(map[string]string{"Skip_Networking": "1"}).Get("skip-networking"); value != "1" {
+		t.Errorf("Expected SimpleSource key to match canonical name, instead found %q", value)
+	}
+
+	// Help output and Write should use the canonical spelling
+	if usage := cfg.FindOption("OTHER_OPTION").Usage(20); !strings.Contains(usage, "--other-option") {
+		t.Errorf("Expected usage to show canonical name, instead found %q", usage)
+	}
+	if _, ok := file.sectionIndex[""].Values["other-option"]; !ok {
+		t.Errorf("Expected value to be stored under canonical name, instead found %v", file.sectionIndex[""].Values)
+	}
+
+	// Re-adding an option with the identical spelling is permitted, but two
+	// options differing only in case or separator is a programmer error
+	cmd.AddOption(StringOption("Other_Option", 0, "", "replacement"))
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected AddOption to panic due to name differing only in case/separator, but it did not")
+		}
+	}()
+	cmd.AddOption(StringOption("my_option", 0, "", "dummy description"))
+}
+
+func TestBoolNegationForms(t *testing.T) {
+	cmd := NewCommand("mycommand", "summary", "description", nil)
+	cmd.AddOption(BoolOption("foo", 0, false, "dummy description"))
+	cmd.AddOption(BoolOption("bar", 0, true, "dummy description"))
+
+	forms := map[string]bool{
+		"foo":             true,
+		"foo=1":           true,
+		"foo=on":          true,
+		"foo=TRUE":        true,
+		"foo=0":           false,
+		"foo=off":         false,
+		"foo=False":       false,
+		"foo=":            false,
+		"enable-foo":      true,
+		"enable-foo=off":  false,
+		"skip-foo":        false,
+		"disable-foo":     false,
+		"skip-foo=0":      true,
+		"disable-foo=off": true,
+		"skip-foo=true":   false,
+		"loose-skip-foo":  false,
+	}
+	for form, expected := range forms {
+		cfg := ParseFakeCLI(t, cmd, "mycommand --"+form)
+		if actual := cfg.GetBool("foo"); actual != expected {
+			t.Errorf("Expected --%s on CLI to result in %t, instead found %t", form, expected, actual)
+		}
+		f, err := getParsedFile(ParseFakeCLI(t, cmd, "mycommand"), false, form+"\n")
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q in option file: %v", form, err)
+			continue
+		}
+		cfg = ParseFakeCLI(t, cmd, "mycommand", f)
+		if actual := cfg.GetBool("foo"); actual != expected {
+			t.Errorf("Expected %s in option file to result in %t, instead found %t", form, expected, actual)
+		}
+	}
+
+	// Help shows the negated form for options enabled by default
+	if usage := cmd.Options()["bar"].Usage(20); !strings.Contains(usage, "--[skip-]bar") {
+		t.Errorf("Expected usage to show negated form, instead found %q", usage)
+	}
+}
+
 func TestGetRaw(t *testing.T) {
 	optionValues := map[string]string{
 		"basic":     "foo",
@@ -152,6 +504,7 @@ func TestGet(t *testing.T) {
 		{"esc-esc", `"c:\\tacotown"`, `c:\tacotown`},
 		{"esc-rando", `'why\ whatevs'`, `why whatevs`},
 		{"esc-uni", `'escaped snowpeople \☃ oh noes'`, `escaped snowpeople ☃ oh noes`},
+		{"esc-mysql", `"tab\there\nnewline\sspace \# hash"`, "tab\there\nnewline space # hash"},
 	}
 	for _, tuple := range quotedValues {
 		assertQuotedGet(tuple[0], tuple[1], tuple[2])
@@ -188,6 +541,159 @@ func TestGetSlice(t *testing.T) {
 	assertGetSlice(" `  `  ", ' ', true)
 }
 
+func TestTypedOptions(t *testing.T) {
+	cmd := simpleCommand()
+	cmd.AddOption(DurationOption("timeout", 0, 30*time.Second, "dummy"))
+	cmd.AddOption(SizeOption("buffer-size", 0, 16*1024*1024, "dummy"))
+	cmd.AddOption(EnumOption("format", 0, "table", []string{"table", "json", "csv"}, "dummy"))
+	cmd.AddOption(DurationOption("no-default", 0, 0, "dummy").ValueOptional())
+	cmd.AddOption(FloatOption("ratio", 0, 0.5, "dummy").SetNumericRange(0, 1))
+
+	cfg := ParseFakeCLI(t, cmd, "mycommand arg1")
+	if f, err := cfg.GetFloat("ratio"); f != 0.5 || err != nil || cfg.Get("ratio") != "0.5" {
+		t.Errorf("Unexpected default float: %v, %v", f, err)
+	}
+	if d, err := cfg.GetDuration("timeout"); d != 30*time.Second || err != nil {
+		t.Errorf("Unexpected default duration: %s, %v", d, err)
+	}
+	if size, err := cfg.GetSize("buffer-size"); size != 16*1024*1024 || err != nil || cfg.Get("buffer-size") != "16M" {
+		t.Errorf("Unexpected default size: %d, %v", size, err)
+	}
+	if value, err := cfg.GetEnum("format"); value != "table" || err != nil {
+		t.Errorf("Unexpected default enum value: %q, %v", value, err)
+	}
+	if d, err := cfg.GetDuration("no-default"); d != 0 || err != nil {
+		t.Errorf("Unexpected default duration: %s, %v", d, err)
+	}
+
+	cfg = ParseFakeCLI(t, cmd, "mycommand --timeout=90 --buffer-size 2g --format=JSON --no-default=1m30s --ratio=25e-3 arg1")
+	if f, _ := cfg.GetFloat("ratio"); f != 0.025 {
+		t.Errorf("Unexpected float: %v", f)
+	}
+	if d, _ := cfg.GetDuration("timeout"); d != 90*time.Second {
+		t.Errorf("Expected bare integer to be parsed as seconds, instead found %s", d)
+	}
+	if size, _ := cfg.GetSize("buffer-size"); size != 2*1024*1024*1024 {
+		t.Errorf("Unexpected size: %d", size)
+	}
+	if value, _ := cfg.GetEnum("format"); value != "json" {
+		t.Errorf("Unexpected enum value: %q", value)
+	}
+	if d, _ := cfg.GetDuration("no-default"); d != 90*time.Second {
+		t.Errorf("Unexpected duration: %s", d)
+	}
+
+	// Invalid values are rejected on the CLI and in option files, with errors
+	// identifying the source
+	for _, cliArgs := range []string{"--timeout=-5s", "--timeout=soon", "--buffer-size=1.3K", "--buffer-size=99999999999999G", "--format=xml", "--ratio=0,5", "--ratio=NaN", "--ratio=0x1p-2"} {
+		_, err := ParseCLI(cmd, strings.Fields("mycommand arg1 "+cliArgs))
+		if ove, ok := err.(OptionValueError); !ok || ove.Location() != "CLI" {
+			t.Errorf("Expected OptionValueError from CLI for %s, instead found %v", cliArgs, err)
+		}
+	}
+	cfg = ParseFakeCLI(t, cmd, "mycommand arg1")
+	_, err := getParsedFile(cfg, false, "timeout=5m\nformat='csv'\nbuffer-size=lots\n")
+	if ove, ok := err.(OptionValueError); !ok || ove.Line() != 3 || ove.OptionName() != "buffer-size" {
+		t.Errorf("Expected OptionValueError on line 3 of file, instead found %v", err)
+	}
+
+	// Values from sources which are not validated during parsing, such as
+	// custom OptionValuers, result in errors from the getters which identify the
+	// source
+	cfg.AddSource(SimpleSource{"timeout": "eventually"})
+	if _, err := cfg.GetDuration("timeout"); err == nil || !strings.Contains(err.Error(), "eventually") {
+		t.Errorf("Expected error from GetDuration, instead found %v", err)
+	}
+	cfg.AddSource(SimpleSource{"ratio": "1.5"})
+	if _, err := cfg.GetFloat("ratio"); err == nil || !strings.Contains(err.Error(), "between 0 and 1") {
+		t.Errorf("Expected error from GetFloat, instead found %v", err)
+	}
+	if _, err := cfg.GetFloat("hasshort"); err != nil {
+		t.Errorf("Expected GetFloat to treat empty value as 0, instead found error %v", err)
+	}
+
+	// Usage should indicate the type of value
+	if usage := cmd.Options()["format"].usageName(); usage != "format {table|json|csv}" {
+		t.Errorf("Unexpected usage name %q", usage)
+	}
+	if usage := cmd.Options()["no-default"].usageName(); usage != "no-default[=duration]" {
+		t.Errorf("Unexpected usage name %q", usage)
+	}
+	if usage := cmd.Options()["ratio"].usageName(); usage != "ratio number" {
+		t.Errorf("Unexpected usage name %q", usage)
+	}
+}
+
+func TestGetTime(t *testing.T) {
+	eastern := time.FixedZone("EST", -5*3600)
+	defaultTime := time.Date(2024, 3, 1, 2, 30, 0, 0, time.UTC)
+	cmd := simpleCommand()
+	cmd.AddOption(TimeOption("start", 0, defaultTime, "dummy"))
+	cmd.AddOption(TimeOption("window", 0, time.Time{}, "dummy").SetTimeLayouts("2006-01-02 15:04", "15:04").SetTimeLocation(eastern))
+
+	cfg := ParseFakeCLI(t, cmd, "mycommand arg1")
+	if ts, err := cfg.GetTime("start"); !ts.Equal(defaultTime) || err != nil || cfg.Get("start") != "2024-03-01T02:30:00Z" {
+		t.Errorf("Unexpected default time: %s, %v", ts, err)
+	}
+	if ts, err := cfg.GetTime("window"); !ts.IsZero() || err != nil {
+		t.Errorf("Unexpected default time: %s, %v", ts, err)
+	}
+	if !cmd.Options()["start"].HasNonzeroDefault() || cmd.Options()["window"].HasNonzeroDefault() {
+		t.Error("Unexpected result from HasNonzeroDefault")
+	}
+
+	// Values with an offset retain it; values without one use the Option's
+	// location
+	cfg = ParseFakeCLI(t, cmd, "mycommand --start=2024-06-01T12:00:00.5+02:00 --window='2024-06-01 09:15' arg1")
+	if ts, _ := cfg.GetTime("start"); !ts.Equal(time.Date(2024, 6, 1, 10, 0, 0, 5e8, time.UTC)) {
+		t.Errorf("Unexpected time: %s", ts)
+	} else if _, offset := ts.Zone(); offset != 2*3600 {
+		t.Errorf("Expected offset to be retained, instead found %d", offset)
+	}
+	if ts, _ := cfg.GetTime("window"); !ts.Equal(time.Date(2024, 6, 1, 9, 15, 0, 0, eastern)) || ts.Location() != eastern {
+		t.Errorf("Unexpected time: %s", ts)
+	}
+	cfg = ParseFakeCLI(t, cmd, "mycommand --window=23:45 arg1")
+	if ts, _ := cfg.GetTime("window"); ts.Hour() != 23 || ts.Minute() != 45 {
+		t.Errorf("Unexpected time: %s", ts)
+	}
+
+	// Invalid values are rejected on the CLI, and by the getter for other sources
+	for _, cliArgs := range []string{"--start=2024-03-01", "--start=23:45", "--window=tomorrow"} {
+		_, err := ParseCLI(cmd, strings.Fields("mycommand arg1 "+cliArgs))
+		if ove, ok := err.(OptionValueError); !ok || ove.Location() != "CLI" {
+			t.Errorf("Expected OptionValueError from CLI for %s, instead found %v", cliArgs, err)
+		}
+	}
+	cfg.AddSource(SimpleSource{"visible": "2024-13-01T00:00:00Z"})
+	if _, err := cfg.GetTime("visible"); err == nil || !strings.Contains(err.Error(), "RFC 3339") {
+		t.Errorf("Expected error from GetTime, instead found %v", err)
+	}
+	if usage := cmd.Options()["start"].usageName(); usage != "start time" {
+		t.Errorf("Unexpected usage name %q", usage)
+	}
+
+	// Struct fields of type time.Time are supported
+	var settings struct {
+		Since time.Time `mybase:"since"`
+	}
+	settings.Since = defaultTime
+	cmd = simpleCommand()
+	cmd.AddOptionsFromStruct(&settings)
+	settings.Since = time.Time{}
+	cfg = ParseFakeCLI(t, cmd, "mycommand arg1")
+	if err := cfg.Unmarshal(&settings); err != nil || !settings.Since.Equal(defaultTime) {
+		t.Errorf("Unexpected result from Unmarshal: %s, %v", settings.Since, err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected SetTimeLayouts to panic on non-time option, but it did not")
+		}
+	}()
+	StringOption("foo", 0, "", "dummy").SetTimeLayouts(time.Kitchen)
+}
+
 func TestGetEnum(t *testing.T) {
 	optionValues := map[string]string{
 		"foo":   "bar",
@@ -205,8 +711,8 @@ func TestGetEnum(t *testing.T) {
 		t.Errorf("Expected BaR,nil; found %s,%s", value, err)
 	}
 	value, err = cfg.GetEnum("foo", "nope", "dope")
-	if value != "" || err == nil {
-		t.Errorf("Expected error, found %s,%s", value, err)
+	if ove, ok := err.(OptionValueError); value != "" || !ok || ove.Name != "foo" || ove.Value != "bar" {
+		t.Errorf("Expected OptionValueError, found %s,%v", value, err)
 	}
 	value, err = cfg.GetEnum("caps", "yelling", "shouting")
 	if value != "shouting" || err != nil {
@@ -218,6 +724,59 @@ func TestGetEnum(t *testing.T) {
 	}
 }
 
+func TestSetChoices(t *testing.T) {
+	cmd := simpleCommand()
+	cmd.AddOption(StringOption("format", 'f', "table", "dummy description").SetChoices("table", "json", "csv"))
+	cmd.AddOption(MultiOption("columns", 0, "", "dummy description").SetChoices("id", "name"))
+	cmd.AddOption(StringOption("output", 'o', "", "dummy description").SetValueHint("path"))
+	cmd.AddOption(EnumOption("level", 0, "", []string{"low", "high"}, "dummy description").SetValueHint("level"))
+
+	cfg := ParseFakeCLI(t, cmd, "mycommand --format=JSON --columns=id,NAME arg1")
+	if value, err := cfg.GetEnum("format"); value != "json" || err != nil {
+		t.Errorf("Expected GetEnum to return json,nil; instead found %q,%v", value, err)
+	}
+	for _, args := range []string{"--format=yaml", "--columns=id,bogus", "-fxml"} {
+		if _, err := ParseCLI(cmd, strings.Fields("mycommand "+args+" arg1")); err == nil {
+			t.Errorf("Expected error from args %q, but err was nil", args)
+		}
+	}
+
+	f, err := getParsedFile(cfg, false, "format=bogus\n")
+	if err == nil {
+		t.Errorf("Expected error from invalid choice in option file, but err was nil; file=%v", f)
+	}
+
+	var b bytes.Buffer
+	if err := cmd.WriteUsage(&b); err != nil {
+		t.Fatalf("Unexpected error from WriteUsage: %v", err)
+	}
+	for _, expected := range []string{"--format {table|json|csv}", "--columns {id|name}", "--output path", "--level level"} {
+		if !strings.Contains(b.String(), expected) {
+			t.Errorf("Expected usage to contain %q, but it did not:\n%s", expected, b.String())
+		}
+	}
+	script, err := cmd.GenerateCompletion("bash")
+	if err != nil {
+		t.Fatalf("Unexpected error from GenerateCompletion: %v", err)
+	} else if !strings.Contains(script, "'table json csv'") {
+		t.Errorf("Expected bash completion to offer choices, but it did not:\n%s", script)
+	}
+
+	expectPanic := func(fn func()) {
+		t.Helper()
+		defer func() {
+			if recover() == nil {
+				t.Error("Expected panic, but there was none")
+			}
+		}()
+		fn()
+	}
+	expectPanic(func() { StringOption("x", 0, "", "dummy").SetChoices() })
+	expectPanic(func() { BoolOption("x", 0, false, "dummy").SetChoices("a") })
+	expectPanic(func() { StringOption("x", 0, "c", "dummy").SetChoices("a", "b") })
+	expectPanic(func() { BoolOption("x", 0, false, "dummy").SetValueHint("a") })
+}
+
 func TestGetBytes(t *testing.T) {
 	optionValues := map[string]string{
 		"simple-ok":     "1234",
@@ -228,7 +787,12 @@ func TestGetBytes(t *testing.T) {
 		"megs1-ok":      "12M",
 		"megs2-ok":      "440mB",
 		"gigs-ok":       "4GB",
-		"tera-fail":     "55t",
+		"tera-ok":       "55t",
+		"frac1-ok":      "1.5G",
+		"frac2-ok":      ".25kb",
+		"frac-fail":     "1.3k",
+		"dot-fail":      ".",
+		"huge-fail":     "16777216T",
 		"blank-ok":      "",
 	}
 	cfg := simpleConfig(optionValues)
@@ -254,7 +818,12 @@ func TestGetBytes(t *testing.T) {
 		"megs1-ok":      12 * 1024 * 1024,
 		"megs2-ok":      440 * 1024 * 1024,
 		"gigs-ok":       4 * 1024 * 1024 * 1024,
-		"tera-fail":     0,
+		"tera-ok":       55 * 1024 * 1024 * 1024 * 1024,
+		"frac1-ok":      1536 * 1024 * 1024,
+		"frac2-ok":      256,
+		"frac-fail":     0,
+		"dot-fail":      0,
+		"huge-fail":     0,
 		"blank-ok":      0,
 	}
 	for name, expect := range expected {
@@ -279,8 +848,9 @@ func TestGetRegexp(t *testing.T) {
 	}
 
 	re, err = cfg.GetRegexp("invalid")
-	if re != nil || err == nil {
-		t.Errorf("Expected invalid regexp to return nil and err, instead returned %v, %v", re, err)
+	var perr ParseError
+	if re != nil || !errors.As(err, &perr) || perr.OptionName() != "invalid" {
+		t.Errorf("Expected invalid regexp to return nil and a ParseError, instead returned %v, %v", re, err)
 	}
 
 	re, err = cfg.GetRegexp("blank")
@@ -291,6 +861,74 @@ func TestGetRegexp(t *testing.T) {
 
 // simpleConfig returns a stub config based on a single map of key->value string
 // pairs. All keys in the map will automatically be considered valid options.
+// TestLateOptionRegistration simulates a plugin which contributes options
+// after the option file has already been parsed.
+func TestLateOptionRegistration(t *testing.T) {
+	cmd := simpleCommand()
+	cfg := ParseFakeCLI(t, cmd, "mycommand arg1")
+	cfg.DeferUnknownOptions = true
+	file := NewFile("/tmp/fake.cnf")
+	file.contents = "visible=foo\nlint-rules=strict\nloose-lint-level=3\n[mysection]\nlint-rules=lax\n"
+	file.read = true
+	if err := file.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error from Parse: %v", err)
+	}
+	cfg.AddSource(file)
+	if cfg.FindOption("lint-rules") != nil {
+		t.Fatal("Expected lint-rules to be unknown prior to registration")
+	}
+
+	// Conflicts with existing options should be detected, naming both declarers
+	err := cmd.RegisterOptions("lint", StringOption("lint-rules", 0, "", "dummy"), StringOption("visible", 0, "", "dummy"))
+	if err == nil || !strings.Contains(err.Error(), "lint") || !strings.Contains(err.Error(), "command mycommand") {
+		t.Errorf("Expected error naming both declarers, instead found %v", err)
+	}
+	if cmd.Options()["lint-rules"] != nil {
+		t.Error("Expected no options to be registered upon conflict")
+	}
+	err = cmd.RegisterOptions("lint", StringOption("lint-rules", 0, "", "dummy"), StringOption("lint-level", 0, "1", "dummy"))
+	if err != nil {
+		t.Fatalf("Unexpected error from RegisterOptions: %v", err)
+	}
+	err = cmd.RegisterOptions("style", StringOption("lint-level", 0, "", "dummy"))
+	if err == nil || !strings.Contains(err.Error(), "style") || !strings.Contains(err.Error(), "declared by lint") {
+		t.Errorf("Expected error naming both declarers, instead found %v", err)
+	}
+
+	if err := cfg.ReevaluateUnknowns(); err != nil {
+		t.Fatalf("Unexpected error from ReevaluateUnknowns: %v", err)
+	}
+	if value := cfg.Get("lint-rules"); value != "strict" {
+		t.Errorf("Expected lint-rules to be %q, instead found %q", "strict", value)
+	}
+	if value := cfg.Get("lint-level"); value != "3" {
+		t.Errorf("Expected lint-level to be %q, instead found %q", "3", value)
+	}
+	if err := file.UseSection("mysection"); err != nil {
+		t.Fatalf("Unexpected error from UseSection: %v", err)
+	}
+	cfg.MarkDirty()
+	if value := cfg.Get("lint-rules"); value != "lax" {
+		t.Errorf("Expected lint-rules to be %q, instead found %q", "lax", value)
+	}
+
+	// Keys which remain unknown should still result in errors, but only if not
+	// loose-prefixed
+	cfg = ParseFakeCLI(t, simpleCommand(), "mycommand arg1")
+	cfg.DeferUnknownOptions = true
+	file = NewFile("/tmp/fake.cnf")
+	file.contents = "visible=foo\nnope=1\nloose-alsonope=1\n"
+	file.read = true
+	if err := file.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error from Parse: %v", err)
+	}
+	cfg.AddSource(file)
+	err = cfg.ReevaluateUnknowns()
+	if one, ok := err.(OptionNotDefinedError); !ok || one.Name != "nope" || one.LineNumber != 2 {
+		t.Errorf("Expected OptionNotDefinedError for line 2, instead found %v", err)
+	}
+}
+
 func simpleConfig(values map[string]string) *Config {
 	cmd := NewCommand("test", "1.0", "this is for testing", nil)
 	for key := range values {
@@ -301,3 +939,504 @@ func simpleConfig(values map[string]string) *Config {
 	}
 	return NewConfig(cli, SimpleSource(values))
 }
+
+// TestConfigConcurrentAccess confirms that option lookups may be safely
+// interleaved with source additions and cache invalidations. This test is
+// most useful when run with the -race flag.
+func TestConfigConcurrentAccess(t *testing.T) {
+	cmd := simpleCommand()
+	cfg := ParseFakeCLI(t, cmd, "mycommand -s 'hello world' arg1")
+	file := NewFile("/tmp/fake.cnf")
+	file.contents = "visible=foo\nbool1\n[mysection]\nvisible=bar\n"
+	file.read = true
+	if err := file.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error from Parse: %v", err)
+	}
+	cfg.AddSource(file)
+
+	var wg sync.WaitGroup
+	for n := 0; n < 8; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				if cfg.Get("hasshort") != "hello world" {
+					t.Errorf("Unexpected value for hasshort: %q", cfg.Get("hasshort"))
+					return
+				}
+				if visible := cfg.Get("visible"); visible != "foo" && visible != "bar" && visible != "baz" {
+					t.Errorf("Unexpected value for visible: %q", visible)
+					return
+				}
+				cfg.GetBool("bool1")
+				cfg.Source("visible")
+				cfg.Changed("truthybool")
+				cfg.FindOption("bool2")
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			switch i % 4 {
+			case 0:
+				file.UseSection("mysection")
+			case 1:
+				file.SetOptionValue("mysection", "visible", "baz")
+			case 2:
+				cfg.AddSource(SimpleSource{"bool2": "1"})
+			default:
+				file.UseSection()
+			}
+			cfg.MarkDirty()
+		}
+	}()
+	wg.Wait()
+}
+
+// TestConfigNoticesFileEdits confirms that a Config automatically reflects
+// modifications to its File sources, even while other goroutines are reading
+// option values, without any call to MarkDirty.
+func TestConfigNoticesFileEdits(t *testing.T) {
+	cfg := ParseFakeCLI(t, simpleCommand(), "mycommand arg1")
+	file := NewFile("/tmp/fake.cnf")
+	file.contents = "visible=foo\n[mysection]\nbool1\n"
+	file.read = true
+	if err := file.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error from Parse: %v", err)
+	}
+	cfg.AddSource(file)
+	if cfg.Get("visible") != "foo" || cfg.GetBool("bool1") {
+		t.Fatalf("Unexpected initial values: visible=%q bool1=%t", cfg.Get("visible"), cfg.GetBool("bool1"))
+	}
+
+	var wg sync.WaitGroup
+	for n := 0; n < 4; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				if visible := cfg.Get("visible"); !strings.HasPrefix(visible, "foo") {
+					t.Errorf("Unexpected value for visible: %q", visible)
+					return
+				}
+				file.OptionValue("visible")
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		file.SetOptionValue("", "visible", "foo"+strconv.Itoa(i))
+	}
+	wg.Wait()
+	if visible := cfg.Get("visible"); visible != "foo99" {
+		t.Errorf("Expected Config to reflect final SetOptionValue, instead found %q", visible)
+	}
+
+	file.UseSection("mysection")
+	if !cfg.GetBool("bool1") {
+		t.Error("Expected Config to reflect UseSection")
+	}
+	file.UnsetOptionValue("mysection", "bool1")
+	if cfg.GetBool("bool1") {
+		t.Error("Expected Config to reflect UnsetOptionValue")
+	}
+}
+
+// rotatingSource is an ExpiringOptionValuer whose value for "visible" changes
+// on each query, and expires after ttl.
+type rotatingSource struct {
+	mu      sync.Mutex
+	queries int
+	ttl     time.Duration
+}
+
+func (src *rotatingSource) OptionValue(optionName string) (string, bool) {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	switch optionName {
+	case "visible":
+		src.queries++
+		return "rotated" + strconv.Itoa(src.queries), true
+	case "hidden":
+		return "static", true
+	}
+	return "", false
+}
+
+func (src *rotatingSource) OptionValueTTL(optionName string) time.Duration {
+	if optionName == "visible" {
+		return src.ttl
+	}
+	return 0
+}
+
+func TestExpiringOptionValuer(t *testing.T) {
+	cfg := ParseFakeCLI(t, simpleCommand(), "mycommand arg1")
+	src := &rotatingSource{ttl: time.Hour}
+	cfg.AddSource(src)
+	if visible := cfg.Get("visible"); visible != "rotated1" {
+		t.Fatalf("Unexpected initial value %q", visible)
+	}
+	if visible := cfg.Get("visible"); visible != "rotated1" {
+		t.Errorf("Expected value to be retained until expiration, instead found %q", visible)
+	}
+
+	// Once the value expires, the next lookup of any option should re-query
+	cfg.mu.Lock()
+	cfg.expiresAt = time.Now().Add(-time.Second)
+	cfg.mu.Unlock()
+	if hidden := cfg.Get("hidden"); hidden != "static" {
+		t.Errorf("Unexpected value for hidden: %q", hidden)
+	}
+	if visible := cfg.Get("visible"); visible != "rotated2" {
+		t.Errorf("Expected expired value to be re-queried, instead found %q", visible)
+	}
+
+	// Values with no TTL never expire
+	src.ttl = 0
+	cfg.MarkDirty()
+	if visible := cfg.Get("visible"); visible != "rotated3" {
+		t.Errorf("Expected re-queried value after MarkDirty, instead found %q", visible)
+	}
+	if !cfg.expiresAt.IsZero() {
+		t.Errorf("Expected no expiration for values without a TTL, instead found %v", cfg.expiresAt)
+	}
+	if visible := cfg.Get("visible"); visible != "rotated3" {
+		t.Errorf("Expected value to be retained, instead found %q", visible)
+	}
+
+	// A value which is overridden by a higher-precedence source does not cause
+	// expiration
+	src.ttl = time.Hour
+	cfg = ParseFakeCLI(t, simpleCommand(), "mycommand --visible=cli arg1", src)
+	if cfg.Get("visible") != "cli" || !cfg.expiresAt.IsZero() {
+		t.Errorf("Unexpected value %q or expiration %v", cfg.Get("visible"), cfg.expiresAt)
+	}
+}
+
+// versionedSource is a VersionedOptionValuer whose values may be replaced
+// after being added to a Config.
+type versionedSource struct {
+	mu      sync.Mutex
+	values  SimpleSource
+	version uint64
+}
+
+func (src *versionedSource) OptionValue(optionName string) (string, bool) {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	return src.values.OptionValue(optionName)
+}
+
+func (src *versionedSource) OptionValuesVersion() uint64 {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	return src.version
+}
+
+func (src *versionedSource) set(values map[string]string) {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	src.values = SimpleSource(values)
+	src.version++
+}
+
+func TestVersionedOptionValuer(t *testing.T) {
+	src := &versionedSource{values: SimpleSource{"visible": "one"}}
+	cfg := ParseFakeCLI(t, simpleCommand(), "mycommand arg1", src)
+	if visible := cfg.Get("visible"); visible != "one" {
+		t.Fatalf("Unexpected initial value %q", visible)
+	}
+
+	// Changing the values without changing the version is not detected
+	src.values = SimpleSource{"visible": "two"}
+	if visible := cfg.Get("visible"); visible != "one" {
+		t.Errorf("Expected cached value to be retained, instead found %q", visible)
+	}
+
+	src.set(map[string]string{"visible": "three", "hasshort": "four"})
+	if visible, hasshort := cfg.Get("visible"), cfg.Get("hasshort"); visible != "three" || hasshort != "four" {
+		t.Errorf("Expected new version's values to be reflected, instead found visible=%q hasshort=%q", visible, hasshort)
+	}
+}
+
+func BenchmarkConfigGet(b *testing.B) {
+	cfg := simpleConfig(map[string]string{"foo": "bar", "baz": "'quoted'"})
+	cfg.Get("foo")
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		cfg.Get("foo")
+		cfg.Get("baz")
+	}
+}
+
+func BenchmarkConfigGetParallel(b *testing.B) {
+	cfg := simpleConfig(map[string]string{"foo": "bar", "baz": "'quoted'"})
+	cfg.Get("foo")
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			cfg.Get("foo")
+			cfg.Get("baz")
+		}
+	})
+}
+
+func BenchmarkConfigGetManyFiles(b *testing.B) {
+	cmd := simpleCommand()
+	cfg, err := ParseCLI(cmd, []string{"mycommand", "arg1"})
+	if err != nil {
+		b.Fatalf("Unexpected error from ParseCLI: %v", err)
+	}
+	for n := 0; n < 20; n++ {
+		f := NewFile("/tmp", "fake"+strconv.Itoa(n)+".cnf")
+		f.contents, f.read = "visible="+strconv.Itoa(n)+"\n", true
+		if err := f.Parse(cfg); err != nil {
+			b.Fatalf("Unexpected error from Parse: %v", err)
+		}
+		cfg.AddSource(f)
+	}
+	cfg.Get("visible")
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		cfg.Get("visible")
+		cfg.Get("hidden")
+	}
+}
+
+func TestCloneWithOverrides(t *testing.T) {
+	cmd := simpleCommand()
+	cmd.AddOption(StringOption("host", 'H', "localhost", "dummy description").AddAlias("server"))
+	cfg := ParseFakeCLI(t, cmd, "mycommand --host=db1 --visible=hello arg1")
+
+	var wg sync.WaitGroup
+	clones := make([]*Config, 3)
+	for n := range clones {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			clones[n] = cfg.CloneWithOverrides(map[string]string{"server": "'db" + strconv.Itoa(n+2) + "'"})
+			clones[n].Get("host")
+		}(n)
+	}
+	wg.Wait()
+	for n, clone := range clones {
+		if expected := "db" + strconv.Itoa(n+2); clone.Get("host") != expected || clone.Get("visible") != "hello" {
+			t.Errorf("Unexpected values in clone %d: host=%q visible=%q", n, clone.Get("host"), clone.Get("visible"))
+		}
+		if source := clone.Source("host"); source == cfg.CLI {
+			t.Errorf("Expected override to take precedence over CLI in clone %d", n)
+		}
+	}
+	if cfg.Get("host") != "db1" {
+		t.Errorf("Expected original Config to be unaffected by overrides, but host=%q", cfg.Get("host"))
+	}
+
+	// Overrides are retained when cloning again, unless replaced
+	clone := clones[0].CloneWithOverrides(map[string]string{"visible": "goodbye"})
+	if clone.Get("host") != "db2" || clone.Get("visible") != "goodbye" || clones[0].Get("visible") != "hello" {
+		t.Errorf("Unexpected values in nested clone: host=%q visible=%q", clone.Get("host"), clone.Get("visible"))
+	}
+	if clone.Clone().Get("host") != "db2" {
+		t.Error("Expected Clone to retain overrides")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected CloneWithOverrides to panic on unknown option, but it did not")
+		}
+	}()
+	cfg.CloneWithOverrides(map[string]string{"doesnt-exist": "1"})
+}
+
+func TestSensitiveOption(t *testing.T) {
+	const password = "hunter2"
+	assertRedacted := func(err error) {
+		t.Helper()
+		if err == nil {
+			t.Fatal("Expected error, but err is nil")
+		} else if msg := err.Error(); strings.Contains(msg, password) || !strings.Contains(msg, redactedValue) {
+			t.Errorf("Expected sensitive value to be redacted in error message, instead found %q", msg)
+		}
+	}
+	newCommand := func() *Command {
+		cmd := simpleCommand()
+		cmd.AddOption(StringOption("password", 'p', "", "dummy description").Sensitive().SetValidator(func(name, value string) error {
+			if len(value) < 10 {
+				return errors.New("too short")
+			}
+			return nil
+		}))
+		cmd.AddOption(StringOption("api-key", 0, "", "dummy description").Secret().SetTransform(func(value string, ctx TransformContext) (string, error) {
+			if value != "" && !strings.HasPrefix(value, "key-") {
+				return "", errors.New("missing key- prefix")
+			}
+			return value, nil
+		}))
+		cmd.AddOption(StringOption("timeout", 0, "5s", "dummy description").SetType(OptionTypeDuration).Sensitive())
+		return cmd
+	}
+
+	// Validator failure: error redacted, but value still available
+	cfg := ParseFakeCLI(t, newCommand(), "mycommand --password="+password+" arg1")
+	assertRedacted(cfg.CheckValues())
+	if cfg.Get("password") != password {
+		t.Errorf("Expected Get to return actual value, instead found %q", cfg.Get("password"))
+	}
+	var b bytes.Buffer
+	if err := cfg.Explain(&b); err != nil {
+		t.Fatalf("Unexpected error from Explain: %v", err)
+	} else if strings.Contains(b.String(), password) || !strings.Contains(b.String(), redactedValue) {
+		t.Errorf("Expected sensitive value to be redacted in Explain output:\n%s", b.String())
+	}
+
+	// Transform failure, with sensitivity implied by Secret
+	cfg = ParseFakeCLI(t, newCommand(), "mycommand --api-key="+password+" arg1")
+	assertRedacted(cfg.CheckValues())
+
+	// Type check failure on the command-line, in a file, and in a getter
+	_, err := ParseCLI(newCommand(), []string{"mycommand", "--timeout=" + password, "arg1"})
+	assertRedacted(err)
+	cfg = ParseFakeCLI(t, newCommand(), "mycommand arg1")
+	file := NewFile("/etc/myconfigs", "my.cnf")
+	file.contents = "timeout=" + password + "\n"
+	file.read = true
+	assertRedacted(file.Parse(cfg))
+	cfg.CLI.OptionValues["timeout"] = password
+	cfg.MarkDirty()
+	_, err = cfg.GetDuration("timeout")
+	assertRedacted(err)
+}
+
+func TestMultiOption(t *testing.T) {
+	cmd := simpleCommand()
+	cmd.AddOption(MultiOption("ignore-table", 'i', "", "dummy description").AddAlias("exclude-table"))
+	cmd.AddOption(MultiOption("init-command", 0, "SET a=1", "dummy description").SetDelimiter(';'))
+
+	assertMulti := func(cfg *Config, name string, expected ...string) {
+		t.Helper()
+		if actual := cfg.GetMulti(name); !reflect.DeepEqual(actual, expected) && (len(actual) > 0 || len(expected) > 0) {
+			t.Errorf("Expected GetMulti(%q) to return %q, instead found %q", name, expected, actual)
+		}
+	}
+
+	cfg := ParseFakeCLI(t, cmd, "mycommand arg1")
+	assertMulti(cfg, "ignore-table")
+	assertMulti(cfg, "init-command", "SET a=1")
+
+	cfg = ParseFakeCLI(t, cmd, "mycommand --ignore-table=foo -i bar,baz --exclude-table 'a b' --init-command='SET b=2;SET c=3' arg1")
+	assertMulti(cfg, "ignore-table", "foo", "bar", "baz", "a b")
+	assertMulti(cfg, "init-command", "SET b=2", "SET c=3")
+	cfg = ParseFakeCLI(t, cmd, "mycommand -ifoo --ignore-table= -ibar arg1")
+	assertMulti(cfg, "ignore-table", "bar")
+
+	// Repeated lines accumulate within a section, but the command-line replaces
+	// the file's values entirely
+	cfg = ParseFakeCLI(t, cmd, "mycommand arg1")
+	file := NewFile("/etc/myconfigs", "my.cnf")
+	file.contents = "ignore-table=foo\nignore-table='x,y'\nexclude-table=bar\n\n[other]\nignore-table=nope\n"
+	file.read = true
+	if err := file.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error from Parse: %v", err)
+	}
+	cfg.AddSource(file)
+	assertMulti(cfg, "ignore-table", "foo", "x,y", "bar")
+	cfg = ParseFakeCLI(t, cmd, "mycommand --ignore-table=cli arg1")
+	cfg.AddSource(file)
+	assertMulti(cfg, "ignore-table", "cli")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected SetDelimiter to panic on non-multi option, but it did not")
+		}
+	}()
+	StringOption("foo", 0, "", "dummy description").SetDelimiter(';')
+}
+
+func TestMapOption(t *testing.T) {
+	cmd := simpleCommand()
+	cmd.AddOption(MapOption("connect-option", 'o', "timeout=5", "dummy description"))
+	cmd.AddOption(MapOption("labels", 0, "", "dummy description").SetDelimiter(';'))
+
+	assertMap := func(cfg *Config, name string, expected map[string]string) {
+		t.Helper()
+		if actual := cfg.GetMap(name); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("Expected GetMap(%q) to return %v, instead found %v", name, expected, actual)
+		}
+	}
+
+	cfg := ParseFakeCLI(t, cmd, "mycommand arg1")
+	assertMap(cfg, "connect-option", map[string]string{"timeout": "5"})
+	assertMap(cfg, "labels", map[string]string{})
+
+	cfg = ParseFakeCLI(t, cmd, "mycommand --connect-option 'charset=utf8mb4, timeout = 10' -osql_mode=\"'A,B'\" --labels='a=1;b=x=y' arg1")
+	assertMap(cfg, "connect-option", map[string]string{"charset": "utf8mb4", "timeout": "10", "sql_mode": "A,B"})
+	assertMap(cfg, "labels", map[string]string{"a": "1", "b": "x=y"})
+
+	// Repeated lines in an option file accumulate, and a fully quote-wrapped
+	// value is unwrapped before splitting
+	cfg = ParseFakeCLI(t, cmd, "mycommand arg1")
+	file := NewFile("/etc/myconfigs", "my.cnf")
+	file.contents = "connect-option='a=1,b=2'\nconnect-option=c=3\n"
+	file.read = true
+	if err := file.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error from Parse: %v", err)
+	}
+	cfg.AddSource(file)
+	assertMap(cfg, "connect-option", map[string]string{"a": "1", "b": "2", "c": "3"})
+
+	// Entries lacking a key are rejected
+	for _, value := range []string{"novalue", "a=1,=2", "a=1,b"} {
+		if _, err := ParseCLI(cmd, []string{"mycommand", "--connect-option=" + value, "arg1"}); err == nil {
+			t.Errorf("Expected error for --connect-option=%s, but err is nil", value)
+		}
+	}
+}
+
+func TestDefaultFunc(t *testing.T) {
+	var calls int
+	cmd := simpleCommand()
+	cmd.AddOption(StringOption("datadir", 0, "/var/lib/mysql", "dummy description"))
+	cmd.AddOption(StringOption("socket", 0, "", "dummy description").SetDefaultFunc(func(cfg *Config) string {
+		calls++
+		return cfg.Get("datadir") + "/mysql.sock"
+	}))
+	cmd.AddOption(StringOption("upper-socket", 0, "", "dummy description").SetDefaultFunc(func(cfg *Config) string {
+		return "'" + cfg.Get("socket") + "'"
+	}).SetTransform(func(value string, ctx TransformContext) (string, error) {
+		return strings.ToUpper(value), nil
+	}))
+
+	cfg := ParseFakeCLI(t, cmd, "mycommand arg1")
+	if actual := cfg.Get("socket"); actual != "/var/lib/mysql/mysql.sock" {
+		t.Errorf("Unexpected value for socket: %q", actual)
+	}
+	if actual := cfg.Get("upper-socket"); actual != "/VAR/LIB/MYSQL/MYSQL.SOCK" {
+		t.Errorf("Unexpected value for upper-socket: %q", actual)
+	}
+	if actual := cfg.GetRaw("upper-socket"); actual != "'/var/lib/mysql/mysql.sock'" {
+		t.Errorf("Unexpected raw value for upper-socket: %q", actual)
+	}
+	if calls != 1 {
+		t.Errorf("Expected DefaultFunc to be called once, instead called %d times", calls)
+	}
+	if cfg.Supplied("socket") || cfg.Changed("socket") || cfg.Changed("upper-socket") {
+		t.Error("Expected options using DefaultFunc to be neither supplied nor changed")
+	}
+
+	// Default is recomputed when sources change
+	cfg.AddSource(SimpleSource(map[string]string{"datadir": "/data"}))
+	if actual := cfg.Get("socket"); actual != "/data/mysql.sock" || calls != 2 {
+		t.Errorf("Unexpected value for socket after adding source: %q (calls=%d)", actual, calls)
+	}
+
+	// Supplied values take precedence, and the func is not called
+	cfg = ParseFakeCLI(t, cmd, "mycommand --socket=/tmp/mysql.sock arg1")
+	if actual := cfg.Get("socket"); actual != "/tmp/mysql.sock" || calls != 2 {
+		t.Errorf("Unexpected value for socket: %q (calls=%d)", actual, calls)
+	}
+	if !cfg.Changed("socket") {
+		t.Error("Expected socket to be changed")
+	}
+}
@@ -0,0 +1,93 @@
+package mybase
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// DecryptorFunc decrypts the payload of an encrypted option file value,
+// returning the plaintext value.
+type DecryptorFunc func(payload string) (string, error)
+
+var (
+	decryptorsMu sync.RWMutex
+	decryptors   = make(map[string]DecryptorFunc)
+)
+
+// RegisterDecryptor permits option files to contain encrypted values, so that
+// secrets such as passwords need not be stored in plaintext. Once a decryptor
+// is registered for a scheme, any unquoted option file value of the form
+// "!scheme:payload" is passed to fn at parse time, and the option is set to the
+// resulting plaintext. For example, after RegisterDecryptor("vault", fn), the
+// line "password=!vault:secret/db" sets the password option to the result of
+// fn("secret/db"). This applies to option files in any FileFormat. Values
+// using a scheme without a registered decryptor, as well as quoted values, are
+// left as-is.
+//
+// Scheme names are case-sensitive, and may only contain letters, digits,
+// hyphens, and underscores; otherwise RegisterDecryptor panics. Registering a
+// scheme again replaces its previous decryptor, and registering a nil fn
+// removes it. Files which have already been parsed are unaffected.
+//
+// When a File containing decrypted values is written, each decrypted value
+// which has not since been modified is written in its original encrypted form.
+func RegisterDecryptor(scheme string, fn DecryptorFunc) {
+	if !validDecryptorScheme(scheme) {
+		panic(fmt.Errorf("RegisterDecryptor: invalid scheme name %q", scheme))
+	}
+	decryptorsMu.Lock()
+	defer decryptorsMu.Unlock()
+	if fn == nil {
+		delete(decryptors, scheme)
+	} else {
+		decryptors[scheme] = fn
+	}
+}
+
+// validDecryptorScheme returns true if scheme is non-empty and consists only
+// of letters, digits, hyphens, and underscores.
+func validDecryptorScheme(scheme string) bool {
+	if scheme == "" {
+		return false
+	}
+	for _, r := range scheme {
+		if !(r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// decryptValue examines a raw value from an option file line. If it has the
+// form "!scheme:payload" and a decryptor is registered for scheme, the
+// decrypted plaintext is returned, quoted as needed for storage in a Section's
+// Values, along with true. Otherwise the returned bool is false.
+func decryptValue(value string) (string, bool, error) {
+	if !strings.HasPrefix(value, "!") {
+		return "", false, nil
+	}
+	colon := strings.IndexByte(value, ':')
+	if colon < 0 {
+		return "", false, nil
+	}
+	scheme, payload := value[1:colon], value[colon+1:]
+	decryptorsMu.RLock()
+	fn := decryptors[scheme]
+	decryptorsMu.RUnlock()
+	if fn == nil {
+		return "", false, nil
+	}
+	plaintext, err := fn(payload)
+	if err != nil {
+		return "", true, fmt.Errorf("unable to decrypt value using %s: %s", scheme, err)
+	}
+	return QuoteValue(plaintext), true, nil
+}
+
+// encryptedValue tracks the original form of an option file value which was
+// decrypted at parse time.
+type encryptedValue struct {
+	ciphertext string // raw value from the option file
+	value      string // corresponding decrypted value stored in Section.Values
+}
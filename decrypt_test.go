@@ -0,0 +1,108 @@
+package mybase
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRegisterDecryptor(t *testing.T) {
+	// Test decryptor just reverses the payload, and fails on an empty payload
+	RegisterDecryptor("test", func(payload string) (string, error) {
+		if payload == "" {
+			return "", errors.New("empty payload")
+		} else if payload == "special" {
+			return `it's "#special"`, nil
+		}
+		runes := []rune(payload)
+		for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+			runes[i], runes[j] = runes[j], runes[i]
+		}
+		return string(runes), nil
+	})
+	defer RegisterDecryptor("test", nil)
+
+	cmd := simpleCommand()
+	cmd.AddOption(MultiOption("multi", 0, "", "dummy description"))
+	cfg := ParseFakeCLI(t, cmd, "mycommand arg1")
+	contents := "hasshort='!test:quoted'\nvisible=!test:special\n\n[foo]\nhasshort=!other:unregistered\nvisible=!test:olleh\n"
+	f, err := getParsedFile(cfg, false, contents)
+	if err != nil {
+		t.Fatalf("Unexpected error from getParsedFile: %v", err)
+	}
+	f.UseSection("foo")
+	cfg.AddSource(f)
+	if actual := cfg.Get("visible"); actual != "hello" {
+		t.Errorf("Expected decrypted value %q, instead found %q", "hello", actual)
+	}
+	if actual := cfg.Get("hasshort"); actual != "!other:unregistered" {
+		t.Errorf("Expected value with unregistered scheme to be left as-is, instead found %q", actual)
+	}
+	if actual, _ := f.OptionValue("hasshort"); actual != "!other:unregistered" {
+		t.Errorf("Expected value with unregistered scheme to be left as-is, instead found %q", actual)
+	}
+	if actual := f.SectionValues("")["hasshort"]; unquote(actual) != "!test:quoted" {
+		t.Errorf("Expected quoted value to be left as-is, instead found %q", actual)
+	}
+	if actual := unquote(f.SectionValues("")["visible"]); actual != `it's "#special"` {
+		t.Errorf("Expected decrypted value %q, instead found %q", `it's "#special"`, actual)
+	}
+
+	// Writing should retain the encrypted form of unmodified values, in any
+	// format
+	if actual, _ := f.renderContents(); actual != contents {
+		t.Errorf("Unexpected rendered contents:\n%s\nexpected:\n%s", actual, contents)
+	}
+	f.Syntax = FileFormatJSON
+	if actual, _ := f.renderContents(); !strings.Contains(actual, `"visible": "!test:olleh"`) {
+		t.Errorf("Unexpected rendered JSON contents:\n%s", actual)
+	}
+	f.Syntax = FileFormatINI
+	f.SetOptionValue("foo", "visible", "changed")
+	if actual, _ := f.renderContents(); !strings.Contains(actual, "visible=changed") || strings.Contains(actual, "olleh") {
+		t.Errorf("Expected modified value to be written as-is, instead found contents:\n%s", actual)
+	}
+
+	// Values decrypted from JSON files are handled the same way
+	f = NewFile("/tmp/fake.json")
+	f.contents = `{"visible": "!test:dlrow"}`
+	f.read = true
+	if err := f.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error from Parse: %v", err)
+	}
+	if actual, _ := f.OptionValue("visible"); actual != "world" {
+		t.Errorf("Expected decrypted value %q, instead found %q", "world", actual)
+	}
+
+	// Decryption errors, and encrypted values for accumulating options, are
+	// rejected
+	for _, contents := range []string{"visible=!test:", "multi=!test:abc"} {
+		if _, err := getParsedFile(cfg, false, contents); err == nil {
+			t.Errorf("Expected error parsing %q, but err is nil", contents)
+		} else if _, ok := err.(OptionValueError); !ok {
+			t.Errorf("Expected error parsing %q to be OptionValueError, instead found %T", contents, err)
+		}
+	}
+
+	// Unregistering a decryptor leaves subsequently-parsed values as-is
+	RegisterDecryptor("test", nil)
+	f, err = getParsedFile(cfg, false, "visible=!test:olleh")
+	if err != nil {
+		t.Fatalf("Unexpected error from getParsedFile: %v", err)
+	}
+	if actual, _ := f.OptionValue("visible"); actual != "!test:olleh" {
+		t.Errorf("Expected value to be left as-is after unregistering decryptor, instead found %q", actual)
+	}
+
+	// Invalid scheme names should panic
+	for _, scheme := range []string{"", "a:b", "a b"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected RegisterDecryptor to panic with scheme %q, but it did not", scheme)
+				}
+			}()
+			RegisterDecryptor(scheme, nil)
+		}()
+	}
+}
@@ -0,0 +1,98 @@
+package mybase
+
+import (
+	"fmt"
+	"sort"
+)
+
+// OptionDiff describes an option or positional arg whose effective value
+// differs between two Configs, as returned by Config.Diff.
+type OptionDiff struct {
+	Name        string       // canonical name of the option or arg
+	Value       string       // value in the Config that Diff was called on, as returned by Config.Get
+	OtherValue  string       // value in the Config passed to Diff, as returned by Config.Get
+	Source      OptionValuer // source of Value; nil if the option does not exist in that Config
+	OtherSource OptionValuer // source of OtherValue; nil if the option does not exist in that Config
+	opt         *Option
+}
+
+// String returns a human-readable description of the difference, in the same
+// format as the value and source columns of Config.Explain. Values of options
+// marked with Option.Sensitive are redacted.
+func (d OptionDiff) String() string {
+	return fmt.Sprintf("%s: %s => %s", d.Name, d.describe(d.Value, d.Source), d.describe(d.OtherValue, d.OtherSource))
+}
+
+// describe returns a description of one side of the difference.
+func (d OptionDiff) describe(value string, source OptionValuer) string {
+	if source == nil {
+		return "(not present)"
+	}
+	return fmt.Sprintf("%s (%s)", d.opt.displayValue(explainOptionValue(d.opt, value)), explainOptionSource(d.opt, source))
+}
+
+// Diff compares the effective values of cfg against those of other, returning
+// an OptionDiff for each option or positional arg whose value differs,
+// ordered by name. Values are compared as returned by Config.Get, so they
+// reflect any transform functions and variable expansion; boolean options are
+// compared by truthiness, so "1" and "true" are considered equal. Options
+// which only exist in one of the two Configs' commands are included, with a
+// nil source on the other side. The built-in help and version options are
+// never included. Sources which merely supply identical values are not
+// considered differences.
+//
+// This is useful for comparing an intended configuration against a running
+// one, or a configuration before and after some change.
+func (cfg *Config) Diff(other *Config) []OptionDiff {
+	options := diffableOptions(cfg)
+	otherOptions := diffableOptions(other)
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	for name := range otherOptions {
+		if _, ok := options[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var result []OptionDiff
+	for _, name := range names {
+		d := OptionDiff{Name: name}
+		opt, otherOpt := options[name], otherOptions[name]
+		if opt != nil {
+			d.opt, d.Value, d.Source = opt, cfg.Get(name), cfg.Source(name)
+		}
+		if otherOpt != nil {
+			d.OtherValue, d.OtherSource = other.Get(name), other.Source(name)
+			if d.opt == nil {
+				d.opt = otherOpt
+			}
+		}
+		if opt != nil && otherOpt != nil {
+			if opt.Type == OptionTypeBool && otherOpt.Type == OptionTypeBool {
+				if BoolValue(d.Value) == BoolValue(d.OtherValue) {
+					continue
+				}
+			} else if d.Value == d.OtherValue {
+				continue
+			}
+		}
+		result = append(result, d)
+	}
+	return result
+}
+
+// diffableOptions returns the options and positional args of cfg's command,
+// keyed by name, omitting the built-in help and version options.
+func diffableOptions(cfg *Config) map[string]*Option {
+	result := cfg.CLI.Command.Options()
+	delete(result, "help")
+	delete(result, "help-all")
+	delete(result, "version")
+	for _, arg := range cfg.CLI.Command.args {
+		result[arg.Name] = arg
+	}
+	return result
+}
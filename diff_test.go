@@ -0,0 +1,59 @@
+package mybase
+
+import (
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	cmd := simpleCommand()
+	cmd.AddOption(StringOption("password", 0, "", "dummy description").Sensitive())
+	intended := ParseFakeCLI(t, cmd, "mycommand --visible=foo --password=a --bool1 arg1")
+	running := ParseFakeCLI(t, cmd, "mycommand --bool1=true --password=b arg2")
+	file := NewFile("/etc/myconfigs", "my.cnf")
+	file.contents = "visible=bar\ntruthybool=0\n"
+	file.read = true
+	if err := file.Parse(running); err != nil {
+		t.Fatalf("Unexpected error from Parse: %v", err)
+	}
+	running.AddSource(file)
+
+	diffs := intended.Diff(running)
+	expected := []string{
+		"password: <redacted> (command line) => <redacted> (command line)",
+		"required: arg1 (command line) => arg2 (command line)",
+		"truthybool: true (default value) => false (/etc/myconfigs/my.cnf line 2)",
+		"visible: foo (command line) => bar (/etc/myconfigs/my.cnf line 1)",
+	}
+	if len(diffs) != len(expected) {
+		t.Fatalf("Expected %d diffs, instead found %d: %v", len(expected), len(diffs), diffs)
+	}
+	for n, d := range diffs {
+		if d.String() != expected[n] {
+			t.Errorf("Unexpected diff[%d]: expected %q, found %q", n, expected[n], d.String())
+		}
+	}
+	if diffs[3].Value != "foo" || diffs[3].OtherValue != "bar" || diffs[3].Source != intended.CLI || diffs[3].OtherSource != file {
+		t.Errorf("Unexpected fields in diff: %+v", diffs[3])
+	}
+
+	// Options only present in one Config's command are included
+	suite := simpleCommandSuite()
+	suite.SubCommands["one"].AddOption(StringOption("only-one", 0, "x", "dummy description"))
+	one := ParseFakeCLI(t, suite, "mycommand one")
+	two := ParseFakeCLI(t, suite, "mycommand two")
+	var found bool
+	for _, d := range one.Diff(two) {
+		if d.Name == "only-one" {
+			found = true
+			if d.OtherSource != nil || d.String() != "only-one: x (default value) => (not present)" {
+				t.Errorf("Unexpected diff: %+v", d)
+			}
+		}
+	}
+	if !found {
+		t.Error("Expected Diff to include option only present in one Config, but it did not")
+	}
+	if diffs = one.Diff(one); len(diffs) != 0 {
+		t.Errorf("Expected Config to have no differences with itself, instead found %v", diffs)
+	}
+}
@@ -0,0 +1,234 @@
+package mybase
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DirSource is an option source which reads a directory containing one file
+// per option: each file's name is an option name, and its contents are the
+// option's value, with a single trailing newline removed if present. This is
+// the layout of a Kubernetes ConfigMap or Secret mounted as a volume, allowing
+// programs to use one directly, without assembling an option file.
+//
+// Files and directories whose names begin with "." are ignored, which includes
+// the "..data" symlinks used by Kubernetes to atomically swap a volume's
+// contents. If SubdirSections is true, each subdirectory supplies the values
+// of the option file section of the same name, which may be selected via
+// UseSection; otherwise subdirectories are ignored.
+type DirSource struct {
+	Dir                  string // directory to read
+	SubdirSections       bool   // if true, each subdirectory supplies the values of a section
+	IgnoreUnknownOptions bool   // if true, files whose names are not options are ignored, rather than causing Load to fail
+	mu                   sync.RWMutex
+	file                 *File    // values as of the most recent successful Load
+	contents             string   // option file representation of file's values, for detecting changes
+	selected             []string // section names supplied to UseSection
+}
+
+// NewDirSource returns a DirSource which reads the supplied directory. The
+// source must be loaded via Load prior to use.
+func NewDirSource(dir string) *DirSource {
+	return &DirSource{Dir: dir}
+}
+
+// Load reads the directory, using cfg to validate option names. An error is
+// returned if the directory cannot be read, or if a file's name is not the
+// name of an option and ds.IgnoreUnknownOptions is false. If the source was
+// already added to a Config, the Config automatically reflects any changed
+// values after a successful Load.
+func (ds *DirSource) Load(cfg *Config) error {
+	contents, err := ds.readDir(cfg)
+	if err != nil {
+		return err
+	}
+	ds.mu.RLock()
+	unchanged := ds.file != nil && contents == ds.contents
+	selected := ds.selected
+	ds.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	f := NewFile(ds.Dir)
+	f.IgnoreUnknownOptions = ds.IgnoreUnknownOptions
+	if err := f.ParseReader(cfg, strings.NewReader(contents)); err != nil {
+		return err
+	}
+	if len(selected) > 0 {
+		f.UseSection(selected...) // missing sections are permitted, since they may appear later
+	}
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.file, ds.contents = f, contents
+	return nil
+}
+
+// readDir returns the directory's values in the form of an option file.
+func (ds *DirSource) readDir(cfg *Config) (string, error) {
+	var b strings.Builder
+	if err := ds.readValues(cfg, ds.Dir, &b); err != nil {
+		return "", err
+	}
+	if !ds.SubdirSections {
+		return b.String(), nil
+	}
+	for _, name := range ds.subdirs() {
+		if strings.ContainsAny(name, "[]#") {
+			return "", fmt.Errorf("%s: Subdirectory name %q cannot be used as a section name", ds.Dir, name)
+		}
+		fmt.Fprintf(&b, "[%s]\n", name)
+		if err := ds.readValues(cfg, filepath.Join(ds.Dir, name), &b); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+// readValues writes an option file line to b for each file in dir, in order
+// by name.
+func (ds *DirSource) readValues(cfg *Config, dir string, b *strings.Builder) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		} else if fi, err := os.Stat(path); err != nil || !fi.Mode().IsRegular() {
+			continue // subdirectory, or dangling symlink
+		}
+		name, _, _, _ := NormalizeOptionToken(entry.Name())
+		if cfg.FindOption(name) == nil {
+			if ds.IgnoreUnknownOptions {
+				continue
+			}
+			return fmt.Errorf("%s: Unknown option %q", path, entry.Name())
+		}
+		value, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(b, "%s=%s\n", name, QuoteValue(strings.TrimSuffix(string(value), "\n")))
+	}
+	return nil
+}
+
+// subdirs returns the names of subdirectories of ds.Dir, in sorted order.
+func (ds *DirSource) subdirs() []string {
+	entries, _ := ioutil.ReadDir(ds.Dir)
+	var names []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if fi, err := os.Stat(filepath.Join(ds.Dir, entry.Name())); err == nil && fi.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// UseSection changes which sections, corresponding to subdirectories, are used
+// when obtaining option values. See File.UseSection. The selection persists
+// across subsequent calls to Load. Since subdirectories may be created after
+// the source is loaded, a SectionNotFoundError is returned if a section does
+// not currently exist, but the selection still takes effect.
+func (ds *DirSource) UseSection(names ...string) error {
+	ds.mu.Lock()
+	f := ds.file
+	if f == nil {
+		ds.mu.Unlock()
+		panic(fmt.Errorf("Call to UseSection on DirSource %s which has not been loaded", ds.Dir))
+	}
+	ds.selected = names
+	ds.mu.Unlock()
+	return f.UseSection(names...)
+}
+
+// OptionValue satisfies the OptionValuer interface. Panics if the source has
+// not been loaded yet, since this is indicative of programmer error.
+func (ds *DirSource) OptionValue(optionName string) (string, bool) {
+	f := ds.loadedFile()
+	if f == nil {
+		panic(fmt.Errorf("Call to OptionValue(\"%s\") on DirSource %s which has not been loaded", optionName, ds.Dir))
+	}
+	return f.OptionValue(optionName)
+}
+
+// editGeneration satisfies the editTracker interface. Each Load which finds
+// changed values results in a different generation, since every File's
+// generation is unique.
+func (ds *DirSource) editGeneration() uint64 {
+	if f := ds.loadedFile(); f != nil {
+		return f.editGeneration()
+	}
+	return 0
+}
+
+// loadedFile returns the File holding the most recently loaded values, or nil
+// if the source has not been loaded yet.
+func (ds *DirSource) loadedFile() *File {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	return ds.file
+}
+
+func (ds *DirSource) String() string {
+	return ds.Dir
+}
+
+// Watch re-loads ds whenever the directory changes, until ctx is done. On
+// Linux, inotify is used to detect changes promptly, with the directory also
+// checked every interval as a fallback; on other platforms, the directory is
+// only checked every interval. After a Load which changes any values, any
+// callbacks registered via Config.Watch are called for options whose values
+// changed. Any error from Load is reported via cfg.Warn, and the previous
+// values are retained. This method blocks, so typically it should be run in a
+// separate goroutine.
+func (ds *DirSource) Watch(ctx context.Context, cfg *Config, interval time.Duration) {
+	changes, err := watchDirChanges(ctx, ds.watchPaths)
+	if err != nil {
+		cfg.Warn("Unable to watch %s for changes, checking every %s instead: %s", ds, interval, err)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case _, ok := <-changes:
+			if !ok {
+				changes = nil // watching failed; rely on the ticker from now on
+			}
+		}
+		watched, oldValues := cfg.watchedValues()
+		generation := ds.editGeneration()
+		if err := ds.Load(cfg); err != nil {
+			cfg.Warn("Unable to reload options from %s: %s", ds, err)
+		} else if ds.editGeneration() != generation {
+			cfg.runWatchers(watched, oldValues)
+		}
+	}
+}
+
+// watchPaths returns the directories which must be watched for changes.
+func (ds *DirSource) watchPaths() []string {
+	paths := []string{ds.Dir}
+	if ds.SubdirSections {
+		for _, name := range ds.subdirs() {
+			paths = append(paths, filepath.Join(ds.Dir, name))
+		}
+	}
+	return paths
+}
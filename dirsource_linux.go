@@ -0,0 +1,56 @@
+package mybase
+
+import (
+	"context"
+	"os"
+	"syscall"
+)
+
+// watchDirChanges returns a channel which receives a value whenever a file is
+// created, removed, renamed, or modified in any of the directories returned by
+// paths, using inotify. The directories are re-examined after each change, so
+// that any new subdirectories are also watched. The channel is closed once ctx
+// is done.
+func watchDirChanges(ctx context.Context, paths func() []string) (<-chan struct{}, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	const mask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_ATTRIB | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+	addWatches := func() error {
+		for _, path := range paths() {
+			if _, err := syscall.InotifyAddWatch(fd, path, mask); err != nil {
+				return &os.PathError{Op: "inotify_add_watch", Path: path, Err: err}
+			}
+		}
+		return nil
+	}
+	if err := addWatches(); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	// Since the descriptor is non-blocking, os.File uses the runtime poller,
+	// so closing it interrupts a pending Read
+	events := os.NewFile(uintptr(fd), "inotify")
+	go func() {
+		<-ctx.Done()
+		events.Close()
+	}()
+	changes := make(chan struct{}, 1)
+	go func() {
+		defer close(changes)
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			if _, err := events.Read(buf); err != nil {
+				return
+			}
+			addWatches() // best effort; subdirectories may be removed concurrently
+			select {
+			case changes <- struct{}{}:
+			default: // a change is already pending
+			}
+		}
+	}()
+	return changes, nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package mybase
+
+import "context"
+
+// watchDirChanges returns a nil channel on platforms other than Linux, since
+// inotify is not available. DirSource.Watch then relies on polling alone.
+func watchDirChanges(ctx context.Context, paths func() []string) (<-chan struct{}, error) {
+	return nil, nil
+}
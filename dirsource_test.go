@@ -0,0 +1,114 @@
+package mybase
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDirSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mybasetest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	writeFile := func(name, contents string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Unable to create dir: %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatalf("Unable to write file: %v", err)
+		}
+	}
+	writeFile("visible", "it's #1\n")
+	writeFile("bool1", "true")
+	writeFile(".hidden", "ignored")
+	writeFile("prod/hasshort", "multi\nline\n")
+	writeFile("prod/visible", "prod")
+
+	cfg := ParseFakeCLI(t, simpleCommand(), "mycommand arg1")
+	ds := NewDirSource(dir)
+	if err := ds.Load(cfg); err != nil {
+		t.Fatalf("Unexpected error from Load: %v", err)
+	}
+	cfg.AddSource(ds)
+	if cfg.Get("visible") != "it's #1" || !cfg.GetBool("bool1") || cfg.Changed("hasshort") || cfg.Get("hidden") != "somedefault" {
+		t.Errorf("Unexpected values: visible=%q bool1=%t hasshort=%q hidden=%q", cfg.Get("visible"), cfg.GetBool("bool1"), cfg.Get("hasshort"), cfg.Get("hidden"))
+	}
+
+	// Subdirectories supply sections, if enabled
+	ds = NewDirSource(dir)
+	ds.SubdirSections = true
+	cfg = ParseFakeCLI(t, simpleCommand(), "mycommand arg1", ds)
+	if err := ds.Load(cfg); err != nil {
+		t.Fatalf("Unexpected error from Load: %v", err)
+	}
+	if err := ds.UseSection("prod"); err != nil {
+		t.Fatalf("Unexpected error from UseSection: %v", err)
+	}
+	cfg.MarkDirty()
+	if cfg.Get("visible") != "prod" || cfg.Get("hasshort") != "multi\nline" {
+		t.Errorf("Unexpected values: visible=%q hasshort=%q", cfg.Get("visible"), cfg.Get("hasshort"))
+	}
+
+	// Changes should be picked up by Load, retaining the selected section
+	writeFile("prod/visible", "prod2")
+	if err := ds.Load(cfg); err != nil {
+		t.Fatalf("Unexpected error from Load: %v", err)
+	}
+	if actual := cfg.Get("visible"); actual != "prod2" {
+		t.Errorf("Expected Config to reflect changed value, instead found %q", actual)
+	}
+
+	// Unknown options are an error, unless ignored
+	writeFile("nonexistent", "x")
+	if err := ds.Load(cfg); err == nil {
+		t.Error("Expected error from unknown option, but err was nil")
+	} else if actual := cfg.Get("visible"); actual != "prod2" {
+		t.Errorf("Expected failed Load to retain previous values, instead found %q", actual)
+	}
+	ds.IgnoreUnknownOptions = true
+	if err := ds.Load(cfg); err != nil {
+		t.Errorf("Unexpected error from Load: %v", err)
+	}
+
+	// Watch should notice changes and run callbacks
+	changed := make(chan string, 10)
+	cfg.Watch("visible", func(oldValue, newValue string) {
+		changed <- newValue
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		ds.Watch(ctx, cfg, 20*time.Millisecond)
+		close(done)
+	}()
+	// Replace the file atomically, as Kubernetes does, so that the watcher
+	// cannot observe a partially-written file
+	writeFile("prod/.visible.tmp", "watched")
+	if err := os.Rename(filepath.Join(dir, "prod", ".visible.tmp"), filepath.Join(dir, "prod", "visible")); err != nil {
+		t.Fatalf("Unable to rename file: %v", err)
+	}
+	select {
+	case value := <-changed:
+		if value != "watched" {
+			t.Errorf("Unexpected new value from watch callback: %q", value)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Timed out waiting for Watch to notice change")
+	}
+	cancel()
+	<-done
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected OptionValue on unloaded source to panic, but it did not")
+		}
+	}()
+	NewDirSource(dir).OptionValue("visible")
+}
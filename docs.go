@@ -0,0 +1,274 @@
+package mybase
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Example describes a sample invocation of a Command, for inclusion in help
+// output and generated reference documentation.
+type Example struct {
+	CommandLine string `json:"command_line"` // Full command-line, including the program name
+	Description string `json:"description"`  // Explanation of what the example does
+}
+
+// AddExample adds a sample invocation to cmd, for inclusion in help output and
+// the output of GenerateDocs. Examples are listed in the order they were added.
+func (cmd *Command) AddExample(commandLine, description string) {
+	cmd.examples = append(cmd.examples, Example{CommandLine: commandLine, Description: description})
+}
+
+// SetLongHelp sets extended help text for cmd, such as a detailed explanation
+// of its behavior or caveats, which would be too lengthy for its Description.
+// The text is shown in a separate "Details" section of help output, after the
+// options, and is also included in the output of GenerateDocs. Paragraphs
+// should be separated by blank lines; text is word-wrapped in help output.
+func (cmd *Command) SetLongHelp(text string) {
+	cmd.longHelp = strings.TrimSpace(text)
+}
+
+// GenerateDocs returns reference documentation for the program that cmd
+// belongs to. The supported formats are "man", which produces a roff man page
+// in section 1, and "markdown". The documentation covers the full command
+// tree, starting from cmd's top-level command, regardless of which Command in
+// the tree it is called on. For each command, it includes the invocation
+// synopsis, description, described positional args, non-hidden options
+// grouped in the same manner as help output, default values, any extended help
+// set via SetLongHelp, and any examples added via AddExample. Options are documented alongside the command
+// which defines them, rather than repeated for each subcommand. The built-in
+// help and version subcommands are omitted.
+//
+// Unlike help output, the text is not word-wrapped, since the man page viewer
+// or Markdown renderer handles this.
+func (cmd *Command) GenerateDocs(format string) (string, error) {
+	root := cmd.Root()
+	var b strings.Builder
+	switch strings.ToLower(format) {
+	case "man":
+		writeManPage(&b, root)
+	case "markdown", "md":
+		writeMarkdown(&b, root)
+	default:
+		return "", fmt.Errorf("Unable to generate docs in format %q: supported formats are man and markdown", format)
+	}
+	return b.String(), nil
+}
+
+// docCommands returns cmd and all of its descendant commands, in a depth-first
+// traversal ordered by each command's SubCommandOrder, omitting built-in
+// subcommands.
+func docCommands(cmd *Command) []*Command {
+	result := []*Command{cmd}
+	for _, sub := range cmd.orderedSubCommands() {
+		if !sub.isBuiltin() {
+			result = append(result, docCommands(sub)...)
+		}
+	}
+	return result
+}
+
+// ownOptionGroups returns the option groups of cmd, in the same order as
+// OptionGroups, but only including options defined by cmd itself rather than
+// inherited from a parent command. The built-in help and version options of
+// subcommands are also excluded, since they duplicate those of the top-level
+// command. Empty groups are omitted.
+func (cmd *Command) ownOptionGroups() []OptionGroup {
+	var result []OptionGroup
+	for _, grp := range cmd.OptionGroups() {
+		var opts []*Option
+		for _, opt := range grp.Options {
+			if cmd.ParentCommand != nil && (opt.Name == "help" || opt.Name == "version") {
+				continue
+			}
+			if cmd.options[opt.Name] == opt {
+				opts = append(opts, opt)
+			}
+		}
+		if len(opts) > 0 {
+			grp.Options = opts
+			result = append(result, grp)
+		}
+	}
+	return result
+}
+
+// docFlags returns the command-line flag(s) used to document opt, for example
+// "-s, --foo value".
+func docFlags(opt *Option) string {
+	var shorthand string
+	if opt.Shorthand > 0 {
+		shorthand = fmt.Sprintf("-%c, ", opt.Shorthand)
+	}
+	return fmt.Sprintf("%s--%s", shorthand, opt.usageName())
+}
+
+// docArgs returns the positional args of cmd if at least one has a
+// description, or nil otherwise. This matches the behavior of help output.
+func docArgs(cmd *Command) []*Option {
+	for _, arg := range cmd.args {
+		if arg.Description != "" {
+			return cmd.args
+		}
+	}
+	return nil
+}
+
+// docArgDescription returns the description of a positional arg, including
+// its default value if any.
+func docArgDescription(arg *Option) string {
+	if !arg.RequireValue && arg.Default != "" {
+		return fmt.Sprintf("%s (default %s)", arg.Description, arg.PrintableDefault())
+	}
+	return arg.Description
+}
+
+// roffEscaper escapes characters which have special meaning in roff text.
+var roffEscaper = strings.NewReplacer(`\`, `\e`, `-`, `\-`)
+
+// roffText returns text in a form suitable for inclusion in a man page. Each
+// line is escaped, and lines which would otherwise be interpreted as roff
+// requests are prefixed by a zero-width character. Blank lines become
+// paragraph breaks.
+func roffText(text string) string {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	for n, line := range lines {
+		line = roffEscaper.Replace(strings.TrimSpace(line))
+		if line == "" {
+			line = ".PP"
+		} else if line[0] == '.' || line[0] == '\'' {
+			line = `\&` + line
+		}
+		lines[n] = line
+	}
+	return strings.Join(lines, "\n")
+}
+
+// writeManPage writes a man page for the command tree rooted at root.
+func writeManPage(b *strings.Builder, root *Command) {
+	name := strings.ToUpper(root.Name)
+	fmt.Fprintf(b, ".TH \"%s\" \"1\" \"\" \"%s %s\" \"%s Manual\"\n", name, root.Name, roffEscaper.Replace(root.Summary), root.Name)
+	fmt.Fprintf(b, ".SH NAME\n%s", roffEscaper.Replace(root.Name))
+	if desc := strings.TrimSpace(root.Description); desc != "" {
+		fmt.Fprintf(b, " \\- %s", roffEscaper.Replace(strings.SplitN(desc, "\n", 2)[0]))
+	}
+	b.WriteString("\n.SH SYNOPSIS\n")
+	fmt.Fprintf(b, ".B %s\n%s\n", roffEscaper.Replace(root.Name), roffEscaper.Replace(strings.TrimPrefix(root.Invocation(), root.Name+" ")))
+	if root.Description != "" {
+		fmt.Fprintf(b, ".SH DESCRIPTION\n%s\n", roffText(root.Description))
+	}
+	writeManCommandBody(b, root, ".SH")
+
+	commands := docCommands(root)[1:]
+	if len(commands) > 0 {
+		b.WriteString(".SH COMMANDS\n")
+	}
+	for _, cmd := range commands {
+		fmt.Fprintf(b, ".SS \"%s\"\n", roffEscaper.Replace(cmd.fullName()))
+		if cmd.Summary != "" {
+			fmt.Fprintf(b, "%s\n.PP\n", roffText(cmd.Summary))
+		}
+		fmt.Fprintf(b, "Usage: \\fB%s\\fR\n", roffEscaper.Replace(cmd.Invocation()))
+		if cmd.Description != "" && cmd.Description != cmd.Summary {
+			fmt.Fprintf(b, ".PP\n%s\n", roffText(cmd.Description))
+		}
+		writeManCommandBody(b, cmd, ".PP\n.B")
+	}
+
+	if webDocs := root.WebDocText(); webDocs != "" {
+		fmt.Fprintf(b, ".SH SEE ALSO\n%s\n", roffText(webDocs))
+	}
+}
+
+// writeManCommandBody writes the positional args, options, extended help, and
+// examples of cmd. Each heading is introduced using headingMacro.
+func writeManCommandBody(b *strings.Builder, cmd *Command, headingMacro string) {
+	if args := docArgs(cmd); args != nil {
+		fmt.Fprintf(b, "%s ARGUMENTS\n", headingMacro)
+		for _, arg := range args {
+			fmt.Fprintf(b, ".TP\n.B <%s>\n%s\n", roffEscaper.Replace(arg.Name), roffText(docArgDescription(arg)))
+		}
+	}
+	for _, grp := range cmd.ownOptionGroups() {
+		fmt.Fprintf(b, "%s \"%s\"\n", headingMacro, roffEscaper.Replace(strings.ToUpper(cmd.groupTitle(grp.Name))))
+		for _, opt := range grp.Options {
+			desc := opt.Description + opt.DefaultUsage() + opt.DeprecationUsage()
+			fmt.Fprintf(b, ".TP\n.B %s\n%s\n", roffEscaper.Replace(docFlags(opt)), roffText(desc))
+		}
+	}
+	if cmd.longHelp != "" {
+		fmt.Fprintf(b, "%s DETAILS\n%s\n", headingMacro, roffText(cmd.longHelp))
+	}
+	if len(cmd.examples) > 0 {
+		fmt.Fprintf(b, "%s EXAMPLES\n", headingMacro)
+		for _, example := range cmd.examples {
+			fmt.Fprintf(b, ".TP\n.B %s\n%s\n", roffEscaper.Replace(example.CommandLine), roffText(example.Description))
+		}
+	}
+}
+
+// markdownEscaper escapes characters which could otherwise be interpreted as
+// Markdown formatting in running text.
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `<`, `&lt;`, `>`, `&gt;`, `[`, `\[`, `]`, `\]`)
+
+// markdownCode returns text formatted as inline code, using a sufficient
+// number of backticks to contain any backticks in text.
+func markdownCode(text string) string {
+	fence := "`"
+	for strings.Contains(text, fence) {
+		fence += "`"
+	}
+	if strings.HasPrefix(text, "`") || strings.HasSuffix(text, "`") {
+		text = " " + text + " "
+	}
+	return fence + text + fence
+}
+
+// writeMarkdown writes Markdown reference docs for the command tree rooted
+// at root.
+func writeMarkdown(b *strings.Builder, root *Command) {
+	for n, cmd := range docCommands(root) {
+		heading := "##"
+		if n == 0 {
+			heading = "#"
+		}
+		fmt.Fprintf(b, "%s %s\n\n", heading, markdownEscaper.Replace(cmd.fullName()))
+		if cmd == root && cmd.Summary != "" {
+			fmt.Fprintf(b, "Version: %s\n\n", markdownEscaper.Replace(cmd.Summary))
+		} else if cmd != root && cmd.Summary != "" && cmd.Summary != cmd.Description {
+			fmt.Fprintf(b, "%s\n\n", markdownEscaper.Replace(cmd.Summary))
+		}
+		fmt.Fprintf(b, "```\n%s\n```\n\n", cmd.Invocation())
+		if desc := strings.TrimSpace(cmd.Description); desc != "" {
+			fmt.Fprintf(b, "%s\n\n", markdownEscaper.Replace(desc))
+		}
+		if args := docArgs(cmd); args != nil {
+			fmt.Fprintf(b, "%s# Arguments\n\n", heading)
+			for _, arg := range args {
+				fmt.Fprintf(b, "* %s: %s\n", markdownCode("<"+arg.Name+">"), markdownEscaper.Replace(docArgDescription(arg)))
+			}
+			b.WriteString("\n")
+		}
+		for _, grp := range cmd.ownOptionGroups() {
+			fmt.Fprintf(b, "%s# %s\n\n", heading, markdownEscaper.Replace(cmd.groupTitle(grp.Name)))
+			for _, opt := range grp.Options {
+				desc := opt.Description + opt.DefaultUsage() + opt.DeprecationUsage()
+				fmt.Fprintf(b, "* %s: %s\n", markdownCode(docFlags(opt)), markdownEscaper.Replace(desc))
+			}
+			b.WriteString("\n")
+		}
+		if cmd.longHelp != "" {
+			fmt.Fprintf(b, "%s# Details\n\n%s\n\n", heading, markdownEscaper.Replace(cmd.longHelp))
+		}
+		if len(cmd.examples) > 0 {
+			fmt.Fprintf(b, "%s# Examples\n\n", heading)
+			for _, example := range cmd.examples {
+				fmt.Fprintf(b, "%s\n\n```\n%s\n```\n\n", markdownEscaper.Replace(example.Description), example.CommandLine)
+			}
+		}
+		if cmd == root {
+			if webDocs := cmd.WebDocText(); webDocs != "" {
+				fmt.Fprintf(b, "%s\n\n", markdownEscaper.Replace(webDocs))
+			}
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package mybase
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateDocs(t *testing.T) {
+	suite := simpleCommandSuite()
+	suite.Description = "Manages things.\n\n.Leading dot and a back\\slash."
+	one := suite.SubCommands["one"]
+	one.AddOption(StringOption("only-one", 0, "x", "Option only for one"))
+	one.AddExample("mycommand one --only-one=y", "Runs one with a *non-default* value")
+	one.SetLongHelp("\nDetails about one.\n\nSecond paragraph.\n")
+	two := suite.SubCommands["two"]
+	two.AddArg("extra", "", false).Describe("An extra arg")
+
+	man, err := two.GenerateDocs("man") // any command in the tree may be used
+	if err != nil {
+		t.Fatalf("Unexpected error from GenerateDocs: %v", err)
+	}
+	for _, expected := range []string{
+		".TH \"MYCOMMAND\" \"1\" \"\" \"mycommand summary\" \"mycommand Manual\"\n",
+		".SH NAME\nmycommand \\- Manages things.\n",
+		".SH DESCRIPTION\nManages things.\n.PP\n\\&.Leading dot and a back\\eslash.\n",
+		".B \\-s, \\-\\-hasshort value\n",
+		".SH \"GLOBAL OPTIONS\"\n",
+		".SS \"mycommand one\"\n",
+		".B \"ONE OPTIONS\"\n.TP\n",
+		".B \\-\\-only\\-one value\nOption only for one (default \"x\")\n",
+		".B DETAILS\nDetails about one.\n.PP\nSecond paragraph.\n.PP\n.B EXAMPLES\n.TP\n.B mycommand one \\-\\-only\\-one=y\n",
+		".B ARGUMENTS\n.TP\n.B <optional>\n",
+		".TP\n.B <extra>\nAn extra arg\n",
+		".SH SEE ALSO\n",
+	} {
+		if !strings.Contains(man, expected) {
+			t.Errorf("Expected man page to contain %q, but it did not. Output:\n%s", expected, man)
+		}
+	}
+	if strings.Contains(man, "\"mycommand help\"") || strings.Count(man, "Display program version") != 1 {
+		t.Errorf("Expected man page to omit built-in subcommands and their duplicate options. Output:\n%s", man)
+	}
+
+	md, err := suite.GenerateDocs("Markdown")
+	if err != nil {
+		t.Fatalf("Unexpected error from GenerateDocs: %v", err)
+	}
+	for _, expected := range []string{
+		"# mycommand\n\nVersion: summary\n\n```\nmycommand [<options>] <command>\n```\n",
+		"## Global Options\n\n* `-?, --help[=value]`: ",
+		"## mycommand one\n",
+		"### One Options\n\n",
+		"* `--only-one value`: Option only for one (default \"x\")\n",
+		"### Details\n\nDetails about one.\n\nSecond paragraph.\n\n### Examples\n\nRuns one with a \\*non-default\\* value\n\n```\nmycommand one --only-one=y\n```\n",
+		"### Arguments\n\n* `<optional>`: ",
+	} {
+		if !strings.Contains(md, expected) {
+			t.Errorf("Expected Markdown to contain %q, but it did not. Output:\n%s", expected, md)
+		}
+	}
+
+	if _, err := suite.GenerateDocs("html"); err == nil {
+		t.Error("Expected error from GenerateDocs with unsupported format, but err is nil")
+	}
+}
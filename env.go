@@ -0,0 +1,50 @@
+package mybase
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// EnvSource is an option source which obtains option values from environment
+// variables. Each option name is mapped to a variable name by converting it to
+// uppercase, replacing dashes and dots with underscores, and prepending the
+// prefix. For example, with prefix "MYAPP", option connect-options corresponds
+// to variable MYAPP_CONNECT_OPTIONS, and namespaced option plugin.foo.timeout
+// corresponds to MYAPP_PLUGIN_FOO_TIMEOUT.
+//
+// Typically an EnvSource should be added to a Config after any option files,
+// so that environment variables override option files, but are still
+// overridden by the command-line.
+type EnvSource struct {
+	Prefix string
+}
+
+// NewEnvSource returns an EnvSource using the supplied variable name prefix.
+// An underscore is automatically placed between the prefix and the option
+// name, if the prefix does not already end in one.
+func NewEnvSource(prefix string) *EnvSource {
+	return &EnvSource{Prefix: prefix}
+}
+
+// VarName returns the name of the environment variable corresponding to
+// optionName.
+func (es *EnvSource) VarName(optionName string) string {
+	prefix := es.Prefix
+	if prefix != "" && !strings.HasSuffix(prefix, "_") {
+		prefix += "_"
+	}
+	return prefix + strings.ToUpper(envVarNameReplacer.Replace(canonicalOptionName(optionName)))
+}
+
+var envVarNameReplacer = strings.NewReplacer("-", "_", ".", "_")
+
+// OptionValue satisfies the OptionValuer interface. A variable which is set to
+// an empty string is considered to supply an empty value.
+func (es *EnvSource) OptionValue(optionName string) (string, bool) {
+	return os.LookupEnv(es.VarName(optionName))
+}
+
+func (es *EnvSource) String() string {
+	return fmt.Sprintf("environment variable %s*", es.VarName(""))
+}
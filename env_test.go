@@ -0,0 +1,54 @@
+package mybase
+
+import (
+	"os"
+	"testing"
+)
+
+func TestEnvSource(t *testing.T) {
+	env := NewEnvSource("MYBASETEST")
+	if varName := env.VarName("has_short"); varName != "MYBASETEST_HAS_SHORT" {
+		t.Errorf("Unexpected variable name %q", varName)
+	}
+	if env2 := NewEnvSource("MYBASETEST_"); env2.VarName("hasshort") != "MYBASETEST_HASSHORT" {
+		t.Errorf("Unexpected variable name %q", env2.VarName("hasshort"))
+	}
+
+	vars := map[string]string{
+		"MYBASETEST_VISIBLE":  "from-env",
+		"MYBASETEST_HASSHORT": "from-env",
+		"MYBASETEST_BOOL1":    "",
+		"MYBASETEST_BOOL2":    "1",
+	}
+	for name, value := range vars {
+		os.Setenv(name, value)
+		defer os.Unsetenv(name)
+	}
+
+	// Env overrides file when added later, but CLI overrides both
+	cfg := ParseFakeCLI(t, simpleCommand(), "mycommand --hasshort=from-cli arg1")
+	file, err := getParsedFile(cfg, false, "visible=from-file\nhidden=from-file\nbool1\n")
+	if err != nil {
+		t.Fatalf("Unexpected error parsing file: %v", err)
+	}
+	cfg.AddSource(file)
+	cfg.AddSource(env)
+	assertValue := func(name, expected string) {
+		t.Helper()
+		if actual := cfg.Get(name); actual != expected {
+			t.Errorf("Expected %s=%q, instead found %q", name, expected, actual)
+		}
+	}
+	assertValue("visible", "from-env")
+	assertValue("hidden", "from-file")
+	assertValue("hasshort", "from-cli")
+	if cfg.GetBool("bool1") || !cfg.GetBool("bool2") {
+		t.Errorf("Unexpected bool values: bool1=%t bool2=%t", cfg.GetBool("bool1"), cfg.GetBool("bool2"))
+	}
+	if source := cfg.Source("visible"); source != env {
+		t.Errorf("Expected source of visible to be env, instead found %v", source)
+	}
+	if _, ok := env.OptionValue("hidden"); ok {
+		t.Error("Expected unset variable to not supply a value")
+	}
+}
@@ -0,0 +1,583 @@
+package mybase
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ParseError is implemented by all errors relating to problems parsing the
+// command-line or option files. Callers may use errors.As to obtain a
+// ParseError from a returned error, in order to programmatically inspect
+// which option, source, and line were involved. For example, an unknown
+// option results in an OptionNotDefinedError, and a value which is invalid for
+// its option results in an OptionValueError. In contrast, problems reading an
+// option file, rather than its contents, are returned as-is from the os
+// package, permitting checks such as errors.Is(err, os.ErrNotExist).
+type ParseError interface {
+	error
+	OptionName() string // Name of the option involved, or "" if not applicable
+	Location() string   // "CLI" or the path of the option file
+	Line() int          // Line number within the option file, or 0 if not applicable
+}
+
+// Sentinel errors identifying broad categories of problems. Every error type
+// in this package reports its category via an Is method, so callers may check
+// for a category using errors.Is without needing to know every specific type;
+// for example, errors.Is(err, ErrUnknownOption) is true for both an
+// OptionNotDefinedError and an OptionAmbiguousError. Problems reading a file,
+// as opposed to problems with its contents, belong to none of these
+// categories, since they are returned as-is from the os package.
+var (
+	// ErrUnknownOption: OptionNotDefinedError, OptionAmbiguousError
+	ErrUnknownOption = errors.New("unknown option")
+
+	// ErrInvalidValue: OptionValueError, ArgValueError, OptionMissingValueError,
+	// OptionSectionError, OptionTransformError, OptionValidationError,
+	// OptionImmutableError, OptionRequiredError, OptionRuleError
+	ErrInvalidValue = errors.New("invalid option value")
+
+	// ErrConflict: OptionAliasConflictError, DuplicateOptionError,
+	// MergeConflictError, SectionExistsError
+	ErrConflict = errors.New("conflicting option values or sections")
+
+	// ErrFileFormat: FileParseFormatError, BinaryContentError, InvalidUTF8Error,
+	// UnknownSectionError, LoginPathFormatError
+	ErrFileFormat = errors.New("invalid option file contents")
+
+	// ErrFileLimit: FileLimitError, FileTooLargeError, NonRegularFileError
+	ErrFileLimit = errors.New("option file limit exceeded")
+
+	// ErrSectionNotFound: SectionNotFoundError, ProfileNotFoundError
+	ErrSectionNotFound = errors.New("section not found")
+
+	// ErrUsage: UsageError
+	ErrUsage = errors.New("invalid command-line usage")
+)
+
+// OptionNotDefinedError is an error returned when an unknown Option is used.
+type OptionNotDefinedError struct {
+	Name       string
+	Source     string
+	FilePath   string // only set if the error occurred in an option file
+	LineNumber int    // only set if the error occurred in an option file
+	Suggestion string // name of a similarly-spelled option, if any
+}
+
+// Error satisfies golang's error interface.
+func (ond OptionNotDefinedError) Error() string {
+	var source string
+	if ond.Source != "" {
+		source = fmt.Sprintf("%s: ", ond.Source)
+	}
+	var suggestion string
+	if ond.Suggestion != "" {
+		suggestion = fmt.Sprintf("; did you mean \"%s\"?", ond.Suggestion)
+	}
+	return fmt.Sprintf("%sUnknown option \"%s\"%s", source, ond.Name, suggestion)
+}
+
+// Is returns true if target is ErrUnknownOption, permitting use of errors.Is.
+func (ond OptionNotDefinedError) Is(target error) bool { return target == ErrUnknownOption }
+
+// OptionName satisfies the ParseError interface.
+func (ond OptionNotDefinedError) OptionName() string { return ond.Name }
+
+// Location satisfies the ParseError interface.
+func (ond OptionNotDefinedError) Location() string { return location(ond.FilePath, ond.Source) }
+
+// Line satisfies the ParseError interface.
+func (ond OptionNotDefinedError) Line() int { return ond.LineNumber }
+
+// OptionAmbiguousError is an error returned when an abbreviated option name
+// is a prefix of multiple options' names, when the top-level Command has
+// AllowOptionPrefixes enabled.
+type OptionAmbiguousError struct {
+	Name       string
+	Candidates []string // names of the options which Name is a prefix of
+	Source     string
+	FilePath   string // only set if the error occurred in an option file
+	LineNumber int    // only set if the error occurred in an option file
+}
+
+// Error satisfies golang's error interface.
+func (oae OptionAmbiguousError) Error() string {
+	var source string
+	if oae.Source != "" {
+		source = fmt.Sprintf("%s: ", oae.Source)
+	}
+	return fmt.Sprintf("%sAmbiguous option \"%s\" could mean any of: %s", source, oae.Name, strings.Join(oae.Candidates, ", "))
+}
+
+// Is returns true if target is ErrUnknownOption, permitting use of errors.Is.
+func (oae OptionAmbiguousError) Is(target error) bool { return target == ErrUnknownOption }
+
+// OptionName satisfies the ParseError interface.
+func (oae OptionAmbiguousError) OptionName() string { return oae.Name }
+
+// Location satisfies the ParseError interface.
+func (oae OptionAmbiguousError) Location() string { return location(oae.FilePath, oae.Source) }
+
+// Line satisfies the ParseError interface.
+func (oae OptionAmbiguousError) Line() int { return oae.LineNumber }
+
+// OptionSectionError is an error returned when an option file sets an Option
+// in a section which is not permitted by Option.OnlyInSections.
+type OptionSectionError struct {
+	Name       string
+	Section    string   // name of the section, or "" for the default section
+	Permitted  []string // section names or patterns which may set the option
+	Source     string
+	FilePath   string
+	LineNumber int
+}
+
+// Error satisfies golang's error interface.
+func (ose OptionSectionError) Error() string {
+	var source string
+	if ose.Source != "" {
+		source = fmt.Sprintf("%s: ", ose.Source)
+	}
+	describe := func(name string) string {
+		if name == "" {
+			return "the default section"
+		}
+		return "[" + name + "]"
+	}
+	permitted := make([]string, len(ose.Permitted))
+	for n, name := range ose.Permitted {
+		permitted[n] = describe(name)
+	}
+	return fmt.Sprintf("%sOption %s may not be set in %s; only permitted in %s", source, ose.Name, describe(ose.Section), strings.Join(permitted, ", "))
+}
+
+// Is returns true if target is ErrInvalidValue, permitting use of errors.Is.
+func (ose OptionSectionError) Is(target error) bool { return target == ErrInvalidValue }
+
+// OptionName satisfies the ParseError interface.
+func (ose OptionSectionError) OptionName() string { return ose.Name }
+
+// Location satisfies the ParseError interface.
+func (ose OptionSectionError) Location() string { return location(ose.FilePath, ose.Source) }
+
+// Line satisfies the ParseError interface.
+func (ose OptionSectionError) Line() int { return ose.LineNumber }
+
+// OptionAliasConflictError is an error returned when a single source supplies
+// conflicting values for an Option using two different spellings of its name,
+// for example via the Option's canonical name as well as one of its aliases.
+// This is not an error if the top-level Command has LastAliasWins enabled.
+type OptionAliasConflictError struct {
+	Name       string // canonical name of the Option
+	Spellings  [2]string
+	Source     string
+	FilePath   string // only set if the error occurred in an option file
+	LineNumber int    // only set if the error occurred in an option file
+}
+
+// Error satisfies golang's error interface.
+func (oac OptionAliasConflictError) Error() string {
+	var source string
+	if oac.Source != "" {
+		source = fmt.Sprintf("%s: ", oac.Source)
+	}
+	return fmt.Sprintf("%sConflicting values supplied for option %s via both \"%s\" and \"%s\"", source, oac.Name, oac.Spellings[0], oac.Spellings[1])
+}
+
+// Is returns true if target is ErrConflict, permitting use of errors.Is.
+func (oac OptionAliasConflictError) Is(target error) bool { return target == ErrConflict }
+
+// OptionName satisfies the ParseError interface.
+func (oac OptionAliasConflictError) OptionName() string { return oac.Name }
+
+// Location satisfies the ParseError interface.
+func (oac OptionAliasConflictError) Location() string { return location(oac.FilePath, oac.Source) }
+
+// Line satisfies the ParseError interface.
+func (oac OptionAliasConflictError) Line() int { return oac.LineNumber }
+
+// DuplicateOptionError is an error returned by File.Parse when an option is set
+// multiple times within the same section of an option file, and the File's
+// DuplicateOptions field is DuplicateError.
+type DuplicateOptionError struct {
+	Name       string
+	Previous   string // location of the earlier line which set the option
+	Source     string
+	FilePath   string
+	LineNumber int
+}
+
+// Error satisfies golang's error interface.
+func (doe DuplicateOptionError) Error() string {
+	return fmt.Sprintf("%s: Option %s was already set at %s in the same section", doe.Source, doe.Name, doe.Previous)
+}
+
+// Is returns true if target is ErrConflict, permitting use of errors.Is.
+func (doe DuplicateOptionError) Is(target error) bool { return target == ErrConflict }
+
+// OptionName satisfies the ParseError interface.
+func (doe DuplicateOptionError) OptionName() string { return doe.Name }
+
+// Location satisfies the ParseError interface.
+func (doe DuplicateOptionError) Location() string { return location(doe.FilePath, doe.Source) }
+
+// Line satisfies the ParseError interface.
+func (doe DuplicateOptionError) Line() int { return doe.LineNumber }
+
+// OptionMissingValueError is an error returned when an Option requires a value,
+// but no value was supplied.
+type OptionMissingValueError struct {
+	Name       string
+	Source     string
+	FilePath   string // only set if the error occurred in an option file
+	LineNumber int    // only set if the error occurred in an option file
+}
+
+// Error satisfies golang's error interface.
+func (omv OptionMissingValueError) Error() string {
+	var source string
+	if omv.Source != "" {
+		source = fmt.Sprintf("%s: ", omv.Source)
+	}
+	return fmt.Sprintf("%sMissing required value for option %s", source, omv.Name)
+}
+
+// Is returns true if target is ErrInvalidValue, permitting use of errors.Is.
+func (omv OptionMissingValueError) Is(target error) bool { return target == ErrInvalidValue }
+
+// OptionName satisfies the ParseError interface.
+func (omv OptionMissingValueError) OptionName() string { return omv.Name }
+
+// Location satisfies the ParseError interface.
+func (omv OptionMissingValueError) Location() string { return location(omv.FilePath, omv.Source) }
+
+// Line satisfies the ParseError interface.
+func (omv OptionMissingValueError) Line() int { return omv.LineNumber }
+
+// OptionValueError is an error returned when an option's value is not valid
+// for its type, for example a malformed duration or a value not permitted for
+// an enum option.
+type OptionValueError struct {
+	Name       string
+	Value      string
+	Problem    string
+	Source     string
+	FilePath   string // only set if the value came from an option file
+	LineNumber int    // only set if the error occurred while parsing an option file
+}
+
+// Error satisfies golang's error interface.
+func (ove OptionValueError) Error() string {
+	var source string
+	if ove.Source != "" {
+		source = fmt.Sprintf("%s: ", ove.Source)
+	}
+	return fmt.Sprintf("%sInvalid value %q for option %s: %s", source, ove.Value, ove.Name, ove.Problem)
+}
+
+// Is returns true if target is ErrInvalidValue, permitting use of errors.Is.
+func (ove OptionValueError) Is(target error) bool { return target == ErrInvalidValue }
+
+// OptionName satisfies the ParseError interface.
+func (ove OptionValueError) OptionName() string { return ove.Name }
+
+// Location satisfies the ParseError interface.
+func (ove OptionValueError) Location() string { return location(ove.FilePath, ove.Source) }
+
+// Line satisfies the ParseError interface.
+func (ove OptionValueError) Line() int { return ove.LineNumber }
+
+// ArgValueError is an error returned when a positional arg supplied on the
+// command-line is not valid for the arg's type.
+type ArgValueError struct {
+	Name     string
+	Position int // 1-based position among the command's positional args
+	Value    string
+	Problem  string
+}
+
+// Error satisfies golang's error interface.
+func (ave ArgValueError) Error() string {
+	return fmt.Sprintf("Invalid value %q for positional arg %d <%s>: %s", ave.Value, ave.Position, ave.Name, ave.Problem)
+}
+
+// Is returns true if target is ErrInvalidValue, permitting use of errors.Is.
+func (ave ArgValueError) Is(target error) bool { return target == ErrInvalidValue }
+
+// OptionName satisfies the ParseError interface.
+func (ave ArgValueError) OptionName() string { return ave.Name }
+
+// Location satisfies the ParseError interface. It always returns "CLI", since
+// positional args can only be supplied on the command-line.
+func (ave ArgValueError) Location() string { return "CLI" }
+
+// Line satisfies the ParseError interface. It always returns 0.
+func (ave ArgValueError) Line() int { return 0 }
+
+// FileParseFormatError is an error returned when File.Parse encounters a
+// problem with the formatting of a file (separate from an unknown option or a
+// lack of a required value for an option, which are handled by other types)
+type FileParseFormatError struct {
+	Problem    string
+	FilePath   string
+	LineNumber int
+}
+
+// Error satisfies golang's error interface.
+func (fpf FileParseFormatError) Error() string {
+	return fmt.Sprintf("Parse error in %s line %d: %s", fpf.FilePath, fpf.LineNumber, fpf.Problem)
+}
+
+// Is returns true if target is ErrFileFormat, permitting use of errors.Is.
+func (fpf FileParseFormatError) Is(target error) bool { return target == ErrFileFormat }
+
+// OptionName satisfies the ParseError interface. It always returns an empty
+// string, since format errors are not specific to any one option.
+func (fpf FileParseFormatError) OptionName() string { return "" }
+
+// Location satisfies the ParseError interface.
+func (fpf FileParseFormatError) Location() string { return fpf.FilePath }
+
+// Line satisfies the ParseError interface.
+func (fpf FileParseFormatError) Line() int { return fpf.LineNumber }
+
+// SectionNotFoundError is an error returned by File.UseSection when one or
+// more of the requested sections do not exist in the file.
+type SectionNotFoundError struct {
+	FilePath string
+	Sections []string
+}
+
+// Error satisfies golang's error interface.
+func (snf SectionNotFoundError) Error() string {
+	return fmt.Sprintf("File %s missing section: %s", snf.FilePath, strings.Join(snf.Sections, ", "))
+}
+
+// Is returns true if target is ErrSectionNotFound, permitting use of errors.Is.
+func (snf SectionNotFoundError) Is(target error) bool { return target == ErrSectionNotFound }
+
+// OptionName satisfies the ParseError interface. It always returns an empty
+// string, since missing sections are not specific to any one option.
+func (snf SectionNotFoundError) OptionName() string { return "" }
+
+// Location satisfies the ParseError interface.
+func (snf SectionNotFoundError) Location() string { return snf.FilePath }
+
+// Line satisfies the ParseError interface. It always returns 0, since a
+// missing section does not correspond to any line of the file.
+func (snf SectionNotFoundError) Line() int { return 0 }
+
+// SectionExistsError is an error returned by File.RenameSection when the new
+// name is already used by another section of the file.
+type SectionExistsError struct {
+	FilePath string
+	Section  string
+}
+
+// Error satisfies golang's error interface.
+func (see SectionExistsError) Error() string {
+	return fmt.Sprintf("File %s already has a section [%s]", see.FilePath, see.Section)
+}
+
+// Is returns true if target is ErrConflict, permitting use of errors.Is.
+func (see SectionExistsError) Is(target error) bool { return target == ErrConflict }
+
+// NonRegularFileError is an error returned by File.Read or File.Parse when the
+// path refers to a FIFO, device, directory, or other non-regular file, and the
+// File's AllowNonRegular field is false.
+type NonRegularFileError struct {
+	FilePath string
+	Mode     os.FileMode
+}
+
+// Error satisfies golang's error interface.
+func (nrf NonRegularFileError) Error() string {
+	return fmt.Sprintf("File %s is not a regular file (mode %s)", nrf.FilePath, nrf.Mode)
+}
+
+// Is returns true if target is ErrFileLimit, permitting use of errors.Is.
+func (nrf NonRegularFileError) Is(target error) bool { return target == ErrFileLimit }
+
+// FileTooLargeError is an error returned by File.Read or File.Parse when the
+// file exceeds the maximum permitted size. If the file grew after its size was
+// checked, Size is only a lower bound of the actual size.
+type FileTooLargeError struct {
+	FilePath string
+	Size     int64
+	MaxSize  int64
+}
+
+// Error satisfies golang's error interface.
+func (ftl FileTooLargeError) Error() string {
+	return fmt.Sprintf("File %s is too large: size %d bytes exceeds maximum of %d bytes", ftl.FilePath, ftl.Size, ftl.MaxSize)
+}
+
+// Is returns true if target is ErrFileLimit, permitting use of errors.Is.
+func (ftl FileTooLargeError) Is(target error) bool { return target == ErrFileLimit }
+
+// LoginPathFormatError is an error returned by LoginPathFile.Read or
+// LoginPathFile.Parse when the file is not a validly-obfuscated login path
+// file.
+type LoginPathFormatError struct {
+	FilePath string
+	Problem  string
+}
+
+// Error satisfies golang's error interface.
+func (lpf LoginPathFormatError) Error() string {
+	return fmt.Sprintf("File %s is not a valid login path file: %s", lpf.FilePath, lpf.Problem)
+}
+
+// Is returns true if target is ErrFileFormat, permitting use of errors.Is.
+func (lpf LoginPathFormatError) Is(target error) bool { return target == ErrFileFormat }
+
+// BinaryContentError is an error returned by File.Parse when the file contains
+// a NUL byte, which indicates it is a binary file rather than an option file.
+// LineNumber refers to the first line containing a NUL byte.
+type BinaryContentError struct {
+	FilePath   string
+	LineNumber int
+}
+
+// Error satisfies golang's error interface.
+func (bce BinaryContentError) Error() string {
+	return fmt.Sprintf("File %s appears to be binary: NUL byte found on line %d", bce.FilePath, bce.LineNumber)
+}
+
+// Is returns true if target is ErrFileFormat, permitting use of errors.Is.
+func (bce BinaryContentError) Is(target error) bool { return target == ErrFileFormat }
+
+// OptionName satisfies the ParseError interface. It always returns an empty
+// string, since binary content is not specific to any one option.
+func (bce BinaryContentError) OptionName() string { return "" }
+
+// Location satisfies the ParseError interface.
+func (bce BinaryContentError) Location() string { return bce.FilePath }
+
+// Line satisfies the ParseError interface.
+func (bce BinaryContentError) Line() int { return bce.LineNumber }
+
+// InvalidUTF8Error is an error returned by File.Parse when a line of the file
+// is not valid UTF-8, and the File's InvalidUTF8 field is InvalidUTF8Reject.
+// Offset is the byte offset of the first invalid byte within the line, and Byte
+// is its value.
+type InvalidUTF8Error struct {
+	FilePath   string
+	LineNumber int
+	Offset     int
+	Byte       byte
+}
+
+// Error satisfies golang's error interface.
+func (iue InvalidUTF8Error) Error() string {
+	return fmt.Sprintf("Parse error in %s line %d: invalid UTF-8 byte 0x%02x at offset %d", iue.FilePath, iue.LineNumber, iue.Byte, iue.Offset)
+}
+
+// Is returns true if target is ErrFileFormat, permitting use of errors.Is.
+func (iue InvalidUTF8Error) Is(target error) bool { return target == ErrFileFormat }
+
+// OptionName satisfies the ParseError interface. It always returns an empty
+// string, since the line cannot be safely interpreted.
+func (iue InvalidUTF8Error) OptionName() string { return "" }
+
+// Location satisfies the ParseError interface.
+func (iue InvalidUTF8Error) Location() string { return iue.FilePath }
+
+// Line satisfies the ParseError interface.
+func (iue InvalidUTF8Error) Line() int { return iue.LineNumber }
+
+// FileLimitError is an error returned by File.Parse when the file exceeds one
+// of the File's safety limits: MaxLineLength, MaxSections, or MaxOptions.
+// Limit describes which limit was exceeded, and Max is its value. LineNumber
+// refers to the line at which the limit was exceeded. Since these limits
+// guard against malformed or malicious files, File.ParseAll stops parsing
+// upon a FileLimitError, instead of continuing to collect problems.
+type FileLimitError struct {
+	FilePath   string
+	LineNumber int
+	Limit      string // "line length", "sections", or "option lines"
+	Max        int
+}
+
+// Error satisfies golang's error interface.
+func (fle FileLimitError) Error() string {
+	if fle.Limit == "line length" {
+		return fmt.Sprintf("Parse error in %s line %d: line exceeds maximum length of %d bytes", fle.FilePath, fle.LineNumber, fle.Max)
+	}
+	return fmt.Sprintf("Parse error in %s line %d: file exceeds maximum of %d %s", fle.FilePath, fle.LineNumber, fle.Max, fle.Limit)
+}
+
+// Is returns true if target is ErrFileLimit, permitting use of errors.Is.
+func (fle FileLimitError) Is(target error) bool { return target == ErrFileLimit }
+
+// OptionName satisfies the ParseError interface. It always returns an empty
+// string, since limits are not specific to any one option.
+func (fle FileLimitError) OptionName() string { return "" }
+
+// Location satisfies the ParseError interface.
+func (fle FileLimitError) Location() string { return fle.FilePath }
+
+// Line satisfies the ParseError interface.
+func (fle FileLimitError) Line() int { return fle.LineNumber }
+
+// UnknownSectionError is an error returned by File.Parse when the file contains
+// a section which is not permitted by File.AllowSections.
+type UnknownSectionError struct {
+	Section    string
+	Permitted  []string // section names or patterns supplied to File.AllowSections
+	Suggestion string   // permitted section name most similar to Section, if any
+	FilePath   string
+	LineNumber int
+}
+
+// Error satisfies golang's error interface.
+func (use UnknownSectionError) Error() string {
+	var suggestion string
+	if use.Suggestion != "" {
+		suggestion = fmt.Sprintf("; did you mean [%s]?", use.Suggestion)
+	}
+	return fmt.Sprintf("%s line %d: Unknown section [%s]%s", use.FilePath, use.LineNumber, use.Section, suggestion)
+}
+
+// Is returns true if target is ErrFileFormat, permitting use of errors.Is.
+func (use UnknownSectionError) Is(target error) bool { return target == ErrFileFormat }
+
+// OptionName satisfies the ParseError interface. It always returns an empty
+// string, since sections are not specific to any one option.
+func (use UnknownSectionError) OptionName() string { return "" }
+
+// Location satisfies the ParseError interface.
+func (use UnknownSectionError) Location() string { return use.FilePath }
+
+// Line satisfies the ParseError interface.
+func (use UnknownSectionError) Line() int { return use.LineNumber }
+
+// ParseErrors is a collection of errors, returned by methods such as
+// File.ParseAll which continue processing after encountering a problem.
+type ParseErrors []error
+
+// Error satisfies golang's error interface. The messages of all errors are
+// returned, separated by newlines.
+func (pe ParseErrors) Error() string {
+	messages := make([]string, len(pe))
+	for n, err := range pe {
+		messages[n] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Unwrap returns the individual errors, permitting use of errors.Is and
+// errors.As with Go 1.20+.
+func (pe ParseErrors) Unwrap() []error {
+	return []error(pe)
+}
+
+// location returns the file path if one is set, or the generic source
+// description otherwise.
+func location(filePath, source string) string {
+	if filePath != "" {
+		return filePath
+	}
+	return source
+}
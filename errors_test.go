@@ -0,0 +1,74 @@
+package mybase
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestErrorCategories confirms that errors returned from parsing, file
+// handling, and the command-line can be classified via errors.Is, and that
+// their specific types remain available via errors.As.
+func TestErrorCategories(t *testing.T) {
+	cmd := simpleCommand()
+	cmd.AddOption(StringOption("level", 0, "low", "dummy description").ValueRequired().SetChoices("low", "high"))
+	cfg := ParseFakeCLI(t, cmd, "mycommand arg1")
+	categories := []error{ErrUnknownOption, ErrInvalidValue, ErrConflict, ErrFileFormat, ErrFileLimit, ErrSectionNotFound, ErrUsage}
+	assertCategory := func(err, expected error) {
+		t.Helper()
+		if err == nil {
+			t.Errorf("Expected error in category %q, but err is nil", expected)
+			return
+		}
+		for _, category := range categories {
+			if is := errors.Is(err, category); is != (category == expected) {
+				t.Errorf("Expected errors.Is(%T, %q) to return %t", err, category, !is)
+			}
+		}
+	}
+
+	_, err := getParsedFile(cfg, false, "nonexistent=1\n")
+	assertCategory(err, ErrUnknownOption)
+	_, err = getParsedFile(cfg, false, "level=medium\n")
+	assertCategory(err, ErrInvalidValue)
+	var ove OptionValueError
+	if !errors.As(err, &ove) || ove.Name != "level" || ove.LineNumber != 1 {
+		t.Errorf("Expected errors.As to obtain OptionValueError for line 1, instead found %+v", ove)
+	}
+	_, err = getParsedFile(cfg, false, "[unterminated\n")
+	assertCategory(err, ErrFileFormat)
+	_, err = getParsedFile(cfg, false, "visible=\x00\n")
+	assertCategory(err, ErrFileFormat)
+
+	f, err := getParsedFile(cfg, false, "[one]\nvisible=1\n[two]\nvisible=2\n")
+	if err != nil {
+		t.Fatalf("Unexpected error from getParsedFile: %v", err)
+	}
+	assertCategory(f.UseSection("three"), ErrSectionNotFound)
+	assertCategory(f.RenameSection("three", "four"), ErrSectionNotFound)
+	assertCategory(f.RenameSection("one", "two"), ErrConflict)
+
+	f = NewFile("/tmp/fake.cnf")
+	f.MaxLineLength = 10
+	assertCategory(f.ParseReader(cfg, strings.NewReader("visible=this is too long\n")), ErrFileLimit)
+
+	_, err = ParseCLI(cmd, []string{"mycommand", "--nonexistent", "arg1"})
+	assertCategory(err, ErrUnknownOption)
+	_, err = ParseCLI(cmd, []string{"mycommand", "arg1", "arg2", "arg3"})
+	assertCategory(err, ErrUsage)
+	_, err = ParseCLI(cmd, []string{"mycommand", "--level"})
+	assertCategory(err, ErrInvalidValue)
+
+	// Problems reading a file belong to no category, and come from the os
+	// package as-is
+	err = NewFile("/tmp/nonexistent/fake.cnf").Read()
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected errors.Is(err, os.ErrNotExist) to be true for %v", err)
+	}
+	for _, category := range categories {
+		if errors.Is(err, category) {
+			t.Errorf("Expected I/O error to not be in category %q", category)
+		}
+	}
+}
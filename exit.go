@@ -0,0 +1,234 @@
+package mybase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Exit codes used by ExitCode and HandleCommandError for errors which do not
+// implement ExitCoder. Applications may use any other values for their own
+// ExitCoder errors.
+const (
+	ExitCodeSuccess  = 0   // No error
+	ExitCodeError    = 1   // Generic error lacking a specific exit code
+	ExitCodeUsage    = 2   // Invalid command-line, such as an unknown option or wrong number of args
+	ExitCodeConfig   = 78  // Invalid configuration, such as a malformed option file; matches EX_CONFIG from sysexits.h
+	ExitCodeCanceled = 130 // Context cancelled, for example by Ctrl-C; matches the convention for SIGINT
+)
+
+// ExitCoder is an error which also indicates the process exit code that should
+// be used as a result of the error.
+type ExitCoder interface {
+	error
+	ExitCode() int
+}
+
+// ExitValue is an error with an associated exit code. It satisfies the
+// ExitCoder interface.
+type ExitValue struct {
+	Code    int
+	Message string
+}
+
+// NewExitValue returns an error with the supplied exit code, and a message
+// formatted in the manner of fmt.Sprintf.
+func NewExitValue(code int, format string, args ...interface{}) error {
+	return &ExitValue{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// Error satisfies golang's error interface.
+func (ev *ExitValue) Error() string {
+	return ev.Message
+}
+
+// ExitCode satisfies the ExitCoder interface.
+func (ev *ExitValue) ExitCode() int {
+	return ev.Code
+}
+
+// UsageError is an error returned when the command-line is invalid in a way
+// not specific to any one option, such as an unknown command name or the wrong
+// number of positional args.
+type UsageError struct {
+	Command *Command // Command which was being parsed, for purposes of displaying its usage
+	Problem string
+}
+
+// Error satisfies golang's error interface.
+func (ue UsageError) Error() string {
+	return ue.Problem
+}
+
+// Is returns true if target is ErrUsage, permitting use of errors.Is.
+func (ue UsageError) Is(target error) bool { return target == ErrUsage }
+
+// ExitCode returns a process exit code corresponding to an error returned by
+// ParseCLI, RunContext, or Config.HandleCommand. Errors are unwrapped as
+// needed to find the code:
+//
+//   - nil results in ExitCodeSuccess.
+//   - Errors implementing ExitCoder return their own code.
+//   - Errors caused by context cancellation result in ExitCodeCanceled.
+//   - Command-line parsing errors (UsageError, or a ParseError from the CLI)
+//     result in ExitCodeUsage.
+//   - Configuration errors result in ExitCodeConfig. These include a
+//     ParseError from any source other than the CLI, problems reading an
+//     option file such as FileTooLargeError, a ProfileNotFoundError, and
+//     validation failures such as OptionRequiredError or OptionRuleError.
+//   - All other errors result in ExitCodeError.
+//
+// Applications may use ExitValue, or any other ExitCoder, to return their own
+// codes; Command.SetExitCode may also be used to assign codes to errors which
+// a command's handler returns.
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitCodeSuccess
+	}
+	var exitCoder ExitCoder
+	if errors.As(err, &exitCoder) {
+		return exitCoder.ExitCode()
+	} else if errors.Is(err, context.Canceled) {
+		return ExitCodeCanceled
+	} else if isUsageError(err) {
+		return ExitCodeUsage
+	} else if isConfigError(err) {
+		return ExitCodeConfig
+	}
+	return ExitCodeError
+}
+
+// SetExitCode declares that errors matching target, as determined by
+// errors.Is, result in the supplied exit code when returned by the handler of
+// cmd or any subcommand of cmd, at any depth, or by their hooks. This permits
+// a command to assign stable exit codes to sentinel errors, for example
+// cmd.SetExitCode(ErrDiffsFound, 1), without each handler needing to wrap them
+// in an ExitValue. Codes set on a descendant command take precedence over
+// those of its ancestors. Errors which already implement ExitCoder retain
+// their own code.
+func (cmd *Command) SetExitCode(target error, code int) {
+	cmd.exitCodes = append(cmd.exitCodes, errorExitCode{target: target, code: code})
+}
+
+// errorExitCode is an exit code declared via Command.SetExitCode.
+type errorExitCode struct {
+	target error
+	code   int
+}
+
+// withExitCode wraps an error to give it an exit code declared via
+// Command.SetExitCode. Its message is unchanged.
+type withExitCode struct {
+	error
+	code int
+}
+
+// ExitCode satisfies the ExitCoder interface.
+func (wec withExitCode) ExitCode() int {
+	return wec.code
+}
+
+// Unwrap returns the original error.
+func (wec withExitCode) Unwrap() error {
+	return wec.error
+}
+
+// applyExitCodes returns err wrapped with the first matching exit code
+// declared on the commands in chain, which should be ordered from the root
+// command to the handling command.
+func applyExitCodes(chain []*Command, err error) error {
+	var exitCoder ExitCoder
+	if err == nil || errors.As(err, &exitCoder) {
+		return err
+	}
+	for n := len(chain) - 1; n >= 0; n-- {
+		for _, ec := range chain[n].exitCodes {
+			if errors.Is(err, ec.target) {
+				return withExitCode{error: err, code: ec.code}
+			}
+		}
+	}
+	return err
+}
+
+// HandleCommandError displays an appropriate message for err on STDERR, and
+// returns the exit code that the application should supply to os.Exit. Usage
+// errors are followed by usage instructions for cmd, or the relevant
+// subcommand if known. Errors implementing ExitCoder have just their message
+// displayed, if non-empty. Other errors are displayed with an "Error: " prefix.
+// A nil err displays nothing and returns ExitCodeSuccess.
+//
+// If STDERR is a terminal, messages are formatted using the Error style of
+// cmd's ColorScheme, and usage instructions are formatted as in WriteUsage.
+func HandleCommandError(cmd *Command, err error) int {
+	return writeCommandError(os.Stderr, cmd, err, colorEnabled(os.Stderr))
+}
+
+func writeCommandError(w io.Writer, cmd *Command, err error, color bool) int {
+	code := ExitCode(err)
+	var exitCoder ExitCoder
+	format := func(msg string) string {
+		if !color {
+			return msg
+		}
+		return ansiFormat(cmd.colors().Error, msg)
+	}
+	if err == nil {
+		return code
+	} else if errors.As(err, &exitCoder) {
+		if msg := err.Error(); msg != "" {
+			fmt.Fprintln(w, format(msg))
+		}
+	} else if isUsageError(err) {
+		var usageErr UsageError
+		if errors.As(err, &usageErr) && usageErr.Command != nil {
+			cmd = usageErr.Command
+		}
+		fmt.Fprintln(w, format(err.Error()))
+		cmd.renderUsage(w, false, color)
+	} else {
+		fmt.Fprintln(w, format("Error: "+err.Error()))
+	}
+	return code
+}
+
+// isUsageError returns true if err is a UsageError, or a ParseError relating
+// to the command-line, or wraps either of these.
+func isUsageError(err error) bool {
+	var usageErr UsageError
+	var parseErr ParseError
+	if errors.As(err, &usageErr) {
+		return true
+	} else if errors.As(err, &parseErr) {
+		return parseErr.Location() == "CLI"
+	}
+	return false
+}
+
+// isConfigError returns true if err relates to the contents of a non-CLI
+// option source, reading an option file, or validating the configuration, or
+// wraps such an error.
+func isConfigError(err error) bool {
+	var parseErr ParseError
+	if errors.As(err, &parseErr) {
+		return parseErr.Location() != "CLI"
+	}
+	for _, target := range []interface{}{
+		&NonRegularFileError{},
+		&FileTooLargeError{},
+		&LoginPathFormatError{},
+		&ProfileNotFoundError{},
+		&OptionRequiredError{},
+		&OptionRuleError{},
+	} {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package mybase
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestHandleCommandError(t *testing.T) {
+	suite := simpleCommandSuite()
+	assertHandled := func(err error, expectCode int, expectOutput ...string) {
+		t.Helper()
+		var buf bytes.Buffer
+		if code := writeCommandError(&buf, suite, err, false); code != expectCode {
+			t.Errorf("Expected exit code %d, instead found %d", expectCode, code)
+		}
+		if code := ExitCode(err); code != expectCode {
+			t.Errorf("Expected ExitCode to return %d, instead found %d", expectCode, code)
+		}
+		for _, expected := range expectOutput {
+			if !strings.Contains(buf.String(), expected) {
+				t.Errorf("Expected output to contain %q, instead found %q", expected, buf.String())
+			}
+		}
+		if len(expectOutput) == 0 && buf.Len() > 0 {
+			t.Errorf("Expected no output, instead found %q", buf.String())
+		}
+	}
+
+	assertHandled(nil, ExitCodeSuccess)
+	assertHandled(NewExitValue(1, "%d differences found", 3), 1, "3 differences found\n")
+	assertHandled(fmt.Errorf("wrapped: %w", NewExitValue(3, "partial success")), 3, "wrapped: partial success\n")
+	assertHandled(NewExitValue(0, ""), ExitCodeSuccess)
+	assertHandled(errors.New("something broke"), ExitCodeError, "Error: something broke\n")
+
+	// Option-parsing failures result in usage text for the relevant command
+	_, err := ParseCLI(suite, []string{"mycommand", "--nonexistent"})
+	assertHandled(err, ExitCodeUsage, "Unknown option", "Usage:  mycommand [<options>] <command>")
+	_, err = ParseCLI(suite, []string{"mycommand", "one", "arg1", "extra", "extra2"})
+	assertHandled(err, ExitCodeUsage, "Extra command-line arg", "Usage:  mycommand one [<options>]")
+	_, err = ParseCLI(suite, []string{"mycommand", "nope"})
+	assertHandled(fmt.Errorf("wrapped: %w", err), ExitCodeUsage, "Unknown command", "Usage:  mycommand [<options>] <command>")
+
+	// Configuration problems outside of the command-line result in ExitCodeConfig
+	cfg := ParseFakeCLI(t, suite, "mycommand")
+	_, err = getParsedFile(cfg, false, "nonexistent=1\n")
+	assertHandled(err, ExitCodeConfig, "Error: ", "Unknown option")
+	assertHandled(fmt.Errorf("wrapped: %w", ProfileNotFoundError{Name: "prod"}), ExitCodeConfig, "Error: wrapped: Profile prod")
+	assertHandled(OptionRequiredError{Name: "host"}, ExitCodeConfig, "Error: Option host is required")
+}
+
+func TestSetExitCode(t *testing.T) {
+	errDiffs, errOther := errors.New("differences found"), errors.New("other problem")
+	suite := NewCommandSuite("mycommand", "1.0", "description")
+	suite.SetExitCode(errDiffs, 1)
+	suite.SetExitCode(errOther, 5)
+	child := NewCommand("child", "summary", "description", func(cfg *Config) error {
+		switch cfg.Get("fail") {
+		case "diffs":
+			return fmt.Errorf("child: %w", errDiffs)
+		case "other":
+			return errOther
+		case "exitvalue":
+			return NewExitValue(9, "%s", errOther)
+		}
+		return nil
+	})
+	child.AddOption(StringOption("fail", 0, "", "dummy description"))
+	child.SetExitCode(errOther, 6)
+	suite.AddSubCommand(child)
+
+	cases := map[string]int{
+		"":          ExitCodeSuccess,
+		"diffs":     1,
+		"other":     6, // child's code takes precedence over suite's
+		"exitvalue": 9, // ExitCoder retains its own code
+	}
+	for fail, expected := range cases {
+		err := RunContext(context.Background(), suite, []string{"mycommand", "child", "--fail=" + fail})
+		if code := ExitCode(err); code != expected {
+			t.Errorf("With --fail=%s, expected exit code %d, instead found %d", fail, expected, code)
+		}
+		if fail == "diffs" && err.Error() != "child: differences found" {
+			t.Errorf("Expected error message to be unchanged, instead found %q", err)
+		}
+	}
+}
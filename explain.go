@@ -0,0 +1,103 @@
+package mybase
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+// Explain writes a table to w describing the effective value of every option
+// and positional arg of the command, along with the source supplying each
+// value: the command line, a specific line of an option file, an environment
+// variable, a default value, or any other OptionValuer. This is intended to
+// help users debug layered configurations. Positional args are listed first,
+// in order, using angle brackets around their names; options are then listed
+// alphabetically. The built-in help and version options are omitted.
+//
+// Values are shown as returned by Config.Get, so they reflect any transform
+// functions and variable expansion. Values are quote-wrapped if they are empty
+// or contain special characters. Boolean options are shown as "true" or
+// "false". Values of options marked with Option.Sensitive are redacted.
+func (cfg *Config) Explain(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tVALUE\tSOURCE")
+	for _, arg := range cfg.CLI.Command.args {
+		fmt.Fprintf(tw, "<%s>\t%s\t%s\n", arg.Name, arg.displayValue(explainValue(cfg.Get(arg.Name))), cfg.explainSource(arg))
+	}
+
+	options := cfg.CLI.Command.Options()
+	names := make([]string, 0, len(options))
+	for name := range options {
+		if name != "help" && name != "help-all" && name != "version" && !cfg.CLI.Command.HasArg(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		opt := options[name]
+		value := explainOptionValue(opt, cfg.Get(name))
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", name, opt.displayValue(value), cfg.explainSource(opt))
+	}
+	return tw.Flush()
+}
+
+// ExplainHandler is a CommandHandler which calls Config.Explain to write the
+// effective configuration to STDOUT. It may be used as the handler of a
+// subcommand, for example a "config" subcommand in a command suite. Note that
+// the output only covers options available to that subcommand, which includes
+// options of its parent command(s) but not options of its siblings.
+func ExplainHandler(cfg *Config) error {
+	return cfg.Explain(os.Stdout)
+}
+
+// explainOptionValue returns value, as returned by Config.Get for opt, in a
+// form suitable for display by Explain. Boolean values are shown as "true" or
+// "false".
+func explainOptionValue(opt *Option, value string) string {
+	if opt.Type == OptionTypeBool {
+		return fmt.Sprint(BoolValue(value))
+	}
+	return explainValue(value)
+}
+
+// explainValue returns value in a form suitable for display by Explain.
+func explainValue(value string) string {
+	if value == "" {
+		return "''"
+	}
+	return QuoteValue(value)
+}
+
+// valueLocator is implemented by sources which can report the location of the
+// line supplying an option's value, such as *File.
+type valueLocator interface {
+	valueLocation(optionName string) (lineLocation, bool)
+}
+
+// explainSource returns a description of the source supplying the value of
+// opt, for use in Explain.
+func (cfg *Config) explainSource(opt *Option) string {
+	return explainOptionSource(opt, cfg.Source(opt.Name))
+}
+
+// explainOptionSource returns a description of source, which supplied the
+// value of opt.
+func explainOptionSource(opt *Option, source OptionValuer) string {
+	switch src := source.(type) {
+	case *Command:
+		return "default value"
+	case valueLocator:
+		if loc, ok := src.valueLocation(opt.Name); ok {
+			return loc.String()
+		}
+	case *EnvSource:
+		for _, name := range append([]string{opt.Name}, opt.Aliases...) {
+			if _, ok := os.LookupEnv(src.VarName(name)); ok {
+				return "environment variable " + src.VarName(name)
+			}
+		}
+	}
+	return fmt.Sprint(source)
+}
@@ -0,0 +1,57 @@
+package mybase
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	defer os.Unsetenv("MYBASE_TEST_EXPLAIN_HASSHORT")
+	os.Setenv("MYBASE_TEST_EXPLAIN_HASSHORT", "from env")
+
+	cmd := simpleCommand()
+	cmd.AddOption(StringOption("inherited", 0, "", "dummy description"))
+	file := NewFile("/etc/myconfigs", "my.cnf")
+	file.contents = "[base]\ninherited=yes\n\n[mycommand]\n!inherit base\nvisible=from file\nbool2\n"
+	file.read = true
+	cfg := ParseFakeCLI(t, cmd, "mycommand -b 'arg one'")
+	if err := file.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error from Parse: %v", err)
+	}
+	if err := file.UseSection("mycommand"); err != nil {
+		t.Fatalf("Unexpected error from UseSection: %v", err)
+	}
+	cfg.AddSource(file)
+	cfg.AddSource(NewEnvSource("MYBASE_TEST_EXPLAIN"))
+
+	var b bytes.Buffer
+	if err := cfg.Explain(&b); err != nil {
+		t.Fatalf("Unexpected error from Explain: %v", err)
+	}
+	expectedLines := []string{
+		"NAME        VALUE        SOURCE",
+		"<required>  arg one      command line",
+		"<optional>  hello        default value",
+		"bool1       true         command line",
+		"bool2       true         /etc/myconfigs/my.cnf line 7",
+		"hasshort    from env     environment variable MYBASE_TEST_EXPLAIN_HASSHORT",
+		"hidden      somedefault  default value",
+		"inherited   yes          /etc/myconfigs/my.cnf line 2",
+		"truthybool  true         default value",
+		"visible     from file    /etc/myconfigs/my.cnf line 6",
+	}
+	if actual := strings.TrimRight(b.String(), "\n"); actual != strings.Join(expectedLines, "\n") {
+		t.Errorf("Unexpected output from Explain:\n%s", actual)
+	}
+
+	// Values set programmatically have no line number, and empty values are
+	// quote-wrapped
+	file.SetOptionValue("mycommand", "visible", "")
+	b.Reset()
+	cfg.Explain(&b)
+	if !strings.Contains(b.String(), "visible     ''           /etc/myconfigs/my.cnf\n") {
+		t.Errorf("Unexpected output from Explain after SetOptionValue:\n%s", b.String())
+	}
+}
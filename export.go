@@ -0,0 +1,165 @@
+package mybase
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mitchellh/go-wordwrap"
+)
+
+// ExportToFile stores the effective value of options into the named section
+// of f, so that a subsequent Write and Parse of f reproduces the same
+// effective values. If onlyChanged is true, only options whose value differs
+// from their default are exported. Hidden options are never exported, nor are
+// the built-in help and version options. If any filter funcs are supplied,
+// options are only exported if all filters return true for the option name.
+// Positional args are not exported, since they cannot appear in option files.
+//
+// Boolean options are stored in a way that causes Write to emit them as a
+// bare option name when true, or with a "skip-" prefix when false. Other
+// values are quote-wrapped if needed to survive a round-trip through Write and
+// Parse.
+//
+// This does not persist anything to disk; the caller must call Write on f
+// afterwards. An error is returned if sectionName cannot be represented in an
+// option file.
+func (cfg *Config) ExportToFile(f *File, sectionName string, onlyChanged bool, filters ...func(optionName string) bool) error {
+	if strings.ContainsAny(sectionName, "[]#\r\n") {
+		return fmt.Errorf("Cannot export to section name %q: name contains illegal characters", sectionName)
+	}
+	options := cfg.CLI.Command.Options()
+	for name, opt := range options {
+		if opt.HiddenOnCLI || name == "help" || name == "version" {
+			continue
+		}
+		if onlyChanged && !cfg.Changed(name) {
+			continue
+		}
+		var filtered bool
+		for _, filter := range filters {
+			if !filter(name) {
+				filtered = true
+				break
+			}
+		}
+		if filtered {
+			continue
+		}
+
+		var value string
+		if opt.Type == OptionTypeBool {
+			if cfg.GetBool(name) {
+				value = "1"
+			}
+		} else {
+			value = fileSafeValue(cfg.GetRaw(name))
+		}
+		f.setOption(sectionName, opt, value)
+	}
+	return nil
+}
+
+// setOption stores an option value in the named section, also associating
+// the Option definition with the value, which affects how Write formats it.
+func (f *File) setOption(sectionName string, opt *Option, value string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	section := f.getOrCreateSection(sectionName)
+	section.Values[opt.Name] = value
+	section.opts[opt.Name] = opt
+}
+
+// fileSafeValue returns value in a form that can be written to an option file
+// and then parsed back to yield the same result from Config.Get. Values which
+// are already entirely quote-wrapped, as well as values lacking any special
+// characters, are returned as-is. Otherwise the value is wrapped in single
+// quotes, with any backslashes or single quotes escaped.
+func fileSafeValue(value string) string {
+	if value == "" {
+		return "''"
+	}
+	if strings.TrimSpace(value) == value && unquote(value) != value {
+		return value // already quote-wrapped
+	}
+	if strings.TrimSpace(value) == value && !strings.ContainsAny(value, "#'\"`") && !strings.HasSuffix(value, `\`) {
+		return value
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	return "'" + replacer.Replace(value) + "'"
+}
+
+// AddConfigTemplateOption adds a --generate-config option to cmd, in the
+// "global" group. When this option is supplied on the command-line,
+// Config.HandleCommand writes the output of WriteConfigTemplate to STDOUT
+// instead of running the command's handler. Typically this is called on the
+// top-level command, so that the option is available to all subcommands.
+func (cmd *Command) AddConfigTemplateOption() {
+	cmd.AddOptions("global",
+		BoolOption("generate-config", 0, false, "Print a commented option file listing all options with their default values, and exit"),
+	)
+}
+
+// WriteConfigTemplate writes a fully-commented option file to w, intended as a
+// starting point for new users. Every option available to cmd is listed,
+// grouped and ordered in the same manner as help output, with its description
+// as a comment above a line setting its default value. The option lines are
+// themselves commented out, so the template has no effect until the user
+// uncomments the lines they wish to change. Hidden and deprecated options are
+// omitted, as are the built-in help and version options and the option added
+// by AddConfigTemplateOption. Positional args are never included, since they
+// cannot appear in option files.
+//
+// Defaults computed at runtime via Option.SetDefaultFunc are not reflected,
+// since they may depend on other options' values.
+func (cmd *Command) WriteConfigTemplate(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Option file for %s\n", cmd.fullName())
+	b.WriteString("# Uncomment and edit any option line below to override its default value.\n")
+	for _, grp := range cmd.OptionGroups() {
+		var opts []*Option
+		for _, opt := range grp.Options {
+			if opt.Deprecation == "" && !cmd.HasArg(opt.Name) && !templateExcluded[opt.Name] {
+				opts = append(opts, opt)
+			}
+		}
+		if len(opts) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n# --- %s ---\n", cmd.groupTitle(grp.Name))
+		for _, opt := range opts {
+			b.WriteString("\n")
+			if opt.Description != "" {
+				desc := wordwrap.WrapString(opt.Description, 78)
+				b.WriteString("# " + strings.Replace(desc, "\n", "\n# ", -1) + "\n")
+			}
+			fmt.Fprintf(&b, "#%s=%s\n", opt.Name, templateValue(opt))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func configTemplateHandler(cfg *Config) error {
+	return cfg.CLI.Command.WriteConfigTemplate(os.Stdout)
+}
+
+// templateExcluded lists the built-in options omitted by WriteConfigTemplate.
+var templateExcluded = map[string]bool{
+	"help":            true,
+	"help-all":        true,
+	"version":         true,
+	"generate-config": true,
+}
+
+// templateValue returns the default value of opt in the form used by
+// WriteConfigTemplate.
+func templateValue(opt *Option) string {
+	if opt.Type == OptionTypeBool {
+		return fmt.Sprint(BoolValue(opt.Default))
+	} else if opt.Default == "" {
+		return ""
+	}
+	return fileSafeValue(opt.Default)
+}
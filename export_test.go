@@ -0,0 +1,133 @@
+package mybase
+
+import (
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestExportToFile(t *testing.T) {
+	cmd := simpleCommand()
+	cmd.AddOption(StringOption("comment", 0, "", "dummy description"))
+	cmd.AddOption(StringOption("quotes", 0, "", "dummy description"))
+	cmd.AddOption(StringOption("spaces", 0, "", "dummy description"))
+	cmd.AddOption(StringOption("backslash", 0, "", "dummy description"))
+	cmd.AddOption(StringOption("empty", 0, "default", "dummy description"))
+	commandLine := `mycommand -s "hello world" --skip-truthybool --bool1 --hidden=secret --comment="a # b" --quotes="it's \"quoted\"" --spaces="  padded " --backslash='c:\\' --empty='' arg1`
+	cfg := ParseFakeCLI(t, cmd, commandLine)
+
+	dir, err := ioutil.TempDir("", "mybasetest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	assertRoundTrip := func(sectionName string, onlyChanged bool, expectSet, expectUnset []string, filters ...func(string) bool) {
+		t.Helper()
+		f := NewFile(dir, "export.cnf")
+		if err := cfg.ExportToFile(f, sectionName, onlyChanged, filters...); err != nil {
+			t.Fatalf("Unexpected error from ExportToFile: %v", err)
+		}
+		if err := f.Write(true); err != nil {
+			t.Fatalf("Unexpected error from Write: %v", err)
+		}
+
+		reread := NewFile(dir, "export.cnf")
+		cfg2 := ParseFakeCLI(t, cmd, "mycommand arg1")
+		if err := reread.Parse(cfg2); err != nil {
+			t.Fatalf("Unexpected error re-parsing exported file: %v", err)
+		}
+		if err := reread.UseSection(sectionName); err != nil {
+			t.Fatalf("Unexpected error from UseSection: %v", err)
+		}
+		cfg2.AddSource(reread)
+		for _, name := range expectSet {
+			if !reread.SectionHasOption(sectionName, name) {
+				t.Errorf("Expected exported file to set option %s, but it does not", name)
+			} else if cfg.Get(name) != cfg2.Get(name) {
+				t.Errorf("Value of option %s did not survive round-trip: expected %q, found %q", name, cfg.Get(name), cfg2.Get(name))
+			}
+		}
+		for _, name := range expectUnset {
+			if reread.SectionHasOption(sectionName, name) {
+				t.Errorf("Expected exported file to NOT set option %s, but it does", name)
+			}
+		}
+	}
+
+	changed := []string{"hasshort", "truthybool", "bool1", "comment", "quotes", "spaces", "backslash", "empty"}
+	unchanged := []string{"visible", "bool2"}
+	neverExported := []string{"hidden", "help", "version", "required", "optional"}
+	assertRoundTrip("production", false, append(changed, unchanged...), neverExported)
+	assertRoundTrip("", true, changed, append(unchanged, neverExported...))
+	noBools := func(name string) bool {
+		return cmd.Options()[name].Type != OptionTypeBool
+	}
+	assertRoundTrip("nobools", false, []string{"hasshort", "comment", "visible"}, []string{"bool1", "bool2", "truthybool"}, noBools)
+
+	if err := cfg.ExportToFile(NewFile(dir, "export.cnf"), "bad]name", false); err == nil {
+		t.Error("Expected ExportToFile to return an error for invalid section name, but it did not")
+	}
+}
+
+func TestWriteConfigTemplate(t *testing.T) {
+	cmd := simpleCommand()
+	cmd.AddOption(StringOption("comment", 0, "a # b", "This option has a default value which requires quoting, along with a description long enough to need wrapping"))
+	cmd.AddOption(StringOption("old", 0, "", "dummy description").Deprecated("visible", ""))
+	cmd.AddOptionGroup("Connection", StringOption("port", 0, "3306", "Port to connect to"))
+	cmd.AddConfigTemplateOption()
+
+	var b strings.Builder
+	if err := cmd.WriteConfigTemplate(&b); err != nil {
+		t.Fatalf("Unexpected error from WriteConfigTemplate: %v", err)
+	}
+	template := b.String()
+	for _, expected := range []string{"#visible=\n", "#truthybool=true\n", "#bool1=false\n", "#comment='a # b'\n", "\n# --- Connection Options ---\n\n# Port to connect to\n#port=3306\n"} {
+		if !strings.Contains(template, expected) {
+			t.Errorf("Expected template to contain %q, but it did not. Template:\n%s", expected, template)
+		}
+	}
+	for _, unexpected := range []string{"hidden", "help", "version", "generate-config", "required", "optional", "old="} {
+		if strings.Contains(template, "#"+unexpected) {
+			t.Errorf("Expected template to omit %s, but it did not. Template:\n%s", unexpected, template)
+		}
+	}
+	for _, line := range strings.Split(template, "\n") {
+		if len(line) > 80 {
+			t.Errorf("Expected template lines to be wrapped, but found line of length %d: %s", len(line), line)
+		}
+	}
+
+	// Uncommenting every option line should yield the default values
+	cfg := ParseFakeCLI(t, cmd, "mycommand arg1")
+	uncommented := regexp.MustCompile(`(?m)^#(\w)`).ReplaceAllString(template, "$1")
+	f, err := getParsedFile(cfg, false, uncommented)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing uncommented template: %v", err)
+	}
+	for name, opt := range cmd.Options() {
+		value, ok := f.OptionValue(name)
+		if !ok {
+			continue
+		}
+		if (opt.Type == OptionTypeBool && BoolValue(value) != BoolValue(opt.Default)) || (opt.Type != OptionTypeBool && unquote(value) != opt.Default) {
+			t.Errorf("Expected uncommented template to set %s to its default %q, instead found %q", name, opt.Default, value)
+		}
+	}
+
+	// --generate-config does not require positional args
+	cfg, err = ParseCLI(cmd, []string{"mycommand", "--generate-config"})
+	if err != nil {
+		t.Fatalf("Unexpected error from ParseCLI: %v", err)
+	}
+	defer func(stdout *os.File) { os.Stdout = stdout }(os.Stdout)
+	if os.Stdout, err = os.OpenFile(os.DevNull, os.O_WRONLY, 0); err != nil {
+		t.Fatalf("Unable to open %s: %v", os.DevNull, err)
+	}
+	defer os.Stdout.Close()
+	if err := cfg.HandleCommand(); err != nil {
+		t.Errorf("Unexpected error from HandleCommand: %v", err)
+	}
+}
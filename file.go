@@ -2,42 +2,368 @@ package mybase
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
+	"math"
 	"os"
+	"path"
 	"path/filepath"
 	"reflect"
+	"runtime"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 	"unicode"
+	"unicode/utf8"
 )
 
 // Section represents a labeled section of an option file. Option values that
 // precede any named section are still associated with a Section object, but
 // with a Name of "".
+//
+// A section may inherit values from one or more other sections, via an
+// "!inherit <section name>" directive line in the file, or equivalently
+// "!extends <section name>". Inherited values act as a base layer, overridden
+// by the section's own values. If a section inherits from multiple sections,
+// later directives take precedence over earlier ones. Values only contains the
+// section's own values; use File.SectionValues to obtain the merged result.
 type Section struct {
-	Name   string
-	Values map[string]string  // mapping of option name => value as string
-	opts   map[string]*Option // mapping of option name => option definition
+	Name        string
+	Values      map[string]string         // mapping of option name => value as string
+	Inherits    []string                  // names of sections that this section inherits values from
+	opts        map[string]*Option        // mapping of option name => option definition
+	inheritLocs []lineLocation            // location of each Inherits directive, if parsed from a file
+	valueLocs   map[string]lineLocation   // location of each value in Values, if parsed from a file
+	encrypted   map[string]encryptedValue // original form of each value in Values which was decrypted at parse time
+	continued   map[string]continuedValue // positions at which values in Values were split across multiple lines, if parsed from a file
+	repeated    bool                      // true if created by a "[[name]]" header, or a repeated header with File.RepeatedSections; see File.SectionsNamed
 }
 
+// continuedValue tracks where an option value was split across multiple lines
+// in an option file.
+type continuedValue struct {
+	value  string // value stored in Section.Values at parse time
+	breaks []int  // offsets in value at which each continuation line began
+}
+
+// optionLine returns the line used to represent the named option's value when
+// writing an option file. If unquotedEscapes is true, the file will be parsed
+// with File.UnquotedEscapes enabled.
+func (section *Section) optionLine(name string, unquotedEscapes bool) string {
+	opt := section.opts[name]
+	val := section.storedValue(name)
+	if cont, ok := section.continued[name]; ok && cont.value == val && len(cont.breaks) > 0 {
+		var b strings.Builder
+		prev := 0
+		b.WriteString(name + "=")
+		for _, offset := range cont.breaks {
+			b.WriteString(val[prev:offset] + "\\\n    ")
+			prev = offset
+		}
+		b.WriteString(val[prev:])
+		return b.String()
+	} else if opt == nil || opt.Type != OptionTypeBool {
+		return fmt.Sprintf("%s=%s", name, fileValue(val, unquotedEscapes))
+	} else if !BoolValue(val) {
+		return fmt.Sprintf("skip-%s", name)
+	}
+	return name
+}
+
+// fileValue returns value, as stored in Section.Values, in a form which Parse
+// reads back as the same value. Values which are already quoted, or which
+// contain no characters interpreted specially by Parse, are returned as-is;
+// otherwise QuoteValue is used. Backslashes only require quoting if
+// unquotedEscapes is true, so that values such as regular expressions and
+// Windows paths are written as-is by default.
+func fileValue(value string, unquotedEscapes bool) string {
+	if value == strings.TrimSpace(value) && unquote(value) != value {
+		return value // already quoted
+	}
+	if !strings.ContainsAny(value, "\r\n") && (!unquotedEscapes || !strings.ContainsRune(value, '\\')) {
+		if parsed, err := parseLine("x=" + value); err == nil && parsed.value == value {
+			return value
+		}
+	}
+	return QuoteValue(value)
+}
+
+// storedValue returns the named option's value in the form it should be
+// written to an option file: its original encrypted form if it was decrypted
+// at parse time and has not since been modified, or its value in Values
+// otherwise.
+func (section *Section) storedValue(name string) string {
+	value := section.Values[name]
+	if enc, ok := section.encrypted[name]; ok && enc.value == value {
+		return enc.ciphertext
+	}
+	return value
+}
+
+// OptionNames returns the names of all options with values set directly in the
+// section, sorted alphabetically. Inherited values are not included.
+func (section *Section) OptionNames() []string {
+	names := make([]string, 0, len(section.Values))
+	for name := range section.Values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Len returns the number of option values set directly in the section.
+// Inherited values are not included.
+func (section *Section) Len() int {
+	return len(section.Values)
+}
+
+// clone returns a deep copy of section.
+func (section *Section) clone() *Section {
+	c := &Section{
+		Name:        section.Name,
+		Values:      make(map[string]string, len(section.Values)),
+		Inherits:    append([]string(nil), section.Inherits...),
+		opts:        make(map[string]*Option, len(section.opts)),
+		inheritLocs: append([]lineLocation(nil), section.inheritLocs...),
+		valueLocs:   make(map[string]lineLocation, len(section.valueLocs)),
+		repeated:    section.repeated,
+	}
+	for name, value := range section.Values {
+		c.Values[name] = value
+	}
+	for name, opt := range section.opts {
+		c.opts[name] = opt
+	}
+	for name, loc := range section.valueLocs {
+		c.valueLocs[name] = loc
+	}
+	if section.encrypted != nil {
+		c.encrypted = make(map[string]encryptedValue, len(section.encrypted))
+		for name, enc := range section.encrypted {
+			c.encrypted[name] = enc
+		}
+	}
+	if section.continued != nil {
+		c.continued = make(map[string]continuedValue, len(section.continued))
+		for name, cont := range section.continued {
+			c.continued[name] = cont
+		}
+	}
+	return c
+}
+
+// resolveOptionName returns the canonical option name corresponding to key,
+// which may be an alias of an option previously parsed into the section.
+// Returns key unchanged if it does not correspond to any such alias. The
+// section may be nil.
+func (section *Section) resolveOptionName(key string) string {
+	if section == nil {
+		return key
+	}
+	for name, opt := range section.opts {
+		for _, alias := range opt.Aliases {
+			if canonicalOptionName(alias) == key {
+				return name
+			}
+		}
+	}
+	return key
+}
+
+// includeDirective is an "!include" or "!includedir" directive parsed from an
+// option file, retained so that Write can reproduce it.
+type includeDirective struct {
+	section    *Section // section containing the directive
+	line       string   // normalized form of the directive, for example "!include extra.cnf"
+	lineNumber int
+}
+
+// lineLocation identifies a line of an option file. The file path may refer to
+// an included file, rather than the File which contains the parsed result.
+type lineLocation struct {
+	filePath   string
+	lineNumber int
+	included   bool   // true if filePath is a file included by the File being parsed
+	spelling   string // name or alias used for the option on this line
+}
+
+// String returns the location in the form used by error messages.
+func (loc lineLocation) String() string {
+	return fmt.Sprintf("%s line %d", loc.filePath, loc.lineNumber)
+}
+
+// DefaultMaxFileSize is the maximum size of an option file permitted by
+// File.Read and File.Parse, if the File's MaxSize field is 0.
+const DefaultMaxFileSize = 16 * 1024 * 1024
+
+// DefaultMaxLineLength is the maximum length of a line of an option file
+// permitted by File.Parse, if the File's MaxLineLength field is 0.
+const DefaultMaxLineLength = 1024 * 1024
+
 // File represents a form of ini-style option file. Lines can contain
 // [sections], option=value, option without value (usually for bools), or
-// comments.
+// comments. A long value may span multiple lines by ending each line but the
+// last with a backslash; leading whitespace of each continuation line is
+// ignored. Write retains such values in their multi-line form, as long as they
+// have not been modified.
+//
+// As with MySQL option files, a File may contain "!include path" and
+// "!includedir path" directives, which parse the named file, or each option
+// file in the named directory, at that point. Values from included files are
+// stored in this File's sections, as if they had appeared in place of the
+// directive. Only the including file is affected by Write: its directives are
+// written back, and values from included files are omitted, unless they were
+// modified via SetOptionValue.
+//
+// Lines may be parsed conditionally, by enclosing them in a block beginning
+// with "!if condition" and ending with "!endif", optionally with "!elif
+// condition" and "!else" branches in between. Blocks may be nested, but each
+// must end in the same file in which it began. Conditions are evaluated by the
+// Conditions field, or DefaultCondition if nil, which permits testing the
+// operating system, architecture, and environment variables. Lines in inactive
+// branches are ignored by Parse, and retained as-is by Write if
+// PreserveFormatting is enabled; otherwise Write only persists the values of
+// active branches.
+//
+// Option files may alternatively use JSON, YAML, or TOML syntax, as determined
+// by the Syntax field, or by the file extension by default. See FileFormat for
+// details. Write preserves the file's format, but PreserveFormatting is only
+// supported for ini-style files.
+//
+// File is safe for concurrent use by multiple goroutines: calls to
+// OptionValue may be interleaved with calls to SetOptionValue, UseSection,
+// Parse, etc. Any Config using the File as a source automatically notices such
+// changes, without needing a call to Config.MarkDirty. However, callers must
+// not directly modify the Values map of any Section while the File is shared
+// between goroutines.
 type File struct {
+	// generation changes whenever option values or selected sections change;
+	// see bumpGeneration. It is accessed atomically, so it must remain the first
+	// field, to guarantee 64-bit alignment on 32-bit platforms.
+	generation uint64
+
 	Dir                  string
 	Name                 string
 	IgnoreUnknownOptions bool
+	DiscardContents      bool  // if true, release the raw file contents after parsing, retaining only the parsed sections
+	AllowNonRegular      bool  // if true, permit reading FIFOs, devices, and other non-regular files
+	ResolveSymlinks      bool  // if true, Path and Exists operate on the final target of any symlinks
+	MaxSize              int64 // maximum size in bytes permitted by Read and Parse; 0 means DefaultMaxFileSize, negative means no limit
+	MaxLineLength        int   // maximum length in bytes of a line permitted by Parse; 0 means DefaultMaxLineLength, negative means no limit
+	MaxSections          int   // maximum number of sections permitted by Parse, including the default section; 0 means no limit
+	MaxOptions           int   // maximum number of option lines permitted by Parse, including included files; 0 means no limit
+	InvalidUTF8          InvalidUTF8Policy
+	Encoding             FileEncoding // character encoding of the file's contents, which Parse converts to UTF-8
+	DuplicateOptions     DuplicatePolicy
+	Syntax               FileFormat    // format of the file's contents; FileFormatAuto selects based on the file extension
+	MaxIncludeDepth      int           // maximum nesting depth of !include and !includedir; 0 means DefaultMaxIncludeDepth, negative prohibits includes
+	PreserveFormatting   bool          // if true, Write only changes lines for options modified via SetOptionValue or UnsetOptionValue; overrides DiscardContents
+	Newline              string        // line ending used by Write, "\n" or "\r\n"; if empty, the line endings of the existing contents are retained, or "\n" for new contents
+	AtomicWrite          bool          // if true, Write replaces the file via a temporary file and rename, so that a crash cannot leave it partially written
+	BackupOnWrite        bool          // if true, Write first copies any existing file to a timestamped ".bak" file in the same directory
+	LazySections         bool          // if true, Parse defers parsing the options of named sections of an ini-format file until they are selected; see UseSection
+	RepeatedSections     bool          // if true, each repeated header of a named section begins a separate section, rather than adding to the first; see SectionsNamed
+	UnquotedEscapes      bool          // if true, Parse also interprets escape sequences such as "\n" in unquoted values, rather than only in quoted values
+	Conditions           ConditionFunc // evaluates conditions of "!if" and "!elif" directives; nil means DefaultCondition
+	Lenient              bool          // if true, Parse continues past problems with the contents, recording them for Problems instead of returning them
+	mu                   sync.RWMutex  // protects all unexported fields below
 	sections             []*Section
 	sectionIndex         map[string]*Section
+	blocks               []*Section         // sections from "[[name]]" headers, or repeated headers if RepeatedSections is true, in file order; see SectionsNamed
+	includes             []includeDirective // "!include" and "!includedir" directives of the file itself, in file order
 	read                 bool
 	parsed               bool
 	contents             string
+	crlf                 bool // true if the most recently read, parsed, or written contents used "\r\n" line endings
 	selected             []string
 	ignoredOptionNames   map[string]bool
+	unknownLines         []unknownLine
+	problems             []error                    // problems with the contents found by the most recent parse; see Problems
+	edited               map[string]map[string]bool // section name => set of option names modified since the last Write
+	renamed              map[string]string          // section name in contents => current name, for sections renamed since the last Write
+	diskStat             os.FileInfo                // result of stat when the file was last opened for reading
+	perm                 os.FileMode                // permissions used by Write, if permSet is true
+	permSet              bool                       // true if SetPermissions has been called
+	pending              map[string][]pendingLine   // section name => lines not yet parsed, if LazySections is true
+	pendingCfg           *Config                    // Config supplied to Parse, used for parsing pending lines
+	fsys                 fileSystem                 // filesystem for reading the file, or nil for the OS filesystem; see NewFileFS
+	allowedSections      []string                   // names or patterns of the only named sections permitted by Parse, if non-empty; see AllowSections
+	sealed               bool                       // true once a Config using the file as a source has been sealed; see Config.Seal
+	warnings             []fileWarning              // warnings generated while parsing, to be reported once f.mu is released; see unlock
+}
+
+// fileWarning is a warning generated while parsing a File, along with the
+// Config to report it to.
+type fileWarning struct {
+	cfg     *Config
+	message string
+}
+
+// fileSystem abstracts the read-only filesystem operations used by Read and
+// Parse, permitting files to be read from sources other than the OS
+// filesystem. See NewFileFS.
+type fileSystem interface {
+	Stat(path string) (os.FileInfo, error)
+	Open(path string) (io.ReadCloser, error)
+	ReadDir(path string) ([]os.FileInfo, error)
+}
+
+// InvalidUTF8Policy controls how File.Parse handles lines containing invalid
+// UTF-8.
+type InvalidUTF8Policy int
+
+// Constants for how to handle invalid UTF-8 in an option file
+const (
+	InvalidUTF8Reject  InvalidUTF8Policy = iota // return an InvalidUTF8Error identifying the line (default)
+	InvalidUTF8Replace                          // replace each invalid byte sequence with U+FFFD
+)
+
+// FileEncoding identifies the character encoding of an option file. Parse
+// converts the contents to UTF-8, so all option values are UTF-8 regardless of
+// the file's encoding. Write always writes UTF-8.
+type FileEncoding int
+
+// Constants for the character encoding of an option file
+const (
+	EncodingUTF8   FileEncoding = iota // UTF-8, optionally beginning with a byte order mark (default)
+	EncodingLatin1                     // ISO-8859-1, in which each byte is a single character
+)
+
+// DuplicatePolicy controls how File.Parse handles an option which is set
+// multiple times within the same section of an option file, including via an
+// included file. Each policy applies regardless of which
+// alias of the option is used on each line.
+type DuplicatePolicy int
+
+// Constants for how to handle duplicate options in an option file
+const (
+	DuplicateAccumulate DuplicatePolicy = iota // multi-valued options accumulate values, while for other options the last value wins (default)
+	DuplicateLastWins                          // the last value wins, even for multi-valued options
+	DuplicateFirstWins                         // the first value wins, even for multi-valued options
+	DuplicateWarn                              // like DuplicateAccumulate, but warn via Config.Warn when an option that does not accumulate is repeated
+	DuplicateError                             // like DuplicateAccumulate, but return a DuplicateOptionError when an option that does not accumulate is repeated
+)
+
+// unknownLine tracks a line of an option file which referred to an unknown
+// option, in case the option is registered later.
+type unknownLine struct {
+	lineLocation
+	section   *Section
+	line      string
+	mustMatch bool // true if an error should be returned if the option remains unknown
+}
+
+// pendingLine is a logical line of a named section whose parsing has been
+// deferred because File.LazySections is true.
+type pendingLine struct {
+	line       string
+	lineNumber int
+	breaks     []int // offsets of continuation lines, as in lineScanner
 }
 
 // NewFile returns a value representing an option file. The arg(s) will be
@@ -65,15 +391,83 @@ func NewFile(paths ...string) *File {
 	}
 }
 
+// copySettings copies all of f's settings to dst, which should be a new File
+// that has not been parsed yet. Settings are the exported fields of File,
+// along with any state configured by methods such as SetPermissions,
+// IgnoreOptions, and AllowSections; parsed contents are not copied. This is
+// used to create a File equivalent to f, so any new setting must be added
+// here. The caller must hold a read lock on f.mu.
+func (f *File) copySettings(dst *File) {
+	dst.Dir, dst.Name, dst.fsys = f.Dir, f.Name, f.fsys
+	dst.IgnoreUnknownOptions = f.IgnoreUnknownOptions
+	dst.DiscardContents = f.DiscardContents
+	dst.AllowNonRegular = f.AllowNonRegular
+	dst.ResolveSymlinks = f.ResolveSymlinks
+	dst.MaxSize = f.MaxSize
+	dst.MaxLineLength = f.MaxLineLength
+	dst.MaxSections = f.MaxSections
+	dst.MaxOptions = f.MaxOptions
+	dst.InvalidUTF8 = f.InvalidUTF8
+	dst.Encoding = f.Encoding
+	dst.DuplicateOptions = f.DuplicateOptions
+	dst.Syntax = f.Syntax
+	dst.MaxIncludeDepth = f.MaxIncludeDepth
+	dst.PreserveFormatting = f.PreserveFormatting
+	dst.Newline = f.Newline
+	dst.AtomicWrite = f.AtomicWrite
+	dst.BackupOnWrite = f.BackupOnWrite
+	dst.LazySections = f.LazySections
+	dst.RepeatedSections = f.RepeatedSections
+	dst.UnquotedEscapes = f.UnquotedEscapes
+	dst.Conditions = f.Conditions
+	dst.Lenient = f.Lenient
+	dst.perm, dst.permSet = f.perm, f.permSet
+	for name := range f.ignoredOptionNames {
+		dst.ignoredOptionNames[name] = true
+	}
+	dst.allowedSections = f.allowedSections
+}
+
 // Exists returns true if the file exists and is visible to the current user.
 func (f *File) Exists() bool {
-	_, err := os.Stat(f.Path())
+	_, err := f.stat(f.Path())
 	return (err == nil)
 }
 
-// Path returns the file's full absolute path with filename.
+// stat returns information about the file at path, using f's filesystem.
+func (f *File) stat(path string) (os.FileInfo, error) {
+	if f.fsys != nil {
+		return f.fsys.Stat(path)
+	}
+	return os.Stat(path)
+}
+
+// Path returns the file's full absolute path with filename. If
+// f.ResolveSymlinks is true and the path refers to a symlink, the path of the
+// symlink's final target is returned instead, assuming it can be resolved.
 func (f *File) Path() string {
-	return filepath.Join(f.Dir, f.Name)
+	path := filepath.Join(f.Dir, f.Name)
+	if f.ResolveSymlinks && f.fsys == nil {
+		if target, err := filepath.EvalSymlinks(path); err == nil {
+			return target
+		}
+	}
+	return path
+}
+
+// SymlinkTarget returns the absolute path of the final target of f, following
+// any chain of symlinks, regardless of the value of f.ResolveSymlinks. If f is
+// not a symlink, its own path is returned. An error is returned if the file
+// does not exist, or a link in the chain cannot be resolved.
+func (f *File) SymlinkTarget() (string, error) {
+	if f.fsys != nil {
+		return f.Path(), nil
+	}
+	target, err := filepath.EvalSymlinks(filepath.Join(f.Dir, f.Name))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(target)
 }
 
 func (f *File) String() string {
@@ -82,63 +476,299 @@ func (f *File) String() string {
 
 // Write writes out the file's contents to disk. If overwrite=false and the
 // file already exists, an error will be returned.
-// Note that if overwrite=true and the file already exists, any comments
+//
+// If f.PreserveFormatting is true and the file's original contents are
+// available (via Read, or retained by Parse), the original contents are
+// written back with only the minimal changes needed to reflect calls to
+// SetOptionValue and UnsetOptionValue: each modified option's line is
+// rewritten in place, or removed if the option was unset, and newly-added
+// options are appended to the end of their section. All other lines, including
+// comments, blank lines, and directives, are written back unchanged.
+//
+// Otherwise, the file is regenerated from its sections, and any comments
 // and extra whitespace in the file will be lost upon re-writing. All option
-// names and values will be normalized in the rewritten file. Any "loose-"
+// names and values will be normalized in the rewritten file. Any "!include" or
+// "!includedir" directives are retained in their original sections, ordered
+// relative to the section's other values as in the original file, and values
+// which were parsed from included files are not written. Values modified since
+// parsing are written after any directives, so they still take precedence. Any "loose-"
 // prefix option names that did not exist will not be written, and any that
-// did exist will have their "loose-" prefix stripped. These shortcomings will
-// be fixed in a future release.
+// did exist will have their "loose-" prefix stripped.
+//
+// If f.BackupOnWrite is true and overwrite is true, any existing file is first
+// copied to a backup file named with a timestamp and ".bak" suffix. If
+// f.AtomicWrite is true, the new contents are written and flushed to a
+// temporary file in the same directory, which is then renamed over the
+// existing file, retaining its permissions and ownership. If the path is a
+// symlink, the file it points to is replaced, rather than the symlink itself.
+// This ensures a crash during Write cannot leave a partially-written file.
+//
+// Files returned by NewFileFS cannot be written, and an error is returned.
 func (f *File) Write(overwrite bool) error {
-	lines := make([]string, 0)
-	for n, section := range f.sections {
-		if section.Name != "" {
-			lines = append(lines, fmt.Sprintf("[%s]", section.Name))
-		}
+	if f.fsys != nil {
+		return fmt.Errorf("Cannot write %s: file is from a read-only filesystem", f.Path())
+	}
+	f.mu.Lock()
+	if err := f.loadPending(nil); err != nil {
+		f.unlock()
+		return err
+	}
+	contents, ok := f.renderContents()
+	if !ok {
+		f.unlock()
+		currentLogger().Info("Skipping write of option file due to empty configuration", "path", f.Path())
+		return nil
+	}
+	f.commitContents(contents)
+	perm, explicitPerm := f.permissions()
+	f.unlock()
+	return f.writeContents(contents, overwrite, f.AtomicWrite, perm, explicitPerm)
+}
 
-		ks := make([]string, 0, len(section.Values))
-		for k := range section.Values {
-			ks = append(ks, k)
+// writeContents writes contents to the file's path on disk, in the manner
+// described by Write. The caller should not hold a lock on f.mu, unless
+// exclusive access is required for the entire write.
+func (f *File) writeContents(contents string, overwrite, atomicWrite bool, perm os.FileMode, explicitPerm bool) error {
+	path := f.Path()
+	if f.BackupOnWrite && overwrite {
+		if _, err := backupFile(path); err != nil {
+			return err
 		}
-		sort.Strings(ks)
-		for _, k := range ks {
-			opt := section.opts[k]
-			val := section.Values[k]
-			if opt == nil || opt.Type != OptionTypeBool {
-				lines = append(lines, fmt.Sprintf("%s=%s", k, val))
-			} else if !BoolValue(val) {
-				lines = append(lines, fmt.Sprintf("skip-%s", k))
-			} else {
-				lines = append(lines, k)
+	}
+	if !atomicWrite {
+		if err := writeFile(path, []byte(contents), overwrite, perm); err != nil {
+			return err
+		}
+	} else {
+		// Renaming replaces any existing file, so its permissions must be copied
+		// over, and overwrite=false must be checked explicitly
+		if fi, err := os.Stat(path); err == nil {
+			if !overwrite {
+				return &os.PathError{Op: "open", Path: path, Err: os.ErrExist}
+			} else if !explicitPerm {
+				perm, explicitPerm = fi.Mode().Perm(), true
+			}
+		}
+		if err := writeFileAtomic(path, []byte(contents), perm); err != nil {
+			return err
+		}
+	}
+	if explicitPerm {
+		// The permissions passed to writeFile only affect newly-created files,
+		// and those passed to writeFileAtomic are subject to the umask
+		return os.Chmod(path, perm)
+	}
+	return nil
+}
+
+// SetPermissions sets the permissions used by Write. These are applied to the
+// file even if it already exists. If SetPermissions is not called, Write
+// creates new files with permissions 0600 if they contain values for any
+// Sensitive options, or 0666 otherwise, in both cases subject to the umask;
+// the permissions of existing files are not changed.
+func (f *File) SetPermissions(perm os.FileMode) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.perm = perm
+	f.permSet = true
+}
+
+// permissions returns the permissions that Write should use, and whether they
+// were set explicitly via SetPermissions. The caller must hold a lock on f.mu.
+func (f *File) permissions() (os.FileMode, bool) {
+	if f.permSet {
+		return f.perm, true
+	} else if f.sensitiveOption() != "" {
+		return 0600, false
+	}
+	return 0666, false
+}
+
+// sensitiveOption returns the name of an option marked as Sensitive which has
+// a value in any section of the file, or an empty string if there is no such
+// option. The caller must hold a lock on f.mu.
+func (f *File) sensitiveOption() string {
+	for _, section := range f.sections {
+		for _, name := range section.OptionNames() {
+			if opt := section.opts[name]; opt != nil && opt.sensitive {
+				return name
 			}
 		}
+	}
+	return ""
+}
+
+// warnIfInsecure reports a warning via cfg.Warn if the file contains a value
+// for any Sensitive option, such as a password, but the file's permissions
+// when last opened permitted access by users other than its owner. This check
+// is skipped on Windows, where file modes do not reflect access control.
+func (f *File) warnIfInsecure(cfg *Config) {
+	if runtime.GOOS == "windows" || f.fsys != nil {
+		return
+	}
+	f.mu.RLock()
+	fi, name := f.diskStat, f.sensitiveOption()
+	f.mu.RUnlock()
+	if fi != nil && name != "" && fi.Mode().Perm()&0077 != 0 {
+		cfg.Warn("%s contains a value for sensitive option %s, but is accessible by other users (permissions %s); consider restricting its permissions, e.g. chmod 600", f.Path(), name, fi.Mode().Perm())
+	}
+}
+
+// renderContents returns the contents that Write should write to disk, using
+// the line endings determined by newline. The returned bool is false if there
+// is nothing to write. The caller must hold a lock on f.mu.
+func (f *File) renderContents() (string, bool) {
+	contents, ok := f.renderFormat()
+	if newline := f.newline(); ok && newline != "\n" {
+		contents = strings.Replace(contents, "\n", newline, -1)
+	}
+	return contents, ok
+}
+
+// newline returns the line ending that Write should use: f.Newline if set,
+// otherwise "\r\n" if the file's most recently read, parsed, or written
+// contents used Windows-style line endings, or "\n" by default. The caller
+// must hold a lock on f.mu.
+func (f *File) newline() string {
+	if f.Newline != "" {
+		return f.Newline
+	} else if f.crlf {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// renderFormat returns the contents that Write should write to disk, in the
+// file's format, with "\n" line endings. The returned bool is false if there
+// is nothing to write. The caller must hold a lock on f.mu.
+func (f *File) renderFormat() (string, bool) {
+	switch f.syntax() {
+	case FileFormatJSON:
+		return f.renderJSON()
+	case FileFormatYAML:
+		return f.renderYAML()
+	case FileFormatTOML:
+		return f.renderTOML()
+	}
+	if f.PreserveFormatting && f.read {
+		return f.roundTripContents(), true
+	}
+	lines := make([]string, 0)
+	all := make([]*Section, 0, len(f.sections)+len(f.blocks))
+	for _, section := range append(f.sections[:len(f.sections):len(f.sections)], f.blocks...) {
+		// Omit sections which only exist due to values from included files
+		if section.Name == "" || len(section.Inherits) > 0 || len(section.Values) == 0 || len(f.sectionBody(section)) > 0 {
+			all = append(all, section)
+		}
+	}
+	for n, section := range all {
+		if section.repeated {
+			lines = append(lines, fmt.Sprintf("[[%s]]", section.Name))
+		} else if section.Name != "" {
+			lines = append(lines, fmt.Sprintf("[%s]", section.Name))
+		}
+		for _, parentName := range section.Inherits {
+			lines = append(lines, fmt.Sprintf("!inherit %s", parentName))
+		}
+		body := f.sectionBody(section)
+		lines = append(lines, body...)
 
 		// Append a blank line after the section, unless it was the last one, or
 		// it was the default section and had no values
-		if n < len(f.sections)-1 && (section.Name != "" || len(section.Values) > 0) {
+		if n < len(all)-1 && (section.Name != "" || len(body) > 0 || len(section.Inherits) > 0) {
 			lines = append(lines, "")
 		}
 	}
-
 	if len(lines) == 0 {
-		log.Printf("Skipping write to %s due to empty configuration", f.Path())
-		return nil
+		return "", false
+	}
+	return fmt.Sprintf("%s\n", strings.Join(lines, "\n")), true
+}
+
+// sectionBody returns the option lines and include directives that Write should
+// write for section, as described by Write. The caller must hold a lock on
+// f.mu.
+func (f *File) sectionBody(section *Section) []string {
+	type bodyLine struct {
+		text      string
+		name      string // option name, or empty string for a directive
+		directive int    // line number of the directive which preceded this line in the original file, or MaxInt32 to follow all directives
+	}
+	var directives []includeDirective
+	for _, directive := range f.includes {
+		if directive.section == section {
+			directives = append(directives, directive)
+		}
+	}
+	precedingDirective := func(lineNumber int) int {
+		var pos int
+		for _, directive := range directives {
+			if directive.lineNumber <= lineNumber {
+				pos = directive.lineNumber
+			}
+		}
+		return pos
+	}
+
+	body := make([]bodyLine, 0, len(section.Values)+len(directives))
+	for name := range section.Values {
+		loc, ok := section.valueLocs[name]
+		if ok && loc.included {
+			continue
+		}
+		bl := bodyLine{text: section.optionLine(name, f.UnquotedEscapes), name: name, directive: math.MaxInt32}
+		if ok {
+			bl.directive = precedingDirective(loc.lineNumber)
+		}
+		body = append(body, bl)
+	}
+	for _, directive := range directives {
+		body = append(body, bodyLine{text: directive.line, directive: directive.lineNumber})
+	}
+
+	// Lines are sorted by option name, except that each directive separates the
+	// lines which preceded and followed it
+	sort.Slice(body, func(i, j int) bool {
+		if len(directives) > 0 && body[i].directive != body[j].directive {
+			return body[i].directive < body[j].directive
+		}
+		return body[i].name < body[j].name
+	})
+	lines := make([]string, len(body))
+	for n, bl := range body {
+		lines[n] = bl.text
 	}
-	f.contents = fmt.Sprintf("%s\n", strings.Join(lines, "\n"))
+	return lines
+}
+
+// commitContents stores contents as the file's current contents, in
+// preparation for writing them to disk. The caller must hold a write lock on
+// f.mu.
+func (f *File) commitContents(contents string) {
+	f.contents = contents
+	f.crlf = strings.Contains(contents, "\r\n")
 	f.read = true
 	f.parsed = true
+	f.edited = nil
+	f.renamed = nil
+}
 
+// writeFile writes data to path, creating the file with permissions perm if
+// it does not exist. If overwrite is false and the file already exists, an
+// error is returned.
+func writeFile(path string, data []byte, overwrite bool, perm os.FileMode) error {
 	flag := os.O_WRONLY | os.O_CREATE
 	if overwrite {
 		flag |= os.O_TRUNC
 	} else {
 		flag |= os.O_EXCL
 	}
-	osFile, err := os.OpenFile(f.Path(), flag, 0666)
+	osFile, err := os.OpenFile(path, flag, perm)
 	if err != nil {
 		return err
 	}
-	n, err := osFile.Write([]byte(f.contents))
-	if err == nil && n < len(f.contents) {
+	n, err := osFile.Write(data)
+	if err == nil && n < len(data) {
 		err = io.ErrShortWrite
 	}
 	if err1 := osFile.Close(); err == nil {
@@ -147,85 +777,1108 @@ func (f *File) Write(overwrite bool) error {
 	return err
 }
 
+// writeFileAtomic writes data to a temporary file in the same directory as
+// path, flushes it to stable storage, and then renames it to path, so that
+// readers never observe a partially-written file, and a crash cannot leave
+// path truncated. If path is a symlink, its target is replaced instead. If the
+// file already exists, its mode and ownership are carried over to the new
+// file; otherwise, the new file is created with permissions perm, subject to
+// the umask.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	existing, err := os.Stat(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var tmp *os.File
+	for attempt := 0; attempt < 100; attempt++ {
+		tmpName := fmt.Sprintf(".%s.tmp%d", filepath.Base(path), time.Now().UnixNano()+int64(attempt))
+		tmp, err = os.OpenFile(filepath.Join(filepath.Dir(path), tmpName), os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+		if !os.IsExist(err) {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		// Ownership must be changed first, since chown may clear setuid/setgid
+		if err = chownLike(tmp, existing); err == nil {
+			err = tmp.Chmod(existing.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky))
+		}
+	}
+	if err == nil {
+		var n int
+		n, err = tmp.Write(data)
+		if err == nil && n < len(data) {
+			err = io.ErrShortWrite
+		}
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err1 := tmp.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	// Also flush the directory, so that the rename itself is durable. This is
+	// not supported on all platforms, so any error is ignored.
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+// backupFile copies the existing file at path, if any, to a file in the same
+// directory with a timestamp and ".bak" appended to its name, retaining its
+// permissions. The path of the backup is returned, or an empty string if there
+// was no existing file to back up.
+func backupFile(path string) (string, error) {
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	backupPath := fmt.Sprintf("%s.%s.bak", path, time.Now().Format("20060102-150405"))
+	return backupPath, writeFile(backupPath, data, true, fi.Mode().Perm())
+}
+
+// roundTripContents returns the file's current contents, modified only to
+// reflect calls to SetOptionValue, UnsetOptionValue, RenameSection, and the
+// Delete and Prune methods since the last Write. Line endings are always "\n"; see
+// renderContents. The caller must hold a lock on f.mu.
+func (f *File) roundTripContents() string {
+	out := make([]string, 0)
+	written := make(map[string]map[string]bool)
+	seenSections := make(map[string]bool)
+
+	// appendNew appends any options which were modified in the named section but
+	// not yet written, prior to any blank lines at the end of the section
+	appendNew := func(sectionName string) {
+		if f.sectionIndex[sectionName] == nil {
+			return
+		}
+		var names []string
+		for name := range f.edited[sectionName] {
+			if _, ok := f.sectionIndex[sectionName].Values[name]; ok && !written[sectionName][name] {
+				names = append(names, name)
+			}
+		}
+		if len(names) == 0 {
+			return
+		}
+		sort.Strings(names)
+		insertAt := len(out)
+		for insertAt > 0 && strings.TrimSpace(out[insertAt-1]) == "" {
+			insertAt--
+		}
+		newLines := make([]string, len(names))
+		for n, name := range names {
+			newLines[n] = f.sectionIndex[sectionName].optionLine(name, f.UnquotedEscapes)
+		}
+		out = append(out[:insertAt], append(newLines, out[insertAt:]...)...)
+		if written[sectionName] == nil {
+			written[sectionName] = make(map[string]bool)
+		}
+		for _, name := range names {
+			written[sectionName][name] = true
+		}
+	}
+
+	// Repeated sections cannot be modified, and lines in inactive branches of
+	// conditional blocks were not parsed, so these lines are copied as-is
+	var sectionName string
+	var inBlock bool
+	var conds conditionStack
+	headers := make(map[string]bool)
+	seenSections[""] = true
+	scanner := newLineScanner(strings.NewReader(f.contents), -1)
+	for scanner.Scan() {
+		line := scanner.text
+		parsedLine, err := parseLine(line)
+		if err == nil && parsedLine.kind == lineTypeDirective && isConditionalDirective(parsedLine.key) {
+			conds.apply(parsedLine.key, parsedLine.value, f.conditions(), scanner.lineNumber)
+			out = append(out, scanner.physical...)
+			continue
+		} else if !conds.active() {
+			out = append(out, scanner.physical...)
+			continue
+		}
+		if err == nil && parsedLine.kind == lineTypeSectionHeader {
+			name := parsedLine.sectionName
+			wasInBlock := inBlock
+			inBlock = parsedLine.repeated || (f.RepeatedSections && headers[name])
+			if inBlock {
+				if !wasInBlock {
+					appendNew(sectionName)
+				}
+				out = append(out, scanner.physical...)
+				continue
+			}
+			headers[name] = true
+		} else if inBlock {
+			out = append(out, scanner.physical...)
+			continue
+		}
+		if sectionName != "" && f.sectionIndex[sectionName] == nil && (err != nil || parsedLine.kind != lineTypeSectionHeader) {
+			continue // within a section removed by DeleteSection
+		} else if err != nil {
+			out = append(out, scanner.physical...)
+			continue
+		}
+		switch parsedLine.kind {
+		case lineTypeSectionHeader:
+			appendNew(sectionName)
+			sectionName = parsedLine.sectionName
+			if newName, ok := f.renamed[sectionName]; ok {
+				sectionName = newName
+				if f.sectionIndex[sectionName] != nil {
+					indent := line[:len(line)-len(strings.TrimLeftFunc(line, unicode.IsSpace))]
+					newLine := indent + "[" + sectionName + "]"
+					if parsedLine.comment != "" {
+						newLine += " #" + parsedLine.comment
+					}
+					out = append(out, newLine)
+				}
+				seenSections[sectionName] = true
+				continue
+			}
+			seenSections[sectionName] = true
+			if f.sectionIndex[sectionName] == nil {
+				continue
+			}
+		case lineTypeKeyOnly, lineTypeKeyValue:
+			section := f.sectionIndex[sectionName]
+			name := section.resolveOptionName(parsedLine.key)
+			if section == nil || !f.edited[sectionName][name] {
+				break
+			}
+			// Rewrite the first line for a modified option, and remove any others,
+			// since they would override the first line. Continuation lines are
+			// rewritten or removed along with the line they continue.
+			if _, stillSet := section.Values[name]; stillSet && !written[sectionName][name] {
+				indent := line[:len(line)-len(strings.TrimLeftFunc(line, unicode.IsSpace))]
+				newLine := indent + section.optionLine(name, f.UnquotedEscapes)
+				if parsedLine.comment != "" {
+					newLine += " #" + parsedLine.comment
+				}
+				out = append(out, newLine)
+				if written[sectionName] == nil {
+					written[sectionName] = make(map[string]bool)
+				}
+				written[sectionName][name] = true
+			}
+			continue
+		}
+		out = append(out, scanner.physical...)
+	}
+	appendNew(sectionName)
+
+	// Add any sections which did not previously exist in the file
+	for _, section := range f.sections {
+		if seenSections[section.Name] || len(f.edited[section.Name]) == 0 || len(section.Values) == 0 {
+			continue
+		}
+		if len(out) > 0 {
+			out = append(out, "")
+		}
+		out = append(out, fmt.Sprintf("[%s]", section.Name))
+		appendNew(section.Name)
+	}
+
+	if len(out) == 0 {
+		return ""
+	}
+	return strings.Join(out, "\n") + "\n"
+}
+
 // Read loads the contents of the option file, but does not parse it.
 func (f *File) Read() error {
-	file, err := os.Open(f.Path())
+	r, err := f.open()
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-	bytes, err := ioutil.ReadAll(file)
+	defer r.Close()
+	bytes, err := ioutil.ReadAll(r)
 	if err != nil {
 		return err
 	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	f.contents = string(bytes)
+	f.crlf = strings.Contains(f.contents, "\r\n")
 	f.read = true
 	return nil
 }
 
 // Parse parses the file contents into a series of Sections. A Config object
 // must be supplied so that the list of valid Options is known.
+// If the file's contents were not already loaded via a prior call to Read,
+// the file is streamed from disk, without retaining its full contents in
+// memory. See ParseReader for more information.
+// Parsing stops at the first problem encountered, and the corresponding error
+// is returned. Errors relating to the file's contents implement the
+// ParseError interface. However, if f.Lenient or cfg.LenientFiles is true,
+// parsing instead continues past such problems, which are recorded for
+// retrieval via Problems, and nil is returned; LazySections is not supported
+// in this mode and is ignored.
+// If the file contains a value for any Sensitive option, such as a password,
+// but its permissions permit access by other users, a warning is reported via
+// cfg.Warn.
 func (f *File) Parse(cfg *Config) error {
-	if !f.read {
-		if err := f.Read(); err != nil {
+	return f.openAndParse(cfg, false)
+}
+
+// ParseAll is like Parse, but continues parsing after encountering a problem
+// with the file's contents, such as an unknown option or a malformed line.
+// If any such problems were encountered, a ParseErrors value is returned,
+// containing an error for each problem. The file's sections will still
+// reflect all other valid lines of the file. Errors relating to reading the
+// file, rather than its contents, still cause parsing to stop immediately.
+func (f *File) ParseAll(cfg *Config) error {
+	return f.openAndParse(cfg, true)
+}
+
+// Problems returns the problems with the file's contents found by the most
+// recent Parse, ParseAll, ParseReader, or ParseAllReader, in the order they
+// were encountered. Each one implements the ParseError interface, identifying
+// the line involved. This is primarily useful if f.Lenient or the Config's
+// LenientFiles field is true, since Parse then records problems and continues,
+// rather than returning an error. This permits files to be loaded through
+// other means, such as a FileChain, with all problems available afterwards.
+// Errors relating to reading the file, rather than its contents, are still
+// returned by Parse as usual, and are not included.
+func (f *File) Problems() []error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return append([]error(nil), f.problems...)
+}
+
+// ParseReader parses option file contents from r into a series of Sections,
+// scanning line-by-line rather than loading the entire input into memory
+// first. A Config object must be supplied so that the list of valid Options is
+// known.
+// After a successful parse, the contents read from r are retained, replacing
+// any contents previously loaded via Read. If f.DiscardContents is true, only
+// the parsed sections are retained instead, which reduces memory usage for
+// large inputs.
+func (f *File) ParseReader(cfg *Config, r io.Reader) error {
+	return f.parse(cfg, r, false)
+}
+
+// ParseAllReader is like ParseReader, but continues parsing after encountering
+// a problem with the contents, in the same manner as ParseAll. This permits
+// tools such as linters to report every problem in a stream, for example
+// contents piped via STDIN, in a single pass.
+func (f *File) ParseAllReader(cfg *Config, r io.Reader) error {
+	return f.parse(cfg, r, true)
+}
+
+// openAndParse parses the previously-read contents of the file, or streams
+// the file from disk if it has not yet been read. After a successful parse, a
+// warning is reported if the file's permissions are insecure for its contents.
+func (f *File) openAndParse(cfg *Config, collectAll bool) error {
+	f.mu.RLock()
+	alreadyRead, contents := f.read, f.contents
+	f.mu.RUnlock()
+	var err error
+	if alreadyRead {
+		err = f.parse(cfg, strings.NewReader(contents), collectAll)
+	} else {
+		var r io.ReadCloser
+		if r, err = f.open(); err != nil {
+			return err
+		}
+		defer r.Close()
+		err = f.parse(cfg, r, collectAll)
+	}
+	if err == nil {
+		currentLogger().Debug("Parsed option file", "path", f.Path())
+		f.warnIfInsecure(cfg)
+	} else {
+		currentLogger().Debug("Unable to parse option file", "path", f.Path(), "error", err)
+	}
+	return err
+}
+
+// open opens the file for reading, after confirming that it is a regular file
+// (unless f.AllowNonRegular is true) and does not exceed the maximum size. The
+// returned reader also enforces the maximum size while reading, in case the
+// file grows after being opened. Any size problem results in an error of type
+// FileTooLargeError.
+func (f *File) open() (io.ReadCloser, error) {
+	path := f.Path()
+	fi, err := f.stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() && !f.AllowNonRegular {
+		return nil, NonRegularFileError{FilePath: path, Mode: fi.Mode()}
+	}
+	maxSize := f.MaxSize
+	if maxSize == 0 {
+		maxSize = DefaultMaxFileSize
+	}
+	if maxSize > 0 && fi.Mode().IsRegular() && fi.Size() > maxSize {
+		return nil, FileTooLargeError{FilePath: path, Size: fi.Size(), MaxSize: maxSize}
+	}
+	var r io.ReadCloser
+	if f.fsys != nil {
+		r, err = f.fsys.Open(path)
+	} else {
+		r, err = os.Open(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	f.diskStat = fi
+	f.mu.Unlock()
+	if maxSize < 0 {
+		return r, nil
+	}
+	return &sizeLimitedReader{ReadCloser: r, path: path, remaining: maxSize, maxSize: maxSize}, nil
+}
+
+// sizeLimitedReader wraps an io.ReadCloser, returning a FileTooLargeError if
+// more than maxSize bytes are read.
+type sizeLimitedReader struct {
+	io.ReadCloser
+	path      string
+	remaining int64
+	maxSize   int64
+}
+
+// Read satisfies io.Reader.
+func (slr *sizeLimitedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > slr.remaining+1 {
+		p = p[:slr.remaining+1]
+	}
+	n, err := slr.ReadCloser.Read(p)
+	slr.remaining -= int64(n)
+	if slr.remaining < 0 {
+		return 0, FileTooLargeError{FilePath: slr.path, Size: slr.maxSize - slr.remaining, MaxSize: slr.maxSize}
+	}
+	return n, err
+}
+
+// parse implements the logic of ParseReader, and if collectAll is true, also
+// the error-collecting behavior of ParseAll.
+func (f *File) parse(cfg *Config, r io.Reader, collectAll bool) error {
+	f.mu.Lock()
+	defer f.unlock()
+	f.assertUnsealed("Parse")
+	f.bumpGeneration()
+
+	r, err := f.decoder(r)
+	if err != nil {
+		return err
+	}
+	var kept strings.Builder
+	keep := !f.DiscardContents || f.PreserveFormatting
+	if keep {
+		r = io.TeeReader(r, &kept)
+	}
+	var endings lineEndingDetector
+	r = io.TeeReader(r, &endings)
+
+	f.pending, f.pendingCfg = nil, nil
+	f.blocks = nil
+	f.includes = nil
+	f.problems = nil
+	lenient := !collectAll && (f.Lenient || cfg.LenientFiles)
+	if f.LazySections && !collectAll && !lenient {
+		f.pending, f.pendingCfg = make(map[string][]pendingLine), cfg
+	}
+	p := newFileParser(f, cfg, collectAll || lenient)
+	if err := p.parse(r, f.Path()); err != nil {
+		return err
+	}
+
+	// Inheritance can only be validated once the entire file has been scanned,
+	// since a section may inherit from a section defined later in the file
+	for _, err := range f.inheritanceErrors() {
+		if err := p.fail(err); err != nil {
 			return err
 		}
 	}
 
-	section := f.sectionIndex[""]
+	f.parsed = true
+	f.crlf = endings.crlf
+	f.selected = []string{""}
+	f.problems = p.problems
+	if len(p.problems) > 0 && !lenient {
+		return p.problems
+	}
+	if keep {
+		f.contents = kept.String()
+		f.read = true
+	} else {
+		f.contents = ""
+		f.read = false
+	}
+	return nil
+}
+
+// utf8BOM is the byte order mark which some editors, notably on Windows, place
+// at the beginning of UTF-8 files.
+const utf8BOM = "\xef\xbb\xbf"
+
+// decoder returns a reader which supplies the contents of r as UTF-8, based on
+// f.Encoding. A leading UTF-8 byte order mark is skipped. Since UTF-16 files
+// are otherwise reported as binary content, a more helpful error is returned
+// if r begins with a UTF-16 byte order mark.
+func (f *File) decoder(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	start, _ := br.Peek(len(utf8BOM))
+	if len(start) >= 2 && ((start[0] == 0xff && start[1] == 0xfe) || (start[0] == 0xfe && start[1] == 0xff)) {
+		return nil, FileParseFormatError{Problem: "file is UTF-16 encoded; only UTF-8 and Latin-1 files are supported", FilePath: f.Path(), LineNumber: 1}
+	}
+	if f.Encoding == EncodingLatin1 {
+		return &latin1Reader{r: br}, nil
+	}
+	if string(start) == utf8BOM {
+		br.Discard(len(utf8BOM))
+	}
+	return br, nil
+}
+
+// latin1Reader is an io.Reader which converts ISO-8859-1 input to UTF-8.
+type latin1Reader struct {
+	r       io.Reader
+	pending []byte // converted bytes not yet returned by Read
+}
+
+// Read satisfies the io.Reader interface.
+func (lr *latin1Reader) Read(p []byte) (int, error) {
+	if len(lr.pending) == 0 {
+		buf := make([]byte, (len(p)+1)/2)
+		n, err := lr.r.Read(buf)
+		var encoded [2]byte
+		for _, b := range buf[:n] {
+			size := utf8.EncodeRune(encoded[:], rune(b))
+			lr.pending = append(lr.pending, encoded[:size]...)
+		}
+		if n == 0 {
+			return 0, err
+		}
+	}
+	n := copy(p, lr.pending)
+	lr.pending = lr.pending[n:]
+	return n, nil
+}
+
+// lineEndingDetector is an io.Writer which notes whether the data written to it
+// contains any Windows-style "\r\n" line endings.
+type lineEndingDetector struct {
+	crlf   bool
+	prevCR bool
+}
+
+// Write satisfies the io.Writer interface.
+func (d *lineEndingDetector) Write(p []byte) (int, error) {
+	if d.crlf {
+		return len(p), nil
+	}
+	for _, b := range p {
+		if b == '\n' && d.prevCR {
+			d.crlf = true
+			break
+		}
+		d.prevCR = (b == '\r')
+	}
+	return len(p), nil
+}
 
-	var lineNumber int
-	scanner := bufio.NewScanner(strings.NewReader(f.contents))
+// maxLineLength returns the maximum line length permitted when parsing f, or
+// -1 if there is no limit.
+func (f *File) maxLineLength() int {
+	if f.MaxLineLength == 0 {
+		return DefaultMaxLineLength
+	} else if f.MaxLineLength < 0 {
+		return -1
+	}
+	return f.MaxLineLength
+}
+
+// DefaultMaxIncludeDepth is the maximum nesting depth of !include and
+// !includedir directives permitted by File.Parse, if the File's
+// MaxIncludeDepth field is 0.
+const DefaultMaxIncludeDepth = 10
+
+// fileParser tracks state while parsing a File, along with any other files
+// that it includes. The File's write lock must be held while using a
+// fileParser.
+type fileParser struct {
+	file       *File
+	cfg        *Config
+	collectAll bool
+	including  []string // paths of files currently being parsed, outermost first
+	problems   ParseErrors
+	options    map[string]*Option // options and aliases of cfg's command, keyed by canonical name; see Command.optionLookup
+	optionRows int                // number of option lines seen so far, for enforcing File.MaxOptions
+	headers    map[string]bool    // names of sections whose "[name]" header has been seen, for File.RepeatedSections
+}
+
+func newFileParser(f *File, cfg *Config, collectAll bool) *fileParser {
+	return &fileParser{
+		file:       f,
+		cfg:        cfg,
+		collectAll: collectAll,
+	}
+}
+
+// findOption returns the same result as p.cfg.FindOption, but avoids the cost
+// of recomputing the command's options for every line.
+func (p *fileParser) findOption(name string) *Option {
+	if p.options == nil {
+		p.options = p.cfg.CLI.Command.optionLookup()
+	}
+	if opt, ok := p.options[canonicalOptionName(name)]; ok {
+		return opt
+	}
+	return p.cfg.FindOption(name)
+}
+
+// fail records a problem with the file's contents. If the parser is not
+// collecting all problems, the error is returned to indicate that parsing
+// should stop; otherwise nil is returned. A FileLimitError always stops
+// parsing, since the limits exist to bound the work done on malformed files.
+func (p *fileParser) fail(err error) error {
+	if _, isLimit := err.(FileLimitError); isLimit || !p.collectAll {
+		return err
+	}
+	p.problems = append(p.problems, err)
+	return nil
+}
+
+// parse scans r, storing option values in p.file. filePath is the path of the
+// file being scanned, which differs from p.file's path when processing an
+// included file. Ini-style files are scanned line-by-line; other formats are
+// handled by parseJSON, parseYAML, or parseTOML. A non-nil error indicates
+// parsing should stop.
+func (p *fileParser) parse(r io.Reader, filePath string) error {
+	// The top-level file's syntax may be set explicitly, but included files are
+	// always identified by extension
+	format := formatForPath(filePath)
+	if len(p.including) == 0 {
+		format = p.file.syntax()
+	}
+	p.including = append(p.including, filePath)
+	defer func() {
+		p.including = p.including[:len(p.including)-1]
+	}()
+
+	switch format {
+	case FileFormatJSON:
+		return p.parseJSON(r, filePath)
+	case FileFormatYAML:
+		return p.parseYAML(r, filePath)
+	case FileFormatTOML:
+		return p.parseTOML(r, filePath)
+	}
+
+	// Each file, including an included file, begins in the default section.
+	// With lazy sections, option lines of the top-level file's named sections
+	// are only stashed for now. Section headers and directives are always
+	// parsed immediately, so that the file's section names and inheritance are
+	// fully known after Parse.
+	// Conditional directives are evaluated first, and lines within inactive
+	// branches are skipped entirely.
+	section := p.file.sectionIndex[""]
+	lazy := p.file.pending != nil && len(p.including) == 1
+	var conds conditionStack
+	scanner := newLineScanner(r, p.file.maxLineLength())
 	for scanner.Scan() {
-		line := scanner.Text()
-		lineNumber++
+		line, ok, err := p.cleanLine(scanner.text, filePath, scanner.lineNumber)
+		if err != nil {
+			return err
+		} else if !ok {
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "!") {
+			if parsed, err := parseLine(trimmed); err == nil && isConditionalDirective(parsed.key) {
+				if err := conds.apply(parsed.key, parsed.value, p.file.conditions(), scanner.lineNumber); err != nil {
+					if err := p.fail(FileParseFormatError{Problem: err.Error(), FilePath: filePath, LineNumber: scanner.lineNumber}); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+		}
+		if !conds.active() {
+			continue
+		}
+		if lazy && section.Name != "" && !section.repeated && !strings.HasPrefix(trimmed, "[") && !strings.HasPrefix(trimmed, "!") {
+			if trimmed != "" && trimmed[0] != '#' && trimmed[0] != ';' {
+				if err := p.countOption(filePath, scanner.lineNumber); err != nil {
+					return err
+				}
+			}
+			pl := pendingLine{line: line, lineNumber: scanner.lineNumber, breaks: scanner.breaks}
+			p.file.pending[section.Name] = append(p.file.pending[section.Name], pl)
+			continue
+		}
+		if section, err = p.parseLineInto(section, line, filePath, scanner.lineNumber); err != nil {
+			if err := p.fail(err); err != nil {
+				return err
+			}
+		} else if len(scanner.breaks) > 0 {
+			p.recordContinuation(section, line, scanner.breaks)
+		}
+	}
+	if err := p.scanErr(scanner.Err(), filePath, scanner.lastLine+1); err != nil {
+		return err
+	} else if lineNumber, err := conds.unterminated(); err != nil {
+		return p.fail(FileParseFormatError{Problem: err.Error(), FilePath: filePath, LineNumber: lineNumber})
+	}
+	return nil
+}
 
-		parsedLine, err := parseLine(line)
+// countOption notes that an option line was found, returning a FileLimitError
+// if this exceeds p.file.MaxOptions.
+func (p *fileParser) countOption(filePath string, lineNumber int) error {
+	p.optionRows++
+	if max := p.file.MaxOptions; max > 0 && p.optionRows > max {
+		return FileLimitError{FilePath: filePath, LineNumber: lineNumber, Limit: "option lines", Max: max}
+	}
+	return nil
+}
+
+// scanErr converts an error from a bufio.Scanner into the error that Parse
+// should return. A line which is too long for the scanner's buffer results in
+// a FileLimitError for lineNumber, rather than bufio's terse ErrTooLong.
+func (p *fileParser) scanErr(err error, filePath string, lineNumber int) error {
+	if err == bufio.ErrTooLong {
+		return FileLimitError{FilePath: filePath, LineNumber: lineNumber, Limit: "line length", Max: p.file.maxLineLength()}
+	}
+	return err
+}
+
+// recordContinuation notes the positions at which an option value, which was
+// just parsed from a line spanning multiple physical lines, was split in the
+// file. This permits Write to split the value in the same way. Nothing is
+// recorded if the stored value differs from the value as written, for example
+// due to quoting or escape sequences.
+func (p *fileParser) recordContinuation(section *Section, line string, breaks []int) {
+	parsedLine, err := parseLine(line)
+	if err != nil || parsedLine.kind != lineTypeKeyValue {
+		return
+	}
+	opt := p.findOption(parsedLine.key)
+	if opt == nil || opt.accumulates() {
+		return
+	}
+	value := section.Values[opt.Name]
+	start := strings.Index(line, "=") + 1
+	start += len(line[start:]) - len(strings.TrimLeftFunc(line[start:], unicode.IsSpace))
+	if !strings.HasPrefix(line[start:], value) {
+		return
+	}
+	var valueBreaks []int
+	for _, offset := range breaks {
+		if offset > start && offset < start+len(value) {
+			valueBreaks = append(valueBreaks, offset-start)
+		}
+	}
+	if section.continued == nil {
+		section.continued = make(map[string]continuedValue)
+	}
+	section.continued[opt.Name] = continuedValue{value: value, breaks: valueBreaks}
+}
+
+// cleanLine checks a line of a file for content which cannot be parsed. NUL
+// bytes indicate a binary file, so there's no point in continuing, even when
+// collecting all errors. Invalid UTF-8 is either replaced, or reported via
+// p.fail, depending on p.file.InvalidUTF8. The returned bool is false if the
+// line should be skipped, and a non-nil error indicates parsing should stop.
+func (p *fileParser) cleanLine(line, filePath string, lineNumber int) (string, bool, error) {
+	if max := p.file.maxLineLength(); max > 0 && len(line) > max {
+		return "", false, FileLimitError{FilePath: filePath, LineNumber: lineNumber, Limit: "line length", Max: max}
+	}
+	if strings.IndexByte(line, 0) > -1 {
+		return "", false, BinaryContentError{FilePath: filePath, LineNumber: lineNumber}
+	}
+	if !utf8.ValidString(line) {
+		if p.file.InvalidUTF8 != InvalidUTF8Replace {
+			offset := invalidUTF8Offset(line)
+			return "", false, p.fail(InvalidUTF8Error{FilePath: filePath, LineNumber: lineNumber, Offset: offset, Byte: line[offset]})
+		}
+		line = strings.ToValidUTF8(line, string(utf8.RuneError))
+	}
+	return line, true, nil
+}
+
+// invalidUTF8Offset returns the byte offset of the first invalid UTF-8 sequence
+// in s, or -1 if s is valid UTF-8.
+func invalidUTF8Offset(s string) int {
+	for n, r := range s {
+		if r == utf8.RuneError {
+			if _, size := utf8.DecodeRuneInString(s[n:]); size == 1 {
+				return n
+			}
+		}
+	}
+	return -1
+}
+
+// normalizeFullWidth replaces full-width brackets around a section name, or a
+// full-width equals sign separating an option name from its value, with their
+// ASCII equivalents. Input methods for CJK languages commonly produce these
+// characters, which otherwise lead to confusing errors about unknown options.
+// Full-width characters elsewhere, such as within option values, are left
+// unchanged. The supplied line should already have leading whitespace removed.
+func normalizeFullWidth(line string) string {
+	// All of the full-width characters handled here begin with byte 0xEF in
+	// UTF-8, so lines without that byte can be returned as-is
+	if strings.IndexByte(line, 0xef) == -1 {
+		return line
+	}
+	if strings.HasPrefix(line, "\uff3b") { // full-width "["
+		line = "[" + line[len("\uff3b"):]
+	}
+	if strings.HasPrefix(line, "[") {
+		if end := strings.Index(line, "\uff3d"); end > -1 && !strings.Contains(line[:end], "]") {
+			line = line[:end] + "]" + line[end+len("\uff3d"):]
+		}
+		return line
+	}
+	if line == "" || strings.ContainsRune("#;!", rune(line[0])) {
+		return line
+	}
+	if n := strings.IndexAny(line, "=\uff1d#"); n > -1 && strings.HasPrefix(line[n:], "\uff1d") {
+		line = line[:n] + "=" + line[n+len("\uff1d"):]
+	}
+	return line
+}
+
+// include parses the file at target, or if isDir is true, all files in the
+// target directory with a ".cnf" extension (or ".ini" on Windows) in
+// alphabetical order. Relative targets are interpreted relative to the
+// directory containing the including file, fromPath. Consistent with MySQL,
+// nonexistent targets are silently skipped.
+func (p *fileParser) include(target string, isDir bool, fromPath string, lineNumber int) error {
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(fromPath), target)
+	}
+	target = filepath.Clean(target)
+	includeErr := func(format string, a ...interface{}) error {
+		return FileParseFormatError{Problem: fmt.Sprintf(format, a...), FilePath: fromPath, LineNumber: lineNumber}
+	}
+
+	maxDepth := p.file.MaxIncludeDepth
+	if maxDepth == 0 {
+		maxDepth = DefaultMaxIncludeDepth
+	}
+	if len(p.including) > maxDepth {
+		return includeErr("cannot include %s: maximum include depth of %d exceeded", target, maxDepth)
+	}
+
+	paths := []string{target}
+	if isDir {
+		var entries []os.FileInfo
+		var err error
+		if p.file.fsys != nil {
+			entries, err = p.file.fsys.ReadDir(target)
+		} else {
+			entries, err = ioutil.ReadDir(target)
+		}
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return includeErr("cannot include directory %s: %s", target, err)
+		}
+		paths = paths[:0]
+		for _, fi := range entries {
+			ext := strings.ToLower(filepath.Ext(fi.Name()))
+			if !fi.IsDir() && (ext == ".cnf" || (ext == ".ini" && runtime.GOOS == "windows")) {
+				paths = append(paths, filepath.Join(target, fi.Name()))
+			}
+		}
+	}
+
+	for _, path := range paths {
+		for n, already := range p.including {
+			if already == path {
+				chain := append(append([]string{}, p.including[n:]...), path)
+				return includeErr("include cycle: %s", strings.Join(chain, " includes "))
+			}
+		}
+		included := &File{
+			Dir:             filepath.Dir(path),
+			Name:            filepath.Base(path),
+			AllowNonRegular: p.file.AllowNonRegular,
+			MaxSize:         p.file.MaxSize,
+			MaxLineLength:   p.file.MaxLineLength,
+			Encoding:        p.file.Encoding,
+			fsys:            p.file.fsys,
+		}
+		r, err := included.open()
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return includeErr("cannot include %s: %s", path, err)
+		}
+		var decoded io.Reader
+		if decoded, err = included.decoder(r); err == nil {
+			err = p.parse(decoded, path)
+		}
+		r.Close()
 		if err != nil {
-			return FileParseFormatError{
-				Problem:    err.Error(),
-				FilePath:   f.Path(),
+			return err
+		}
+	}
+	return nil
+}
+
+// parseLineInto parses a single line of a file, storing any option value in
+// section. It returns the section that subsequent lines should be stored in,
+// which will differ from the supplied section if the line was a section
+// header.
+func (p *fileParser) parseLineInto(section *Section, line, filePath string, lineNumber int) (*Section, error) {
+	f, cfg := p.file, p.cfg
+	parsedLine, err := parseLine(line)
+	if err != nil {
+		return section, FileParseFormatError{
+			Problem:    err.Error(),
+			FilePath:   filePath,
+			LineNumber: lineNumber,
+		}
+	}
+
+	switch parsedLine.kind {
+	case lineTypeSectionHeader:
+		name := parsedLine.sectionName
+		repeated := parsedLine.repeated || (f.RepeatedSections && name != "" && p.headers[name])
+		if max := f.MaxSections; max > 0 && (repeated || f.sectionIndex[name] == nil) && len(f.sections)+len(f.blocks) >= max {
+			return section, FileLimitError{FilePath: filePath, LineNumber: lineNumber, Limit: "sections", Max: max}
+		}
+		// An unknown section is still created, so that its option lines are not
+		// attributed to the previous section if parsing continues
+		if repeated {
+			section = &Section{Name: name, Values: make(map[string]string), opts: make(map[string]*Option), repeated: true}
+			f.blocks = append(f.blocks, section)
+		} else {
+			if p.headers == nil {
+				p.headers = make(map[string]bool)
+			}
+			p.headers[name] = true
+			section = f.getOrCreateSection(name)
+		}
+		if !f.sectionAllowed(section.Name) {
+			return section, UnknownSectionError{
+				Section:    section.Name,
+				Permitted:  f.allowedSections,
+				Suggestion: sectionSuggestion(section.Name, f.allowedSections),
+				FilePath:   filePath,
 				LineNumber: lineNumber,
 			}
 		}
-
-		switch parsedLine.kind {
-		case lineTypeSectionHeader:
-			section = f.getOrCreateSection(parsedLine.sectionName)
-		case lineTypeKeyOnly, lineTypeKeyValue:
-			if f.ignoredOptionNames[parsedLine.key] {
-				continue
+		return section, nil
+	case lineTypeDirective:
+		switch parsedLine.key {
+		case "inherit", "extends":
+			if parsedLine.value == "" {
+				return section, FileParseFormatError{Problem: fmt.Sprintf("!%s directive missing section name", parsedLine.key), FilePath: filePath, LineNumber: lineNumber}
+			}
+			for _, parentName := range section.Inherits {
+				if parentName == parsedLine.value {
+					return section, nil
+				}
+			}
+			section.Inherits = append(section.Inherits, parsedLine.value)
+			section.inheritLocs = append(section.inheritLocs, lineLocation{filePath: filePath, lineNumber: lineNumber})
+		case "include", "includedir":
+			if parsedLine.value == "" {
+				return section, FileParseFormatError{Problem: fmt.Sprintf("!%s directive missing path", parsedLine.key), FilePath: filePath, LineNumber: lineNumber}
+			}
+			if len(p.including) == 1 {
+				f.includes = append(f.includes, includeDirective{section: section, line: "!" + parsedLine.key + " " + parsedLine.value, lineNumber: lineNumber})
+			}
+			return section, p.include(parsedLine.value, parsedLine.key == "includedir", filePath, lineNumber)
+		default:
+			return section, FileParseFormatError{Problem: fmt.Sprintf("unknown directive !%s", parsedLine.key), FilePath: filePath, LineNumber: lineNumber}
+		}
+	case lineTypeKeyOnly, lineTypeKeyValue:
+		if err := p.countOption(filePath, lineNumber); err != nil {
+			return section, err
+		}
+		if f.ignoredOptionNames[parsedLine.key] {
+			return section, nil
+		}
+		loc := lineLocation{filePath: filePath, lineNumber: lineNumber, included: len(p.including) > 1} // formatted only if needed, for performance
+		opt := p.findOption(parsedLine.key)
+		var candidates []string
+		if opt == nil && cfg.CLI.Command.Root().OptionPrefixes {
+			if opt, candidates = optionPrefixMatch(parsedLine.key, cfg.CLI.Command.Options()); opt != nil {
+				parsedLine.key = opt.Name // treat prefix as a spelling of the canonical name
+			}
+		}
+		if opt == nil {
+			// Retain the line in case the option is registered later; see
+			// Config.ReevaluateUnknowns
+			unknown := unknownLine{section: section, line: line, lineLocation: loc}
+			if parsedLine.isLoose || f.IgnoreUnknownOptions || cfg.LooseFileOptions {
+				f.unknownLines = append(f.unknownLines, unknown)
+				return section, nil
+			} else if cfg.DeferUnknownOptions {
+				unknown.mustMatch = true
+				f.unknownLines = append(f.unknownLines, unknown)
+				return section, nil
+			}
+			if len(candidates) > 0 {
+				return section, OptionAmbiguousError{Name: parsedLine.key, Candidates: candidates, Source: loc.String(), FilePath: filePath, LineNumber: lineNumber}
+			}
+			suggestion := optionSuggestion(parsedLine.key, cfg.CLI.Command.Options())
+			return section, OptionNotDefinedError{Name: parsedLine.key, Source: loc.String(), FilePath: filePath, LineNumber: lineNumber, Suggestion: suggestion}
+		}
+		if !opt.permittedInSection(section.Name) {
+			return section, OptionSectionError{Name: opt.Name, Section: section.Name, Permitted: opt.sections, Source: loc.String(), FilePath: filePath, LineNumber: lineNumber}
+		}
+		if parsedLine.kind == lineTypeKeyOnly {
+			if opt.RequireValue {
+				return section, OptionMissingValueError{Name: opt.Name, Source: loc.String(), FilePath: filePath, LineNumber: lineNumber}
+			} else if opt.Type == OptionTypeBool || opt.Type == OptionTypeCount {
+				// For booleans, option without value indicates option is being enabled
+				parsedLine.value = "1"
+			}
+		} else if parsedLine.value == "" && opt.Type == OptionTypeString {
+			// Convert empty strings into quote-wrapped empty strings, so that callers
+			// may differentiate between bare "foo" vs "foo=" if desired, by using
+			// Config.GetRaw(). Meanwhile Config.Get and most other getters strip
+			// surrounding quotes, so this does not break anything.
+			parsedLine.value = "''"
+		} else if parsedLine.value == "" && opt.Type == OptionTypeCount {
+			parsedLine.value = "0" // "skip-" prefix resets a count
+		}
+		var ciphertext string
+		if decrypted, ok, err := decryptValue(parsedLine.value); ok {
+			if err == nil && opt.accumulates() {
+				err = errors.New("encrypted values are not supported for options which accumulate values")
+			}
+			if err != nil {
+				return section, OptionValueError{
+					Name:       opt.Name,
+					Value:      opt.displayValue(parsedLine.value),
+					Problem:    err.Error(),
+					Source:     loc.String(),
+					FilePath:   filePath,
+					LineNumber: lineNumber,
+				}
+			}
+			ciphertext, parsedLine.value = parsedLine.value, decrypted
+		} else if f.UnquotedEscapes && strings.ContainsRune(parsedLine.value, '\\') && unquote(parsedLine.value) == parsedLine.value {
+			// Escape sequences in unquoted values are interpreted here, since getters
+			// only unescape quoted values. The result is quoted as needed.
+			if unescaped := unescape(parsedLine.value, true); unescaped != parsedLine.value {
+				parsedLine.value = QuoteValue(unescaped)
+			}
+		}
+		if err := opt.checkValue(parsedLine.value); err != nil {
+			return section, OptionValueError{
+				Name:       opt.Name,
+				Value:      opt.displayValue(unquote(parsedLine.value)),
+				Problem:    err.Error(),
+				Source:     loc.String(),
+				FilePath:   filePath,
+				LineNumber: lineNumber,
 			}
-			opt := cfg.FindOption(parsedLine.key)
-			if opt == nil {
-				if parsedLine.isLoose || f.IgnoreUnknownOptions || cfg.LooseFileOptions {
-					continue
-				} else {
-					return OptionNotDefinedError{parsedLine.key, fmt.Sprintf("%s line %d", f.Path(), lineNumber)}
+		}
+		if prevLoc, seen := section.valueLocs[opt.Name]; seen {
+			switch policy := f.DuplicateOptions; {
+			case policy == DuplicateFirstWins:
+				return section, nil
+			case policy == DuplicateLastWins:
+				delete(section.Values, opt.Name) // so that multi-valued options do not accumulate
+			case opt.accumulates():
+			case policy == DuplicateWarn:
+				// The lock on f.mu is held here, so the warning is reported by f.unlock
+				f.warnings = append(f.warnings, fileWarning{cfg: cfg, message: fmt.Sprintf("%s: option %s was already set at %s in the same section; this value overrides it", loc, opt.Name, prevLoc)})
+			case policy == DuplicateError:
+				return section, DuplicateOptionError{
+					Name:       opt.Name,
+					Previous:   prevLoc.String(),
+					Source:     loc.String(),
+					FilePath:   filePath,
+					LineNumber: lineNumber,
 				}
 			}
-			if parsedLine.kind == lineTypeKeyOnly {
-				if opt.RequireValue {
-					return OptionMissingValueError{opt.Name, fmt.Sprintf("%s line %d", f.Path(), lineNumber)}
-				} else if opt.Type == OptionTypeBool {
-					// For booleans, option without value indicates option is being enabled
-					parsedLine.value = "1"
-				}
-			} else if parsedLine.value == "" && opt.Type == OptionTypeString {
-				// Convert empty strings into quote-wrapped empty strings, so that callers
-				// may differentiate between bare "foo" vs "foo=" if desired, by using
-				// Config.GetRaw(). Meanwhile Config.Get and most other getters strip
-				// surrounding quotes, so this does not break anything.
-				parsedLine.value = "''"
+		}
+		loc.spelling = parsedLine.key
+		if prevLoc, seen := section.valueLocs[opt.Name]; seen && prevLoc.spelling != loc.spelling && section.Values[opt.Name] != parsedLine.value && !opt.accumulates() && !cfg.CLI.Command.Root().LastAliasWins {
+			return section, OptionAliasConflictError{
+				Name:       opt.Name,
+				Spellings:  [2]string{prevLoc.spelling, loc.spelling},
+				Source:     loc.String(),
+				FilePath:   filePath,
+				LineNumber: lineNumber,
+			}
+		}
+		section.Values[opt.Name] = opt.accumulate(section.Values[opt.Name], parsedLine.value)
+		section.opts[opt.Name] = opt
+		if section.valueLocs == nil {
+			section.valueLocs = make(map[string]lineLocation)
+		}
+		section.valueLocs[opt.Name] = loc
+		if ciphertext != "" {
+			if section.encrypted == nil {
+				section.encrypted = make(map[string]encryptedValue)
 			}
-			section.Values[parsedLine.key] = parsedLine.value
-			section.opts[parsedLine.key] = opt
+			section.encrypted[opt.Name] = encryptedValue{ciphertext: ciphertext, value: section.Values[opt.Name]}
+		} else {
+			delete(section.encrypted, opt.Name)
 		}
 	}
+	return section, nil
+}
 
-	f.parsed = true
-	f.selected = []string{""}
-	return scanner.Err()
+// reevaluateUnknowns re-processes any lines which previously referred to
+// unknown options. Lines that now correspond to a known option are applied,
+// in their original order. Errors are returned for any lines that still refer
+// to an unknown option, unless they were ignorable at the time of parsing.
+func (f *File) reevaluateUnknowns(cfg *Config) (errs []error) {
+	f.mu.Lock()
+	defer f.unlock()
+	f.assertUnsealed("ReevaluateUnknowns")
+	var stillUnknown []unknownLine
+	p := newFileParser(f, cfg, true)
+	for _, unknown := range f.unknownLines {
+		parsedLine, _ := parseLine(unknown.line) // already parsed successfully once
+		if cfg.FindOption(parsedLine.key) != nil {
+			if _, err := p.parseLineInto(unknown.section, unknown.line, unknown.filePath, unknown.lineNumber); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		stillUnknown = append(stillUnknown, unknown)
+		if unknown.mustMatch {
+			errs = append(errs, OptionNotDefinedError{
+				Name:       parsedLine.key,
+				Source:     unknown.lineLocation.String(),
+				FilePath:   unknown.filePath,
+				LineNumber: unknown.lineNumber,
+			})
+		}
+	}
+	f.unknownLines = stillUnknown
+	return errs
 }
 
 // UseSection changes which section(s) of the file are used when calling
@@ -236,52 +1889,251 @@ func (f *File) Parse(cfg *Config) error {
 // prior to a section header) is automatically appended to the end of the list.
 // So this section is always checked, at lowest priority, need not be
 // passed to this function.
+//
+// A name containing glob metacharacters (as used by path.Match, e.g. "prod-*")
+// selects every matching named section, in the order they appear in the file,
+// unless the file has a section with that exact name. A pattern which matches
+// no sections is treated like a missing section name.
+//
+// If f.LazySections was true when the file was parsed, the options of the
+// selected sections, and of any sections they inherit from, are parsed now if
+// they have not been already. Any problem with their contents is returned,
+// taking precedence over a SectionNotFoundError. Other methods which examine
+// every section, such as Sections or SectionValues, also parse any remaining
+// deferred sections first, but ignore problems with their contents; Write
+// returns such problems instead of writing.
 func (f *File) UseSection(names ...string) error {
+	f.mu.Lock()
+	defer f.unlock()
+	f.assertUnsealed("UseSection")
+	f.bumpGeneration()
 	notFound := make([]string, 0)
 	already := make(map[string]bool, len(names))
 	f.selected = make([]string, 0, len(names)+1)
 
 	for _, name := range names {
+		if _, ok := f.sectionIndex[name]; !ok && isSectionPattern(name) {
+			matches := f.sectionsLike(name)
+			if len(matches) == 0 {
+				notFound = append(notFound, name)
+			}
+			for _, match := range matches {
+				if !already[match] {
+					already[match] = true
+					f.selected = append(f.selected, match)
+				}
+			}
+			continue
+		}
 		if already[name] {
 			continue
 		}
 		already[name] = true
-		if f.HasSection(name) {
+		if _, ok := f.sectionIndex[name]; ok {
 			f.selected = append(f.selected, name)
 		} else {
 			notFound = append(notFound, name)
 		}
 	}
 	if !already[""] {
-		f.selected = append(names, "")
+		f.selected = append(f.selected, "")
+	}
+	if err := f.loadPending(f.selected); err != nil {
+		return err
 	}
 
 	if len(notFound) == 0 {
 		return nil
 	}
-	return fmt.Errorf("File %s missing section: %s", f.Path(), strings.Join(notFound, ", "))
+	return SectionNotFoundError{FilePath: f.Path(), Sections: notFound}
+}
+
+// loadPending parses the deferred lines of the named sections, along with any
+// sections they inherit from, or of all sections if names is nil. This only
+// has an effect if f.LazySections was true when the file was parsed. A problem
+// with one line does not prevent parsing the remaining lines; the first such
+// problem is returned. The caller must hold a write lock on f.mu.
+func (f *File) loadPending(names []string) (firstErr error) {
+	if len(f.pending) == 0 {
+		return nil
+	}
+	if names == nil {
+		for _, section := range f.sections {
+			names = append(names, section.Name)
+		}
+	}
+	p := newFileParser(f, f.pendingCfg, false)
+	p.including = []string{f.Path()}
+	for len(names) > 0 {
+		name := names[0]
+		names = names[1:]
+		lines, ok := f.pending[name]
+		if !ok {
+			continue
+		}
+		delete(f.pending, name)
+		f.bumpGeneration()
+		section := f.sectionIndex[name]
+		names = append(names, section.Inherits...)
+		for _, pl := range lines {
+			if _, err := p.parseLineInto(section, pl.line, f.Path(), pl.lineNumber); err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+			} else if len(pl.breaks) > 0 {
+				p.recordContinuation(section, pl.line, pl.breaks)
+			}
+		}
+	}
+	return firstErr
+}
+
+// unlock releases the write lock on f.mu, and then reports any warnings queued
+// while it was held. Warnings are not reported while holding the lock, since
+// a Config's WarningHandler may itself access f. Any method which parses, or
+// may parse deferred sections via loadPending, must use this instead of
+// f.mu.Unlock.
+func (f *File) unlock() {
+	warnings := f.warnings
+	f.warnings = nil
+	f.mu.Unlock()
+	for _, warning := range warnings {
+		warning.cfg.Warn("%s", warning.message)
+	}
+}
+
+// loadAllPending parses any deferred sections, for use by methods which
+// examine every section of the file. Problems with the deferred lines are
+// ignored here. The caller must not hold a lock on f.mu.
+func (f *File) loadAllPending() {
+	f.mu.RLock()
+	count := len(f.pending)
+	f.mu.RUnlock()
+	if count > 0 {
+		f.mu.Lock()
+		f.loadPending(nil)
+		f.unlock()
+	}
+}
+
+// Sections returns all sections of the file, in the order they appear in the
+// file, beginning with the default nameless section "". The returned Sections
+// are copies, so they may be freely inspected even if the File is shared
+// between goroutines, but modifying them has no effect on the File; use
+// SetOptionValue or UnsetOptionValue instead.
+func (f *File) Sections() []*Section {
+	f.loadAllPending()
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	result := make([]*Section, len(f.sections))
+	for n, section := range f.sections {
+		result[n] = section.clone()
+	}
+	return result
 }
 
 // HasSection returns true if the file has a section with the supplied name.
 func (f *File) HasSection(name string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
 	_, ok := f.sectionIndex[name]
 	return ok
 }
 
+// SectionsNamed returns every section with the supplied name, in order. This
+// permits an option file to describe a list of similar resources, each in its
+// own section, by using "[[name]]" headers; or by repeating a "[name]" header,
+// if f.RepeatedSections is true. Without either, values from repeated headers
+// are merged into a single section, so this returns at most one section.
+//
+// The result begins with the section used by UseSection and OptionValue, if
+// there is one, followed by each section from a "[[name]]" header, or a
+// repeated header if f.RepeatedSections is true, in file order. These sections
+// only contain their own values, and they cannot be selected by UseSection.
+// The returned Sections are copies, as with Sections. Returns nil if there are
+// no sections with the name.
+func (f *File) SectionsNamed(name string) []*Section {
+	f.mu.Lock()
+	defer f.unlock()
+	f.loadPending([]string{name})
+	var result []*Section
+	if section, ok := f.sectionIndex[name]; ok {
+		result = append(result, section.clone())
+	}
+	for _, section := range f.blocks {
+		if section.Name == name {
+			result = append(result, section.clone())
+		}
+	}
+	return result
+}
+
+// SectionsLike returns the names of all named sections matching the supplied
+// glob pattern, using the syntax of path.Match, in the order they appear in
+// the file. The default nameless section "" is never included. Returns an
+// empty slice if the pattern is malformed or matches no sections.
+func (f *File) SectionsLike(pattern string) []string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.sectionsLike(pattern)
+}
+
+// sectionsLike is the implementation of SectionsLike. The caller must hold a
+// lock on f.mu.
+func (f *File) sectionsLike(pattern string) []string {
+	result := make([]string, 0)
+	for _, section := range f.sections {
+		if section.Name == "" {
+			continue
+		}
+		if matched, err := path.Match(pattern, section.Name); err == nil && matched {
+			result = append(result, section.Name)
+		}
+	}
+	return result
+}
+
+// isSectionPattern returns true if name contains any glob metacharacters
+// recognized by path.Match.
+func isSectionPattern(name string) bool {
+	return strings.ContainsAny(name, `*?[\`)
+}
+
 // SectionsWithOption returns a list of section names that set the supplied
-// option name.
+// option name. The option name is normalized, and may be an alias of an option
+// previously parsed into the file.
 func (f *File) SectionsWithOption(optionName string) []string {
+	f.loadAllPending()
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	optionName = canonicalOptionName(optionName)
 	result := make([]string, 0, len(f.sections))
 	for _, section := range f.sections {
-		if _, ok := section.Values[optionName]; ok {
+		if _, ok := section.Values[section.resolveOptionName(optionName)]; ok {
 			result = append(result, section.Name)
 		}
 	}
 	return result
 }
 
+// SectionHasOption returns true if the named section explicitly sets the
+// supplied option name, regardless of whether the section is currently
+// selected, and regardless of whether the value equals the option's default.
+// Returns false if the section does not exist.
+func (f *File) SectionHasOption(sectionName, optionName string) bool {
+	f.loadAllPending()
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	section, ok := f.sectionIndex[sectionName]
+	if !ok {
+		return false
+	}
+	_, ok = section.Values[section.resolveOptionName(canonicalOptionName(optionName))]
+	return ok
+}
+
 // SomeSectionHasOption returns true if at least one section sets the supplied
-// option name.
+// option name, which is normalized in the same manner as SectionsWithOption.
 func (f *File) SomeSectionHasOption(optionName string) bool {
 	return len(f.SectionsWithOption(optionName)) > 0
 }
@@ -293,41 +2145,400 @@ func (f *File) SomeSectionHasOption(optionName string) bool {
 // This is satisfies the OptionValuer interface, allowing Files to be used as
 // an option source in Config.
 func (f *File) OptionValue(optionName string) (string, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
 	if !f.parsed {
 		panic(fmt.Errorf("Call to OptionValue(\"%s\") on unparsed file %s", optionName, f.Path()))
 	}
+	optionName = canonicalOptionName(optionName)
 	for _, sectionName := range f.selected {
 		section := f.sectionIndex[sectionName]
 		if section == nil {
 			continue
 		}
-		if value, ok := section.Values[optionName]; ok {
+		if value, ok := f.sectionValue(section, optionName, 0); ok {
 			return value, true
 		}
 	}
 	return "", false
 }
 
+// sectionValue returns the value of optionName in section, either set
+// directly in the section or inherited from another section. The caller must
+// hold a read lock on f.mu.
+func (f *File) sectionValue(section *Section, optionName string, depth int) (string, bool) {
+	if owner := f.valueSection(section, optionName, depth); owner != nil {
+		return owner.Values[optionName], true
+	}
+	return "", false
+}
+
+// valueLocation returns the file and line number that supplied the value of
+// optionName, as returned by OptionValue. The bool is false if the option has
+// no value in the selected sections, or if its value was not parsed from a
+// line of a file, for example if it was set via SetOptionValue.
+func (f *File) valueLocation(optionName string) (lineLocation, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	optionName = canonicalOptionName(optionName)
+	for _, sectionName := range f.selected {
+		if section := f.sectionIndex[sectionName]; section != nil {
+			if owner := f.valueSection(section, optionName, 0); owner != nil {
+				loc, ok := owner.valueLocs[optionName]
+				return loc, ok
+			}
+		}
+	}
+	return lineLocation{}, false
+}
+
+// valueSection returns the section which supplies the value of optionName to
+// section: either section itself, or a section it inherits from. Returns nil
+// if no value is available. The caller must hold a read lock on f.mu.
+func (f *File) valueSection(section *Section, optionName string, depth int) *Section {
+	if _, ok := section.Values[optionName]; ok {
+		return section
+	}
+	if depth > len(f.sections) { // inheritance cycle; Parse prevents this but be defensive
+		return nil
+	}
+	for n := len(section.Inherits) - 1; n >= 0; n-- {
+		if parent := f.sectionIndex[section.Inherits[n]]; parent != nil {
+			if owner := f.valueSection(parent, optionName, depth+1); owner != nil {
+				return owner
+			}
+		}
+	}
+	return nil
+}
+
+// SectionValues returns a map of option name => value for the named section,
+// including any values inherited from other sections. The returned map is a
+// copy, so modifying it does not affect the file. Returns nil if the section
+// does not exist.
+func (f *File) SectionValues(sectionName string) map[string]string {
+	f.loadAllPending()
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	section, ok := f.sectionIndex[sectionName]
+	if !ok {
+		return nil
+	}
+	result := make(map[string]string, len(section.Values))
+	f.mergeSectionValues(section, result, 0)
+	return result
+}
+
+// mergeSectionValues copies the values of section into dest, after first
+// recursively copying any inherited values. The caller must hold a read lock
+// on f.mu.
+func (f *File) mergeSectionValues(section *Section, dest map[string]string, depth int) {
+	if depth <= len(f.sections) {
+		for _, parentName := range section.Inherits {
+			if parent := f.sectionIndex[parentName]; parent != nil {
+				f.mergeSectionValues(parent, dest, depth+1)
+			}
+		}
+	}
+	for name, value := range section.Values {
+		dest[name] = value
+	}
+}
+
+// inheritanceErrors returns errors for any sections which inherit from a
+// nonexistent section, or any inheritance cycles. The caller must hold a read
+// lock on f.mu.
+func (f *File) inheritanceErrors() (errs []error) {
+	location := func(section *Section, n int) lineLocation {
+		if n < len(section.inheritLocs) {
+			return section.inheritLocs[n]
+		}
+		return lineLocation{filePath: f.Path()}
+	}
+	for _, section := range f.sections {
+		for n, parentName := range section.Inherits {
+			if _, ok := f.sectionIndex[parentName]; !ok {
+				loc := location(section, n)
+				errs = append(errs, FileParseFormatError{
+					Problem:    fmt.Sprintf("section [%s] inherits from nonexistent section [%s]", section.Name, parentName),
+					FilePath:   loc.filePath,
+					LineNumber: loc.lineNumber,
+				})
+			}
+		}
+	}
+
+	// Depth-first search for cycles, tracking the current path of section names
+	const unvisited, visiting, visited = 0, 1, 2
+	state := make(map[*Section]int, len(f.sections))
+	var path []string
+	var visit func(*Section)
+	visit = func(section *Section) {
+		state[section] = visiting
+		path = append(path, section.Name)
+		for n, parentName := range section.Inherits {
+			parent := f.sectionIndex[parentName]
+			if parent == nil {
+				continue
+			}
+			if state[parent] == visiting {
+				var cycleStart int
+				for cycleStart = range path {
+					if path[cycleStart] == parentName {
+						break
+					}
+				}
+				chain := append(append([]string{}, path[cycleStart:]...), parentName)
+				loc := location(section, n)
+				errs = append(errs, FileParseFormatError{
+					Problem:    fmt.Sprintf("section inheritance cycle: [%s]", strings.Join(chain, "] inherits [")),
+					FilePath:   loc.filePath,
+					LineNumber: loc.lineNumber,
+				})
+			} else if state[parent] == unvisited {
+				visit(parent)
+			}
+		}
+		path = path[:len(path)-1]
+		state[section] = visited
+	}
+	for _, section := range f.sections {
+		if state[section] == unvisited {
+			visit(section)
+		}
+	}
+	return errs
+}
+
 // SetOptionValue sets an option value in the named section. This is not
 // persisted to the file until Write is called on the File.
 // If the caller plans to subsequently read configuration values from this
 // same File object, it is the caller's responsibility to normalize the
-// optionName and value prior to calling this method, and call MarkDirty() on
-// any relevant Configs. These shortcomings will be fixed in a future release.
+// optionName and value prior to calling this method. Any Config using this
+// File as a source automatically reflects the change.
 func (f *File) SetOptionValue(sectionName, optionName, value string) {
+	f.mu.Lock()
+	defer f.unlock()
+	f.assertUnsealed("SetOptionValue")
+	f.loadPending([]string{sectionName})
 	section := f.getOrCreateSection(sectionName)
 	section.Values[optionName] = value
+	f.markEdited(sectionName, optionName)
 }
 
 // UnsetOptionValue removes an option value in the named section. This is not
 // persisted to the file until Write is called on the File.
 // If the caller plans to subsequently read configuration values from this
 // same File object, it is the caller's responsibility to normalize the
-// optionName and value prior to calling this method, and call MarkDirty() on
-// any relevant Configs. These shortcomings will be fixed in a future release.
+// optionName prior to calling this method. Any Config using this File as a
+// source automatically reflects the change.
 func (f *File) UnsetOptionValue(sectionName, optionName string) {
+	f.mu.Lock()
+	defer f.unlock()
+	f.assertUnsealed("UnsetOptionValue")
+	f.loadPending([]string{sectionName})
 	section := f.getOrCreateSection(sectionName)
 	delete(section.Values, optionName)
+	f.markEdited(sectionName, optionName)
+}
+
+// DeleteOption removes an option value from the named section, returning true
+// if the section had a value for the option. Unlike UnsetOptionValue, the
+// option name is normalized, and may be an alias of an option previously
+// parsed into the section. As with UnsetOptionValue, this is not persisted to
+// the file until Write is called, and any Config using this File as a source
+// automatically reflects the change.
+func (f *File) DeleteOption(sectionName, optionName string) bool {
+	f.mu.Lock()
+	defer f.unlock()
+	f.assertUnsealed("DeleteOption")
+	f.loadPending([]string{sectionName})
+	return f.deleteOption(sectionName, optionName)
+}
+
+// deleteOption implements DeleteOption. The caller must hold a write lock on
+// f.mu, and must have already loaded any pending lines of the section.
+func (f *File) deleteOption(sectionName, optionName string) bool {
+	section := f.sectionIndex[sectionName]
+	if section == nil {
+		return false
+	}
+	optionName = section.resolveOptionName(canonicalOptionName(optionName))
+	if _, ok := section.Values[optionName]; !ok {
+		return false
+	}
+	delete(section.Values, optionName)
+	f.markEdited(sectionName, optionName)
+	return true
+}
+
+// DeleteSection removes the named section and all of its values, returning
+// true if the section existed. The default nameless section "" cannot be
+// removed, so its values are removed instead. When Write preserves the
+// original contents (see PreserveFormatting), the section's header and all
+// lines within it, including comments, are removed. Other sections which
+// inherit from the deleted section are not modified. Any Config using this
+// File as a source automatically reflects the change.
+func (f *File) DeleteSection(name string) bool {
+	f.mu.Lock()
+	defer f.unlock()
+	f.assertUnsealed("DeleteSection")
+	f.loadPending([]string{name})
+	return f.deleteSection(name)
+}
+
+// deleteSection implements DeleteSection. The caller must hold a write lock on
+// f.mu, and must have already loaded any pending lines of the section.
+func (f *File) deleteSection(name string) bool {
+	section := f.sectionIndex[name]
+	if section == nil {
+		return false
+	}
+	for optionName := range section.Values {
+		delete(section.Values, optionName)
+		f.markEdited(name, optionName)
+	}
+	f.bumpGeneration()
+	if name == "" {
+		return true
+	}
+	delete(f.sectionIndex, name)
+	for n := range f.sections {
+		if f.sections[n] == section {
+			f.sections = append(f.sections[:n], f.sections[n+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// RenameSection changes the name of a section, retaining its values. An error
+// is returned if oldName does not exist (a SectionNotFoundError), if newName
+// already exists (a SectionExistsError), or if either is the default nameless
+// section "". When Write preserves the original contents (see
+// PreserveFormatting), only the section's header line is changed. Any !inherit
+// directives naming the section are not modified. Any Config using this File
+// as a source automatically reflects the change.
+func (f *File) RenameSection(oldName, newName string) error {
+	f.mu.Lock()
+	defer f.unlock()
+	f.assertUnsealed("RenameSection")
+	f.loadPending([]string{oldName, newName})
+	return f.renameSection(oldName, newName)
+}
+
+// renameSection implements RenameSection. The caller must hold a write lock on
+// f.mu, and must have already loaded any pending lines of both sections.
+func (f *File) renameSection(oldName, newName string) error {
+	section := f.sectionIndex[oldName]
+	if oldName == "" || newName == "" {
+		return fmt.Errorf("%s: Cannot rename the default section", f.Path())
+	} else if section == nil {
+		return SectionNotFoundError{FilePath: f.Path(), Sections: []string{oldName}}
+	} else if f.sectionIndex[newName] != nil {
+		return SectionExistsError{FilePath: f.Path(), Section: newName}
+	}
+	section.Name = newName
+	delete(f.sectionIndex, oldName)
+	f.sectionIndex[newName] = section
+	if edited := f.edited[oldName]; edited != nil {
+		if f.edited[newName] == nil {
+			f.edited[newName] = make(map[string]bool)
+		}
+		for optionName := range edited {
+			f.edited[newName][optionName] = true
+		}
+		delete(f.edited, oldName)
+	}
+
+	// Track the name of the section's header in the file's existing contents,
+	// which may differ from oldName if the section was already renamed
+	headerName := oldName
+	for from, to := range f.renamed {
+		if to == oldName {
+			headerName = from
+		}
+	}
+	if f.renamed == nil {
+		f.renamed = make(map[string]string)
+	}
+	f.renamed[headerName] = newName
+	f.bumpGeneration()
+	return nil
+}
+
+// PruneDefaults removes values which merely mirror their option's default
+// value, so that a subsequent Write only persists options which were set to a
+// non-default value. This is useful after populating a File programmatically,
+// for example via Config.ExportToFile. If any section names are supplied, only
+// those sections are pruned; otherwise all sections are pruned. Option
+// definitions are obtained from cfg; values for unknown options, and for
+// options whose default is computed via Option.SetDefaultFunc, are retained.
+// Boolean values are compared by truthiness, and other values after removing
+// any surrounding quotes. Returns the number of values removed.
+func (f *File) PruneDefaults(cfg *Config, sectionNames ...string) int {
+	f.mu.Lock()
+	defer f.unlock()
+	f.assertUnsealed("PruneDefaults")
+	f.loadPending(nil)
+	if len(sectionNames) == 0 {
+		for _, section := range f.sections {
+			sectionNames = append(sectionNames, section.Name)
+		}
+	}
+	var removed int
+	for _, sectionName := range sectionNames {
+		section := f.sectionIndex[sectionName]
+		if section == nil {
+			continue
+		}
+		for name, value := range section.Values {
+			opt := cfg.FindOption(name)
+			if opt == nil || opt.defaultFunc != nil {
+				continue
+			}
+			if (opt.Type == OptionTypeBool && BoolValue(unquote(value)) == BoolValue(opt.Default)) || (opt.Type != OptionTypeBool && unquote(value) == opt.Default) {
+				delete(section.Values, name)
+				f.markEdited(sectionName, name)
+				removed++
+			}
+		}
+	}
+	return removed
+}
+
+// fileGenerations is the source of values for File.generation. Using a single
+// package-wide counter ensures that a File's generation never repeats, and
+// never matches the generation of a different File.
+var fileGenerations uint64
+
+// bumpGeneration changes f's generation, indicating to any Config using f as
+// a source that its caches must be rebuilt. The caller must hold a write lock
+// on f.mu.
+func (f *File) bumpGeneration() {
+	atomic.StoreUint64(&f.generation, atomic.AddUint64(&fileGenerations, 1))
+}
+
+// editGeneration satisfies the editTracker interface. It does not acquire
+// f.mu, since Config calls it for every source on every option lookup.
+func (f *File) editGeneration() uint64 {
+	return atomic.LoadUint64(&f.generation)
+}
+
+// markEdited tracks that an option has been modified since the last Write.
+// The caller must hold a write lock on f.mu.
+func (f *File) markEdited(sectionName, optionName string) {
+	f.bumpGeneration()
+	if section := f.sectionIndex[sectionName]; section != nil {
+		delete(section.valueLocs, optionName) // no longer corresponds to a line
+	}
+	if f.edited == nil {
+		f.edited = make(map[string]map[string]bool)
+	}
+	if f.edited[sectionName] == nil {
+		f.edited[sectionName] = make(map[string]bool)
+	}
+	f.edited[sectionName][optionName] = true
 }
 
 // SameContents returns true if f and other have the same sections and values.
@@ -337,25 +2548,58 @@ func (f *File) UnsetOptionValue(sectionName, optionName string) {
 // error.
 // This method is primarily intended for unit testing purposes.
 func (f *File) SameContents(other *File) bool {
-	if !f.parsed || !other.parsed {
+	// Each file's contents are copied while holding only that file's lock, since
+	// holding both locks at once could deadlock with a concurrent call which
+	// compares the same files in the opposite order
+	mine, myParsed := f.contentsSnapshot()
+	theirs, theirParsed := other.contentsSnapshot()
+	if !myParsed || !theirParsed {
 		panic(errors.New("File.SameContents called on a file that has not yet been parsed"))
 	}
-	if len(f.sectionIndex) != len(other.sectionIndex) {
+	if len(mine) != len(theirs) {
 		return false
 	}
-	for name := range f.sectionIndex {
-		a := f.sectionIndex[name]
-		b, ok := other.sectionIndex[name]
+	for name := range mine {
+		a := mine[name]
+		b, ok := theirs[name]
 		if !ok || a.Name != b.Name {
 			return false
 		}
 		if !reflect.DeepEqual(a.Values, b.Values) {
 			return false
 		}
+		if len(a.Inherits) != len(b.Inherits) || (len(a.Inherits) > 0 && !reflect.DeepEqual(a.Inherits, b.Inherits)) {
+			return false
+		}
 	}
 	return true
 }
 
+// contentsSnapshot returns a copy of the name, values, and inheritance of each
+// of f's sections, keyed by section name, for use by SameContents. The
+// returned bool is false if f has not been parsed.
+func (f *File) contentsSnapshot() (map[string]*Section, bool) {
+	f.loadAllPending()
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if !f.parsed {
+		return nil, false
+	}
+	snapshot := make(map[string]*Section, len(f.sectionIndex))
+	for name, section := range f.sectionIndex {
+		values := make(map[string]string, len(section.Values))
+		for key, value := range section.Values {
+			values[key] = value
+		}
+		snapshot[name] = &Section{
+			Name:     section.Name,
+			Values:   values,
+			Inherits: append([]string(nil), section.Inherits...),
+		}
+	}
+	return snapshot, true
+}
+
 // IgnoreOptions causes the supplied option names to be ignored by a subsequent
 // call to Parse. The supplied option names do not need to exist as valid
 // options.
@@ -363,14 +2607,50 @@ func (f *File) SameContents(other *File) bool {
 // from the rewritten version.
 // Panics if the file has already been parsed, as this would indicate a bug.
 func (f *File) IgnoreOptions(names ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	if f.parsed {
 		panic(errors.New("File.IgnoreOptions called on a file that has already been parsed"))
 	}
 	for _, name := range names {
-		f.ignoredOptionNames[name] = true
+		f.ignoredOptionNames[canonicalOptionName(name)] = true
+	}
+}
+
+// AllowSections restricts which named sections may appear in the file: a
+// subsequent call to Parse returns an UnknownSectionError for any section
+// header not matching one of the supplied names, including in any included
+// files. This catches misspelled section headers, which would otherwise be
+// silently ignored. Names may be glob patterns, using the syntax of
+// path.Match, for example "prod-*". The default nameless section "" is always
+// permitted. Each call adds to the names permitted by previous calls; if
+// AllowSections is never called, all sections are permitted.
+// Panics if the file has already been parsed, as this would indicate a bug.
+func (f *File) AllowSections(names ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.parsed {
+		panic(errors.New("File.AllowSections called on a file that has already been parsed"))
 	}
+	f.allowedSections = append(f.allowedSections, names...)
 }
 
+// sectionAllowed returns true if the named section is permitted by any names
+// supplied to AllowSections. The caller must hold a lock on f.mu.
+func (f *File) sectionAllowed(name string) bool {
+	if name == "" || len(f.allowedSections) == 0 {
+		return true
+	}
+	for _, pattern := range f.allowedSections {
+		if matched, _ := path.Match(pattern, name); matched || pattern == name {
+			return true
+		}
+	}
+	return false
+}
+
+// getOrCreateSection returns the section with the supplied name, creating it
+// if it does not already exist. The caller must hold a write lock on f.mu.
 func (f *File) getOrCreateSection(name string) *Section {
 	if s, exists := f.sectionIndex[name]; exists {
 		return s
@@ -387,12 +2667,190 @@ func (f *File) getOrCreateSection(name string) *Section {
 
 type lineType int
 
+// errTrailingBackslash is returned by parseLine for a line ending in a single
+// backslash. Outside of lineScanner, this can only occur on the last line of a
+// file.
+var errTrailingBackslash = errors.New("Value ends in a single backslash")
+
+// lineScanner reads the lines of an ini-style option file, joining each line
+// ending in a single backslash with the following line, minus its leading
+// whitespace. This permits long values to span multiple lines.
+//
+// Unlike bufio.Scanner, which allocates a new string for each line, lineScanner
+// converts its input to strings in chunks of many complete lines at a time,
+// and returns lines as substrings of those chunks. Its read buffer is obtained
+// from a pool, and returned to the pool once the input is exhausted.
+type lineScanner struct {
+	r          io.Reader
+	maxLength  int      // maximum length of a physical line, or negative for no limit
+	buf        []byte   // bytes read from r which are not yet part of chunk
+	chunk      string   // unconsumed complete lines; may end in a partial line once r is exhausted
+	eof        bool     // true once r has been exhausted
+	err        error    // first non-EOF error, returned by Err
+	text       string   // current logical line, after joining any continuation lines
+	lineNumber int      // line number of the first physical line of text
+	physical   []string // physical lines comprising text
+	breaks     []int    // offsets in text at which each continuation line begins
+	lastLine   int      // line number of the last physical line consumed
+}
+
+// lineScannerBufSize is the initial size of a lineScanner's read buffer. The
+// buffer only grows beyond this if a single line is longer.
+const lineScannerBufSize = 32 * 1024
+
+var lineScannerBufPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, lineScannerBufSize)
+		return &buf
+	},
+}
+
+// newLineScanner returns a lineScanner for r. Physical lines longer than
+// maxLength bytes cause Err to return bufio.ErrTooLong; a negative maxLength
+// means no limit.
+func newLineScanner(r io.Reader, maxLength int) *lineScanner {
+	return &lineScanner{r: r, maxLength: maxLength}
+}
+
+// newScanner returns a bufio.Scanner for the lines of r, permitting lines of up
+// to maxLength bytes, or any length if maxLength is negative.
+func newScanner(r io.Reader, maxLength int) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	if maxLength < 0 {
+		maxLength = int(^uint(0)>>1) - 2
+	}
+	scanner.Buffer(nil, maxLength+2) // room for a trailing "\r\n"
+	return scanner
+}
+
+// Scan advances to the next logical line, returning false at the end of the
+// input or upon an error.
+func (ls *lineScanner) Scan() bool {
+	line, ok := ls.next()
+	if !ok {
+		return false
+	}
+	ls.lastLine++
+	ls.lineNumber = ls.lastLine
+	ls.text = line
+	ls.physical = append(ls.physical[:0], ls.text) // reused between lines to avoid allocations
+	ls.breaks = nil
+	for continuesLine(ls.text) {
+		next, ok := ls.next()
+		if !ok {
+			break
+		}
+		ls.lastLine++
+		ls.physical = append(ls.physical, next)
+		ls.text = ls.text[:len(ls.text)-1]
+		ls.breaks = append(ls.breaks, len(ls.text))
+		ls.text += strings.TrimLeftFunc(next, unicode.IsSpace)
+	}
+	return true
+}
+
+// Err returns the first non-EOF error encountered by Scan.
+func (ls *lineScanner) Err() error {
+	return ls.err
+}
+
+// next returns the next physical line, minus its line ending. The returned
+// bool is false at the end of the input or upon an error.
+func (ls *lineScanner) next() (string, bool) {
+	for {
+		if n := strings.IndexByte(ls.chunk, '\n'); n > -1 {
+			line := ls.chunk[:n]
+			ls.chunk = ls.chunk[n+1:]
+			return strings.TrimSuffix(line, "\r"), true
+		} else if ls.eof {
+			line := ls.chunk
+			ls.chunk = ""
+			return strings.TrimSuffix(line, "\r"), line != ""
+		} else if !ls.fill() {
+			return "", false
+		}
+	}
+}
+
+// fill reads from ls.r until at least one more complete line is available, or
+// the input is exhausted, and then moves all complete lines from ls.buf into
+// ls.chunk. This requires one allocation per chunk, rather than one per line.
+// It returns false if an error occurred.
+func (ls *lineScanner) fill() bool {
+	if ls.err != nil {
+		return false
+	} else if ls.buf == nil {
+		ls.buf = *lineScannerBufPool.Get().(*[]byte)
+	}
+	for empty := 0; ; {
+		if len(ls.buf) == cap(ls.buf) {
+			if ls.maxLength >= 0 && len(ls.buf) >= ls.maxLength+2 { // room for a trailing "\r\n"
+				return ls.fail(bufio.ErrTooLong)
+			}
+			size := 2 * cap(ls.buf)
+			if ls.maxLength >= 0 && size > ls.maxLength+2 {
+				size = ls.maxLength + 2
+			}
+			ls.buf = append(make([]byte, 0, size), ls.buf...)
+		}
+		n, err := ls.r.Read(ls.buf[len(ls.buf):cap(ls.buf)])
+		ls.buf = ls.buf[:len(ls.buf)+n]
+		if err == io.EOF {
+			ls.eof = true
+			ls.chunk = string(ls.buf)
+			ls.release()
+			return true
+		} else if err != nil {
+			return ls.fail(err)
+		} else if n == 0 {
+			if empty++; empty >= 100 {
+				return ls.fail(io.ErrNoProgress)
+			}
+			continue
+		}
+		if end := bytes.LastIndexByte(ls.buf[len(ls.buf)-n:], '\n'); end > -1 {
+			end += len(ls.buf) - n + 1
+			ls.chunk = string(ls.buf[:end])
+			ls.buf = ls.buf[:copy(ls.buf, ls.buf[end:])]
+			return true
+		}
+	}
+}
+
+// fail records err for return by Err, and releases the read buffer.
+func (ls *lineScanner) fail(err error) bool {
+	ls.err = err
+	ls.release()
+	return false
+}
+
+// release returns the read buffer to the pool, unless it grew to accommodate
+// an unusually long line.
+func (ls *lineScanner) release() {
+	if cap(ls.buf) == lineScannerBufSize {
+		buf := ls.buf[:0]
+		lineScannerBufPool.Put(&buf)
+	}
+	ls.buf = nil
+}
+
+// continuesLine returns true if line is an option line ending in a single
+// backslash, indicating that it continues on the next line.
+func continuesLine(line string) bool {
+	if !strings.HasSuffix(line, "\\") {
+		return false
+	}
+	_, err := parseLine(line)
+	return err == errTrailingBackslash
+}
+
 const (
 	lineTypeBlank lineType = iota
 	lineTypeComment
 	lineTypeSectionHeader
 	lineTypeKeyOnly
 	lineTypeKeyValue
+	lineTypeDirective
 )
 
 type parsedLine struct {
@@ -402,12 +2860,14 @@ type parsedLine struct {
 	comment     string
 	kind        lineType
 	isLoose     bool
+	repeated    bool // true for a "[[name]]" section header
 }
 
-// parseLine parses a file line into its components
-func parseLine(line string) (*parsedLine, error) {
-	line = strings.TrimLeftFunc(line, unicode.IsSpace)
-	result := new(parsedLine)
+// parseLine parses a file line into its components. The result is returned by
+// value, rather than by pointer, to avoid a heap allocation for every line.
+func parseLine(line string) (parsedLine, error) {
+	line = normalizeFullWidth(strings.TrimLeftFunc(line, unicode.IsSpace))
+	var result parsedLine
 
 	if line == "" {
 		result.kind = lineTypeBlank
@@ -419,25 +2879,44 @@ func parseLine(line string) (*parsedLine, error) {
 		return result, nil
 	}
 
+	// Directives such as "!inherit foo". The directive name is stored in key, and
+	// its argument (the remainder of the line, trimmed) in value. Consistent with
+	// MySQL's treatment of directives, inline comments are not supported.
+	if line[0] == '!' {
+		fields := strings.Fields(line[1:])
+		if len(fields) == 0 {
+			return parsedLine{}, errors.New("missing directive name")
+		}
+		result.kind = lineTypeDirective
+		result.key = strings.ToLower(fields[0])
+		result.value = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line[1:]), fields[0]))
+		return result, nil
+	}
+
 	if line[0] == '[' {
-		endIndex := strings.Index(line, "]")
+		// A "[[name]]" header begins one of a list of repeated sections
+		open, close := "[", "]"
+		if strings.HasPrefix(line, "[[") {
+			open, close, result.repeated = "[[", "]]", true
+		}
+		endIndex := strings.Index(line, close)
 		hashIndex := strings.Index(line, "#")
 		if endIndex == -1 || (hashIndex > -1 && hashIndex < endIndex) {
-			return nil, errors.New("unterminated section name")
+			return parsedLine{}, errors.New("unterminated section name")
 		}
-		if endIndex < len(line)-1 {
+		if endIndex < len(line)-len(close) {
 			var after string
 			if hashIndex > -1 {
-				after = line[endIndex+1 : hashIndex]
+				after = line[endIndex+len(close) : hashIndex]
 			} else {
-				after = line[endIndex+1:]
+				after = line[endIndex+len(close):]
 			}
 			if len(strings.TrimSpace(after)) > 0 {
-				return nil, errors.New("extra characters after section name")
+				return parsedLine{}, errors.New("extra characters after section name")
 			}
 		}
 		result.kind = lineTypeSectionHeader
-		result.sectionName = line[1:endIndex]
+		result.sectionName = line[len(open):endIndex]
 		if hashIndex > -1 {
 			result.comment = line[hashIndex+1:]
 		}
@@ -446,11 +2925,14 @@ func parseLine(line string) (*parsedLine, error) {
 
 	// If we get here, it's one of the key/value types
 	var inValue, escapeNext bool
-	var inQuote rune
+	var inQuote byte
 
 	// Parse out any inline comment, being careful to still allow escaped hashes or
-	// hashes inside of quoted values
-	for n, c := range line {
+	// hashes inside of quoted values. Only ASCII characters are significant here,
+	// and bytes of multi-byte UTF-8 sequences never match ASCII, so it is safe
+	// (and much faster) to iterate over bytes rather than runes.
+	for n := 0; n < len(line); n++ {
+		c := line[n]
 		if escapeNext {
 			escapeNext = false
 			continue
@@ -465,7 +2947,7 @@ func parseLine(line string) (*parsedLine, error) {
 			case '=':
 				inValue = true
 			case '\'', '"', '`', '\\':
-				return nil, fmt.Errorf("Illegal character %c in option name", c)
+				return parsedLine{}, fmt.Errorf("Illegal character %c in option name", c)
 			}
 			continue
 		}
@@ -481,11 +2963,13 @@ func parseLine(line string) (*parsedLine, error) {
 		}
 	}
 
-	if inQuote != 0 {
-		return nil, errors.New("Quoted value has no terminating quote")
-	}
+	// A trailing backslash is checked first, since it may indicate a quoted
+	// value continues on the next line; see lineScanner
 	if escapeNext {
-		return nil, errors.New("Value ends in a single backslash")
+		return parsedLine{}, errTrailingBackslash
+	}
+	if inQuote != 0 {
+		return parsedLine{}, errors.New("Quoted value has no terminating quote")
 	}
 
 	var hasValue bool
@@ -497,17 +2981,3 @@ func parseLine(line string) (*parsedLine, error) {
 	}
 	return result, nil
 }
-
-// FileParseFormatError is an error returned when File.Parse encounters a
-// problem with the formatting of a file (separate from an unknown option or a
-// lack of a required value for an option, which are handled by other types)
-type FileParseFormatError struct {
-	Problem    string
-	FilePath   string
-	LineNumber int
-}
-
-// Error satisfies golang's error interface.
-func (fpf FileParseFormatError) Error() string {
-	return fmt.Sprintf("Parse error in %s line %d: %s", fpf.FilePath, fpf.LineNumber, fpf.Problem)
-}
@@ -0,0 +1,11 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package mybase
+
+import "os"
+
+// chownLike has no effect on platforms without Unix-style file ownership.
+func chownLike(f *os.File, fi os.FileInfo) error {
+	return nil
+}
@@ -1,9 +1,21 @@
 package mybase
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
 func getParsedFile(cfg *Config, ignoreUnknownOptions bool, contents string, ignoredOpts ...string) (*File, error) {
@@ -76,6 +88,217 @@ func TestFileReadWrite(t *testing.T) {
 	}
 }
 
+func TestFileWritePreserveFormatting(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mybasetest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	contents := "# Top comment\r\nvisible=1\r\n\r\n[foo]\r\n  hasshort = old # keep me\r\nbool1\r\n; another comment\r\nhasshort=dupe\r\n\r\n[bar]\r\nvisible=bar\r\n"
+	path := filepath.Join(dir, "test.cnf")
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("Unable to write file: %v", err)
+	}
+	cfg := ParseFakeCLI(t, simpleCommand(), "mycommand arg1")
+	f := NewFile(path)
+	f.PreserveFormatting = true
+	if err := f.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error from Parse: %v", err)
+	}
+
+	// Writing without modifications should not change anything
+	if err := f.Write(true); err != nil {
+		t.Fatalf("Unexpected error from Write: %v", err)
+	}
+	if actual, _ := ioutil.ReadFile(path); string(actual) != contents {
+		t.Errorf("Unexpected file contents after Write without modifications: %q", actual)
+	}
+
+	f.SetOptionValue("foo", "hasshort", "new")
+	f.SetOptionValue("foo", "visible", "added")
+	f.UnsetOptionValue("bar", "visible")
+	f.SetOptionValue("baz", "hidden", "brandnew")
+	if err := f.Write(true); err != nil {
+		t.Fatalf("Unexpected error from Write: %v", err)
+	}
+	expected := "# Top comment\r\nvisible=1\r\n\r\n[foo]\r\n  hasshort=new # keep me\r\nbool1\r\n; another comment\r\nvisible=added\r\n\r\n[bar]\r\n\r\n[baz]\r\nhidden=brandnew\r\n"
+	if actual, _ := ioutil.ReadFile(path); string(actual) != expected {
+		t.Errorf("Unexpected file contents after Write:\n%q\nexpected:\n%q", actual, expected)
+	}
+
+	// Re-parsing should yield the same values as the in-memory File
+	reparsed := NewFile(path)
+	if err := reparsed.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error from Parse: %v", err)
+	}
+	if !reparsed.SameContents(f) {
+		t.Error("Expected re-parsed file to have same contents as written file, but it does not")
+	}
+}
+
+func TestFileDeleteAndPrune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mybasetest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	contents := "visible=1\nhidden=somedefault\n\n[foo]\n# foo comment\nhasshort=x\nbool2\n\n[bar]\nbool1=0\ntruthybool\nvisible=bar\n"
+	path := filepath.Join(dir, "test.cnf")
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("Unable to write file: %v", err)
+	}
+	cfg := ParseFakeCLI(t, simpleCommand(), "mycommand arg1")
+	f := NewFile(path)
+	f.PreserveFormatting = true
+	if err := f.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error from Parse: %v", err)
+	}
+
+	if f.DeleteOption("nonexistent", "visible") || f.DeleteOption("bar", "hidden") {
+		t.Error("Expected DeleteOption to return false for nonexistent section or value, but it did not")
+	}
+	if !f.DeleteOption("bar", "Visible") {
+		t.Error("Expected DeleteOption to return true, but it did not")
+	}
+	if f.DeleteSection("nonexistent") {
+		t.Error("Expected DeleteSection to return false for nonexistent section, but it did not")
+	}
+	if !f.DeleteSection("foo") {
+		t.Error("Expected DeleteSection to return true, but it did not")
+	}
+	if f.HasSection("foo") {
+		t.Error("Expected section foo to be gone after DeleteSection, but it is still present")
+	}
+	if removed := f.PruneDefaults(cfg); removed != 3 {
+		t.Errorf("Expected PruneDefaults to remove 3 values, instead removed %d", removed)
+	}
+	if err := f.Write(true); err != nil {
+		t.Fatalf("Unexpected error from Write: %v", err)
+	}
+	expected := "visible=1\n\n[bar]\n"
+	if actual, _ := ioutil.ReadFile(path); string(actual) != expected {
+		t.Errorf("Unexpected file contents after Write:\n%q\nexpected:\n%q", actual, expected)
+	}
+
+	// PruneDefaults can be limited to specific sections, and deleting the
+	// default section only removes its values
+	f = NewFile(dir, "regen.cnf")
+	f.SetOptionValue("", "hidden", "somedefault")
+	f.SetOptionValue("foo", "hidden", "somedefault")
+	f.SetOptionValue("foo", "bool2", "1")
+	if removed := f.PruneDefaults(cfg, "foo"); removed != 1 {
+		t.Errorf("Expected PruneDefaults to remove 1 value, instead removed %d", removed)
+	}
+	if !f.DeleteSection("") || !f.HasSection("") {
+		t.Error("Expected DeleteSection to return true and retain the default section")
+	}
+	if err := f.Write(false); err != nil {
+		t.Fatalf("Unexpected error from Write: %v", err)
+	}
+	expected = "[foo]\nbool2=1\n"
+	if actual, _ := ioutil.ReadFile(f.Path()); string(actual) != expected {
+		t.Errorf("Unexpected file contents after Write:\n%q\nexpected:\n%q", actual, expected)
+	}
+}
+
+func TestFileAtomicWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mybasetest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "atomic.cnf")
+	if err := ioutil.WriteFile(path, []byte("visible=old\n"), 0644); err != nil {
+		t.Fatalf("Unable to write file: %v", err)
+	}
+
+	f := NewFile(path)
+	f.AtomicWrite = true
+	f.BackupOnWrite = true
+	f.SetOptionValue("", "visible", "new")
+	if err := f.Write(false); !os.IsExist(err) {
+		t.Errorf("Expected Write(false) to fail with existing file, instead err=%v", err)
+	}
+	if err := f.Write(true); err != nil {
+		t.Fatalf("Unexpected error from Write: %v", err)
+	}
+	if actual, _ := ioutil.ReadFile(path); string(actual) != "visible=new\n" {
+		t.Errorf("Unexpected file contents after Write: %q", actual)
+	}
+
+	// Only the target and its backup should remain in the directory
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Unable to read dir: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected dir to contain 2 files, instead found %d", len(entries))
+	}
+	backupName := entries[1].Name()
+	if entries[0].Name() != "atomic.cnf" || !strings.HasPrefix(backupName, "atomic.cnf.") || !strings.HasSuffix(backupName, ".bak") {
+		t.Errorf("Unexpected dir contents: %s, %s", entries[0].Name(), backupName)
+	}
+	if actual, _ := ioutil.ReadFile(filepath.Join(dir, backupName)); string(actual) != "visible=old\n" {
+		t.Errorf("Unexpected backup file contents: %q", actual)
+	}
+
+	// New files may also be written atomically
+	f = NewFile(dir, "new.cnf")
+	f.AtomicWrite = true
+	f.SetOptionValue("", "visible", "x")
+	if err := f.Write(false); err != nil {
+		t.Fatalf("Unexpected error from Write: %v", err)
+	}
+	if actual, _ := ioutil.ReadFile(f.Path()); string(actual) != "visible=x\n" {
+		t.Errorf("Unexpected file contents after Write: %q", actual)
+	}
+}
+
+func TestFileLineEndings(t *testing.T) {
+	cfg := ParseFakeCLI(t, simpleCommand(), "mycommand arg1")
+	for name, contents := range map[string]string{
+		"crlf.cnf":  "visible=x\r\n\r\n[foo]\r\nhasshort=y\r\n",
+		"crlf.json": "{\r\n  \"visible\": \"x\",\r\n  \"foo\": {\r\n    \"hasshort\": \"y\"\r\n  }\r\n}\r\n",
+		"crlf.yaml": "visible: x\r\nfoo:\r\n  hasshort: y\r\n",
+		"crlf.toml": "visible = \"x\"\r\n\r\n[foo]\r\nhasshort = \"y\"\r\n",
+	} {
+		f := NewFile(os.TempDir(), name)
+		f.contents, f.read = contents, true
+		if err := f.Parse(cfg); err != nil {
+			t.Errorf("Unexpected error parsing %s: %v", name, err)
+			continue
+		}
+		f.UseSection("foo")
+		if visible, _ := f.OptionValue("visible"); visible != "x" {
+			t.Errorf("Unexpected value for visible in %s: %q", name, visible)
+		}
+		if hasshort, _ := f.OptionValue("hasshort"); hasshort != "y" {
+			t.Errorf("Unexpected value for hasshort in %s: %q", name, hasshort)
+		}
+
+		// Existing line endings are retained by default, but may be overridden
+		if actual, _ := f.renderContents(); actual != contents {
+			t.Errorf("Expected %s to render with original line endings, instead found %q", name, actual)
+		}
+		f.Newline = "\n"
+		if actual, _ := f.renderContents(); actual != strings.Replace(contents, "\r\n", "\n", -1) {
+			t.Errorf("Expected %s to render with Unix line endings, instead found %q", name, actual)
+		}
+	}
+
+	// New files use "\n" by default, or f.Newline if set
+	f := NewFile(os.TempDir(), "new.cnf")
+	f.SetOptionValue("", "visible", "x")
+	f.SetOptionValue("foo", "hasshort", "y")
+	if actual, _ := f.renderContents(); actual != "visible=x\n\n[foo]\nhasshort=y\n" {
+		t.Errorf("Unexpected rendered contents: %q", actual)
+	}
+	f.Newline = "\r\n"
+	if actual, _ := f.renderContents(); actual != "visible=x\r\n\r\n[foo]\r\nhasshort=y\r\n" {
+		t.Errorf("Unexpected rendered contents: %q", actual)
+	}
+}
+
 func TestParse(t *testing.T) {
 	assertFileParsed := func(f *File, err error, expectedSections ...string) {
 		t.Helper()
@@ -154,6 +377,1034 @@ func TestParse(t *testing.T) {
 	assertFileValue(f, "one", "mystring", "hello")
 }
 
+func TestParseReader(t *testing.T) {
+	cmd := NewCommand("test", "1.0", "this is for testing", nil)
+	cmd.AddOption(StringOption("mystring", 0, "", ""))
+	cmd.AddOption(BoolOption("mybool", 0, false, ""))
+	cfg := NewConfig(&CommandLine{Command: cmd})
+
+	f := NewFile("/tmp/fake.cnf")
+	if err := f.ParseReader(cfg, strings.NewReader("mystring=hello\n[one]\nmybool\n")); err != nil {
+		t.Fatalf("Unexpected error from ParseReader: %v", err)
+	}
+	if f.contents != "mystring=hello\n[one]\nmybool\n" || !f.read {
+		t.Errorf("Expected contents to be retained after parsing, but found read=%t contents=%q", f.read, f.contents)
+	}
+	if !f.SectionHasOption("", "mystring") || !f.SectionHasOption("one", "mybool") {
+		t.Error("ParseReader did not set values as expected")
+	}
+
+	f = NewFile("/tmp/fake.cnf")
+	contents := "mystring=hello\n\n[one]\nmybool\nnope=123\n"
+	err := f.ParseReader(cfg, strings.NewReader(contents))
+	if err == nil || !strings.Contains(err.Error(), "line 5") {
+		t.Errorf("Expected error referencing line 5, instead found %v", err)
+	}
+	f.IgnoreUnknownOptions = true
+	if err := f.ParseReader(cfg, strings.NewReader(contents)); err != nil {
+		t.Errorf("Unexpected error from ParseReader: %v", err)
+	} else if f.contents != contents || !f.read {
+		t.Errorf("Expected contents to be retained, but found read=%t contents=%q", f.read, f.contents)
+	}
+
+	f = NewFile("/tmp/fake.cnf")
+	f.DiscardContents = true
+	if err := f.ParseReader(cfg, strings.NewReader("mystring=hello\n[one]\nmybool\n")); err != nil {
+		t.Fatalf("Unexpected error from ParseReader: %v", err)
+	}
+	if f.contents != "" || f.read {
+		t.Errorf("Expected contents to be discarded with DiscardContents, but found read=%t contents=%q", f.read, f.contents)
+	}
+	if !f.SectionHasOption("", "mystring") || !f.SectionHasOption("one", "mybool") {
+		t.Error("ParseReader did not set values as expected with DiscardContents")
+	}
+}
+
+func TestParseAll(t *testing.T) {
+	cmd := NewCommand("test", "1.0", "this is for testing", nil)
+	cmd.AddOption(StringOption("mystring", 0, "", ""))
+	cmd.AddOption(BoolOption("mybool", 0, false, ""))
+	cfg := NewConfig(&CommandLine{Command: cmd})
+
+	contents := "mystring=hello\nunknown1=foo\n[one]\nmystring\nmybool\n[bad\nmystring=world\n"
+	f := NewFile("/tmp/fake.cnf")
+	f.contents, f.read = contents, true
+	err := f.Parse(cfg)
+	var perr ParseError
+	if !errors.As(err, &perr) || perr.OptionName() != "unknown1" || perr.Line() != 2 || perr.Location() != f.Path() {
+		t.Errorf("Unexpected error from Parse: %v", err)
+	}
+
+	f = NewFile("/tmp/fake.cnf")
+	f.contents, f.read = contents, true
+	err = f.ParseAll(cfg)
+	problems, ok := err.(ParseErrors)
+	if !ok {
+		t.Fatalf("Expected ParseAll to return ParseErrors, instead found %T %v", err, err)
+	}
+	expectLines := []int{2, 4, 6}
+	expectOptions := []string{"unknown1", "mystring", ""}
+	if len(problems) != len(expectLines) {
+		t.Fatalf("Expected %d errors, instead found %d: %v", len(expectLines), len(problems), problems)
+	}
+	for n, problem := range problems {
+		if !errors.As(problem, &perr) {
+			t.Errorf("Expected error %v to implement ParseError, but it does not", problem)
+		} else if perr.Line() != expectLines[n] || perr.OptionName() != expectOptions[n] {
+			t.Errorf("Unexpected error[%d]: line=%d option=%q", n, perr.Line(), perr.OptionName())
+		}
+	}
+	if strings.Count(err.Error(), "\n") != 2 {
+		t.Errorf("Expected ParseErrors message to contain each error on a separate line, instead found %q", err.Error())
+	}
+
+	// Valid lines should still be processed
+	if value, _ := f.OptionValue("mystring"); value != "hello" {
+		t.Errorf("Expected mystring to be %q, instead found %q", "hello", value)
+	}
+	if err := f.UseSection("one"); err != nil {
+		t.Errorf("Unexpected error from UseSection: %v", err)
+	} else if value, _ := f.OptionValue("mystring"); value != "world" {
+		t.Errorf("Expected mystring in section one to be %q, instead found %q", "world", value)
+	}
+
+	// ParseAllReader behaves the same way on a stream
+	f = NewFile("/tmp/fake.cnf")
+	err = f.ParseAllReader(cfg, strings.NewReader(contents))
+	if problems, ok := err.(ParseErrors); !ok || len(problems) != len(expectLines) {
+		t.Errorf("Unexpected error from ParseAllReader: %v", err)
+	} else if value, _ := f.OptionValue("mystring"); value != "hello" {
+		t.Errorf("Expected mystring to be %q, instead found %q", "hello", value)
+	}
+
+	// No problems: ParseAll returns nil
+	f = NewFile("/tmp/fake.cnf")
+	f.contents, f.read = "mystring=hello\n", true
+	if err := f.ParseAll(cfg); err != nil {
+		t.Errorf("Unexpected error from ParseAll: %v", err)
+	}
+	err = f.UseSection("one", "two")
+	if snf, ok := err.(SectionNotFoundError); !ok || len(snf.Sections) != 2 || snf.FilePath != f.Path() {
+		t.Errorf("Unexpected error from UseSection: %v", err)
+	} else if !errors.As(err, &perr) {
+		t.Error("Expected SectionNotFoundError to implement ParseError, but it does not")
+	}
+}
+
+func TestParseLenient(t *testing.T) {
+	cmd := NewCommand("test", "1.0", "this is for testing", nil)
+	cmd.AddOption(StringOption("mystring", 0, "", ""))
+	cmd.AddOption(BoolOption("mybool", 0, false, ""))
+	cfg := NewConfig(&CommandLine{Command: cmd})
+	contents := "mystring=hello\nunknown1=foo\n[one]\nmystring\nmybool\n[bad\nmystring=world\n"
+	expectLines := []int{2, 4, 6}
+	assertProblems := func(f *File) {
+		t.Helper()
+		problems := f.Problems()
+		if len(problems) != len(expectLines) {
+			t.Fatalf("Expected %d problems, instead found %d: %v", len(expectLines), len(problems), problems)
+		}
+		var perr ParseError
+		for n, problem := range problems {
+			if !errors.As(problem, &perr) || perr.Line() != expectLines[n] {
+				t.Errorf("Unexpected problem[%d]: %v", n, problem)
+			}
+		}
+		if value, _ := f.OptionValue("mystring"); value != "hello" {
+			t.Errorf("Expected mystring to be %q, instead found %q", "hello", value)
+		}
+	}
+
+	// With Lenient, Parse succeeds but records every problem
+	f := NewFile("/tmp/fake.cnf")
+	f.Lenient = true
+	if err := f.ParseReader(cfg, strings.NewReader(contents)); err != nil {
+		t.Fatalf("Unexpected error from ParseReader: %v", err)
+	}
+	assertProblems(f)
+
+	// A later parse without problems clears them
+	if err := f.ParseReader(cfg, strings.NewReader("mystring=hello\n")); err != nil || len(f.Problems()) > 0 {
+		t.Errorf("Unexpected result from ParseReader: err=%v problems=%v", err, f.Problems())
+	}
+
+	// ParseAll still returns problems, and records them as well
+	f = NewFile("/tmp/fake.cnf")
+	f.Lenient = true
+	if err := f.ParseAllReader(cfg, strings.NewReader(contents)); err == nil {
+		t.Error("Expected ParseAllReader to return an error, but it did not")
+	}
+	assertProblems(f)
+
+	// Config.LenientFiles applies to all files, including lazily-parsed ones
+	cfg.LenientFiles = true
+	f = NewFile("/tmp/fake.cnf")
+	f.LazySections = true
+	if err := f.ParseReader(cfg, strings.NewReader(contents)); err != nil {
+		t.Fatalf("Unexpected error from ParseReader: %v", err)
+	}
+	assertProblems(f)
+
+	// Safety limits still stop parsing and return an error
+	f = NewFile("/tmp/fake.cnf")
+	f.MaxLineLength = 10
+	if err := f.ParseReader(cfg, strings.NewReader(contents)); err == nil {
+		t.Error("Expected error from exceeding MaxLineLength, but err is nil")
+	}
+}
+
+func TestSectionInheritance(t *testing.T) {
+	cmd := NewCommand("test", "1.0", "this is for testing", nil)
+	cmd.AddOption(StringOption("user", 0, "", ""))
+	cmd.AddOption(StringOption("port", 0, "", ""))
+	cmd.AddOption(StringOption("host", 0, "", ""))
+	cmd.AddOption(BoolOption("ssl", 0, false, ""))
+	cfg := NewConfig(&CommandLine{Command: cmd})
+
+	contents := "port=3306\n\n[prod-us]\n!inherit prod\nhost=us.example.com\n\n[prod]\n!inherit common\nport=3307\n\n[common]\nuser=app\nssl\nport=3308\n"
+	f, err := getParsedFile(cfg, false, contents)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing file: %v", err)
+	}
+	f.UseSection("prod-us")
+	expected := map[string]string{"user": "app", "ssl": "1", "port": "3307", "host": "us.example.com"}
+	for name, value := range expected {
+		if actual, ok := f.OptionValue(name); !ok || actual != value {
+			t.Errorf("Expected OptionValue(%q) to be %q, instead found %q", name, value, actual)
+		}
+	}
+	if actual := f.SectionValues("prod-us"); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Unexpected result from SectionValues: %v", actual)
+	}
+	if f.SectionValues("doesnt-exist") != nil {
+		t.Error("Expected SectionValues on nonexistent section to return nil")
+	}
+	if f.SectionHasOption("prod-us", "user") {
+		t.Error("Expected SectionHasOption to only consider the section's own values")
+	}
+
+	// Changes to an inherited section should be visible immediately
+	f.SetOptionValue("common", "user", "other")
+	if actual, _ := f.OptionValue("user"); actual != "other" {
+		t.Errorf("Expected modified inherited value, instead found %q", actual)
+	}
+
+	// Write should persist the directive rather than flattened values
+	f.Dir = os.TempDir()
+	f.Name = "mybasetest-inherit.cnf"
+	if err := f.Write(true); err != nil {
+		t.Fatalf("Unexpected error from Write: %v", err)
+	}
+	defer os.Remove(f.Path())
+	written, err := ioutil.ReadFile(f.Path())
+	if err != nil {
+		t.Fatalf("Unexpected error re-reading file: %v", err)
+	}
+	if !strings.Contains(string(written), "[prod-us]\n!inherit prod\nhost=us.example.com\n") {
+		t.Errorf("Unexpected file contents after Write: %s", written)
+	}
+	reread := NewFile(f.Path())
+	if err := reread.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error re-parsing file: %v", err)
+	} else if !reread.SameContents(f) {
+		t.Error("Expected re-parsed file to have same contents as original")
+	}
+
+	assertParseError := func(contents string, expectLine int, expectSubstring string) {
+		t.Helper()
+		_, err := getParsedFile(cfg, false, contents)
+		if fpf, ok := err.(FileParseFormatError); !ok {
+			t.Errorf("Expected FileParseFormatError, instead found %T %v", err, err)
+		} else if fpf.LineNumber != expectLine || !strings.Contains(fpf.Problem, expectSubstring) {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+	assertParseError("[a]\n!inherit b\n[b]\n!inherit c\n[c]\n!inherit a\n", 6, "[a] inherits [b] inherits [c] inherits [a]")
+	assertParseError("[a]\n!inherit a\n", 2, "cycle")
+	assertParseError("[a]\nport=1\n!inherit nope\n", 3, "nonexistent section [nope]")
+	assertParseError("[a]\n!inherit\n", 2, "missing section name")
+	assertParseError("[a]\n!frobnicate\n", 2, "unknown directive")
+
+	// !extends is a synonym for !inherit, including for cycle detection
+	f, err = getParsedFile(cfg, false, "[base]\nuser=app\nport=3306\n[staging]\n!extends base\nport=3307\n")
+	if err != nil {
+		t.Fatalf("Unexpected error parsing file: %v", err)
+	}
+	expected = map[string]string{"user": "app", "port": "3307"}
+	if actual := f.SectionValues("staging"); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Unexpected result from SectionValues with !extends: %v", actual)
+	}
+	assertParseError("[a]\n!extends b\n[b]\n!inherit a\n", 4, "[a] inherits [b] inherits [a]")
+	assertParseError("[a]\n!extends\n", 2, "!extends directive missing section name")
+}
+
+func TestUseSectionPattern(t *testing.T) {
+	cmd := NewCommand("test", "1.0", "this is for testing", nil)
+	cmd.AddOption(StringOption("host", 0, "", ""))
+	cmd.AddOption(StringOption("port", 0, "", ""))
+	cfg := NewConfig(&CommandLine{Command: cmd})
+
+	contents := "port=3306\n\n[prod-b]\nhost=b\n\n[staging]\nhost=s\nport=3307\n\n[prod-a]\nhost=a\nport=3308\n\n[prod-*]\nhost=literal\n"
+	f, err := getParsedFile(cfg, false, contents)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing file: %v", err)
+	}
+	if actual := f.SectionsLike("prod-[a-z]"); !reflect.DeepEqual(actual, []string{"prod-b", "prod-a"}) {
+		t.Errorf("Unexpected result from SectionsLike: %v", actual)
+	}
+	if actual := f.SectionsLike("*"); len(actual) != 4 {
+		t.Errorf("Expected SectionsLike(\"*\") to return all named sections, instead found %v", actual)
+	}
+	if actual := f.SectionsLike("[bad"); len(actual) != 0 {
+		t.Errorf("Expected SectionsLike with malformed pattern to return no sections, instead found %v", actual)
+	}
+
+	// Matching sections are selected in file order; an exact section name takes
+	// priority over pattern matching
+	if err := f.UseSection("prod-[a-z]"); err != nil {
+		t.Errorf("Unexpected error from UseSection: %v", err)
+	}
+	if host, _ := f.OptionValue("host"); host != "b" {
+		t.Errorf("Expected host to be %q, instead found %q", "b", host)
+	}
+	if port, _ := f.OptionValue("port"); port != "3308" {
+		t.Errorf("Expected port to be %q, instead found %q", "3308", port)
+	}
+	if err := f.UseSection("prod-*"); err != nil {
+		t.Errorf("Unexpected error from UseSection: %v", err)
+	}
+	if host, _ := f.OptionValue("host"); host != "literal" {
+		t.Errorf("Expected host to be %q, instead found %q", "literal", host)
+	}
+	err = f.UseSection("staging", "prod-[a-z]", "dev-*")
+	if snf, ok := err.(SectionNotFoundError); !ok || !reflect.DeepEqual(snf.Sections, []string{"dev-*"}) {
+		t.Errorf("Unexpected error from UseSection: %v", err)
+	}
+	if host, _ := f.OptionValue("host"); host != "s" {
+		t.Errorf("Expected host to be %q, instead found %q", "s", host)
+	}
+}
+
+func TestFileLazySections(t *testing.T) {
+	cmd := NewCommand("test", "1.0", "this is for testing", nil)
+	cmd.AddOption(StringOption("host", 0, "", ""))
+	cmd.AddOption(StringOption("port", 0, "", ""))
+	cfg := NewConfig(&CommandLine{Command: cmd})
+
+	contents := "port=3306\n\n[prod]\n!inherit common\nhost=prod.example.com\n\n[broken]\nnope=1\n\n[common]\nport=3307\n\n[long]\nhost=long\\\n  .example.com\n"
+	f := NewFile("/tmp/fake.cnf")
+	f.contents, f.read = contents, true
+	f.LazySections = true
+	if err := f.Parse(cfg); err != nil {
+		t.Fatalf("Expected problems in unselected sections to be deferred, instead found error %v", err)
+	}
+	if !f.HasSection("broken") || len(f.pending) != 4 {
+		t.Errorf("Expected all section names to be known, and all named sections to be pending; found pending=%v", f.pending)
+	}
+	if port, _ := f.OptionValue("port"); port != "3306" {
+		t.Errorf("Expected default section to be parsed immediately, instead found port=%q", port)
+	}
+
+	// Selecting a section parses it along with the sections it inherits from
+	if err := f.UseSection("prod"); err != nil {
+		t.Fatalf("Unexpected error from UseSection: %v", err)
+	}
+	if len(f.pending) != 2 {
+		t.Errorf("Expected 2 sections to remain pending, instead found %v", f.pending)
+	}
+	if host, _ := f.OptionValue("host"); host != "prod.example.com" {
+		t.Errorf("Expected host to be %q, instead found %q", "prod.example.com", host)
+	}
+	if port, _ := f.OptionValue("port"); port != "3307" {
+		t.Errorf("Expected port to be %q, instead found %q", "3307", port)
+	}
+	if err := f.UseSection("long"); err != nil {
+		t.Fatalf("Unexpected error from UseSection: %v", err)
+	} else if host, _ := f.OptionValue("host"); host != "long.example.com" {
+		t.Errorf("Expected continued value to be parsed, instead found host=%q", host)
+	}
+
+	// Problems are reported upon selecting the section
+	err := f.UseSection("broken", "doesnt-exist")
+	if uoe, ok := err.(OptionNotDefinedError); !ok || uoe.LineNumber != 8 {
+		t.Errorf("Expected OptionNotDefinedError on line 8, instead found %T %v", err, err)
+	}
+
+	// Methods examining all sections parse everything first
+	f = NewFile("/tmp/fake.cnf")
+	f.contents, f.read = contents, true
+	f.LazySections = true
+	f.Parse(cfg)
+	if sections := f.Sections(); len(sections) != 5 || sections[4].Values["host"] != "long.example.com" || len(f.pending) != 0 {
+		t.Errorf("Expected Sections to parse all pending sections, instead found %v", sections)
+	}
+
+	// ParseAll ignores LazySections, since its purpose is finding every problem
+	f = NewFile("/tmp/fake.cnf")
+	f.contents, f.read = contents, true
+	f.LazySections = true
+	if err := f.ParseAll(cfg); err == nil {
+		t.Error("Expected ParseAll to return an error, but it did not")
+	}
+}
+
+func TestOptionSections(t *testing.T) {
+	cmd := simpleCommand()
+	cmd.AddOption(StringOption("host", 0, "", "dummy").OnlyInSections("host-*", "localhost"))
+	cmd.AddOption(StringOption("schema", 0, "", "dummy").OnlyInSections(""))
+	cfg := ParseFakeCLI(t, cmd, "mycommand --host=cli-is-fine arg1")
+
+	f, err := getParsedFile(cfg, false, "schema=foo\n[host-a]\nhost=a.example.com\n[localhost]\nhost=127.0.0.1\n")
+	if err != nil {
+		t.Fatalf("Unexpected error from getParsedFile: %v", err)
+	}
+	assertFileValue := func(section, name, expected string) {
+		t.Helper()
+		if actual := f.SectionValues(section)[name]; actual != expected {
+			t.Errorf("Expected [%s] %s=%q, instead found %q", section, name, expected, actual)
+		}
+	}
+	assertFileValue("host-a", "host", "a.example.com")
+	assertFileValue("localhost", "host", "127.0.0.1")
+
+	_, err = getParsedFile(cfg, false, "[host-a]\nhost=a.example.com\nschema=foo\n")
+	if ose, ok := err.(OptionSectionError); !ok || ose.Name != "schema" || ose.Section != "host-a" || ose.Line() != 3 {
+		t.Errorf("Unexpected error from getParsedFile: %v", err)
+	} else if expected := "Option schema may not be set in [host-a]; only permitted in the default section"; !strings.HasSuffix(ose.Error(), expected) {
+		t.Errorf("Unexpected error message: %s", ose.Error())
+	}
+	if _, err = getParsedFile(cfg, false, "host=foo\n"); err == nil {
+		t.Error("Expected error setting host in default section, but err is nil")
+	}
+
+	// Lint reports the same problem
+	f = NewFile("/tmp/fake.cnf")
+	f.contents, f.read = "[other]\nhost=foo\n", true
+	problems, err := f.Lint(cfg)
+	if err != nil || len(problems) != 1 || problems[0].Severity != LintError || problems[0].Option != "host" {
+		t.Errorf("Unexpected result from Lint: %+v, %v", problems, err)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Expected OnlyInSections to panic with malformed pattern, but it did not")
+			}
+		}()
+		StringOption("foo", 0, "", "dummy").OnlyInSections("[")
+	}()
+}
+
+func TestFileSections(t *testing.T) {
+	cmd := NewCommand("test", "1.0", "this is for testing", nil)
+	cmd.AddOption(StringOption("host", 0, "", ""))
+	cmd.AddOption(StringOption("port", 0, "", ""))
+	cfg := NewConfig(&CommandLine{Command: cmd})
+
+	f, err := getParsedFile(cfg, false, "port=3306\n\n[two]\nport=3307\nhost=b\n\n[one]\n!inherit two\n")
+	if err != nil {
+		t.Fatalf("Unexpected error parsing file: %v", err)
+	}
+	sections := f.Sections()
+	var names []string
+	for _, section := range sections {
+		names = append(names, section.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"", "two", "one"}) {
+		t.Fatalf("Unexpected section names from Sections: %v", names)
+	}
+	if !f.HasSection("one") || f.HasSection("three") {
+		t.Error("Unexpected result from HasSection")
+	}
+	two := sections[1]
+	if two.Len() != 2 || !reflect.DeepEqual(two.OptionNames(), []string{"host", "port"}) {
+		t.Errorf("Unexpected contents of section two: len=%d names=%v", two.Len(), two.OptionNames())
+	}
+	if one := sections[2]; one.Len() != 0 || len(one.OptionNames()) != 0 || !reflect.DeepEqual(one.Inherits, []string{"two"}) {
+		t.Errorf("Unexpected contents of section one: len=%d names=%v inherits=%v", one.Len(), one.OptionNames(), one.Inherits)
+	}
+
+	// Returned sections are copies
+	two.Values["port"] = "9999"
+	if f.SectionValues("two")["port"] != "3307" {
+		t.Error("Modifying a Section returned by Sections unexpectedly affected the File")
+	}
+}
+
+func TestFileSectionsNamed(t *testing.T) {
+	cmd := NewCommand("test", "1.0", "this is for testing", nil)
+	cmd.AddOption(StringOption("host", 0, "", ""))
+	cmd.AddOption(StringOption("port", 0, "", ""))
+	cfg := NewConfig(&CommandLine{Command: cmd})
+	hosts := func(sections []*Section) (result []string) {
+		for _, section := range sections {
+			result = append(result, section.Values["host"])
+		}
+		return result
+	}
+
+	// "[[name]]" headers always begin a separate section
+	contents := "port=3306\n[[server]]\nhost=a\n[server]\nhost=b\nport=1\n[[server]]\nhost=c\n"
+	f, err := getParsedFile(cfg, false, contents)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing file: %v", err)
+	}
+	servers := f.SectionsNamed("server")
+	if actual := hosts(servers); !reflect.DeepEqual(actual, []string{"b", "a", "c"}) {
+		t.Errorf("Unexpected result from SectionsNamed: %v", actual)
+	}
+	if _, ok := servers[2].Values["port"]; ok {
+		t.Errorf("Expected repeated section to only contain its own values, instead found %v", servers[2].Values)
+	}
+	if f.SectionsNamed("client") != nil {
+		t.Error("Expected SectionsNamed to return nil for nonexistent section")
+	}
+	f.UseSection("server")
+	if value, _ := f.OptionValue("host"); value != "b" {
+		t.Errorf("Expected repeated sections to be ignored by OptionValue, instead found host=%q", value)
+	}
+
+	// Concurrent calls are safe, even if they load a lazily-parsed section
+	f = NewFile("/tmp/fake.cnf")
+	f.LazySections = true
+	f.contents, f.read = contents, true
+	if err := f.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error parsing file: %v", err)
+	}
+	var wg sync.WaitGroup
+	for n := 0; n < 4; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if actual := hosts(f.SectionsNamed("server")); !reflect.DeepEqual(actual, []string{"b", "a", "c"}) {
+				t.Errorf("Unexpected result from concurrent SectionsNamed: %v", actual)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Repeated "[name]" headers are merged, unless RepeatedSections is enabled
+	contents = "[server]\nhost=a\n[server]\nhost=b\nport=1\n"
+	f, err = getParsedFile(cfg, false, contents)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing file: %v", err)
+	}
+	if actual := hosts(f.SectionsNamed("server")); !reflect.DeepEqual(actual, []string{"b"}) {
+		t.Errorf("Unexpected result from SectionsNamed: %v", actual)
+	}
+	f.RepeatedSections = true
+	f.contents, f.read = contents, true
+	if err := f.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error parsing file: %v", err)
+	}
+	if actual := hosts(f.SectionsNamed("server")); !reflect.DeepEqual(actual, []string{"a", "b"}) {
+		t.Errorf("Unexpected result from SectionsNamed with RepeatedSections: %v", actual)
+	}
+
+	// Lint and Format treat each repeated section separately
+	if problems, err := f.Lint(cfg); err != nil || len(problems) > 0 {
+		t.Errorf("Unexpected result from Lint: %v %v", problems, err)
+	}
+	var buf bytes.Buffer
+	if err := f.Format(&buf, FormatStyle{}); err != nil {
+		t.Fatalf("Unexpected error from Format: %v", err)
+	} else if !strings.Contains(buf.String(), "[server]\nhost=a\n\n[server]\nhost=b\nport=1\n") {
+		t.Errorf("Unexpected output from Format:\n%s", buf.String())
+	}
+
+	// Repeated sections survive writing, with or without PreserveFormatting
+	dir, err := ioutil.TempDir("", "mybasetest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	for _, preserve := range []bool{false, true} {
+		path := filepath.Join(dir, "test.cnf")
+		contents := "[server]\nhost=a\n[[server]]\nhost=b\n[[server]]\nhost=c\n"
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatalf("Unable to write file: %v", err)
+		}
+		f := NewFile(path)
+		f.PreserveFormatting = preserve
+		if err := f.Parse(cfg); err != nil {
+			t.Fatalf("Unexpected error from Parse: %v", err)
+		}
+		f.SetOptionValue("server", "port", "3307")
+		if err := f.Write(true); err != nil {
+			t.Fatalf("Unexpected error from Write: %v", err)
+		}
+		reparsed := NewFile(path)
+		if err := reparsed.Parse(cfg); err != nil {
+			t.Fatalf("Unexpected error from Parse: %v", err)
+		}
+		servers := reparsed.SectionsNamed("server")
+		if actual := hosts(servers); !reflect.DeepEqual(actual, []string{"a", "b", "c"}) || servers[0].Values["port"] != "3307" || len(servers[1].Values) != 1 {
+			t.Errorf("Unexpected sections after Write with PreserveFormatting=%t: %v", preserve, servers)
+		}
+	}
+}
+
+func TestFileMaxSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mybasetest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	contents := "[mysection]\nvisible=" + strings.Repeat("x", 1000) + "\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "big.cnf"), []byte(contents), 0644); err != nil {
+		t.Fatalf("Unable to write file: %v", err)
+	}
+	cfg := ParseFakeCLI(t, simpleCommand(), "mycommand arg1")
+
+	f := NewFile(dir, "big.cnf")
+	f.MaxSize = 100
+	err = f.Read()
+	if tooLarge, ok := err.(FileTooLargeError); !ok || tooLarge.Size != int64(len(contents)) || tooLarge.MaxSize != 100 {
+		t.Errorf("Expected FileTooLargeError with actual size, instead found %v", err)
+	} else if !strings.Contains(err.Error(), strconv.Itoa(len(contents))) {
+		t.Errorf("Expected error message to include actual size, instead found %q", err.Error())
+	}
+	if err := f.Parse(cfg); err == nil {
+		t.Error("Expected streaming Parse to fail on oversized file, but err is nil")
+	}
+
+	// Default limit and unlimited should both permit this file
+	for _, maxSize := range []int64{0, -1} {
+		f = NewFile(dir, "big.cnf")
+		f.MaxSize = maxSize
+		if err := f.Parse(cfg); err != nil {
+			t.Errorf("Unexpected error from Parse with MaxSize %d: %v", maxSize, err)
+		}
+	}
+
+	// Directories are not regular files
+	f = NewFile(dir)
+	if err := f.Read(); err == nil {
+		t.Error("Expected Read of directory to fail, but err is nil")
+	} else if _, ok := err.(NonRegularFileError); !ok {
+		t.Errorf("Expected NonRegularFileError, instead found %T %v", err, err)
+	}
+}
+
+func TestFileLimits(t *testing.T) {
+	cfg := ParseFakeCLI(t, simpleCommand(), "mycommand arg1")
+	parse := func(f *File, contents string) error {
+		t.Helper()
+		return f.ParseReader(cfg, strings.NewReader(contents))
+	}
+	assertLimitErr := func(err error, limit string, lineNumber int) {
+		t.Helper()
+		if fle, ok := err.(FileLimitError); !ok || fle.Limit != limit || fle.LineNumber != lineNumber {
+			t.Errorf("Expected FileLimitError for %s on line %d, instead found %T %v", limit, lineNumber, err, err)
+		}
+	}
+
+	// Lines longer than bufio.Scanner's default buffer are permitted by default,
+	// but not beyond MaxLineLength
+	long := "visible=" + strings.Repeat("x", 100000) + "\n"
+	if err := parse(NewFile("/tmp/fake.cnf"), "[one]\n"+long); err != nil {
+		t.Errorf("Unexpected error from Parse with default MaxLineLength: %v", err)
+	}
+	f := NewFile("/tmp/fake.cnf")
+	f.MaxLineLength = 1000
+	assertLimitErr(parse(f, "[one]\n"+long), "line length", 2)
+	f.MaxLineLength = 50000 // beyond the scanner's initial buffer
+	assertLimitErr(parse(f, "[one]\n\n"+long), "line length", 3)
+	f.Syntax = FileFormatYAML
+	assertLimitErr(parse(f, "one:\n  "+strings.Replace(long, "=", ": ", 1)), "line length", 2)
+	f = NewFile("/tmp/fake.cnf")
+	f.MaxLineLength = -1
+	if err := parse(f, long); err != nil {
+		t.Errorf("Unexpected error from Parse with no MaxLineLength: %v", err)
+	}
+
+	// MaxSections includes the default section; repeated headers don't count
+	f = NewFile("/tmp/fake.cnf")
+	f.MaxSections = 2
+	if err := parse(f, "[one]\nvisible=1\n[one]\n"); err != nil {
+		t.Errorf("Unexpected error from Parse: %v", err)
+	}
+	assertLimitErr(parse(f, "[one]\n[two]\n"), "sections", 2)
+
+	// MaxOptions stops ParseAll immediately, and also applies to lazy sections
+	f = NewFile("/tmp/fake.cnf")
+	f.MaxOptions = 2
+	err := f.ParseAllReader(cfg, strings.NewReader("visible=1\nbogus=1\n# comment\n[one]\nhidden=2\n"))
+	assertLimitErr(err, "option lines", 5)
+	if err != nil && !strings.Contains(err.Error(), "maximum of 2 option lines") {
+		t.Errorf("Unexpected error message: %s", err)
+	}
+	f.LazySections = true
+	assertLimitErr(parse(f, "[one]\nvisible=1\n\nhidden=2\nhidden=3\n"), "option lines", 5)
+}
+
+func TestFileAllowSections(t *testing.T) {
+	cfg := ParseFakeCLI(t, simpleCommand(), "mycommand arg1")
+	newFile := func() *File {
+		f := NewFile("/tmp/fake.cnf")
+		f.AllowSections("production", "staging")
+		f.AllowSections("dev-*")
+		return f
+	}
+
+	f := newFile()
+	if err := f.ParseReader(cfg, strings.NewReader("visible=1\n[production]\nhidden=2\n[dev-alice]\n[staging]\n")); err != nil {
+		t.Errorf("Unexpected error from Parse: %v", err)
+	}
+
+	f = newFile()
+	err := f.ParseReader(cfg, strings.NewReader("visible=1\n[prodution]\nhidden=2\n"))
+	if use, ok := err.(UnknownSectionError); !ok || use.Section != "prodution" || use.LineNumber != 2 || use.Suggestion != "production" {
+		t.Errorf("Expected UnknownSectionError with suggestion, instead found %T %v", err, err)
+	} else if expected := "/tmp/fake.cnf line 2: Unknown section [prodution]; did you mean [production]?"; err.Error() != expected {
+		t.Errorf("Unexpected error message: %s", err)
+	}
+
+	// ParseAll continues past the unknown section without attributing its
+	// options to the previous section; structured formats are checked too
+	f = newFile()
+	err = f.ParseAllReader(cfg, strings.NewReader("[staging]\nvisible=1\n[qa]\nvisible=2\n[zzz]\n"))
+	if errs, ok := err.(ParseErrors); !ok || len(errs) != 2 {
+		t.Errorf("Expected 2 errors from ParseAll, instead found %v", err)
+	} else if use := errs[0].(UnknownSectionError); use.Suggestion != "" {
+		t.Errorf("Expected no suggestion for [qa], instead found %q", use.Suggestion)
+	}
+	if value := f.SectionValues("staging")["visible"]; value != "1" {
+		t.Errorf("Expected [staging] to be unaffected by later unknown section, instead found visible=%q", value)
+	}
+	f = newFile()
+	f.Syntax = FileFormatYAML
+	if err := f.ParseReader(cfg, strings.NewReader("staging:\n  visible: 1\ntest:\n  visible: 2\n")); err == nil {
+		t.Error("Expected error for unknown section in YAML file, but err was nil")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected panic from AllowSections after Parse, but it did not occur")
+		}
+	}()
+	f = newFile()
+	f.ParseReader(cfg, strings.NewReader("[staging]\n"))
+	f.AllowSections("test")
+}
+
+func TestFileSymlinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mybasetest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if dir, err = filepath.EvalSymlinks(dir); err != nil { // in case temp dir is itself a symlink
+		t.Fatalf("Unable to resolve temp dir: %v", err)
+	}
+	target := filepath.Join(dir, "real.cnf")
+	if err := ioutil.WriteFile(target, []byte("visible=hello\n"), 0644); err != nil {
+		t.Fatalf("Unable to write file: %v", err)
+	}
+	// link2.cnf -> link1.cnf -> real.cnf
+	if err := os.Symlink(target, filepath.Join(dir, "link1.cnf")); err != nil {
+		t.Skipf("Unable to create symlink: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "link1.cnf"), filepath.Join(dir, "link2.cnf")); err != nil {
+		t.Fatalf("Unable to create symlink: %v", err)
+	}
+
+	f := NewFile(dir, "link2.cnf")
+	if f.Path() != filepath.Join(dir, "link2.cnf") {
+		t.Errorf("Unexpected result from Path() without ResolveSymlinks: %s", f.Path())
+	}
+	if resolved, err := f.SymlinkTarget(); err != nil || resolved != target {
+		t.Errorf("Unexpected result from SymlinkTarget(): %q / %v", resolved, err)
+	}
+	f.ResolveSymlinks = true
+	if f.Path() != target || !f.Exists() {
+		t.Errorf("Unexpected result from Path() or Exists() with ResolveSymlinks: %s / %t", f.Path(), f.Exists())
+	}
+	cfg := ParseFakeCLI(t, simpleCommand(), "mycommand arg1")
+	if err := f.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error from Parse: %v", err)
+	}
+	if value, _ := f.OptionValue("visible"); value != "hello" {
+		t.Errorf("Unexpected value from symlinked file: %q", value)
+	}
+
+	// Dangling symlinks: Path falls back to the unresolved path
+	if err := os.Remove(target); err != nil {
+		t.Fatalf("Unable to remove file: %v", err)
+	}
+	if f.Path() != filepath.Join(dir, "link2.cnf") || f.Exists() {
+		t.Errorf("Unexpected result from Path() or Exists() with dangling symlink: %s / %t", f.Path(), f.Exists())
+	}
+	if _, err := f.SymlinkTarget(); err == nil {
+		t.Error("Expected SymlinkTarget() to fail on dangling symlink, but err is nil")
+	}
+}
+
+func TestParseBinaryContent(t *testing.T) {
+	cfg := ParseFakeCLI(t, simpleCommand(), "mycommand arg1")
+	parseString := func(contents string, policy InvalidUTF8Policy, collectAll bool) (*File, error) {
+		f := NewFile("test.cnf")
+		f.InvalidUTF8 = policy
+		f.contents, f.read = contents, true
+		if collectAll {
+			return f, f.ParseAll(cfg)
+		}
+		return f, f.Parse(cfg)
+	}
+
+	// NUL bytes are always fatal, even with ParseAll
+	for _, collectAll := range []bool{false, true} {
+		_, err := parseString("visible=1\n[foo]\nhasshort=a\x00b\nbool1\x00\n", InvalidUTF8Reject, collectAll)
+		if bce, ok := err.(BinaryContentError); !ok || bce.Line() != 3 {
+			t.Errorf("Expected BinaryContentError on line 3, instead found %T %v", err, err)
+		}
+	}
+
+	// Invalid UTF-8 is rejected by default, with ParseAll reporting each line
+	contents := "visible=caf\xe9\nhasshort=ok\n[\xff]\n"
+	if _, err := parseString(contents, InvalidUTF8Reject, false); err == nil {
+		t.Error("Expected error from invalid UTF-8, but err is nil")
+	} else if iue, ok := err.(InvalidUTF8Error); !ok || iue.Line() != 1 || iue.Offset != 11 || iue.Byte != 0xe9 {
+		t.Errorf("Expected InvalidUTF8Error on line 1 offset 11, instead found %T %v", err, err)
+	}
+	if _, err := parseString(contents, InvalidUTF8Reject, true); err == nil {
+		t.Error("Expected error from invalid UTF-8, but err is nil")
+	} else if pe, ok := err.(ParseErrors); !ok || len(pe) != 2 || pe[1].(ParseError).Line() != 3 {
+		t.Errorf("Expected 2 errors with the second on line 3, instead found %v", err)
+	}
+
+	// Replacement policy substitutes U+FFFD for invalid sequences
+	f, err := parseString(contents, InvalidUTF8Replace, false)
+	if err != nil {
+		t.Fatalf("Unexpected error with InvalidUTF8Replace: %v", err)
+	}
+	if value, _ := f.OptionValue("visible"); value != "caf\uFFFD" {
+		t.Errorf("Expected invalid byte to be replaced, instead found %q", value)
+	}
+	if !f.HasSection("\uFFFD") {
+		t.Error("Expected section name to have invalid byte replaced")
+	}
+}
+
+func TestParseEncoding(t *testing.T) {
+	cfg := ParseFakeCLI(t, simpleCommand(), "mycommand arg1")
+	parseString := func(contents string, encoding FileEncoding) (*File, error) {
+		f := NewFile("test.cnf")
+		f.Encoding = encoding
+		f.contents, f.read = contents, true
+		return f, f.Parse(cfg)
+	}
+
+	// Byte order marks, full-width brackets, and full-width equals signs are
+	// handled, as are full-width characters within values
+	contents := "\xef\xbb\xbfvisible\uff1dcaf\u00e9\n\uff3bfoo\uff3d\nhasshort = a\uff1db\n[bar\uff3d # comment\n"
+	f, err := parseString(contents, EncodingUTF8)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if value, _ := f.OptionValue("visible"); value != "caf\u00e9" {
+		t.Errorf("Unexpected value for visible: %q", value)
+	}
+	if !f.HasSection("foo") || !f.HasSection("bar") {
+		t.Errorf("Expected sections foo and bar, instead found %v", f.SectionsLike("*"))
+	}
+	f.UseSection("foo")
+	if value, _ := f.OptionValue("hasshort"); value != "a\uff1db" {
+		t.Errorf("Unexpected value for hasshort: %q", value)
+	}
+
+	// UTF-16 files are rejected with a descriptive error
+	if _, err := parseString("\xff\xfev\x00=\x001\x00", EncodingUTF8); err == nil || !strings.Contains(err.Error(), "UTF-16") {
+		t.Errorf("Expected error mentioning UTF-16, instead found %v", err)
+	}
+
+	// Latin-1 files are converted to UTF-8
+	f, err = parseString("visible=caf\xe9\n[\xe9t\xe9]\nhasshort=\xff\n", EncodingLatin1)
+	if err != nil {
+		t.Fatalf("Unexpected error with EncodingLatin1: %v", err)
+	}
+	if value, _ := f.OptionValue("visible"); value != "caf\u00e9" {
+		t.Errorf("Unexpected value for visible: %q", value)
+	}
+	f.UseSection("\u00e9t\u00e9")
+	if value, _ := f.OptionValue("hasshort"); value != "\u00ff" {
+		t.Errorf("Unexpected value for hasshort: %q", value)
+	}
+}
+
+func TestParseDuplicateOptions(t *testing.T) {
+	cmd := simpleCommand()
+	cmd.AddOption(MultiOption("multi", 0, "", "dummy description"))
+	var warnings []string
+	cfg := ParseFakeCLI(t, cmd, "mycommand arg1")
+	cfg.WarningHandler = func(message string) {
+		warnings = append(warnings, message)
+	}
+	contents := "visible=1\nmulti=a\n[foo]\nvisible=x\nmulti=b\nvisible=2\nmulti=c\n"
+	parseString := func(policy DuplicatePolicy) (*File, error) {
+		f := NewFile("test.cnf")
+		f.DuplicateOptions = policy
+		f.contents, f.read = contents, true
+		err := f.Parse(cfg)
+		f.UseSection("foo")
+		return f, err
+	}
+
+	cases := []struct {
+		policy         DuplicatePolicy
+		expectVisible  string
+		expectMulti    string
+		expectWarnings int
+	}{
+		{DuplicateAccumulate, "2", "b,c", 0},
+		{DuplicateLastWins, "2", "c", 0},
+		{DuplicateFirstWins, "x", "b", 0},
+		{DuplicateWarn, "2", "b,c", 1},
+	}
+	for _, c := range cases {
+		warnings = nil
+		f, err := parseString(c.policy)
+		if err != nil {
+			t.Errorf("Unexpected error from Parse with policy %d: %v", c.policy, err)
+			continue
+		}
+		visible, _ := f.OptionValue("visible")
+		multi, _ := f.OptionValue("multi")
+		if visible != c.expectVisible || multi != c.expectMulti || len(warnings) != c.expectWarnings {
+			t.Errorf("With policy %d: expected visible=%q multi=%q with %d warnings; instead found visible=%q multi=%q with warnings %v", c.policy, c.expectVisible, c.expectMulti, c.expectWarnings, visible, multi, warnings)
+		}
+	}
+
+	_, err := parseString(DuplicateError)
+	if doe, ok := err.(DuplicateOptionError); !ok || doe.Line() != 6 || doe.OptionName() != "visible" || !strings.HasSuffix(doe.Previous, "test.cnf line 4") {
+		t.Errorf("Expected DuplicateOptionError on line 6, instead found %T %v", err, err)
+	}
+
+	// Warnings are reported after releasing the file's lock, so the handler may
+	// access the file, including when the warning comes from a lazily-parsed
+	// section
+	for _, lazy := range []bool{false, true} {
+		f := NewFile("test.cnf")
+		f.DuplicateOptions = DuplicateWarn
+		f.LazySections = lazy
+		f.contents, f.read = contents, true
+		warnings = nil
+		cfg.WarningHandler = func(message string) {
+			value, _ := f.OptionValue("visible")
+			warnings = append(warnings, value)
+		}
+		if err := f.Parse(cfg); err != nil {
+			t.Fatalf("Unexpected error from Parse: %v", err)
+		}
+		if err := f.UseSection("foo"); err != nil {
+			t.Fatalf("Unexpected error from UseSection: %v", err)
+		}
+		if len(warnings) != 1 {
+			t.Errorf("With LazySections=%t, expected 1 warning, instead found %v", lazy, warnings)
+		}
+	}
+}
+
+func TestParseIncludes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mybasetest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	writeFile := func(name, contents string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Unable to create dir: %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatalf("Unable to write file: %v", err)
+		}
+	}
+	writeFile("main.cnf", "visible=main\n[foo]\n!include sub/extra.cnf\nhidden=main\n!includedir conf.d\n!include nonexistent.cnf\n!includedir nonexistent.d\n")
+	writeFile("sub/extra.cnf", "[foo]\nvisible=extra\nhidden=extra\n")
+	writeFile("conf.d/b.cnf", "[foo]\nhasshort=b\n")
+	writeFile("conf.d/a.cnf", "[foo]\nhasshort=a\nbool1\n")
+	writeFile("conf.d/c.txt", "[foo]\nhasshort=c\n")
+	cfg := ParseFakeCLI(t, simpleCommand(), "mycommand arg1")
+
+	f := NewFile(dir, "main.cnf")
+	if err := f.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error from Parse: %v", err)
+	}
+	expected := map[string]string{"visible": "extra", "hidden": "main", "hasshort": "b", "bool1": "1"}
+	if actual := f.SectionValues("foo"); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected section values %v, instead found %v", expected, actual)
+	}
+	if value, _ := f.OptionValue("visible"); value != "main" {
+		t.Errorf("Expected included file to not affect including file's current section, instead found visible=%q", value)
+	}
+
+	// Write should retain directives, omitting values from included files unless
+	// modified, and ordering modified values after directives
+	assertWritten := func(f *File, expected string) {
+		t.Helper()
+		if err := f.Write(true); err != nil {
+			t.Fatalf("Unexpected error from Write: %v", err)
+		}
+		if actual, err := ioutil.ReadFile(f.Path()); err != nil {
+			t.Fatalf("Unable to read file: %v", err)
+		} else if string(actual) != expected {
+			t.Errorf("Unexpected contents after Write:\n%s\nexpected:\n%s", actual, expected)
+		}
+	}
+	f.SetOptionValue("", "visible", "changed")
+	assertWritten(f, "visible=changed\n\n[foo]\n!include sub/extra.cnf\nhidden=main\n!includedir conf.d\n!include nonexistent.cnf\n!includedir nonexistent.d\n")
+	writeFile("top.cnf", "visible=a\n!include sub/extra2.cnf\n")
+	writeFile("sub/extra2.cnf", "hasshort=3307\n[foo]\nbool1\n")
+	f = NewFile(dir, "top.cnf")
+	if err := f.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error from Parse: %v", err)
+	}
+	f.SetOptionValue("", "visible", "b")
+	assertWritten(f, "!include sub/extra2.cnf\nvisible=b\n")
+	f.SetOptionValue("", "hasshort", "3308")
+	assertWritten(f, "!include sub/extra2.cnf\nhasshort=3308\nvisible=b\n")
+
+	// Errors in included files should reference the included file
+	writeFile("conf.d/d.cnf", "[foo]\nbool2=1\nnonexistent=1\n")
+	f = NewFile(dir, "main.cnf")
+	if err := f.Parse(cfg); err == nil {
+		t.Error("Expected error from unknown option in included file, but err is nil")
+	} else if pe, ok := err.(ParseError); !ok || pe.Location() != filepath.Join(dir, "conf.d", "d.cnf") || pe.Line() != 3 {
+		t.Errorf("Expected error to reference line 3 of included file, instead found %v", err)
+	}
+	os.Remove(filepath.Join(dir, "conf.d", "d.cnf"))
+
+	// Cycles and excessive depth are errors, as is a missing path
+	writeFile("cycle1.cnf", "!include cycle2.cnf\n")
+	writeFile("cycle2.cnf", "[foo]\n!include cycle1.cnf\n")
+	f = NewFile(dir, "cycle1.cnf")
+	if err := f.Parse(cfg); err == nil || !strings.Contains(err.Error(), "include cycle") {
+		t.Errorf("Expected include cycle error, instead found %v", err)
+	}
+	f = NewFile(dir, "main.cnf")
+	f.MaxIncludeDepth = -1
+	if err := f.Parse(cfg); err == nil || !strings.Contains(err.Error(), "maximum include depth") {
+		t.Errorf("Expected include depth error, instead found %v", err)
+	}
+	writeFile("nested.cnf", "!include main.cnf\n")
+	f = NewFile(dir, "nested.cnf")
+	f.MaxIncludeDepth = 1
+	if err := f.Parse(cfg); err == nil || !strings.Contains(err.Error(), "maximum include depth") {
+		t.Errorf("Expected include depth error, instead found %v", err)
+	}
+	f.MaxIncludeDepth = 2
+	if err := f.Parse(cfg); err != nil {
+		t.Errorf("Unexpected error from Parse: %v", err)
+	}
+	f = NewFile(dir, "fake.cnf")
+	f.contents, f.read = "!include\n", true
+	if err := f.Parse(cfg); err == nil {
+		t.Error("Expected error from !include without path, but err is nil")
+	}
+}
+
 func TestFileSameContents(t *testing.T) {
 	cmd := NewCommand("test", "1.0", "this is for testing", nil)
 	cmd.AddOption(StringOption("mystring", 0, "", ""))
@@ -187,6 +1438,231 @@ func TestFileSameContents(t *testing.T) {
 	if !f1.SameContents(f3) {
 		t.Error("Expected f1 and f3 to now have the same contents, but they did not")
 	}
+	if !f1.SameContents(f1) {
+		t.Error("Expected f1 to have the same contents as itself, but it did not")
+	}
+
+	// Concurrent comparisons in opposite orders, interleaved with writes to both
+	// files, must not deadlock
+	done := make(chan struct{})
+	go func() {
+		var wg sync.WaitGroup
+		for _, pair := range [][2]*File{{f1, f3}, {f3, f1}} {
+			wg.Add(2)
+			go func(a, b *File) {
+				defer wg.Done()
+				for n := 0; n < 1000; n++ {
+					a.SameContents(b)
+				}
+			}(pair[0], pair[1])
+			go func(f *File) {
+				defer wg.Done()
+				for n := 0; n < 1000; n++ {
+					f.SetOptionValue("", "mystring", "whatever")
+				}
+			}(pair[0])
+		}
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for concurrent SameContents calls; deadlock?")
+	}
+}
+
+func TestParseEscapes(t *testing.T) {
+	contents := "visible=a\\#b\\tc # comment\nhasshort=C:\\Users\\me\nhidden=\"  quoted # \\\"value\\\"\\n\"\nregex=^_\\b\n"
+	parseWithEscapes := func(unquotedEscapes bool) *Config {
+		t.Helper()
+		cmd := simpleCommand()
+		cmd.AddOption(StringOption("regex", 0, "", "dummy description"))
+		cfg := ParseFakeCLI(t, cmd, "mycommand arg1")
+		f := NewFile("/tmp/fake.cnf")
+		f.UnquotedEscapes = unquotedEscapes
+		f.contents, f.read = contents, true
+		if err := f.Parse(cfg); err != nil {
+			t.Fatalf("Unexpected error from Parse: %v", err)
+		}
+		cfg.AddSource(f)
+		return cfg
+	}
+
+	// By default, escape sequences are only interpreted in quoted values
+	cfg := parseWithEscapes(false)
+	for name, expected := range map[string]string{
+		"visible":  `a\#b\tc`,
+		"hasshort": `C:\Users\me`,
+		"hidden":   "  quoted # \"value\"\n",
+		"regex":    `^_\b`,
+	} {
+		if actual := cfg.Get(name); actual != expected {
+			t.Errorf("Expected Get(%s) to return %q, instead found %q", name, expected, actual)
+		}
+	}
+	cfg = parseWithEscapes(true)
+	for name, expected := range map[string]string{
+		"visible":  "a#b\tc",
+		"hasshort": `C:\Users\me`,
+		"hidden":   "  quoted # \"value\"\n",
+		"regex":    "^_\b",
+	} {
+		if actual := cfg.Get(name); actual != expected {
+			t.Errorf("With UnquotedEscapes, expected Get(%s) to return %q, instead found %q", name, expected, actual)
+		}
+	}
+
+	// Arbitrary values should round-trip through QuoteValue, Write, and Parse, in
+	// any format
+	values := []string{
+		"plain",
+		" leading and trailing space ",
+		"hash # and 'quotes' \"of\" `all` kinds",
+		"back\\slash\\n",
+		"multi\nline\r\nvalue\twith\ttabs",
+		"",
+	}
+	for _, name := range []string{"escapes.cnf", "escapes.json", "escapes.yaml", "escapes.toml"} {
+		f := NewFile(os.TempDir(), name)
+		for n, value := range values {
+			f.SetOptionValue(fmt.Sprintf("section%d", n), "visible", QuoteValue(value))
+		}
+		contents, _ := f.renderContents()
+		reparsed := NewFile(os.TempDir(), name)
+		reparsed.contents, reparsed.read = contents, true
+		if err := reparsed.Parse(cfg); err != nil {
+			t.Errorf("Unexpected error re-parsing %s: %v\n%s", name, err, contents)
+			continue
+		}
+		for n, value := range values {
+			reparsed.UseSection(fmt.Sprintf("section%d", n))
+			if actual, _ := reparsed.OptionValue("visible"); unquote(actual) != value {
+				t.Errorf("Value %q did not round-trip in %s: found %q", value, name, unquote(actual))
+			}
+		}
+	}
+
+	// Values supplied to SetOptionValue without quoting should also round-trip,
+	// with or without UnquotedEscapes
+	for _, unquotedEscapes := range []bool{false, true} {
+		f := NewFile(os.TempDir(), "escapes.cnf")
+		f.UnquotedEscapes = unquotedEscapes
+		for n, value := range values {
+			if value == strings.TrimSpace(value) {
+				f.SetOptionValue(fmt.Sprintf("section%d", n), "visible", value)
+			}
+		}
+		contents, _ := f.renderContents()
+		reparsed := NewFile(os.TempDir(), "escapes.cnf")
+		reparsed.UnquotedEscapes = unquotedEscapes
+		reparsed.contents, reparsed.read = contents, true
+		if err := reparsed.Parse(cfg); err != nil {
+			t.Errorf("Unexpected error re-parsing: %v\n%s", err, contents)
+			continue
+		}
+		for n, value := range values {
+			reparsed.UseSection(fmt.Sprintf("section%d", n))
+			if actual, ok := reparsed.OptionValue("visible"); ok && unquote(actual) != value {
+				t.Errorf("Unquoted value %q did not round-trip with UnquotedEscapes=%t: found %q", value, unquotedEscapes, unquote(actual))
+			}
+		}
+	}
+}
+
+func TestParseLineContinuation(t *testing.T) {
+	cfg := ParseFakeCLI(t, simpleCommand(), "mycommand arg1")
+	contents := "visible=SELECT 1, \\\n    2, \\\n    3 # comment\nhasshort=\"quoted \\\n  value\"\n# comment ending in backslash \\\n[foo]\nhidden=single\n"
+	f, err := getParsedFile(cfg, false, contents)
+	if err != nil {
+		t.Fatalf("Unexpected error from getParsedFile: %v", err)
+	}
+	f.UseSection("foo")
+	cfg.AddSource(f)
+	for name, expected := range map[string]string{
+		"visible":  "SELECT 1, 2, 3",
+		"hasshort": "quoted value",
+		"hidden":   "single",
+	} {
+		if actual := cfg.Get(name); actual != expected {
+			t.Errorf("Expected Get(%s) to return %q, instead found %q", name, expected, actual)
+		}
+	}
+	if loc, _ := f.valueLocation("hidden"); loc.lineNumber != 8 {
+		t.Errorf("Expected hidden to be located on line 8, instead found line %d", loc.lineNumber)
+	}
+
+	// Write should retain the multi-line form of unmodified values
+	expected := "hasshort=\"quoted \\\n    value\"\nvisible=SELECT 1, \\\n    2, \\\n    3\n\n[foo]\nhidden=single\n"
+	if actual, _ := f.renderContents(); actual != expected {
+		t.Errorf("Unexpected rendered contents:\n%q\nexpected:\n%q", actual, expected)
+	}
+	f.SetOptionValue("", "visible", "SELECT 4")
+	if actual, _ := f.renderContents(); !strings.Contains(actual, "\nvisible=SELECT 4\n") {
+		t.Errorf("Expected modified value to be written on one line, instead found contents:\n%s", actual)
+	}
+
+	// With PreserveFormatting, continuation lines are replaced along with the
+	// line they continue
+	f = NewFile("/tmp/fake.cnf")
+	f.PreserveFormatting = true
+	f.contents, f.read = contents, true
+	if err := f.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error from Parse: %v", err)
+	}
+	if actual, _ := f.renderContents(); actual != contents {
+		t.Errorf("Expected unmodified contents to be written as-is, instead found:\n%q", actual)
+	}
+	f.SetOptionValue("", "visible", "SELECT 4")
+	expected = strings.Replace(contents, "visible=SELECT 1, \\\n    2, \\\n    3 # comment", "visible=SELECT 4 # comment", 1)
+	if actual, _ := f.renderContents(); actual != expected {
+		t.Errorf("Unexpected rendered contents:\n%q\nexpected:\n%q", actual, expected)
+	}
+
+	// A backslash on the last line is still an error
+	if _, err := getParsedFile(cfg, false, "visible=foo \\"); err == nil {
+		t.Error("Expected error from trailing backslash on last line, but err is nil")
+	}
+}
+
+// TestLineScanner confirms that lineScanner returns the same lines regardless
+// of how its input is split across reads, including lines longer than its
+// initial buffer.
+func TestLineScanner(t *testing.T) {
+	long := strings.Repeat("x", 3*lineScannerBufSize)
+	contents := "a=1\r\nb=2 \\\n   3\n\n" + long + "\r\nlast"
+	expected := []string{"a=1", "b=2 3", "", long, "last"}
+	expectedLineNumbers := []int{1, 2, 4, 5, 6}
+	readers := map[string]io.Reader{
+		"whole":   strings.NewReader(contents),
+		"onebyte": iotest.OneByteReader(strings.NewReader(contents)),
+		"half":    iotest.HalfReader(strings.NewReader(contents)),
+	}
+	for name, r := range readers {
+		scanner := newLineScanner(r, -1)
+		var lines []string
+		var lineNumbers []int
+		for scanner.Scan() {
+			lines = append(lines, scanner.text)
+			lineNumbers = append(lineNumbers, scanner.lineNumber)
+		}
+		if err := scanner.Err(); err != nil {
+			t.Errorf("%s: unexpected error from Err: %v", name, err)
+		}
+		if !reflect.DeepEqual(lines, expected) || !reflect.DeepEqual(lineNumbers, expectedLineNumbers) {
+			t.Errorf("%s: unexpected lines %d %v", name, len(lines), lineNumbers)
+		}
+	}
+
+	scanner := newLineScanner(strings.NewReader(contents), 1000)
+	for scanner.Scan() {
+	}
+	if err := scanner.Err(); err != bufio.ErrTooLong {
+		t.Errorf("Expected bufio.ErrTooLong, instead found %v", err)
+	}
+	if scanner.lastLine != 4 {
+		t.Errorf("Expected scanner to stop after line 4, instead lastLine=%d", scanner.lastLine)
+	}
 }
 
 func TestParseLine(t *testing.T) {
@@ -204,8 +1680,8 @@ func TestParseLine(t *testing.T) {
 			kind:        kind,
 			isLoose:     isLoose,
 		}
-		if *result != expect {
-			t.Errorf("Result %v does not match expectation %v", *result, expect)
+		if result != expect {
+			t.Errorf("Result %v does not match expectation %v", result, expect)
 		}
 	}
 	assertLineHasErr := func(line string) {
@@ -229,6 +1705,10 @@ func TestParseLine(t *testing.T) {
 	assertLine("foo='first' part of value only is quoted", "", "foo", "'first' part of value only is quoted", "", lineTypeKeyValue, false)
 	assertLine("foo='first' and last parts of value are 'quoted'", "", "foo", "'first' and last parts of value are 'quoted'", "", lineTypeKeyValue, false)
 
+	assertLine("!inherit cool beans ", "", "inherit", "cool beans", "", lineTypeDirective, false)
+	assertLine("  !INCLUDE /etc/foo.cnf", "", "include", "/etc/foo.cnf", "", lineTypeDirective, false)
+
+	assertLineHasErr("!")
 	assertLineHasErr("[section")
 	assertLineHasErr("[section   # hmmm")
 	assertLineHasErr("[section] lol # lolol")
@@ -239,3 +1719,152 @@ func TestParseLine(t *testing.T) {
 	assertLineHasErr("foo=\"mismatched quotes`")
 	assertLineHasErr("foo=`unbalanced`quotes`")
 }
+
+// TestFileConcurrentAccess confirms that reads of option values may be safely
+// interleaved with calls that modify the file's sections. This test is most
+// useful when run with the -race flag.
+func TestFileConcurrentAccess(t *testing.T) {
+	cmd := NewCommand("test", "1.0", "this is for testing", nil)
+	cmd.AddOption(StringOption("mystring", 0, "", ""))
+	cmd.AddOption(BoolOption("mybool", 0, false, ""))
+	cfg := NewConfig(&CommandLine{Command: cmd})
+	f, err := getParsedFile(cfg, false, "mystring=hello\nmybool\n[one]\nmystring=world\n")
+	if err != nil {
+		t.Fatalf("Unexpected error from Parse: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for n := 0; n < 8; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				if _, ok := f.OptionValue("mystring"); !ok {
+					t.Error("Expected mystring to always be set, but it was not")
+					return
+				}
+				f.HasSection("two")
+				f.SectionsWithOption("mybool")
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			section := fmt.Sprintf("section%d", i)
+			f.SetOptionValue(section, "mystring", "value")
+			f.UseSection(section, "one")
+			f.UnsetOptionValue(section, "mybool")
+		}
+	}()
+	wg.Wait()
+}
+
+func BenchmarkFileOptionValue(b *testing.B) {
+	cmd := NewCommand("test", "1.0", "this is for testing", nil)
+	cmd.AddOption(StringOption("mystring", 0, "", ""))
+	cfg := NewConfig(&CommandLine{Command: cmd})
+	f, err := getParsedFile(cfg, false, "mystring=hello\n[one]\nmystring=world\n")
+	if err != nil {
+		b.Fatalf("Unexpected error from Parse: %v", err)
+	}
+	f.UseSection("one")
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			f.OptionValue("mystring")
+		}
+	})
+}
+
+// BenchmarkParseLargeFile compares memory usage of Read followed by Parse,
+// versus a streaming Parse without a prior Read.
+func BenchmarkParseLargeFile(b *testing.B) {
+	cmd := NewCommand("test", "1.0", "this is for testing", nil)
+	cmd.AddOption(StringOption("mystring", 0, "", ""))
+	cmd.AddOption(BoolOption("mybool", 0, false, ""))
+	cfg := NewConfig(&CommandLine{Command: cmd})
+
+	// Generate a synthetic 50MB option file, with one section per "shard"
+	dir, err := ioutil.TempDir("", "mybasetest")
+	if err != nil {
+		b.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "large.cnf")
+	osFile, err := os.Create(path)
+	if err != nil {
+		b.Fatalf("Unable to create %s: %v", path, err)
+	}
+	value := strings.Repeat("x", 1000)
+	var size int
+	for n := 0; size < 50*1024*1024; n++ {
+		written, err := fmt.Fprintf(osFile, "[shard%d]\nmystring=%s\nmybool\n\n", n, value)
+		if err != nil {
+			b.Fatalf("Unable to write %s: %v", path, err)
+		}
+		size += written
+	}
+	if err := osFile.Close(); err != nil {
+		b.Fatalf("Unable to close %s: %v", path, err)
+	}
+
+	b.Run("ReadThenParse", func(b *testing.B) {
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			f := NewFile(path)
+			f.MaxSize = -1
+			if err := f.Read(); err != nil {
+				b.Fatalf("Unexpected error from Read: %v", err)
+			}
+			if err := f.Parse(cfg); err != nil {
+				b.Fatalf("Unexpected error from Parse: %v", err)
+			}
+		}
+	})
+	b.Run("Streaming", func(b *testing.B) {
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			f := NewFile(path)
+			f.DiscardContents = true
+			f.MaxSize = -1
+			if err := f.Parse(cfg); err != nil {
+				b.Fatalf("Unexpected error from Parse: %v", err)
+			}
+		}
+	})
+}
+
+// BenchmarkParseTypicalFile measures parsing of a small option file, typical
+// of a conf.d directory, by a command with a realistic number of options.
+func BenchmarkParseTypicalFile(b *testing.B) {
+	cmd := NewCommandSuite("test", "1.0", "this is for testing")
+	for n := 0; n < 60; n++ {
+		cmd.AddOption(StringOption(fmt.Sprintf("option-%d", n), 0, "", "").AddAlias(fmt.Sprintf("alias-%d", n)))
+	}
+	cmd.AddOption(BoolOption("mybool", 0, false, ""))
+	sub := NewCommand("sub", "", "", nil)
+	cmd.AddSubCommand(sub)
+	cfg := NewConfig(&CommandLine{Command: sub})
+
+	var b2 strings.Builder
+	b2.WriteString("# Typical option file\n\nmybool\noption-1=value1\n")
+	for section := 0; section < 4; section++ {
+		fmt.Fprintf(&b2, "\n[section%d]\n", section)
+		for n := 0; n < 12; n++ {
+			fmt.Fprintf(&b2, "Option_%d = 'some value %d' # comment\n", section*12+n, n)
+		}
+		b2.WriteString("skip-mybool\nalias-59=foo\n")
+	}
+	contents := b2.String()
+	b.SetBytes(int64(len(contents)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		f := NewFile("/tmp/typical.cnf")
+		if err := f.ParseReader(cfg, strings.NewReader(contents)); err != nil {
+			b.Fatalf("Unexpected error from ParseReader: %v", err)
+		}
+	}
+}
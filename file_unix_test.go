@@ -0,0 +1,200 @@
+//go:build !windows
+// +build !windows
+
+package mybase
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestFileNonRegular confirms that Read and Parse refuse to block on a FIFO,
+// unless AllowNonRegular is enabled.
+func TestFileNonRegular(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mybasetest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "fifo.cnf")
+	if err := syscall.Mkfifo(path, 0600); err != nil {
+		t.Skipf("Unable to create FIFO: %v", err)
+	}
+	cfg := ParseFakeCLI(t, simpleCommand(), "mycommand arg1")
+
+	f := NewFile(path)
+	if err := f.Read(); err == nil {
+		t.Error("Expected Read of FIFO to fail, but err is nil")
+	} else if nrf, ok := err.(NonRegularFileError); !ok || nrf.Mode&os.ModeNamedPipe == 0 {
+		t.Errorf("Expected NonRegularFileError for named pipe, instead found %v", err)
+	}
+	if err := f.Parse(cfg); err == nil {
+		t.Error("Expected Parse of FIFO to fail, but err is nil")
+	}
+
+	// With AllowNonRegular, the FIFO can be read once a writer supplies data
+	go func() {
+		w, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			return
+		}
+		w.Write([]byte("visible=fromfifo\n"))
+		w.Close()
+	}()
+	f = NewFile(path)
+	f.AllowNonRegular = true
+	done := make(chan error)
+	go func() {
+		done <- f.Parse(cfg)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Unexpected error from Parse: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for Parse of FIFO")
+	}
+	if value, _ := f.OptionValue("visible"); value != "fromfifo" {
+		t.Errorf("Unexpected value from FIFO: %q", value)
+	}
+}
+
+// TestFilePermissions confirms that Write uses restrictive permissions for
+// files containing sensitive options, and that Parse warns about such files
+// when other users can access them.
+func TestFilePermissions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mybasetest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	oldUmask := syscall.Umask(022)
+	defer syscall.Umask(oldUmask)
+
+	cmd := simpleCommand()
+	cmd.AddOption(StringOption("password", 0, "", "dummy description").Sensitive())
+	var warnings []string
+	cfg := ParseFakeCLI(t, cmd, "mycommand arg1")
+	cfg.WarningHandler = func(message string) {
+		warnings = append(warnings, message)
+	}
+	assertMode := func(path string, expected os.FileMode) {
+		t.Helper()
+		if fi, err := os.Stat(path); err != nil {
+			t.Errorf("Unexpected error from Stat: %v", err)
+		} else if fi.Mode().Perm() != expected {
+			t.Errorf("Expected %s to have permissions %s, instead found %s", path, expected, fi.Mode().Perm())
+		}
+	}
+
+	// New files are created with default permissions, unless they contain a
+	// sensitive option
+	plain := NewFile(dir, "plain.cnf")
+	plain.SetOptionValue("", "visible", "x")
+	if err := plain.Write(false); err != nil {
+		t.Fatalf("Unexpected error from Write: %v", err)
+	}
+	assertMode(plain.Path(), 0644)
+	secret := NewFile(dir, "secret.cnf")
+	if err := secret.ParseReader(cfg, strings.NewReader("visible=x\npassword=hunter2\n")); err != nil {
+		t.Fatalf("Unexpected error from ParseReader: %v", err)
+	}
+	if err := secret.Write(false); err != nil {
+		t.Fatalf("Unexpected error from Write: %v", err)
+	}
+	assertMode(secret.Path(), 0600)
+
+	// Parsing a file with a sensitive option only warns if other users can
+	// access it
+	if err := NewFile(secret.Path()).Parse(cfg); err != nil || len(warnings) != 0 {
+		t.Errorf("Unexpected result from Parse: err=%v, warnings=%v", err, warnings)
+	}
+	if err := os.Chmod(secret.Path(), 0640); err != nil {
+		t.Fatalf("Unable to chmod: %v", err)
+	}
+	if err := NewFile(secret.Path()).Parse(cfg); err != nil || len(warnings) != 1 {
+		t.Errorf("Unexpected result from Parse: err=%v, warnings=%v", err, warnings)
+	}
+	if err := os.Chmod(plain.Path(), 0666); err != nil {
+		t.Fatalf("Unable to chmod: %v", err)
+	}
+	if err := NewFile(plain.Path()).Parse(cfg); err != nil || len(warnings) != 1 {
+		t.Errorf("Unexpected result from Parse: err=%v, warnings=%v", err, warnings)
+	}
+
+	// Explicit permissions are applied even to existing files
+	secret.SetPermissions(0400)
+	if err := secret.Write(true); err != nil {
+		t.Fatalf("Unexpected error from Write: %v", err)
+	}
+	assertMode(secret.Path(), 0400)
+
+	// Atomic writes retain the permissions of the existing file
+	if err := os.Chmod(plain.Path(), 0640); err != nil {
+		t.Fatalf("Unable to chmod: %v", err)
+	}
+	plain.AtomicWrite = true
+	plain.SetOptionValue("", "visible", "y")
+	if err := plain.Write(true); err != nil {
+		t.Fatalf("Unexpected error from Write: %v", err)
+	}
+	assertMode(plain.Path(), 0640)
+}
+
+// TestFileAtomicWriteSymlink confirms that atomic writes replace the target of
+// a symlink rather than the symlink itself, and retain the target's ownership.
+func TestFileAtomicWriteSymlink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mybasetest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "target.cnf")
+	link := filepath.Join(dir, "link.cnf")
+	if err := ioutil.WriteFile(target, []byte("visible=x\n"), 0640); err != nil {
+		t.Fatalf("Unable to write file: %v", err)
+	}
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatalf("Unable to create symlink: %v", err)
+	}
+	wantUID, wantGID := os.Getuid(), os.Getgid()
+	if wantUID == 0 {
+		// Only root can give away ownership, so only root can confirm it is kept
+		wantUID, wantGID = 65534, 65534
+		if err := os.Chown(target, wantUID, wantGID); err != nil {
+			t.Fatalf("Unable to chown: %v", err)
+		}
+	}
+
+	f := NewFile(link)
+	f.AtomicWrite = true
+	f.SetOptionValue("", "visible", "y")
+	if err := f.Write(true); err != nil {
+		t.Fatalf("Unexpected error from Write: %v", err)
+	}
+	if fi, err := os.Lstat(link); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("Expected %s to still be a symlink; err=%v", link, err)
+	}
+	if contents, err := ioutil.ReadFile(target); err != nil || !strings.Contains(string(contents), "visible=y") {
+		t.Errorf("Expected target to be rewritten, instead found %q / %v", contents, err)
+	}
+	fi, err := os.Stat(target)
+	if err != nil {
+		t.Fatalf("Unexpected error from Stat: %v", err)
+	}
+	if fi.Mode().Perm() != 0640 {
+		t.Errorf("Expected permissions 0640 to be retained, instead found %s", fi.Mode().Perm())
+	}
+	if st := fi.Sys().(*syscall.Stat_t); int(st.Uid) != wantUID || int(st.Gid) != wantGID {
+		t.Errorf("Expected ownership %d:%d to be retained, instead found %d:%d", wantUID, wantGID, st.Uid, st.Gid)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, ".*.tmp*")); len(matches) > 0 {
+		t.Errorf("Unexpected leftover temporary files: %v", matches)
+	}
+}
@@ -0,0 +1,273 @@
+package mybase
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// StandardFilePaths returns the paths of option files typically consulted by a
+// program named appName, ordered from lowest to highest precedence. This
+// follows the conventions of MySQL's client programs, along with the XDG base
+// directory specification:
+//
+//	/etc/<appName>.cnf
+//	/etc/<appName>/<appName>.cnf
+//	$XDG_CONFIG_HOME/<appName>/<appName>.cnf
+//	~/.<appName>.cnf
+//
+// If the XDG_CONFIG_HOME environment variable is not set, ~/.config is used in
+// its place. On Windows, the following paths are used instead:
+//
+//	%PROGRAMDATA%\<appName>\<appName>.cnf
+//	%APPDATA%\<appName>\<appName>.cnf
+//	%USERPROFILE%\.<appName>.cnf
+//
+// Paths relative to the user's home directory, or to an environment variable
+// which is not set, are omitted. No check is made regarding whether the files
+// exist.
+func StandardFilePaths(appName string) []string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = ""
+	}
+	return standardFilePaths(appName, runtime.GOOS, home, os.Getenv)
+}
+
+// standardFilePaths implements StandardFilePaths for the supplied operating
+// system, home directory, and environment variable lookup function.
+func standardFilePaths(appName, goos, home string, getenv func(string) string) []string {
+	fileName := appName + ".cnf"
+	if goos == "windows" {
+		var paths []string
+		for _, dir := range []string{getenv("PROGRAMDATA"), getenv("APPDATA")} {
+			if dir != "" {
+				paths = append(paths, filepath.Join(dir, appName, fileName))
+			}
+		}
+		if home != "" {
+			paths = append(paths, filepath.Join(home, "."+fileName))
+		}
+		return paths
+	}
+	paths := []string{
+		filepath.Join("/etc", fileName),
+		filepath.Join("/etc", appName, fileName),
+	}
+	if configHome := getenv("XDG_CONFIG_HOME"); configHome != "" {
+		paths = append(paths, filepath.Join(configHome, appName, fileName))
+	} else if home != "" {
+		paths = append(paths, filepath.Join(home, ".config", appName, fileName))
+	}
+	if home != "" {
+		paths = append(paths, filepath.Join(home, "."+fileName))
+	}
+	return paths
+}
+
+// StandardFileChain returns a File for each path returned by
+// StandardFilePaths(appName) which exists, in the same order. The files are not
+// read or parsed; see Config.LoadFileChain.
+func StandardFileChain(appName string) []*File {
+	var files []*File
+	for _, path := range StandardFilePaths(appName) {
+		if f := NewFile(path); f.Exists() {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// AddDefaultsFileOptions adds options to cmd which control the behavior of
+// Config.LoadFileChain, mirroring the MySQL client programs' options of the
+// same names:
+//
+//	--defaults-file=path: only read options from the specified file
+//	--defaults-extra-file=path: also read the specified file, after all others
+//	--no-defaults: do not read any option files
+//	--print-defaults: print the options supplied by option files, and exit
+//
+// The options are added to the "global" group. Typically this is called on
+// the top-level command, so that the options are available to all
+// subcommands.
+func (cmd *Command) AddDefaultsFileOptions() {
+	cmd.AddOptions("global",
+		StringOption("defaults-file", 0, "", "Only read options from the specified file").Bootstrap(),
+		StringOption("defaults-extra-file", 0, "", "Read options from the specified file after all other option files").Bootstrap(),
+		BoolOption("no-defaults", 0, false, "Do not read options from any option file").Bootstrap(),
+		BoolOption("print-defaults", 0, false, "Print the program name and all options supplied by option files, and exit"),
+	)
+}
+
+// LoadFileChain parses each of the supplied files which exists, and adds it to
+// cfg as a source, in order. Later files therefore take precedence over earlier
+// ones, and all files take precedence over any sources previously added to cfg.
+// Files which do not exist are skipped. Parsing stops at the first error. The
+// files which were added are returned, so that the caller may subsequently
+// select sections via File.UseSection.
+//
+// The supplied files may be overridden on the command-line, using the options
+// added by Command.AddDefaultsFileOptions. Only the command-line is consulted
+// for these options, since they determine which other sources are used:
+//
+//   - If --no-defaults is supplied, no files are loaded at all.
+//   - If --defaults-file is supplied, the supplied files are ignored, and only
+//     the file at that path is loaded. It is an error if it does not exist.
+//   - If --defaults-extra-file is supplied, the file at that path is loaded
+//     after all others, so it takes precedence over them. It is an error if it
+//     does not exist.
+//
+// These options are ignored if the command does not have them.
+func (cfg *Config) LoadFileChain(files ...*File) ([]*File, error) {
+	if BoolValue(cfg.cliValue("no-defaults")) {
+		return nil, nil
+	}
+	var required []*File
+	if path := cfg.cliValue("defaults-file"); path != "" {
+		files = nil
+		required = append(required, NewFile(path))
+	}
+	if path := cfg.cliValue("defaults-extra-file"); path != "" {
+		required = append(required, NewFile(path))
+	}
+
+	var added []*File
+	for _, f := range files {
+		if !f.Exists() {
+			continue
+		}
+		if err := f.Parse(cfg); err != nil {
+			return added, err
+		}
+		cfg.AddSource(f)
+		added = append(added, f)
+	}
+	for _, f := range required {
+		if err := f.Parse(cfg); err != nil {
+			return added, err
+		}
+		cfg.AddSource(f)
+		added = append(added, f)
+	}
+	return added, nil
+}
+
+// DirFileChain returns a File for each directory from root down to leaf which
+// contains an option file named fileName, ordered from root to leaf. This
+// permits per-directory configuration, in which an option file in a
+// subdirectory overrides values from option files in its parent directories.
+// The files are not read or parsed; see Config.LoadDirChain.
+//
+// If root is "", every ancestor of leaf is checked, up to the filesystem root.
+// Otherwise, an error is returned if leaf is not root or a descendant of root.
+func DirFileChain(leaf, root, fileName string) ([]*File, error) {
+	leaf, err := filepath.Abs(leaf)
+	if err != nil {
+		return nil, err
+	}
+	if root != "" {
+		if root, err = filepath.Abs(root); err != nil {
+			return nil, err
+		}
+		if rel, err := filepath.Rel(root, leaf); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("Directory %s is not within %s", leaf, root)
+		}
+	}
+	var files []*File
+	for dir := leaf; ; dir = filepath.Dir(dir) {
+		if f := NewFile(dir, fileName); f.Exists() {
+			files = append([]*File{f}, files...)
+		}
+		if dir == root || filepath.Dir(dir) == dir {
+			break
+		}
+	}
+	return files, nil
+}
+
+// LoadDirChain parses each option file returned by DirFileChain(leaf, root,
+// fileName), and adds it to cfg as a source, in order. Files in deeper
+// directories therefore take precedence over files in their ancestors, and all
+// files take precedence over any sources previously added to cfg. Parsing stops
+// at the first error. The files which were added are returned, so that the
+// caller may subsequently select sections via File.UseSection.
+//
+// Unlike LoadFileChain, this is not affected by the options added by
+// Command.AddDefaultsFileOptions.
+func (cfg *Config) LoadDirChain(leaf, root, fileName string) ([]*File, error) {
+	files, err := DirFileChain(leaf, root, fileName)
+	if err != nil {
+		return nil, err
+	}
+	var added []*File
+	for _, f := range files {
+		if err := f.Parse(cfg); err != nil {
+			return added, err
+		}
+		cfg.AddSource(f)
+		added = append(added, f)
+	}
+	return added, nil
+}
+
+// cliValue returns the unquoted value of the named option as supplied on the
+// command-line, or an empty string if it was not supplied there. Unlike
+// Config.Get, this does not panic if the option does not exist.
+func (cfg *Config) cliValue(name string) string {
+	value, _ := cfg.CLI.OptionValue(name)
+	return unquote(value)
+}
+
+// PrintDefaults writes the options supplied by cfg's sources to w, in the
+// same manner as the MySQL client programs' --print-defaults option: a line
+// naming the program, followed by a line listing each option in the form it
+// would take on the command-line. This is intended to help users debug
+// layered option files. Only sources added to cfg are considered, such as
+// option files and environment variables; options supplied on the command-line
+// and default values are excluded. If several sources supply the same option,
+// the value from the highest-precedence source is shown. Options are listed
+// alphabetically, and values of options marked with Option.Sensitive are
+// redacted.
+func (cfg *Config) PrintDefaults(w io.Writer) error {
+	sources := cfg.Sources()
+	options := cfg.CLI.Command.Options()
+	names := make([]string, 0, len(options))
+	for name := range options {
+		if !cfg.CLI.Command.HasArg(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	args := []string{}
+	for _, name := range names {
+		opt := options[name]
+		for n := len(sources) - 1; n >= 0; n-- {
+			if value, ok := optionValueOrAlias(sources[n], opt); ok {
+				args = append(args, printDefaultsArg(opt, value))
+				break
+			}
+		}
+	}
+	_, err := fmt.Fprintf(w, "%s would have been started with the following arguments:\n%s\n", cfg.CLI.Command.Root().Name, strings.Join(args, " "))
+	return err
+}
+
+// printDefaultsArg returns opt with the supplied raw value from a source, in
+// the form used by PrintDefaults.
+func printDefaultsArg(opt *Option, value string) string {
+	if opt.Type == OptionTypeBool {
+		if BoolValue(value) {
+			return "--" + opt.Name
+		}
+		return "--skip-" + opt.Name
+	}
+	return fmt.Sprintf("--%s=%s", opt.Name, opt.displayValue(unquote(value)))
+}
+
+func printDefaultsHandler(cfg *Config) error {
+	return cfg.PrintDefaults(os.Stdout)
+}
@@ -0,0 +1,205 @@
+package mybase
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStandardFilePathsWindows(t *testing.T) {
+	env := map[string]string{"PROGRAMDATA": `C:\ProgramData`, "APPDATA": `C:\Users\me\AppData\Roaming`}
+	getenv := func(name string) string { return env[name] }
+	expected := []string{
+		filepath.Join(env["PROGRAMDATA"], "mybasetest", "mybasetest.cnf"),
+		filepath.Join(env["APPDATA"], "mybasetest", "mybasetest.cnf"),
+		filepath.Join(`C:\Users\me`, ".mybasetest.cnf"),
+	}
+	if actual := standardFilePaths("mybasetest", "windows", `C:\Users\me`, getenv); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Unexpected result from standardFilePaths: %v", actual)
+	}
+	delete(env, "APPDATA")
+	if actual := standardFilePaths("mybasetest", "windows", "", getenv); !reflect.DeepEqual(actual, expected[:1]) {
+		t.Errorf("Unexpected result from standardFilePaths: %v", actual)
+	}
+}
+
+func TestStandardFileChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mybasetest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	defer os.Setenv("XDG_CONFIG_HOME", os.Getenv("XDG_CONFIG_HOME"))
+	os.Setenv("HOME", dir)
+	os.Setenv("XDG_CONFIG_HOME", "")
+
+	expected := []string{
+		"/etc/mybasetest.cnf",
+		"/etc/mybasetest/mybasetest.cnf",
+		filepath.Join(dir, ".config", "mybasetest", "mybasetest.cnf"),
+		filepath.Join(dir, ".mybasetest.cnf"),
+	}
+	if actual := StandardFilePaths("mybasetest"); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Unexpected result from StandardFilePaths: %v", actual)
+	}
+	os.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "xdg"))
+	expected[2] = filepath.Join(dir, "xdg", "mybasetest", "mybasetest.cnf")
+	if actual := StandardFilePaths("mybasetest"); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Unexpected result from StandardFilePaths: %v", actual)
+	}
+
+	// Only existing files are included in the chain, and later ones take
+	// precedence when loaded
+	if err := os.MkdirAll(filepath.Dir(expected[2]), 0755); err != nil {
+		t.Fatalf("Unable to create dir: %v", err)
+	}
+	writeFile := func(path, contents string) {
+		t.Helper()
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatalf("Unable to write %s: %v", path, err)
+		}
+	}
+	writeFile(expected[2], "visible=xdg\nhasshort=xdg\n")
+	writeFile(expected[3], "hasshort=home\n")
+	files := StandardFileChain("mybasetest")
+	if len(files) != 2 || files[0].Path() != expected[2] || files[1].Path() != expected[3] {
+		t.Fatalf("Unexpected result from StandardFileChain: %v", files)
+	}
+
+	cmd := simpleCommand()
+	cmd.AddDefaultsFileOptions()
+	cfg := ParseFakeCLI(t, cmd, "mycommand arg1")
+	added, err := cfg.LoadFileChain(append(files, NewFile(dir, "nonexistent.cnf"))...)
+	if err != nil || len(added) != 2 {
+		t.Fatalf("Unexpected result from LoadFileChain: %v, %v", added, err)
+	}
+	if cfg.Get("visible") != "xdg" || cfg.Get("hasshort") != "home" {
+		t.Errorf("Unexpected values after LoadFileChain: visible=%q hasshort=%q", cfg.Get("visible"), cfg.Get("hasshort"))
+	}
+
+	// --defaults-file replaces the chain, and must exist
+	writeFile(filepath.Join(dir, "override.cnf"), "visible=override\n")
+	cfg = ParseFakeCLI(t, cmd, "mycommand --defaults-file="+filepath.Join(dir, "override.cnf")+" arg1")
+	if added, err = cfg.LoadFileChain(files...); err != nil || len(added) != 1 {
+		t.Fatalf("Unexpected result from LoadFileChain: %v, %v", added, err)
+	}
+	if cfg.Get("visible") != "override" || cfg.Get("hasshort") != "" {
+		t.Errorf("Unexpected values after LoadFileChain: visible=%q hasshort=%q", cfg.Get("visible"), cfg.Get("hasshort"))
+	}
+	for _, name := range []string{"defaults-file", "defaults-extra-file"} {
+		cfg = ParseFakeCLI(t, cmd, "mycommand --"+name+"="+filepath.Join(dir, "nonexistent.cnf")+" arg1")
+		if _, err = cfg.LoadFileChain(files...); err == nil {
+			t.Errorf("Expected error from LoadFileChain with nonexistent %s, but err is nil", name)
+		}
+	}
+
+	// --defaults-extra-file is loaded after the chain
+	cfg = ParseFakeCLI(t, cmd, "mycommand --defaults-extra-file="+filepath.Join(dir, "override.cnf")+" arg1")
+	if added, err = cfg.LoadFileChain(files...); err != nil || len(added) != 3 {
+		t.Fatalf("Unexpected result from LoadFileChain: %v, %v", added, err)
+	}
+	if cfg.Get("visible") != "override" || cfg.Get("hasshort") != "home" {
+		t.Errorf("Unexpected values after LoadFileChain: visible=%q hasshort=%q", cfg.Get("visible"), cfg.Get("hasshort"))
+	}
+
+	// --no-defaults disables all files, even when combined with the others
+	cfg = ParseFakeCLI(t, cmd, "mycommand --no-defaults --defaults-extra-file="+filepath.Join(dir, "override.cnf")+" arg1")
+	if added, err = cfg.LoadFileChain(files...); err != nil || len(added) != 0 || cfg.Get("visible") != "" {
+		t.Errorf("Unexpected result from LoadFileChain with --no-defaults: %v, %v", added, err)
+	}
+
+	// Values from other sources are ignored for these options
+	cfg = ParseFakeCLI(t, cmd, "mycommand arg1")
+	cfg.AddSource(SimpleSource(map[string]string{"no-defaults": "1"}))
+	if added, err = cfg.LoadFileChain(files...); err != nil || len(added) != 2 {
+		t.Errorf("Unexpected result from LoadFileChain: %v, %v", added, err)
+	}
+}
+
+func TestDirFileChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mybasetest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	leaf := filepath.Join(dir, "a", "b", "c")
+	if err := os.MkdirAll(leaf, 0755); err != nil {
+		t.Fatalf("Unable to create dir: %v", err)
+	}
+	writeFile := func(path, contents string) {
+		t.Helper()
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatalf("Unable to write %s: %v", path, err)
+		}
+	}
+	writeFile(filepath.Join(dir, ".mybasetest"), "visible=root\nhasshort=root\nhidden=root\n")
+	writeFile(filepath.Join(dir, "a", ".mybasetest"), "visible=a\n[production]\nhasshort=a-prod\n")
+	writeFile(filepath.Join(leaf, ".mybasetest"), "visible=c\n")
+
+	files, err := DirFileChain(leaf, dir, ".mybasetest")
+	expected := []string{filepath.Join(dir, ".mybasetest"), filepath.Join(dir, "a", ".mybasetest"), filepath.Join(leaf, ".mybasetest")}
+	if err != nil || len(files) != len(expected) {
+		t.Fatalf("Unexpected result from DirFileChain: %v, %v", files, err)
+	}
+	for n, f := range files {
+		if f.Path() != expected[n] {
+			t.Errorf("Expected files[%d] to be %s, instead found %s", n, expected[n], f.Path())
+		}
+	}
+
+	// Without a root, the search continues above dir, but there should be no
+	// additional matching files there
+	if files, err := DirFileChain(leaf, "", ".mybasetest"); err != nil || len(files) != len(expected) {
+		t.Errorf("Unexpected result from DirFileChain without root: %v, %v", files, err)
+	}
+	if files, err := DirFileChain(filepath.Join(dir, "a"), filepath.Join(dir, "a"), ".mybasetest"); err != nil || len(files) != 1 {
+		t.Errorf("Unexpected result from DirFileChain with leaf equal to root: %v, %v", files, err)
+	}
+	if _, err := DirFileChain(dir, leaf, ".mybasetest"); err == nil {
+		t.Error("Expected error from DirFileChain with leaf outside of root, but err is nil")
+	}
+
+	// Deeper directories take precedence
+	cfg := ParseFakeCLI(t, simpleCommand(), "mycommand arg1")
+	added, err := cfg.LoadDirChain(leaf, dir, ".mybasetest")
+	if err != nil || len(added) != 3 {
+		t.Fatalf("Unexpected result from LoadDirChain: %v, %v", added, err)
+	}
+	for _, f := range added {
+		f.UseSection("production")
+	}
+	if cfg.Get("visible") != "c" || cfg.Get("hasshort") != "a-prod" || cfg.Get("hidden") != "root" {
+		t.Errorf("Unexpected values after LoadDirChain: visible=%q hasshort=%q hidden=%q", cfg.Get("visible"), cfg.Get("hasshort"), cfg.Get("hidden"))
+	}
+}
+
+func TestPrintDefaults(t *testing.T) {
+	cmd := simpleCommand()
+	cmd.AddOption(StringOption("password", 'p', "", "dummy description").Sensitive())
+	cmd.AddDefaultsFileOptions()
+	cfg := ParseFakeCLI(t, cmd, "mycommand --print-defaults --visible=fromcli")
+	low := SimpleSource(map[string]string{"visible": "low", "hasshort": "low", "bool1": "1"})
+	high := SimpleSource(map[string]string{"hasshort": "'high value'", "truthybool": "0", "password": "s3cr3t"})
+	cfg.AddSource(low)
+	cfg.AddSource(high)
+
+	var b strings.Builder
+	if err := cfg.PrintDefaults(&b); err != nil {
+		t.Fatalf("Unexpected error from PrintDefaults: %v", err)
+	}
+	expected := "mycommand would have been started with the following arguments:\n--bool1 --hasshort=high value --password=" + redactedValue + " --skip-truthybool --visible=low\n"
+	if actual := b.String(); actual != expected {
+		t.Errorf("Unexpected output from PrintDefaults:\n%s\nexpected:\n%s", actual, expected)
+	}
+
+	// Output still includes the heading if no sources supply options
+	b.Reset()
+	cfg = ParseFakeCLI(t, cmd, "mycommand arg1")
+	if err := cfg.PrintDefaults(&b); err != nil || b.String() != "mycommand would have been started with the following arguments:\n\n" {
+		t.Errorf("Unexpected output from PrintDefaults with no sources: %q, %v", b.String(), err)
+	}
+}
@@ -0,0 +1,701 @@
+package mybase
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// FileFormat identifies the syntax used by an option file.
+//
+// Regardless of format, an option file consists of option values in a default
+// section, plus any number of named sections of option values. In JSON files,
+// the top-level value must be an object; its members with object values are
+// named sections, and all other members are option values in the default
+// section. YAML files use the same structure, expressed as a mapping of
+// "key: value" lines, where a key with no value followed by indented lines
+// begins a named section. Only this limited subset of YAML is supported:
+// sequences, nested mappings, anchors, tags, and multi-line scalars are not.
+// TOML files use "key = value" lines, with "[name]" table headers beginning
+// named sections. Only single-line values are supported in TOML: basic and
+// literal strings, numbers, booleans, dates, and arrays of these, which are
+// converted to a comma-separated list for use with MultiOption. Nested tables,
+// dotted keys, arrays of tables, inline tables, and multi-line strings are not
+// supported.
+//
+// In all of these formats, string, number, and boolean values are treated the
+// same as the equivalent value in an ini-style file. In JSON and YAML, a null
+// value is equivalent to an option name without any value. Directives such as
+// !include are only supported in ini-style files.
+type FileFormat int
+
+// Constants representing different FileFormat enumerated values
+const (
+	FileFormatAuto FileFormat = iota // select format based on file extension: .json, .yaml, .yml, or .toml; otherwise ini (default)
+	FileFormatINI                    // ini-style MySQL option file syntax
+	FileFormatJSON                   // JSON object
+	FileFormatYAML                   // limited subset of YAML
+	FileFormatTOML                   // limited subset of TOML
+)
+
+// formatForPath returns the format of an option file based on its extension.
+func formatForPath(path string) FileFormat {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return FileFormatJSON
+	case ".yaml", ".yml":
+		return FileFormatYAML
+	case ".toml":
+		return FileFormatTOML
+	default:
+		return FileFormatINI
+	}
+}
+
+// syntax returns the format of the file, resolving FileFormatAuto based on the
+// file's extension.
+func (f *File) syntax() FileFormat {
+	if f.Syntax != FileFormatAuto {
+		return f.Syntax
+	}
+	return formatForPath(f.Name)
+}
+
+// parseJSON parses a JSON-format option file from r. Values are applied via
+// the same logic as ini-style files, by converting each member of the JSON
+// object into the equivalent ini-style line.
+func (p *fileParser) parseJSON(r io.Reader, filePath string) error {
+	data, err := p.cleanContents(r, filePath)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	lineNumber := func() int {
+		return 1 + bytes.Count(data[:dec.InputOffset()], []byte{'\n'})
+	}
+	formatErr := func(format string, a ...interface{}) error {
+		return FileParseFormatError{Problem: fmt.Sprintf(format, a...), FilePath: filePath, LineNumber: lineNumber()}
+	}
+	nextToken := func() (json.Token, error) {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil, formatErr("unexpected end of JSON input")
+		} else if err != nil {
+			return nil, formatErr("%s", err)
+		}
+		return tok, nil
+	}
+
+	if tok, err := nextToken(); err != nil {
+		return err
+	} else if tok != json.Delim('{') {
+		return formatErr("top-level JSON value must be an object")
+	}
+	defaultSection := p.file.sectionIndex[""]
+	for dec.More() {
+		tok, err := nextToken()
+		if err != nil {
+			return err
+		}
+		key := tok.(string) // object keys are always strings
+		if tok, err = nextToken(); err != nil {
+			return err
+		}
+		if tok != json.Delim('{') {
+			if err := p.setJSONValue(defaultSection, key, tok, filePath, lineNumber()); err != nil {
+				return err
+			}
+			continue
+		}
+		section, err := p.parseLineInto(defaultSection, "["+key+"]", filePath, lineNumber())
+		if err != nil {
+			return err
+		}
+		for dec.More() {
+			if tok, err = nextToken(); err != nil {
+				return err
+			}
+			optionName := tok.(string)
+			if tok, err = nextToken(); err != nil {
+				return err
+			}
+			if err := p.setJSONValue(section, optionName, tok, filePath, lineNumber()); err != nil {
+				return err
+			}
+		}
+		if _, err := nextToken(); err != nil { // closing brace of section
+			return err
+		}
+	}
+	if _, err := nextToken(); err != nil { // closing brace of top-level object
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return formatErr("unexpected content after top-level JSON object")
+	}
+	return nil
+}
+
+// setJSONValue stores the value represented by tok for the named option.
+func (p *fileParser) setJSONValue(section *Section, name string, tok json.Token, filePath string, lineNumber int) error {
+	switch tok := tok.(type) {
+	case nil:
+		return p.setValue(section, name, "", false, filePath, lineNumber)
+	case string:
+		return p.setValue(section, name, tok, true, filePath, lineNumber)
+	case json.Number:
+		return p.setValue(section, name, tok.String(), true, filePath, lineNumber)
+	case bool:
+		return p.setValue(section, name, strconv.FormatBool(tok), true, filePath, lineNumber)
+	}
+	// Arrays and nested objects cannot be skipped without further scanning, so
+	// this is fatal even when collecting all errors
+	return FileParseFormatError{
+		Problem:    fmt.Sprintf("unsupported value type for %s: only strings, numbers, booleans, and null are permitted", name),
+		FilePath:   filePath,
+		LineNumber: lineNumber,
+	}
+}
+
+// parseYAML parses a YAML-format option file from r. Only the limited subset
+// of YAML described in the FileFormat documentation is supported. Values are
+// applied via the same logic as ini-style files, by converting each line into
+// the equivalent ini-style line.
+func (p *fileParser) parseYAML(r io.Reader, filePath string) error {
+	var lines []string
+	scanner := newScanner(r, p.file.maxLineLength())
+	for scanner.Scan() {
+		line, ok, err := p.cleanLine(scanner.Text(), filePath, len(lines)+1)
+		if err != nil {
+			return err
+		} else if !ok {
+			line = "" // retain numbering of subsequent lines
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return p.scanErr(err, filePath, len(lines)+1)
+	}
+
+	// nextIndent returns the indentation of the next non-blank, non-comment line
+	// after lines[n], or -1 if there is no such line.
+	nextIndent := func(n int) int {
+		for _, line := range lines[n+1:] {
+			if trimmed := strings.TrimSpace(line); trimmed != "" && trimmed[0] != '#' {
+				return len(line) - len(strings.TrimLeft(line, " "))
+			}
+		}
+		return -1
+	}
+
+	defaultSection := p.file.sectionIndex[""]
+	var section *Section // current named section, or nil if at top level
+	for n, line := range lines {
+		lineNumber := n + 1
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed[0] == '#' || ((trimmed == "---" || trimmed == "...") && line == trimmed) {
+			continue
+		}
+		formatErr := func(problem string) error {
+			return p.fail(FileParseFormatError{Problem: problem, FilePath: filePath, LineNumber: lineNumber})
+		}
+		if line[0] == '\t' {
+			if err := formatErr("tabs may not be used for indentation"); err != nil {
+				return err
+			}
+			continue
+		}
+		key, value, hasValue, err := parseYAMLLine(trimmed)
+		if err != nil {
+			if err := formatErr(err.Error()); err != nil {
+				return err
+			}
+			continue
+		}
+
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		if indent == 0 {
+			section = nil
+			if !hasValue && nextIndent(n) > 0 {
+				if section, err = p.parseLineInto(defaultSection, "["+key+"]", filePath, lineNumber); err != nil {
+					section = nil
+					if err := p.fail(err); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+		} else if section == nil {
+			if err := formatErr("unexpected indentation"); err != nil {
+				return err
+			}
+			continue
+		} else if !hasValue && nextIndent(n) > indent {
+			if err := formatErr("nested mappings are not supported"); err != nil {
+				return err
+			}
+			continue
+		}
+
+		target := section
+		if target == nil {
+			target = defaultSection
+		}
+		if err := p.setValue(target, key, value, hasValue, filePath, lineNumber); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseYAMLLine parses a "key: value" line of a YAML file, which has already
+// been trimmed of surrounding whitespace. The returned value has already been
+// unquoted. The returned bool is false if the value is null or omitted.
+func parseYAMLLine(line string) (key, value string, hasValue bool, err error) {
+	if line == "-" || strings.HasPrefix(line, "- ") {
+		return "", "", false, errors.New("sequences are not supported")
+	}
+	pos := strings.Index(line, ": ")
+	if pos == -1 && strings.HasSuffix(line, ":") {
+		pos = len(line) - 1
+	} else if pos == -1 {
+		return "", "", false, errors.New("expected key: value")
+	}
+	key = strings.TrimSpace(line[:pos])
+	rest := strings.TrimSpace(line[pos+1:])
+
+	var after string
+	switch {
+	case rest == "" || rest[0] == '#':
+		return key, "", false, nil
+	case rest[0] == '"':
+		end := 1
+		for ; end < len(rest) && rest[end] != '"'; end++ {
+			if rest[end] == '\\' {
+				end++
+			}
+		}
+		if end >= len(rest) {
+			return "", "", false, errors.New("quoted value has no terminating quote")
+		}
+		if value, err = strconv.Unquote(rest[:end+1]); err != nil {
+			return "", "", false, fmt.Errorf("invalid quoted value: %s", err)
+		}
+		after = rest[end+1:]
+	case rest[0] == '\'':
+		var b strings.Builder
+		end := 1
+		for ; end < len(rest); end++ {
+			if rest[end] == '\'' {
+				if end+1 < len(rest) && rest[end+1] == '\'' { // '' is an escaped single quote
+					end++
+				} else {
+					break
+				}
+			}
+			b.WriteByte(rest[end])
+		}
+		if end >= len(rest) {
+			return "", "", false, errors.New("quoted value has no terminating quote")
+		}
+		value = b.String()
+		after = rest[end+1:]
+	case strings.ContainsRune("[{&*!|>%@`", rune(rest[0])):
+		return "", "", false, fmt.Errorf("unsupported value syntax %q", rest)
+	default:
+		if pos := strings.Index(rest, " #"); pos > -1 {
+			rest = strings.TrimSpace(rest[:pos])
+		}
+		switch rest {
+		case "~", "null", "Null", "NULL":
+			return key, "", false, nil
+		}
+		return key, rest, true, nil
+	}
+	if after = strings.TrimSpace(after); after != "" && after[0] != '#' {
+		return "", "", false, errors.New("extra characters after quoted value")
+	}
+	return key, value, true, nil
+}
+
+// parseTOML parses a TOML-format option file from r. Only the limited subset of
+// TOML described in the FileFormat documentation is supported. Values are
+// applied via the same logic as ini-style files, by converting each line into
+// the equivalent ini-style line.
+func (p *fileParser) parseTOML(r io.Reader, filePath string) error {
+	defaultSection := p.file.sectionIndex[""]
+	section := defaultSection
+	var lineNumber int
+	scanner := newScanner(r, p.file.maxLineLength())
+	for scanner.Scan() {
+		lineNumber++
+		line, ok, err := p.cleanLine(scanner.Text(), filePath, lineNumber)
+		if err != nil {
+			return err
+		} else if !ok {
+			continue
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		formatErr := func(problem string) error {
+			return p.fail(FileParseFormatError{Problem: problem, FilePath: filePath, LineNumber: lineNumber})
+		}
+		if line[0] == '[' {
+			name, err := parseTOMLTable(line)
+			if err != nil {
+				if err := formatErr(err.Error()); err != nil {
+					return err
+				}
+				continue
+			}
+			if section, err = p.parseLineInto(defaultSection, "["+name+"]", filePath, lineNumber); err != nil {
+				section = defaultSection
+				if err := p.fail(err); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		key, value, err := parseTOMLLine(line)
+		if err != nil {
+			if err := formatErr(err.Error()); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := p.setValue(section, key, value, true, filePath, lineNumber); err != nil {
+			return err
+		}
+	}
+	return p.scanErr(scanner.Err(), filePath, lineNumber+1)
+}
+
+// parseTOMLTable parses a "[name]" table header line of a TOML file, which has
+// already been trimmed of surrounding whitespace, returning the table name.
+func parseTOMLTable(line string) (string, error) {
+	if strings.HasPrefix(line, "[[") {
+		return "", errors.New("arrays of tables are not supported")
+	}
+	name, rest, err := parseTOMLKey(strings.TrimLeft(line[1:], " \t"))
+	if err != nil {
+		return "", err
+	}
+	rest = strings.TrimSpace(rest)
+	if strings.HasPrefix(rest, ".") {
+		return "", errors.New("nested tables are not supported")
+	} else if !strings.HasPrefix(rest, "]") {
+		return "", errors.New("table header has no terminating bracket")
+	}
+	if rest = strings.TrimSpace(rest[1:]); rest != "" && rest[0] != '#' {
+		return "", errors.New("extra characters after table header")
+	}
+	return name, nil
+}
+
+// parseTOMLLine parses a "key = value" line of a TOML file, which has already
+// been trimmed of surrounding whitespace. The returned value has already been
+// unquoted; arrays are converted to a comma-separated list of their elements.
+func parseTOMLLine(line string) (key, value string, err error) {
+	key, rest, err := parseTOMLKey(line)
+	if err != nil {
+		return "", "", err
+	}
+	rest = strings.TrimSpace(rest)
+	if strings.HasPrefix(rest, ".") {
+		return "", "", errors.New("dotted keys are not supported")
+	} else if !strings.HasPrefix(rest, "=") {
+		return "", "", errors.New("expected key = value")
+	}
+	rest = strings.TrimSpace(rest[1:])
+	if strings.HasPrefix(rest, "[") {
+		var elements []string
+		rest = strings.TrimSpace(rest[1:])
+		for !strings.HasPrefix(rest, "]") {
+			var element string
+			if element, rest, err = parseTOMLValue(rest); err != nil {
+				return "", "", err
+			}
+			elements = append(elements, element)
+			rest = strings.TrimSpace(rest)
+			if strings.HasPrefix(rest, ",") {
+				rest = strings.TrimSpace(rest[1:])
+			} else if !strings.HasPrefix(rest, "]") {
+				return "", "", errors.New("arrays must be on a single line, with elements separated by commas")
+			}
+		}
+		value, rest = strings.Join(elements, ","), rest[1:]
+	} else if value, rest, err = parseTOMLValue(rest); err != nil {
+		return "", "", err
+	}
+	if rest = strings.TrimSpace(rest); rest != "" && rest[0] != '#' {
+		return "", "", errors.New("extra characters after value")
+	}
+	return key, value, nil
+}
+
+// parseTOMLKey parses a bare or quoted key at the start of s, returning the
+// key and the remainder of s.
+func parseTOMLKey(s string) (key, rest string, err error) {
+	if strings.HasPrefix(s, `"`) || strings.HasPrefix(s, "'") {
+		return parseTOMLString(s)
+	}
+	end := strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' || r == '-')
+	})
+	if end == -1 {
+		end = len(s)
+	}
+	if end == 0 {
+		return "", "", errors.New("expected key = value")
+	}
+	return s[:end], s[end:], nil
+}
+
+// parseTOMLValue parses a single non-array value at the start of s, returning
+// the value and the remainder of s. Strings are unquoted, and underscores are
+// removed from numbers. Other values are returned as-is.
+func parseTOMLValue(s string) (value, rest string, err error) {
+	if strings.HasPrefix(s, `"""`) || strings.HasPrefix(s, "'''") {
+		return "", "", errors.New("multi-line strings are not supported")
+	} else if strings.HasPrefix(s, `"`) || strings.HasPrefix(s, "'") {
+		return parseTOMLString(s)
+	} else if strings.HasPrefix(s, "{") {
+		return "", "", errors.New("inline tables are not supported")
+	} else if strings.HasPrefix(s, "[") {
+		return "", "", errors.New("nested arrays are not supported")
+	}
+	end := strings.IndexAny(s, ",]#")
+	if end == -1 {
+		end = len(s)
+	}
+	value = strings.TrimSpace(s[:end])
+	if value == "" {
+		return "", "", errors.New("missing value")
+	}
+	if value[0] == '+' || value[0] == '-' || (value[0] >= '0' && value[0] <= '9') {
+		if !strings.ContainsAny(value, ":T") { // not a date or time
+			value = strings.Replace(value, "_", "", -1)
+		}
+	}
+	return value, s[end:], nil
+}
+
+// parseTOMLString parses a basic ("...") or literal ('...') string at the
+// start of s, returning the unquoted string and the remainder of s.
+func parseTOMLString(s string) (value, rest string, err error) {
+	if s[0] == '\'' {
+		end := strings.IndexByte(s[1:], '\'')
+		if end == -1 {
+			return "", "", errors.New("quoted value has no terminating quote")
+		}
+		return s[1 : end+1], s[end+2:], nil
+	}
+	end := 1
+	for ; end < len(s) && s[end] != '"'; end++ {
+		if s[end] == '\\' {
+			end++
+		}
+	}
+	if end >= len(s) {
+		return "", "", errors.New("quoted value has no terminating quote")
+	}
+	if value, err = strconv.Unquote(s[:end+1]); err != nil {
+		return "", "", fmt.Errorf("invalid quoted value: %s", err)
+	}
+	return value, s[end+1:], nil
+}
+
+// setValue stores a value from a JSON, YAML, or TOML file for the named
+// option, by converting it into the equivalent ini-style line. The value
+// should already be unquoted, if it was quoted in the original file. A non-nil
+// error indicates parsing should stop.
+func (p *fileParser) setValue(section *Section, name, value string, hasValue bool, filePath string, lineNumber int) error {
+	formatErr := func(problem string) error {
+		return p.fail(FileParseFormatError{Problem: problem, FilePath: filePath, LineNumber: lineNumber})
+	}
+	if name == "" || strings.ContainsAny(name, "=#;![]'\"`\\ \t") {
+		return formatErr(fmt.Sprintf("invalid option name %q", name))
+	}
+	line := name
+	if hasValue {
+		line += "=" + QuoteValue(value)
+	}
+	if _, err := p.parseLineInto(section, line, filePath, lineNumber); err != nil {
+		return p.fail(err)
+	}
+	return nil
+}
+
+// cleanContents reads all of r, checking each line via cleanLine. Lines which
+// cleanLine indicates should be skipped are blanked out, retaining the line
+// numbering of subsequent lines.
+func (p *fileParser) cleanContents(r io.Reader, filePath string) ([]byte, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	lines := bytes.Split(data, []byte{'\n'})
+	for n := range lines {
+		line, ok, err := p.cleanLine(string(lines[n]), filePath, n+1)
+		if err != nil {
+			return nil, err
+		} else if !ok {
+			line = ""
+		}
+		lines[n] = []byte(line)
+	}
+	return bytes.Join(lines, []byte{'\n'}), nil
+}
+
+// QuoteValue returns value in a form suitable for use in an ini-style option
+// file line, or for passing to File.SetOptionValue, such that Config getters
+// return the original value. If value contains any characters which would
+// otherwise be interpreted specially, or leading or trailing whitespace, it is
+// wrapped in double quotes, with backslashes, double quotes, and control
+// characters escaped.
+func QuoteValue(value string) string {
+	if !strings.ContainsAny(value, "#'\"`\\\b\t\n\r") && value == strings.TrimSpace(value) {
+		return value
+	}
+	return `"` + valueEscaper.Replace(value) + `"`
+}
+
+// valueEscaper escapes the characters in a value which cannot appear literally
+// within a double-quoted value. It is the inverse of unescape.
+var valueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\b", `\b`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+// renderJSON returns the file's sections formatted as a JSON object, for use
+// by Write. The returned bool is false if there is nothing to write. The
+// caller must hold a lock on f.mu.
+func (f *File) renderJSON() (string, bool) {
+	var members []string
+	for _, section := range f.sections {
+		values := section.renderValues(func(name, value string, isBool bool) string {
+			if isBool {
+				return fmt.Sprintf("%s: %t", jsonString(name), BoolValue(value))
+			}
+			return fmt.Sprintf("%s: %s", jsonString(name), jsonString(unquote(value)))
+		})
+		if section.Name == "" {
+			members = append(members, values...)
+		} else if len(values) == 0 {
+			members = append(members, fmt.Sprintf("%s: {}", jsonString(section.Name)))
+		} else {
+			indented := strings.Join(values, ",\n    ")
+			members = append(members, fmt.Sprintf("%s: {\n    %s\n  }", jsonString(section.Name), indented))
+		}
+	}
+	if len(members) == 0 {
+		return "", false
+	}
+	return fmt.Sprintf("{\n  %s\n}\n", strings.Join(members, ",\n  ")), true
+}
+
+// renderYAML returns the file's sections formatted as YAML, for use by Write.
+// Sections without any values are omitted, since they cannot be represented
+// in the supported subset of YAML. The returned bool is false if there is
+// nothing to write. The caller must hold a lock on f.mu.
+func (f *File) renderYAML() (string, bool) {
+	var lines []string
+	for _, section := range f.sections {
+		indent := ""
+		if section.Name != "" {
+			indent = "  "
+		}
+		values := section.renderValues(func(name, value string, isBool bool) string {
+			if isBool {
+				return fmt.Sprintf("%s%s: %t", indent, name, BoolValue(value))
+			}
+			return fmt.Sprintf("%s%s: %s", indent, name, yamlString(unquote(value)))
+		})
+		if section.Name != "" && len(values) > 0 {
+			lines = append(lines, section.Name+":")
+		}
+		lines = append(lines, values...)
+	}
+	if len(lines) == 0 {
+		return "", false
+	}
+	return strings.Join(lines, "\n") + "\n", true
+}
+
+// renderTOML returns the file's sections formatted as TOML, for use by Write.
+// The returned bool is false if there is nothing to write. The caller must
+// hold a lock on f.mu.
+func (f *File) renderTOML() (string, bool) {
+	var lines []string
+	for _, section := range f.sections {
+		values := section.renderValues(func(name, value string, isBool bool) string {
+			if isBool {
+				return fmt.Sprintf("%s = %t", name, BoolValue(value))
+			}
+			return fmt.Sprintf("%s = %s", name, jsonString(unquote(value)))
+		})
+		if section.Name != "" {
+			if len(lines) > 0 {
+				lines = append(lines, "")
+			}
+			lines = append(lines, "["+tomlKey(section.Name)+"]")
+		}
+		lines = append(lines, values...)
+	}
+	if len(lines) == 0 {
+		return "", false
+	}
+	return strings.Join(lines, "\n") + "\n", true
+}
+
+// tomlKey returns name as a TOML key, quoting it if it is not a valid bare
+// key.
+func tomlKey(name string) string {
+	if key, rest, err := parseTOMLKey(name); err == nil && key == name && rest == "" {
+		return name
+	}
+	return jsonString(name)
+}
+
+// renderValues returns the result of calling render on each of the section's
+// values, in order by option name.
+func (section *Section) renderValues(render func(name, value string, isBool bool) string) []string {
+	names := section.OptionNames()
+	result := make([]string, len(names))
+	for n, name := range names {
+		opt := section.opts[name]
+		result[n] = render(name, section.storedValue(name), opt != nil && opt.Type == OptionTypeBool)
+	}
+	return result
+}
+
+// jsonString returns s as a JSON string, without escaping HTML characters.
+func jsonString(s string) string {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s) // cannot fail for a string
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// yamlString returns s as a YAML scalar, quoting it if a plain scalar would
+// be interpreted differently.
+func yamlString(s string) string {
+	var needsQuote bool
+	switch strings.ToLower(s) {
+	case "", "~", "null", "true", "false", "yes", "no", "on", "off":
+		needsQuote = true
+	default:
+		needsQuote = s != strings.TrimSpace(s) || strings.ContainsAny(s, ":#'\"\\\b\t\n\r") || strings.ContainsRune("-?,[]{}&*!|>%@`", rune(s[0]))
+	}
+	if needsQuote {
+		return strconv.Quote(s)
+	}
+	return s
+}
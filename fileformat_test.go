@@ -0,0 +1,171 @@
+package mybase
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseStructuredFormats(t *testing.T) {
+	cfg := simpleConfig(map[string]string{"foo": "", "bar": "", "empty": ""})
+	cfg.CLI.Command.AddOption(BoolOption("flag", 0, false, "dummy description"))
+	cfg.CLI.Command.AddOption(StringOption("baz", 0, "", "dummy description").ValueOptional())
+
+	jsonContents := `{
+  "foo": "hello # not a comment",
+  "flag": true,
+  "bar": 123,
+  "prod": {
+    "foo": "it's \"quoted\"",
+    "baz": null,
+    "flag": false
+  },
+  "empty": ""
+}
+`
+	yamlContents := `---
+# comment
+foo: hello # not a comment
+flag: true
+bar: 123
+prod:
+  foo: 'it''s "quoted"'
+  baz: ~
+
+  flag: false # comment
+empty: ""
+`
+	tomlContents := `# comment
+foo = "hello # not a comment"
+flag = true
+bar = 1_23
+empty = ''
+
+[prod]
+foo = "it's \"quoted\""
+baz = ""
+  flag = false # comment
+`
+	for format, contents := range map[FileFormat]string{FileFormatJSON: jsonContents, FileFormatYAML: yamlContents, FileFormatTOML: tomlContents} {
+		file := NewFile("/tmp/fake.conf")
+		file.Syntax = format
+		file.contents = contents
+		file.read = true
+		if err := file.Parse(cfg); err != nil {
+			t.Errorf("Unexpected error parsing format %d: %v", format, err)
+			continue
+		}
+		expectedDefault := map[string]string{"flag": "true", "bar": "123", "empty": "''"}
+		if format == FileFormatJSON || format == FileFormatTOML {
+			expectedDefault["foo"] = `"hello # not a comment"`
+		} else {
+			expectedDefault["foo"] = "hello" // YAML treats " #" as start of comment
+		}
+		if values := file.SectionValues(""); !reflect.DeepEqual(values, expectedDefault) {
+			t.Errorf("Format %d: unexpected values in default section: %v", format, values)
+		}
+		file.UseSection("prod")
+		cfg.sources = []OptionValuer{file}
+		cfg.MarkDirty()
+		if foo := cfg.Get("foo"); foo != `it's "quoted"` {
+			t.Errorf("Format %d: unexpected value for foo: %q", format, foo)
+		}
+		if cfg.GetBool("flag") || cfg.Get("bar") != "123" || !cfg.Supplied("baz") {
+			t.Errorf("Format %d: unexpected values: flag=%t bar=%q supplied(baz)=%t", format, cfg.GetBool("flag"), cfg.Get("bar"), cfg.Supplied("baz"))
+		}
+	}
+
+	// Confirm extension-based detection, and errors with line numbers
+	cases := []struct {
+		name     string
+		contents string
+		line     int
+	}{
+		{"a.json", "[1, 2]", 1},
+		{"b.json", "{\n\"foo\": \"a\",\n\"bar\": [1]\n}", 3},
+		{"c.json", "{\n\"foo\": \"a\",\n\"unknown\": 1}", 3},
+		{"d.json", "{\"foo\": \"a\"} {}", 1},
+		{"e.json", "{\n\"foo\": \"a\",\n", 2},
+		{"f.yaml", "foo: a\n  bar: b\n", 2},
+		{"g.yml", "foo: a\nsection:\n  nested:\n    bar: b\n", 3},
+		{"h.yaml", "foo: a\n- bar\n", 2},
+		{"i.yaml", "foo: \"unterminated\n", 1},
+		{"j.yaml", "foo: a\nbar: &anchor b\n", 2},
+		{"k.yaml", "foo: a\nbad key: b\n", 2},
+		{"l.toml", "foo = 'a'\n[prod.us]\n", 2},
+		{"m.toml", "foo = 'a'\n[[prod]]\n", 2},
+		{"n.toml", "foo = 'a'\nbar.baz = 1\n", 2},
+		{"o.toml", "foo = 'a'\nbar = \"\"\"multi\n", 2},
+		{"p.toml", "foo = 'a'\nbar = [1,\n2]\n", 2},
+		{"q.toml", "foo = 'a'\nbar = {x = 1}\n", 2},
+		{"r.toml", "foo = 'a'\nbar = 'unterminated\n", 2},
+		{"s.toml", "foo = 'a'\nbar\n", 2},
+		{"t.toml", "foo = 'a'\n[prod\n", 2},
+	}
+	for _, c := range cases {
+		file := NewFile("/tmp", c.name)
+		file.contents = c.contents
+		file.read = true
+		err := file.Parse(cfg)
+		var pe ParseError
+		if err == nil {
+			t.Errorf("Expected error parsing %s, but err is nil", c.name)
+		} else if !errors.As(err, &pe) {
+			t.Errorf("Expected error parsing %s to be a ParseError, instead found %T: %v", c.name, err, err)
+		} else if pe.Line() != c.line {
+			t.Errorf("Expected error parsing %s to be on line %d, instead found line %d: %v", c.name, c.line, pe.Line(), err)
+		}
+	}
+}
+
+func TestWriteStructuredFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mybasetest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := simpleConfig(map[string]string{"foo": "", "bar": ""})
+	cfg.CLI.Command.AddOption(BoolOption("flag", 0, false, "dummy description"))
+	expectedContents := map[string]string{
+		"out.json": "{\n  \"bar\": \"a: b\",\n  \"flag\": true,\n  \"foo\": \"<x>\",\n  \"prod\": {\n    \"foo\": \"it's\"\n  }\n}\n",
+		"out.yaml": "bar: \"a: b\"\nflag: true\nfoo: <x>\nprod:\n  foo: \"it's\"\n",
+		"out.toml": "bar = \"a: b\"\nflag = true\nfoo = \"<x>\"\n\n[prod]\nfoo = \"it's\"\n",
+	}
+	for name, expected := range expectedContents {
+		file := NewFile(dir, name)
+		file.SetOptionValue("", "foo", "<x>")
+		file.SetOptionValue("", "bar", "a: b")
+		file.SetOptionValue("", "flag", "1")
+		file.SetOptionValue("prod", "foo", "it's")
+		file.sections[0].opts["flag"] = cfg.FindOption("flag")
+		if err := file.Write(false); err != nil {
+			t.Fatalf("Unexpected error writing %s: %v", name, err)
+		}
+		contents, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("Unexpected error reading %s: %v", name, err)
+		} else if string(contents) != expected {
+			t.Errorf("Unexpected contents of %s:\n%s", name, contents)
+		}
+
+		// Confirm round-trip
+		reread := NewFile(dir, name)
+		if err := reread.Parse(cfg); err != nil {
+			t.Fatalf("Unexpected error parsing %s: %v", name, err)
+		}
+		reread.UseSection("prod")
+		if value, _ := reread.OptionValue("foo"); unquote(value) != "it's" {
+			t.Errorf("Unexpected value after round-trip of %s: %q", name, value)
+		}
+		if value, _ := reread.OptionValue("bar"); unquote(value) != "a: b" {
+			t.Errorf("Unexpected value after round-trip of %s: %q", name, value)
+		}
+		if _, err := reread.Lint(cfg); err == nil {
+			t.Errorf("Expected Lint of %s to return an error, but it did not", name)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+//go:build go1.16
+// +build go1.16
+
+package mybase
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// NewFileFS returns a value representing an option file at name within fsys,
+// such as an embed.FS, a zip archive opened via archive/zip, or a
+// testing/fstest.MapFS. The name must be a valid fs.FS path, i.e. slash-
+// separated and unrooted, such as "config/my.cnf". Include directives within
+// the file are also resolved within fsys, relative to the including file.
+//
+// The returned File may be read and parsed like any other File, but Write
+// returns an error, ResolveSymlinks has no effect, and no warning is reported
+// for insecure permissions. Panics if name is not a valid fs.FS path, since
+// this is indicative of programmer error.
+func NewFileFS(fsys fs.FS, name string) *File {
+	if !fs.ValidPath(name) {
+		panic(&fs.PathError{Op: "NewFileFS", Path: name, Err: fs.ErrInvalid})
+	}
+	f := NewFile(name)
+	f.Dir, f.Name = filepath.FromSlash(path.Dir(name)), path.Base(name)
+	f.fsys = ioFS{fsys}
+	return f
+}
+
+// ioFS adapts an fs.FS to the fileSystem interface. Paths supplied by File are
+// in OS-specific form, so they are converted to slash-separated form.
+type ioFS struct {
+	fsys fs.FS
+}
+
+func (iofs ioFS) Stat(name string) (os.FileInfo, error) {
+	return fs.Stat(iofs.fsys, filepath.ToSlash(name))
+}
+
+func (iofs ioFS) Open(name string) (io.ReadCloser, error) {
+	return iofs.fsys.Open(filepath.ToSlash(name))
+}
+
+func (iofs ioFS) ReadDir(name string) ([]os.FileInfo, error) {
+	entries, err := fs.ReadDir(iofs.fsys, filepath.ToSlash(name))
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
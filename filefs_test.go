@@ -0,0 +1,58 @@
+//go:build go1.16
+// +build go1.16
+
+package mybase
+
+import (
+	"os"
+	"testing"
+	"testing/fstest"
+)
+
+func TestNewFileFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"conf/my.cnf":         {Data: []byte("visible=hello\n!include extra/one.cnf\n!includedir extra\n[mysection]\nhidden=secret\n")},
+		"conf/extra/one.cnf":  {Data: []byte("hasshort=one\n")},
+		"conf/extra/two.cnf":  {Data: []byte("bool1\n")},
+		"conf/extra/skip.txt": {Data: []byte("bogus=1\n")},
+		"conf/big.cnf":        {Data: []byte("visible=" + string(make([]byte, 200)) + "\n")},
+	}
+	cfg := ParseFakeCLI(t, simpleCommand(), "mycommand arg1")
+
+	f := NewFileFS(fsys, "conf/my.cnf")
+	if !f.Exists() {
+		t.Fatal("Expected file to exist in fsys")
+	}
+	if err := f.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error from Parse: %v", err)
+	}
+	if err := f.UseSection("mysection"); err != nil {
+		t.Fatalf("Unexpected error from UseSection: %v", err)
+	}
+	for name, expected := range map[string]string{"visible": "hello", "hasshort": "one", "bool1": "1", "hidden": "secret"} {
+		if actual, _ := f.OptionValue(name); actual != expected {
+			t.Errorf("Expected %s=%q, instead found %q", name, expected, actual)
+		}
+	}
+	if err := f.Write(true); err == nil {
+		t.Error("Expected Write to fail for file from fs.FS, but err is nil")
+	}
+
+	// Limits apply as usual; missing files behave as on disk
+	f = NewFileFS(fsys, "conf/big.cnf")
+	f.MaxSize = 100
+	if _, ok := f.Parse(cfg).(FileTooLargeError); !ok {
+		t.Error("Expected FileTooLargeError from Parse")
+	}
+	f = NewFileFS(fsys, "conf/missing.cnf")
+	if err := f.Read(); f.Exists() || !os.IsNotExist(err) {
+		t.Errorf("Expected nonexistent file, instead found err=%v", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected NewFileFS to panic for invalid path, but it did not")
+		}
+	}()
+	NewFileFS(fsys, "/conf/my.cnf")
+}
@@ -0,0 +1,160 @@
+package mybase
+
+import (
+	"errors"
+	"fmt"
+)
+
+// FileTx stages a batch of changes to a File, for use with File.Edit. Its
+// methods correspond to those of File, but rather than taking effect
+// immediately, each change is recorded and only applied once the function
+// passed to Edit returns successfully.
+type FileTx struct {
+	ops  []func(f *File) error
+	done bool
+}
+
+// SetOptionValue stages setting an option value in the named section, in the
+// same manner as File.SetOptionValue.
+func (tx *FileTx) SetOptionValue(sectionName, optionName, value string) {
+	tx.stage(func(f *File) error {
+		f.getOrCreateSection(sectionName).Values[optionName] = value
+		f.markEdited(sectionName, optionName)
+		return nil
+	})
+}
+
+// DeleteOption stages removing an option value from the named section, in the
+// same manner as File.DeleteOption. It is not an error if the section or value
+// does not exist.
+func (tx *FileTx) DeleteOption(sectionName, optionName string) {
+	tx.stage(func(f *File) error {
+		f.deleteOption(sectionName, optionName)
+		return nil
+	})
+}
+
+// DeleteSection stages removing the named section and all of its values, in
+// the same manner as File.DeleteSection. It is not an error if the section
+// does not exist.
+func (tx *FileTx) DeleteSection(name string) {
+	tx.stage(func(f *File) error {
+		f.deleteSection(name)
+		return nil
+	})
+}
+
+// RenameSection stages changing the name of a section, in the same manner as
+// File.RenameSection. If the section cannot be renamed, Edit returns an error
+// and none of the staged changes are applied.
+func (tx *FileTx) RenameSection(oldName, newName string) {
+	tx.stage(func(f *File) error {
+		return f.renameSection(oldName, newName)
+	})
+}
+
+// stage records op for later application by File.Edit. Panics if called after
+// Edit has returned, since this is indicative of programmer error.
+func (tx *FileTx) stage(op func(f *File) error) {
+	if tx.done {
+		panic(errors.New("FileTx used after File.Edit returned"))
+	}
+	tx.ops = append(tx.ops, op)
+}
+
+// Edit makes a batch of changes to f, and then writes f to disk, as a single
+// all-or-nothing operation. The supplied function stages changes via the
+// methods of tx. If it returns an error, none of the changes are applied, and
+// Edit returns that error. Otherwise, the changes are applied in the order they
+// were staged, and f is written atomically (as if f.AtomicWrite were true),
+// overwriting any existing file. If any change fails, or if the write fails,
+// f is restored to its prior state, and the file on disk is left unchanged.
+// Edit also returns an error without writing if the changes would leave f with
+// nothing to write, rather than leaving the file on disk out of sync with f.
+//
+// Any changes made to f via other means prior to calling Edit are written
+// along with the staged changes. The function must not call any methods of f,
+// since f is locked while the changes are applied and written; any Config
+// using f as a source reflects either all of the changes or none of them.
+// Files returned by NewFileFS cannot be edited, and an error is returned.
+func (f *File) Edit(fn func(tx *FileTx) error) error {
+	if f.fsys != nil {
+		return fmt.Errorf("Cannot write %s: file is from a read-only filesystem", f.Path())
+	}
+	tx := &FileTx{}
+	err := fn(tx)
+	tx.done = true
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.unlock()
+	f.assertUnsealed("Edit")
+	if err := f.loadPending(nil); err != nil {
+		return err
+	}
+	snap := f.snapshot()
+	for _, op := range tx.ops {
+		if err := op(f); err != nil {
+			f.restore(snap)
+			return err
+		}
+	}
+	contents, ok := f.renderContents()
+	if !ok {
+		f.restore(snap)
+		return fmt.Errorf("Cannot write %s: edits would leave an empty configuration", f.Path())
+	}
+	perm, explicitPerm := f.permissions()
+	if err := f.writeContents(contents, true, true, perm, explicitPerm); err != nil {
+		f.restore(snap)
+		return err
+	}
+	f.commitContents(contents)
+	return nil
+}
+
+// fileSnapshot is a copy of the mutable state of a File, permitting File.Edit
+// to discard partially-applied changes.
+type fileSnapshot struct {
+	sections []*Section
+	edited   map[string]map[string]bool
+	renamed  map[string]string
+}
+
+// snapshot returns a copy of f's sections and edit-tracking state. The caller
+// must hold a lock on f.mu.
+func (f *File) snapshot() fileSnapshot {
+	snap := fileSnapshot{
+		sections: make([]*Section, len(f.sections)),
+		edited:   make(map[string]map[string]bool, len(f.edited)),
+		renamed:  make(map[string]string, len(f.renamed)),
+	}
+	for n, section := range f.sections {
+		snap.sections[n] = section.clone()
+	}
+	for sectionName, names := range f.edited {
+		snap.edited[sectionName] = make(map[string]bool, len(names))
+		for name := range names {
+			snap.edited[sectionName][name] = true
+		}
+	}
+	for from, to := range f.renamed {
+		snap.renamed[from] = to
+	}
+	return snap
+}
+
+// restore reverts f's sections and edit-tracking state to those of snap. The
+// caller must hold a write lock on f.mu.
+func (f *File) restore(snap fileSnapshot) {
+	f.sections = snap.sections
+	f.sectionIndex = make(map[string]*Section, len(snap.sections))
+	for _, section := range snap.sections {
+		f.sectionIndex[section.Name] = section
+	}
+	f.edited = snap.edited
+	f.renamed = snap.renamed
+	f.bumpGeneration()
+}
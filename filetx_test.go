@@ -0,0 +1,123 @@
+package mybase
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileEdit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mybasetest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	contents := "visible=1\n\n[foo] # foo comment\nhasshort=x\n\n[bar]\n# bar comment\nbool1\n\n[baz]\nhidden=z\n"
+	path := filepath.Join(dir, "test.cnf")
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("Unable to write file: %v", err)
+	}
+	cfg := ParseFakeCLI(t, simpleCommand(), "mycommand arg1")
+	f := NewFile(path)
+	f.PreserveFormatting = true
+	if err := f.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error from Parse: %v", err)
+	}
+	assertUnchanged := func() {
+		t.Helper()
+		if actual, _ := ioutil.ReadFile(path); string(actual) != contents {
+			t.Errorf("Unexpected file contents after failed Edit: %q", actual)
+		}
+		if !f.HasSection("foo") || !f.HasSection("baz") || f.HasSection("renamed") || f.SectionValues("")["visible"] != "1" {
+			t.Errorf("Expected failed Edit to leave f unchanged, but it did not; sections=%v", f.SectionsLike("*"))
+		}
+	}
+
+	// An error from the function prevents any changes
+	expectErr := errors.New("abort")
+	err = f.Edit(func(tx *FileTx) error {
+		tx.SetOptionValue("", "visible", "2")
+		tx.DeleteSection("foo")
+		return expectErr
+	})
+	if err != expectErr {
+		t.Errorf("Expected Edit to return function's error, instead found %v", err)
+	}
+	assertUnchanged()
+
+	// A failing change discards all earlier changes
+	err = f.Edit(func(tx *FileTx) error {
+		tx.SetOptionValue("", "visible", "2")
+		tx.DeleteSection("baz")
+		tx.RenameSection("foo", "renamed")
+		tx.RenameSection("bar", "renamed")
+		return nil
+	})
+	if err == nil {
+		t.Error("Expected Edit to return an error for renaming to an existing section, but it did not")
+	}
+	assertUnchanged()
+
+	// Successful edit
+	var leaked *FileTx
+	err = f.Edit(func(tx *FileTx) error {
+		leaked = tx
+		tx.SetOptionValue("", "visible", "2")
+		tx.DeleteOption("foo", "hasshort")
+		tx.SetOptionValue("foo", "hidden", "new")
+		tx.RenameSection("foo", "renamed")
+		tx.RenameSection("bar", "foo")
+		tx.DeleteSection("baz")
+		tx.DeleteOption("nonexistent", "visible")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error from Edit: %v", err)
+	}
+	expected := "visible=2\n\n[renamed] # foo comment\nhidden=new\n\n[foo]\n# bar comment\nbool1\n\n"
+	if actual, _ := ioutil.ReadFile(path); string(actual) != expected {
+		t.Errorf("Unexpected file contents after Edit:\n%q\nexpected:\n%q", actual, expected)
+	}
+	if value := f.SectionValues("renamed")["hidden"]; value != "new" {
+		t.Errorf("Expected renamed section to have hidden=new, instead found %q", value)
+	}
+	reparsed := NewFile(path)
+	if err := reparsed.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error from Parse: %v", err)
+	}
+	if !reparsed.SameContents(f) {
+		t.Error("Expected re-parsed file to have same contents as edited file, but it does not")
+	}
+
+	// Using a FileTx after Edit returns should panic
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected FileTx use after Edit to panic, but it did not")
+		}
+	}()
+	leaked.DeleteSection("foo")
+}
+
+func TestFileRenameSection(t *testing.T) {
+	cfg := ParseFakeCLI(t, simpleCommand(), "mycommand arg1")
+	f, err := getParsedFile(cfg, false, "visible=1\n[a]\nhidden=a\n[b]\nhidden=b\n")
+	if err != nil {
+		t.Fatalf("Unexpected error from getParsedFile: %v", err)
+	}
+	for _, names := range [][2]string{{"", "c"}, {"a", ""}, {"nonexistent", "c"}, {"a", "b"}} {
+		if err := f.RenameSection(names[0], names[1]); err == nil {
+			t.Errorf("Expected error renaming [%s] to [%s], but err was nil", names[0], names[1])
+		}
+	}
+	if err := f.RenameSection("a", "c"); err != nil {
+		t.Fatalf("Unexpected error from RenameSection: %v", err)
+	}
+	if err := f.RenameSection("b", "a"); err != nil {
+		t.Fatalf("Unexpected error from RenameSection: %v", err)
+	}
+	if f.SectionValues("a")["hidden"] != "b" || f.SectionValues("c")["hidden"] != "a" || f.HasSection("b") {
+		t.Errorf("Unexpected section values after RenameSection: a=%v c=%v", f.SectionValues("a"), f.SectionValues("c"))
+	}
+}
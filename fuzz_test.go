@@ -0,0 +1,75 @@
+//go:build go1.18
+// +build go1.18
+
+package mybase
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// FuzzFileParse confirms that File.ParseAll never panics, regardless of input,
+// and that successfully-parsed values never contain NUL bytes or invalid
+// UTF-8. Additional seed inputs are in testdata/fuzz/FuzzFileParse; any
+// crashers found by fuzzing should be added there as regression fixtures.
+func FuzzFileParse(f *testing.F) {
+	seeds := []string{
+		"",
+		"[",
+		"]",
+		"[]",
+		"!",
+		"!inherit",
+		"=",
+		"==",
+		"#",
+		"\\",
+		"visible=\"",
+		"visible=`x\\",
+		"hasshort\n[foo]\n!inherit foo\n",
+		"[a]\n!inherit b\n[b]\n!inherit a\n",
+		"bool1=1\nskip-bool1\nloose-nonexistent=1\n",
+		"visible = 'hello # world' # comment\r\n",
+		"visible=\x00\n",
+		"visible=\xff\xfe\n",
+		"\xef\xbb\xbfvisible=1\n",
+	}
+	for _, seed := range seeds {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		cfg := simpleConfig(nil)
+		for _, policy := range []InvalidUTF8Policy{InvalidUTF8Reject, InvalidUTF8Replace} {
+			file := NewFile("fuzz.cnf")
+			file.InvalidUTF8 = policy
+			file.contents, file.read = string(data), true
+			err := file.ParseAll(cfg)
+			var bce BinaryContentError
+			if strings.IndexByte(string(data), 0) > -1 {
+				if !errors.As(err, &bce) {
+					t.Fatalf("Expected BinaryContentError for input %q, instead found %v", data, err)
+				}
+				continue
+			} else if errors.As(err, &bce) {
+				t.Fatalf("Unexpected BinaryContentError for input %q", data)
+			}
+			if err != nil {
+				continue
+			}
+			for _, section := range file.sections {
+				if !utf8.ValidString(section.Name) {
+					t.Fatalf("Invalid UTF-8 in parsed section name %q for input %q", section.Name, data)
+				}
+				for name, value := range section.Values {
+					if !utf8.ValidString(name) || !utf8.ValidString(value) {
+						t.Fatalf("Invalid UTF-8 in parsed option %q=%q for input %q", name, value, data)
+					}
+				}
+			}
+			file.OptionValue("visible")
+			file.SectionValues("")
+		}
+	})
+}
@@ -0,0 +1,560 @@
+package mybase
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+
+	"github.com/mitchellh/go-wordwrap"
+	terminal "golang.org/x/term"
+)
+
+// minTerminalWidth is the narrowest line length that help output will be
+// wrapped to, regardless of the detected terminal width.
+const minTerminalWidth = 40
+
+// DefaultHelpTemplate is the text of the template used for help output, unless
+// a different one is supplied via Command.SetHelpTemplate or
+// SetDefaultHelpTemplate. Applications may use this as a starting point for a
+// custom template. The template is executed with a *HelpData value.
+const DefaultHelpTemplate = `
+{{.Heading "Usage:"}}  {{.Invocation}}
+
+{{.Description}}
+{{if .Args}}
+{{.Heading "Arguments:"}}
+{{range .Args}}{{.Line}}{{end}}{{end}}{{range .Categories}}
+{{$.Heading (printf "%s:" .Title)}}
+{{range .Commands}}{{.Line}}{{end}}{{end}}{{range .OptionGroups}}
+{{$.Heading (printf "%s:" .Title)}}
+{{range .Options}}{{.Line}}{{end}}{{end}}{{if .LongHelp}}
+{{.Heading "Details:"}}
+{{.LongHelp}}
+{{end}}{{if .Examples}}
+{{.Heading "Examples:"}}
+{{range .Examples}}{{.Line}}{{end}}{{end}}{{if .WebDocText}}
+{{.WebDocText}}
+
+{{end}}`
+
+var defaultHelpTemplate = template.Must(ParseHelpTemplate(DefaultHelpTemplate))
+
+var (
+	helpTemplateMu      sync.RWMutex
+	packageHelpTemplate = defaultHelpTemplate
+)
+
+// SetDefaultHelpTemplate changes the template used for rendering usage
+// instructions of every Command which lacks a template supplied via
+// Command.SetHelpTemplate, either directly or by an ancestor command. This
+// permits a downstream tool to restyle all of its help output in one place.
+// Supplying nil restores the template parsed from DefaultHelpTemplate.
+func SetDefaultHelpTemplate(tmpl *template.Template) {
+	if tmpl == nil {
+		tmpl = defaultHelpTemplate
+	}
+	helpTemplateMu.Lock()
+	defer helpTemplateMu.Unlock()
+	packageHelpTemplate = tmpl
+}
+
+// currentHelpTemplate returns the template set via SetDefaultHelpTemplate, or
+// the template parsed from DefaultHelpTemplate.
+func currentHelpTemplate() *template.Template {
+	helpTemplateMu.RLock()
+	defer helpTemplateMu.RUnlock()
+	return packageHelpTemplate
+}
+
+// HelpTemplateFuncs returns functions which are useful in help templates, for
+// use with template.Funcs. ParseHelpTemplate includes these automatically.
+//
+//   - wrap WIDTH TEXT: word-wraps TEXT to lines of at most WIDTH characters
+//   - indent N TEXT: prefixes each non-empty line of TEXT with N spaces
+//   - pad WIDTH TEXT: appends spaces to TEXT to reach WIDTH characters
+//   - join SEP LIST: joins a list of strings with SEP, such as option aliases
+//
+// The argument order permits use in pipelines, for example
+// {{.Description | wrap 60 | indent 4}}.
+func HelpTemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"wrap": func(width int, text string) string {
+			return wordwrap.WrapString(text, uint(width))
+		},
+		"indent": func(n int, text string) string {
+			prefix := strings.Repeat(" ", n)
+			lines := strings.Split(text, "\n")
+			for i, line := range lines {
+				if line != "" {
+					lines[i] = prefix + line
+				}
+			}
+			return strings.Join(lines, "\n")
+		},
+		"pad": func(width int, text string) string {
+			if len(text) >= width {
+				return text
+			}
+			return text + strings.Repeat(" ", width-len(text))
+		},
+		"join": func(sep string, list []string) string {
+			return strings.Join(list, sep)
+		},
+	}
+}
+
+// ParseHelpTemplate parses text as a help template, suitable for supplying to
+// Command.SetHelpTemplate or SetDefaultHelpTemplate, with the functions of
+// HelpTemplateFuncs available.
+func ParseHelpTemplate(text string) (*template.Template, error) {
+	return template.New("help").Funcs(HelpTemplateFuncs()).Parse(text)
+}
+
+// ColorScheme controls the ANSI formatting of help output and error messages,
+// when written to a terminal. Each field is a sequence of SGR parameters
+// separated by semicolons, for example "1" for bold, "36" for cyan, or "1;31"
+// for bold red. An empty field leaves that element unformatted.
+type ColorScheme struct {
+	Heading     string // Section headings, such as "Usage:" and "Options:"
+	CommandName string // Command name in the usage synopsis, and subcommand names in command listings
+	OptionFlag  string // Option flags, such as "-s, --foo value"
+	Default     string // Descriptions of option default values, such as (default "foo")
+	Error       string // Error messages displayed by HandleCommandError
+}
+
+// DefaultColorScheme is the ColorScheme used unless a different one is
+// supplied via Command.SetColorScheme. It formats headings in bold, and leaves
+// all other text unformatted.
+var DefaultColorScheme = ColorScheme{Heading: "1"}
+
+// SetColorScheme supplies the formatting used for help output and error
+// messages of cmd and any of its subcommands which lack their own scheme.
+// Formatting is only applied when output is written to a terminal, unless the
+// NO_COLOR environment variable is set or TERM is "dumb". Supplying nil
+// reverts to the parent command's scheme, or DefaultColorScheme if there is no
+// parent.
+func (cmd *Command) SetColorScheme(scheme *ColorScheme) {
+	if scheme != nil {
+		copied := *scheme
+		scheme = &copied
+	}
+	cmd.colorScheme = scheme
+}
+
+// colors returns the ColorScheme of cmd, or of its closest ancestor with one,
+// or DefaultColorScheme.
+func (cmd *Command) colors() ColorScheme {
+	for current := cmd; current != nil; current = current.ParentCommand {
+		if current.colorScheme != nil {
+			return *current.colorScheme
+		}
+	}
+	return DefaultColorScheme
+}
+
+// ansiFormat returns text wrapped in ANSI escape sequences applying style, a
+// field of a ColorScheme. If style or text is empty, text is returned as-is.
+func ansiFormat(style, text string) string {
+	if style == "" || text == "" {
+		return text
+	}
+	return "\x1b[" + style + "m" + text + "\x1b[0m"
+}
+
+// HelpData contains the information supplied to a help template when
+// rendering usage instructions for a Command.
+type HelpData struct {
+	Command         *Command          // Command being described, for access to metadata not otherwise present in HelpData
+	Name            string            // Command name, prefixed by the names of any parent commands
+	Summary         string            // Short description text, or version if this is a top-level command
+	Description     string            // Long description text, word-wrapped to Width
+	Invocation      string            // Synopsis of invoking the command, including its args
+	ArgSynopsis     string            // Synopsis of only the positional args, or "<command>" for a command suite
+	Args            []HelpArg         // Positional args in order, only populated if at least one has a description
+	SubCommands     []HelpCommand     // Subcommands in the order listed by help output, if any; see Command.SetSubCommandOrder
+	SubCommandWidth int               // Length of the longest subcommand name
+	Categories      []HelpCategory    // Subcommands grouped by category, in the order listed by help output; see Command.SetCategory
+	OptionGroups    []HelpOptionGroup // Groups of options, in the same order as Command.OptionGroups, except that options of the unnamed group inherited from a parent command are in a separate "Inherited Options" group; hidden options are only included for --help-all
+	LongHelp        string            // Extended help text set via Command.SetLongHelp, word-wrapped to Width; empty if none
+	Examples        []HelpExample     // Sample invocations added via Command.AddExample, in the order added
+	WebDocText      string            // Text referring to online documentation, word-wrapped to Width; empty if none
+	Width           int               // Line length used for word-wrapping
+	Color           bool              // True if output should be formatted using ANSI escape sequences, in which case Invocation and Line fields are already formatted
+	Colors          ColorScheme       // Formatting used if Color is true; see Command.SetColorScheme
+}
+
+// Heading returns text formatted for use as a heading. If Color is true, the
+// text is formatted using the Heading style of Colors, which is bold by
+// default; otherwise it is returned as-is.
+func (data *HelpData) Heading(text string) string {
+	return data.Format(data.Colors.Heading, text)
+}
+
+// Format returns text formatted using style, which is typically a field of
+// Colors, for example {{.Format .Colors.OptionFlag "--foo"}} in a template. If
+// Color is false, text is returned as-is.
+func (data *HelpData) Format(style, text string) string {
+	if !data.Color {
+		return text
+	}
+	return ansiFormat(style, text)
+}
+
+// HelpArg describes a positional arg in HelpData.
+type HelpArg struct {
+	Arg         *Option // Underlying definition of the arg
+	Name        string
+	Description string // Description text, not word-wrapped
+	Required    bool
+	Variadic    bool   // True if the arg consumes all remaining positional values
+	Line        string // Full line in the default layout, aligned and word-wrapped, including trailing newline
+}
+
+// HelpExample describes a sample invocation in HelpData.
+type HelpExample struct {
+	CommandLine string
+	Description string // Description text, not word-wrapped
+	Line        string // Full entry in the default layout, with the description word-wrapped on indented lines below the command-line, including trailing newline
+}
+
+// HelpCommand describes a subcommand in HelpData.
+type HelpCommand struct {
+	Command  *Command // Underlying subcommand
+	Name     string
+	Summary  string
+	Category string // Category set via Command.SetCategory, or empty string if none
+	Line     string // Full line in the default layout, aligned, including trailing newline
+}
+
+// HelpCategory describes a category of subcommands in HelpData. If no
+// subcommands have a category, there is a single HelpCategory with
+// title "Commands".
+type HelpCategory struct {
+	Title    string // Heading for the category, for example "Common commands"
+	Commands []HelpCommand
+}
+
+// HelpOptionGroup describes a group of related options in HelpData.
+type HelpOptionGroup struct {
+	Title   string // Heading for the group, for example "Global Options"
+	Options []HelpOption
+}
+
+// HelpOption describes an option in HelpData.
+type HelpOption struct {
+	Option      *Option  // Underlying option; its Default reflects any override via Command.SetDefaults
+	Name        string   // Canonical long name of the option
+	Shorthand   string   // Single-character short name, or empty string if none
+	Aliases     []string // Alternative long names
+	UsageName   string   // Name(s) annotated for display, for example "[skip-]foo" or "foo value"
+	Description string   // Description text, not word-wrapped
+	Default     string   // Human-readable description of the default, for example ` (default "foo")`; empty if none
+	Deprecation string   // Human-readable deprecation notice, for example ` [DEPRECATED: use option bar instead]`; empty if none
+	Inherited   bool     // True if the option was added to an ancestor command, rather than this command
+	Line        string   // Full line in the default layout, aligned and word-wrapped, including trailing newline
+}
+
+// SetHelpTemplate supplies a custom template for rendering usage instructions
+// for cmd and any of its subcommands which lack their own custom template. The
+// template is executed with a *HelpData value. Supplying nil reverts to the
+// parent command's template, or the template supplied to
+// SetDefaultHelpTemplate if there is no parent.
+func (cmd *Command) SetHelpTemplate(tmpl *template.Template) {
+	cmd.helpTemplate = tmpl
+}
+
+// WriteUsage renders usage instructions for cmd to w, using the template
+// supplied to SetHelpTemplate or SetDefaultHelpTemplate. Headings are formatted
+// using ANSI escape sequences if w is a terminal, unless the NO_COLOR
+// environment variable is set or TERM is "dumb".
+func (cmd *Command) WriteUsage(w io.Writer) error {
+	return cmd.writeUsage(w, false)
+}
+
+// WriteUsageAll is like WriteUsage, but also includes hidden options. This is
+// used for the --help-all flag.
+func (cmd *Command) WriteUsageAll(w io.Writer) error {
+	return cmd.writeUsage(w, true)
+}
+
+func (cmd *Command) writeUsage(w io.Writer, showHidden bool) error {
+	return cmd.renderUsage(w, showHidden, colorEnabled(w))
+}
+
+// renderUsage writes usage instructions for cmd to w, optionally including
+// hidden options and ANSI formatting of headings.
+func (cmd *Command) renderUsage(w io.Writer, showHidden, color bool) error {
+	tmpl := currentHelpTemplate()
+	for current := cmd; current != nil; current = current.ParentCommand {
+		if current.helpTemplate != nil {
+			tmpl = current.helpTemplate
+			break
+		}
+	}
+	return tmpl.Execute(w, cmd.helpData(showHidden, color))
+}
+
+// HelpData returns the information used to render usage instructions for cmd,
+// without ANSI formatting.
+func (cmd *Command) HelpData() *HelpData {
+	return cmd.helpData(false, false)
+}
+
+// helpData implements HelpData. If showHidden is true, hidden options are
+// included as well. If color is true, ANSI formatting is applied using cmd's
+// ColorScheme.
+func (cmd *Command) helpData(showHidden, color bool) *HelpData {
+	lineLen := terminalWidth()
+	if lineLen == 0 {
+		lineLen = 80
+	} else if lineLen > 180 {
+		lineLen = 160
+	} else if lineLen > 120 {
+		lineLen -= 20
+	}
+
+	data := &HelpData{
+		Command:     cmd,
+		Name:        cmd.fullName(),
+		Summary:     cmd.Summary,
+		Description: wordwrap.WrapString(cmd.Description, uint(lineLen)),
+		LongHelp:    wordwrap.WrapString(cmd.longHelp, uint(lineLen)),
+		Invocation:  cmd.Invocation(),
+		ArgSynopsis: strings.TrimSpace(cmd.argUsage()),
+		Width:       lineLen,
+		Color:       color,
+		Colors:      cmd.colors(),
+	}
+	if color {
+		name := cmd.fullName()
+		data.Invocation = data.Format(data.Colors.CommandName, name) + strings.TrimPrefix(data.Invocation, name)
+	}
+
+	categories, members := cmd.subCommandCategories()
+	for _, category := range categories {
+		helpCategory := HelpCategory{Title: category}
+		if category == "" && len(categories) > 1 {
+			helpCategory.Title = "Other commands"
+		} else if category == "" {
+			helpCategory.Title = "Commands"
+		}
+		for _, sub := range members[category] {
+			helpCmd := HelpCommand{Command: sub, Name: sub.Name, Summary: sub.Summary, Category: category}
+			helpCategory.Commands = append(helpCategory.Commands, helpCmd)
+			data.SubCommands = append(data.SubCommands, helpCmd)
+			if len(sub.Name) > data.SubCommandWidth {
+				data.SubCommandWidth = len(sub.Name)
+			}
+		}
+		data.Categories = append(data.Categories, helpCategory)
+	}
+	commandLine := func(helpCmd *HelpCommand) {
+		padding := strings.Repeat(" ", data.SubCommandWidth-len(helpCmd.Name))
+		helpCmd.Line = fmt.Sprintf("      %s%s  %s\n", data.Format(data.Colors.CommandName, helpCmd.Name), padding, helpCmd.Summary)
+	}
+	for n := range data.SubCommands {
+		commandLine(&data.SubCommands[n])
+	}
+	for _, helpCategory := range data.Categories {
+		for n := range helpCategory.Commands {
+			commandLine(&helpCategory.Commands[n])
+		}
+	}
+
+	var described bool
+	var maxArgLen int
+	for _, arg := range cmd.args {
+		described = described || arg.Description != ""
+		if nameLen := len(arg.Name) + 2; nameLen > maxArgLen {
+			maxArgLen = nameLen
+		}
+	}
+	for n := 0; described && n < len(cmd.args); n++ {
+		arg := cmd.args[n]
+		data.Args = append(data.Args, HelpArg{
+			Arg:         arg,
+			Name:        arg.Name,
+			Description: arg.Description,
+			Required:    arg.RequireValue,
+			Variadic:    arg.variadic,
+			Line:        arg.argUsage(maxArgLen),
+		})
+	}
+
+	groups := cmd.optionGroups(showHidden)
+	var maxLen int
+	for _, grp := range groups {
+		for _, opt := range grp.Options {
+			if nameLen := len(opt.usageName()); nameLen > maxLen {
+				maxLen = nameLen
+			}
+		}
+	}
+	for _, grp := range groups {
+		// In a subcommand's unnamed group, options inherited from a parent are
+		// split out into a separate group, to distinguish them from local options
+		helpGroup := HelpOptionGroup{Title: cmd.groupTitle(grp.Name)}
+		inheritedGroup := HelpOptionGroup{Title: "Inherited Options"}
+		for _, opt := range grp.Options {
+			if value, _, ok := cmd.commandDefault(opt); ok {
+				withDefault := *opt
+				withDefault.Default = unquote(value)
+				opt = &withDefault
+			}
+			helpOpt := HelpOption{
+				Option:      opt,
+				Name:        opt.Name,
+				Aliases:     opt.Aliases,
+				UsageName:   opt.usageName(),
+				Description: opt.Description,
+				Default:     opt.DefaultUsage(),
+				Deprecation: opt.DeprecationUsage(),
+				Inherited:   cmd.options[opt.Name] == nil,
+				Line:        opt.Usage(maxLen),
+			}
+			if opt.Shorthand > 0 {
+				helpOpt.Shorthand = string(opt.Shorthand)
+			}
+			if color {
+				helpOpt.Line = data.formatOptionLine(helpOpt)
+			}
+			if helpOpt.Inherited && grp.Name == "" {
+				inheritedGroup.Options = append(inheritedGroup.Options, helpOpt)
+			} else {
+				helpGroup.Options = append(helpGroup.Options, helpOpt)
+			}
+		}
+		if len(helpGroup.Options) > 0 {
+			data.OptionGroups = append(data.OptionGroups, helpGroup)
+		}
+		if len(inheritedGroup.Options) > 0 {
+			data.OptionGroups = append(data.OptionGroups, inheritedGroup)
+		}
+	}
+
+	for _, example := range cmd.examples {
+		data.Examples = append(data.Examples, HelpExample{
+			CommandLine: example.CommandLine,
+			Description: example.Description,
+			Line:        fmt.Sprintf("  %s\n", example.CommandLine) + usageLine("      ", example.Description),
+		})
+	}
+
+	if webDocs := cmd.WebDocText(); webDocs != "" {
+		data.WebDocText = wordwrap.WrapString(webDocs, uint(lineLen))
+	}
+	return data
+}
+
+// formatOptionLine returns the Line of helpOpt with its flags and default value
+// formatted using data.Colors. A default value which was word-wrapped onto
+// multiple lines is left unformatted.
+func (data *HelpData) formatOptionLine(helpOpt HelpOption) string {
+	line := helpOpt.Line
+	flags := "--" + helpOpt.UsageName
+	if helpOpt.Shorthand != "" {
+		flags = "-" + helpOpt.Shorthand + ", " + flags
+	}
+	line = strings.Replace(line, flags, data.Format(data.Colors.OptionFlag, flags), 1)
+	if def := strings.TrimSpace(helpOpt.Default); def != "" {
+		line = strings.Replace(line, def, data.Format(data.Colors.Default, def), 1)
+	}
+	return line
+}
+
+// groupTitle returns the heading used for an option group of cmd, for example
+// "Global Options".
+func (cmd *Command) groupTitle(groupName string) string {
+	if groupName == "" && cmd.ParentCommand != nil {
+		groupName = cmd.Name
+	}
+	return strings.TrimSpace(fmt.Sprintf("%s Options", strings.Title(groupName)))
+}
+
+// fixedTerminalWidth, if positive, overrides the result of terminalWidth. It
+// is used by AssertUsageGolden to render usage deterministically.
+var fixedTerminalWidth int32
+
+// terminalWidth returns the width of the terminal attached to STDERR. If
+// STDERR is not a terminal, the COLUMNS environment variable is used instead.
+// Returns 0 if the width cannot be determined; otherwise the returned width is
+// never less than minTerminalWidth.
+func terminalWidth() int {
+	if width := atomic.LoadInt32(&fixedTerminalWidth); width > 0 {
+		return int(width)
+	}
+	var width int
+	if fd := int(os.Stderr.Fd()); terminal.IsTerminal(fd) {
+		width, _, _ = terminal.GetSize(fd)
+	}
+	if width <= 0 {
+		width, _ = strconv.Atoi(os.Getenv("COLUMNS"))
+	}
+	if width <= 0 {
+		return 0
+	} else if width < minTerminalWidth {
+		width = minTerminalWidth
+	}
+	// Avoid extra blank lines on Windows when output matches full line length
+	if runtime.GOOS == "windows" {
+		width--
+	}
+	return width
+}
+
+// colorEnabled returns true if output written to w may be formatted using ANSI
+// escape sequences: w must be a terminal, the NO_COLOR environment variable
+// must not be set, and TERM must not be "dumb".
+func colorEnabled(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok || !terminal.IsTerminal(int(f.Fd())) {
+		return false
+	}
+	if _, noColor := os.LookupEnv("NO_COLOR"); noColor {
+		return false
+	}
+	return os.Getenv("TERM") != "dumb"
+}
+
+// writePaged writes text to out. If out is a terminal and text has more lines
+// than fit in the terminal, text is instead piped through the pager program
+// specified by the PAGER environment variable, or "less" if PAGER is not set.
+// If the pager cannot be started, text is written to out directly.
+func writePaged(out *os.File, text string) error {
+	fd := int(out.Fd())
+	if !terminal.IsTerminal(fd) {
+		_, err := io.WriteString(out, text)
+		return err
+	}
+	_, height, err := terminal.GetSize(fd)
+	pager := os.Getenv("PAGER")
+	if pager == "" {
+		pager = "less"
+	}
+	pagerArgs := strings.Fields(pager)
+	if err != nil || strings.Count(text, "\n") < height || len(pagerArgs) == 0 {
+		_, err := io.WriteString(out, text)
+		return err
+	}
+	pagerCmd := exec.Command(pagerArgs[0], pagerArgs[1:]...)
+	pagerCmd.Stdin = strings.NewReader(text)
+	pagerCmd.Stdout = out
+	pagerCmd.Stderr = os.Stderr
+	if os.Getenv("LESS") == "" {
+		// Permit ANSI formatting, and exit immediately if output fits on screen
+		pagerCmd.Env = append(os.Environ(), "LESS=FRX")
+	}
+	if err := pagerCmd.Start(); err != nil {
+		_, err := io.WriteString(out, text)
+		return err
+	}
+	pagerCmd.Wait() // exit status of pager is not meaningful to the caller
+	return nil
+}
@@ -0,0 +1,130 @@
+package mybase
+
+import "sort"
+
+// OptionHook is a callback registered via Option.OnResolve or Option.OnSet.
+// It receives the Config which resolved the option's value, and a description
+// of the value.
+type OptionHook func(cfg *Config, change OptionChange)
+
+// OptionChange describes the resolved value of an option, as supplied to an
+// OptionHook. Values are as returned by Config.Get, i.e. after unquoting and
+// any transform.
+type OptionChange struct {
+	Option    *Option
+	OldValue  string       // Previous value; empty string for OnResolve hooks
+	NewValue  string       // Current value
+	OldSource OptionValuer // Source of OldValue; nil for OnResolve hooks
+	Source    OptionValuer // Source of NewValue; a *Command for default values
+}
+
+// hookState records the value and source of an option as of the last time its
+// hooks were run by a Config.
+type hookState struct {
+	value  string
+	source OptionValuer
+}
+
+// pendingHook is a call to an OptionHook queued while rebuilding a Config's
+// caches, to be made once the lock is released.
+type pendingHook struct {
+	hooks  []OptionHook
+	change OptionChange
+}
+
+// OnResolve registers a callback to be called the first time each Config
+// resolves the Option's value. This permits a live subsystem to be initialized
+// from the option, regardless of which source supplies its value. Multiple
+// callbacks may be registered. See OnSet regarding when callbacks are run.
+func (opt *Option) OnResolve(hook OptionHook) *Option {
+	opt.onResolve = append(opt.onResolve, hook)
+	return opt
+}
+
+// OnSet registers a callback to be called whenever a Config's resolved value
+// for the Option changes after it was first resolved. This occurs when a
+// source is modified, for example via File.SetOptionValue, File.UseSection, or
+// a reload of an option file; or when a source is added to the Config.
+// Multiple callbacks may be registered.
+//
+// Callbacks registered via OnResolve or OnSet are run synchronously, in the
+// goroutine which caused the Config to re-resolve its option values, e.g. via
+// Config.Get, Config.ReloadFiles, or a source's Watch method. Callbacks may
+// safely call methods of the Config. Hooks are not run for an option whose
+// value comes from a default set via SetDefaultFunc (unless overridden via
+// Command.SetDefaults), or whose transform returned an error.
+func (opt *Option) OnSet(hook OptionHook) *Option {
+	opt.onSet = append(opt.onSet, hook)
+	return opt
+}
+
+// queueHooks compares the newly-resolved value of each option which has hooks
+// against the value as of the previous run of its hooks, and queues calls to
+// any hooks which should run. Hooks are queued in order by option name. The
+// caller must hold a write lock on cfg.mu, and must have just rebuilt the
+// caches.
+func (cfg *Config) queueHooks(options map[string]*Option) {
+	if cfg.noHooks {
+		return
+	}
+	var names []string
+	for name, opt := range options {
+		if len(opt.onResolve) > 0 || len(opt.onSet) > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		opt, source := options[name], cfg.unifiedSources[name]
+		if _, ok := cfg.transformErrors[name]; ok {
+			continue
+		} else if cfg.unresolvedOptions[name] {
+			continue
+		} else if _, isDefault := source.(*Command); isDefault && cfg.lazyOptions[name] != nil {
+			continue
+		}
+		value, ok := cfg.unifiedTransformed[name]
+		if !ok {
+			value = unquote(cfg.unifiedValues[name])
+		}
+		if cfg.hookStates == nil {
+			cfg.hookStates = make(map[string]hookState)
+		}
+		prev, resolved := cfg.hookStates[name]
+		cfg.hookStates[name] = hookState{value: value, source: source}
+		if !resolved && len(opt.onResolve) > 0 {
+			cfg.pendingHooks = append(cfg.pendingHooks, pendingHook{
+				hooks:  opt.onResolve,
+				change: OptionChange{Option: opt, NewValue: value, Source: source},
+			})
+		} else if resolved && value != prev.value && len(opt.onSet) > 0 {
+			cfg.pendingHooks = append(cfg.pendingHooks, pendingHook{
+				hooks:  opt.onSet,
+				change: OptionChange{Option: opt, OldValue: prev.value, NewValue: value, OldSource: prev.source, Source: source},
+			})
+		}
+	}
+}
+
+// runHooks makes any calls to hooks queued by queueHooks. The caller must NOT
+// hold a lock on cfg.mu, since hooks may access cfg.
+func (cfg *Config) runHooks() {
+	cfg.mu.Lock()
+	pending := cfg.pendingHooks
+	cfg.pendingHooks = nil
+	cfg.mu.Unlock()
+	for _, call := range pending {
+		for _, hook := range call.hooks {
+			hook(cfg, call.change)
+		}
+	}
+}
+
+// refresh rebuilds cfg's caches if they are stale, running any resulting
+// hooks and reporting any resulting warnings.
+func (cfg *Config) refresh() {
+	cfg.mu.Lock()
+	cfg.resolveCommands()
+	cfg.mu.Unlock()
+	cfg.flushPending()
+}
@@ -0,0 +1,62 @@
+package mybase
+
+import (
+	"testing"
+)
+
+func TestOptionHooks(t *testing.T) {
+	var resolved, set []OptionChange
+	cmd := simpleCommand()
+	cmd.AddOption(StringOption("log-level", 0, "info", "dummy").OnResolve(func(cfg *Config, change OptionChange) {
+		resolved = append(resolved, change)
+		cfg.Get("visible") // hooks may safely use the Config
+	}).OnSet(func(cfg *Config, change OptionChange) {
+		set = append(set, change)
+	}))
+	f, err := getParsedFile(ParseFakeCLI(t, cmd, "mycommand arg1"), false, "log-level='warn'\n[debug]\nlog-level=debug\n")
+	if err != nil {
+		t.Fatalf("Unexpected error parsing file: %v", err)
+	}
+	resolved = nil
+	cfg := ParseFakeCLI(t, cmd, "mycommand arg1", f)
+
+	// OnResolve hooks run once, upon first lookup of any option
+	cfg.Get("visible")
+	cfg.Get("log-level")
+	if len(resolved) != 1 || resolved[0].NewValue != "warn" || resolved[0].Source != f || resolved[0].Option.Name != "log-level" {
+		t.Fatalf("Unexpected OnResolve calls: %+v", resolved)
+	}
+	if len(set) != 0 {
+		t.Errorf("Expected no OnSet calls yet, instead found %+v", set)
+	}
+
+	// OnSet hooks run when a source changes the resolved value, but not when
+	// an unrelated change is made
+	f.SetOptionValue("", "log-level", "error")
+	cfg.Get("visible")
+	f.SetOptionValue("", "hasshort", "x")
+	cfg.Get("visible")
+	f.UseSection("debug")
+	cfg.Get("visible")
+	if len(set) != 2 {
+		t.Fatalf("Expected 2 OnSet calls, instead found %+v", set)
+	}
+	if set[0].OldValue != "warn" || set[0].NewValue != "error" || set[0].OldSource != f || set[0].Source != f {
+		t.Errorf("Unexpected first OnSet call: %+v", set[0])
+	}
+	if set[1].OldValue != "error" || set[1].NewValue != "debug" {
+		t.Errorf("Unexpected second OnSet call: %+v", set[1])
+	}
+
+	// Hooks run for a reload even if no lookups occur, and each Config resolves
+	// independently
+	cfg.AddSource(SimpleSource(map[string]string{"log-level": "trace"}))
+	cfg.runWatchers(nil, nil)
+	if len(set) != 3 || set[2].NewValue != "trace" {
+		t.Errorf("Expected OnSet call for added source, instead found %+v", set)
+	}
+	cfg.Clone().Get("visible")
+	if len(resolved) != 2 || resolved[1].NewValue != "trace" {
+		t.Errorf("Expected OnResolve call for clone, instead found %+v", resolved)
+	}
+}
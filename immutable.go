@@ -0,0 +1,116 @@
+package mybase
+
+import (
+	"fmt"
+)
+
+// Immutable marks an Option as being unchangeable once a protected source
+// supplies its value. Sources are protected via Config.ProtectSource, for
+// example a system-wide option file or a SimpleSource of compiled-in policy.
+// When resolving an Immutable option, a Config uses the value from the
+// highest-precedence protected source which supplies it, even if other sources
+// with higher precedence (including the command-line) also supply a value. If
+// any of those sources supply a different value, Config.CheckValues and
+// Config.Validate return an OptionImmutableError. This also applies to values
+// added later via File.SetOptionValue on an unprotected File.
+//
+// If no protected source supplies a value, the Option's value is resolved
+// normally. This is useful for enforcing organizational security policies,
+// such as requiring a particular ssl-mode.
+func (opt *Option) Immutable() *Option {
+	opt.immutable = true
+	return opt
+}
+
+// ProtectSource marks source as protected, meaning that its values for
+// Immutable options cannot be overridden by any other source. Panics if source
+// has not already been added to cfg, or is cfg.CLI, since these are indicative
+// of programmer error.
+func (cfg *Config) ProtectSource(source OptionValuer) {
+	cfg.assertUnsealed("ProtectSource")
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	cfg.sourceIndex(source) // panics if not a source
+	if !cfg.isProtected(source) {
+		cfg.protected = append(cfg.protected, source)
+		cfg.dirty = true
+	}
+}
+
+// isProtected returns true if source was supplied to ProtectSource. The caller
+// must hold a lock on cfg.mu.
+func (cfg *Config) isProtected(source OptionValuer) bool {
+	for _, protected := range cfg.protected {
+		if sameSource(source, protected) {
+			return true
+		}
+	}
+	return false
+}
+
+// OptionImmutableError is an error returned by Config.CheckValues when a source
+// attempts to change the value of an Immutable option which was supplied by a
+// protected source.
+type OptionImmutableError struct {
+	Name            string
+	Value           string // value which was rejected
+	Source          string // description of the source which supplied the rejected value
+	FilePath        string // only set if the rejected value came from an option file
+	ProtectedValue  string
+	ProtectedSource string // description of the protected source which supplied the value in effect
+}
+
+// Error satisfies golang's error interface.
+func (oie OptionImmutableError) Error() string {
+	return fmt.Sprintf("%s: Value %q for option %s is not permitted, since %s has set it to %q and it cannot be changed", oie.Source, oie.Value, oie.Name, oie.ProtectedSource, oie.ProtectedValue)
+}
+
+// Is returns true if target is ErrInvalidValue, permitting use of errors.Is.
+func (oie OptionImmutableError) Is(target error) bool { return target == ErrInvalidValue }
+
+// OptionName satisfies the ParseError interface.
+func (oie OptionImmutableError) OptionName() string { return oie.Name }
+
+// Location satisfies the ParseError interface.
+func (oie OptionImmutableError) Location() string { return location(oie.FilePath, oie.Source) }
+
+// Line satisfies the ParseError interface. It always returns 0, since option
+// values are not tracked by line.
+func (oie OptionImmutableError) Line() int { return 0 }
+
+// enforceImmutable ensures that the value of Immutable option opt comes from
+// the highest-precedence protected source in allSources which supplies it, if
+// any. It returns an OptionImmutableError if a source with higher precedence
+// supplies a different value. The caller must hold a write lock on cfg.mu.
+func (cfg *Config) enforceImmutable(opt *Option, allSources []OptionValuer) error {
+	var overriders []OptionValuer
+	for n := len(allSources) - 1; n > 0; n-- { // allSources[0] is the Command, which only supplies defaults
+		source := allSources[n]
+		value, ok := optionValueOrAlias(source, opt)
+		if !ok {
+			continue
+		} else if !cfg.isProtected(source) {
+			overriders = append(overriders, source)
+			continue
+		}
+		cfg.unifiedValues[opt.Name] = value
+		cfg.unifiedSources[opt.Name] = source
+		for _, overrider := range overriders {
+			overrideValue, _ := optionValueOrAlias(overrider, opt)
+			if unquote(overrideValue) != unquote(value) {
+				sourceName, filePath := describeSource(overrider)
+				protectedName, _ := describeSource(source)
+				return OptionImmutableError{
+					Name:            opt.Name,
+					Value:           opt.displayValue(unquote(overrideValue)),
+					Source:          sourceName,
+					FilePath:        filePath,
+					ProtectedValue:  opt.displayValue(unquote(value)),
+					ProtectedSource: protectedName,
+				}
+			}
+		}
+		return nil
+	}
+	return nil
+}
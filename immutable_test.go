@@ -0,0 +1,83 @@
+package mybase
+
+import (
+	"testing"
+)
+
+func TestImmutable(t *testing.T) {
+	cmd := simpleCommand()
+	cmd.AddOption(StringOption("ssl-mode", 0, "preferred", "dummy description").Immutable())
+	cmd.AddOption(StringOption("password", 0, "", "dummy description").Immutable().Sensitive())
+
+	getConfig := func(commandLine, policyContents, userContents string) (*Config, *File) {
+		t.Helper()
+		cfg := ParseFakeCLI(t, cmd, commandLine)
+		policy, err := getParsedFile(cfg, false, policyContents)
+		if err != nil {
+			t.Fatalf("Unexpected error from getParsedFile: %v", err)
+		}
+		user, err := getParsedFile(cfg, false, userContents)
+		if err != nil {
+			t.Fatalf("Unexpected error from getParsedFile: %v", err)
+		}
+		cfg.AddSource(policy)
+		cfg.AddSource(user)
+		cfg.ProtectSource(policy)
+		return cfg, user
+	}
+
+	// Protected value wins over higher-precedence sources, with an error if they
+	// differ; non-immutable options are unaffected
+	cfg, user := getConfig("mycommand --ssl-mode=disabled arg1", "ssl-mode=required\nvisible=policy\n", "visible=user\n")
+	if actual := cfg.Get("ssl-mode"); actual != "required" {
+		t.Errorf("Expected protected value to be used, instead found %q", actual)
+	}
+	if actual := cfg.Get("visible"); actual != "user" {
+		t.Errorf("Expected non-immutable option to resolve normally, instead found %q", actual)
+	}
+	if err := cfg.CheckValues(); err == nil {
+		t.Error("Expected error from CheckValues, but err is nil")
+	} else if oie, ok := err.(OptionImmutableError); !ok || oie.Name != "ssl-mode" || oie.Source != "command line" || oie.Value != "disabled" {
+		t.Errorf("Unexpected error from CheckValues: %T %v", err, err)
+	}
+
+	// Same value from a higher-precedence source is not an error; changing an
+	// unprotected source via SetOptionValue is
+	cfg, user = getConfig("mycommand arg1", "ssl-mode=required\n", "ssl-mode='required'\n")
+	if err := cfg.CheckValues(); err != nil {
+		t.Errorf("Unexpected error from CheckValues: %v", err)
+	}
+	user.SetOptionValue("", "ssl-mode", "disabled")
+	if actual := cfg.Get("ssl-mode"); actual != "required" {
+		t.Errorf("Expected protected value to be used after SetOptionValue, instead found %q", actual)
+	}
+	if err := cfg.CheckValues(); err == nil {
+		t.Error("Expected error from CheckValues after SetOptionValue, but err is nil")
+	} else if oie, ok := err.(OptionImmutableError); !ok || oie.FilePath != user.Path() {
+		t.Errorf("Unexpected error from CheckValues: %T %v", err, err)
+	}
+
+	// Sensitive values are redacted; clones retain protection
+	cfg, _ = getConfig("mycommand --password=hunter2 arg1", "password=s3cr3t\n", "\n")
+	clone := cfg.Clone()
+	if actual := clone.Get("password"); actual != "s3cr3t" {
+		t.Errorf("Expected clone to use protected value, instead found %q", actual)
+	}
+	if err, ok := clone.CheckValues().(OptionImmutableError); !ok || err.Value == "hunter2" || err.ProtectedValue == "s3cr3t" {
+		t.Errorf("Expected OptionImmutableError with redacted values, instead found %v", err)
+	}
+
+	// Without a value from a protected source, resolution is normal
+	cfg, _ = getConfig("mycommand --ssl-mode=disabled arg1", "\n", "\n")
+	if actual := cfg.Get("ssl-mode"); actual != "disabled" || cfg.CheckValues() != nil {
+		t.Errorf("Unexpected value %q or error %v", actual, cfg.CheckValues())
+	}
+
+	// Panics if the source was not added
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected ProtectSource to panic for unknown source, but it did not")
+		}
+	}()
+	cfg.ProtectSource(SimpleSource{})
+}
@@ -0,0 +1,147 @@
+package mybase
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ExpandInstances returns a Config for each database server instance defined
+// by the named sections of f, for programs which operate on several instances
+// at once. Each section name is a comma-separated list of instances, and each
+// instance is in one of these forms:
+//
+//	host
+//	host:port
+//	/path/to/socket
+//
+// For example, a section named [db1:3306,db1:3307,db2] defines three
+// instances. A socket path implies host "localhost". An IPv6 address may be
+// used as a host, but cannot be followed by a port, since its colons would be
+// ambiguous; set the port option within the section instead. Sections whose names
+// contain * or ? are instead treated as host patterns, using the syntax of
+// path.Match: rather than defining any instances, they supply option values to
+// every instance whose "host" or "host:port" form matches.
+//
+// Each returned Config is a clone of cfg, in the same manner as
+// CloneWithOverrides. The host, port, and socket options are overridden by the
+// values from the section name, if present there; these take precedence over
+// all other sources, including the command-line. An additional source supplies
+// the values of the instance's own section, which take precedence over those of
+// any matching pattern sections; in turn, matching pattern sections later in
+// the file take precedence over earlier ones. This source overrides all other
+// sources of cfg, except the command-line. The default section of f is not
+// used, since f is typically already a source of cfg.
+//
+// The Configs are returned in the order their instances appear in f. An error
+// is returned if a section name is malformed, or if multiple sections define
+// the same instance. Panics if cfg's command lacks any of the host, port, and
+// socket options, since this is indicative of programmer error.
+func (cfg *Config) ExpandInstances(f *File) ([]*Config, error) {
+	for _, name := range []string{"host", "port", "socket"} {
+		if cfg.FindOption(name) == nil {
+			panic(fmt.Errorf("ExpandInstances: command %s does not have a %s option", cfg.CLI.Command.Name, name))
+		}
+	}
+
+	sections := f.Sections()
+	var patterns []string
+	for _, section := range sections {
+		if strings.ContainsAny(section.Name, "*?") {
+			patterns = append(patterns, section.Name)
+		}
+	}
+
+	var result []*Config
+	definedBy := make(map[string]string) // "host:port:socket" => section name
+	for _, section := range sections {
+		if section.Name == "" || strings.ContainsAny(section.Name, "*?") {
+			continue
+		}
+		for _, entry := range strings.Split(section.Name, ",") {
+			host, port, socket, err := parseInstance(strings.TrimSpace(entry))
+			if err != nil {
+				return nil, fmt.Errorf("%s: Invalid instance in section [%s]: %s", f.Path(), section.Name, err)
+			}
+			key := host + ":" + port + ":" + socket
+			if prev, dupe := definedBy[key]; dupe {
+				return nil, fmt.Errorf("%s: Instance %s is defined by both section [%s] and section [%s]", f.Path(), strings.TrimSpace(entry), prev, section.Name)
+			}
+			definedBy[key] = section.Name
+
+			values := make(map[string]string)
+			for _, pattern := range patterns {
+				if instanceMatches(pattern, host, port) {
+					for name, value := range f.SectionValues(pattern) {
+						values[name] = value
+					}
+				}
+			}
+			for name, value := range f.SectionValues(section.Name) {
+				values[name] = value
+			}
+
+			overrides := map[string]string{"host": host}
+			if port != "" {
+				overrides["port"] = port
+			}
+			if socket != "" {
+				overrides["socket"] = socket
+			}
+			instance := cfg.CloneWithOverrides(overrides)
+			instance.AddSource(instanceSource{values: values, description: fmt.Sprintf("%s section [%s]", f.Path(), section.Name)})
+			result = append(result, instance)
+		}
+	}
+	return result, nil
+}
+
+// parseInstance splits one instance from a section name into its host, port,
+// and socket. The port and socket are empty if not present.
+func parseInstance(entry string) (host, port, socket string, err error) {
+	switch {
+	case strings.HasPrefix(entry, "/"):
+		return "localhost", "", entry, nil
+	case strings.Count(entry, ":") == 1:
+		colon := strings.Index(entry, ":")
+		host, port = entry[:colon], entry[colon+1:]
+	default: // bare hostname, or an IPv6 address without a port
+		host = entry
+	}
+	if host == "" {
+		return "", "", "", fmt.Errorf("missing host in %q", entry)
+	}
+	if port != "" {
+		if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 {
+			return "", "", "", fmt.Errorf("port %q is not a number between 1 and 65535", port)
+		}
+	}
+	return host, port, "", nil
+}
+
+// instanceMatches returns true if the supplied pattern matches an instance's
+// host, or its host and port joined by a colon.
+func instanceMatches(pattern, host, port string) bool {
+	if matched, _ := path.Match(pattern, host); matched {
+		return true
+	}
+	matched, _ := path.Match(pattern, host+":"+port)
+	return matched && port != ""
+}
+
+// instanceSource is an OptionValuer supplying the values of an instance's
+// sections, for Configs returned by Config.ExpandInstances.
+type instanceSource struct {
+	values      map[string]string
+	description string
+}
+
+func (src instanceSource) OptionValue(optionName string) (string, bool) {
+	value, ok := src.values[canonicalOptionName(optionName)]
+	return value, ok
+}
+
+func (src instanceSource) String() string {
+	return src.description
+}
@@ -0,0 +1,64 @@
+package mybase
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestExpandInstances(t *testing.T) {
+	cmd := simpleCommand()
+	cmd.AddOptions("connection",
+		StringOption("host", 'h', "localhost", "Database hostname"),
+		StringOption("port", 'P', "3306", "Database port"),
+		StringOption("socket", 'S', "/tmp/mysql.sock", "Database socket"),
+	)
+	cfg := ParseFakeCLI(t, cmd, "mycommand --visible=cli arg1")
+	contents := "hasshort=base\n\n[db-*]\nhasshort=pattern\nhidden=pattern\n\n[*:3307]\nhidden=port3307\n\n[db-1:3307, db-2]\nhidden=own\n\n[/var/run/mysqld.sock]\n\n[other]\nport=3310\nvisible=file\n"
+	f, err := getParsedFile(cfg, false, contents)
+	if err != nil {
+		t.Fatalf("Unexpected error from getParsedFile: %v", err)
+	}
+	cfg.AddSource(f)
+
+	instances, err := cfg.ExpandInstances(f)
+	if err != nil {
+		t.Fatalf("Unexpected error from ExpandInstances: %v", err)
+	}
+	var actual []string
+	for _, inst := range instances {
+		actual = append(actual, fmt.Sprintf("%s:%s:%s:%s:%s:%s", inst.Get("host"), inst.Get("port"), inst.Get("socket"), inst.Get("hasshort"), inst.Get("hidden"), inst.Get("visible")))
+	}
+	expected := []string{
+		"db-1:3307:/tmp/mysql.sock:pattern:own:cli",
+		"db-2:3306:/tmp/mysql.sock:pattern:own:cli",
+		"localhost:3306:/var/run/mysqld.sock:base:somedefault:cli",
+		"other:3310:/tmp/mysql.sock:base:somedefault:cli",
+	}
+	if strings.Join(actual, " ") != strings.Join(expected, " ") {
+		t.Errorf("Unexpected instances:\n  expected %v\n  found    %v", expected, actual)
+	}
+
+	// Pattern sections later in the file take precedence over earlier ones
+	f, _ = getParsedFile(cfg, false, "[db-*]\nhidden=pattern\n\n[*:3307]\nhidden=port3307\n\n[db-1:3307]\n")
+	if instances, err := cfg.ExpandInstances(f); err != nil || len(instances) != 1 || instances[0].Get("hidden") != "port3307" {
+		t.Errorf("Unexpected result from ExpandInstances: %v, %v", instances, err)
+	}
+
+	// Malformed or duplicate instances are errors
+	for _, contents := range []string{"[db1:notaport]\n", "[db1:0]\n", "[:3306]\n", "[db1,db2]\n\n[db2]\n"} {
+		f, _ = getParsedFile(cfg, false, contents)
+		if _, err := cfg.ExpandInstances(f); err == nil {
+			t.Errorf("Expected error from ExpandInstances for %q, but err was nil", contents)
+		}
+	}
+
+	// Panics if the command lacks the necessary options
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected panic from ExpandInstances, but it did not occur")
+		}
+	}()
+	cfg = ParseFakeCLI(t, simpleCommand(), "mycommand arg1")
+	cfg.ExpandInstances(f)
+}
@@ -0,0 +1,82 @@
+package mybase
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envReferencePrefix is the prefix used in "${ENV:NAME}" references to
+// environment variables.
+const envReferencePrefix = "ENV:"
+
+// expandedValue returns the value of the named option or arg, unquoted and
+// with any variable references expanded. See Config.ExpandVariables for the
+// reference syntax. chain lists the names of options currently being expanded,
+// for purposes of detecting circular references, and memo caches results. The
+// caller must hold a write lock on cfg.mu, and the value caches must already
+// be populated.
+func (cfg *Config) expandedValue(name string, chain []string, memo map[string]string) (string, error) {
+	if value, ok := memo[name]; ok {
+		return value, nil
+	}
+	for n, prev := range chain {
+		if prev == name {
+			return "", fmt.Errorf("circular reference: %s -> %s", strings.Join(chain[n:], " -> "), name)
+		}
+	}
+	value := unquote(cfg.unifiedValues[name])
+	if strings.Contains(value, "${") {
+		var err error
+		value, err = expandVariables(value, func(ref string) (string, error) {
+			if strings.HasPrefix(ref, envReferencePrefix) {
+				return os.Getenv(strings.TrimPrefix(ref, envReferencePrefix)), nil
+			}
+			refName := cfg.resolveName(ref)
+			if _, ok := cfg.unifiedValues[refName]; !ok {
+				return "", fmt.Errorf("reference to unknown option %s", ref)
+			}
+			return cfg.expandedValue(refName, append(chain, name), memo)
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	memo[name] = value
+	return value, nil
+}
+
+// expandVariables replaces each "${ref}" in value with the result of calling
+// lookup on ref. A literal "${" may be obtained by writing "$${".
+func expandVariables(value string, lookup func(ref string) (string, error)) (string, error) {
+	var b strings.Builder
+	for {
+		pos := strings.Index(value, "${")
+		if pos < 0 {
+			b.WriteString(value)
+			return b.String(), nil
+		}
+		if pos > 0 && value[pos-1] == '$' { // escaped
+			b.WriteString(value[:pos-1])
+			b.WriteString("${")
+			value = value[pos+2:]
+			continue
+		}
+		end := strings.IndexByte(value[pos:], '}')
+		if end < 0 {
+			return "", errors.New("unterminated ${ in value")
+		}
+		ref := strings.TrimSpace(value[pos+2 : pos+end])
+		if ref == "" {
+			return "", errors.New("empty ${} in value")
+		}
+		replacement, err := lookup(ref)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(value[:pos])
+		b.WriteString(replacement)
+		value = value[pos+end+1:]
+	}
+}
@@ -0,0 +1,98 @@
+package mybase
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestExpandVariables(t *testing.T) {
+	defer os.Setenv("MYBASE_TEST_HOME", os.Getenv("MYBASE_TEST_HOME"))
+	os.Setenv("MYBASE_TEST_HOME", "/home/mybase")
+
+	cmd := simpleCommand()
+	cmd.AddOption(StringOption("datadir", 0, "${ENV:MYBASE_TEST_HOME}/data", "dummy description"))
+	cmd.AddOption(StringOption("socket", 0, "", "dummy description").SetTransform(TransformLower))
+	cmd.AddOption(StringOption("loop-a", 0, "${loop-b}", "dummy description"))
+	cmd.AddOption(StringOption("loop-b", 0, "${loop_a}", "dummy description"))
+	cmd.AddOption(StringOption("literal", 0, "", "dummy description"))
+	file := NewFile("/etc/myconfigs", "my.cnf")
+	file.contents = "socket=\"${DataDir}/MySQL.sock\"\nliteral=$${datadir} for ${required}\nvisible=${nope}\n"
+	file.read = true
+
+	cfg := ParseFakeCLI(t, cmd, "mycommand arg1")
+	if err := file.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error from Parse: %v", err)
+	}
+	cfg.AddSource(file)
+
+	// Without ExpandVariables, values are left alone
+	if actual := cfg.Get("datadir"); actual != "${ENV:MYBASE_TEST_HOME}/data" {
+		t.Errorf("Expected no expansion by default, instead found %q", actual)
+	}
+
+	cfg.ExpandVariables = true
+	cfg.MarkDirty()
+	expected := map[string]string{
+		"datadir": "/home/mybase/data",
+		"socket":  "/home/mybase/data/mysql.sock", // transform applied after expansion
+		"literal": "${datadir} for arg1",
+		"visible": "${nope}",
+	}
+	for name, value := range expected {
+		if actual := cfg.Get(name); actual != value {
+			t.Errorf("Expected %s to be %q, instead found %q", name, value, actual)
+		}
+	}
+	if actual := cfg.GetRaw("socket"); actual != `"${DataDir}/MySQL.sock"` {
+		t.Errorf("Expected GetRaw to return unexpanded value, instead found %q", actual)
+	}
+
+	// Unknown references and cycles are reported by CheckValues
+	err := cfg.CheckValues()
+	problems, ok := err.(ParseErrors)
+	if !ok || len(problems) != 3 {
+		t.Fatalf("Expected 3 errors from CheckValues, instead found %v", err)
+	}
+	for n, name := range []string{"loop-a", "loop-b", "visible"} {
+		var ote OptionTransformError
+		if !errors.As(problems[n], &ote) || ote.Name != name {
+			t.Errorf("Expected error %d to be OptionTransformError for %s, instead found %v", n, name, problems[n])
+		}
+	}
+	if msg := problems[0].Error(); !strings.Contains(msg, "circular reference: loop-a -> loop-b -> loop-a") {
+		t.Errorf("Unexpected error message: %s", msg)
+	}
+	if msg := problems[2].Error(); !strings.Contains(msg, "unknown option nope") || !strings.Contains(msg, file.Path()) {
+		t.Errorf("Unexpected error message: %s", msg)
+	}
+
+	// Clones retain the setting
+	if actual := cfg.Clone().Get("datadir"); actual != "/home/mybase/data" {
+		t.Errorf("Expected clone to expand variables, instead found %q", actual)
+	}
+}
+
+func TestExpandVariablesSyntax(t *testing.T) {
+	lookup := func(ref string) (string, error) { return "<" + ref + ">", nil }
+	cases := map[string]string{
+		"":                 "",
+		"plain":            "plain",
+		"$x {y} $":         "$x {y} $",
+		"${a}${ b }c${d}":  "<a><b>c<d>",
+		"$${a} $$${a}":     "${a} $${a}",
+		"${a}}":            "<a>}",
+		"pre ${ENV:X} suf": "pre <ENV:X> suf",
+	}
+	for input, expected := range cases {
+		if actual, err := expandVariables(input, lookup); err != nil || actual != expected {
+			t.Errorf("Unexpected result from expandVariables(%q): %q, %v", input, actual, err)
+		}
+	}
+	for _, input := range []string{"${a", "x ${}", "${a} ${"} {
+		if _, err := expandVariables(input, lookup); err == nil {
+			t.Errorf("Expected error from expandVariables(%q), but err is nil", input)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package mybase
+
+import (
+	"encoding/json"
+)
+
+// String returns a short lowercase name for the OptionType, such as "string",
+// "bool", or "duration".
+func (typ OptionType) String() string {
+	switch typ {
+	case OptionTypeString:
+		return "string"
+	case OptionTypeBool:
+		return "bool"
+	case OptionTypeDuration:
+		return "duration"
+	case OptionTypeSize:
+		return "size"
+	case OptionTypeEnum:
+		return "enum"
+	case OptionTypeCount:
+		return "count"
+	case OptionTypeMulti:
+		return "multi"
+	case OptionTypeMap:
+		return "map"
+	case OptionTypeFloat:
+		return "float"
+	case OptionTypeTime:
+		return "time"
+	case OptionTypeTemplate:
+		return "template"
+	default:
+		return "unknown"
+	}
+}
+
+// CommandInfo is a machine-readable description of a Command and its
+// descendants, returned by Command.Introspect. It is intended for external
+// tools, such as documentation sites, completion generators, or GUI wrappers,
+// which need to consume the definition of a program's command-line interface.
+type CommandInfo struct {
+	Name         string            `json:"name"`                    // Command name, as used in CLI
+	FullName     string            `json:"full_name"`               // Command name, prefixed by the names of any parent commands
+	Version      string            `json:"version,omitempty"`       // Version string; only set for a top-level command
+	Summary      string            `json:"summary,omitempty"`       // Short description text; not set for a top-level command
+	Description  string            `json:"description,omitempty"`   // Long description text
+	LongHelp     string            `json:"long_help,omitempty"`     // Extended help text set via SetLongHelp
+	Invocation   string            `json:"invocation"`              // Synopsis of invoking the command, including its args
+	Category     string            `json:"category,omitempty"`      // Category set via SetCategory
+	WebDocURL    string            `json:"web_doc_url,omitempty"`   // URL for online documentation of this command
+	Args         []ArgInfo         `json:"args,omitempty"`          // Positional args, in order
+	OptionGroups []OptionGroupInfo `json:"option_groups,omitempty"` // Groups of options defined by this command, in the same order as help output
+	Examples     []Example         `json:"examples,omitempty"`      // Sample invocations added via AddExample
+	SubCommands  []CommandInfo     `json:"subcommands,omitempty"`   // Subcommands, in the order listed by help output
+}
+
+// OptionGroupInfo describes a group of related options in a CommandInfo.
+type OptionGroupInfo struct {
+	Name    string       `json:"name"`  // Group name supplied when adding the options, or empty string for the unnamed group
+	Title   string       `json:"title"` // Heading for the group in help output, for example "Global Options"
+	Options []OptionInfo `json:"options"`
+}
+
+// OptionInfo describes an option in a CommandInfo.
+type OptionInfo struct {
+	Name           string   `json:"name"`                       // Canonical long name
+	Shorthand      string   `json:"shorthand,omitempty"`        // Single-character short name, if any
+	Aliases        []string `json:"aliases,omitempty"`          // Alternative long names
+	Type           string   `json:"type"`                       // Name of the OptionType, for example "string" or "bool"
+	Default        string   `json:"default"`                    // Default value; "true" or "false" for bool options
+	Description    string   `json:"description,omitempty"`      // Description text, as shown in help output
+	RequireValue   bool     `json:"require_value"`              // True if the option must be supplied with a value on the command-line
+	AllowedValues  []string `json:"allowed_values,omitempty"`   // Permitted values for enum options, or options with choices; see Option.SetChoices
+	ValueHint      string   `json:"value_hint,omitempty"`       // Placeholder for the value in help output, if set via Option.SetValueHint
+	Mandatory      bool     `json:"mandatory,omitempty"`        // True if some source must supply a value; see Option.Mandatory
+	MandatoryOnCLI bool     `json:"mandatory_on_cli,omitempty"` // True if the command-line must supply a value; see Option.MandatoryOnCLI
+	Sensitive      bool     `json:"sensitive,omitempty"`        // True if values are redacted; see Option.Sensitive
+	Local          bool     `json:"local,omitempty"`            // True if not inherited by subcommands; see Option.Local
+	Deprecation    string   `json:"deprecation,omitempty"`      // Explanation of what to use instead, if deprecated
+	Replacement    string   `json:"replacement,omitempty"`      // Name of the option which replaces this deprecated option, if any
+}
+
+// ArgInfo describes a positional arg in a CommandInfo.
+type ArgInfo struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`                  // Name of the OptionType, for example "string"
+	Default     string `json:"default,omitempty"`     // Value used if an optional arg is omitted
+	Description string `json:"description,omitempty"` // Description text set via Option.Describe
+	Required    bool   `json:"required"`              // True if the arg must be supplied
+	Variadic    bool   `json:"variadic,omitempty"`    // True if the arg consumes all remaining positional values
+}
+
+// Introspect returns a description of cmd and all of its descendant commands,
+// including their positional args, options, and examples. Consistent with
+// GenerateDocs, options are described alongside the command which defines them
+// rather than repeated for each subcommand, and hidden options and the
+// built-in help and version subcommands are omitted.
+func (cmd *Command) Introspect() CommandInfo {
+	info := CommandInfo{
+		Name:        cmd.Name,
+		FullName:    cmd.fullName(),
+		Description: cmd.Description,
+		LongHelp:    cmd.longHelp,
+		Invocation:  cmd.Invocation(),
+		Category:    cmd.category,
+		WebDocURL:   cmd.WebDocURL,
+		Examples:    cmd.examples,
+	}
+	if cmd.ParentCommand == nil {
+		info.Version = cmd.Summary
+	} else {
+		info.Summary = cmd.Summary
+	}
+	for _, arg := range cmd.args {
+		info.Args = append(info.Args, ArgInfo{
+			Name:        arg.Name,
+			Type:        arg.Type.String(),
+			Default:     arg.Default,
+			Description: arg.Description,
+			Required:    arg.RequireValue,
+			Variadic:    arg.variadic,
+		})
+	}
+	for _, grp := range cmd.ownOptionGroups() {
+		groupInfo := OptionGroupInfo{Name: grp.Name, Title: cmd.groupTitle(grp.Name)}
+		for _, opt := range grp.Options {
+			groupInfo.Options = append(groupInfo.Options, opt.introspect())
+		}
+		info.OptionGroups = append(info.OptionGroups, groupInfo)
+	}
+	for _, sub := range cmd.orderedSubCommands() {
+		if !sub.isBuiltin() {
+			info.SubCommands = append(info.SubCommands, sub.Introspect())
+		}
+	}
+	return info
+}
+
+// introspect returns a description of opt for use in a CommandInfo.
+func (opt *Option) introspect() OptionInfo {
+	info := OptionInfo{
+		Name:           opt.Name,
+		Aliases:        opt.Aliases,
+		Type:           opt.Type.String(),
+		Default:        opt.Default,
+		Description:    opt.Description,
+		RequireValue:   opt.RequireValue,
+		AllowedValues:  opt.AllowedValues,
+		ValueHint:      opt.valueHint,
+		Mandatory:      opt.mandatory,
+		MandatoryOnCLI: opt.mandatoryCLI,
+		Sensitive:      opt.sensitive,
+		Local:          opt.local,
+		Deprecation:    opt.Deprecation,
+		Replacement:    opt.Replacement,
+	}
+	if opt.Shorthand > 0 {
+		info.Shorthand = string(opt.Shorthand)
+	}
+	if opt.Type == OptionTypeBool {
+		info.Default = "false"
+		if BoolValue(opt.Default) {
+			info.Default = "true"
+		}
+	}
+	return info
+}
+
+// MarshalJSON satisfies the json.Marshaler interface, encoding the result of
+// Introspect.
+func (cmd *Command) MarshalJSON() ([]byte, error) {
+	return json.Marshal(cmd.Introspect())
+}
@@ -0,0 +1,76 @@
+package mybase
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestIntrospect(t *testing.T) {
+	suite := simpleCommandSuite()
+	suite.AddOption(EnumOption("mode", 'm', "fast", []string{"fast", "safe"}, "dummy description").Mandatory())
+	one := suite.SubCommands["one"]
+	one.AddExample("mycommand one --newopt=x", "Runs one")
+	one.SetLongHelp("Details about one.")
+	one.AddArg("target", "", true).Describe("What to act upon")
+
+	info := suite.Introspect()
+	if info.Name != "mycommand" || info.Version != "summary" || info.Summary != "" || info.FullName != "mycommand" {
+		t.Errorf("Unexpected top-level info: %+v", info)
+	}
+	for _, sub := range info.SubCommands {
+		if sub.Name == "help" || sub.Name == "version" {
+			t.Errorf("Expected built-in subcommand %s to be omitted", sub.Name)
+		}
+	}
+	if len(info.OptionGroups) == 0 {
+		t.Fatal("Expected top-level command to have option groups")
+	}
+	var foundMode, foundBool bool
+	for _, grp := range info.OptionGroups {
+		for _, opt := range grp.Options {
+			if opt.Name == "hidden" {
+				t.Error("Expected hidden option to be omitted")
+			} else if opt.Name == "mode" {
+				foundMode = opt.Type == "enum" && opt.Shorthand == "m" && opt.Default == "fast" && len(opt.AllowedValues) == 2 && opt.Mandatory
+			} else if opt.Name == "truthybool" {
+				foundBool = opt.Type == "bool" && opt.Default == "true" && !opt.RequireValue
+			}
+		}
+	}
+	if !foundMode || !foundBool {
+		t.Errorf("Unexpected option info: %+v", info.OptionGroups)
+	}
+
+	var oneInfo CommandInfo
+	for _, sub := range info.SubCommands {
+		if sub.Name == "one" {
+			oneInfo = sub
+		}
+	}
+	if oneInfo.FullName != "mycommand one" || oneInfo.LongHelp != "Details about one." || len(oneInfo.Examples) != 1 {
+		t.Errorf("Unexpected subcommand info: %+v", oneInfo)
+	}
+	if len(oneInfo.Args) != 1 || oneInfo.Args[0].Name != "target" || !oneInfo.Args[0].Required || oneInfo.Args[0].Type != "string" {
+		t.Errorf("Unexpected arg info: %+v", oneInfo.Args)
+	}
+
+	// MarshalJSON encodes the same information, and can be decoded back
+	b, err := json.Marshal(suite)
+	if err != nil {
+		t.Fatalf("Unexpected error from json.Marshal: %v", err)
+	}
+	for _, expected := range []string{`"full_name":"mycommand one"`, `"allowed_values":["fast","safe"]`, `"command_line":"mycommand one --newopt=x"`} {
+		if !strings.Contains(string(b), expected) {
+			t.Errorf("Expected JSON to contain %s, but it did not: %s", expected, b)
+		}
+	}
+	var decoded CommandInfo
+	if err := json.Unmarshal(b, &decoded); err != nil || len(decoded.SubCommands) != len(info.SubCommands) {
+		t.Errorf("Unable to round-trip JSON: %v", err)
+	}
+
+	if OptionTypeTemplate.String() != "template" || OptionType(99).String() != "unknown" {
+		t.Error("Unexpected result from OptionType.String")
+	}
+}
@@ -0,0 +1,626 @@
+package mybase
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// LintSeverity indicates how serious a LintProblem is.
+type LintSeverity int
+
+// Constants representing different LintSeverity enumerated values.
+const (
+	LintNotice  LintSeverity = iota // Stylistic problem which does not affect behavior
+	LintWarning                     // Likely mistake, which does not prevent use of the file
+	LintError                       // Problem which would cause File.Parse to fail or an option value to be invalid
+)
+
+// String returns a lowercase description of the severity.
+func (sev LintSeverity) String() string {
+	switch sev {
+	case LintNotice:
+		return "notice"
+	case LintWarning:
+		return "warning"
+	default:
+		return "error"
+	}
+}
+
+// LintProblem describes a problem found by File.Lint.
+type LintProblem struct {
+	Severity   LintSeverity
+	LineNumber int    // Line number of the problem, or 0 if not specific to a line
+	Section    string // Name of the section containing the problem, or "" for the default section
+	Option     string // Name of the option involved, if any
+	Message    string
+	Fix        string // Suggested fix, if any, e.g. "replace with \"visible\""
+}
+
+// String returns a human-readable description of the problem, including its
+// suggested fix if any.
+func (lp LintProblem) String() string {
+	if lp.Fix != "" {
+		return fmt.Sprintf("line %d: %s: %s (suggested fix: %s)", lp.LineNumber, lp.Severity, lp.Message, lp.Fix)
+	}
+	return fmt.Sprintf("line %d: %s: %s", lp.LineNumber, lp.Severity, lp.Message)
+}
+
+// Lint examines the file's contents without applying them, returning any
+// problems found, ordered by line number. The file does not need to have been
+// parsed, and its IgnoreUnknownOptions and IgnoreOptions settings are not
+// applied, but any ignored option names are still skipped. If the file's
+// contents were not already loaded via Read, they are read from disk, and any
+// problem doing so is returned as an error. Only ini-style option files are
+// supported; an error is returned for other formats.
+//
+// Problems reported include malformed lines; unknown options; deprecated
+// options; options set multiple times in the same section, or in a section not
+// permitted by Option.OnlyInSections; values that are missing, malformed for
+// the option's type, or rejected by the option's transform function;
+// suspicious values, such as one truncated by a "#" which begins a comment;
+// empty sections; references to nonexistent sections in !inherit directives;
+// trailing whitespace; and inconsistent spacing around "=". If any environment
+// names are supplied, named sections which would never be selected by those
+// environments (directly or via inheritance) are also reported as unknown.
+// If the file has multiple sections selected via UseSection, lines whose
+// values are shadowed by a higher-precedence selected section are reported as
+// well. Where possible, each problem includes a suggested fix.
+func (f *File) Lint(cfg *Config, environments ...string) ([]LintProblem, error) {
+	if f.syntax() != FileFormatINI {
+		return nil, fmt.Errorf("Unable to lint %s: only ini-style option files are supported", f.Path())
+	}
+	r, err := f.rawContents()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	f.mu.RLock()
+	ignored := make(map[string]bool, len(f.ignoredOptionNames))
+	for name := range f.ignoredOptionNames {
+		ignored[name] = true
+	}
+	selectedSections := append([]string{}, f.selected...)
+	repeatedHeaders := f.RepeatedSections
+	f.mu.RUnlock()
+	eval := f.conditions()
+	var conds conditionStack
+
+	type lintSection struct {
+		headerLine  int
+		optionLines map[string][]int // option name => line numbers where set
+		inherits    []string
+		inheritLine []int
+		hasContent  bool
+	}
+	sections := map[string]*lintSection{"": {optionLines: make(map[string][]int), hasContent: true}}
+	sectionOrder := []string{""}
+	var problems []LintProblem
+	var currentName string
+	current := sections[""]
+	var spacedStyle *bool // nil until the first key=value line establishes a style
+	add := func(sev LintSeverity, lineNumber int, option, format string, args ...interface{}) {
+		problems = append(problems, LintProblem{
+			Severity:   sev,
+			LineNumber: lineNumber,
+			Section:    currentName,
+			Option:     option,
+			Message:    fmt.Sprintf(format, args...),
+		})
+	}
+	suggest := func(format string, args ...interface{}) { // sets Fix of the most recently added problem
+		problems[len(problems)-1].Fix = fmt.Sprintf(format, args...)
+	}
+
+	scanner := newLineScanner(r, f.maxLineLength())
+	for scanner.Scan() {
+		lineNumber, line := scanner.lineNumber, scanner.text
+		for n, physical := range scanner.physical {
+			if strings.TrimRightFunc(physical, unicode.IsSpace) != physical {
+				add(LintNotice, lineNumber+n, "", "trailing whitespace")
+				suggest("remove trailing whitespace")
+			}
+		}
+		parsedLine, err := parseLine(line)
+		if err != nil {
+			add(LintError, lineNumber, "", "%s", err)
+			continue
+		}
+		// Lines in inactive branches of conditional blocks are ignored by Parse,
+		// so they are not checked
+		if parsedLine.kind == lineTypeDirective && isConditionalDirective(parsedLine.key) {
+			if err := conds.apply(parsedLine.key, parsedLine.value, eval, lineNumber); err != nil {
+				add(LintError, lineNumber, "", "%s", err)
+			}
+			continue
+		} else if !conds.active() {
+			continue
+		}
+		switch parsedLine.kind {
+		case lineTypeSectionHeader:
+			currentName = parsedLine.sectionName
+			if parsedLine.repeated || (repeatedHeaders && currentName != "" && sections[currentName] != nil) {
+				// Each repeated section is separate, so it is only checked on its own
+				current = &lintSection{headerLine: lineNumber, optionLines: make(map[string][]int)}
+				continue
+			}
+			if sections[currentName] == nil {
+				sections[currentName] = &lintSection{headerLine: lineNumber, optionLines: make(map[string][]int)}
+				sectionOrder = append(sectionOrder, currentName)
+			}
+			current = sections[currentName]
+		case lineTypeDirective:
+			current.hasContent = true
+			switch {
+			case isIncludeDirective(parsedLine.key) && parsedLine.value == "":
+				add(LintError, lineNumber, "", "!%s directive missing path", parsedLine.key)
+			case isIncludeDirective(parsedLine.key):
+				// Included files are not linted
+			case !isInheritDirective(parsedLine.key):
+				add(LintError, lineNumber, "", "unknown directive !%s", parsedLine.key)
+			case parsedLine.value == "":
+				add(LintError, lineNumber, "", "!%s directive missing section name", parsedLine.key)
+			default:
+				current.inherits = append(current.inherits, parsedLine.value)
+				current.inheritLine = append(current.inheritLine, lineNumber)
+			}
+		case lineTypeKeyOnly, lineTypeKeyValue:
+			current.hasContent = true
+			if parsedLine.kind == lineTypeKeyValue {
+				spaced := hasSpaceAroundEquals(line)
+				if spacedStyle == nil {
+					spacedStyle = &spaced
+				} else if spaced != *spacedStyle {
+					add(LintNotice, lineNumber, parsedLine.key, "inconsistent spacing around \"=\" compared to earlier lines")
+					if *spacedStyle {
+						suggest("use \"%s = %s\"", parsedLine.key, parsedLine.value)
+					} else {
+						suggest("use \"%s=%s\"", parsedLine.key, parsedLine.value)
+					}
+				}
+			}
+			if ignored[parsedLine.key] {
+				continue
+			}
+			opt := cfg.FindOption(parsedLine.key)
+			if opt == nil {
+				if parsedLine.isLoose {
+					add(LintWarning, lineNumber, parsedLine.key, "unknown option %q (ignored due to loose- prefix)", parsedLine.key)
+				} else {
+					add(LintError, lineNumber, parsedLine.key, "unknown option %q", parsedLine.key)
+				}
+				if suggestion := optionSuggestion(parsedLine.key, cfg.CLI.Command.Options()); suggestion != "" {
+					suggest("replace with %q", suggestion)
+				}
+				continue
+			}
+			if prevLines := current.optionLines[opt.Name]; len(prevLines) > 0 {
+				add(LintWarning, lineNumber, opt.Name, "option %s already set on line %d in the same section; this value overrides it", opt.Name, prevLines[len(prevLines)-1])
+				suggest("remove line %d", prevLines[len(prevLines)-1])
+			}
+			current.optionLines[opt.Name] = append(current.optionLines[opt.Name], lineNumber)
+			if opt.Deprecation != "" {
+				add(LintWarning, lineNumber, opt.Name, "option %s is deprecated: %s", opt.Name, opt.Deprecation)
+				if opt.Replacement != "" {
+					suggest("replace with %q", opt.Replacement)
+				} else {
+					suggest("remove this line")
+				}
+			}
+			if !opt.permittedInSection(currentName) {
+				add(LintError, lineNumber, opt.Name, "option %s may not be set in this section; only permitted in sections %q", opt.Name, opt.sections)
+				if len(opt.sections) == 1 {
+					suggest("move to section [%s]", opt.sections[0])
+				}
+			}
+			if parsedLine.kind == lineTypeKeyOnly && opt.RequireValue {
+				add(LintError, lineNumber, opt.Name, "missing required value for option %s", opt.Name)
+				suggest("supply a value, e.g. \"%s=<value>\"", parsedLine.key)
+				continue
+			}
+			if opt.Type == OptionTypeBool && parsedLine.kind == lineTypeKeyValue && !isBoolLiteral(unquote(parsedLine.value)) {
+				add(LintWarning, lineNumber, opt.Name, "value %q for boolean option %s will be interpreted as true", parsedLine.value, opt.Name)
+				suggest("use a value of 1, 0, true, false, on, or off")
+			}
+			if pos := strings.LastIndex(line, "#"+parsedLine.comment); parsedLine.kind == lineTypeKeyValue && parsedLine.comment != "" && pos > 0 && !unicode.IsSpace(rune(line[pos-1])) && !strings.HasSuffix(line[:pos], "=") {
+				add(LintWarning, lineNumber, opt.Name, "value of option %s is truncated at \"#\", since the remainder of the line is treated as a comment", opt.Name)
+				suggest("quote the value, e.g. %s='%s'", parsedLine.key, strings.TrimSpace(line[strings.Index(line, "=")+1:]))
+			} else if tokens := strings.SplitN(unquote(parsedLine.value), "=", 2); len(tokens) == 2 && cfg.FindOption(strings.TrimSpace(tokens[0])) != nil {
+				add(LintWarning, lineNumber, opt.Name, "value of option %s appears to set option %s; is a line break missing?", opt.Name, strings.TrimSpace(tokens[0]))
+				suggest("move \"%s\" to its own line", unquote(parsedLine.value))
+			}
+			if err := opt.checkValue(parsedLine.value); err != nil {
+				add(LintError, lineNumber, opt.Name, "invalid value for option %s: %s", opt.Name, err)
+			} else if opt.transform != nil {
+				ctx := TransformContext{Option: opt, Source: f, Dir: f.Dir}
+				if _, err := opt.transform(unquote(parsedLine.value), ctx); err != nil {
+					add(LintError, lineNumber, opt.Name, "invalid value for option %s: %s", opt.Name, err)
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if lineNumber, err := conds.unterminated(); err != nil {
+		add(LintError, lineNumber, "", "%s", err)
+	}
+
+	// Checks that require the entire file to have been scanned
+	for _, name := range sectionOrder {
+		section := sections[name]
+		currentName = name
+		if !section.hasContent {
+			add(LintWarning, section.headerLine, "", "section [%s] is empty", name)
+			suggest("remove the section header")
+		}
+		for n, parent := range section.inherits {
+			if sections[parent] == nil {
+				add(LintError, section.inheritLine[n], "", "section [%s] inherits from nonexistent section [%s]", name, parent)
+				if suggestion := closestMatch(parent, sectionOrder[1:]); suggestion != "" {
+					suggest("replace with \"!inherit %s\"", suggestion)
+				}
+			}
+		}
+	}
+	if len(environments) > 0 {
+		selected := make(map[string]bool)
+		var selectWithParents func(name string)
+		selectWithParents = func(name string) {
+			if section := sections[name]; section != nil && !selected[name] {
+				selected[name] = true
+				for _, parent := range section.inherits {
+					selectWithParents(parent)
+				}
+			}
+		}
+		for _, env := range environments {
+			selectWithParents(env)
+		}
+		for _, name := range sectionOrder {
+			if name != "" && !selected[name] {
+				currentName = name
+				add(LintWarning, sections[name].headerLine, "", "unknown section [%s] is not used by any of the supplied environments", name)
+				if suggestion := closestMatch(name, environments); suggestion != "" && sections[suggestion] == nil {
+					suggest("rename to [%s]", suggestion)
+				}
+			}
+		}
+	}
+
+	// Values in selected sections which are shadowed by a higher-precedence
+	// selected section, taking inheritance into account
+	if len(selectedSections) > 1 {
+		var chain []string
+		visited := make(map[string]bool)
+		var expand func(name string)
+		expand = func(name string) {
+			if section := sections[name]; section != nil && !visited[name] {
+				visited[name] = true
+				chain = append(chain, name)
+				for n := len(section.inherits) - 1; n >= 0; n-- {
+					expand(section.inherits[n])
+				}
+			}
+		}
+		for _, name := range selectedSections {
+			expand(name)
+		}
+		owners := make(map[string]string) // option name => name of section supplying its value
+		for _, name := range chain {
+			currentName = name
+			optionNames := make([]string, 0, len(sections[name].optionLines))
+			for optionName := range sections[name].optionLines {
+				optionNames = append(optionNames, optionName)
+			}
+			sort.Strings(optionNames)
+			for _, optionName := range optionNames {
+				owner, shadowed := owners[optionName]
+				if !shadowed {
+					owners[optionName] = name
+					continue
+				}
+				ownerLines := sections[owner].optionLines[optionName]
+				for _, lineNumber := range sections[name].optionLines[optionName] {
+					add(LintNotice, lineNumber, optionName, "value of option %s is shadowed by section [%s] on line %d, which takes precedence", optionName, owner, ownerLines[len(ownerLines)-1])
+					suggest("remove this line")
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].LineNumber < problems[j].LineNumber
+	})
+	return problems, nil
+}
+
+// FormatStyle controls the output of File.Format.
+type FormatStyle struct {
+	SortOptions    bool // if true, options within each section are sorted by name; otherwise original order is retained
+	AlignEquals    bool // if true, "=" is surrounded by spaces and aligned within each section
+	NormalizeBools bool // if true, values of boolean options such as "true", "on", or "off" are written as "1" or "0"
+}
+
+// Format writes the file's contents to w in a canonical style, without
+// changing semantics: parsing the output yields the same sections and values
+// as parsing the original. Option names are normalized to lowercase with
+// dashes; whitespace is normalized; blank lines are removed except for a single
+// blank line between sections; and if a section appears multiple times in the
+// original, its contents are combined at its first appearance. Values which
+// span multiple lines via trailing backslashes are joined onto a single line.
+// Comments are retained: full-line comments remain attached to the line that
+// follows them, and inline comments remain on their line.
+//
+// Since an included file may set options in any section, the position of an
+// !include or !includedir directive relative to other lines is significant.
+// Options are never sorted across such a directive, and if the file contains
+// any such directives, repeated sections are not combined. The same applies to
+// conditional directives such as !if and !endif, and if the file contains any
+// of these, no directives are moved to the top of their section.
+//
+// If style.NormalizeBools is true, values of boolean options are rewritten
+// using "1" for true and "0" for false. This requires knowledge of each
+// option's type, so it only affects files which have been parsed, and only
+// values which are conventional boolean spellings; bare option names without a
+// value are left as-is. Otherwise, the file does not need to have been parsed.
+//
+// If the file's contents were not already loaded via Read, they are read from
+// disk. An error is returned if the contents cannot be read, or contain a
+// malformed line, or if the file is not an ini-style option file.
+func (f *File) Format(w io.Writer, style FormatStyle) error {
+	if f.syntax() != FileFormatINI {
+		return fmt.Errorf("Unable to format %s: only ini-style option files are supported", f.Path())
+	}
+	r, err := f.rawContents()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	var lines []string
+	var hasIncludes, hasConditionals bool
+	scanner := newLineScanner(r, f.maxLineLength())
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.text)
+		parsedLine, err := parseLine(line)
+		if err != nil {
+			return FileParseFormatError{Problem: err.Error(), FilePath: f.Path(), LineNumber: scanner.lineNumber}
+		}
+		if parsedLine.kind == lineTypeDirective && isIncludeDirective(parsedLine.key) {
+			hasIncludes = true
+		} else if parsedLine.kind == lineTypeDirective && isConditionalDirective(parsedLine.key) {
+			hasIncludes, hasConditionals = true, true
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	type formatItem struct {
+		comments []string // full-line comments preceding this item
+		key      string   // normalized option name, for sorting
+		name     string   // option name as written, including any prefixes; or full text of a directive
+		value    string
+		hasValue bool
+		comment  string // inline comment, including leading "#"
+		barrier  bool   // true for directives which items must not be sorted across
+	}
+	type formatSection struct {
+		header     string
+		directives []string
+		items      []formatItem
+		pending    []string // full-line comments not yet attached to any item
+		trailing   []string // full-line comments at the end of the section
+	}
+	current := &formatSection{}
+	sections := map[string]*formatSection{"": current}
+	sectionOrder := []*formatSection{current}
+	var currentName string
+	var boolOptions map[string]map[string]bool // section name => set of option names and aliases which are booleans
+	if style.NormalizeBools {
+		boolOptions = f.boolOptionNames()
+	}
+
+	for _, line := range lines {
+		parsedLine, _ := parseLine(line) // already parsed successfully above
+		var inlineComment string
+		if parsedLine.kind != lineTypeComment && parsedLine.comment != "" {
+			inlineComment = "#" + parsedLine.comment
+			line = strings.TrimSpace(strings.TrimSuffix(line, inlineComment))
+		}
+		switch parsedLine.kind {
+		case lineTypeComment:
+			current.pending = append(current.pending, line)
+		case lineTypeSectionHeader:
+			current.trailing = append(current.trailing, current.pending...)
+			current.pending = nil
+			name := parsedLine.sectionName
+			currentName = name
+			if parsedLine.repeated || (f.RepeatedSections && name != "" && sections[name] != nil) {
+				header := "[" + name + "]"
+				if parsedLine.repeated {
+					header = "[[" + name + "]]"
+				}
+				current = &formatSection{header: joinComment(header, inlineComment)}
+				sectionOrder = append(sectionOrder, current)
+				continue
+			}
+			if sections[name] == nil || hasIncludes {
+				sections[name] = &formatSection{header: joinComment("["+name+"]", inlineComment)}
+				sectionOrder = append(sectionOrder, sections[name])
+			}
+			current = sections[name]
+		case lineTypeDirective:
+			directive := strings.TrimSpace(fmt.Sprintf("!%s %s", parsedLine.key, parsedLine.value))
+			if isIncludeDirective(parsedLine.key) || hasConditionals {
+				current.items = append(current.items, formatItem{comments: current.pending, name: directive, barrier: true})
+			} else {
+				current.directives = append(current.directives, current.pending...)
+				current.directives = append(current.directives, directive)
+			}
+			current.pending = nil
+		case lineTypeKeyOnly, lineTypeKeyValue:
+			tokens := strings.SplitN(line, "=", 2)
+			item := formatItem{
+				comments: current.pending,
+				key:      parsedLine.key,
+				name:     canonicalOptionName(strings.TrimSpace(tokens[0])),
+				hasValue: len(tokens) > 1,
+				comment:  inlineComment,
+			}
+			if item.hasValue {
+				item.value = strings.TrimSpace(tokens[1])
+				if boolOptions[currentName][parsedLine.key] && isBoolLiteral(unquote(item.value)) {
+					if BoolValue(unquote(item.value)) {
+						item.value = "1"
+					} else {
+						item.value = "0"
+					}
+				}
+			}
+			current.items = append(current.items, item)
+			current.pending = nil
+		}
+	}
+	current.trailing = append(current.trailing, current.pending...)
+
+	bw := bufio.NewWriter(w)
+	var wroteAny bool
+	for n, section := range sectionOrder {
+		if n == 0 && len(section.directives)+len(section.items)+len(section.trailing) == 0 {
+			continue
+		}
+		if wroteAny {
+			bw.WriteString("\n")
+		}
+		wroteAny = true
+		if n > 0 {
+			fmt.Fprintln(bw, section.header)
+		}
+		for _, directive := range section.directives {
+			fmt.Fprintln(bw, directive)
+		}
+		if style.SortOptions {
+			// Sort each run of items between barriers separately
+			for start := 0; start < len(section.items); start++ {
+				end := start
+				for end < len(section.items) && !section.items[end].barrier {
+					end++
+				}
+				run := section.items[start:end]
+				sort.SliceStable(run, func(i, j int) bool {
+					return run[i].key < run[j].key
+				})
+				start = end
+			}
+		}
+		var maxLen int
+		for _, item := range section.items {
+			if item.hasValue && len(item.name) > maxLen {
+				maxLen = len(item.name)
+			}
+		}
+		for _, item := range section.items {
+			for _, comment := range item.comments {
+				fmt.Fprintln(bw, comment)
+			}
+			line := item.name
+			if item.hasValue && style.AlignEquals {
+				line = fmt.Sprintf("%-*s = %s", maxLen, item.name, item.value)
+			} else if item.hasValue {
+				line = fmt.Sprintf("%s=%s", item.name, item.value)
+			}
+			fmt.Fprintln(bw, strings.TrimRightFunc(joinComment(line, item.comment), unicode.IsSpace))
+		}
+		for _, comment := range section.trailing {
+			fmt.Fprintln(bw, comment)
+		}
+	}
+	return bw.Flush()
+}
+
+// rawContents returns a reader for the file's raw contents: from memory if
+// already read, or from disk otherwise.
+func (f *File) rawContents() (io.ReadCloser, error) {
+	f.mu.RLock()
+	alreadyRead, contents := f.read, f.contents
+	f.mu.RUnlock()
+	if alreadyRead {
+		return ioutil.NopCloser(strings.NewReader(contents)), nil
+	}
+	return f.open()
+}
+
+// boolOptionNames returns the names and aliases of boolean options which were
+// set in each section when the file was parsed. Returns nil if the file has not
+// been parsed.
+func (f *File) boolOptionNames() map[string]map[string]bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if !f.parsed {
+		return nil
+	}
+	result := make(map[string]map[string]bool, len(f.sections))
+	for _, section := range f.sections {
+		names := make(map[string]bool)
+		for _, opt := range section.opts {
+			if opt.Type == OptionTypeBool {
+				names[opt.Name] = true
+				for _, alias := range opt.Aliases {
+					names[canonicalOptionName(alias)] = true
+				}
+			}
+		}
+		result[section.Name] = names
+	}
+	return result
+}
+
+// joinComment appends an inline comment to a line, if the comment is non-empty.
+func joinComment(line, comment string) string {
+	if comment == "" {
+		return line
+	}
+	return line + " " + comment
+}
+
+// hasSpaceAroundEquals returns true if the first "=" in line is immediately
+// preceded or followed by whitespace.
+func hasSpaceAroundEquals(line string) bool {
+	pos := strings.Index(line, "=")
+	if pos < 0 {
+		return false
+	}
+	return (pos > 0 && unicode.IsSpace(rune(line[pos-1]))) || (pos < len(line)-1 && unicode.IsSpace(rune(line[pos+1])))
+}
+
+// isIncludeDirective returns true if the directive name is one which causes
+// another file or directory of files to be parsed.
+func isIncludeDirective(name string) bool {
+	return name == "include" || name == "includedir"
+}
+
+// isInheritDirective returns true if the directive name is one which causes
+// the current section to inherit values from another section. "!extends" is
+// accepted as a synonym for "!inherit".
+func isInheritDirective(name string) bool {
+	return name == "inherit" || name == "extends"
+}
+
+// isBoolLiteral returns true if value is one of the strings conventionally
+// used to represent a boolean.
+func isBoolLiteral(value string) bool {
+	switch strings.ToLower(value) {
+	case "", "0", "1", "on", "off", "true", "false":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,208 @@
+package mybase
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileLint(t *testing.T) {
+	cmd := simpleCommand()
+	cmd.AddOption(StringOption("strict", 0, "", "dummy").SetTransform(func(value string, ctx TransformContext) (string, error) {
+		if value == "bad" {
+			return "", errors.New("value is bad")
+		}
+		return value, nil
+	}))
+	cfg := ParseFakeCLI(t, cmd, "mycommand arg1")
+	contents := `visible=foo
+nonexistent=1
+loose-alsononexistent=1
+bool1 = maybe
+hasshort
+[production]
+visible=1
+visible=2 
+strict=bad
+[staging]
+!inherit production
+!inherit nowhere
+[unused]
+[broken
+`
+	f, _ := getParsedFile(cfg, true, "")
+	f.contents, f.read = contents, true
+	problems, err := f.Lint(cfg, "staging")
+	if err != nil {
+		t.Fatalf("Unexpected error from Lint: %v", err)
+	}
+	expected := []struct {
+		severity   LintSeverity
+		lineNumber int
+	}{
+		{LintError, 2},    // unknown option, even though IgnoreUnknownOptions is enabled
+		{LintWarning, 3},  // unknown loose- option
+		{LintNotice, 4},   // inconsistent spacing
+		{LintWarning, 4},  // non-boolean value for boolean option
+		{LintError, 5},    // missing required value
+		{LintNotice, 8},   // trailing whitespace
+		{LintWarning, 8},  // duplicate
+		{LintError, 9},    // rejected by transform
+		{LintError, 12},   // nonexistent parent section
+		{LintWarning, 13}, // empty section
+		{LintWarning, 13}, // section not used by supplied environments
+		{LintError, 14},   // malformed section header
+	}
+	if len(problems) != len(expected) {
+		t.Fatalf("Expected %d problems, instead found %d: %+v", len(expected), len(problems), problems)
+	}
+	for n, problem := range problems {
+		if problem.Severity != expected[n].severity || problem.LineNumber != expected[n].lineNumber {
+			t.Errorf("problems[%d]: expected %s on line %d, instead found %s", n, expected[n].severity, expected[n].lineNumber, problem)
+		}
+	}
+
+	// Suggested fixes, deprecated options, suspicious values, and shadowing
+	cmd.AddOption(StringOption("oldname", 0, "", "dummy").Deprecated("visible", ""))
+	cfg = ParseFakeCLI(t, cmd, "mycommand arg1")
+	f, _ = getParsedFile(cfg, true, "hasshort=base\n[prod]\nhasshort=1\n")
+	f.UseSection("prod")
+	f.contents, f.read = `hasshort=base
+[prod]
+visibel=1
+oldname=2
+hasshort=a#b
+visible=bool1=1
+[staging]
+!inherit prd
+[qa2]
+bool1
+`, true
+	problems, err = f.Lint(cfg, "staging", "qa")
+	if err != nil {
+		t.Fatalf("Unexpected error from Lint: %v", err)
+	}
+	expectedFixes := []struct {
+		lineNumber int
+		fix        string
+	}{
+		{1, "remove this line"},                     // hasshort shadowed by selected section [prod]
+		{2, ""},                                     // section not used by supplied environments
+		{3, `replace with "visible"`},               // misspelled option
+		{4, `replace with "visible"`},               // deprecated option
+		{5, "quote the value, e.g. hasshort='a#b'"}, // value truncated by comment
+		{6, `move "bool1=1" to its own line`},       // value which looks like another option
+		{8, `replace with "!inherit prod"`},         // misspelled parent section
+		{9, "rename to [qa]"},                       // misspelled environment name
+	}
+	if len(problems) != len(expectedFixes) {
+		t.Fatalf("Expected %d problems, instead found %d: %+v", len(expectedFixes), len(problems), problems)
+	}
+	for n, problem := range problems {
+		if problem.LineNumber != expectedFixes[n].lineNumber || problem.Fix != expectedFixes[n].fix {
+			t.Errorf("problems[%d]: expected line %d with fix %q, instead found %s", n, expectedFixes[n].lineNumber, expectedFixes[n].fix, problem)
+		}
+	}
+
+	// A clean file should have no problems
+	f, _ = getParsedFile(cfg, false, "")
+	f.contents, f.read = "visible=foo\n[production]\nbool1\n", true
+	if problems, err := f.Lint(cfg, "production"); err != nil || len(problems) > 0 {
+		t.Errorf("Expected no problems, instead found %+v / %v", problems, err)
+	}
+}
+
+// TestFileFormat confirms that formatting never changes semantics, for every
+// fixture file in testdata/format and every combination of style settings.
+func TestFileFormat(t *testing.T) {
+	cfg := ParseFakeCLI(t, simpleCommand(), "mycommand arg1")
+	parse := func(path string, contents []byte) *File {
+		t.Helper()
+		f := NewFile(path)
+		f.IgnoreUnknownOptions = true
+		f.contents, f.read = string(contents), true
+		if err := f.Parse(cfg); err != nil {
+			t.Fatalf("Unexpected error from Parse: %v\n%s", err, contents)
+		}
+		return f
+	}
+
+	paths, err := filepath.Glob(filepath.Join("testdata", "format", "*.cnf"))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("Unable to find fixture files: %v", err)
+	}
+	styles := []FormatStyle{{}, {SortOptions: true}, {AlignEquals: true}, {SortOptions: true, AlignEquals: true}}
+	for _, path := range paths {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Unable to read %s: %v", path, err)
+		}
+		original := parse(path, contents)
+		for _, style := range styles {
+			var buf bytes.Buffer
+			if err := NewFile(path).Format(&buf, style); err != nil {
+				t.Fatalf("Unexpected error from Format on %s: %v", path, err)
+			}
+			formatted := buf.Bytes()
+			if !original.SameContents(parse(path, formatted)) {
+				t.Errorf("Formatting %s with %+v changed its semantics; formatted output:\n%s", path, style, formatted)
+			}
+
+			// Formatting should be idempotent
+			f := NewFile(path)
+			f.contents, f.read = string(formatted), true
+			buf.Reset()
+			if err := f.Format(&buf, style); err != nil {
+				t.Fatalf("Unexpected error from Format: %v", err)
+			} else if !bytes.Equal(formatted, buf.Bytes()) {
+				t.Errorf("Formatting %s with %+v is not idempotent:\n%s\n---\n%s", path, style, formatted, buf.Bytes())
+			}
+		}
+	}
+
+	// Boolean values are normalized only for boolean options, and only if the
+	// file has been parsed
+	contents := "bool1=true\nvisible=on\n[foo]\nskip-bool2 = OFF # comment\nbool1\nloose-bool1='false'\nbool1=maybe\n"
+	f := NewFile("/tmp/fake.cnf")
+	f.contents, f.read = contents, true
+	var buf bytes.Buffer
+	if err := f.Format(&buf, FormatStyle{NormalizeBools: true}); err != nil {
+		t.Fatalf("Unexpected error from Format: %v", err)
+	} else if strings.Contains(buf.String(), "=1") || strings.Contains(buf.String(), "=0") {
+		t.Errorf("Expected unparsed file to be unaffected by NormalizeBools, instead found:\n%s", buf.String())
+	}
+	if err := f.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error from Parse: %v", err)
+	}
+	buf.Reset()
+	if err := f.Format(&buf, FormatStyle{NormalizeBools: true}); err != nil {
+		t.Fatalf("Unexpected error from Format: %v", err)
+	}
+	expected := "bool1=1\nvisible=on\n\n[foo]\nskip-bool2=0 # comment\nbool1\nloose-bool1=0\nbool1=maybe\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected output from Format with NormalizeBools:\n%s", buf.String())
+	}
+	formatted := parse("/tmp/fake.cnf", buf.Bytes())
+	for _, section := range []string{"", "foo"} {
+		cfg := ParseFakeCLI(t, simpleCommand(), "mycommand arg1")
+		original := parse("/tmp/fake.cnf", []byte(contents))
+		original.UseSection(section)
+		formatted.UseSection(section)
+		cfgOriginal, cfgFormatted := NewConfig(cfg.CLI, original), NewConfig(cfg.CLI, formatted)
+		for _, name := range []string{"bool1", "bool2"} {
+			if cfgOriginal.GetBool(name) != cfgFormatted.GetBool(name) {
+				t.Errorf("Formatting with NormalizeBools changed value of %s in section %q", name, section)
+			}
+		}
+	}
+
+	// Malformed files cannot be formatted
+	f = NewFile("/tmp/fake.cnf")
+	f.contents, f.read = "[broken\n", true
+	if err := f.Format(&bytes.Buffer{}, FormatStyle{}); err == nil {
+		t.Error("Expected Format of malformed file to fail, but err is nil")
+	}
+}
@@ -0,0 +1,81 @@
+package mybase
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// Logger is the interface used by this package for logging. Each method
+// receives a message, followed by any number of alternating keys and values
+// providing structured context, for example "path", "/etc/myapp.cnf". Keys are
+// always strings.
+//
+// The method signatures match those of *slog.Logger from Go 1.21+, so a
+// *slog.Logger may be supplied to SetLogger directly. Other structured logging
+// packages, such as zap or logrus, may be used via a small adapter type.
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Info(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+}
+
+var (
+	loggerMu      sync.RWMutex
+	packageLogger Logger = stdLogger{}
+)
+
+// SetLogger changes the Logger used by this package. This affects messages
+// about files being parsed or written, as well as debug-level details of how
+// Configs resolve option values. It also affects warnings reported via
+// Config.Warn, such as use of deprecated options, for any Config which lacks a
+// WarningHandler. Supplying nil restores the default Logger, which uses the
+// standard library's log package for info and warn levels, and discards debug
+// messages.
+//
+// Logger methods may be called while internal locks are held, so they must not
+// call methods of any Config or File.
+func SetLogger(logger Logger) {
+	if logger == nil {
+		logger = stdLogger{}
+	}
+	loggerMu.Lock()
+	defer loggerMu.Unlock()
+	packageLogger = logger
+}
+
+// currentLogger returns the Logger set via SetLogger, or the default Logger.
+func currentLogger() Logger {
+	loggerMu.RLock()
+	defer loggerMu.RUnlock()
+	return packageLogger
+}
+
+// stdLogger is the default Logger, which uses the standard library's log
+// package. Structured context is appended to the message in key=value form.
+type stdLogger struct{}
+
+func (stdLogger) Debug(msg string, keysAndValues ...interface{}) {}
+
+func (stdLogger) Info(msg string, keysAndValues ...interface{}) {
+	log.Print(formatLogMessage(msg, keysAndValues))
+}
+
+func (stdLogger) Warn(msg string, keysAndValues ...interface{}) {
+	log.Print(formatLogMessage(msg, keysAndValues))
+}
+
+// formatLogMessage returns msg followed by keysAndValues in key=value form.
+func formatLogMessage(msg string, keysAndValues []interface{}) string {
+	var b strings.Builder
+	b.WriteString(msg)
+	for n := 0; n < len(keysAndValues); n += 2 {
+		if n+1 < len(keysAndValues) {
+			fmt.Fprintf(&b, " %v=%v", keysAndValues[n], keysAndValues[n+1])
+		} else {
+			fmt.Fprintf(&b, " %v", keysAndValues[n])
+		}
+	}
+	return b.String()
+}
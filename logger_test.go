@@ -0,0 +1,86 @@
+package mybase
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingLogger is a Logger which stores messages for inspection by tests.
+type recordingLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (rl *recordingLogger) record(level, msg string, keysAndValues []interface{}) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.messages = append(rl.messages, level+": "+formatLogMessage(msg, keysAndValues))
+}
+
+func (rl *recordingLogger) Debug(msg string, keysAndValues ...interface{}) {
+	rl.record("DEBUG", msg, keysAndValues)
+}
+
+func (rl *recordingLogger) Info(msg string, keysAndValues ...interface{}) {
+	rl.record("INFO", msg, keysAndValues)
+}
+
+func (rl *recordingLogger) Warn(msg string, keysAndValues ...interface{}) {
+	rl.record("WARN", msg, keysAndValues)
+}
+
+func (rl *recordingLogger) contains(substr string) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	for _, message := range rl.messages {
+		if strings.Contains(message, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSetLogger(t *testing.T) {
+	logger := &recordingLogger{}
+	SetLogger(logger)
+	defer SetLogger(nil)
+
+	cmd := simpleCommand()
+	cmd.AddOption(StringOption("old", 0, "", "dummy description").Deprecated("visible", ""))
+	cfg := ParseFakeCLI(t, cmd, "mycommand --old=foo arg1")
+	cfg.Get("visible")
+	if !logger.contains("DEBUG: Resolving option values command=mycommand") {
+		t.Errorf("Expected debug message about resolving values, instead found %v", logger.messages)
+	}
+	if !logger.contains("WARN: ") || !logger.contains("deprecated") {
+		t.Errorf("Expected deprecation warning, instead found %v", logger.messages)
+	}
+
+	f, err := getParsedFile(cfg, false, "visible=1\n")
+	if err != nil {
+		t.Fatalf("Unexpected error from getParsedFile: %v", err)
+	}
+	if !logger.contains("DEBUG: Parsed option file path=" + f.Path()) {
+		t.Errorf("Expected debug message about parsing, instead found %v", logger.messages)
+	}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Unable to obtain working directory: %v", err)
+	}
+	empty := NewFile(dir, "empty.cnf")
+	empty.parsed = true
+	if err := empty.Write(false); err != nil {
+		t.Fatalf("Unexpected error from Write: %v", err)
+	}
+	if !logger.contains(fmt.Sprintf("INFO: Skipping write of option file due to empty configuration path=%s", empty.Path())) {
+		t.Errorf("Expected info message about skipped write, instead found %v", logger.messages)
+	}
+
+	if actual := formatLogMessage("msg", []interface{}{"a", 1, "dangling"}); actual != "msg a=1 dangling" {
+		t.Errorf("Unexpected result from formatLogMessage: %q", actual)
+	}
+}
@@ -0,0 +1,234 @@
+package mybase
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Constants relating to the layout of a login path file
+const (
+	loginPathUnusedLen = 4  // leading bytes which are ignored
+	loginPathKeyLen    = 20 // length of the key, which follows the unused bytes
+	loginPathHeaderLen = loginPathUnusedLen + loginPathKeyLen
+)
+
+// LoginPathFile represents a login path file, as created by MySQL's
+// mysql_config_editor, typically located at ~/.mylogin.cnf. Each section of a
+// login path file corresponds to a login path, generally supplying host, user,
+// password, port, and/or socket.
+//
+// A login path file is an ordinary option file which has been obfuscated
+// using AES-128-ECB, with a key stored in the file itself. This prevents
+// passwords from being visible in plaintext, but it is not secure against a
+// user who can read the file.
+//
+// LoginPathFile embeds a *File, and so it may be used anywhere an OptionValuer
+// is expected. The Read, Parse, ParseAll, and Write methods of the embedded
+// File are overridden to handle the obfuscation. NewLoginPathFile sets
+// IgnoreUnknownOptions to true, since login path files may contain options
+// which are not relevant to the application.
+type LoginPathFile struct {
+	*File
+}
+
+// NewLoginPathFile returns a value representing a login path file. As with
+// NewFile, the arg(s) will be joined to create a single path. If no args are
+// supplied, DefaultLoginPathFilePath is used.
+func NewLoginPathFile(paths ...string) *LoginPathFile {
+	if len(paths) == 0 {
+		paths = []string{DefaultLoginPathFilePath()}
+	}
+	f := NewFile(paths...)
+	f.IgnoreUnknownOptions = true
+	return &LoginPathFile{File: f}
+}
+
+// DefaultLoginPathFilePath returns the location of the current user's login
+// path file, using the same logic as MySQL's client programs: the
+// MYSQL_TEST_LOGIN_FILE environment variable if set, otherwise .mylogin.cnf in
+// the user's home directory (or in %APPDATA%\MySQL on Windows).
+func DefaultLoginPathFilePath() string {
+	if path := os.Getenv("MYSQL_TEST_LOGIN_FILE"); path != "" {
+		return path
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("APPDATA"), "MySQL", ".mylogin.cnf")
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".mylogin.cnf")
+}
+
+// Read loads and deobfuscates the contents of the login path file, but does
+// not parse it.
+func (lpf *LoginPathFile) Read() error {
+	r, err := lpf.open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	contents, err := decryptLoginPath(data)
+	if err != nil {
+		return LoginPathFormatError{FilePath: lpf.Path(), Problem: err.Error()}
+	}
+	lpf.mu.Lock()
+	defer lpf.mu.Unlock()
+	lpf.contents = contents
+	lpf.read = true
+	return nil
+}
+
+// Parse deobfuscates the login path file, if it was not already loaded via a
+// prior call to Read, and then parses it. See File.Parse.
+func (lpf *LoginPathFile) Parse(cfg *Config) error {
+	if err := lpf.readIfNeeded(); err != nil {
+		return err
+	}
+	return lpf.File.Parse(cfg)
+}
+
+// ParseAll is like Parse, but continues parsing after encountering a problem
+// with the file's contents. See File.ParseAll.
+func (lpf *LoginPathFile) ParseAll(cfg *Config) error {
+	if err := lpf.readIfNeeded(); err != nil {
+		return err
+	}
+	return lpf.File.ParseAll(cfg)
+}
+
+// readIfNeeded calls Read, unless the contents have already been read.
+func (lpf *LoginPathFile) readIfNeeded() error {
+	lpf.mu.RLock()
+	alreadyRead := lpf.read
+	lpf.mu.RUnlock()
+	if alreadyRead {
+		return nil
+	}
+	return lpf.Read()
+}
+
+// UseLoginPath changes which login path is used when calling OptionValue.
+// Values in the named login path take precedence over values in the "client"
+// login path, which is used by MySQL's client programs when no login path is
+// specified. An empty name is equivalent to "client". An error is returned if
+// the named login path does not exist in the file.
+func (lpf *LoginPathFile) UseLoginPath(name string) error {
+	if name == "" {
+		name = "client"
+	}
+	names := []string{name}
+	if name != "client" && lpf.HasSection("client") {
+		names = append(names, "client")
+	}
+	return lpf.UseSection(names...)
+}
+
+// Write obfuscates the file's contents and writes them to disk. If
+// overwrite=false and the file already exists, an error will be returned. If
+// the file does not already exist, it is created with permissions restricting
+// access to the current user. See File.Write regarding how the contents are
+// generated.
+func (lpf *LoginPathFile) Write(overwrite bool) error {
+	lpf.mu.Lock()
+	contents, ok := lpf.renderContents()
+	if !ok {
+		lpf.mu.Unlock()
+		currentLogger().Info("Skipping write of login path file due to empty configuration", "path", lpf.Path())
+		return nil
+	}
+	lpf.commitContents(contents)
+	lpf.mu.Unlock()
+	data, err := encryptLoginPath(contents)
+	if err != nil {
+		return err
+	}
+	return writeFile(lpf.Path(), data, overwrite, 0600)
+}
+
+// loginPathCipherKey derives the AES-128 key from the key stored in a login
+// path file, by XORing its bytes cyclically into 16 bytes.
+func loginPathCipherKey(fileKey []byte) []byte {
+	key := make([]byte, aes.BlockSize)
+	for n, b := range fileKey {
+		key[n%aes.BlockSize] ^= b
+	}
+	return key
+}
+
+// decryptLoginPath returns the plaintext contents of a login path file. After
+// the header, the file consists of a series of chunks, each typically
+// representing one line: a 4-byte little-endian length, followed by that many
+// bytes of AES-128-ECB ciphertext with PKCS#7 padding.
+func decryptLoginPath(data []byte) (string, error) {
+	if len(data) < loginPathHeaderLen {
+		return "", fmt.Errorf("file is too short to contain a header")
+	}
+	block, err := aes.NewCipher(loginPathCipherKey(data[loginPathUnusedLen:loginPathHeaderLen]))
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	data = data[loginPathHeaderLen:]
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return "", fmt.Errorf("truncated chunk length")
+		}
+		chunkLen := int(binary.LittleEndian.Uint32(data))
+		data = data[4:]
+		if chunkLen == 0 || chunkLen%aes.BlockSize != 0 || chunkLen > len(data) {
+			return "", fmt.Errorf("invalid chunk length %d", chunkLen)
+		}
+		chunk := make([]byte, chunkLen)
+		for n := 0; n < chunkLen; n += aes.BlockSize {
+			block.Decrypt(chunk[n:n+aes.BlockSize], data[n:n+aes.BlockSize])
+		}
+		padLen := int(chunk[chunkLen-1])
+		if padLen == 0 || padLen > aes.BlockSize || !bytes.Equal(chunk[chunkLen-padLen:], bytes.Repeat([]byte{byte(padLen)}, padLen)) {
+			return "", fmt.Errorf("invalid padding; file may be corrupt")
+		}
+		b.Write(chunk[:chunkLen-padLen])
+		data = data[chunkLen:]
+	}
+	return b.String(), nil
+}
+
+// encryptLoginPath returns the obfuscated form of contents, using a newly
+// generated random key. Each line is stored as a separate chunk, matching the
+// behavior of mysql_config_editor.
+func encryptLoginPath(contents string) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(make([]byte, loginPathUnusedLen))
+	fileKey := make([]byte, loginPathKeyLen)
+	if _, err := rand.Read(fileKey); err != nil {
+		return nil, err
+	}
+	buf.Write(fileKey)
+	block, err := aes.NewCipher(loginPathCipherKey(fileKey))
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.SplitAfter(contents, "\n") {
+		if line == "" {
+			continue
+		}
+		padLen := aes.BlockSize - len(line)%aes.BlockSize
+		chunk := append([]byte(line), bytes.Repeat([]byte{byte(padLen)}, padLen)...)
+		for n := 0; n < len(chunk); n += aes.BlockSize {
+			block.Encrypt(chunk[n:n+aes.BlockSize], chunk[n:n+aes.BlockSize])
+		}
+		binary.Write(&buf, binary.LittleEndian, uint32(len(chunk)))
+		buf.Write(chunk)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,94 @@
+package mybase
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoginPathFile(t *testing.T) {
+	cmd := NewCommand("mycommand", "summary", "description", nil)
+	cmd.AddOptions("connection",
+		StringOption("host", 'h', "localhost", "dummy description"),
+		StringOption("user", 'u', "", "dummy description"),
+		StringOption("password", 'p', "", "dummy description"),
+		StringOption("port", 'P', "3306", "dummy description"),
+	)
+	cfg := ParseFakeCLI(t, cmd, "mycommand")
+
+	// testdata/mylogin.cnf was generated independently using openssl, so it
+	// confirms compatibility with the format used by mysql_config_editor
+	lpf := NewLoginPathFile("testdata", "mylogin.cnf")
+	if err := lpf.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error parsing login path file: %v", err)
+	}
+	if err := lpf.UseLoginPath("prod"); err != nil {
+		t.Fatalf("Unexpected error from UseLoginPath: %v", err)
+	}
+	cfg.AddSource(lpf)
+	expected := map[string]string{
+		"host":     "db.example.com",
+		"user":     "root",
+		"password": "s3cr3t",
+		"port":     "3307",
+	}
+	for name, value := range expected {
+		if actual := cfg.Get(name); actual != value {
+			t.Errorf("Expected %s=%q, instead found %q", name, value, actual)
+		}
+	}
+	if err := lpf.UseLoginPath("doesnt-exist"); err == nil {
+		t.Error("Expected error from UseLoginPath with nonexistent login path, but err is nil")
+	}
+	if err := lpf.UseLoginPath(""); err != nil {
+		t.Errorf("Unexpected error from UseLoginPath: %v", err)
+	}
+	cfg.MarkDirty()
+	if cfg.Get("host") != "localhost" || cfg.Get("user") != "root" {
+		t.Errorf("Unexpected values for client login path: host=%q user=%q", cfg.Get("host"), cfg.Get("user"))
+	}
+
+	// Confirm round-trip through Write
+	dir, err := ioutil.TempDir("", "mybasetest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	lpf2 := NewLoginPathFile(dir, ".mylogin.cnf")
+	lpf2.SetOptionValue("staging", "host", "staging.example.com")
+	lpf2.SetOptionValue("staging", "password", "hunter2")
+	if err := lpf2.Write(false); err != nil {
+		t.Fatalf("Unexpected error from Write: %v", err)
+	}
+	data, err := ioutil.ReadFile(lpf2.Path())
+	if err != nil {
+		t.Fatalf("Unexpected error reading written file: %v", err)
+	} else if bytes.Contains(data, []byte("hunter2")) {
+		t.Error("Written file unexpectedly contains plaintext password")
+	}
+	if fi, err := os.Stat(lpf2.Path()); err != nil {
+		t.Fatalf("Unexpected error from Stat: %v", err)
+	} else if filepath.Separator == '/' && fi.Mode().Perm() != 0600 {
+		t.Errorf("Expected written file to have mode 0600, instead found %s", fi.Mode().Perm())
+	}
+	lpf3 := NewLoginPathFile(lpf2.Path())
+	if err := lpf3.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error parsing written file: %v", err)
+	}
+	if values := lpf3.SectionValues("staging"); values["host"] != "staging.example.com" || values["password"] != "hunter2" {
+		t.Errorf("Unexpected values after round-trip: %v", values)
+	}
+
+	// Confirm malformed files are rejected
+	plain := filepath.Join(dir, "plain.cnf")
+	if err := ioutil.WriteFile(plain, []byte("[client]\nuser=root\npassword=hunter2\n"), 0600); err != nil {
+		t.Fatalf("Unable to write file: %v", err)
+	}
+	if err := NewLoginPathFile(plain).Parse(cfg); err == nil {
+		t.Error("Expected error parsing plaintext file as login path file, but err is nil")
+	} else if _, ok := err.(LoginPathFormatError); !ok {
+		t.Errorf("Expected error to be LoginPathFormatError, instead found %T", err)
+	}
+}
@@ -0,0 +1,157 @@
+package mybase
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// MergeStrategy controls how File.Merge resolves an option which has different
+// values in the two files being merged.
+type MergeStrategy int
+
+// Constants for how to resolve conflicting values when merging files
+const (
+	MergeOverwrite    MergeStrategy = iota // the value from the other file replaces the existing value
+	MergeKeepExisting                      // the existing value is retained
+	MergeError                             // a MergeConflictError is returned, and neither file is changed
+)
+
+// MergeConflict describes an option which has different values in the two
+// files passed to File.Merge.
+type MergeConflict struct {
+	Section  string // name of the section containing the option, or "" for the default section
+	Option   string // name of the option
+	Existing string // value in the file that Merge was called on
+	Incoming string // value in the file passed to Merge
+	opt      *Option
+}
+
+// String returns a human-readable description of the conflict. Values of
+// options marked with Option.Sensitive are redacted.
+func (mc MergeConflict) String() string {
+	display := func(value string) string {
+		if mc.opt != nil {
+			return mc.opt.displayValue(value)
+		}
+		return value
+	}
+	return fmt.Sprintf("[%s] %s: %s vs %s", mc.Section, mc.Option, display(mc.Existing), display(mc.Incoming))
+}
+
+// MergeConflictError is returned by File.Merge when the MergeError strategy is
+// used and at least one option has conflicting values.
+type MergeConflictError struct {
+	FilePath      string
+	OtherFilePath string
+	Conflicts     []MergeConflict
+}
+
+// Error satisfies golang's error interface. Option values are not included
+// in the message, since they may be sensitive.
+func (mce MergeConflictError) Error() string {
+	first := mce.Conflicts[0]
+	others := " has a conflicting value"
+	if len(mce.Conflicts) > 1 {
+		others = fmt.Sprintf(" and %d other options have conflicting values", len(mce.Conflicts)-1)
+	}
+	return fmt.Sprintf("Unable to merge %s into %s: option %s in section [%s]%s", mce.OtherFilePath, mce.FilePath, first.Option, first.Section, others)
+}
+
+// Is returns true if target is ErrConflict, permitting use of errors.Is.
+func (mce MergeConflictError) Is(target error) bool { return target == ErrConflict }
+
+// Merge combines the sections and values of other into f, as a section-wise
+// union: sections which only exist in other are added to f, and within each
+// section, options which are only set in other are added. Options which are
+// set to different values in both files are resolved according to strategy,
+// and returned as a list of MergeConflicts ordered by section and option name,
+// regardless of strategy. Any !inherit directives of other's sections which f
+// lacks are also added. Values which were decrypted at parse time are copied
+// in their original encrypted form. The other file is never modified.
+//
+// Changes to f are not persisted until f.Write is called, at which point they
+// are written in the same manner as SetOptionValue. However, if
+// f.PreserveFormatting is enabled, added !inherit directives are not written.
+// Any Config using f as a source automatically reflects the changes.
+//
+// Both files must be parsed by the caller prior to calling this method,
+// otherwise this method panics to indicate programmer error. With the
+// MergeError strategy, if any values conflict, a MergeConflictError is
+// returned and f is left unchanged.
+func (f *File) Merge(other *File, strategy MergeStrategy) ([]MergeConflict, error) {
+	if other == f {
+		return nil, nil
+	}
+	f.loadAllPending()
+	other.loadAllPending()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.assertUnsealed("Merge")
+	other.mu.RLock()
+	defer other.mu.RUnlock()
+	if !f.parsed || !other.parsed {
+		panic(errors.New("File.Merge called on a file that has not yet been parsed"))
+	}
+
+	// Determine conflicts first, so that the MergeError strategy can bail out
+	// without changing anything
+	var conflicts []MergeConflict
+	for _, incoming := range other.sections {
+		existing := f.sectionIndex[incoming.Name]
+		if existing == nil {
+			continue
+		}
+		for name, value := range incoming.Values {
+			if prev, ok := existing.Values[name]; ok && prev != value {
+				opt := existing.opts[name]
+				if opt == nil {
+					opt = incoming.opts[name]
+				}
+				conflicts = append(conflicts, MergeConflict{Section: incoming.Name, Option: name, Existing: prev, Incoming: value, opt: opt})
+			}
+		}
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		if conflicts[i].Section != conflicts[j].Section {
+			return conflicts[i].Section < conflicts[j].Section
+		}
+		return conflicts[i].Option < conflicts[j].Option
+	})
+	if len(conflicts) > 0 && strategy == MergeError {
+		return conflicts, MergeConflictError{FilePath: f.Path(), OtherFilePath: other.Path(), Conflicts: conflicts}
+	}
+
+	for _, incoming := range other.sections {
+		section := f.getOrCreateSection(incoming.Name)
+		for _, parent := range incoming.Inherits {
+			var found bool
+			for _, existingParent := range section.Inherits {
+				found = found || existingParent == parent
+			}
+			if !found {
+				section.Inherits = append(section.Inherits, parent)
+				f.bumpGeneration()
+			}
+		}
+		for name, value := range incoming.Values {
+			if prev, ok := section.Values[name]; ok && (prev == value || strategy == MergeKeepExisting) {
+				continue
+			}
+			section.Values[name] = value
+			if opt := incoming.opts[name]; opt != nil {
+				section.opts[name] = opt
+			}
+			if enc, ok := incoming.encrypted[name]; ok {
+				if section.encrypted == nil {
+					section.encrypted = make(map[string]encryptedValue)
+				}
+				section.encrypted[name] = enc
+			} else {
+				delete(section.encrypted, name)
+			}
+			f.markEdited(incoming.Name, name)
+		}
+	}
+	return conflicts, nil
+}
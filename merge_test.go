@@ -0,0 +1,67 @@
+package mybase
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFileMerge(t *testing.T) {
+	cmd := simpleCommand()
+	cmd.AddOption(StringOption("password", 0, "", "dummy description").Sensitive())
+	cfg := ParseFakeCLI(t, cmd, "mycommand arg1")
+	getFiles := func() (*File, *File) {
+		t.Helper()
+		base, err := getParsedFile(cfg, false, "visible=base\nhasshort=base\n[prod]\nbool1\npassword=old\n")
+		if err != nil {
+			t.Fatalf("Unexpected error from getParsedFile: %v", err)
+		}
+		override, err := getParsedFile(cfg, false, "visible=override\nbool2\n[prod]\npassword=new\n[host1]\n!inherit prod\nhasshort=host1\n")
+		if err != nil {
+			t.Fatalf("Unexpected error from getParsedFile: %v", err)
+		}
+		return base, override
+	}
+
+	base, override := getFiles()
+	conflicts, err := base.Merge(override, MergeOverwrite)
+	if err != nil {
+		t.Fatalf("Unexpected error from Merge: %v", err)
+	}
+	if len(conflicts) != 2 || conflicts[0].Option != "visible" || conflicts[1].Section != "prod" {
+		t.Errorf("Unexpected conflicts: %+v", conflicts)
+	}
+	if strings.Contains(conflicts[1].String(), "new") {
+		t.Errorf("Expected sensitive value to be redacted, instead found %s", conflicts[1])
+	}
+	expected, _ := getParsedFile(cfg, false, "visible=override\nhasshort=base\nbool2\n[prod]\nbool1\npassword=new\n[host1]\n!inherit prod\nhasshort=host1\n")
+	if !base.SameContents(expected) {
+		t.Errorf("Unexpected contents after merge: %+v", base.SectionValues("prod"))
+	}
+	base.UseSection("host1")
+	if value, _ := base.OptionValue("password"); value != "new" {
+		t.Errorf("Expected inheritance to be merged, instead found password=%q", value)
+	}
+
+	base, override = getFiles()
+	if _, err := base.Merge(override, MergeKeepExisting); err != nil {
+		t.Fatalf("Unexpected error from Merge: %v", err)
+	}
+	if values := base.SectionValues(""); values["visible"] != "base" || values["bool2"] != "1" {
+		t.Errorf("Unexpected values after merge with MergeKeepExisting: %v", values)
+	}
+
+	base, override = getFiles()
+	original, _ := getFiles()
+	conflicts, err = base.Merge(override, MergeError)
+	if mce, ok := err.(MergeConflictError); !ok || len(mce.Conflicts) != 2 || len(conflicts) != 2 {
+		t.Errorf("Expected MergeConflictError with 2 conflicts, instead found %T %v", err, err)
+	} else if strings.Contains(err.Error(), "new") {
+		t.Errorf("Expected error message to omit values, instead found %q", err)
+	}
+	if !base.SameContents(original) {
+		t.Error("Expected file to be unchanged after failed merge")
+	}
+	if _, err := base.Merge(base, MergeError); err != nil {
+		t.Errorf("Expected merging a file into itself to be a no-op, instead found %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+package mybase
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"runtime"
+	"strings"
+)
+
+// DefaultMySQLSocket is the Unix domain socket path used by MySQLDSN when
+// connecting to localhost, if the socket option is absent or has no value.
+// This matches the default of the MySQL client programs.
+const DefaultMySQLSocket = "/tmp/mysql.sock"
+
+// MySQLDSN returns a data source name for the go-sql-driver/mysql package,
+// connecting to the supplied database (which may be ""), using the values of
+// the standard MySQL client connection options: host, port, socket, user,
+// password, and connect-timeout, along with the options added by
+// Command.AddTLSOptions. Options which the command does not have are treated
+// as having no value, so programs need only define the options relevant to
+// them.
+//
+// Like the MySQL client programs, a host of "localhost" or "" connects via the
+// Unix domain socket given by the socket option (or DefaultMySQLSocket), and
+// the port is ignored; any other host connects via TCP, and the socket is
+// ignored. On Windows, TCP is always used. The connect-timeout option may be a
+// duration or a bare number of seconds.
+//
+// The ssl-mode option is interpreted as described by TLSConfig, except that
+// the driver's default is used if no TLS option has a value. Some combinations,
+// such as VERIFY_CA or any use of ssl-ca, ssl-cert, ssl-key, or tls-version,
+// require a custom TLS configuration: the returned DSN then refers to it by
+// the name returned by MySQLTLSConfig, and the caller must register it with
+// the driver before connecting:
+//
+//	name, tlsConfig, err := cfg.MySQLTLSConfig()
+//	if err == nil && tlsConfig != nil {
+//		err = mysql.RegisterTLSConfig(name, tlsConfig)
+//	}
+//
+// An OptionValueError is returned if an option has an invalid value.
+func (cfg *Config) MySQLDSN(database string) (string, error) {
+	var dsn strings.Builder
+	if user := cfg.optionValue("user"); user != "" {
+		dsn.WriteString(user)
+		if password := cfg.optionValue("password"); password != "" {
+			dsn.WriteString(":" + password)
+		}
+		dsn.WriteString("@")
+	}
+	host := cfg.optionValue("host")
+	if (host == "" || host == "localhost") && runtime.GOOS != "windows" {
+		socket := cfg.optionValue("socket")
+		if socket == "" {
+			socket = DefaultMySQLSocket
+		}
+		fmt.Fprintf(&dsn, "unix(%s)", socket)
+	} else {
+		if host == "" {
+			host = "localhost"
+		}
+		port := cfg.optionValue("port")
+		if port == "" {
+			port = "3306"
+		} else if n, err := cfg.GetInt("port"); err != nil || n < 1 || n > 65535 {
+			return "", cfg.valueError("port", port, errors.New("must be a number between 1 and 65535"))
+		}
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]" // IPv6 address
+		}
+		fmt.Fprintf(&dsn, "tcp(%s:%s)", host, port)
+	}
+	dsn.WriteString("/" + database)
+
+	params := url.Values{}
+	if cfg.optionValue("connect-timeout") != "" {
+		timeout, err := cfg.GetDuration("connect-timeout")
+		if err != nil {
+			return "", err
+		}
+		params.Set("timeout", timeout.String())
+	}
+	tlsParam, err := cfg.mysqlTLSParam()
+	if err != nil {
+		return "", err
+	}
+	if tlsParam != "" {
+		params.Set("tls", tlsParam)
+	}
+	if len(params) > 0 {
+		dsn.WriteString("?" + params.Encode())
+	}
+	return dsn.String(), nil
+}
+
+// MySQLTLSConfig returns the custom TLS configuration needed by the DSN from
+// MySQLDSN, along with the name by which the DSN refers to it. If no custom
+// configuration is needed, returns "", nil, nil. The configuration is obtained
+// from TLSConfig. The name is derived from the configuration's settings, so
+// Configs with different settings (for example, those returned by
+// ExpandInstances) may register their configurations independently. An error
+// is returned if an ssl option has an invalid value, or if the files named by
+// ssl-ca, ssl-cert, or ssl-key cannot be loaded.
+func (cfg *Config) MySQLTLSConfig() (name string, tlsConfig *tls.Config, err error) {
+	mode, err := cfg.sslMode()
+	if err != nil || !cfg.mysqlCustomTLS(mode) {
+		return "", nil, err
+	}
+	if tlsConfig, err = cfg.TLSConfig(); err != nil {
+		return "", nil, err
+	}
+	settings := []string{mode, cfg.optionValue("host")}
+	for _, name := range []string{"ssl-ca", "ssl-cert", "ssl-key", "tls-version"} {
+		settings = append(settings, cfg.optionValue(name))
+	}
+	sum := sha256.Sum256([]byte(strings.Join(settings, "\x00")))
+	return "mybase-" + hex.EncodeToString(sum[:8]), tlsConfig, nil
+}
+
+// mysqlTLSParam returns the value of the tls parameter for MySQLDSN, or "" if
+// the driver's default should be used.
+func (cfg *Config) mysqlTLSParam() (string, error) {
+	mode, err := cfg.sslMode()
+	if err != nil {
+		return "", err
+	}
+	if cfg.mysqlCustomTLS(mode) {
+		name, _, err := cfg.MySQLTLSConfig()
+		return name, err
+	} else if cfg.optionValue("ssl-mode") == "" {
+		return "", nil
+	}
+	switch mode {
+	case "DISABLED":
+		return "false", nil
+	case "PREFERRED":
+		return "preferred", nil
+	case "REQUIRED":
+		return "skip-verify", nil
+	}
+	return "true", nil // VERIFY_IDENTITY
+}
+
+// mysqlCustomTLS returns true if the supplied normalized ssl-mode, along with
+// the values of the other TLS options, requires a custom TLS configuration.
+func (cfg *Config) mysqlCustomTLS(mode string) bool {
+	if mode == "DISABLED" {
+		return false
+	} else if mode == "VERIFY_CA" {
+		return true
+	}
+	for _, name := range []string{"ssl-ca", "ssl-cert", "ssl-key", "tls-version"} {
+		if cfg.optionValue(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// optionValue returns the value of the named option, or "" if the command
+// does not have the option. Unlike Get, this does not panic for unknown
+// options.
+func (cfg *Config) optionValue(name string) string {
+	if cfg.FindOption(name) == nil {
+		return ""
+	}
+	return cfg.Get(name)
+}
@@ -0,0 +1,118 @@
+package mybase
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMySQLDSN(t *testing.T) {
+	cmd := simpleCommand()
+	cmd.AddOptions("connection",
+		StringOption("host", 0, "", "Database hostname"),
+		StringOption("port", 'P', "3306", "Database port"),
+		StringOption("socket", 'S', "", "Database socket"),
+		StringOption("user", 'u', "root", "Database user"),
+		StringOption("password", 'p', "", "Database password").Sensitive(),
+		StringOption("connect-timeout", 0, "", "Connection timeout"),
+		StringOption("ssl-mode", 0, "", "TLS mode"),
+		StringOption("ssl-ca", 0, "", "TLS certificate authority file"),
+	)
+	cases := map[string]string{
+		"mycommand arg1 --host=db1 --port=3307 --password=s3cr3t":            "root:s3cr3t@tcp(db1:3307)/mydb",
+		"mycommand arg1 --host=::1 --user=app --connect-timeout=5":           "app@tcp([::1]:3306)/mydb?timeout=5s",
+		"mycommand arg1 --host=db1 --ssl-mode=required --connect-timeout=1m": "root@tcp(db1:3306)/mydb?timeout=1m0s&tls=skip-verify",
+		"mycommand arg1 --host=db1 --ssl-mode=DISABLED":                      "root@tcp(db1:3306)/mydb?tls=false",
+		"mycommand arg1 --host=db1 --ssl-mode=verify_identity":               "root@tcp(db1:3306)/mydb?tls=true",
+	}
+	if runtime.GOOS != "windows" {
+		cases["mycommand arg1 --port=3307"] = "root@unix(/tmp/mysql.sock)/mydb"
+		cases["mycommand arg1 --host=localhost --socket=/var/run/mysqld.sock"] = "root@unix(/var/run/mysqld.sock)/mydb"
+	}
+	for commandLine, expected := range cases {
+		cfg := ParseFakeCLI(t, cmd, commandLine)
+		if dsn, err := cfg.MySQLDSN("mydb"); err != nil || dsn != expected {
+			t.Errorf("Unexpected result from MySQLDSN for %q: expected %q, found %q, err=%v", commandLine, expected, dsn, err)
+		}
+		if name, tlsConfig, err := cfg.MySQLTLSConfig(); name != "" || tlsConfig != nil || err != nil {
+			t.Errorf("Expected no custom TLS config for %q, instead found %q, %v, %v", commandLine, name, tlsConfig, err)
+		}
+	}
+
+	// Invalid values are errors
+	for _, commandLine := range []string{"mycommand arg1 --host=db1 --port=99999", "mycommand arg1 --ssl-mode=sometimes", "mycommand arg1 --connect-timeout=soon"} {
+		cfg := ParseFakeCLI(t, cmd, commandLine)
+		if _, err := cfg.MySQLDSN(""); err == nil {
+			t.Errorf("Expected error from MySQLDSN for %q, but err was nil", commandLine)
+		}
+	}
+
+	// Options which the command lacks are treated as having no value
+	cfg := ParseFakeCLI(t, simpleCommand(), "mycommand arg1")
+	expected := "unix(/tmp/mysql.sock)/"
+	if runtime.GOOS == "windows" {
+		expected = "tcp(localhost:3306)/"
+	}
+	if dsn, err := cfg.MySQLDSN(""); err != nil || dsn != expected {
+		t.Errorf("Unexpected result from MySQLDSN: %q, %v", dsn, err)
+	}
+
+	// ssl-ca requires a custom TLS config, verifying the CA but not the hostname
+	dir, err := ioutil.TempDir("", "mybasetest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	caPath := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(caPath, testCertificatePEM(t), 0644); err != nil {
+		t.Fatalf("Unable to write %s: %v", caPath, err)
+	}
+	cfg = ParseFakeCLI(t, cmd, "mycommand arg1 --host=db1 --ssl-ca="+caPath)
+	name, tlsConfig, err := cfg.MySQLTLSConfig()
+	if err != nil || !strings.HasPrefix(name, "mybase-") || tlsConfig == nil || tlsConfig.RootCAs == nil || tlsConfig.VerifyPeerCertificate == nil {
+		t.Fatalf("Unexpected result from MySQLTLSConfig: %q, %+v, %v", name, tlsConfig, err)
+	}
+	if dsn, err := cfg.MySQLDSN("mydb"); err != nil || dsn != "root@tcp(db1:3306)/mydb?tls="+name {
+		t.Errorf("Unexpected result from MySQLDSN: %q, %v", dsn, err)
+	}
+	if otherName, _, _ := ParseFakeCLI(t, cmd, "mycommand arg1 --host=db2 --ssl-ca="+caPath).MySQLTLSConfig(); otherName == name {
+		t.Error("Expected TLS config names to differ for different hosts")
+	}
+	cfg = ParseFakeCLI(t, cmd, "mycommand arg1 --host=db1 --ssl-ca="+filepath.Join(dir, "missing.pem"))
+	if _, err := cfg.MySQLDSN("mydb"); err == nil {
+		t.Error("Expected error from MySQLDSN for nonexistent ssl-ca, but err was nil")
+	}
+}
+
+// testCertificatePEM returns a self-signed PEM-encoded certificate.
+func testCertificatePEM(t *testing.T) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unable to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "mybase test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Unable to create certificate: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
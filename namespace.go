@@ -0,0 +1,132 @@
+package mybase
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RegisterNamespacedOptions is like RegisterOptions, but first places each
+// Option into the supplied namespace, by prefixing its name and any aliases
+// with the namespace followed by a dot. For example, an Option named "timeout"
+// registered in namespace "plugin.foo" becomes "plugin.foo.timeout". This
+// permits independently-developed plugins to use the same short option names
+// without colliding. The namespace is also used as the declarer in error
+// messages. Namespaced options are supplied like any other option, e.g.
+// "--plugin.foo.timeout=5s" on the command-line, or "plugin.foo.timeout=5s" in
+// an option file. Use Config.Scope to look up their values without repeating
+// the namespace.
+//
+// The Options are modified in place, so they should not have been added to any
+// Command yet. Panics if namespace is empty, since this is indicative of
+// programmer error.
+func (cmd *Command) RegisterNamespacedOptions(namespace string, opts ...*Option) error {
+	namespace = strings.TrimSuffix(canonicalOptionName(namespace), ".")
+	if namespace == "" {
+		panic(fmt.Errorf("RegisterNamespacedOptions: namespace for options of command %s must not be empty", cmd.Name))
+	}
+	for _, opt := range opts {
+		if opt.definedAs == "" {
+			opt.definedAs = opt.Name
+		}
+		opt.Name = namespace + "." + canonicalOptionName(opt.Name)
+		opt.definedAs = namespace + "." + opt.definedAs
+		for n := range opt.Aliases {
+			opt.Aliases[n] = namespace + "." + opt.Aliases[n]
+		}
+	}
+	return cmd.RegisterOptions(namespace, opts...)
+}
+
+// GetAllWithPrefix returns the values of all options whose names begin with
+// prefix, keyed by full option name. Values are obtained via Get. This is
+// primarily useful for enumerating the options of a namespace, by supplying a
+// prefix such as "plugin.foo.", or of all namespaces under a common parent,
+// such as "plugin.". Positional args are not included.
+func (cfg *Config) GetAllWithPrefix(prefix string) map[string]string {
+	prefix = canonicalOptionName(prefix)
+	values := make(map[string]string)
+	for name := range cfg.CLI.Command.Options() {
+		if strings.HasPrefix(name, prefix) {
+			values[name] = cfg.Get(name)
+		}
+	}
+	return values
+}
+
+// ScopedConfig provides lookups of option values within a namespace, using
+// option names relative to that namespace. See Config.Scope. For getters
+// which ScopedConfig does not provide, use the Config field along with Name.
+type ScopedConfig struct {
+	Config    *Config
+	Namespace string
+}
+
+// Scope returns a ScopedConfig for looking up values of options in the
+// supplied namespace, as registered via Command.RegisterNamespacedOptions. For
+// example, cfg.Scope("plugin.foo").Get("timeout") is equivalent to
+// cfg.Get("plugin.foo.timeout").
+func (cfg *Config) Scope(namespace string) *ScopedConfig {
+	return &ScopedConfig{
+		Config:    cfg,
+		Namespace: strings.TrimSuffix(canonicalOptionName(namespace), "."),
+	}
+}
+
+// Name returns the full name of the option with the supplied name relative to
+// the namespace.
+func (sc *ScopedConfig) Name(name string) string {
+	return sc.Namespace + "." + name
+}
+
+// Supplied is equivalent to Config.Supplied for the option in the namespace.
+func (sc *ScopedConfig) Supplied(name string) bool {
+	return sc.Config.Supplied(sc.Name(name))
+}
+
+// Changed is equivalent to Config.Changed for the option in the namespace.
+func (sc *ScopedConfig) Changed(name string) bool {
+	return sc.Config.Changed(sc.Name(name))
+}
+
+// Get is equivalent to Config.Get for the option in the namespace.
+func (sc *ScopedConfig) Get(name string) string {
+	return sc.Config.Get(sc.Name(name))
+}
+
+// GetBool is equivalent to Config.GetBool for the option in the namespace.
+func (sc *ScopedConfig) GetBool(name string) bool {
+	return sc.Config.GetBool(sc.Name(name))
+}
+
+// GetInt is equivalent to Config.GetInt for the option in the namespace.
+func (sc *ScopedConfig) GetInt(name string) (int, error) {
+	return sc.Config.GetInt(sc.Name(name))
+}
+
+// GetDuration is equivalent to Config.GetDuration for the option in the
+// namespace.
+func (sc *ScopedConfig) GetDuration(name string) (time.Duration, error) {
+	return sc.Config.GetDuration(sc.Name(name))
+}
+
+// GetSize is equivalent to Config.GetSize for the option in the namespace.
+func (sc *ScopedConfig) GetSize(name string) (uint64, error) {
+	return sc.Config.GetSize(sc.Name(name))
+}
+
+// GetMulti is equivalent to Config.GetMulti for the option in the namespace.
+func (sc *ScopedConfig) GetMulti(name string) []string {
+	return sc.Config.GetMulti(sc.Name(name))
+}
+
+// GetAll returns the values of all options in the namespace, keyed by option
+// name relative to the namespace.
+func (sc *ScopedConfig) GetAll() map[string]string {
+	prefix := sc.Namespace + "."
+	values := make(map[string]string)
+	for name, value := range sc.Config.GetAllWithPrefix(prefix) {
+		values[strings.TrimPrefix(name, prefix)] = value
+	}
+	return values
+}
@@ -0,0 +1,60 @@
+package mybase
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRegisterNamespacedOptions(t *testing.T) {
+	cmd := simpleCommand()
+	for _, namespace := range []string{"plugin.foo", "plugin.bar."} {
+		err := cmd.RegisterNamespacedOptions(namespace,
+			DurationOption("timeout", 0, time.Second, "dummy"),
+			BoolOption("enabled", 0, false, "dummy").AddAlias("on"),
+		)
+		if err != nil {
+			t.Fatalf("Unexpected error from RegisterNamespacedOptions: %v", err)
+		}
+	}
+	if err := cmd.RegisterNamespacedOptions("plugin.foo", StringOption("timeout", 0, "", "dummy")); err == nil {
+		t.Error("Expected error registering conflicting option in same namespace, but err is nil")
+	}
+
+	cfg := ParseFakeCLI(t, cmd, "mycommand --plugin.foo.timeout=5s --plugin.bar.on arg1")
+	f, err := getParsedFile(cfg, false, "plugin.bar.timeout=3s\n")
+	if err != nil {
+		t.Fatalf("Unexpected error from getParsedFile: %v", err)
+	}
+	cfg.AddSource(f)
+	defer os.Setenv("MYAPP_PLUGIN_FOO_ENABLED", os.Getenv("MYAPP_PLUGIN_FOO_ENABLED"))
+	os.Setenv("MYAPP_PLUGIN_FOO_ENABLED", "1")
+	cfg.AddSource(NewEnvSource("MYAPP"))
+
+	foo, bar := cfg.Scope("plugin.foo"), cfg.Scope("Plugin.Bar.")
+	if d, err := foo.GetDuration("timeout"); d != 5*time.Second || err != nil {
+		t.Errorf("Unexpected result from GetDuration: %s, %v", d, err)
+	}
+	if d, err := bar.GetDuration("timeout"); d != 3*time.Second || err != nil {
+		t.Errorf("Unexpected result from GetDuration: %s, %v", d, err)
+	}
+	if !foo.GetBool("enabled") || !bar.GetBool("enabled") || !bar.Supplied("enabled") {
+		t.Error("Expected both enabled options to be true")
+	}
+	if all := bar.GetAll(); len(all) != 2 || all["timeout"] != "3s" || all["enabled"] != "1" {
+		t.Errorf("Unexpected result from GetAll: %v", all)
+	}
+	if all := cfg.GetAllWithPrefix("plugin."); len(all) != 4 || all["plugin.foo.timeout"] != "5s" {
+		t.Errorf("Unexpected result from GetAllWithPrefix: %v", all)
+	}
+	if all := cfg.GetAllWithPrefix("nope."); len(all) != 0 {
+		t.Errorf("Unexpected result from GetAllWithPrefix: %v", all)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected RegisterNamespacedOptions to panic with empty namespace, but it did not")
+		}
+	}()
+	cmd.RegisterNamespacedOptions("", StringOption("x", 0, "", "dummy"))
+}
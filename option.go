@@ -1,28 +1,46 @@
 package mybase
 
 import (
+	"errors"
 	"fmt"
-	"os"
-	"runtime"
+	"math"
+	"math/big"
+	"path"
 	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 	"unicode"
+	"unicode/utf8"
 
 	"github.com/mitchellh/go-wordwrap"
-	terminal "golang.org/x/term"
 )
 
 // OptionType is an enum for representing the type of an option.
 type OptionType int
 
 // Constants representing different OptionType enumerated values.
-// Note that there intentionally aren't separate types for int, comma-separated
-// list, regex, etc. From the perspective of the CLI or an option file, these
-// are all strings; callers may *process* a string value as a different Golang
-// type at runtime using Config.GetInt, Config.GetSlice, etc.
+// Note that there intentionally aren't separate types for int, regex, etc.
+// From the perspective of the CLI or an option file, these are all strings;
+// callers may *process* a string value as a different Golang type at runtime
+// using Config.GetInt, Config.GetRegexp, etc. The duration, size, enum,
+// count, float, time, and template types are exceptions, since their values
+// are validated when parsing the command-line or an option file. The multi and map types are also
+// exceptions, since repeated uses of the option accumulate instead of
+// overriding.
 const (
-	OptionTypeString OptionType = iota // String-valued option
-	OptionTypeBool                     // Boolean-valued option
+	OptionTypeString   OptionType = iota // String-valued option
+	OptionTypeBool                       // Boolean-valued option
+	OptionTypeDuration                   // Duration-valued option, e.g. "1m30s", or bare number of seconds
+	OptionTypeSize                       // Byte size option, with optional K, M, or G suffix
+	OptionTypeEnum                       // String-valued option restricted to a list of allowed values
+	OptionTypeCount                      // Non-negative integer option, incremented by each valueless use on the CLI, e.g. -vvv
+	OptionTypeMulti                      // List-valued option, accumulating values from repeated uses
+	OptionTypeMap                        // Map-valued option of key=value entries, accumulating entries from repeated uses
+	OptionTypeFloat                      // Floating-point option, e.g. "0.75" or "1e-3", always using "." as the decimal separator
+	OptionTypeTime                       // Timestamp option in RFC 3339 format, or any additional layouts set via SetTimeLayouts
+	OptionTypeTemplate                   // String-valued option containing a text/template, e.g. "{{.Name}}: {{.Size}}"
 )
 
 // Option represents a flag/setting for a Command. Any Option present for a
@@ -30,26 +48,58 @@ const (
 // subcommands, although subcommands may choose to override the exact semantics
 // by providing another conflicting Option of same Name.
 type Option struct {
-	Name         string
-	Shorthand    rune
-	Type         OptionType
-	Default      string
-	Description  string
-	RequireValue bool
-	HiddenOnCLI  bool
-	Group        string // Used in help information
+	Name          string
+	Shorthand     rune
+	Type          OptionType
+	Default       string
+	Description   string
+	RequireValue  bool
+	HiddenOnCLI   bool
+	Group         string          // Used in help information
+	Aliases       []string        // Alternative long names which resolve to this Option
+	AllowedValues []string        // Permitted values for OptionTypeEnum, or of any type if set via SetChoices; compared case-insensitively
+	Deprecation   string          // If non-empty, the Option is deprecated, and this explains what to do instead
+	Replacement   string          // Name of the Option which replaces this deprecated Option, if any
+	declarer      string          // Name of plugin which registered this Option, if any
+	definedAs     string          // Name as originally supplied, prior to canonicalization
+	transform     OptionTransform // Function applied to the option's value when resolved by a Config
+	validator     OptionValidator // Function which checks the option's value when resolved by a Config
+	mandatory     bool            // If true, Config.Validate returns an error if no source supplies a value
+	mandatoryCLI  bool            // If true, ParseCLI returns an error if the command-line does not supply a value
+	sections      []string        // If non-empty, names or patterns of the only option file sections which may set this option
+	variadic      bool            // Only used for positional args: true if arg consumes all remaining values
+	prompt        string          // Question to ask interactively if no source supplies a value
+	secret        bool            // If true, input is not echoed when prompting for a value
+	promptConfirm string          // Question asking to re-enter a prompted value, if set via ConfirmPrompt
+	promptCheck   OptionValidator // Checks answers to the prompt, if set via SetPromptValidator
+	sensitive     bool            // If true, value is redacted in error messages and Config.Explain
+	delimiter     rune            // Only used for OptionTypeMulti and OptionTypeMap: separator between values
+	defaultFunc   DefaultFunc     // Computes the default value at runtime, if set via SetDefaultFunc
+	cmdTimeout    time.Duration   // If positive, "$(command)" values are replaced by the command's output; see AllowCommandSubstitution
+	numRange      *numericRange   // Permitted numeric values, if set via SetNumericRange or SetNumericStep
+	timeLayouts   []string        // Only used for OptionTypeTime: layouts accepted in addition to RFC 3339
+	timeLocation  *time.Location  // Only used for OptionTypeTime: location of values lacking a time zone; UTC if nil
+	completer     CompletionFunc  // Supplies dynamic shell completions of the value, if set via SetCompletion
+	local         bool            // If true, the Option is not inherited by subcommands of the Command it was added to
+	immutable     bool            // If true, values from protected sources cannot be overridden; see Immutable
+	bootstrap     bool            // If true, the Option is a meta-option resolved by BootstrapCLI
+	valueHint     string          // Placeholder for the value in help output, if set via SetValueHint
+	funcs         templateFuncs   // Only used for OptionTypeTemplate: functions available to the template, if set via SetTemplateFuncs
+	onResolve     []OptionHook    // Called when a Config first resolves the value, if set via OnResolve
+	onSet         []OptionHook    // Called when a Config's resolved value changes, if set via OnSet
 }
 
 // StringOption creates a string-type Option. By default, string options require
 // a value, though this can be overridden via ValueOptional().
 func StringOption(long string, short rune, defaultValue string, description string) *Option {
 	return &Option{
-		Name:         strings.Replace(long, "_", "-", -1),
+		Name:         canonicalOptionName(long),
 		Shorthand:    short,
 		Type:         OptionTypeString,
 		Default:      defaultValue,
 		Description:  description,
 		RequireValue: true,
+		definedAs:    long,
 	}
 }
 
@@ -63,15 +113,199 @@ func BoolOption(long string, short rune, defaultValue bool, description string)
 		defaultAsStr = ""
 	}
 	return &Option{
-		Name:         strings.Replace(long, "_", "-", -1),
+		Name:         canonicalOptionName(long),
 		Shorthand:    short,
 		Type:         OptionTypeBool,
 		Default:      defaultAsStr,
 		Description:  description,
 		RequireValue: false,
+		definedAs:    long,
 	}
 }
 
+// DurationOption creates a duration-type Option. Values may be expressed in
+// any format accepted by time.ParseDuration, such as "1m30s" or "250ms", or as
+// a bare non-negative integer number of seconds. Duration options require a
+// value by default.
+func DurationOption(long string, short rune, defaultValue time.Duration, description string) *Option {
+	opt := StringOption(long, short, "", description)
+	opt.Type = OptionTypeDuration
+	if defaultValue != 0 {
+		opt.Default = defaultValue.String()
+	}
+	return opt
+}
+
+// FloatOption creates a floating-point-type Option. Values are parsed in the
+// same manner regardless of the system locale: the decimal separator is always
+// ".", and no thousands separators are permitted. Exponents such as "1e-3" are
+// accepted, but infinities and NaN are not. Use Config.GetFloat to obtain the
+// value. Float options require a value by default.
+func FloatOption(long string, short rune, defaultValue float64, description string) *Option {
+	opt := StringOption(long, short, "", description)
+	opt.Type = OptionTypeFloat
+	if defaultValue != 0 {
+		opt.Default = formatFloat(defaultValue)
+	}
+	return opt
+}
+
+// TimeOption creates a timestamp-type Option. By default, values must be in
+// RFC 3339 format, such as "2024-03-01T02:30:00Z" or
+// "2024-03-01T02:30:00-05:00"; additional layouts may be permitted via
+// SetTimeLayouts. Use Config.GetTime to obtain the value. A zero defaultValue
+// means the option has no default. Time options require a value by default.
+func TimeOption(long string, short rune, defaultValue time.Time, description string) *Option {
+	opt := StringOption(long, short, "", description)
+	opt.Type = OptionTypeTime
+	if !defaultValue.IsZero() {
+		opt.Default = defaultValue.Format(time.RFC3339Nano)
+	}
+	return opt
+}
+
+// SizeOption creates a byte-size-type Option. Values may be a number of bytes,
+// or use a suffix of K, M, or G (optionally followed by B, in either case) to
+// multiply by 1024, 1024^2, or 1024^3 respectively. Size options require a
+// value by default.
+func SizeOption(long string, short rune, defaultValue uint64, description string) *Option {
+	opt := StringOption(long, short, "", description)
+	opt.Type = OptionTypeSize
+	if defaultValue != 0 {
+		opt.Default = formatSize(defaultValue)
+	}
+	return opt
+}
+
+// EnumOption creates an enum-type Option, which only permits values matching
+// one of allowedValues, case-insensitively. The default value need not be
+// included in allowedValues. Panics if allowedValues is empty, since this is
+// indicative of programmer error. Enum options require a value by default.
+func EnumOption(long string, short rune, defaultValue string, allowedValues []string, description string) *Option {
+	if len(allowedValues) == 0 {
+		panic(fmt.Errorf("Option %s: enum options must have at least one allowed value", long))
+	}
+	opt := StringOption(long, short, defaultValue, description)
+	opt.Type = OptionTypeEnum
+	opt.AllowedValues = allowedValues
+	return opt
+}
+
+// CountOption creates a count-type Option, which has a default of 0. Each use
+// of the option on the command-line without a value increments the count, so
+// that "-vvv" or "--verbose --verbose --verbose" both result in a value of 3.
+// This mirrors the behavior of the MySQL client programs. A value may also be
+// supplied explicitly, for example "--verbose=2", in which case it replaces the
+// count accumulated so far; "--skip-verbose" resets the count to 0. In an
+// option file, the option name without a value is equivalent to a value of 1.
+// Use Config.GetInt to obtain the count.
+func CountOption(long string, short rune, description string) *Option {
+	opt := StringOption(long, short, "0", description)
+	opt.Type = OptionTypeCount
+	opt.RequireValue = false
+	return opt
+}
+
+// MultiOption creates a multi-valued Option. Unlike other types, repeated uses
+// of the option on the command-line, or repeated lines for the option within
+// one section of an option file, accumulate into a list instead of the last
+// one taking precedence. Each individual value may also contain a list, using
+// a comma delimiter by default; see SetDelimiter. An explicitly empty value
+// clears any values accumulated so far. Between different sources, normal
+// precedence rules apply: for example, values supplied on the command-line
+// replace values from option files, rather than adding to them. Use
+// Config.GetMulti to obtain the values. Multi options require a value by
+// default.
+func MultiOption(long string, short rune, defaultValue string, description string) *Option {
+	opt := StringOption(long, short, defaultValue, description)
+	opt.Type = OptionTypeMulti
+	opt.delimiter = ','
+	return opt
+}
+
+// MapOption creates a map-valued Option, with a value consisting of key=value
+// entries, for example "--connect-option=timeout=5,charset=utf8mb4". Entries
+// are separated by a comma by default; see SetDelimiter. As with MultiOption,
+// repeated uses of the option within a single source accumulate, so the
+// previous example may also be written as "--connect-option timeout=5
+// --connect-option charset=utf8mb4". Use Config.GetMap to obtain the entries.
+// Map options require a value by default.
+func MapOption(long string, short rune, defaultValue string, description string) *Option {
+	opt := StringOption(long, short, defaultValue, description)
+	opt.Type = OptionTypeMap
+	opt.delimiter = ','
+	return opt
+}
+
+// SetDelimiter changes the delimiter used to separate values of a multi-valued
+// or map-valued Option, which is a comma by default. Panics if the Option is
+// not of type OptionTypeMulti or OptionTypeMap, since this is indicative of
+// programmer error.
+func (opt *Option) SetDelimiter(delimiter rune) *Option {
+	if opt.Type != OptionTypeMulti && opt.Type != OptionTypeMap {
+		panic(fmt.Errorf("Cannot set delimiter of option %s: not a multi-valued or map-valued option", opt.Name))
+	}
+	opt.delimiter = delimiter
+	return opt
+}
+
+// SetTimeLayouts permits values of a timestamp-type Option to be supplied in
+// the given layouts, in addition to RFC 3339. Layouts use the reference time
+// format of the time package, for example "2006-01-02 15:04" or "15:04". They
+// are tried in the order supplied, after RFC 3339, and the first successful
+// parse is used. Components omitted by a layout take their zero values, so a
+// value parsed with layout "15:04" falls on January 1 of year 0. Panics if the
+// Option is not of type OptionTypeTime, or if its default value cannot be
+// parsed, since these are indicative of programmer error.
+func (opt *Option) SetTimeLayouts(layouts ...string) *Option {
+	if opt.Type != OptionTypeTime {
+		panic(fmt.Errorf("Cannot set time layouts of option %s: not a time option", opt.Name))
+	}
+	opt.timeLayouts = layouts
+	if err := opt.checkValue(opt.Default); err != nil {
+		panic(fmt.Errorf("Cannot set time layouts of option %s: default value %q is invalid: %v", opt.Name, opt.Default, err))
+	}
+	return opt
+}
+
+// SetTimeLocation sets the location used for values of a timestamp-type Option
+// which are supplied in a layout lacking a time zone or offset, such as
+// "2006-01-02 15:04". By default such values are interpreted as UTC. Values
+// which include an offset, including all RFC 3339 values, retain that offset
+// regardless of loc. Panics if the Option is not of type OptionTypeTime or if
+// loc is nil, since these are indicative of programmer error.
+func (opt *Option) SetTimeLocation(loc *time.Location) *Option {
+	if opt.Type != OptionTypeTime {
+		panic(fmt.Errorf("Cannot set time location of option %s: not a time option", opt.Name))
+	} else if loc == nil {
+		panic(fmt.Errorf("Cannot set time location of option %s: location is nil", opt.Name))
+	}
+	opt.timeLocation = loc
+	return opt
+}
+
+// accumulate returns the result of supplying value for the Option, when the
+// same source has already supplied prev. For multi-valued and map-valued
+// Options, value is appended to prev, unless either is empty. For all other
+// types, value simply replaces prev. For map-valued Options, a fully
+// quote-wrapped value is unwrapped first, so that its entries are still split
+// after being appended.
+func (opt *Option) accumulate(prev, value string) string {
+	if opt.Type == OptionTypeMap {
+		value = unquote(value)
+	}
+	if !opt.accumulates() || prev == "" || value == "" {
+		return value
+	}
+	return prev + string(opt.delimiter) + value
+}
+
+// accumulates returns true if repeated uses of the Option within a single
+// source accumulate, rather than the last use taking precedence.
+func (opt *Option) accumulates() bool {
+	return opt.Type == OptionTypeMulti || opt.Type == OptionTypeMap
+}
+
 // Hidden prevents an Option from being displayed in a Command's help/usage
 // text.
 func (opt *Option) Hidden() *Option {
@@ -79,6 +313,157 @@ func (opt *Option) Hidden() *Option {
 	return opt
 }
 
+// Local prevents an Option from being inherited by subcommands of the Command
+// it is added to. By default, options are persistent: an Option added to a
+// command suite is available to all of its descendant subcommands, and may be
+// supplied on the command-line either before or after the subcommand name. A
+// local Option instead only applies when the command suite itself is invoked
+// without a subcommand, which is primarily useful for suites with
+// subcommand-specific behavior such as a default action. Local options of a
+// command suite may not be supplied before a subcommand name.
+func (opt *Option) Local() *Option {
+	opt.local = true
+	atomic.AddUint64(&optionEdits, 1)
+	return opt
+}
+
+// DefaultFunc is a function which computes an Option's default value at
+// runtime. See Option.SetDefaultFunc.
+type DefaultFunc func(cfg *Config) string
+
+// SetDefaultFunc supplies a function which computes the Option's default value
+// at runtime, instead of using the static Default. This permits defaults which
+// depend on other options, or on the environment, such as a socket path
+// derived from a data directory. The function is called the first time a
+// Config reads the option's value while no other source supplies it, and the
+// result is cached until the Config's sources change. The function may read
+// other options from cfg, but must not read this option, either directly or
+// via another option's DefaultFunc.
+//
+// The returned value is treated like a static default: it may be quote-wrapped,
+// and any transform function is applied to it by Config.Get. The static
+// Default is still used in help output, so it may be left empty, or set to a
+// representative value.
+func (opt *Option) SetDefaultFunc(fn DefaultFunc) *Option {
+	opt.defaultFunc = fn
+	return opt
+}
+
+// Sensitive indicates that an Option's value should never be revealed by this
+// package, for example because it is a password. The value is replaced by a
+// placeholder in the Value field and message of any error involving the
+// Option, as well as in the output of Config.Explain. The actual value is
+// still returned by Config.Get and other getters.
+func (opt *Option) Sensitive() *Option {
+	opt.sensitive = true
+	return opt
+}
+
+// redactedValue is the placeholder used in place of the value of a Sensitive
+// Option.
+const redactedValue = "<redacted>"
+
+// displayValue returns value, or a placeholder if opt is Sensitive. It is
+// safe to call on a nil *Option.
+func (opt *Option) displayValue(value string) string {
+	if opt != nil && opt.sensitive {
+		return redactedValue
+	}
+	return value
+}
+
+// AddAlias adds one or more alternative long names for an Option. Each alias
+// resolves to the same underlying Option, with one stored value: it may be
+// used on the command-line or in option files, and may be passed to Config
+// getter methods, all interchangeably with the Option's canonical name. If a
+// single source supplies conflicting values for the Option via two different
+// spellings, an OptionAliasConflictError results, unless the top-level
+// Command has LastAliasWins enabled.
+func (opt *Option) AddAlias(names ...string) *Option {
+	for _, name := range names {
+		name = canonicalOptionName(name)
+		if name == opt.Name || opt.HasAlias(name) {
+			continue
+		}
+		opt.Aliases = append(opt.Aliases, name)
+		atomic.AddUint64(&optionEdits, 1)
+	}
+	return opt
+}
+
+// HasAlias returns true if name is one of the Option's aliases. The Option's
+// canonical name is not considered to be an alias.
+func (opt *Option) HasAlias(name string) bool {
+	for _, alias := range opt.Aliases {
+		if alias == name {
+			return true
+		}
+	}
+	return false
+}
+
+// OnlyInSections restricts which sections of an option file may supply a value
+// for the Option. Each pattern is either a section name, or a glob pattern
+// using the syntax of path.Match, e.g. "host-*". Use "" to permit the default
+// nameless section at the top of the file. If an option file sets the Option
+// in any other section, parsing the file returns an OptionSectionError.
+// Values supplied by other sources, such as the command-line, are unaffected.
+// Panics if a pattern is malformed, since this is indicative of programmer
+// error.
+func (opt *Option) OnlyInSections(patterns ...string) *Option {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			panic(fmt.Errorf("Option %s: invalid section pattern %q: %v", opt.Name, pattern, err))
+		}
+	}
+	opt.sections = patterns
+	return opt
+}
+
+// permittedInSection returns true if an option file may set the Option in the
+// section with the supplied name.
+func (opt *Option) permittedInSection(sectionName string) bool {
+	if len(opt.sections) == 0 {
+		return true
+	}
+	for _, pattern := range opt.sections {
+		if matched, _ := path.Match(pattern, sectionName); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Deprecated marks an Option as deprecated. Whenever a Config resolves option
+// values, a warning is reported via Config.Warn for each source which supplies
+// a value for the deprecated Option, once per source.
+//
+// If replacement is non-empty, it should be the name of another Option which
+// supersedes this one. When a source supplies a value for the deprecated
+// Option but not for the replacement, the value is also used for the
+// replacement, at the same precedence as the source which supplied it.
+//
+// The message should explain what to do instead, and is included in warnings
+// and help output. If message is empty, a generic message is used.
+// Panics if replacement is the Option itself, as this indicates programmer
+// error.
+func (opt *Option) Deprecated(replacement, message string) *Option {
+	replacement = canonicalOptionName(replacement)
+	if replacement != "" && (replacement == opt.Name || opt.HasAlias(replacement)) {
+		panic(fmt.Errorf("Option %s cannot be deprecated in favor of itself", opt.Name))
+	}
+	if message == "" {
+		if replacement != "" {
+			message = fmt.Sprintf("use option %s instead", replacement)
+		} else {
+			message = "this option will be removed in a future release"
+		}
+	}
+	opt.Deprecation = message
+	opt.Replacement = replacement
+	return opt
+}
+
 // ValueRequired marks an Option as needing a value, so it will be an error if
 // the option is supplied alone without any corresponding value.
 func (opt *Option) ValueRequired() *Option {
@@ -89,6 +474,73 @@ func (opt *Option) ValueRequired() *Option {
 	return opt
 }
 
+// Describe sets an Option's description text. This is primarily useful for
+// positional args, which are created by Command.AddArg without a description;
+// described args are listed in the command's help output.
+func (opt *Option) Describe(description string) *Option {
+	opt.Description = description
+	return opt
+}
+
+// SetType changes an Option's type. This is primarily useful for positional
+// args, which are created by Command.AddArg as string args; args of other
+// types are validated when parsing the command-line. For OptionTypeEnum, the
+// permitted values must be supplied. Panics if the type cannot be used with
+// the Option's default value or other settings, since this is indicative of
+// programmer error.
+func (opt *Option) SetType(typ OptionType, allowedValues ...string) *Option {
+	if typ == OptionTypeEnum && len(allowedValues) == 0 {
+		panic(fmt.Errorf("Cannot set type of option %s to enum: no allowed values supplied", opt.Name))
+	}
+	opt.Type = typ
+	opt.AllowedValues = allowedValues
+	if opt.Default != "" {
+		if err := opt.checkValue(opt.Default); err != nil {
+			panic(fmt.Errorf("Cannot set type of option %s: default value %q is invalid: %v", opt.Name, opt.Default, err))
+		}
+	}
+	return opt
+}
+
+// SetValueHint sets the placeholder text shown for the Option's value in help
+// output, man pages, and Markdown docs, for example "path" to show
+// "--defaults-file path" rather than "--defaults-file value". This takes
+// precedence over the placeholder derived from the Option's type or choices.
+// Panics if the Option is a boolean, since booleans do not take a value, as
+// this is indicative of programmer error.
+func (opt *Option) SetValueHint(hint string) *Option {
+	if opt.Type == OptionTypeBool {
+		panic(fmt.Errorf("Cannot set value hint of option %s: boolean options do not take a value", opt.Name))
+	}
+	opt.valueHint = hint
+	return opt
+}
+
+// SetChoices restricts the Option to the supplied values, compared
+// case-insensitively, from a single declaration which is used for validation,
+// help output, and shell completion: values are validated when parsing the
+// command-line and option files, help output shows a placeholder such as
+// "{table|json|csv}" unless SetValueHint was also used, completion scripts
+// from Command.Completion offer the choices, and Config.GetEnum may be called
+// without supplying allowed values. The Option's default value is always
+// permitted. For multi-valued options, each value must be one of the choices.
+// Unlike EnumOption, this may be used with options of any type which takes a
+// value. Panics if no choices are supplied, if the Option is a boolean, or if
+// its default value is not one of the choices, since these are indicative of
+// programmer error.
+func (opt *Option) SetChoices(choices ...string) *Option {
+	if len(choices) == 0 {
+		panic(fmt.Errorf("Cannot set choices of option %s: no choices supplied", opt.Name))
+	} else if opt.Type == OptionTypeBool {
+		panic(fmt.Errorf("Cannot set choices of option %s: boolean options do not take a value", opt.Name))
+	}
+	opt.AllowedValues = choices
+	if opt.Default != "" && opt.Type != OptionTypeEnum && !opt.matchesChoices(unquote(opt.Default)) {
+		panic(fmt.Errorf("Cannot set choices of option %s: default value %q is not one of the choices", opt.Name, opt.Default))
+	}
+	return opt
+}
+
 // ValueOptional marks an Option as not needing a value, allowing the Option to
 // appear without any value associated.
 func (opt *Option) ValueOptional() *Option {
@@ -96,33 +548,49 @@ func (opt *Option) ValueOptional() *Option {
 	return opt
 }
 
-// Usage displays one-line help information on the Option.
+// Usage displays one-line help information on the Option. The description is
+// word-wrapped and aligned to the terminal width, if it can be determined.
 func (opt *Option) Usage(maxNameLength int) string {
 	if opt.HiddenOnCLI {
 		return ""
 	}
 
-	lineLen := 10000
-	stdinFd := int(os.Stderr.Fd())
-	if terminal.IsTerminal(stdinFd) {
-		lineLen, _, _ = terminal.GetSize(stdinFd)
-		if lineLen < 80 {
-			lineLen = 80
-		}
-		// Avoid extra blank lines on Windows when output matches full line length
-		if runtime.GOOS == "windows" {
-			lineLen--
-		}
-	}
-
 	var shorthand string
 	if opt.Shorthand > 0 {
 		shorthand = fmt.Sprintf("-%c,", opt.Shorthand)
 	}
+	var repeatable string
+	if opt.Type == OptionTypeCount {
+		repeatable = " (may be repeated)"
+	}
 	head := fmt.Sprintf("  %3s --%*s  ", shorthand, -1*maxNameLength, opt.usageName())
-	desc := fmt.Sprintf("%s%s", opt.Description, opt.DefaultUsage())
+	return usageLine(head, fmt.Sprintf("%s%s%s%s", opt.Description, repeatable, opt.DefaultUsage(), opt.DeprecationUsage()))
+}
+
+// argUsage displays one-line help information on a positional arg, in the same
+// layout as Usage.
+func (opt *Option) argUsage(maxNameLength int) string {
+	var desc string
+	if !opt.RequireValue && opt.Default != "" {
+		desc = fmt.Sprintf(" (default %s)", opt.PrintableDefault())
+	}
+	head := fmt.Sprintf("      %*s  ", -1*maxNameLength, "<"+opt.Name+">")
+	return usageLine(head, opt.Description+desc)
+}
+
+// usageLine returns head followed by desc, word-wrapping desc to the terminal
+// width and aligning continuation lines with the end of head.
+func usageLine(head, desc string) string {
+	lineLen := terminalWidth()
+	if lineLen == 0 {
+		lineLen = 10000
+	}
 	if len(desc)+len(head) > lineLen {
-		desc = wordwrap.WrapString(desc, uint(lineLen-len(head)))
+		descLen := lineLen - len(head)
+		if descLen < 20 {
+			descLen = 20
+		}
+		desc = wordwrap.WrapString(desc, uint(descLen))
 		spacer := fmt.Sprintf("\n%s", strings.Repeat(" ", len(head)))
 		desc = strings.Replace(desc, "\n", spacer, -1)
 	}
@@ -140,30 +608,81 @@ func (opt *Option) DefaultUsage() string {
 	return fmt.Sprintf(" (default %s)", opt.PrintableDefault())
 }
 
+// DeprecationUsage returns usage information relating to the Option's
+// deprecation, or an empty string if the Option is not deprecated.
+func (opt *Option) DeprecationUsage() string {
+	if opt.HiddenOnCLI || opt.Deprecation == "" {
+		return ""
+	}
+	return fmt.Sprintf(" [DEPRECATED: %s]", opt.Deprecation)
+}
+
 // usageName returns the option's name, potentially modified/annotated for
 // display on help screen.
 func (opt *Option) usageName() string {
 	if opt.HiddenOnCLI {
 		return ""
-	} else if opt.Type == OptionTypeBool {
+	}
+	names := opt.Name
+	if len(opt.Aliases) > 0 {
+		names = fmt.Sprintf("%s, --%s", opt.Name, strings.Join(opt.Aliases, ", --"))
+	}
+	placeholder := "value"
+	switch opt.Type {
+	case OptionTypeBool:
 		if opt.HasNonzeroDefault() {
-			return fmt.Sprintf("[skip-]%s", opt.Name)
+			return fmt.Sprintf("[skip-]%s", names)
 		}
-		return opt.Name
-	} else if opt.RequireValue {
-		return fmt.Sprintf("%s value", opt.Name)
+		return names
+	case OptionTypeDuration:
+		placeholder = "duration"
+	case OptionTypeSize:
+		placeholder = "size"
+	case OptionTypeCount:
+		placeholder = "count"
+	case OptionTypeMap:
+		placeholder = "key=value"
+	case OptionTypeFloat:
+		placeholder = "number"
+	case OptionTypeTime:
+		placeholder = "time"
+	case OptionTypeTemplate:
+		placeholder = "template"
+	}
+	if opt.valueHint != "" {
+		placeholder = opt.valueHint
+	} else if len(opt.AllowedValues) > 0 {
+		placeholder = fmt.Sprintf("{%s}", strings.Join(opt.AllowedValues, "|"))
 	}
-	return fmt.Sprintf("%s[=value]", opt.Name)
+	if opt.RequireValue {
+		return fmt.Sprintf("%s %s", names, placeholder)
+	}
+	return fmt.Sprintf("%s[=%s]", names, placeholder)
 }
 
 // HasNonzeroDefault returns true if the Option's default value differs from
 // its type's zero/empty value.
 func (opt *Option) HasNonzeroDefault() bool {
 	switch opt.Type {
-	case OptionTypeString:
+	case OptionTypeString, OptionTypeEnum, OptionTypeTemplate:
 		return opt.Default != ""
 	case OptionTypeBool:
 		return BoolValue(opt.Default)
+	case OptionTypeDuration:
+		d, _ := parseDuration(opt.Default)
+		return d != 0
+	case OptionTypeSize:
+		size, _ := parseSize(opt.Default)
+		return size != 0
+	case OptionTypeCount:
+		count, _ := strconv.ParseUint(opt.Default, 10, 64)
+		return count != 0
+	case OptionTypeFloat:
+		f, _ := parseFloat(opt.Default)
+		return f != 0
+	case OptionTypeTime:
+		t, _ := opt.parseTime(opt.Default)
+		return !t.IsZero()
 	default:
 		return false
 	}
@@ -183,6 +702,217 @@ func (opt *Option) PrintableDefault() string {
 	}
 }
 
+// checkValue returns an error if value is not valid for the Option's type.
+// Only the duration, size, enum, count, map, float, time, and template types
+// are checked, along with any choices set via SetChoices; values of any other
+// type are otherwise always considered valid. The value should not be unquoted
+// yet.
+func (opt *Option) checkValue(value string) (err error) {
+	if (opt.Type == OptionTypeString || opt.Type == OptionTypeBool) && len(opt.AllowedValues) == 0 {
+		return nil // nothing to check, so avoid the cost of unquoting
+	}
+	value = unquote(value)
+	switch opt.Type {
+	case OptionTypeDuration:
+		_, err = parseDuration(value)
+	case OptionTypeSize:
+		_, err = parseSize(value)
+	case OptionTypeEnum:
+		if strings.EqualFold(value, opt.Default) {
+			return nil
+		}
+		for _, allowed := range opt.AllowedValues {
+			if strings.EqualFold(value, allowed) {
+				return nil
+			}
+		}
+		err = fmt.Errorf("must be one of: %s", strings.Join(opt.AllowedValues, ", "))
+	case OptionTypeCount:
+		if _, parseErr := strconv.ParseUint(value, 10, 64); parseErr != nil {
+			err = errors.New("must be a non-negative integer")
+		}
+	case OptionTypeFloat:
+		_, err = parseFloat(value)
+	case OptionTypeTime:
+		_, err = opt.parseTime(value)
+	case OptionTypeTemplate:
+		_, err = opt.parseTemplate(value)
+	case OptionTypeMap:
+		for _, entry := range splitValue(value, opt.delimiter) {
+			if _, _, ok := splitMapEntry(entry); !ok {
+				return errors.New("each entry must be in key=value format")
+			}
+		}
+	}
+	if err == nil && opt.Type != OptionTypeEnum && len(opt.AllowedValues) > 0 {
+		err = opt.checkChoices(value)
+	}
+	return err
+}
+
+// checkChoices returns an error if value, which should already be unquoted,
+// is not one of the choices set via SetChoices. The default value is always
+// permitted. For multi-valued options, each value is checked.
+func (opt *Option) checkChoices(value string) error {
+	if strings.EqualFold(value, unquote(opt.Default)) || opt.matchesChoices(value) {
+		return nil
+	}
+	return fmt.Errorf("must be one of: %s", strings.Join(opt.AllowedValues, ", "))
+}
+
+// matchesChoices returns true if value, which should already be unquoted, is
+// one of the Option's AllowedValues, compared case-insensitively. For
+// multi-valued options, each value must match.
+func (opt *Option) matchesChoices(value string) bool {
+	values := []string{value}
+	if opt.Type == OptionTypeMulti {
+		values = splitValue(value, opt.delimiter)
+	}
+	for _, v := range values {
+		var found bool
+		for _, allowed := range opt.AllowedValues {
+			found = found || strings.EqualFold(v, allowed)
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// parseFloat parses a decimal floating-point number. An empty string is
+// treated as 0. Infinities, NaN, and the hexadecimal and underscore-separated
+// forms accepted by strconv.ParseFloat are not permitted.
+func parseFloat(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsInf(f, 0) || math.IsNaN(f) || strings.ContainsAny(value, "_xX") {
+		return 0, errors.New("not a valid number; use digits with an optional decimal point and exponent, such as 0.75 or 1e-3")
+	}
+	return f, nil
+}
+
+// parseTime parses a timestamp in RFC 3339 format, or in any of the Option's
+// additional layouts. An empty string is treated as the zero time.
+func (opt *Option) parseTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	loc := opt.timeLocation
+	if loc == nil {
+		loc = time.UTC
+	}
+	for _, layout := range opt.timeLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	msg := "not a valid time; use RFC 3339 format, such as 2006-01-02T15:04:05Z"
+	if len(opt.timeLayouts) > 0 {
+		msg += fmt.Sprintf(", or one of these layouts: %s", strings.Join(opt.timeLayouts, ", "))
+	}
+	return time.Time{}, errors.New(msg)
+}
+
+// parseDuration parses a duration in any format accepted by
+// time.ParseDuration, or a bare integer number of seconds. An empty string is
+// treated as 0. Negative durations are not permitted.
+func parseDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	var d time.Duration
+	if seconds, err := strconv.ParseUint(value, 10, 32); err == nil {
+		d = time.Duration(seconds) * time.Second
+	} else if d, err = time.ParseDuration(value); err != nil {
+		return 0, errors.New("not a valid duration; use a number of seconds, or a number with a unit suffix such as 250ms, 30s, 5m, or 2h")
+	}
+	if d < 0 {
+		return 0, errors.New("duration cannot be negative")
+	}
+	return d, nil
+}
+
+// parseSize parses a byte size, with an optional suffix of K, M, G, or T
+// (optionally followed by B, in either case). The number may have a fractional
+// part, for example "1.5G", as long as the result is a whole number of bytes.
+// An empty string is treated as 0.
+func parseSize(value string) (uint64, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return 0, nil
+	}
+	value = strings.TrimSuffix(value, "b")
+	var multiplier uint64 = 1
+	if value != "" {
+		switch value[len(value)-1] {
+		case 'k':
+			multiplier = 1024
+		case 'm':
+			multiplier = 1024 * 1024
+		case 'g':
+			multiplier = 1024 * 1024 * 1024
+		case 't':
+			multiplier = 1024 * 1024 * 1024 * 1024
+		}
+		if multiplier > 1 {
+			value = value[:len(value)-1]
+		}
+	}
+	whole, frac := value, ""
+	if dot := strings.IndexByte(value, '.'); dot >= 0 {
+		whole, frac = value[:dot], value[dot+1:]
+		if whole == "" && frac != "" {
+			whole = "0"
+		}
+	}
+	numVal, err := strconv.ParseUint(whole, 10, 64)
+	if err != nil || strings.Trim(frac, "0123456789") != "" {
+		return 0, errors.New("not a valid size; use a number of bytes, optionally with a suffix of K, M, G, or T")
+	}
+	if numVal > math.MaxUint64/multiplier {
+		return 0, errors.New("size is too large")
+	}
+	size := numVal * multiplier
+	if frac != "" {
+		// Compute the fractional part exactly, to avoid floating-point rounding
+		num, _ := new(big.Int).SetString(frac, 10)
+		num.Mul(num, new(big.Int).SetUint64(multiplier))
+		den := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(len(frac))), nil)
+		extra, rem := num.QuoRem(num, den, new(big.Int))
+		if rem.Sign() != 0 {
+			return 0, errors.New("size must be a whole number of bytes")
+		}
+		if extra.Uint64() > math.MaxUint64-size { // extra < multiplier, so fits in a uint64
+			return 0, errors.New("size is too large")
+		}
+		size += extra.Uint64()
+	}
+	return size, nil
+}
+
+// formatSize returns a string representation of a byte size, using the largest
+// suffix that can represent it exactly.
+func formatSize(size uint64) string {
+	for _, unit := range []struct {
+		suffix     string
+		multiplier uint64
+	}{{"G", 1024 * 1024 * 1024}, {"M", 1024 * 1024}, {"K", 1024}} {
+		if size >= unit.multiplier && size%unit.multiplier == 0 {
+			return fmt.Sprintf("%d%s", size/unit.multiplier, unit.suffix)
+		}
+	}
+	return strconv.FormatUint(size, 10)
+}
+
 // OptionGroup is a group of related Options, used in generation of usage
 // instructions for a Command.
 type OptionGroup struct {
@@ -209,15 +939,19 @@ func newOptionGroup(group string, options []*Option) *OptionGroup {
 // parses it into separate key and value. It also returns whether the arg
 // included a value (to tell "" vs no-value) and whether it had a "loose-"
 // prefix, meaning that the calling parser shouldn't return an error if the key
-// does not correspond to any existing option.
+// does not correspond to any existing option. The returned key is always in
+// canonical form: lowercase, with any underscores converted to dashes.
 func NormalizeOptionToken(arg string) (key, value string, hasValue, loose bool) {
-	tokens := strings.SplitN(arg, "=", 2)
-	key = strings.TrimFunc(tokens[0], unicode.IsSpace)
+	key, rawValue := arg, ""
+	if eq := strings.IndexByte(arg, '='); eq > -1 {
+		key, rawValue, hasValue = arg[:eq], arg[eq+1:], true
+	}
+	key = strings.TrimFunc(key, unicode.IsSpace)
 	if key == "" {
+		hasValue = false
 		return
 	}
-	key = strings.ToLower(key)
-	key = strings.Replace(key, "_", "-", -1)
+	key = canonicalOptionName(key)
 
 	if strings.HasPrefix(key, "loose-") {
 		key = key[6:]
@@ -235,9 +969,8 @@ func NormalizeOptionToken(arg string) (key, value string, hasValue, loose bool)
 		key = key[7:]
 	}
 
-	if len(tokens) > 1 {
-		hasValue = true
-		value = strings.TrimFunc(tokens[1], unicode.IsSpace)
+	if hasValue {
+		value = strings.TrimFunc(rawValue, unicode.IsSpace)
 		// negated and value supplied: set to falsey value of "" UNLESS the value is
 		// also falsey, in which case we have a double-negative, meaning enable
 		if negated {
@@ -269,40 +1002,42 @@ func BoolValue(input string) bool {
 	}
 }
 
+// canonicalOptionName returns the canonical form of an option name: lowercase,
+// with any underscores converted to dashes. Unlike NormalizeOptionName, this
+// does not strip prefixes such as "loose-" or "skip-". Names which are already
+// canonical are returned without allocating, and ASCII names are converted in
+// a single pass, since this is called for every line of every option file.
+func canonicalOptionName(name string) string {
+	first := -1
+	for n := 0; n < len(name); n++ {
+		c := name[n]
+		if c >= utf8.RuneSelf {
+			return strings.Replace(strings.ToLower(name), "_", "-", -1)
+		} else if first == -1 && (c == '_' || ('A' <= c && c <= 'Z')) {
+			first = n
+		}
+	}
+	if first == -1 {
+		return name
+	}
+	var b strings.Builder
+	b.Grow(len(name))
+	b.WriteString(name[:first])
+	for n := first; n < len(name); n++ {
+		c := name[n]
+		if c == '_' {
+			c = '-'
+		} else if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
 // NormalizeOptionName is a convenience function that only returns the "key"
 // portion of NormalizeOptionToken.
 func NormalizeOptionName(name string) string {
 	ret, _, _, _ := NormalizeOptionToken(name)
 	return ret
 }
-
-// OptionNotDefinedError is an error returned when an unknown Option is used.
-type OptionNotDefinedError struct {
-	Name   string
-	Source string
-}
-
-// Error satisfies golang's error interface.
-func (ond OptionNotDefinedError) Error() string {
-	var source string
-	if ond.Source != "" {
-		source = fmt.Sprintf("%s: ", ond.Source)
-	}
-	return fmt.Sprintf("%sUnknown option \"%s\"", source, ond.Name)
-}
-
-// OptionMissingValueError is an error returned when an Option requires a value,
-// but no value was supplied.
-type OptionMissingValueError struct {
-	Name   string
-	Source string
-}
-
-// Error satisfies golang's error interface.
-func (omv OptionMissingValueError) Error() string {
-	var source string
-	if omv.Source != "" {
-		source = fmt.Sprintf("%s: ", omv.Source)
-	}
-	return fmt.Sprintf("%sMissing required value for option %s", source, omv.Name)
-}
@@ -0,0 +1,71 @@
+package mybase
+
+import "fmt"
+
+// OptionSet is a reusable bundle of related Options, such as options for
+// connecting to a database, which may be attached to any number of Commands
+// via Command.AddOptionSet. Every Command shares the same Option values, so
+// there is a single place to define or update each option's default value,
+// description, or other settings, rather than copying option definitions
+// between commands and risking them drifting apart.
+type OptionSet struct {
+	Name     string // option group name used in help output; empty for the unnamed group
+	options  []*Option
+	commands []*Command // commands which the set has been attached to
+}
+
+// NewOptionSet returns an OptionSet containing the supplied Options, which
+// will be listed in help output under the supplied group name.
+func NewOptionSet(name string, opts ...*Option) *OptionSet {
+	set := &OptionSet{Name: name}
+	set.Add(opts...)
+	return set
+}
+
+// Add appends Options to the set. If the set has already been attached to any
+// Commands, the Options are also added to each of them.
+func (set *OptionSet) Add(opts ...*Option) {
+	set.options = append(set.options, opts...)
+	for _, cmd := range set.commands {
+		set.attach(cmd, opts)
+	}
+}
+
+// Options returns the Options in the set, in the order they were added.
+// The returned slice is a copy, but the Options themselves are shared.
+func (set *OptionSet) Options() []*Option {
+	return append([]*Option(nil), set.options...)
+}
+
+// Option returns the Option in the set with the supplied name or alias, so
+// that its settings may be updated for every Command that the set is attached
+// to. Panics if the set has no such Option, as this indicates programmer
+// error.
+func (set *OptionSet) Option(name string) *Option {
+	canonical := canonicalOptionName(name)
+	for _, opt := range set.options {
+		if canonicalOptionName(opt.Name) == canonical || opt.HasAlias(canonical) {
+			return opt
+		}
+	}
+	panic(fmt.Errorf("Assertion failed: option set %q has no option %s", set.Name, name))
+}
+
+// AddOptionSet attaches each OptionSet to cmd, adding their Options as with
+// AddOptionGroup, using each set's Name as the group name. Options later added
+// to a set are added to cmd as well.
+func (cmd *Command) AddOptionSet(sets ...*OptionSet) {
+	for _, set := range sets {
+		set.commands = append(set.commands, cmd)
+		set.attach(cmd, set.options)
+	}
+}
+
+// attach adds opts, which belong to set, to cmd.
+func (set *OptionSet) attach(cmd *Command, opts []*Option) {
+	if set.Name == "" {
+		cmd.AddOptions("", opts...)
+	} else {
+		cmd.AddOptionGroup(set.Name, opts...)
+	}
+}
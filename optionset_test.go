@@ -0,0 +1,48 @@
+package mybase
+
+import (
+	"testing"
+)
+
+func TestOptionSet(t *testing.T) {
+	conn := NewOptionSet("connection",
+		StringOption("host", 'h', "localhost", "Database host"),
+		StringOption("port", 'P', "3306", "Database port").AddAlias("db-port"),
+	)
+	suite := NewCommandSuite("mycommand", "summary", "description")
+	one := NewCommand("one", "summary", "description", nil)
+	two := NewCommand("two", "summary", "description", nil)
+	suite.AddSubCommand(one)
+	suite.AddSubCommand(two)
+	one.AddOptionSet(conn)
+	two.AddOptionSet(conn)
+
+	// Updates via the set apply to every command
+	conn.Option("DB_PORT").Default = "3307"
+	conn.Add(BoolOption("ssl", 0, false, "Use SSL"))
+	for _, name := range []string{"one", "two"} {
+		cfg := ParseFakeCLI(t, suite, "mycommand "+name+" -h db1")
+		if cfg.Get("host") != "db1" || cfg.Get("port") != "3307" || cfg.GetBool("ssl") {
+			t.Errorf("Unexpected values for command %s: host=%q port=%q ssl=%t", name, cfg.Get("host"), cfg.Get("port"), cfg.GetBool("ssl"))
+		}
+	}
+	if _, ok := suite.Options()["host"]; ok {
+		t.Error("Expected option set to only affect commands it was attached to")
+	}
+	if len(conn.Options()) != 3 {
+		t.Errorf("Unexpected Options from set: %v", conn.Options())
+	}
+
+	// Help output lists the set as a named group
+	data := one.HelpData()
+	if len(data.OptionGroups) == 0 || data.OptionGroups[0].Title != "Connection Options" || len(data.OptionGroups[0].Options) != 3 {
+		t.Errorf("Unexpected option groups: %+v", data.OptionGroups)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected OptionSet.Option to panic for nonexistent option, but it did not")
+		}
+	}()
+	conn.Option("nope")
+}
@@ -0,0 +1,134 @@
+package mybase
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AddConfigJSONOption adds a string option, config-json, to cmd. Its value is a
+// JSON object of option names and values, which Config.UseConfigJSON layers
+// over option files. This permits callers such as CI pipelines to supply
+// several option values in a single argument, without generating a temporary
+// option file.
+func (cmd *Command) AddConfigJSONOption() {
+	cmd.AddOptions("global",
+		StringOption("config-json", 0, "", "Apply option values from a JSON object, e.g. '{\"port\": 3307}', overriding option files"),
+	)
+}
+
+// UseConfigJSON applies the value of the config-json option via ApplyJSON. The
+// option must have been added via Command.AddConfigJSONOption. This should be
+// called after all option files have been added to cfg as sources. If the
+// option has no value, UseConfigJSON does nothing. Panics if cfg's command
+// does not have a config-json option, since this is indicative of programmer
+// error.
+func (cfg *Config) UseConfigJSON() error {
+	if cfg.FindOption("config-json") == nil {
+		panic(fmt.Errorf("UseConfigJSON: command %s does not have a config-json option; call AddConfigJSONOption first", cfg.CLI.Command.Name))
+	}
+	if data := cfg.Get("config-json"); data != "" {
+		return cfg.ApplyJSON(data)
+	}
+	return nil
+}
+
+// ApplyJSON adds a source to cfg containing the option values in data, which
+// must be a JSON object mapping option names (or aliases) to values. The source
+// overrides all previously-added sources, but not the command-line. Values may
+// be strings, numbers, booleans, or null; null is equivalent to an option file
+// line which names the option without a value, such as "skip-networking".
+// Nested objects and arrays are not permitted.
+//
+// If data is not a valid JSON object, or any member is not a known option or
+// has a value which is not valid for the option's type, an error is returned
+// and no source is added. If there are multiple such problems with members,
+// they are returned as ParseErrors, ordered by option name.
+func (cfg *Config) ApplyJSON(data string) error {
+	source, err := cfg.parseJSONValues(data, jsonSource(nil).String())
+	if err != nil {
+		return err
+	}
+	cfg.AddSource(source)
+	return nil
+}
+
+// parseJSONValues converts data, a JSON object of option names and values, into
+// a map of canonical option names to values in option file form. Any errors
+// refer to the values' source by sourceName. See ApplyJSON for the permitted
+// formats of data.
+func (cfg *Config) parseJSONValues(data, sourceName string) (jsonSource, error) {
+	dec := json.NewDecoder(strings.NewReader(data))
+	dec.UseNumber()
+	var members map[string]interface{}
+	if err := dec.Decode(&members); err != nil {
+		return nil, fmt.Errorf("%s: invalid JSON object: %s", sourceName, err)
+	} else if members == nil {
+		return nil, fmt.Errorf("%s: invalid JSON object: top-level value must be an object", sourceName)
+	} else if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("%s: invalid JSON object: unexpected content after top-level object", sourceName)
+	}
+
+	names := make([]string, 0, len(members))
+	for name := range members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	source := make(jsonSource, len(members))
+	options := cfg.CLI.Command.Options()
+	var problems ParseErrors
+	for _, name := range names {
+		opt := cfg.FindOption(name)
+		if opt == nil {
+			problems = append(problems, OptionNotDefinedError{Name: name, Source: sourceName, Suggestion: optionSuggestion(canonicalOptionName(name), options)})
+			continue
+		}
+		var value string
+		switch member := members[name].(type) {
+		case nil:
+			if opt.RequireValue {
+				problems = append(problems, OptionMissingValueError{Name: opt.Name, Source: sourceName})
+				continue
+			} else if opt.Type == OptionTypeBool || opt.Type == OptionTypeCount {
+				value = "1"
+			}
+		case string:
+			value = QuoteValue(member)
+		case json.Number:
+			value = member.String()
+		case bool:
+			value = strconv.FormatBool(member)
+		default:
+			problems = append(problems, OptionValueError{Name: opt.Name, Value: opt.displayValue(fmt.Sprint(member)), Problem: "only strings, numbers, booleans, and null are permitted", Source: sourceName})
+			continue
+		}
+		if err := opt.checkValue(value); err != nil {
+			problems = append(problems, OptionValueError{Name: opt.Name, Value: opt.displayValue(unquote(value)), Problem: err.Error(), Source: sourceName})
+			continue
+		}
+		source[opt.Name] = value
+	}
+	if len(problems) == 1 {
+		return nil, problems[0]
+	} else if len(problems) > 1 {
+		return nil, problems
+	}
+	return source, nil
+}
+
+// jsonSource is an OptionValuer storing values that were supplied to
+// Config.ApplyJSON, keyed by canonical option name. Once created, it is never
+// modified.
+type jsonSource map[string]string
+
+func (src jsonSource) OptionValue(optionName string) (string, bool) {
+	value, ok := src[optionName]
+	return value, ok
+}
+
+func (src jsonSource) String() string {
+	return "JSON overlay"
+}
@@ -0,0 +1,63 @@
+package mybase
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestApplyJSON(t *testing.T) {
+	cmd := simpleCommand()
+	cmd.AddOption(StringOption("timeout", 0, "1s", "dummy description").SetType(OptionTypeDuration))
+	cmd.AddConfigJSONOption()
+
+	cfg := ParseFakeCLI(t, cmd, `mycommand --hasshort=cli --config-json='{"visible": "json", "hasshort": "json", "BOOL1": true, "bool2": null, "timeout": "5s", "hidden": " padded "}' arg1`)
+	f, err := getParsedFile(cfg, false, "visible=file\ntimeout=2s\n")
+	if err != nil {
+		t.Fatalf("Unexpected error from getParsedFile: %v", err)
+	}
+	cfg.AddSource(f)
+	if err := cfg.UseConfigJSON(); err != nil {
+		t.Fatalf("Unexpected error from UseConfigJSON: %v", err)
+	}
+	if cfg.Get("visible") != "json" || cfg.Get("hasshort") != "cli" || cfg.Get("timeout") != "5s" || cfg.Get("hidden") != " padded " {
+		t.Errorf("Unexpected values: visible=%q hasshort=%q timeout=%q hidden=%q", cfg.Get("visible"), cfg.Get("hasshort"), cfg.Get("timeout"), cfg.Get("hidden"))
+	}
+	if !cfg.GetBool("bool1") || !cfg.GetBool("bool2") {
+		t.Errorf("Expected bool options to be enabled, instead found bool1=%t bool2=%t", cfg.GetBool("bool1"), cfg.GetBool("bool2"))
+	}
+	if source := cfg.Source("visible"); source == nil || source.(interface{ String() string }).String() != "JSON overlay" {
+		t.Errorf("Unexpected source for visible: %v", source)
+	}
+
+	// Invalid input results in an error, without adding a source
+	cases := map[string]string{
+		`[1, 2]`:                             "invalid JSON object",
+		`null`:                               "invalid JSON object",
+		`{"visible": "a"} {}`:                "unexpected content",
+		`{"visibel": "a"}`:                   `did you mean "visible"`,
+		`{"visible": ["a"]}`:                 "only strings",
+		`{"timeout": "soon"}`:                "timeout",
+		`{"visible": null}`:                  "Missing required value",
+		`{"nope": 1, "visible": {"a": "b"}}`: "Unknown option \"nope\"\nJSON overlay: Invalid value",
+	}
+	for input, expected := range cases {
+		cfg := ParseFakeCLI(t, cmd, "mycommand arg1")
+		if err := cfg.ApplyJSON(input); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error containing %q for input %s, instead found %v", expected, input, err)
+		} else if len(cfg.Sources()) != 0 {
+			t.Errorf("Expected no source to be added for input %s", input)
+		}
+	}
+
+	// No-op without a value, and panics without the option
+	cfg = ParseFakeCLI(t, cmd, "mycommand arg1")
+	if err := cfg.UseConfigJSON(); err != nil || len(cfg.Sources()) != 0 {
+		t.Errorf("Expected UseConfigJSON without a value to do nothing; err=%v, sources=%v", err, cfg.Sources())
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected UseConfigJSON to panic without config-json option, but it did not")
+		}
+	}()
+	ParseFakeCLI(t, simpleCommand(), "mycommand arg1").UseConfigJSON()
+}
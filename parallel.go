@@ -0,0 +1,64 @@
+package mybase
+
+import (
+	"runtime"
+	"sync"
+)
+
+// ParseFiles reads and parses the option files at the supplied paths, using up
+// to concurrency goroutines at once. If concurrency is not positive,
+// runtime.GOMAXPROCS(0) goroutines are used. This is useful for applications
+// which scan a large number of small option files, since parsing each one is
+// typically bound by I/O latency rather than CPU.
+//
+// The returned slice always has the same length and order as paths, regardless
+// of the order in which parsing completes. The files are not added to cfg as
+// sources. If any file cannot be parsed, its entry in the returned slice is
+// nil, and an error is returned after all other files have been processed; if
+// multiple files had problems, a ParseErrors value is returned, ordered in the
+// same manner as paths.
+//
+// Any WarningHandler of cfg may be called concurrently from multiple
+// goroutines, for example to warn about insecure file permissions.
+func ParseFiles(cfg *Config, paths []string, concurrency int) ([]*File, error) {
+	if concurrency <= 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+	if concurrency > len(paths) {
+		concurrency = len(paths)
+	}
+	files := make([]*File, len(paths))
+	errs := make([]error, len(paths))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for n := 0; n < concurrency; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				f := NewFile(paths[i])
+				if errs[i] = f.Parse(cfg); errs[i] == nil {
+					files[i] = f
+				}
+			}
+		}()
+	}
+	for i := range paths {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	var problems ParseErrors
+	for _, err := range errs {
+		if err != nil {
+			problems = append(problems, err)
+		}
+	}
+	if len(problems) == 1 {
+		return files, problems[0]
+	} else if len(problems) > 1 {
+		return files, problems
+	}
+	return files, nil
+}
@@ -0,0 +1,58 @@
+package mybase
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mybasetest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var paths []string
+	for n := 0; n < 20; n++ {
+		path := filepath.Join(dir, fmt.Sprintf("file%02d.cnf", n))
+		contents := fmt.Sprintf("visible=%d\n", n)
+		if n == 7 || n == 13 {
+			contents = "nope=1\n"
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatalf("Unable to write %s: %v", path, err)
+		}
+		paths = append(paths, path)
+	}
+	cfg := ParseFakeCLI(t, simpleCommand(), "mycommand arg1")
+
+	for _, concurrency := range []int{0, 1, 4, 100} {
+		files, err := ParseFiles(cfg, paths, concurrency)
+		if len(files) != len(paths) {
+			t.Fatalf("Expected %d files, instead found %d", len(paths), len(files))
+		}
+		for n, f := range files {
+			if n == 7 || n == 13 {
+				if f != nil {
+					t.Errorf("Expected nil entry for file with error, instead found %v", f)
+				}
+			} else if f == nil || f.Path() != paths[n] {
+				t.Errorf("Unexpected file at position %d: %v", n, f)
+			} else if value, _ := f.OptionValue("visible"); value != fmt.Sprint(n) {
+				t.Errorf("Unexpected value in %s: %q", f.Path(), value)
+			}
+		}
+		if pe, ok := err.(ParseErrors); !ok || len(pe) != 2 {
+			t.Errorf("Expected ParseErrors with 2 errors, instead found %T %v", err, err)
+		} else if pe[0].(ParseError).Location() != paths[7] || pe[1].(ParseError).Location() != paths[13] {
+			t.Errorf("Errors are not ordered by path: %v", pe)
+		}
+	}
+
+	if files, err := ParseFiles(cfg, nil, 0); len(files) != 0 || err != nil {
+		t.Errorf("Unexpected result from ParseFiles with no paths: %v, %v", files, err)
+	}
+}
@@ -0,0 +1,187 @@
+package mybase
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	pluginsMu sync.Mutex
+	plugins   = make(map[string][]*Command) // full name of parent command => subcommands
+)
+
+// RegisterPlugin makes sub available as a subcommand of the command suite
+// whose full name is parentName, for example "myapp" for a top-level command,
+// or "myapp db" for a nested command suite. This permits independently-
+// developed packages to contribute subcommands, along with their own options,
+// without modifying the application's command tree directly: typically a
+// plugin package calls RegisterPlugin from its init function, and the
+// application imports the package for its side effects.
+//
+// Registered subcommands are not added until the application calls
+// Command.AddPlugins, which should be done at startup, prior to parsing the
+// command-line.
+func RegisterPlugin(parentName string, sub *Command) {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	plugins[parentName] = append(plugins[parentName], sub)
+}
+
+// AddPlugins adds all subcommands which were registered via RegisterPlugin for
+// cmd or any of its descendant command suites, including suites which were
+// themselves registered as plugins. Subcommands which were already added are
+// skipped, so calling AddPlugins more than once is harmless. An error is
+// returned if a plugin's subcommand name conflicts with a different existing
+// subcommand or plugin, or if a plugin was registered for a command which is
+// not a command suite; in this case, none of the plugins for that command are
+// added.
+func (cmd *Command) AddPlugins() error {
+	pluginsMu.Lock()
+	registered := make(map[string][]*Command, len(plugins))
+	for parentName, subs := range plugins {
+		registered[parentName] = append([]*Command{}, subs...)
+	}
+	pluginsMu.Unlock()
+	return cmd.addPlugins(registered)
+}
+
+// addPlugins implements AddPlugins recursively, using a snapshot of the
+// registered plugins.
+func (cmd *Command) addPlugins(registered map[string][]*Command) error {
+	fullName := cmd.fullName()
+	subs := registered[fullName]
+	if len(subs) > 0 && (cmd.SubCommands == nil || cmd.Handler != nil) {
+		return fmt.Errorf("Cannot add plugin subcommand %s to command %s: not a command suite", subs[0].Name, fullName)
+	}
+	pending := make(map[string]*Command, len(subs))
+	for _, sub := range subs {
+		existing, exists := cmd.SubCommands[sub.Name]
+		if other := pending[sub.Name]; (exists && existing != sub) || (other != nil && other != sub) {
+			return fmt.Errorf("Cannot add plugin subcommand %s to command %s: conflicts with another subcommand of the same name", sub.Name, fullName)
+		}
+		pending[sub.Name] = sub
+	}
+	for _, sub := range subs {
+		if cmd.SubCommands[sub.Name] != sub {
+			cmd.AddSubCommand(sub)
+		}
+	}
+	for _, sub := range cmd.orderedSubCommands() {
+		if err := sub.addPlugins(registered); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddExternalSubCommands adds a subcommand to cmd for each executable file in
+// the directories of the PATH environment variable whose name consists of
+// cmd's full name, with spaces replaced by dashes, followed by a dash and the
+// subcommand name. For example, if cmd is a top-level command named "myapp",
+// an executable named "myapp-foo" results in subcommand "foo". On Windows, the
+// file must also have an extension listed in the PATHEXT environment
+// variable, which is not considered part of the subcommand name. This permits
+// users to extend an application with programs written in any language, in
+// the same manner as git.
+//
+// Executables found in earlier directories of PATH take precedence over those
+// found later, and existing subcommands of cmd take precedence over all
+// executables. The names of subcommands which were added are returned in
+// alphabetical order.
+//
+// Running an external subcommand runs its executable, supplying all
+// command-line args which followed the subcommand name, as-is; these are not
+// parsed as options, so any --help option is handled by the executable. The
+// executable's exit code is returned via an ExitCoder error, if non-zero.
+// Panics if cmd is not a command suite, since this is indicative of programmer
+// error.
+func (cmd *Command) AddExternalSubCommands() []string {
+	if cmd.SubCommands == nil || cmd.Handler != nil {
+		panic(fmt.Errorf("AddExternalSubCommands: Command %s was not created as a CommandSuite", cmd.Name))
+	}
+	prefix := strings.Replace(cmd.fullName(), " ", "-", -1) + "-"
+	var added []string
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		if dir == "" {
+			continue // don't search the working directory implicitly
+		}
+		file, err := os.Open(dir)
+		if err != nil {
+			continue
+		}
+		fileNames, _ := file.Readdirnames(-1)
+		file.Close()
+		sort.Strings(fileNames)
+		for _, fileName := range fileNames {
+			name, ok := externalSubCommandName(fileName, prefix)
+			if !ok {
+				continue
+			} else if _, exists := cmd.SubCommands[name]; exists {
+				continue
+			}
+			filePath := filepath.Join(dir, fileName)
+			if info, err := os.Stat(filePath); err != nil || !info.Mode().IsRegular() || (runtime.GOOS != "windows" && info.Mode().Perm()&0111 == 0) {
+				continue
+			}
+			cmd.AddSubCommand(externalSubCommand(name, filePath))
+			added = append(added, name)
+		}
+	}
+	sort.Strings(added)
+	return added
+}
+
+// externalSubCommandName returns the subcommand name corresponding to an
+// executable file name, if it begins with prefix and (on Windows) has an
+// executable extension.
+func externalSubCommandName(fileName, prefix string) (string, bool) {
+	if !strings.HasPrefix(fileName, prefix) {
+		return "", false
+	}
+	name := fileName[len(prefix):]
+	if runtime.GOOS == "windows" {
+		pathExt := os.Getenv("PATHEXT")
+		if pathExt == "" {
+			pathExt = ".com;.exe;.bat;.cmd"
+		}
+		ext := filepath.Ext(name)
+		var executable bool
+		for _, allowed := range filepath.SplitList(pathExt) {
+			executable = executable || (ext != "" && strings.EqualFold(ext, allowed))
+		}
+		if !executable {
+			return "", false
+		}
+		name = strings.TrimSuffix(name, ext)
+	}
+	if name == "" || strings.ContainsAny(name, " \t") || strings.HasPrefix(name, "-") {
+		return "", false
+	}
+	return name, true
+}
+
+// externalSubCommand returns a Command which runs the executable at filePath.
+func externalSubCommand(name, filePath string) *Command {
+	sub := NewCommand(name, fmt.Sprintf("Run external command %s", filepath.Base(filePath)), fmt.Sprintf("Runs the external command %s, supplying all remaining args as-is.", filePath), nil)
+	sub.AddVariadicArg("args", false)
+	sub.rawArgs = true
+	sub.SetContextHandler(func(ctx context.Context, cfg *Config) error {
+		external := exec.CommandContext(ctx, filePath, cfg.CLI.ArgValues...)
+		external.Stdin, external.Stdout, external.Stderr = os.Stdin, os.Stdout, os.Stderr
+		err := external.Run()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		} else if exitErr, ok := err.(*exec.ExitError); ok {
+			return &ExitValue{Code: exitErr.ExitCode()}
+		}
+		return err
+	})
+	return sub
+}
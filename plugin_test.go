@@ -0,0 +1,105 @@
+package mybase
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestAddPlugins(t *testing.T) {
+	pluginsMu.Lock()
+	defer func(orig map[string][]*Command) {
+		pluginsMu.Lock()
+		plugins = orig
+		pluginsMu.Unlock()
+	}(plugins)
+	plugins = make(map[string][]*Command)
+	pluginsMu.Unlock()
+
+	plugin := NewCommand("plugged", "summary", "description", nil)
+	plugin.AddOption(StringOption("pluginopt", 0, "", "dummy description"))
+	RegisterPlugin("mycommand", plugin)
+	nested := NewCommandSuite("nested", "summary", "description")
+	RegisterPlugin("mycommand", nested)
+	RegisterPlugin("mycommand nested", NewCommand("deep", "summary", "description", nil))
+
+	suite := simpleCommandSuite()
+	for n := 0; n < 2; n++ {
+		if err := suite.AddPlugins(); err != nil {
+			t.Fatalf("Unexpected error from AddPlugins call %d: %v", n, err)
+		}
+	}
+	cfg := ParseFakeCLI(t, suite, "mycommand plugged --pluginopt=hello")
+	if cfg.CLI.Command != plugin || cfg.Get("pluginopt") != "hello" {
+		t.Errorf("Unexpected command %s or option value %q", cfg.CLI.Command.Name, cfg.Get("pluginopt"))
+	}
+	if cfg = ParseFakeCLI(t, suite, "mycommand nested deep"); cfg.CLI.Command.Name != "deep" {
+		t.Errorf("Expected nested plugin to be added, instead found command %s", cfg.CLI.Command.Name)
+	}
+
+	// Conflicting names and non-suite parents are errors
+	RegisterPlugin("mycommand", NewCommand("one", "summary", "description", nil))
+	if err := simpleCommandSuite().AddPlugins(); err == nil {
+		t.Error("Expected error from conflicting plugin name, but err is nil")
+	}
+	plugins = make(map[string][]*Command)
+	RegisterPlugin("mycommand two", NewCommand("three", "summary", "description", nil))
+	if err := simpleCommandSuite().AddPlugins(); err == nil {
+		t.Error("Expected error from plugin for non-suite command, but err is nil")
+	}
+}
+
+func TestAddExternalSubCommands(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test uses shell scripts, which are not supported on Windows")
+	}
+	dir, err := ioutil.TempDir("", "mybasetest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "output")
+	scripts := map[string]string{
+		"mycommand-hello":   "#!/bin/sh\necho \"$@\" > " + output + "\n",
+		"mycommand-fail":    "#!/bin/sh\nexit 3\n",
+		"mycommand-one":     "#!/bin/sh\nexit 0\n",
+		"othercommand-nope": "#!/bin/sh\nexit 0\n",
+	}
+	for name, contents := range scripts {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0755); err != nil {
+			t.Fatalf("Unable to write script: %v", err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "mycommand-noexec"), []byte("#!/bin/sh\n"), 0644); err != nil {
+		t.Fatalf("Unable to write script: %v", err)
+	}
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", dir)
+
+	suite := simpleCommandSuite()
+	added := suite.AddExternalSubCommands()
+	if strings.Join(added, ",") != "fail,hello" {
+		t.Errorf("Unexpected result from AddExternalSubCommands: %v", added)
+	}
+
+	// Args following the subcommand name are passed as-is, without parsing
+	cfg := ParseFakeCLI(t, suite, "mycommand --visible=x hello --foo -b bar --help")
+	if err := cfg.HandleCommand(); err != nil {
+		t.Errorf("Unexpected error from external subcommand: %v", err)
+	}
+	if contents, err := ioutil.ReadFile(output); err != nil || string(contents) != "--foo -b bar --help\n" {
+		t.Errorf("Unexpected output from external subcommand: %q (err=%v)", contents, err)
+	}
+	if cfg.Get("visible") != "x" {
+		t.Errorf("Expected options prior to subcommand name to be parsed normally, instead found %q", cfg.Get("visible"))
+	}
+
+	// Exit codes are propagated
+	cfg = ParseFakeCLI(t, suite, "mycommand fail")
+	if err := cfg.HandleCommand(); ExitCode(err) != 3 {
+		t.Errorf("Expected exit code 3, instead found %d (err=%v)", ExitCode(err), err)
+	}
+}
@@ -0,0 +1,102 @@
+package mybase
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// AddProfileOption adds a --profile option to cmd, in the "global" group. A
+// profile is a named bundle of option values, which is defined by a section of
+// that name in any option file; see Config.UseProfile. Typically this is
+// called on the top-level command, so that the option is available to all
+// subcommands.
+func (cmd *Command) AddProfileOption() {
+	cmd.AddOptions("global",
+		StringOption("profile", 0, "", "Use option values from the named profile, defined by a section of that name in option files").Bootstrap(),
+	)
+}
+
+// ProfileNotFoundError is returned by Config.UseProfile when none of the
+// Config's option files define the requested profile.
+type ProfileNotFoundError struct {
+	Name string
+}
+
+// Error satisfies golang's error interface.
+func (pnf ProfileNotFoundError) Error() string {
+	return fmt.Sprintf("Profile %s is not defined in any option file", pnf.Name)
+}
+
+// Is returns true if target is ErrSectionNotFound, permitting use of errors.Is.
+func (pnf ProfileNotFoundError) Is(target error) bool { return target == ErrSectionNotFound }
+
+// UseProfile activates the profile named by the value of the profile option,
+// which must have been added via Command.AddProfileOption. This should be
+// called after all option files have been added to cfg as sources. If the
+// profile option has no value, UseProfile does nothing; its value may come
+// from any source, including the default section of an option file.
+//
+// For each File source of cfg which has a section with the profile's name,
+// that section is selected via UseSection, taking precedence over any sections
+// that were already selected in the file. It is not an error for some files to
+// lack the section, but a ProfileNotFoundError is returned if no file has it.
+//
+// If envPrefix is non-empty, an EnvSource is also added to cfg, at higher
+// precedence than all other sources except the command-line. Its prefix is
+// envPrefix followed by the profile name in uppercase, with any characters
+// other than letters and digits replaced by underscores. For example, with
+// envPrefix "MYAPP" and profile "staging", option port may be supplied by the
+// environment variable MYAPP_STAGING_PORT.
+//
+// Panics if cfg's command does not have a profile option, since this is
+// indicative of programmer error.
+func (cfg *Config) UseProfile(envPrefix string) error {
+	if cfg.FindOption("profile") == nil {
+		panic(fmt.Errorf("UseProfile: command %s does not have a profile option; call AddProfileOption first", cfg.CLI.Command.Name))
+	}
+	name := cfg.Get("profile")
+	if name == "" {
+		return nil
+	}
+
+	var found bool
+	for _, source := range cfg.Sources() {
+		f, ok := source.(*File)
+		if !ok || !f.HasSection(name) {
+			continue
+		}
+		f.mu.RLock()
+		sections := []string{name}
+		for _, selected := range f.selected {
+			if selected != name && selected != "" {
+				sections = append(sections, selected)
+			}
+		}
+		f.mu.RUnlock()
+		if err := f.UseSection(sections...); err != nil {
+			return err
+		}
+		found = true
+	}
+	if !found {
+		return ProfileNotFoundError{Name: name}
+	}
+
+	if envPrefix != "" {
+		cfg.AddSource(NewEnvSource(profileEnvPrefix(envPrefix, name)))
+	}
+	return nil
+}
+
+// profileEnvPrefix returns the environment variable prefix used by UseProfile
+// for the named profile.
+func profileEnvPrefix(envPrefix, name string) string {
+	mapped := strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, name)
+	return strings.TrimSuffix(envPrefix, "_") + "_" + mapped
+}
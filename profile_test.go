@@ -0,0 +1,72 @@
+package mybase
+
+import (
+	"os"
+	"testing"
+)
+
+func TestUseProfile(t *testing.T) {
+	cmd := simpleCommand()
+	cmd.AddProfileOption()
+
+	getConfig := func(commandLine string) *Config {
+		t.Helper()
+		cfg := ParseFakeCLI(t, cmd, commandLine)
+		f1, err := getParsedFile(cfg, false, "visible=base\nhasshort=base\n\n[staging]\nvisible=staging1\n\n[other]\nhasshort=other\n")
+		if err != nil {
+			t.Fatalf("Unexpected error from getParsedFile: %v", err)
+		}
+		f2, err := getParsedFile(cfg, false, "profile=staging\n\n[prod]\nvisible=prod\n")
+		if err != nil {
+			t.Fatalf("Unexpected error from getParsedFile: %v", err)
+		}
+		f1.UseSection("other")
+		cfg.AddSource(f1)
+		cfg.AddSource(f2)
+		return cfg
+	}
+
+	// Profile may be selected via an option file, and takes precedence over
+	// previously-selected sections; files lacking the section are unaffected
+	cfg := getConfig("mycommand arg1")
+	if err := cfg.UseProfile(""); err != nil {
+		t.Fatalf("Unexpected error from UseProfile: %v", err)
+	}
+	if cfg.Get("visible") != "staging1" || cfg.Get("hasshort") != "other" {
+		t.Errorf("Unexpected values after UseProfile: visible=%q hasshort=%q", cfg.Get("visible"), cfg.Get("hasshort"))
+	}
+
+	// Profile-specific environment variables take precedence over files
+	defer os.Setenv("MYAPP_PROD_HASSHORT", os.Getenv("MYAPP_PROD_HASSHORT"))
+	os.Setenv("MYAPP_PROD_HASSHORT", "env")
+	cfg = getConfig("mycommand --profile=prod arg1")
+	if err := cfg.UseProfile("MYAPP"); err != nil {
+		t.Fatalf("Unexpected error from UseProfile: %v", err)
+	}
+	if cfg.Get("visible") != "prod" || cfg.Get("hasshort") != "env" {
+		t.Errorf("Unexpected values after UseProfile: visible=%q hasshort=%q", cfg.Get("visible"), cfg.Get("hasshort"))
+	}
+	if actual := profileEnvPrefix("MYAPP_", "us-east.1"); actual != "MYAPP_US_EAST_1" {
+		t.Errorf("Unexpected result from profileEnvPrefix: %q", actual)
+	}
+
+	// No profile is a no-op, but a nonexistent profile is an error
+	cfg = ParseFakeCLI(t, cmd, "mycommand arg1")
+	if err := cfg.UseProfile("MYAPP"); err != nil || len(cfg.Sources()) != 0 {
+		t.Errorf("Expected UseProfile without a profile to do nothing; err=%v, sources=%v", err, cfg.Sources())
+	}
+	cfg = getConfig("mycommand --profile=nope arg1")
+	if err := cfg.UseProfile(""); err == nil {
+		t.Error("Expected error from UseProfile with nonexistent profile, but err is nil")
+	} else if _, ok := err.(ProfileNotFoundError); !ok {
+		t.Errorf("Expected error to be ProfileNotFoundError, instead found %T", err)
+	}
+
+	// Panics without the profile option
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected UseProfile to panic without profile option, but it did not")
+		}
+	}()
+	ParseFakeCLI(t, simpleCommand(), "mycommand arg1").UseProfile("")
+}
@@ -0,0 +1,327 @@
+package mybase
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	terminal "golang.org/x/term"
+)
+
+// SetConfirmOption designates a boolean option (for example "force") which,
+// when enabled, causes Confirm to automatically answer yes without prompting.
+// Panics if the named option does not exist, since this is indicative of
+// programmer error.
+func (cfg *Config) SetConfirmOption(name string) {
+	if cfg.FindOption(name) == nil {
+		panic(fmt.Errorf("Assertion failed: SetConfirmOption called on unknown option %s", name))
+	}
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	cfg.confirmOption = name
+}
+
+// Confirm asks the user a yes/no question, and returns their answer. If the
+// user just hits enter, or input is at EOF, defaultYes is returned.
+//
+// No prompt is displayed if the option designated via SetConfirmOption is
+// enabled, in which case true is returned; or if input is not interactive
+// (cfg.PromptInput is nil and STDIN is not a terminal), in which case
+// defaultYes is returned.
+func (cfg *Config) Confirm(question string, defaultYes bool) (bool, error) {
+	cfg.mu.RLock()
+	confirmOption := cfg.confirmOption
+	cfg.mu.RUnlock()
+	if confirmOption != "" && cfg.GetBool(confirmOption) {
+		return true, nil
+	}
+	if !cfg.interactive() {
+		return defaultYes, nil
+	}
+
+	choices := "[y/N]"
+	if defaultYes {
+		choices = "[Y/n]"
+	}
+	for {
+		if _, err := fmt.Fprintf(cfg.promptOutput(), "%s %s ", question, choices); err != nil {
+			return false, err
+		}
+		answer, err := readLine(cfg.promptInput())
+		if err == io.EOF {
+			return defaultYes, nil
+		} else if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "":
+			return defaultYes, nil
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+	}
+}
+
+// PromptValue asks the user to supply a free-form string value, and returns
+// their answer with surrounding whitespace trimmed. If the user just hits
+// enter, or input is at EOF, defaultValue is returned. No prompt is displayed
+// if input is not interactive (cfg.PromptInput is nil and STDIN is not a
+// terminal), in which case defaultValue is returned.
+func (cfg *Config) PromptValue(question, defaultValue string) (string, error) {
+	if !cfg.interactive() {
+		return defaultValue, nil
+	}
+	prompt := question
+	if defaultValue != "" {
+		prompt = fmt.Sprintf("%s [%s]", question, defaultValue)
+	}
+	if _, err := fmt.Fprintf(cfg.promptOutput(), "%s: ", prompt); err != nil {
+		return "", err
+	}
+	answer, err := readLine(cfg.promptInput())
+	if err == io.EOF {
+		return defaultValue, nil
+	} else if err != nil {
+		return "", err
+	}
+	if answer = strings.TrimSpace(answer); answer == "" {
+		return defaultValue, nil
+	}
+	return answer, nil
+}
+
+// PromptIfMissing causes Config.PromptMissing to interactively ask the user
+// for the Option's value, using the supplied question, if no source supplies a
+// value. For a string Option which does not require a value, supplying the
+// Option without a value (for example bare "--password" on the command-line)
+// also results in a prompt. If question is blank, a generic question is used.
+// Panics if used on a boolean Option, since this is indicative of programmer
+// error.
+func (opt *Option) PromptIfMissing(question string) *Option {
+	if opt.Type == OptionTypeBool {
+		panic(fmt.Errorf("Cannot use PromptIfMissing on boolean option %s", opt.Name))
+	}
+	if question == "" {
+		question = fmt.Sprintf("Enter value for %s", opt.Name)
+	}
+	opt.prompt = question
+	return opt
+}
+
+// ConfirmPrompt causes Config.PromptMissing to ask the user to enter the
+// Option's value a second time, using the supplied question, and to repeat
+// both prompts if the two answers differ. This is useful when setting a new
+// password, especially along with Secret. If question is blank, a generic
+// question is used. This has no effect unless PromptIfMissing is also used.
+func (opt *Option) ConfirmPrompt(question string) *Option {
+	if question == "" {
+		question = fmt.Sprintf("Re-enter value for %s", opt.Name)
+	}
+	opt.promptConfirm = question
+	return opt
+}
+
+// SetPromptValidator associates a validation function with answers to the
+// Option's prompt; see PromptIfMissing. Config.PromptMissing calls the
+// validator with each answer that is valid for the Option's type, and if the
+// validator returns an error, displays it and asks the question again. Unlike
+// SetValidator, values from other sources are not checked, so this is suited
+// to rules which only apply to newly-chosen values, such as password strength;
+// see PasswordStrength.
+func (opt *Option) SetPromptValidator(validator OptionValidator) *Option {
+	opt.promptCheck = validator
+	return opt
+}
+
+// PasswordStrength returns an OptionValidator which requires values to be at
+// least minLength characters long, and to contain at least one character from
+// each of the supplied charsets. For example, PasswordStrength(12,
+// "0123456789", "!@#$%^&*") requires at least 12 characters, including a digit
+// and one of the listed symbols. Empty values are not permitted.
+func PasswordStrength(minLength int, charsets ...string) OptionValidator {
+	return func(name, value string) error {
+		if n := utf8.RuneCountInString(value); n == 0 || n < minLength {
+			return fmt.Errorf("must be at least %d characters long", minLength)
+		}
+		for _, charset := range charsets {
+			if !strings.ContainsAny(value, charset) {
+				return fmt.Errorf("must contain at least one of these characters: %s", charset)
+			}
+		}
+		return nil
+	}
+}
+
+// Secret indicates that an Option's value is sensitive, such as a password.
+// When prompting for its value on a terminal, the user's input is not echoed.
+// Secret also implies Sensitive.
+func (opt *Option) Secret() *Option {
+	opt.secret = true
+	return opt.Sensitive()
+}
+
+// promptAnswers is an OptionValuer storing values that were obtained by
+// Config.PromptMissing. The caller must hold a lock on the Config's mu when
+// accessing it.
+type promptAnswers map[string]string
+
+func (pa promptAnswers) OptionValue(optionName string) (string, bool) {
+	value, ok := pa[optionName]
+	return value, ok
+}
+
+func (pa promptAnswers) String() string {
+	return "interactive prompt"
+}
+
+// PromptMissing asks the user for the value of each option which was marked
+// with Option.PromptIfMissing and is not supplied by any source. The answers
+// take precedence over all other sources, including the command-line; Source
+// reports them as coming from "interactive prompt". An answer which is not
+// valid for the option's type, or which is rejected by a validator set via
+// Option.SetPromptValidator, results in the question being asked again, as
+// does a mismatched confirmation for an option using Option.ConfirmPrompt.
+// HandleCommand calls this automatically prior to running the command's
+// handler.
+//
+// Options are prompted in order by name. If input is not interactive
+// (cfg.PromptInput is nil and STDIN is not a terminal), or input reaches EOF,
+// an OptionMissingValueError is returned for the first option lacking a value.
+func (cfg *Config) PromptMissing() error {
+	var opts []*Option
+	for _, opt := range cfg.CLI.Command.Options() {
+		if opt.prompt != "" && cfg.missingValue(opt) {
+			opts = append(opts, opt)
+		}
+	}
+	sort.Slice(opts, func(i, j int) bool { return opts[i].Name < opts[j].Name })
+	for _, opt := range opts {
+		if !cfg.interactive() {
+			return OptionMissingValueError{Name: opt.Name}
+		}
+		for {
+			answer, err := cfg.promptAnswer(opt, opt.prompt)
+			if err != nil {
+				return err
+			}
+			if err := opt.checkValue(QuoteValue(answer)); err != nil {
+				fmt.Fprintf(cfg.promptOutput(), "Invalid value for %s: %v\n", opt.Name, err)
+				continue
+			} else if opt.promptCheck != nil {
+				if err := opt.promptCheck(opt.Name, answer); err != nil {
+					fmt.Fprintf(cfg.promptOutput(), "Invalid value for %s: %v\n", opt.Name, err)
+					continue
+				}
+			}
+			if opt.promptConfirm != "" {
+				confirmation, err := cfg.promptAnswer(opt, opt.promptConfirm)
+				if err != nil {
+					return err
+				} else if confirmation != answer {
+					fmt.Fprintf(cfg.promptOutput(), "Values for %s do not match; please try again\n", opt.Name)
+					continue
+				}
+			}
+			cfg.assertUnsealed("PromptMissing")
+			cfg.mu.Lock()
+			if cfg.prompted == nil {
+				cfg.prompted = make(promptAnswers)
+			}
+			cfg.prompted[opt.Name] = QuoteValue(answer)
+			cfg.dirty = true
+			cfg.mu.Unlock()
+			break
+		}
+	}
+	return nil
+}
+
+// promptAnswer displays question, and returns the user's answer for opt. Input
+// at EOF results in an OptionMissingValueError.
+func (cfg *Config) promptAnswer(opt *Option, question string) (string, error) {
+	if _, err := fmt.Fprintf(cfg.promptOutput(), "%s: ", question); err != nil {
+		return "", err
+	}
+	answer, err := cfg.readAnswer(opt.secret)
+	if err == io.EOF {
+		return "", OptionMissingValueError{Name: opt.Name}
+	} else if err != nil {
+		return "", err
+	}
+	if !opt.secret {
+		answer = strings.TrimSpace(answer)
+	}
+	return answer, nil
+}
+
+// missingValue returns true if no source supplies a value for opt, or if opt
+// is a string Option not requiring a value and it was supplied without one.
+func (cfg *Config) missingValue(opt *Option) bool {
+	if !cfg.Supplied(opt.Name) {
+		return true
+	}
+	return opt.Type == OptionTypeString && !opt.RequireValue && cfg.GetRaw(opt.Name) == ""
+}
+
+// readAnswer reads a line of input for PromptMissing. If secret is true and
+// input comes from a terminal, the input is not echoed.
+func (cfg *Config) readAnswer(secret bool) (string, error) {
+	if osFile, ok := cfg.promptInput().(*os.File); ok && secret {
+		answer, err := terminal.ReadPassword(int(osFile.Fd()))
+		fmt.Fprintln(cfg.promptOutput()) // user's newline was not echoed
+		return string(answer), err
+	}
+	return readLine(cfg.promptInput())
+}
+
+// interactive returns true if prompts should be displayed: either a custom
+// PromptInput has been supplied, or input comes from a terminal.
+func (cfg *Config) interactive() bool {
+	if osFile, ok := cfg.promptInput().(*os.File); ok {
+		return terminal.IsTerminal(int(osFile.Fd()))
+	}
+	return true
+}
+
+func (cfg *Config) promptInput() io.Reader {
+	if cfg.PromptInput == nil {
+		return os.Stdin
+	}
+	return cfg.PromptInput
+}
+
+func (cfg *Config) promptOutput() io.Writer {
+	if cfg.PromptOutput == nil {
+		return os.Stdout
+	}
+	return cfg.PromptOutput
+}
+
+// readLine reads from r until a newline or EOF, returning the line without
+// its line ending. Input is intentionally read one byte at a time, so that no
+// input beyond the newline is consumed. An io.EOF error is only returned if no
+// input was available at all.
+func readLine(r io.Reader) (string, error) {
+	var line []byte
+	buf := make([]byte, 1)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if buf[0] == '\n' {
+				break
+			}
+			line = append(line, buf[0])
+		}
+		if err == io.EOF && len(line) > 0 {
+			break
+		} else if err != nil {
+			return "", err
+		}
+	}
+	return strings.TrimSuffix(string(line), "\r"), nil
+}
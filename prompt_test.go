@@ -0,0 +1,137 @@
+package mybase
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestConfirm(t *testing.T) {
+	cmd := simpleCommand()
+	cmd.AddOption(BoolOption("force", 0, false, "dummy description"))
+
+	assertConfirm := func(commandLine, input string, defaultYes, expected bool, expectPrompts int) {
+		t.Helper()
+		cfg := ParseFakeCLI(t, cmd, commandLine)
+		cfg.SetConfirmOption("force")
+		var output bytes.Buffer
+		cfg.PromptInput = strings.NewReader(input)
+		cfg.PromptOutput = &output
+		actual, err := cfg.Confirm("Are you sure?", defaultYes)
+		if err != nil {
+			t.Errorf("Unexpected error from Confirm: %v", err)
+		} else if actual != expected {
+			t.Errorf("Expected Confirm with input %q to return %t, instead found %t", input, expected, actual)
+		}
+		if prompts := strings.Count(output.String(), "Are you sure?"); prompts != expectPrompts {
+			t.Errorf("Expected Confirm with input %q to prompt %d times, instead found %d", input, expectPrompts, prompts)
+		}
+	}
+	assertConfirm("mycommand arg1", "y\n", false, true, 1)
+	assertConfirm("mycommand arg1", "YES\n", false, true, 1)
+	assertConfirm("mycommand arg1", "n\n", true, false, 1)
+	assertConfirm("mycommand arg1", "\n", true, true, 1)
+	assertConfirm("mycommand arg1", "\r\n", false, false, 1)
+	assertConfirm("mycommand arg1", "", true, true, 1)
+	assertConfirm("mycommand arg1", "", false, false, 1)
+	assertConfirm("mycommand arg1", "maybe\nwhat\ny", false, true, 3)
+	assertConfirm("mycommand arg1", "maybe\n", true, true, 2)
+	assertConfirm("mycommand --force arg1", "n\n", false, true, 0)
+
+	// Confirm panics if confirm option does not exist
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected SetConfirmOption to panic on nonexistent option, but it did not")
+		}
+	}()
+	cfg := ParseFakeCLI(t, cmd, "mycommand arg1")
+	cfg.SetConfirmOption("doesnt-exist")
+}
+
+func TestPromptValue(t *testing.T) {
+	assertPromptValue := func(input, defaultValue, expected, expectOutput string) {
+		t.Helper()
+		cfg := ParseFakeCLI(t, simpleCommand(), "mycommand arg1")
+		var output bytes.Buffer
+		cfg.PromptInput = strings.NewReader(input)
+		cfg.PromptOutput = &output
+		actual, err := cfg.PromptValue("Schema name", defaultValue)
+		if err != nil {
+			t.Errorf("Unexpected error from PromptValue: %v", err)
+		} else if actual != expected {
+			t.Errorf("Expected PromptValue with input %q to return %q, instead found %q", input, expected, actual)
+		}
+		if output.String() != expectOutput {
+			t.Errorf("Expected PromptValue to output %q, instead found %q", expectOutput, output.String())
+		}
+	}
+	assertPromptValue("foo\nbar\n", "", "foo", "Schema name: ")
+	assertPromptValue("  foo  ", "default", "foo", "Schema name [default]: ")
+	assertPromptValue("\n", "default", "default", "Schema name [default]: ")
+	assertPromptValue("", "default", "default", "Schema name [default]: ")
+}
+
+func TestPromptMissing(t *testing.T) {
+	cmd := simpleCommand()
+	cmd.AddOption(StringOption("password", 'p', "", "dummy description").ValueOptional().PromptIfMissing("Password").Secret())
+	cmd.AddOption(DurationOption("timeout", 0, 0, "dummy description").PromptIfMissing(""))
+
+	var output bytes.Buffer
+	cfg := ParseFakeCLI(t, cmd, "mycommand --password arg1")
+	cfg.PromptInput = strings.NewReader(" it's secret \nforever\n5m\n")
+	cfg.PromptOutput = &output
+	if err := cfg.PromptMissing(); err != nil {
+		t.Fatalf("Unexpected error from PromptMissing: %v", err)
+	}
+	if actual := cfg.Get("password"); actual != " it's secret " {
+		t.Errorf("Unexpected value for password: %q", actual)
+	}
+	if d, err := cfg.GetDuration("timeout"); err != nil || d.Minutes() != 5 {
+		t.Errorf("Unexpected value for timeout: %q", cfg.Get("timeout"))
+	}
+	if source := fmt.Sprint(cfg.Source("timeout")); source != "interactive prompt" {
+		t.Errorf("Unexpected source for prompted value: %s", source)
+	}
+	expectOutput := "Password: Enter value for timeout: Invalid value for timeout: "
+	if !strings.HasPrefix(output.String(), expectOutput) || strings.Count(output.String(), "Enter value for timeout") != 2 {
+		t.Errorf("Unexpected prompt output: %q", output.String())
+	}
+
+	// Already-supplied values and clones should not cause prompts
+	output.Reset()
+	if err := cfg.Clone().PromptMissing(); err != nil || output.Len() > 0 {
+		t.Errorf("Unexpected result from PromptMissing on clone: err=%v output=%q", err, output.String())
+	}
+
+	// EOF or non-interactive input results in an error, which prevents the
+	// handler from running
+	cfg = ParseFakeCLI(t, cmd, "mycommand --password=foo arg1")
+	cfg.PromptInput = strings.NewReader("")
+	cfg.PromptOutput = &output
+	if err := cfg.HandleCommand(); err == nil {
+		t.Error("Expected HandleCommand to return error, but err is nil")
+	} else if omv, ok := err.(OptionMissingValueError); !ok || omv.Name != "timeout" {
+		t.Errorf("Unexpected error from HandleCommand: %v", err)
+	}
+
+	// Answers may be required to satisfy a validator, and to be confirmed
+	cmd.AddOption(StringOption("new-password", 0, "", "dummy description").PromptIfMissing("New password").Secret().ConfirmPrompt("").SetPromptValidator(PasswordStrength(6, "0123456789")))
+	output.Reset()
+	cfg = ParseFakeCLI(t, cmd, "mycommand --password=foo --timeout=1s arg1")
+	cfg.PromptInput = strings.NewReader("abc1\nlonger\nlonger1\nlonger2\nlonger3\nlonger3\n")
+	cfg.PromptOutput = &output
+	if err := cfg.PromptMissing(); err != nil {
+		t.Fatalf("Unexpected error from PromptMissing: %v", err)
+	}
+	if actual := cfg.Get("new-password"); actual != "longer3" {
+		t.Errorf("Unexpected value for new-password: %q", actual)
+	}
+	expectOutput = "New password: Invalid value for new-password: must be at least 6 characters long\n" +
+		"New password: Invalid value for new-password: must contain at least one of these characters: 0123456789\n" +
+		"New password: Re-enter value for new-password: Values for new-password do not match; please try again\n" +
+		"New password: Re-enter value for new-password: "
+	if output.String() != expectOutput {
+		t.Errorf("Unexpected prompt output: %q", output.String())
+	}
+}
@@ -0,0 +1,290 @@
+package mybase
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RemoteSource is an option source which fetches an option file over HTTP or
+// HTTPS. Its contents use the same formats as any other option file: ini-style
+// by default, or JSON, YAML, or TOML based on the Syntax field or the extension
+// of the URL's path. The most recently fetched contents may be cached on local
+// disk, both to avoid re-downloading unchanged contents (via ETag revalidation)
+// and to permit use of the source when the remote server cannot be reached.
+// TLS client certificate authentication may be configured via
+// SetClientCertificate.
+//
+// Since the remote contents come from another host, !include and !includedir
+// directives in them are not permitted, and the size of the response body is
+// limited by the MaxSize field.
+//
+// Typically a RemoteSource should be added to a Config prior to any local
+// option files, so that local files take precedence over it.
+type RemoteSource struct {
+	URL                  string
+	Timeout              time.Duration // maximum duration of the entire request, including reading the response body; 0 means DefaultRemoteTimeout
+	TokenOption          string        // name of an option whose value, if non-empty, is sent as a bearer token
+	CachePath            string        // path of local cache file, or empty string to disable caching
+	IgnoreUnknownOptions bool          // if true, unknown options in the remote contents are ignored
+	Client               *http.Client  // HTTP client to use; a default client is used if nil
+	Syntax               FileFormat    // format of the remote contents; FileFormatAuto selects based on the extension of the URL's path
+	MaxSize              int64         // maximum size of the response body in bytes; 0 means DefaultRemoteMaxSize
+	mu                   sync.RWMutex  // protects file
+	file                 *File
+}
+
+// DefaultRemoteMaxSize is the maximum size of a RemoteSource's response body,
+// in bytes, if its MaxSize field is 0.
+const DefaultRemoteMaxSize = 1 << 20
+
+// DefaultRemoteTimeout is the maximum duration of a RemoteSource's request, if
+// its Timeout field is 0.
+const DefaultRemoteTimeout = 30 * time.Second
+
+// NewRemoteSource returns a RemoteSource for the supplied URL. The source
+// must be loaded via Load prior to use.
+func NewRemoteSource(url string, timeout time.Duration, tokenOption, cachePath string) *RemoteSource {
+	return &RemoteSource{
+		URL:         url,
+		Timeout:     timeout,
+		TokenOption: tokenOption,
+		CachePath:   cachePath,
+	}
+}
+
+// Load fetches the remote contents and parses them, using cfg to determine
+// which options are valid and to obtain the value of rs.TokenOption. If a
+// cached copy exists along with its ETag, the request asks the server to skip
+// re-sending unchanged contents.
+//
+// If the request fails and a cached copy exists, the cached copy is used
+// instead, and a warning is reported via cfg.Warn. An error is returned if the
+// request fails and no cached copy exists, or if the contents cannot be parsed.
+// Fetched contents are only written to the cache once they have been parsed
+// successfully. If the source was already added to a Config, the Config
+// automatically reflects the new contents after a successful Load.
+func (rs *RemoteSource) Load(cfg *Config) error {
+	cached, cachedETag := rs.readCache()
+	contents, etag, err := rs.fetch(cfg, cached, cachedETag)
+	if err != nil {
+		if cached == nil {
+			return err
+		}
+		cfg.Warn("Unable to fetch options from %s, using cached copy from %s instead: %s", rs.URL, rs.CachePath, err)
+		contents, etag = cached, cachedETag
+	}
+
+	f := NewFile(rs.filePath())
+	f.IgnoreUnknownOptions = rs.IgnoreUnknownOptions
+	f.Syntax = rs.syntax()
+	f.MaxIncludeDepth = -1
+	if err := f.ParseReader(cfg, strings.NewReader(string(contents))); err != nil {
+		return err
+	}
+	if etag != cachedETag || string(contents) != string(cached) {
+		if err := rs.writeCache(contents, etag); err != nil {
+			cfg.Warn("Unable to write cache of options from %s to %s: %s", rs.URL, rs.CachePath, err)
+		}
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.file = f
+	return nil
+}
+
+// UseSection changes which section(s) of the remote contents are used when
+// obtaining option values. See File.UseSection.
+func (rs *RemoteSource) UseSection(names ...string) error {
+	f := rs.loadedFile()
+	if f == nil {
+		panic(fmt.Errorf("Call to UseSection on RemoteSource %s which has not been loaded", rs.URL))
+	}
+	return f.UseSection(names...)
+}
+
+// OptionValue satisfies the OptionValuer interface. Panics if the source has
+// not been loaded yet, since this is indicative of programmer error.
+func (rs *RemoteSource) OptionValue(optionName string) (string, bool) {
+	f := rs.loadedFile()
+	if f == nil {
+		panic(fmt.Errorf("Call to OptionValue(\"%s\") on RemoteSource %s which has not been loaded", optionName, rs.URL))
+	}
+	return f.OptionValue(optionName)
+}
+
+// editGeneration satisfies the editTracker interface. Each successful Load
+// results in a different generation, since every File's generation is unique.
+func (rs *RemoteSource) editGeneration() uint64 {
+	if f := rs.loadedFile(); f != nil {
+		return f.editGeneration()
+	}
+	return 0
+}
+
+// loadedFile returns the File holding the most recently loaded remote
+// contents, or nil if the source has not been loaded yet.
+func (rs *RemoteSource) loadedFile() *File {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	return rs.file
+}
+
+func (rs *RemoteSource) String() string {
+	return rs.URL
+}
+
+// SetClientCertificate configures rs to use TLS client certificate
+// authentication, using the PEM-encoded certificate and private key in the
+// supplied files. If caFile is non-empty, the server's certificate is verified
+// using the PEM-encoded CA certificates in that file, instead of the system's
+// root CAs. Either certFile and keyFile, or caFile, may be empty strings to
+// only configure the other. If rs.Client was already set, its other settings
+// are retained, but its Transport is replaced.
+func (rs *RemoteSource) SetClientCertificate(certFile, keyFile, caFile string) error {
+	tlsConfig := &tls.Config{}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		caPEM, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caPEM) {
+			return fmt.Errorf("No valid PEM-encoded certificates found in %s", caFile)
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	client := &http.Client{}
+	if rs.Client != nil {
+		*client = *rs.Client
+	}
+	client.Transport = transport
+	rs.Client = client
+	return nil
+}
+
+// syntax returns the format of the remote contents, resolving FileFormatAuto
+// based on the extension of the URL's path. Any query string in the URL is
+// ignored.
+func (rs *RemoteSource) syntax() FileFormat {
+	if rs.Syntax != FileFormatAuto {
+		return rs.Syntax
+	}
+	u, err := url.Parse(rs.URL)
+	if err != nil {
+		return FileFormatINI
+	}
+	return formatForPath(u.Path)
+}
+
+// fetch requests the remote contents. If the server indicates the cached
+// contents are still current, they are returned instead.
+func (rs *RemoteSource) fetch(cfg *Config, cached []byte, cachedETag string) (contents []byte, etag string, err error) {
+	timeout := rs.Timeout
+	if timeout <= 0 {
+		timeout = DefaultRemoteTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodGet, rs.URL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req = req.WithContext(ctx)
+	if rs.TokenOption != "" {
+		if token := cfg.Get(rs.TokenOption); token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+	if cached != nil && cachedETag != "" {
+		req.Header.Set("If-None-Match", cachedETag)
+	}
+
+	client := rs.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		return cached, cachedETag, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, "", fmt.Errorf("Unexpected HTTP response status %s from %s", resp.Status, rs.URL)
+	}
+	maxSize := rs.MaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultRemoteMaxSize
+	}
+	if contents, err = ioutil.ReadAll(io.LimitReader(resp.Body, maxSize+1)); err != nil {
+		return nil, "", err
+	} else if int64(len(contents)) > maxSize {
+		return nil, "", fmt.Errorf("Response from %s exceeds maximum size of %d bytes", rs.URL, maxSize)
+	}
+	return contents, resp.Header.Get("ETag"), nil
+}
+
+// readCache returns the contents of the cache file and its corresponding ETag.
+// If there is no cache, the returned contents are nil.
+func (rs *RemoteSource) readCache() (contents []byte, etag string) {
+	if rs.CachePath == "" {
+		return nil, ""
+	}
+	contents, err := ioutil.ReadFile(rs.CachePath)
+	if err != nil {
+		return nil, ""
+	}
+	etagBytes, _ := ioutil.ReadFile(rs.CachePath + ".etag")
+	return contents, strings.TrimSpace(string(etagBytes))
+}
+
+// writeCache replaces the cache file and its corresponding ETag, each of which
+// is written atomically. The old ETag is removed before the contents are
+// written, so an interruption can leave the contents without an ETag, but can
+// never associate an ETag with the wrong contents. Contents without an ETag
+// are still usable as a fallback, and are simply re-sent in full by the server
+// on the next Load.
+func (rs *RemoteSource) writeCache(contents []byte, etag string) error {
+	if rs.CachePath == "" {
+		return nil
+	}
+	if err := os.Remove(rs.CachePath + ".etag"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := writeFileAtomic(rs.CachePath, contents, 0600); err != nil {
+		return err
+	}
+	if etag == "" {
+		return nil
+	}
+	return writeFileAtomic(rs.CachePath+".etag", []byte(etag), 0600)
+}
+
+// filePath returns the path used to identify the remote contents in errors.
+func (rs *RemoteSource) filePath() string {
+	if rs.CachePath != "" {
+		return rs.CachePath
+	}
+	return path.Base(rs.URL)
+}
@@ -0,0 +1,304 @@
+package mybase
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRemoteSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mybasetest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	cachePath := filepath.Join(dir, "remote.cnf")
+
+	var fullResponses, notModifiedResponses int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer s3cr3t" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&notModifiedResponses, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		atomic.AddInt32(&fullResponses, 1)
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, "visible=remote\nhasshort=remote\n")
+	}))
+
+	cmd := simpleCommand()
+	cmd.AddOption(StringOption("token", 0, "", "dummy"))
+	var warnings []string
+	newConfig := func() *Config {
+		cfg := ParseFakeCLI(t, cmd, "mycommand --token=s3cr3t arg1")
+		cfg.WarningHandler = func(message string) {
+			warnings = append(warnings, message)
+		}
+		return cfg
+	}
+
+	// Initial fetch: 200 response, with contents written to cache
+	cfg := newConfig()
+	rs := NewRemoteSource(server.URL, 5*time.Second, "token", cachePath)
+	if err := rs.Load(cfg); err != nil {
+		t.Fatalf("Unexpected error from Load: %v", err)
+	}
+	file := NewFile(dir, "local.cnf")
+	file.contents, file.read = "hasshort=local\n", true
+	if err := file.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error from Parse: %v", err)
+	}
+	cfg.AddSource(rs)
+	cfg.AddSource(file)
+	if cfg.Get("visible") != "remote" || cfg.Get("hasshort") != "local" {
+		t.Errorf("Unexpected option values: visible=%q hasshort=%q", cfg.Get("visible"), cfg.Get("hasshort"))
+	}
+	if contents, err := ioutil.ReadFile(cachePath); err != nil || !strings.Contains(string(contents), "visible=remote") {
+		t.Errorf("Expected cache file to be written, instead found %q / %v", contents, err)
+	}
+
+	// Subsequent fetch: 304 response, using cached contents
+	cfg = newConfig()
+	rs = NewRemoteSource(server.URL, 5*time.Second, "token", cachePath)
+	if err := rs.Load(cfg); err != nil {
+		t.Fatalf("Unexpected error from Load: %v", err)
+	}
+	cfg.AddSource(rs)
+	if cfg.Get("visible") != "remote" {
+		t.Errorf("Unexpected option value: visible=%q", cfg.Get("visible"))
+	}
+	if fullResponses != 1 || notModifiedResponses != 1 {
+		t.Errorf("Expected 1 full response and 1 not-modified response, instead found %d and %d", fullResponses, notModifiedResponses)
+	}
+	if len(warnings) > 0 {
+		t.Errorf("Unexpected warnings: %v", warnings)
+	}
+
+	// Server unavailable: cached contents used, with a warning
+	server.Close()
+	cfg = newConfig()
+	rs = NewRemoteSource(server.URL, 5*time.Second, "token", cachePath)
+	if err := rs.Load(cfg); err != nil {
+		t.Fatalf("Unexpected error from Load: %v", err)
+	}
+	cfg.AddSource(rs)
+	if cfg.Get("visible") != "remote" {
+		t.Errorf("Unexpected option value: visible=%q", cfg.Get("visible"))
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "using cached copy") {
+		t.Errorf("Expected 1 warning about using cache, instead found %v", warnings)
+	}
+
+	// Server unavailable without a cache: error
+	rs = NewRemoteSource(server.URL, 5*time.Second, "token", filepath.Join(dir, "nonexistent.cnf"))
+	if err := rs.Load(cfg); err == nil {
+		t.Error("Expected error from Load without cache, but err is nil")
+	}
+}
+
+func TestRemoteSourceTimeout(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+
+	cfg := ParseFakeCLI(t, simpleCommand(), "mycommand arg1")
+	rs := NewRemoteSource(server.URL, 100*time.Millisecond, "", "")
+	start := time.Now()
+	if err := rs.Load(cfg); err == nil {
+		t.Error("Expected timeout error from Load, but err is nil")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected Load to respect timeout, but it took %s", elapsed)
+	}
+}
+
+func TestRemoteSourceClientCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mybasetest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Generate a CA, plus a server cert and client cert signed by it
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "mybase test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Unable to create CA cert: %v", err)
+	}
+	caCert, _ := x509.ParseCertificate(caDER)
+	issue := func(serial int64, usage x509.ExtKeyUsage) tls.Certificate {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "mybase test"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatalf("Unable to create cert: %v", err)
+		}
+		return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	}
+	writePEM := func(name, blockType string, der []byte) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+			t.Fatalf("Unable to write %s: %v", name, err)
+		}
+		return path
+	}
+	serverCert := issue(2, x509.ExtKeyUsageServerAuth)
+	clientCert := issue(3, x509.ExtKeyUsageClientAuth)
+	clientKeyDER, _ := x509.MarshalECPrivateKey(clientCert.PrivateKey.(*ecdsa.PrivateKey))
+	caFile := writePEM("ca.pem", "CERTIFICATE", caDER)
+	certFile := writePEM("client.pem", "CERTIFICATE", clientCert.Certificate[0])
+	keyFile := writePEM("client-key.pem", "EC PRIVATE KEY", clientKeyDER)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"visible": "remote json", "bool1": true}`)
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+	server.Config.ErrorLog = log.New(ioutil.Discard, "", 0) // handshake failure below is expected
+	server.StartTLS()
+	defer server.Close()
+
+	// Without a client cert, the request fails; with one, it succeeds, and the
+	// JSON format is detected from the URL path despite the query string
+	cfg := ParseFakeCLI(t, simpleCommand(), "mycommand arg1")
+	rs := NewRemoteSource(server.URL+"/config/options.json?env=prod", 5*time.Second, "", "")
+	if err := rs.SetClientCertificate("", "", caFile); err != nil {
+		t.Fatalf("Unexpected error from SetClientCertificate: %v", err)
+	}
+	if err := rs.Load(cfg); err == nil {
+		t.Error("Expected Load without client certificate to fail, but err is nil")
+	}
+	if err := rs.SetClientCertificate(certFile, keyFile, caFile); err != nil {
+		t.Fatalf("Unexpected error from SetClientCertificate: %v", err)
+	}
+	if err := rs.Load(cfg); err != nil {
+		t.Fatalf("Unexpected error from Load: %v", err)
+	}
+	cfg.AddSource(rs)
+	if cfg.Get("visible") != "remote json" || !cfg.GetBool("bool1") {
+		t.Errorf("Unexpected option values: visible=%q bool1=%t", cfg.Get("visible"), cfg.GetBool("bool1"))
+	}
+
+	// Invalid files result in errors
+	if err := rs.SetClientCertificate(certFile, caFile, ""); err == nil {
+		t.Error("Expected error from SetClientCertificate with mismatched key, but err is nil")
+	}
+	if err := rs.SetClientCertificate("", "", keyFile); err == nil {
+		t.Error("Expected error from SetClientCertificate with invalid CA file, but err is nil")
+	}
+}
+
+func TestRemoteSourceRestrictions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mybasetest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "local.cnf"), []byte("visible=local\n"), 0600); err != nil {
+		t.Fatalf("Unable to write file: %v", err)
+	}
+
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, body)
+	}))
+	defer server.Close()
+	cfg := ParseFakeCLI(t, simpleCommand(), "mycommand arg1")
+
+	// Includes are not permitted in remote contents
+	body = "!include " + filepath.Join(dir, "local.cnf") + "\nhasshort=remote\n"
+	rs := NewRemoteSource(server.URL, 5*time.Second, "", "")
+	if err := rs.Load(cfg); err == nil {
+		t.Error("Expected error from Load with !include, but err is nil")
+	}
+
+	// Response bodies over the size limit are rejected
+	body = "visible=" + strings.Repeat("x", 100) + "\n"
+	rs = NewRemoteSource(server.URL, 5*time.Second, "", "")
+	rs.MaxSize = 50
+	if err := rs.Load(cfg); err == nil || !strings.Contains(err.Error(), "maximum size") {
+		t.Errorf("Expected error from Load due to size limit, instead found %v", err)
+	}
+	rs.MaxSize = 0
+	if err := rs.Load(cfg); err != nil {
+		t.Errorf("Unexpected error from Load: %v", err)
+	}
+
+	// Contents which cannot be parsed are not written to the cache
+	cachePath := filepath.Join(dir, "remote.cnf")
+	if err := ioutil.WriteFile(cachePath, []byte("visible=cached\n"), 0600); err != nil {
+		t.Fatalf("Unable to write file: %v", err)
+	}
+	if err := ioutil.WriteFile(cachePath+".etag", []byte(`"v1"`), 0600); err != nil {
+		t.Fatalf("Unable to write file: %v", err)
+	}
+	body = "visible=remote\nbadoption=remote\n"
+	rs = NewRemoteSource(server.URL, 5*time.Second, "", cachePath)
+	if err := rs.Load(cfg); err == nil {
+		t.Error("Expected error from Load with unknown option, but err is nil")
+	}
+	if contents, err := ioutil.ReadFile(cachePath); err != nil || string(contents) != "visible=cached\n" {
+		t.Errorf("Expected cache file to be unchanged, instead found %q / %v", contents, err)
+	}
+
+	// Replacing the cache with contents lacking an ETag removes the old ETag
+	body = "visible=remote\n"
+	if err := rs.Load(cfg); err != nil {
+		t.Errorf("Unexpected error from Load: %v", err)
+	}
+	if contents, err := ioutil.ReadFile(cachePath); err != nil || string(contents) != body {
+		t.Errorf("Expected cache file to be replaced, instead found %q / %v", contents, err)
+	}
+	if _, err := os.Stat(cachePath + ".etag"); !os.IsNotExist(err) {
+		t.Errorf("Expected old ETag file to be removed, instead found err=%v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package mybase
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+)
+
+// AddRetryOptions adds options to cmd which control retrying failed operations
+// and limiting the rate of operations, so that all programs built with this
+// package expose consistent knobs for these behaviors:
+//
+//	--max-retries=N: number of times to retry a failed operation (default 3)
+//	--retry-backoff=duration: delay before the first retry, doubling for each subsequent retry (default 1s)
+//	--rate-limit=N: maximum operations per second, or 0 for no limit (default 0)
+//
+// The options are added to the "global" group, with validation of their
+// ranges. See Config.RetryPolicy to obtain the corresponding RetryPolicy.
+func (cmd *Command) AddRetryOptions() {
+	cmd.AddOptions("global",
+		StringOption("max-retries", 0, "3", "Number of times to retry a failed operation").SetNumericRange(0, math.MaxInt32).SetNumericStep(1),
+		DurationOption("retry-backoff", 0, time.Second, "Delay before the first retry of a failed operation, doubling for each subsequent retry"),
+		FloatOption("rate-limit", 0, 0, "Maximum operations per second, or 0 for no limit").SetNumericRange(0, math.MaxFloat64),
+	)
+}
+
+// RetryPolicy describes how to retry failed operations and limit the rate of
+// operations. See Config.RetryPolicy.
+type RetryPolicy struct {
+	MaxRetries int           // number of retries after the initial attempt
+	Backoff    time.Duration // delay before the first retry
+	MaxBackoff time.Duration // upper bound on the delay before any retry; 0 means no limit
+	RateLimit  float64       // maximum operations per second; 0 means no limit
+}
+
+// RetryPolicy returns a RetryPolicy reflecting the values of the options added
+// by Command.AddRetryOptions. Options which the command does not have are
+// treated as having no value, which means no retries, no backoff, and no rate
+// limit. An OptionValueError is returned if an option has an invalid value.
+// The returned policy's MaxBackoff is 0; callers may set it as needed.
+func (cfg *Config) RetryPolicy() (RetryPolicy, error) {
+	var policy RetryPolicy
+	var err error
+	if value := cfg.optionValue("max-retries"); value != "" {
+		if _, err := strconv.Atoi(value); err != nil {
+			return RetryPolicy{}, cfg.valueError("max-retries", value, errors.New("must be a non-negative integer"))
+		} else if policy.MaxRetries, err = cfg.GetInt("max-retries"); err != nil {
+			return RetryPolicy{}, err
+		}
+	}
+	if cfg.FindOption("retry-backoff") != nil {
+		if policy.Backoff, err = cfg.GetDuration("retry-backoff"); err != nil {
+			return RetryPolicy{}, err
+		}
+	}
+	if cfg.FindOption("rate-limit") != nil {
+		if policy.RateLimit, err = cfg.GetFloat("rate-limit"); err != nil {
+			return RetryPolicy{}, err
+		}
+	}
+	return policy, nil
+}
+
+// Delay returns the delay before the supplied retry, numbered from 1. The
+// delay is Backoff for the first retry, doubling for each subsequent retry, up
+// to MaxBackoff if it is positive. Returns 0 if retry is less than 1.
+func (p RetryPolicy) Delay(retry int) time.Duration {
+	if retry < 1 || p.Backoff <= 0 {
+		return 0
+	}
+	delay := p.Backoff
+	for n := 1; n < retry; n++ {
+		if delay > math.MaxInt64/2 {
+			delay = math.MaxInt64
+			break
+		}
+		delay *= 2
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	return delay
+}
+
+// Interval returns the minimum time between the starts of consecutive
+// operations to comply with RateLimit, or 0 if there is no rate limit.
+func (p RetryPolicy) Interval() time.Duration {
+	if p.RateLimit <= 0 {
+		return 0
+	}
+	return time.Duration(float64(time.Second) / p.RateLimit)
+}
+
+// Do calls fn until it returns nil, up to 1+MaxRetries times in total, waiting
+// for Delay between attempts. The error from the final attempt is returned. If
+// ctx is done while waiting, ctx.Err() is returned instead. RateLimit is not
+// applied, since it relates to separate operations rather than retries of one
+// operation; see Interval.
+func (p RetryPolicy) Do(ctx context.Context, fn func() error) error {
+	err := fn()
+	for retry := 1; err != nil && retry <= p.MaxRetries; retry++ {
+		timer := time.NewTimer(p.Delay(retry))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		err = fn()
+	}
+	return err
+}
@@ -0,0 +1,76 @@
+package mybase
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	cmd := simpleCommand()
+	cmd.AddRetryOptions()
+	cfg := ParseFakeCLI(t, cmd, "mycommand arg1")
+	policy, err := cfg.RetryPolicy()
+	if err != nil {
+		t.Fatalf("Unexpected error from RetryPolicy: %v", err)
+	}
+	if expected := (RetryPolicy{MaxRetries: 3, Backoff: time.Second}); policy != expected {
+		t.Errorf("Expected default policy %+v, instead found %+v", expected, policy)
+	}
+
+	cfg = ParseFakeCLI(t, cmd, "mycommand --max-retries=5 --retry-backoff=100ms --rate-limit=4 arg1")
+	if policy, err = cfg.RetryPolicy(); err != nil {
+		t.Fatalf("Unexpected error from RetryPolicy: %v", err)
+	}
+	if policy.MaxRetries != 5 || policy.Backoff != 100*time.Millisecond || policy.RateLimit != 4 || policy.Interval() != 250*time.Millisecond {
+		t.Errorf("Unexpected policy %+v", policy)
+	}
+	policy.MaxBackoff = 500 * time.Millisecond
+	for retry, expected := range []time.Duration{0, 100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 500 * time.Millisecond} {
+		if actual := policy.Delay(retry); actual != expected {
+			t.Errorf("Expected Delay(%d) to return %s, instead found %s", retry, expected, actual)
+		}
+	}
+	if d := (RetryPolicy{Backoff: time.Hour}).Delay(100); d <= 0 {
+		t.Errorf("Expected Delay to saturate rather than overflow, instead found %s", d)
+	}
+
+	// Invalid values are errors
+	for _, badArg := range []string{"--max-retries=-1", "--max-retries=1.5", "--max-retries=x", "--rate-limit=-2"} {
+		cfg = ParseFakeCLI(t, cmd, "mycommand arg1 "+badArg)
+		if _, err := cfg.RetryPolicy(); err == nil {
+			t.Errorf("Expected error from %s, but err was nil", badArg)
+		}
+	}
+
+	// Commands without the options have no retries or limits
+	if policy, err := ParseFakeCLI(t, simpleCommand(), "mycommand arg1").RetryPolicy(); err != nil || policy != (RetryPolicy{}) {
+		t.Errorf("Unexpected policy %+v or error %v", policy, err)
+	}
+
+	// Do retries until success, or until retries are exhausted
+	policy = RetryPolicy{MaxRetries: 2, Backoff: time.Millisecond}
+	var attempts int
+	failTwice := func() error {
+		if attempts++; attempts <= 2 {
+			return errors.New("fail")
+		}
+		return nil
+	}
+	if err := policy.Do(context.Background(), failTwice); err != nil || attempts != 3 {
+		t.Errorf("Expected success after 3 attempts, instead found err=%v attempts=%d", err, attempts)
+	}
+	attempts = 0
+	policy.MaxRetries = 1
+	if err := policy.Do(context.Background(), failTwice); err == nil || attempts != 2 {
+		t.Errorf("Expected failure after 2 attempts, instead found err=%v attempts=%d", err, attempts)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	attempts = 0
+	policy.Backoff = time.Hour
+	if err := policy.Do(ctx, failTwice); err != context.Canceled || attempts != 1 {
+		t.Errorf("Expected cancellation after 1 attempt, instead found err=%v attempts=%d", err, attempts)
+	}
+}
@@ -0,0 +1,304 @@
+package mybase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// CommandHandlerContext is a function that can be associated with a Command
+// via SetContextHandler, as an alternative to CommandHandler. It receives a
+// context which may be cancelled while the handler is running, for example
+// due to a deadline or a signal; see RunContext.
+type CommandHandlerContext func(context.Context, *Config) error
+
+// SetContextHandler associates a context-aware handler with cmd. This also
+// sets cmd.Handler to an adapter which calls the handler with
+// context.Background(), so that the Command remains usable with
+// Config.HandleCommand. Panics if cmd is a command suite, since this is
+// indicative of programmer error.
+func (cmd *Command) SetContextHandler(handler CommandHandlerContext) {
+	if len(cmd.SubCommands) > 0 {
+		panic(fmt.Errorf("SetContextHandler: Command %s is a CommandSuite, which cannot have a handler", cmd.Name))
+	}
+	cmd.contextHandler = handler
+	cmd.Handler = func(cfg *Config) error {
+		return handler(context.Background(), cfg)
+	}
+}
+
+// PreRunHook is a function which runs prior to the handler of a Command and all
+// of its descendant subcommands; see Command.AddPreRunHook.
+type PreRunHook func(context.Context, *Config) error
+
+// PostRunHook is a function which runs after the handler of a Command and all
+// of its descendant subcommands; see Command.AddPostRunHook. It receives the
+// error returned by the handler, or by a later PostRunHook, and its return
+// value replaces that error.
+type PostRunHook func(ctx context.Context, cfg *Config, err error) error
+
+// AddPreRunHook adds a hook which runs prior to the handler of cmd, as well as
+// prior to the handler of any subcommand of cmd, at any depth. This permits
+// cross-cutting logic, such as logging setup or additional validation, to be
+// shared by many commands. Hooks of ancestor commands run before hooks of
+// descendant commands; hooks of the same command run in the order added. If a
+// hook returns an error, no further hooks run, the handler is not run, and the
+// error is returned by Config.HandleCommand.
+//
+// Hooks do not run for --help, --help-all, --version, --generate-config, or
+// --print-defaults, nor for the help and version subcommands of a command
+// suite, nor for requests for dynamic shell completions.
+func (cmd *Command) AddPreRunHook(hook PreRunHook) {
+	cmd.preRunHooks = append(cmd.preRunHooks, hook)
+}
+
+// AddPostRunHook adds a hook which runs after the handler of cmd, as well as
+// after the handler of any subcommand of cmd, at any depth. Post-run hooks run
+// in the opposite order of pre-run hooks: descendant commands before
+// ancestors, and in reverse order added for the same command. Post-run hooks
+// only run if all pre-run hooks succeeded, but they run regardless of whether
+// the handler returned an error.
+func (cmd *Command) AddPostRunHook(hook PostRunHook) {
+	cmd.postRunHooks = append(cmd.postRunHooks, hook)
+}
+
+// runHandler runs the handler of cmd, along with the hooks of cmd and its
+// ancestors, reporting telemetry to any Collector.
+func (cmd *Command) runHandler(ctx context.Context, cfg *Config) (err error) {
+	var chain []*Command // cmd and its ancestors, from root to cmd
+	if !cmd.isBuiltin() {
+		for current := cmd; current != nil; current = current.ParentCommand {
+			chain = append([]*Command{current}, chain...)
+		}
+		start, before := time.Now(), cfg.counters()
+		defer func() {
+			cmd.collectExecution(cfg, start, before, err)
+		}()
+		if w, key := cmd.activeAuditLog(); w != nil {
+			if err := cfg.WriteAuditRecord(w, key); err != nil {
+				return err
+			}
+		}
+	}
+	for _, current := range chain {
+		for _, hook := range current.preRunHooks {
+			if err := hook(ctx, cfg); err != nil {
+				return err
+			}
+		}
+	}
+
+	if cmd.contextHandler != nil {
+		err = cmd.contextHandler(ctx, cfg)
+	} else {
+		err = cmd.Handler(cfg)
+	}
+
+	for n := len(chain) - 1; n >= 0; n-- {
+		hooks := chain[n].postRunHooks
+		for i := len(hooks) - 1; i >= 0; i-- {
+			err = hooks[i](ctx, cfg, err)
+		}
+	}
+	return applyExitCodes(chain, err)
+}
+
+// isBuiltin returns true if cmd is the help or version subcommand that is
+// automatically added to a command suite, or the hidden command which serves
+// dynamic shell completions.
+func (cmd *Command) isBuiltin() bool {
+	return cmd.ParentCommand != nil && len(cmd.SubCommands) == 0 && (cmd.Name == "help" || cmd.Name == "version" || cmd.Name == completeCommandName)
+}
+
+// SignalError is returned by RunContext when the top-level command's
+// CancelOnSignal field is true, and a signal caused the command to stop. This
+// occurs if the handler returns an error caused by the cancellation of its
+// context, or if RunContext stops waiting for the handler; see RunContext.
+// SignalError satisfies the ExitCoder interface.
+type SignalError struct {
+	Signal os.Signal // signal which was received
+	Err    error     // error returned by the handler, or nil if RunContext did not wait for the handler to return
+	Code   int       // exit code corresponding to Signal
+}
+
+// Error satisfies golang's error interface.
+func (se *SignalError) Error() string {
+	if se.Err != nil {
+		return se.Err.Error()
+	}
+	return fmt.Sprintf("Exiting without waiting for command to finish, after receiving signal: %s", se.Signal)
+}
+
+// Unwrap returns the error returned by the handler, if any.
+func (se *SignalError) Unwrap() error {
+	return se.Err
+}
+
+// ExitCode satisfies the ExitCoder interface.
+func (se *SignalError) ExitCode() int {
+	return se.Code
+}
+
+// SetSignalExitCode configures the exit code of a SignalError caused by sig,
+// overriding the default of 128 plus the signal number, which is the shell
+// convention: 130 for SIGINT, or 143 for SIGTERM. This only has an effect on
+// the top-level command, when its CancelOnSignal field is true.
+func (cmd *Command) SetSignalExitCode(sig os.Signal, code int) {
+	if cmd.signalCodes == nil {
+		cmd.signalCodes = make(map[os.Signal]int)
+	}
+	cmd.signalCodes[sig] = code
+}
+
+// signalExitCode returns the exit code to use for a SignalError caused by sig.
+func (cmd *Command) signalExitCode(sig os.Signal) int {
+	if code, ok := cmd.signalCodes[sig]; ok {
+		return code
+	} else if num, ok := sig.(syscall.Signal); ok {
+		return 128 + int(num)
+	}
+	return ExitCodeCanceled
+}
+
+// RunContext parses the supplied args, which should match the format of
+// os.Args, and then executes the handler of the selected command, supplying
+// ctx if the handler was set via SetContextHandler. Callers may use ExitCode
+// to convert the returned error into a process exit code.
+//
+// If the top-level command's CancelOnSignal field is true, the context is also
+// cancelled upon receipt of SIGINT or SIGTERM, permitting the handler to shut
+// down gracefully. If the handler then returns an error caused by the
+// cancellation, RunContext returns a *SignalError wrapping it. If the
+// top-level command's ShutdownGrace field is positive, RunContext waits at
+// most that long after the signal for the handler to return; a second signal
+// also stops the wait. In either case, RunContext returns a *SignalError
+// without waiting for the handler, and the caller should exit the process
+// promptly, since the handler continues to run in the background.
+func RunContext(ctx context.Context, cmd *Command, args []string) error {
+	root := cmd.Root()
+	if !root.CancelOnSignal {
+		cfg, err := ParseCLI(cmd, args)
+		if err != nil {
+			return err
+		}
+		return cfg.HandleCommandContext(ctx)
+	}
+
+	sc := newSignalContext(ctx)
+	defer sc.stop()
+	cfg, err := ParseCLI(cmd, args)
+	if err != nil {
+		return err
+	}
+	type result struct {
+		err       error
+		recovered interface{}
+	}
+	done := make(chan result, 1)
+	go func() {
+		var panicked bool
+		defer func() {
+			// Re-panic in the caller's goroutine, rather than crashing here
+			if panicked {
+				done <- result{recovered: recover()}
+			}
+		}()
+		panicked = true
+		err := cfg.HandleCommandContext(sc)
+		panicked = false
+		done <- result{err: err}
+	}()
+
+	received := sc.received
+	var graceExpired <-chan time.Time
+	for {
+		select {
+		case res := <-done:
+			if res.recovered != nil {
+				panic(res.recovered)
+			}
+			if sig := sc.receivedSignal(); sig != nil && errors.Is(res.err, context.Canceled) {
+				return &SignalError{Signal: sig, Err: res.err, Code: root.signalExitCode(sig)}
+			}
+			return res.err
+		case <-received:
+			received = nil // only trigger once
+			if root.ShutdownGrace > 0 {
+				graceExpired = time.After(root.ShutdownGrace)
+			}
+		case <-graceExpired:
+			return &SignalError{Signal: sc.signal, Code: root.signalExitCode(sc.signal)}
+		case <-sc.forced:
+			return &SignalError{Signal: sc.signal, Code: root.signalExitCode(sc.signal)}
+		}
+	}
+}
+
+// Run is a convenience function for an application's main function. It calls
+// RunContext using os.Args, cancelling the context upon SIGINT or SIGTERM if
+// the top-level command's CancelOnSignal field is true. Any resulting error is
+// displayed via HandleCommandError, and the process then exits with the
+// corresponding exit code; see ExitCode. Run does not return.
+func Run(cmd *Command) {
+	err := RunContext(context.Background(), cmd, os.Args)
+	os.Exit(HandleCommandError(cmd, err))
+}
+
+// signalContext is a context which is cancelled upon receipt of SIGINT or
+// SIGTERM. Its stop method must be called to release resources and stop
+// handling signals.
+type signalContext struct {
+	context.Context
+	cancel   context.CancelFunc
+	signals  chan os.Signal
+	signal   os.Signal     // first signal received; only read after received is closed
+	received chan struct{} // closed upon the first signal
+	forced   chan struct{} // closed upon the second signal
+}
+
+// newSignalContext returns a signalContext which is a child of parent.
+func newSignalContext(parent context.Context) *signalContext {
+	ctx, cancel := context.WithCancel(parent)
+	sc := &signalContext{
+		Context:  ctx,
+		cancel:   cancel,
+		signals:  make(chan os.Signal, 2),
+		received: make(chan struct{}),
+		forced:   make(chan struct{}),
+	}
+	signal.Notify(sc.signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sc.signal = <-sc.signals:
+			close(sc.received)
+			cancel()
+		case <-ctx.Done():
+			return
+		}
+		if _, ok := <-sc.signals; ok {
+			close(sc.forced)
+		}
+	}()
+	return sc
+}
+
+// receivedSignal returns the first signal received, or nil if none has been
+// received yet.
+func (sc *signalContext) receivedSignal() os.Signal {
+	select {
+	case <-sc.received:
+		return sc.signal
+	default:
+		return nil
+	}
+}
+
+func (sc *signalContext) stop() {
+	signal.Stop(sc.signals)
+	sc.cancel()
+	close(sc.signals)
+}
@@ -0,0 +1,248 @@
+package mybase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// blockingCommand returns a command whose handler blocks until its context is
+// done. The returned channel is closed once the handler has started.
+func blockingCommand() (*Command, chan struct{}) {
+	started := make(chan struct{})
+	cmd := NewCommand("mycommand", "1.0", "description", nil)
+	cmd.SetContextHandler(func(ctx context.Context, cfg *Config) error {
+		close(started)
+		<-ctx.Done()
+		return fmt.Errorf("handler interrupted: %w", ctx.Err())
+	})
+	return cmd, started
+}
+
+func TestRunContextCancel(t *testing.T) {
+	cmd, started := blockingCommand()
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	done := make(chan error)
+	go func() {
+		done <- RunContext(ctx, cmd, []string{"mycommand"})
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected error to wrap context.Canceled, instead found %v", err)
+		}
+		if code := ExitCode(err); code != ExitCodeCanceled {
+			t.Errorf("Expected exit code %d, instead found %d", ExitCodeCanceled, code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for cancellation to propagate to handler")
+	}
+
+	// The old handler signature is still usable with a context-aware handler
+	// set, and vice versa
+	cmd = NewCommand("mycommand", "1.0", "description", nil)
+	var ran bool
+	cmd.SetContextHandler(func(ctx context.Context, cfg *Config) error {
+		ran = (ctx != nil)
+		return nil
+	})
+	cfg := ParseFakeCLI(t, cmd, "mycommand")
+	if err := cfg.HandleCommand(); err != nil || !ran {
+		t.Errorf("Unexpected result from HandleCommand: ran=%t err=%v", ran, err)
+	}
+	cmd = NewCommand("mycommand", "1.0", "description", func(cfg *Config) error {
+		return errors.New("oops")
+	})
+	if err := RunContext(context.Background(), cmd, []string{"mycommand"}); ExitCode(err) != 1 {
+		t.Errorf("Unexpected error from RunContext: %v", err)
+	}
+	if code := ExitCode(nil); code != 0 {
+		t.Errorf("Expected exit code 0 for nil error, instead found %d", code)
+	}
+}
+
+func TestRunContextSignal(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping test since sending signals is not supported on Windows")
+	}
+	cmd, started := blockingCommand()
+	cmd.CancelOnSignal = true
+	go func() {
+		<-started
+		proc, _ := os.FindProcess(os.Getpid())
+		proc.Signal(os.Interrupt)
+	}()
+	done := make(chan error)
+	go func() {
+		done <- RunContext(context.Background(), cmd, []string{"mycommand"})
+	}()
+	select {
+	case err := <-done:
+		if code := ExitCode(err); code != ExitCodeCanceled {
+			t.Errorf("Expected exit code %d, instead found %d from error %v", ExitCodeCanceled, code, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for signal to cancel handler's context")
+	}
+}
+
+func TestRunHooks(t *testing.T) {
+	var calls []string
+	record := func(name string) PreRunHook {
+		return func(ctx context.Context, cfg *Config) error {
+			calls = append(calls, name)
+			if cfg.GetBool("fail") && name == "child-pre" {
+				return errors.New("pre-run hook failed")
+			}
+			return nil
+		}
+	}
+	recordPost := func(name string) PostRunHook {
+		return func(ctx context.Context, cfg *Config, err error) error {
+			calls = append(calls, fmt.Sprintf("%s(%v)", name, err))
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			return nil
+		}
+	}
+	errHandler := errors.New("handler failed")
+	suite := NewCommandSuite("mycommand", "1.0", "description")
+	suite.AddOption(BoolOption("fail", 0, false, "dummy description"))
+	suite.AddPreRunHook(record("root-pre1"))
+	suite.AddPreRunHook(record("root-pre2"))
+	suite.AddPostRunHook(recordPost("root-post1"))
+	suite.AddPostRunHook(recordPost("root-post2"))
+	child := NewCommand("child", "summary", "description", func(cfg *Config) error {
+		calls = append(calls, "handler")
+		return errHandler
+	})
+	child.AddPreRunHook(record("child-pre"))
+	child.AddPostRunHook(recordPost("child-post"))
+	suite.AddSubCommand(child)
+
+	assertCalls := func(expected ...string) {
+		t.Helper()
+		if fmt.Sprint(calls) != fmt.Sprint(expected) {
+			t.Errorf("Unexpected calls:\n  expected %v\n  found    %v", expected, calls)
+		}
+		calls = nil
+	}
+
+	err := RunContext(context.Background(), suite, []string{"mycommand", "child"})
+	if !errors.Is(err, errHandler) || err.Error() != "root-post1: root-post2: child-post: handler failed" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	assertCalls("root-pre1", "root-pre2", "child-pre", "handler", "child-post(handler failed)",
+		"root-post2(child-post: handler failed)", "root-post1(root-post2: child-post: handler failed)")
+
+	// Failing pre-run hook prevents the handler and post-run hooks from running
+	if err := RunContext(context.Background(), suite, []string{"mycommand", "child", "--fail"}); err == nil || err.Error() != "pre-run hook failed" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	assertCalls("root-pre1", "root-pre2", "child-pre")
+
+	// Hooks do not run for version subcommand or --help
+	devnull, _ := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	defer devnull.Close()
+	stdout := os.Stdout
+	os.Stdout = devnull
+	defer func() { os.Stdout = stdout }()
+	RunContext(context.Background(), suite, []string{"mycommand", "version"})
+	RunContext(context.Background(), suite, []string{"mycommand", "child", "--help"})
+	assertCalls()
+}
+
+func TestRunContextShutdownGrace(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping test since sending signals is not supported on Windows")
+	}
+	sendSignal := func(sig os.Signal) {
+		proc, _ := os.FindProcess(os.Getpid())
+		proc.Signal(sig)
+	}
+
+	// Handler which ignores cancellation: RunContext stops waiting after the
+	// grace period
+	release := make(chan struct{})
+	defer close(release)
+	ignoringCommand := func() (*Command, chan struct{}) {
+		started := make(chan struct{})
+		cmd := NewCommand("mycommand", "1.0", "description", nil)
+		cmd.SetContextHandler(func(ctx context.Context, cfg *Config) error {
+			close(started)
+			<-release
+			return nil
+		})
+		cmd.CancelOnSignal = true
+		return cmd, started
+	}
+	cmd, started := ignoringCommand()
+	cmd.ShutdownGrace = 50 * time.Millisecond
+	cmd.SetSignalExitCode(syscall.SIGTERM, 99)
+	go func(started chan struct{}) {
+		<-started
+		sendSignal(syscall.SIGTERM)
+	}(started)
+	err := RunContext(context.Background(), cmd, []string{"mycommand"})
+	if se, ok := err.(*SignalError); !ok || se.Signal != syscall.SIGTERM || se.Err != nil || ExitCode(err) != 99 {
+		t.Errorf("Unexpected error from RunContext: %T %v (exit code %d)", err, err, ExitCode(err))
+	}
+
+	// Without a grace period, a second signal stops the wait
+	cmd, started = ignoringCommand()
+	go func(started chan struct{}) {
+		<-started
+		sendSignal(os.Interrupt)
+		time.Sleep(50 * time.Millisecond)
+		sendSignal(os.Interrupt)
+	}(started)
+	done := make(chan error)
+	go func(cmd *Command) {
+		done <- RunContext(context.Background(), cmd, []string{"mycommand"})
+	}(cmd)
+	select {
+	case err := <-done:
+		if code := ExitCode(err); code != ExitCodeCanceled {
+			t.Errorf("Expected exit code %d, instead found %d from error %v", ExitCodeCanceled, code, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for second signal to stop RunContext")
+	}
+
+	// Handler which returns promptly upon cancellation has its error wrapped,
+	// with the default exit code for the signal
+	cmd, started = blockingCommand()
+	cmd.CancelOnSignal = true
+	cmd.ShutdownGrace = time.Minute
+	go func(started chan struct{}) {
+		<-started
+		sendSignal(syscall.SIGTERM)
+	}(started)
+	err = RunContext(context.Background(), cmd, []string{"mycommand"})
+	if ExitCode(err) != 143 || !errors.Is(err, context.Canceled) || !strings.Contains(err.Error(), "handler interrupted") {
+		t.Errorf("Unexpected error from RunContext: %v (exit code %d)", err, ExitCode(err))
+	}
+
+	// Panics in the handler propagate to the caller
+	cmd = NewCommand("mycommand", "1.0", "description", func(cfg *Config) error {
+		panic("boom")
+	})
+	cmd.CancelOnSignal = true
+	defer func() {
+		if recover() != "boom" {
+			t.Error("Expected panic to propagate from RunContext")
+		}
+	}()
+	RunContext(context.Background(), cmd, []string{"mycommand"})
+}
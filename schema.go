@@ -0,0 +1,162 @@
+package mybase
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+)
+
+// jsonSchemaDialect is the JSON Schema version used by WriteJSONSchema, chosen
+// for its broad support among editors and validation tools.
+const jsonSchemaDialect = "http://json-schema.org/draft-07/schema#"
+
+// WriteJSONSchema writes a JSON Schema to w, describing the option files
+// accepted by cmd in JSON or YAML format. This permits editors and CI systems
+// to validate option files without running the program. The schema describes
+// a top-level object whose members are either option values for the default
+// section, or objects representing named sections of option values.
+//
+// Every option available to cmd is described, including hidden options, with
+// its description, default value, and permitted types of value. Restrictions
+// set via SetChoices, SetNumericRange, SetNumericStep, and OnlyInSections are
+// also included, to the extent that JSON Schema can express them: choices are
+// matched case-sensitively, ranges of duration options are omitted, and
+// options permitted only in specific named sections may appear in any named
+// section. Keys must use the canonical form of option names or aliases, with
+// dashes rather than underscores. Boolean options may also use a "skip-",
+// "disable-", or "enable-" prefix, and any key may use a "loose-" prefix,
+// which the schema permits without further validation. Positional args and
+// the built-in help and version options are omitted, as with
+// WriteConfigTemplate.
+func (cmd *Command) WriteJSONSchema(w io.Writer) error {
+	options := cmd.Options()
+	names := make([]string, 0, len(options))
+	for name := range options {
+		if !cmd.HasArg(name) && !templateExcluded[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	definitions := make(map[string]interface{}, len(names))
+	defaultProps := make(map[string]interface{})
+	sectionProps := make(map[string]interface{})
+	for _, name := range names {
+		opt := options[name]
+		definitions[name] = opt.jsonSchema()
+		ref := map[string]string{"$ref": "#/definitions/" + name}
+		for _, key := range opt.schemaKeys() {
+			if opt.permittedInSection("") {
+				defaultProps[key] = ref
+			}
+			if !opt.onlyInDefaultSection() {
+				sectionProps[key] = ref
+			}
+		}
+	}
+	loose := map[string]interface{}{"^loose-": map[string]interface{}{}}
+	schema := map[string]interface{}{
+		"$schema":           jsonSchemaDialect,
+		"title":             "Option file for " + cmd.fullName(),
+		"type":              "object",
+		"definitions":       definitions,
+		"properties":        defaultProps,
+		"patternProperties": loose,
+		"additionalProperties": map[string]interface{}{
+			"type":                 "object",
+			"properties":           sectionProps,
+			"patternProperties":    loose,
+			"additionalProperties": false,
+		},
+	}
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// jsonSchema returns a JSON Schema describing the values permitted for opt in
+// a JSON or YAML option file.
+func (opt *Option) jsonSchema() map[string]interface{} {
+	schema := make(map[string]interface{})
+	if opt.Description != "" {
+		schema["description"] = opt.Description
+	}
+	if opt.Deprecation != "" {
+		schema["deprecated"] = true
+	}
+
+	// Option files convert all scalar values to text, so most options accept
+	// several JSON types
+	var types []string
+	switch opt.Type {
+	case OptionTypeBool:
+		types = []string{"boolean", "number", "string"}
+		schema["default"] = BoolValue(opt.Default)
+	case OptionTypeCount:
+		types = []string{"integer", "string"}
+	case OptionTypeFloat:
+		types = []string{"number", "string"}
+	default:
+		types = []string{"string", "number", "boolean"}
+	}
+	if opt.Type != OptionTypeBool && opt.Default != "" {
+		schema["default"] = unquote(opt.Default)
+	}
+	if !opt.RequireValue {
+		types = append(types, "null")
+	}
+	schema["type"] = types
+
+	if len(opt.AllowedValues) > 0 {
+		allowed := make([]interface{}, 0, len(opt.AllowedValues)+1)
+		for _, value := range opt.AllowedValues {
+			allowed = append(allowed, value)
+		}
+		if !opt.RequireValue {
+			allowed = append(allowed, nil)
+		}
+		schema["enum"] = allowed
+	}
+	if r := opt.numRange; r != nil && opt.Type != OptionTypeDuration {
+		if !math.IsInf(r.min, 0) {
+			schema["minimum"] = r.min
+		}
+		if !math.IsInf(r.max, 0) {
+			schema["maximum"] = r.max
+		}
+		if r.step > 0 && (math.IsInf(r.min, 0) || math.Mod(r.min, r.step) == 0) {
+			schema["multipleOf"] = r.step
+		}
+	}
+	return schema
+}
+
+// schemaKeys returns the keys which may set opt's value in an option file, for
+// use in a JSON Schema: its name and aliases, along with their negated and
+// enabled forms for boolean options.
+func (opt *Option) schemaKeys() []string {
+	names := append([]string{opt.Name}, opt.Aliases...)
+	if opt.Type != OptionTypeBool {
+		return names
+	}
+	keys := make([]string, 0, 4*len(names))
+	for _, name := range names {
+		keys = append(keys, name, "skip-"+name, "disable-"+name, "enable-"+name)
+	}
+	return keys
+}
+
+// onlyInDefaultSection returns true if opt was restricted via OnlyInSections
+// such that it may only be set in the default nameless section.
+func (opt *Option) onlyInDefaultSection() bool {
+	for _, pattern := range opt.sections {
+		if pattern != "" {
+			return false
+		}
+	}
+	return len(opt.sections) > 0
+}
@@ -0,0 +1,105 @@
+package mybase
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestCommandWriteJSONSchema(t *testing.T) {
+	cmd := NewCommand("mycommand", "summary", "description", nil)
+	cmd.AddOptions("",
+		StringOption("host", 'h', "localhost", "Database host").AddAlias("server"),
+		BoolOption("debug", 0, false, "Enable debug logging"),
+		EnumOption("mode", 0, "fast", []string{"fast", "safe"}, "Operating mode"),
+		StringOption("port", 0, "3306", "Port number").SetNumericRange(1, 65535),
+		StringOption("schema", 0, "", "Schema name").OnlyInSections("db-*"),
+		StringOption("pid-file", 0, "", "Path to PID file").OnlyInSections(""),
+		StringOption("old-host", 0, "", "Old host").Hidden().Deprecated("host", ""),
+	)
+	cmd.AddArg("target", "", false)
+
+	var buf bytes.Buffer
+	if err := cmd.WriteJSONSchema(&buf); err != nil {
+		t.Fatalf("Unexpected error from WriteJSONSchema: %v", err)
+	}
+	var schema struct {
+		Schema               string                            `json:"$schema"`
+		Definitions          map[string]map[string]interface{} `json:"definitions"`
+		Properties           map[string]map[string]string      `json:"properties"`
+		AdditionalProperties struct {
+			Properties           map[string]map[string]string `json:"properties"`
+			AdditionalProperties bool                         `json:"additionalProperties"`
+		} `json:"additionalProperties"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &schema); err != nil {
+		t.Fatalf("Unable to unmarshal schema: %v\n%s", err, buf.String())
+	}
+	if schema.Schema != jsonSchemaDialect || schema.AdditionalProperties.AdditionalProperties {
+		t.Errorf("Unexpected top-level schema:\n%s", buf.String())
+	}
+
+	for _, name := range []string{"help", "version", "target"} {
+		if _, ok := schema.Definitions[name]; ok {
+			t.Errorf("Expected %s to be omitted from schema", name)
+		}
+	}
+	expectDefinitions := map[string]map[string]interface{}{
+		"host": {
+			"description": "Database host",
+			"default":     "localhost",
+			"type":        []interface{}{"string", "number", "boolean"},
+		},
+		"debug": {
+			"description": "Enable debug logging",
+			"default":     false,
+			"type":        []interface{}{"boolean", "number", "string", "null"},
+		},
+		"mode": {
+			"description": "Operating mode",
+			"default":     "fast",
+			"type":        []interface{}{"string", "number", "boolean"},
+			"enum":        []interface{}{"fast", "safe"},
+		},
+		"port": {
+			"description": "Port number",
+			"default":     "3306",
+			"type":        []interface{}{"string", "number", "boolean"},
+			"minimum":     float64(1),
+			"maximum":     float64(65535),
+		},
+		"old-host": {
+			"description": "Old host",
+			"deprecated":  true,
+			"type":        []interface{}{"string", "number", "boolean"},
+		},
+	}
+	for name, expected := range expectDefinitions {
+		if actual := schema.Definitions[name]; !reflect.DeepEqual(actual, expected) {
+			t.Errorf("Unexpected definition for %s: %v", name, actual)
+		}
+	}
+
+	// Check which keys are permitted in the default section vs named sections
+	expectKeys := map[string][2]bool{
+		"host":         {true, true},
+		"server":       {true, true},
+		"debug":        {true, true},
+		"skip-debug":   {true, true},
+		"enable-debug": {true, true},
+		"skip-host":    {false, false},
+		"schema":       {false, true},
+		"pid-file":     {true, false},
+	}
+	for key, expected := range expectKeys {
+		_, inDefault := schema.Properties[key]
+		_, inSection := schema.AdditionalProperties.Properties[key]
+		if inDefault != expected[0] || inSection != expected[1] {
+			t.Errorf("Unexpected placement of key %s: in default section %t, in named sections %t", key, inDefault, inSection)
+		}
+	}
+	if ref := schema.Properties["server"]["$ref"]; ref != "#/definitions/host" {
+		t.Errorf("Unexpected $ref for alias: %q", ref)
+	}
+}
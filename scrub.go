@@ -0,0 +1,135 @@
+package mybase
+
+import (
+	"os"
+	"strings"
+)
+
+// ScrubArgs hides the values of Sensitive options supplied on the
+// command-line, such as "--password=secret" or "-psecret", by replacing each
+// character of the value with "x". This mirrors the MySQL client programs, so
+// that a password supplied on the command-line does not linger in the output
+// of ps. It should be called as early as possible after ParseCLI, since the
+// value is visible to other users until then, and must be called before
+// Seal.
+//
+// On Linux, the process's original argument memory is overwritten in place,
+// as the MySQL client programs do, which changes the command-line shown by ps.
+// No special privileges are required. The values cfg parsed from the
+// command-line are copied first, so cfg continues to see the original values.
+// However, Go strings in os.Args share the argument memory, so any other
+// holders of those strings, such as a copy of os.Args made before calling
+// ScrubArgs, see the scrubbed values too. On other platforms, or if os.Args
+// no longer matches the process's original arguments, the argument memory is
+// left as-is.
+//
+// In all cases, the affected entries of os.Args are replaced with scrubbed
+// strings. The return value is true if the process's command-line was
+// changed, or false if this was not possible on the current platform.
+func (cfg *Config) ScrubArgs() bool {
+	cfg.assertUnsealed("ScrubArgs")
+	spans := sensitiveArgSpans(cfg.CLI.Command, os.Args)
+	if len(spans) == 0 {
+		return false
+	}
+	cfg.copyCLIValues()
+	changed := overwriteProcessArgs(os.Args, spans)
+	scrubArgSpans(os.Args, spans)
+	return changed
+}
+
+// copyCLIValues replaces every string held by cfg.CLI with a copy, so that none
+// of them share memory with os.Args, and marks cfg's caches dirty so that they
+// are rebuilt from the copies.
+func (cfg *Config) copyCLIValues() {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	cli := cfg.CLI
+	cli.InvokedAs = copyString(cli.InvokedAs)
+	for name, value := range cli.OptionValues {
+		cli.OptionValues[name] = copyString(value)
+	}
+	for n, value := range cli.ArgValues {
+		cli.ArgValues[n] = copyString(value)
+	}
+	for name, spelling := range cli.spellings {
+		cli.spellings[name] = copyString(spelling)
+	}
+	cfg.dirty = true
+}
+
+// copyString returns a copy of s which does not share its memory.
+func copyString(s string) string {
+	var b strings.Builder
+	b.WriteString(s)
+	return b.String()
+}
+
+// scrubArgSpans replaces the values at spans in args, which must be in the
+// same format as os.Args, with "x" characters. Each modified entry of args is
+// replaced with a new string.
+func scrubArgSpans(args []string, spans []argSpan) {
+	for _, span := range spans {
+		arg := args[span.index]
+		args[span.index] = arg[:span.start] + strings.Repeat("x", len(arg)-span.start)
+	}
+}
+
+// argSpan identifies the portion of a command-line arg which holds a value:
+// args[index][start:].
+type argSpan struct {
+	index int
+	start int
+}
+
+// sensitiveArgSpans returns the location of each value of a Sensitive option in
+// args, following the same rules as ParseCLI. Since the args were already
+// parsed successfully, unknown options are simply skipped.
+func sensitiveArgSpans(cmd *Command, args []string) (spans []argSpan) {
+	longOptionIndex, shortOptionIndex := cliOptionIndexes(cmd)
+	usePrefixes := cmd.Root().OptionPrefixes
+	for n := 1; n < len(args); n++ {
+		arg := args[n]
+		nextIsValue := n+1 < len(args) && !strings.HasPrefix(args[n+1], "-")
+		if arg == "--" {
+			break
+		} else if len(arg) > 2 && arg[0:2] == "--" {
+			key, _, hasValue, _ := NormalizeOptionToken(arg[2:])
+			opt := longOptionIndex[key]
+			if opt == nil && usePrefixes {
+				opt, _ = optionPrefixMatch(key, longOptionIndex)
+			}
+			if opt == nil {
+				continue
+			} else if hasValue && opt.sensitive {
+				spans = append(spans, argSpan{index: n, start: strings.IndexByte(arg, '=') + 1})
+			} else if !hasValue && opt.RequireValue && nextIsValue {
+				if opt.sensitive {
+					spans = append(spans, argSpan{index: n + 1})
+				}
+				n++
+			}
+		} else if len(arg) > 1 && arg[0] == '-' && !(isNegativeNumber(arg) && shortOptionIndex[rune(arg[1])] == nil) {
+			for offset, short := range arg[1:] {
+				opt := shortOptionIndex[short]
+				if opt == nil {
+					break
+				}
+				start := 1 + offset + len(string(short))
+				if start < len(arg) && opt.Type != OptionTypeBool && opt.Type != OptionTypeCount {
+					if opt.sensitive {
+						spans = append(spans, argSpan{index: n, start: start})
+					}
+					break
+				} else if opt.RequireValue && nextIsValue {
+					if opt.sensitive {
+						spans = append(spans, argSpan{index: n + 1})
+					}
+					n++
+					break
+				}
+			}
+		}
+	}
+	return spans
+}
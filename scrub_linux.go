@@ -0,0 +1,74 @@
+package mybase
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// overwriteProcessArgs replaces the values at spans in the process's original
+// argument memory with "x" characters, so that the command-line reported by
+// the kernel, for example to ps via /proc/<pid>/cmdline, no longer contains
+// them. The memory is located via /proc/self/stat, and written via
+// /proc/self/mem, which a process may always do to itself. args must be the
+// process's original arguments; if they do not exactly match the contents of
+// the argument memory, for example because os.Args was replaced, nothing is
+// written and false is returned.
+func overwriteProcessArgs(args []string, spans []argSpan) bool {
+	start, end, err := processArgsArea()
+	if err != nil {
+		return false
+	}
+	expected := strings.Join(args, "\x00") + "\x00"
+	if end-start != uint64(len(expected)) {
+		return false
+	}
+	mem, err := os.OpenFile("/proc/self/mem", os.O_RDWR, 0)
+	if err != nil {
+		return false
+	}
+	defer mem.Close()
+	current := make([]byte, len(expected))
+	if _, err := mem.ReadAt(current, int64(start)); err != nil || string(current) != expected {
+		return false
+	}
+
+	offsets := make([]int, len(args))
+	for n := 1; n < len(args); n++ {
+		offsets[n] = offsets[n-1] + len(args[n-1]) + 1
+	}
+	for _, span := range spans {
+		scrubbed := bytes.Repeat([]byte{'x'}, len(args[span.index])-span.start)
+		if _, err := mem.WriteAt(scrubbed, int64(start)+int64(offsets[span.index]+span.start)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// processArgsArea returns the start and end addresses of the process's
+// argument memory, from the arg_start and arg_end fields of /proc/self/stat.
+func processArgsArea() (start, end uint64, err error) {
+	stat, err := ioutil.ReadFile("/proc/self/stat")
+	if err != nil {
+		return 0, 0, err
+	}
+	// The second field is the executable's name in parentheses, which may
+	// itself contain spaces or parentheses, so fields are counted from the
+	// last closing parenthesis. The remaining fields begin with the third.
+	after := stat[bytes.LastIndexByte(stat, ')')+1:]
+	fields := strings.Fields(string(after))
+	const argStartField, argEndField = 48, 49
+	if len(fields) < argEndField-2 {
+		return 0, 0, os.ErrInvalid
+	}
+	if start, err = strconv.ParseUint(fields[argStartField-3], 10, 64); err != nil {
+		return 0, 0, err
+	}
+	if end, err = strconv.ParseUint(fields[argEndField-3], 10, 64); err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
@@ -0,0 +1,95 @@
+package mybase
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// TestScrubArgsCmdline runs the test binary in a child process, as an
+// unprivileged user if the test is running as root, and confirms that the
+// child's ScrubArgs changes its /proc/self/cmdline. See
+// TestScrubArgsHelperProcess for the child's logic.
+func TestScrubArgsCmdline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mybasetest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatalf("Unable to determine test executable: %v", err)
+	}
+
+	child := exec.Command(executable, "-test.run=^TestScrubArgsHelperProcess$", "arg1", "-phunter2", "--visible=v", "--secret", "s3cr3t")
+	child.Env = append(os.Environ(), "MYBASE_TEST_SCRUB_HELPER=1")
+	if os.Getuid() == 0 {
+		// The unprivileged user must be able to execute a copy of the test binary
+		contents, err := ioutil.ReadFile(executable)
+		if err != nil {
+			t.Fatalf("Unable to read test executable: %v", err)
+		}
+		child.Path = filepath.Join(dir, "mybase.test")
+		if err := ioutil.WriteFile(child.Path, contents, 0755); err != nil {
+			t.Fatalf("Unable to copy test executable: %v", err)
+		}
+		if err := os.Chmod(dir, 0755); err != nil {
+			t.Fatalf("Unable to chmod temp dir: %v", err)
+		}
+		child.Dir = dir
+		child.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{Uid: 65534, Gid: 65534}}
+	}
+	output, err := child.CombinedOutput()
+	if err != nil {
+		t.Fatalf("Child process failed: %v\n%s", err, output)
+	}
+	if !strings.Contains(string(output), "scrubbed cmdline") {
+		t.Errorf("Child process did not run the expected test:\n%s", output)
+	}
+}
+
+// TestScrubArgsHelperProcess is run as a child process by
+// TestScrubArgsCmdline. The args following the test binary's own flags are
+// parsed as a command-line, and then scrubbed via ScrubArgs.
+func TestScrubArgsHelperProcess(t *testing.T) {
+	if os.Getenv("MYBASE_TEST_SCRUB_HELPER") != "1" {
+		t.Skip("Only run as a child process of TestScrubArgsCmdline")
+	}
+	cmd := simpleCommand()
+	cmd.AddOption(StringOption("password", 'p', "", "dummy").ValueOptional().Sensitive())
+	cmd.AddOption(StringOption("secret", 0, "", "dummy").Sensitive())
+
+	// The parsed values share the process's argument memory, since they are
+	// substrings of os.Args
+	cfg, err := ParseCLI(cmd, append([]string{"mycommand"}, os.Args[2:]...))
+	if err != nil {
+		t.Fatalf("Unexpected error from ParseCLI: %v", err)
+	}
+	argsCopy := append([]string(nil), os.Args...)
+	if !cfg.ScrubArgs() {
+		t.Fatalf("Expected ScrubArgs to return true as uid %d, but it did not", os.Getuid())
+	}
+	cmdline, err := ioutil.ReadFile("/proc/self/cmdline")
+	if err != nil {
+		t.Fatalf("Unable to read /proc/self/cmdline: %v", err)
+	}
+	expected := strings.Join(append([]string{os.Args[0], os.Args[1]}, "arg1", "-pxxxxxxx", "--visible=v", "--secret", "xxxxxx"), "\x00") + "\x00"
+	if string(cmdline) != expected {
+		t.Errorf("Unexpected /proc/self/cmdline after ScrubArgs: %q", cmdline)
+	}
+	if actual := strings.Join(os.Args, "\x00") + "\x00"; actual != expected {
+		t.Errorf("Unexpected os.Args after ScrubArgs: %q", os.Args)
+	}
+	if cfg.Get("password") != "hunter2" || cfg.Get("secret") != "s3cr3t" || cfg.Get("visible") != "v" || cfg.Get("required") != "arg1" {
+		t.Errorf("Unexpected values after ScrubArgs: password=%q secret=%q visible=%q", cfg.Get("password"), cfg.Get("secret"), cfg.Get("visible"))
+	}
+	if argsCopy[3] != "-pxxxxxxx" {
+		t.Errorf("Expected strings sharing the argument memory to be scrubbed, instead found %q", argsCopy[3])
+	}
+	fmt.Println("scrubbed cmdline")
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package mybase
+
+// overwriteProcessArgs is only supported on Linux, where the process's
+// argument memory can be located and written without unsafe code.
+func overwriteProcessArgs(args []string, spans []argSpan) bool {
+	return false
+}
@@ -0,0 +1,48 @@
+package mybase
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestScrubArgs(t *testing.T) {
+	cmd := simpleCommand()
+	cmd.AddOption(StringOption("password", 'p', "", "dummy").ValueOptional().Sensitive())
+	cmd.AddOption(StringOption("secret", 0, "", "dummy").Sensitive())
+
+	args := []string{"mycommand", "-bpSecret", "--secret", "s2", "--visible=v", "--password=pw", "--", "--secret=not-an-option"}
+	orig := append([]string(nil), args...)
+	scrubArgSpans(args, sensitiveArgSpans(cmd, args))
+	expected := []string{"mycommand", "-bpxxxxxx", "--secret", "xx", "--visible=v", "--password=xx", "--", "--secret=not-an-option"}
+	if strings.Join(args, " ") != strings.Join(expected, " ") {
+		t.Errorf("Unexpected args after scrubArgSpans: %q", args)
+	}
+	if orig[1] != "-bpSecret" || orig[3] != "s2" || orig[5] != "--password=pw" {
+		t.Errorf("Expected original strings to be unaffected by scrubArgSpans, instead found %q", orig)
+	}
+	if spans := sensitiveArgSpans(cmd, []string{"mycommand", "--visible=v", "arg1"}); len(spans) > 0 {
+		t.Errorf("Expected no spans when no sensitive values are present, instead found %v", spans)
+	}
+
+	// os.Args entries are replaced, and cfg retains the original values
+	defer func(orig []string) { os.Args = orig }(os.Args)
+	os.Args = []string{"mycommand", "arg1", "-phunter2", "--secret", strings.Repeat("z", 3)}
+	cfg, err := ParseCLI(cmd, os.Args)
+	if err != nil {
+		t.Fatalf("Unexpected error from ParseCLI: %v", err)
+	}
+	changed := cfg.ScrubArgs()
+	if actual := strings.Join(os.Args, " "); actual != "mycommand arg1 -pxxxxxxx --secret xxx" {
+		t.Errorf("Unexpected os.Args after ScrubArgs: %s", actual)
+	}
+	if cfg.Get("password") != "hunter2" || cfg.Get("secret") != "zzz" || cfg.Get("required") != "arg1" {
+		t.Errorf("Unexpected values after ScrubArgs: password=%q secret=%q", cfg.Get("password"), cfg.Get("secret"))
+	}
+
+	// The process's argument memory is left alone, since os.Args no longer
+	// matches it
+	if changed {
+		t.Error("Expected ScrubArgs to return false when os.Args was replaced")
+	}
+}
@@ -0,0 +1,65 @@
+package mybase
+
+import (
+	"fmt"
+)
+
+// Seal makes cfg immutable, typically once a program has finished its startup
+// logic of adding sources and selecting option file sections. Option values
+// are resolved immediately, and from then on cfg always returns these same
+// values. Afterwards, any attempt to change the configuration panics, since
+// this is indicative of programmer error. This includes adding or protecting
+// sources of cfg, PromptMissing, ReloadFiles, and ReloadValidated, as well as
+// calling methods which modify any *File source of cfg, such as
+// SetOptionValue, UnsetOptionValue, DeleteOption, DeleteSection,
+// RenameSection, PruneDefaults, UseSection, Edit, Merge, Reload, or any of the
+// Parse methods. Files remain sealed even if later used by another Config.
+//
+// Other types of sources cannot detect modification, but a sealed cfg ignores
+// any change to their values, including expiration of values from an
+// ExpiringOptionValuer; MarkDirty has no effect once cfg is sealed. Direct
+// modification of cfg.CLI's fields is not prevented, and also has no effect.
+//
+// A Config returned by Clone or CloneWithOverrides is not sealed, even if the
+// original Config was. Calling Seal more than once has no further effect.
+func (cfg *Config) Seal() {
+	cfg.mu.Lock()
+	if cfg.sealed {
+		cfg.mu.Unlock()
+		return
+	}
+	cfg.resolveCommands()
+	cfg.sealed = true
+	for _, source := range cfg.sources {
+		if f, ok := source.(*File); ok {
+			f.mu.Lock()
+			f.sealed = true
+			f.mu.Unlock()
+		}
+	}
+	cfg.mu.Unlock()
+	cfg.flushPending()
+}
+
+// Sealed returns true if Seal has been called on cfg.
+func (cfg *Config) Sealed() bool {
+	cfg.mu.RLock()
+	defer cfg.mu.RUnlock()
+	return cfg.sealed
+}
+
+// assertUnsealed panics if cfg has been sealed. The caller must not hold a lock
+// on cfg.mu.
+func (cfg *Config) assertUnsealed(method string) {
+	if cfg.Sealed() {
+		panic(fmt.Errorf("Assertion failed: Config.%s called after Config.Seal", method))
+	}
+}
+
+// assertUnsealed panics if f is a source of a sealed Config. The caller must
+// hold a lock on f.mu.
+func (f *File) assertUnsealed(method string) {
+	if f.sealed {
+		panic(fmt.Errorf("Assertion failed: File.%s called on %s, which is a source of a sealed Config", method, f.Path()))
+	}
+}
@@ -0,0 +1,63 @@
+package mybase
+
+import (
+	"testing"
+)
+
+func TestConfigSeal(t *testing.T) {
+	src := SimpleSource(map[string]string{"hidden": "fromsource"})
+	cfg := ParseFakeCLI(t, simpleCommand(), "mycommand arg1", src)
+	f, err := getParsedFile(cfg, false, "visible=fromfile\n[a]\nhasshort=a\n")
+	if err != nil {
+		t.Fatalf("Unexpected error parsing file: %v", err)
+	}
+	cfg.AddSource(f)
+	if cfg.Sealed() {
+		t.Fatal("Expected new Config to not be sealed")
+	}
+	cfg.Seal()
+	cfg.Seal() // no further effect
+	if !cfg.Sealed() {
+		t.Fatal("Expected Config to be sealed")
+	}
+
+	expectPanic := func(description string, fn func()) {
+		t.Helper()
+		defer func() {
+			t.Helper()
+			if recover() == nil {
+				t.Errorf("Expected %s to panic on sealed Config, but it did not", description)
+			}
+		}()
+		fn()
+	}
+	expectPanic("AddSource", func() { cfg.AddSource(SimpleSource{}) })
+	expectPanic("InsertSourceBefore", func() { cfg.InsertSourceBefore(f, SimpleSource{}) })
+	expectPanic("InsertSourceAfter", func() { cfg.InsertSourceAfter(f, SimpleSource{}) })
+	expectPanic("ProtectSource", func() { cfg.ProtectSource(f) })
+	expectPanic("ReloadFiles", func() { cfg.ReloadFiles() })
+	expectPanic("File.SetOptionValue", func() { f.SetOptionValue("", "visible", "changed") })
+	expectPanic("File.UnsetOptionValue", func() { f.UnsetOptionValue("", "visible") })
+	expectPanic("File.DeleteSection", func() { f.DeleteSection("a") })
+	expectPanic("File.UseSection", func() { f.UseSection("a") })
+	expectPanic("File.ParseReader", func() { f.ParseReader(cfg, nil) })
+
+	// Changes to sources which cannot be prevented are ignored
+	src["hidden"] = "changed"
+	cfg.MarkDirty()
+	if cfg.Get("visible") != "fromfile" || cfg.Get("hidden") != "fromsource" || cfg.Get("hasshort") != "" {
+		t.Errorf("Unexpected values from sealed Config: visible=%q hidden=%q hasshort=%q", cfg.Get("visible"), cfg.Get("hidden"), cfg.Get("hasshort"))
+	}
+
+	// A clone is not sealed, and reflects current values of its sources, but the
+	// File remains sealed
+	clone := cfg.Clone()
+	if clone.Sealed() {
+		t.Error("Expected Clone of sealed Config to not be sealed")
+	}
+	clone.AddSource(SimpleSource{"bool1": "1"})
+	if !clone.GetBool("bool1") || clone.Get("hidden") != "changed" {
+		t.Errorf("Unexpected values from clone: bool1=%t hidden=%q", clone.GetBool("bool1"), clone.Get("hidden"))
+	}
+	expectPanic("File.SetOptionValue via clone", func() { f.SetOptionValue("", "visible", "changed") })
+}
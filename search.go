@@ -0,0 +1,61 @@
+package mybase
+
+import "fmt"
+
+// Occurrence describes a value for an option found by Search.
+type Occurrence struct {
+	FilePath   string // path of the file containing the value, which may be a file included by one of the searched files
+	Section    string // name of the section containing the value, or "" for the default section
+	LineNumber int    // line number of the value, or 0 if it was not parsed from a line, e.g. if set via File.SetOptionValue
+	Value      string // value as stored in the section, e.g. "0" for a "skip-" prefixed boolean
+	opt        *Option
+}
+
+// String returns a human-readable description of the occurrence, in the form
+// "path line N [section]: value". The values of options marked with
+// Option.Sensitive are redacted, if the value was parsed from a line.
+func (o Occurrence) String() string {
+	location := o.FilePath
+	if o.LineNumber > 0 {
+		location = lineLocation{filePath: o.FilePath, lineNumber: o.LineNumber}.String()
+	}
+	return fmt.Sprintf("%s [%s]: %s", location, o.Section, o.opt.displayValue(o.Value))
+}
+
+// Search returns every place in the supplied files where the named option has
+// a value, including sections which are not selected and sections which are
+// not otherwise used by the program. This is useful for determining where an
+// option is actually set across layered option files. The option name may use
+// underscores or an alias of an option, as in the files themselves.
+//
+// The files should already be parsed by the caller; files which have not been
+// parsed are skipped. Occurrences are returned in the order of configChain,
+// and then in the order of sections within each file. Since each section
+// retains only one value for an option, a value which is repeated within the
+// same section is only reported once, at the location which took effect.
+func Search(configChain []*File, optionName string) []Occurrence {
+	optionName = canonicalOptionName(optionName)
+	var result []Occurrence
+	for _, f := range configChain {
+		f.loadAllPending()
+		f.mu.RLock()
+		if !f.parsed {
+			f.mu.RUnlock()
+			continue
+		}
+		for _, section := range f.sections {
+			name := section.resolveOptionName(optionName)
+			value, ok := section.Values[name]
+			if !ok {
+				continue
+			}
+			occ := Occurrence{FilePath: f.Path(), Section: section.Name, Value: value, opt: section.opts[name]}
+			if loc, ok := section.valueLocs[name]; ok {
+				occ.FilePath, occ.LineNumber = loc.filePath, loc.lineNumber
+			}
+			result = append(result, occ)
+		}
+		f.mu.RUnlock()
+	}
+	return result
+}
@@ -0,0 +1,81 @@
+package mybase
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSearch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mybasetest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	writeFile := func(name, contents string) {
+		t.Helper()
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatalf("Unable to write file: %v", err)
+		}
+	}
+	writeFile("a.cnf", "visible=a\n[unused]\nhidden=x\n\n[prod]\ndb_name=a-prod\n")
+	writeFile("b.cnf", "password=secret\n[prod]\n!include extra.cnf\nhidden=y\n")
+	writeFile("extra.cnf", "database=extra\n")
+
+	cmd := simpleCommand()
+	cmd.AddOption(StringOption("database", 'D', "", "dummy description").AddAlias("db_name"))
+	cmd.AddOption(StringOption("password", 'p', "", "dummy description").Sensitive())
+	cfg := ParseFakeCLI(t, cmd, "mycommand arg1")
+	var files []*File
+	for _, name := range []string{"a.cnf", "b.cnf", "nonexistent.cnf"} {
+		f := NewFile(dir, name)
+		if f.Exists() {
+			if err := f.Parse(cfg); err != nil {
+				t.Fatalf("Unexpected error from Parse: %v", err)
+			}
+		}
+		files = append(files, f)
+	}
+	files[1].SetOptionValue("", "visible", "set")
+
+	results := Search(files, "hidden")
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 occurrences, instead found %d: %v", len(results), results)
+	}
+	expected := Occurrence{FilePath: filepath.Join(dir, "a.cnf"), Section: "unused", LineNumber: 3, Value: "x"}
+	if actual := results[0]; actual.FilePath != expected.FilePath || actual.Section != expected.Section || actual.LineNumber != expected.LineNumber || actual.Value != expected.Value {
+		t.Errorf("Expected first occurrence %+v, instead found %+v", expected, actual)
+	}
+	if results[1].Section != "prod" || results[1].LineNumber != 4 {
+		t.Errorf("Unexpected second occurrence %+v", results[1])
+	}
+
+	// Aliases and underscores should match, and values from included files
+	// should report the included file's path
+	results = Search(files, "DB_NAME")
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 occurrences, instead found %d: %v", len(results), results)
+	}
+	if results[0].Value != "a-prod" || results[1].FilePath != filepath.Join(dir, "extra.cnf") || results[1].LineNumber != 1 {
+		t.Errorf("Unexpected occurrences %v", results)
+	}
+	if str := results[0].String(); str != filepath.Join(dir, "a.cnf")+" line 6 [prod]: a-prod" {
+		t.Errorf("Unexpected String() result: %q", str)
+	}
+
+	// Values not parsed from a line have no line number, and sensitive values
+	// should be redacted
+	results = Search(files, "visible")
+	if len(results) != 2 || results[1].LineNumber != 0 || results[1].Value != "set" {
+		t.Errorf("Unexpected occurrences %v", results)
+	}
+	results = Search(files, "password")
+	if len(results) != 1 || results[0].String() != filepath.Join(dir, "b.cnf")+" line 1 []: "+redactedValue {
+		t.Errorf("Unexpected occurrences %v", results)
+	}
+
+	if results = Search(files, "bool1"); len(results) != 0 {
+		t.Errorf("Expected no occurrences, instead found %v", results)
+	}
+}
@@ -0,0 +1,148 @@
+package mybase
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// AddOptionsFromStruct adds an Option to cmd for each tagged field of the
+// struct pointed to by ptr, using the same `mybase:"option-name"` tags as
+// Config.Unmarshal, so that one struct type can both define a command's
+// options and receive their values. Each field's current value becomes the
+// Option's default. These additional tags are also recognized:
+//
+//   - `short:"x"` sets the Option's single-character shorthand
+//   - `desc:"text"` sets the Option's description
+//   - `group:"name"` on an untagged struct field places the options of the
+//     nested struct into the named option group
+//
+// The type of each Option is determined by the field's type: a string field
+// creates a string option; bool creates a bool option; time.Duration creates a
+// duration option; time.Time creates a time option; []string creates a
+// multi-valued option; map[string]string creates a map-valued option; float32
+// or float64 creates a float option; and integer fields create a string
+// option. Numeric options also have a validator which rejects values not
+// representable by the field's type. Options of fields in the top-level struct
+// are placed in the unnamed group. Untagged struct fields are processed recursively, as
+// with Config.Unmarshal.
+//
+// Panics if ptr is not a non-nil pointer to a struct, if a tagged field has an
+// unsupported type, or if a short tag is not a single character, since these
+// indicate programmer error. Also panics in the same situations as AddOption.
+func (cmd *Command) AddOptionsFromStruct(ptr interface{}) {
+	v := reflect.ValueOf(ptr)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		panic(fmt.Errorf("AddOptionsFromStruct: argument must be a non-nil pointer to a struct, instead found %T", ptr))
+	}
+	cmd.addOptionsFromStruct(v.Elem(), "")
+}
+
+// addOptionsFromStruct implements AddOptionsFromStruct for a single struct
+// value, placing its options in the supplied group.
+func (cmd *Command) addOptionsFromStruct(v reflect.Value, group string) {
+	t := v.Type()
+	for n := 0; n < t.NumField(); n++ {
+		field := t.Field(n)
+		if field.PkgPath != "" { // unexported
+			continue
+		}
+		name, tagged := field.Tag.Lookup(structTagName)
+		if name == "-" {
+			continue
+		} else if !tagged {
+			if field.Type.Kind() == reflect.Struct {
+				subGroup := group
+				if tagGroup, ok := field.Tag.Lookup("group"); ok {
+					subGroup = tagGroup
+				}
+				cmd.addOptionsFromStruct(v.Field(n), subGroup)
+			}
+			continue
+		}
+		var short rune
+		if shortTag := field.Tag.Get("short"); shortTag != "" {
+			if utf8.RuneCountInString(shortTag) != 1 {
+				panic(fmt.Errorf("AddOptionsFromStruct: field %s of %s has short tag %q, which is not a single character", field.Name, t, shortTag))
+			}
+			short, _ = utf8.DecodeRuneInString(shortTag)
+		}
+		opt := structFieldOption(v.Field(n), name, short, field.Tag.Get("desc"))
+		cmd.AddOptions(group, opt)
+	}
+}
+
+// structFieldOption returns an Option suitable for populating fieldValue via
+// Config.Unmarshal, using fieldValue's current value as the default.
+func structFieldOption(fieldValue reflect.Value, name string, short rune, description string) *Option {
+	typ := fieldValue.Type()
+	if typ == durationType {
+		return DurationOption(name, short, time.Duration(fieldValue.Int()), description)
+	} else if typ == timeType {
+		return TimeOption(name, short, fieldValue.Interface().(time.Time), description)
+	}
+	switch typ.Kind() {
+	case reflect.String:
+		return StringOption(name, short, fieldValue.String(), description)
+	case reflect.Bool:
+		return BoolOption(name, short, fieldValue.Bool(), description)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var defaultValue string
+		if fieldValue.Int() != 0 {
+			defaultValue = strconv.FormatInt(fieldValue.Int(), 10)
+		}
+		return StringOption(name, short, defaultValue, description).SetValidator(numericValidator(typ, func(value string) error {
+			_, err := strconv.ParseInt(value, 10, typ.Bits())
+			return err
+		}))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var defaultValue string
+		if fieldValue.Uint() != 0 {
+			defaultValue = strconv.FormatUint(fieldValue.Uint(), 10)
+		}
+		return StringOption(name, short, defaultValue, description).SetValidator(numericValidator(typ, func(value string) error {
+			_, err := strconv.ParseUint(value, 10, typ.Bits())
+			return err
+		}))
+	case reflect.Float32, reflect.Float64:
+		opt := FloatOption(name, short, 0, description)
+		if fieldValue.Float() != 0 {
+			opt.Default = strconv.FormatFloat(fieldValue.Float(), 'g', -1, typ.Bits())
+		}
+		return opt.SetValidator(numericValidator(typ, func(value string) error {
+			_, err := strconv.ParseFloat(value, typ.Bits())
+			return err
+		}))
+	case reflect.Slice:
+		if reflect.TypeOf([]string(nil)).ConvertibleTo(typ) {
+			values := fieldValue.Convert(reflect.TypeOf([]string(nil))).Interface().([]string)
+			return MultiOption(name, short, strings.Join(values, ","), description)
+		}
+	case reflect.Map:
+		if reflect.TypeOf(map[string]string(nil)).ConvertibleTo(typ) {
+			entries := fieldValue.Convert(reflect.TypeOf(map[string]string(nil))).Interface().(map[string]string)
+			pairs := make([]string, 0, len(entries))
+			for key, value := range entries {
+				pairs = append(pairs, key+"="+value)
+			}
+			sort.Strings(pairs)
+			return MapOption(name, short, strings.Join(pairs, ","), description)
+		}
+	}
+	panic(fmt.Errorf("AddOptionsFromStruct: unsupported field type %s for option %s", typ, name))
+}
+
+// numericValidator returns an OptionValidator which permits empty values, and
+// otherwise rejects values for which parse returns an error.
+func numericValidator(typ reflect.Type, parse func(value string) error) OptionValidator {
+	return func(name, value string) error {
+		if value == "" || parse(value) == nil {
+			return nil
+		}
+		return fmt.Errorf("must be a number representable as %s", typ)
+	}
+}
@@ -0,0 +1,98 @@
+package mybase
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAddOptionsFromStruct(t *testing.T) {
+	type connOptions struct {
+		Host    string        `mybase:"host" short:"h" desc:"Server host"`
+		Port    uint16        `mybase:"port" short:"P"`
+		Timeout time.Duration `mybase:"timeout"`
+	}
+	type options struct {
+		Verbose  bool              `mybase:"verbose" short:"v" desc:"Enable verbose output"`
+		Ratio    float64           `mybase:"ratio"`
+		Retries  int               `mybase:"retries"`
+		Tables   []string          `mybase:"table"`
+		Vars     map[string]string `mybase:"var"`
+		Conn     connOptions       `group:"connection"`
+		Ignored  string            `mybase:"-"`
+		Untagged string
+	}
+	defaults := options{
+		Ratio:  0.25,
+		Tables: []string{"a", "b"},
+		Vars:   map[string]string{"y": "2", "x": "1"},
+		Conn:   connOptions{Host: "localhost", Port: 3306, Timeout: 5 * time.Second},
+	}
+	cmd := NewCommand("mycommand", "summary", "description", nil)
+	cmd.AddOptionsFromStruct(&defaults)
+
+	opts := cmd.Options()
+	for name, expected := range map[string]*Option{
+		"verbose": {Type: OptionTypeBool, Shorthand: 'v', Description: "Enable verbose output"},
+		"ratio":   {Type: OptionTypeFloat, Default: "0.25"},
+		"retries": {Type: OptionTypeString},
+		"table":   {Type: OptionTypeMulti, Default: "a,b"},
+		"var":     {Type: OptionTypeMap, Default: "x=1,y=2"},
+		"host":    {Type: OptionTypeString, Shorthand: 'h', Default: "localhost", Description: "Server host", Group: "connection"},
+		"port":    {Type: OptionTypeString, Shorthand: 'P', Default: "3306", Group: "connection"},
+		"timeout": {Type: OptionTypeDuration, Default: "5s", Group: "connection"},
+	} {
+		opt := opts[name]
+		if opt == nil {
+			t.Errorf("Expected option %s to be added, but it was not", name)
+			continue
+		}
+		if opt.Type != expected.Type || opt.Shorthand != expected.Shorthand || opt.Default != expected.Default || opt.Description != expected.Description || opt.Group != expected.Group {
+			t.Errorf("Unexpected option %s: %+v", name, opt)
+		}
+	}
+	if len(opts) != 11 { // 8 above, plus help, help-all, version
+		t.Errorf("Expected 11 options, instead found %d", len(opts))
+	}
+
+	// Round-trip via Unmarshal
+	cfg := ParseFakeCLI(t, cmd, "mycommand -v -P 3307 --retries=3 --table=c")
+	var actual options
+	if err := cfg.Unmarshal(&actual); err != nil {
+		t.Fatalf("Unexpected error from Unmarshal: %v", err)
+	}
+	expected := defaults
+	expected.Verbose, expected.Conn.Port, expected.Retries, expected.Tables = true, 3307, 3, []string{"c"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Unexpected result from Unmarshal:\n%+v\nexpected:\n%+v", actual, expected)
+	}
+
+	// Numeric values are validated
+	cfg = ParseFakeCLI(t, cmd, "mycommand --port=70000")
+	if err := cfg.CheckValues(); err == nil {
+		t.Error("Expected error from CheckValues with out-of-range port, but err is nil")
+	}
+	if _, err := ParseCLI(cmd, []string{"mycommand", "--ratio=lots"}); err == nil {
+		t.Error("Expected error from ParseCLI with invalid ratio, but err is nil")
+	}
+
+	// Programmer errors
+	for _, ptr := range []interface{}{
+		defaults,
+		&struct {
+			Bad []int `mybase:"bad"`
+		}{},
+		&struct {
+			Bad string `mybase:"bad" short:"ab"`
+		}{},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected AddOptionsFromStruct to panic with %T, but it did not", ptr)
+				}
+			}()
+			NewCommand("other", "summary", "description", nil).AddOptionsFromStruct(ptr)
+		}()
+	}
+}
@@ -0,0 +1,183 @@
+package mybase
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCommandTimeout is the maximum duration of a command executed for an
+// Option with command substitution, if AllowCommandSubstitution was called
+// with a non-positive timeout.
+const DefaultCommandTimeout = 10 * time.Second
+
+// AllowCommandSubstitution permits the Option's value to be obtained by
+// executing a command. When a Config resolves the Option's value, and the value
+// from the supplying source is of the form "$(command)", the command is run
+// using the system shell ("sh -c", or "cmd /C" on Windows), and its output
+// becomes the Option's value, minus any trailing newlines. For example, an
+// option file line "password=$(pass show db/prod)" obtains the password from a
+// password manager. Values which are quote-wrapped are left as-is.
+//
+// The command is killed if it does not complete within timeout, or within
+// DefaultCommandTimeout if timeout is not positive. A command which fails or
+// times out results in an OptionTransformError. Each distinct command is run
+// at most once per Config, even if the Config's caches are rebuilt; its output
+// or failure is remembered. Commands run without holding the Config's lock, so
+// lookups of other options are not delayed by a slow command. Config.Get and
+// all getters built on it return the command's output, while Config.GetRaw
+// returns the original value. Any transform set via SetTransform is applied to
+// the command's output, rather than to the original value.
+//
+// Command substitution only applies to values from the command-line, from
+// local option files (Files), and from the Option's default. A "$(command)"
+// value from any other source, such as a RemoteSource, an EnvSource, the
+// runtime overrides of an AdminServer, or a parent process's configuration
+// applied via Config.UseParentConfig, is not executed; it results in an
+// OptionTransformError instead.
+func (opt *Option) AllowCommandSubstitution(timeout time.Duration) *Option {
+	if timeout <= 0 {
+		timeout = DefaultCommandTimeout
+	}
+	opt.cmdTimeout = timeout
+	return opt
+}
+
+// substitutionPermitted returns true if command substitution may be performed
+// on values supplied by source. Only sources which are under the control of the
+// local user are permitted, since a "$(command)" value from anywhere else could
+// run arbitrary commands.
+func substitutionPermitted(source OptionValuer) bool {
+	switch source.(type) {
+	case *CommandLine, *File, *Command:
+		return true
+	}
+	return false
+}
+
+// substitutionCommand returns the command in value, if value is of the form
+// "$(command)" and was not quote-wrapped.
+func substitutionCommand(value string) (string, bool) {
+	if !strings.HasPrefix(value, "$(") || !strings.HasSuffix(value, ")") {
+		return "", false
+	}
+	command := strings.TrimSpace(value[2 : len(value)-1])
+	return command, command != ""
+}
+
+// commandCache holds the results of commands run for command substitution,
+// keyed by command. It has its own lock, so that commands may run without
+// holding a lock on the Config.
+type commandCache struct {
+	mu      sync.Mutex
+	results map[string]*commandResult
+}
+
+// commandResult is the outcome of running a command for command substitution.
+// The done channel is closed once output and err are populated.
+type commandResult struct {
+	done   chan struct{}
+	output string
+	err    error
+}
+
+// result returns the result of command, and whether it has completed. If
+// command has not been run yet, nil and false are returned.
+func (cc *commandCache) result(command string) (*commandResult, bool) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	result := cc.results[command]
+	if result == nil {
+		return nil, false
+	}
+	select {
+	case <-result.done:
+		return result, true
+	default:
+		return result, false
+	}
+}
+
+// run runs command if it has not already been run, or waits for it to
+// complete if it is already running. The caller must not hold a lock on the
+// Config's mu, since commands may be slow.
+func (cc *commandCache) run(command string, timeout time.Duration) {
+	cc.mu.Lock()
+	result := cc.results[command]
+	if result != nil {
+		cc.mu.Unlock()
+		<-result.done
+		return
+	}
+	if cc.results == nil {
+		cc.results = make(map[string]*commandResult)
+	}
+	result = &commandResult{done: make(chan struct{})}
+	cc.results[command] = result
+	cc.mu.Unlock()
+
+	result.output, result.err = runCommand(command, timeout)
+	close(result.done)
+}
+
+// resolveCommands rebuilds cfg's caches if they are stale, and then runs any
+// commands for command substitution which the rebuild found to be needed,
+// rebuilding again once they complete. The lock on cfg.mu is released while
+// commands run. The caller must hold a write lock on cfg.mu.
+func (cfg *Config) resolveCommands() {
+	if cfg.stale() {
+		cfg.rebuild()
+	}
+	for len(cfg.unresolved) > 0 {
+		unresolved := cfg.unresolved
+		cfg.mu.Unlock()
+		for command, opt := range unresolved {
+			cfg.commands.run(command, opt.cmdTimeout)
+		}
+		cfg.mu.Lock()
+		cfg.rebuild()
+	}
+}
+
+// runCommand runs command using the system shell, returning its output minus
+// any trailing newlines.
+func runCommand(command string, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("command %q failed: %s", command, err)
+	}
+
+	// The shell is killed upon timeout, but Wait also waits for any of its child
+	// processes which still hold its output pipes, so don't rely on Wait alone
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		return "", fmt.Errorf("command %q did not complete within %s", command, timeout)
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("command %q failed: %s: %s", command, err, msg)
+		}
+		return "", fmt.Errorf("command %q failed: %s", command, err)
+	}
+	return strings.TrimRight(stdout.String(), "\r\n"), nil
+}
@@ -0,0 +1,127 @@
+package mybase
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAllowCommandSubstitution(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mybasetest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	counter := filepath.Join(dir, "counter")
+
+	cmd := simpleCommand()
+	cmd.AddOption(StringOption("password", 'p', "", "dummy description").AllowCommandSubstitution(0).Sensitive())
+	cmd.AddOption(StringOption("upper", 0, "", "dummy description").AllowCommandSubstitution(time.Second).SetTransform(func(value string, ctx TransformContext) (string, error) {
+		return strings.ToUpper(value), nil
+	}))
+	cmd.AddOption(StringOption("slow", 0, "", "dummy description").AllowCommandSubstitution(50 * time.Millisecond))
+	cfg := ParseFakeCLI(t, cmd, "mycommand --visible='$(echo hi)' --upper='$(echo shout)' arg1")
+	f, err := getParsedFile(cfg, false, "password=$(echo s3cr3t>>"+counter+" && echo s3cr3t)\nhasshort='$(echo quoted)'\n")
+	if err != nil {
+		t.Fatalf("Unexpected error from getParsedFile: %v", err)
+	}
+	cfg.AddSource(f)
+
+	if actual := cfg.Get("password"); actual != "s3cr3t" {
+		t.Errorf("Expected command output %q, instead found %q", "s3cr3t", actual)
+	}
+	if actual := cfg.GetRaw("password"); !strings.HasPrefix(actual, "$(") {
+		t.Errorf("Expected GetRaw to return original value, instead found %q", actual)
+	}
+	if actual := cfg.Get("upper"); actual != "SHOUT" {
+		t.Errorf("Expected transform to apply to command output, instead found %q", actual)
+	}
+	if actual := cfg.Get("visible"); actual != "$(echo hi)" {
+		t.Errorf("Expected option without command substitution to be left as-is, instead found %q", actual)
+	}
+	if actual := cfg.Get("hasshort"); actual != "$(echo quoted)" {
+		t.Errorf("Expected quoted value to be left as-is, instead found %q", actual)
+	}
+
+	// Rebuilding caches should not re-run a command, even if it failed
+	cfg.MarkDirty()
+	cfg.Get("password")
+	if contents, err := ioutil.ReadFile(counter); err != nil || strings.Count(string(contents), "s3cr3t") != 1 {
+		t.Errorf("Expected command to run exactly once; contents=%q err=%v", contents, err)
+	}
+	failCounter := filepath.Join(dir, "failcounter")
+	cfg = ParseFakeCLI(t, cmd, "mycommand --slow='$(echo fail>>"+failCounter+" && exit 3)' arg1")
+	for n := 0; n < 2; n++ {
+		if err := cfg.CheckValues(); err == nil {
+			t.Error("Expected failed command to cause an error, but it did not")
+		}
+		cfg.MarkDirty()
+	}
+	if contents, err := ioutil.ReadFile(failCounter); err != nil || strings.Count(string(contents), "fail") != 1 {
+		t.Errorf("Expected failed command to run exactly once; contents=%q err=%v", contents, err)
+	}
+
+	// A slow command should not block lookups of other options
+	if runtime.GOOS != "windows" {
+		cfg = ParseFakeCLI(t, cmd, "mycommand --upper='$(sleep 0.5 && echo done)' --visible=quick arg1")
+		done := make(chan string)
+		go func() {
+			done <- cfg.Get("upper")
+		}()
+		time.Sleep(100 * time.Millisecond)
+		start := time.Now()
+		if actual := cfg.Get("visible"); actual != "quick" {
+			t.Errorf("Expected %q, instead found %q", "quick", actual)
+		}
+		if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+			t.Errorf("Lookup of unrelated option blocked for %s while command was running", elapsed)
+		}
+		if actual := <-done; actual != "DONE" {
+			t.Errorf("Expected %q, instead found %q", "DONE", actual)
+		}
+	}
+
+	// Failures and timeouts are OptionTransformErrors
+	commands := []string{"$(exit 3)"}
+	if runtime.GOOS != "windows" {
+		commands = append(commands, "$(sleep 5)")
+	}
+	for _, command := range commands {
+		cfg := ParseFakeCLI(t, cmd, "mycommand --slow='"+command+"' arg1")
+		err := cfg.CheckValues()
+		if ote, ok := err.(OptionTransformError); !ok || ote.Name != "slow" {
+			t.Errorf("Expected OptionTransformError for %s, instead found %T %v", command, err, err)
+		}
+	}
+
+	// Values from sources other than the command-line, option files, and
+	// defaults are never executed
+	untrustedCounter := filepath.Join(dir, "untrustedcounter")
+	untrusted := "$(echo ran>>" + untrustedCounter + ")"
+	cfg = ParseFakeCLI(t, cmd, "mycommand arg1")
+	if err := cfg.ApplyJSON(`{"slow": "` + untrusted + `"}`); err != nil {
+		t.Fatalf("Unexpected error from ApplyJSON: %v", err)
+	}
+	as := NewAdminServer(ParseFakeCLI(t, cmd, "mycommand arg1"))
+	as.overrides.values["slow"] = untrusted
+	for _, cfg := range []*Config{cfg, ParseFakeCLI(t, cmd, "mycommand arg1", SimpleSource{"slow": untrusted}), as.cfg} {
+		err := cfg.CheckValues()
+		if ote, ok := err.(OptionTransformError); !ok || ote.Name != "slow" || !strings.Contains(ote.Error(), "not permitted") {
+			t.Errorf("Expected OptionTransformError for command from untrusted source, instead found %T %v", err, err)
+		}
+		if actual := cfg.GetRaw("slow"); actual != untrusted {
+			t.Errorf("Expected GetRaw to return original value, instead found %q", actual)
+		}
+	}
+	if _, err := os.Stat(untrustedCounter); !os.IsNotExist(err) {
+		t.Errorf("Expected command from untrusted source to not run, but it did; err=%v", err)
+	}
+
+	if _, ok := substitutionCommand("$( )"); ok {
+		t.Error("Expected empty command to not be substituted")
+	}
+}
@@ -0,0 +1,163 @@
+package mybase
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// closestMatch returns the candidate most similar to name, for use in "did you
+// mean" suggestions. Only candidates close enough to plausibly be a typo of
+// name are considered: the edit distance may be at most a third of name's
+// length, with a minimum of 1. Ties are broken alphabetically. Returns "" if
+// no candidate is close enough, or if name is itself a candidate.
+func closestMatch(name string, candidates []string) string {
+	maxDistance := len(name) / 3
+	if maxDistance < 1 {
+		maxDistance = 1
+	}
+	sorted := make([]string, len(candidates))
+	copy(sorted, candidates)
+	sort.Strings(sorted)
+	var best string
+	bestDistance := maxDistance + 1
+	for _, candidate := range sorted {
+		if candidate == name {
+			return ""
+		}
+		if d := editDistance(name, candidate); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the optimal string alignment distance between a and b:
+// the number of single-character insertions, deletions, substitutions, or
+// transpositions of adjacent characters needed to turn a into b.
+func editDistance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	// d[i][j] is the distance between the first i runes of s and first j of t
+	d := make([][]int, len(s)+1)
+	for i := range d {
+		d[i] = make([]int, len(t)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			d[i][j] = minInt(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				d[i][j] = minInt(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(s)][len(t)]
+}
+
+// minInt returns the smallest of the supplied ints.
+func minInt(first int, rest ...int) int {
+	for _, n := range rest {
+		if n < first {
+			first = n
+		}
+	}
+	return first
+}
+
+// optionSuggestion returns the name or alias of an option in options which
+// most closely resembles name, or "" if none is similar enough. Names of
+// boolean options are also considered with "skip-", "disable-", and "enable-"
+// prefixes, so that a typo in a negated boolean yields a suggestion in the
+// same form, e.g. "skip-foo" rather than "foo".
+func optionSuggestion(name string, options map[string]*Option) string {
+	candidates := make([]string, 0, len(options))
+	for key, opt := range options {
+		names := []string{key}
+		for _, alias := range opt.Aliases {
+			names = append(names, canonicalOptionName(alias))
+		}
+		for _, n := range names {
+			candidates = append(candidates, n)
+			if opt.Type == OptionTypeBool {
+				candidates = append(candidates, "skip-"+n, "disable-"+n, "enable-"+n)
+			}
+		}
+	}
+	return closestMatch(name, candidates)
+}
+
+// typedOptionName returns the option name from arg, a long option token from
+// the command-line without its leading dashes, in the form the user typed it:
+// in canonical form, without any value or "loose-" prefix, but retaining any
+// "skip-", "disable-", or "enable-" prefix.
+func typedOptionName(arg string) string {
+	if eq := strings.IndexByte(arg, '='); eq > -1 {
+		arg = arg[:eq]
+	}
+	return strings.TrimPrefix(canonicalOptionName(strings.TrimSpace(arg)), "loose-")
+}
+
+// optionPrefixMatch returns the sole option in options whose name or alias
+// begins with prefix. If several distinct options match, nil is returned along
+// with the sorted names of the matching options. If none match, nil and an
+// empty slice are returned.
+func optionPrefixMatch(prefix string, options map[string]*Option) (*Option, []string) {
+	matches := make(map[string]*Option)
+	for key, opt := range options {
+		if strings.HasPrefix(key, prefix) {
+			matches[opt.Name] = opt
+			continue
+		}
+		for _, alias := range opt.Aliases {
+			if strings.HasPrefix(canonicalOptionName(alias), prefix) {
+				matches[opt.Name] = opt
+				break
+			}
+		}
+	}
+	if len(matches) == 1 {
+		for _, opt := range matches {
+			return opt, nil
+		}
+	}
+	names := make([]string, 0, len(matches))
+	for name := range matches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return nil, names
+}
+
+// sectionSuggestion returns the name in allowed which most closely resembles
+// name, or "" if none is similar enough. Glob patterns in allowed are not
+// considered.
+func sectionSuggestion(name string, allowed []string) string {
+	candidates := make([]string, 0, len(allowed))
+	for _, candidate := range allowed {
+		if !isSectionPattern(candidate) {
+			candidates = append(candidates, candidate)
+		}
+	}
+	return closestMatch(name, candidates)
+}
+
+// unknownCommandProblem returns a description of an unknown subcommand name
+// supplied to cmd, including a suggestion of a similar subcommand name if any.
+func unknownCommandProblem(cmd *Command, name string) string {
+	candidates := make([]string, 0, len(cmd.SubCommands))
+	for subName := range cmd.SubCommands {
+		candidates = append(candidates, subName)
+	}
+	problem := fmt.Sprintf("Unknown command \"%s\"", name)
+	if suggestion := closestMatch(name, candidates); suggestion != "" {
+		problem += fmt.Sprintf("; did you mean \"%s\"?", suggestion)
+	}
+	return problem
+}
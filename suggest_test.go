@@ -0,0 +1,144 @@
+package mybase
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestEditDistance(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		expected int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"visible", "visible", 0},
+		{"vsible", "visible", 1},
+		{"tow", "two", 1},
+		{"kitten", "sitting", 3},
+		{"héllo", "hello", 1},
+	}
+	for _, c := range cases {
+		if actual := editDistance(c.a, c.b); actual != c.expected {
+			t.Errorf("Expected editDistance(%q, %q) to return %d, instead found %d", c.a, c.b, c.expected, actual)
+		}
+	}
+
+	candidates := []string{"one", "two", "help", "version"}
+	expected := map[string]string{
+		"tow":     "two",
+		"vresion": "version",
+		"hlep":    "help",
+		"on":      "one",
+		"three":   "",
+		"two":     "",
+		"x":       "",
+	}
+	for name, expect := range expected {
+		if actual := closestMatch(name, candidates); actual != expect {
+			t.Errorf("Expected closestMatch(%q) to return %q, instead found %q", name, expect, actual)
+		}
+	}
+}
+
+func TestSuggestions(t *testing.T) {
+	cmd := simpleCommand()
+	cmd.AddOption(StringOption("canonical", 0, "", "dummy description").AddAlias("old_name"))
+	assertSuggestion := func(err error, expected string) {
+		t.Helper()
+		if err == nil {
+			t.Errorf("Expected error containing %q, but err is nil", expected)
+		} else if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error containing %q, instead found %q", expected, err.Error())
+		}
+	}
+	_, err := ParseCLI(cmd, []string{"mycommand", "--vsible", "arg1"})
+	assertSuggestion(err, `Unknown option "vsible"; did you mean "visible"?`)
+	_, err = ParseCLI(cmd, []string{"mycommand", "--old-nmae", "arg1"})
+	assertSuggestion(err, `did you mean "old-name"?`)
+	_, err = ParseCLI(cmd, []string{"mycommand", "--skip-bool11", "arg1"})
+	assertSuggestion(err, `Unknown option "skip-bool11"; did you mean "skip-bool1"?`)
+	_, err = ParseCLI(cmd, []string{"mycommand", "--skp_bool1=0", "arg1"})
+	assertSuggestion(err, `Unknown option "skp-bool1"; did you mean "skip-bool1"?`)
+	_, err = ParseCLI(cmd, []string{"mycommand", "--disable-truthybol", "arg1"})
+	assertSuggestion(err, `did you mean "disable-truthybool"?`)
+	_, err = ParseCLI(cmd, []string{"mycommand", "--totally-different", "arg1"})
+	if err == nil || strings.Contains(err.Error(), "did you mean") {
+		t.Errorf("Expected error without suggestion, instead found %v", err)
+	}
+
+	cfg := ParseFakeCLI(t, cmd, "mycommand arg1")
+	file := NewFile("/tmp/fake.cnf")
+	file.contents, file.read = "hasshort=a\nhashort=b\n", true
+	err = file.Parse(cfg)
+	assertSuggestion(err, `/tmp/fake.cnf line 2: Unknown option "hashort"; did you mean "hasshort"?`)
+	var ond OptionNotDefinedError
+	if !errors.As(err, &ond) || ond.Suggestion != "hasshort" {
+		t.Errorf("Expected OptionNotDefinedError with Suggestion, instead found %#v", err)
+	}
+
+	// Unknown subcommands, including within the help subcommand at any depth
+	suite := simpleCommandSuite()
+	nested := NewCommandSuite("nested", "Nested suite", "")
+	nested.AddSubCommand(NewCommand("inner", "Inner command", "", func(*Config) error { return nil }))
+	suite.AddSubCommand(nested)
+	_, err = ParseCLI(suite, []string{"mycommand", "tow"})
+	assertSuggestion(err, `Unknown command "tow"; did you mean "two"?`)
+	cfg = ParseFakeCLI(t, suite, "mycommand help nested innr")
+	assertSuggestion(cfg.HandleCommand(), `Unknown command "innr"; did you mean "inner"?`)
+
+	defer func(stdout *os.File) { os.Stdout = stdout }(os.Stdout)
+	if os.Stdout, err = os.OpenFile(os.DevNull, os.O_WRONLY, 0); err != nil {
+		t.Fatalf("Unable to open %s: %v", os.DevNull, err)
+	}
+	defer os.Stdout.Close()
+	for _, commandLine := range []string{"mycommand help nested inner", "mycommand help", "mycommand nested --help"} {
+		if err := ParseFakeCLI(t, suite, commandLine).HandleCommand(); err != nil {
+			t.Errorf("Unexpected error from %q: %v", commandLine, err)
+		}
+	}
+}
+
+func TestOptionPrefixes(t *testing.T) {
+	cmd := NewCommand("mycommand", "summary", "description", nil)
+	cmd.AddOption(StringOption("defaults-file", 0, "", "dummy"))
+	cmd.AddOption(StringOption("defaults-extra-file", 0, "", "dummy"))
+	cmd.AddOption(BoolOption("dry-run", 0, false, "dummy").AddAlias("simulate"))
+	cmd.AddOption(StringOption("user", 'u', "", "dummy"))
+
+	// Prefixes are not accepted by default
+	if _, err := ParseCLI(cmd, []string{"mycommand", "--def=foo.cnf"}); err == nil {
+		t.Error("Expected error using prefix without OptionPrefixes, but err is nil")
+	}
+
+	cmd.OptionPrefixes = true
+	cfg := ParseFakeCLI(t, cmd, "mycommand --defaults-f=foo.cnf --sim --us root")
+	if cfg.Get("defaults-file") != "foo.cnf" || !cfg.GetBool("dry-run") || cfg.Get("user") != "root" {
+		t.Errorf("Unexpected values from prefixed options: %q %t %q", cfg.Get("defaults-file"), cfg.GetBool("dry-run"), cfg.Get("user"))
+	}
+	cfg = ParseFakeCLI(t, cmd, "mycommand --dry-run --skip-dry --loose-def=x")
+	if cfg.GetBool("dry-run") {
+		t.Error("Expected --skip-dry to disable dry-run, but it did not")
+	}
+	_, err := ParseCLI(cmd, []string{"mycommand", "--def=foo.cnf"})
+	if oae, ok := err.(OptionAmbiguousError); !ok || len(oae.Candidates) != 2 || oae.Candidates[0] != "defaults-extra-file" || oae.Candidates[1] != "defaults-file" {
+		t.Errorf("Unexpected error from ambiguous prefix: %v", err)
+	}
+
+	// Option files use the same logic
+	f, err := getParsedFile(cfg, false, "[foo]\ndefaults-e=bar.cnf\nsim\n")
+	if err != nil {
+		t.Fatalf("Unexpected error from getParsedFile: %v", err)
+	}
+	f.UseSection("foo")
+	if value, _ := f.OptionValue("defaults-extra-file"); value != "bar.cnf" {
+		t.Errorf("Unexpected value from prefixed option in file: %q", value)
+	}
+	AssertFileSetsOptions(t, f, "dry-run")
+	_, err = getParsedFile(cfg, false, "\n\ndefaults=bar.cnf\n")
+	if oae, ok := err.(OptionAmbiguousError); !ok || oae.Line() != 3 || len(oae.Candidates) != 2 {
+		t.Errorf("Unexpected error from ambiguous prefix in file: %v", err)
+	}
+}
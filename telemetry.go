@@ -0,0 +1,122 @@
+package mybase
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// Collector receives telemetry about command executions, for example to
+// export metrics to Prometheus or OpenTelemetry. See Command.SetCollector.
+type Collector interface {
+	CollectExecution(stats ExecutionStats)
+}
+
+// CollectorFunc adapts an ordinary function into a Collector.
+type CollectorFunc func(stats ExecutionStats)
+
+// CollectExecution satisfies the Collector interface.
+func (fn CollectorFunc) CollectExecution(stats ExecutionStats) {
+	fn(stats)
+}
+
+// ExecutionStats describes one execution of a Command's handler, as supplied
+// to a Collector.
+type ExecutionStats struct {
+	Command    *Command
+	Name       string        // full name of the command, such as "mycommand subcommand"
+	Duration   time.Duration // elapsed time of the handler, including pre-run and post-run hooks
+	Lookups    int           // number of option values looked up during execution, e.g. via Config.Get
+	Rebuilds   int           // number of times the Config re-resolved its option values during execution
+	Err        error         // error returned by the handler and hooks, if any
+	ErrorClass ErrorClass    // category of Err, suitable for use as a metric label
+	ExitCode   int           // process exit code corresponding to Err; see ExitCode
+}
+
+// ErrorClass is a coarse category of error, suitable for use as a metric
+// label. See ClassifyError.
+type ErrorClass string
+
+// Constants for error classes
+const (
+	ErrorClassNone     ErrorClass = ""         // no error
+	ErrorClassUsage    ErrorClass = "usage"    // invalid command-line
+	ErrorClassConfig   ErrorClass = "config"   // invalid configuration, such as a malformed option file
+	ErrorClassCanceled ErrorClass = "canceled" // context cancellation, for example by a signal
+	ErrorClassOther    ErrorClass = "error"    // any other error
+)
+
+// ClassifyError returns the ErrorClass of err, using the same categories as
+// ExitCode, but regardless of whether err implements ExitCoder.
+func ClassifyError(err error) ErrorClass {
+	switch {
+	case err == nil:
+		return ErrorClassNone
+	case errors.Is(err, context.Canceled):
+		return ErrorClassCanceled
+	case isUsageError(err):
+		return ErrorClassUsage
+	case isConfigError(err):
+		return ErrorClassConfig
+	}
+	return ErrorClassOther
+}
+
+// SetCollector configures cmd to report telemetry to collector after each
+// execution of the handler of cmd, or of any of its descendant subcommands
+// which do not have their own Collector. This permits uniform telemetry for an
+// application without wrapping every handler. Telemetry is not reported for
+// the same situations in which pre-run hooks do not run; see AddPreRunHook.
+// The collector is called synchronously, after all post-run hooks, so it
+// should return promptly.
+func (cmd *Command) SetCollector(collector Collector) {
+	cmd.collector = collector
+}
+
+// activeCollector returns the Collector of cmd, or of its closest ancestor with
+// one, or nil if there is none.
+func (cmd *Command) activeCollector() Collector {
+	for current := cmd; current != nil; current = current.ParentCommand {
+		if current.collector != nil {
+			return current.collector
+		}
+	}
+	return nil
+}
+
+// execCounters is a snapshot of a Config's telemetry counters.
+type execCounters struct {
+	lookups  uint32
+	rebuilds uint32
+}
+
+// counters returns the current values of cfg's telemetry counters. The
+// counters may wrap around, but differences between snapshots remain correct
+// as long as fewer than 2^32 events occur in between.
+func (cfg *Config) counters() execCounters {
+	return execCounters{
+		lookups:  atomic.LoadUint32(&cfg.lookupCount),
+		rebuilds: atomic.LoadUint32(&cfg.rebuildCount),
+	}
+}
+
+// collectExecution reports an execution of cmd's handler, which began at start
+// with cfg's counters at before, to the active Collector, if any.
+func (cmd *Command) collectExecution(cfg *Config, start time.Time, before execCounters, err error) {
+	collector := cmd.activeCollector()
+	if collector == nil {
+		return
+	}
+	after := cfg.counters()
+	collector.CollectExecution(ExecutionStats{
+		Command:    cmd,
+		Name:       cmd.fullName(),
+		Duration:   time.Since(start),
+		Lookups:    int(after.lookups - before.lookups),
+		Rebuilds:   int(after.rebuilds - before.rebuilds),
+		Err:        err,
+		ErrorClass: ClassifyError(err),
+		ExitCode:   ExitCode(err),
+	})
+}
@@ -0,0 +1,76 @@
+package mybase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCollector(t *testing.T) {
+	var collected []ExecutionStats
+	suite := simpleCommandSuite()
+	suite.SetCollector(CollectorFunc(func(stats ExecutionStats) {
+		collected = append(collected, stats)
+	}))
+	suite.SubCommands["one"].Handler = func(cfg *Config) error {
+		time.Sleep(time.Millisecond)
+		cfg.Get("visible")
+		cfg.GetBool("bool1")
+		return nil
+	}
+	suite.SubCommands["two"].Handler = func(cfg *Config) error {
+		return NewExitValue(3, "failed")
+	}
+
+	cfg := ParseFakeCLI(t, suite, "mycommand one")
+	if err := cfg.HandleCommand(); err != nil {
+		t.Fatalf("Unexpected error from HandleCommand: %v", err)
+	}
+	if len(collected) != 1 {
+		t.Fatalf("Expected 1 execution to be collected, instead found %d", len(collected))
+	}
+	stats := collected[0]
+	if stats.Name != "mycommand one" || stats.Command != suite.SubCommands["one"] || stats.Duration < time.Millisecond || stats.Lookups < 2 || stats.Err != nil || stats.ErrorClass != ErrorClassNone || stats.ExitCode != 0 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+
+	cfg = ParseFakeCLI(t, suite, "mycommand two")
+	cfg.HandleCommand()
+	if stats := collected[len(collected)-1]; stats.Name != "mycommand two" || stats.ErrorClass != ErrorClassOther || stats.ExitCode != 3 {
+		t.Errorf("Unexpected stats for failed execution: %+v", stats)
+	}
+
+	// Failed pre-run hooks are also collected, but builtin commands are not
+	suite.AddPreRunHook(func(ctx context.Context, cfg *Config) error {
+		return context.Canceled
+	})
+	cfg = ParseFakeCLI(t, suite, "mycommand one")
+	cfg.HandleCommand()
+	if stats := collected[len(collected)-1]; stats.ErrorClass != ErrorClassCanceled {
+		t.Errorf("Unexpected stats for failed pre-run hook: %+v", stats)
+	}
+	count := len(collected)
+	cfg = ParseFakeCLI(t, suite, "mycommand help")
+	cfg.HandleCommand()
+	if len(collected) != count {
+		t.Errorf("Expected builtin command not to be collected, instead found %+v", collected[count:])
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	cases := map[error]ErrorClass{
+		nil:             ErrorClassNone,
+		errors.New("x"): ErrorClassOther,
+		fmt.Errorf("wrapped: %w", context.Canceled): ErrorClassCanceled,
+		UsageError{Problem: "x"}:                    ErrorClassUsage,
+		OptionRequiredError{Name: "x"}:              ErrorClassConfig,
+		NewExitValue(5, "x"):                        ErrorClassOther,
+	}
+	for err, expected := range cases {
+		if actual := ClassifyError(err); actual != expected {
+			t.Errorf("Expected ClassifyError(%v) to return %q, instead found %q", err, expected, actual)
+		}
+	}
+}
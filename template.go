@@ -0,0 +1,97 @@
+package mybase
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// templateFuncs stores functions available to a template-type Option.
+type templateFuncs template.FuncMap
+
+// templateCache stores templates parsed by Config.GetTemplate, keyed by option
+// name. Only the template for each option's most recent value is retained.
+type templateCache map[string]cachedTemplate
+
+type cachedTemplate struct {
+	value string
+	tmpl  *template.Template
+}
+
+// TemplateOption creates a template-type Option. Values are parsed as a
+// text/template, for example "{{.Name}}\t{{.Size}}", which is useful for
+// options controlling the format of a program's output. Syntax errors are
+// reported when parsing the command-line or an option file, including the line
+// of the value containing the problem. Use Config.GetTemplate to obtain the
+// parsed template. Template options require a value by default.
+func TemplateOption(long string, short rune, defaultValue string, description string) *Option {
+	opt := StringOption(long, short, defaultValue, description)
+	opt.Type = OptionTypeTemplate
+	return opt
+}
+
+// SetTemplateFuncs makes the supplied functions available to values of a
+// template-type Option, in addition to text/template's predefined functions.
+// Since templates referencing unknown functions cannot be parsed, functions
+// must be set before any values are parsed. Panics if the Option is not of type
+// OptionTypeTemplate, or if its default value cannot be parsed, since these are
+// indicative of programmer error.
+func (opt *Option) SetTemplateFuncs(funcs template.FuncMap) *Option {
+	if opt.Type != OptionTypeTemplate {
+		panic(fmt.Errorf("Cannot set template functions of option %s: not a template option", opt.Name))
+	}
+	opt.funcs = templateFuncs(funcs)
+	if err := opt.checkValue(opt.Default); err != nil {
+		panic(fmt.Errorf("Cannot set template functions of option %s: default value %q is invalid: %v", opt.Name, opt.Default, err))
+	}
+	return opt
+}
+
+// parseTemplate parses value as a text/template, using any functions set via
+// SetTemplateFuncs. Parse errors are reworded to omit text/template's prefix,
+// leaving just the line of value containing the problem.
+func (opt *Option) parseTemplate(value string) (*template.Template, error) {
+	tmpl, err := template.New(opt.Name).Funcs(template.FuncMap(opt.funcs)).Parse(value)
+	if err != nil {
+		msg := strings.TrimPrefix(err.Error(), "template: "+opt.Name+":")
+		if colon := strings.Index(msg, ": "); colon > 0 && strings.Trim(msg[:colon], "0123456789") == "" {
+			return nil, fmt.Errorf("invalid template at line %s: %s", msg[:colon], msg[colon+2:])
+		}
+		return nil, fmt.Errorf("invalid template: %s", msg)
+	}
+	return tmpl, nil
+}
+
+// GetTemplate returns an option's value parsed as a *template.Template, using
+// any functions set via Option.SetTemplateFuncs. The template's name is the
+// option's name. If the option value isn't set (empty string), returns
+// nil,nil. If the value cannot be parsed, returns nil and an OptionValueError.
+// Parsed templates are cached until the option's value changes, so the
+// returned template is shared between callers and must not be modified, for
+// example via its Parse or Funcs methods; use its Clone method first if
+// modification is needed. Executing the template concurrently is safe. Panics
+// if the named option does not exist.
+func (cfg *Config) GetTemplate(name string) (*template.Template, error) {
+	value := cfg.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	opt := cfg.FindOption(name)
+	cfg.mu.RLock()
+	cached, ok := cfg.templates[opt.Name]
+	cfg.mu.RUnlock()
+	if ok && cached.value == value {
+		return cached.tmpl, nil
+	}
+	tmpl, err := opt.parseTemplate(value)
+	if err != nil {
+		return nil, cfg.valueError(name, value, err)
+	}
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	if cfg.templates == nil {
+		cfg.templates = make(templateCache)
+	}
+	cfg.templates[opt.Name] = cachedTemplate{value: value, tmpl: tmpl}
+	return tmpl, nil
+}
@@ -0,0 +1,66 @@
+package mybase
+
+import (
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestGetTemplate(t *testing.T) {
+	cmd := simpleCommand()
+	cmd.AddOption(TemplateOption("format", 0, "{{.Name}}", "dummy"))
+	cmd.AddOption(TemplateOption("header", 0, "", "dummy").SetTemplateFuncs(template.FuncMap{"upper": strings.ToUpper}))
+
+	render := func(tmpl *template.Template) string {
+		t.Helper()
+		var b strings.Builder
+		if err := tmpl.Execute(&b, struct{ Name string }{"widget"}); err != nil {
+			t.Fatalf("Unexpected error from Execute: %v", err)
+		}
+		return b.String()
+	}
+
+	cfg := ParseFakeCLI(t, cmd, "mycommand --header='Name: {{upper .Name}}' arg1")
+	tmpl, err := cfg.GetTemplate("format")
+	if err != nil || render(tmpl) != "widget" {
+		t.Errorf("Unexpected result from GetTemplate: %v", err)
+	}
+	if again, _ := cfg.GetTemplate("format"); again != tmpl {
+		t.Error("Expected GetTemplate to return cached template")
+	}
+	if tmpl, err := cfg.GetTemplate("header"); err != nil || render(tmpl) != "Name: WIDGET" {
+		t.Errorf("Unexpected result from GetTemplate: %v", err)
+	}
+
+	// Changed value is re-parsed; empty value returns nil
+	cfg = ParseFakeCLI(t, cmd, "mycommand --format= arg1")
+	if tmpl, err := cfg.GetTemplate("format"); tmpl != nil || err != nil {
+		t.Errorf("Expected nil template and error for empty value, instead found %v, %v", tmpl, err)
+	}
+
+	// Invalid syntax is rejected on the CLI, and in option files with the line of
+	// the value containing the problem
+	_, err = ParseCLI(cmd, strings.Fields("mycommand --format={{.Name arg1"))
+	if ove, ok := err.(OptionValueError); !ok || !strings.Contains(ove.Problem, "unclosed action") {
+		t.Errorf("Expected OptionValueError from CLI, instead found %v", err)
+	}
+	cfg = ParseFakeCLI(t, cmd, "mycommand arg1")
+	_, err = getParsedFile(cfg, false, "format=\"{{.Name}}\\n{{end}}\"\n")
+	if ove, ok := err.(OptionValueError); !ok || ove.Problem != "invalid template at line 2: unexpected {{end}}" {
+		t.Errorf("Expected OptionValueError with line number, instead found %v", err)
+	}
+	_, err = getParsedFile(cfg, false, "header={{lower .Name}}\n")
+	if err == nil || !strings.Contains(err.Error(), `function "lower" not defined`) {
+		t.Errorf("Expected error for undefined function, instead found %v", err)
+	}
+
+	if usage := cmd.Options()["format"].usageName(); usage != "format template" {
+		t.Errorf("Unexpected usage name %q", usage)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected SetTemplateFuncs to panic on non-template option, but it did not")
+		}
+	}()
+	StringOption("nope", 0, "", "dummy").SetTemplateFuncs(nil)
+}
@@ -2,6 +2,10 @@ package mybase
 
 import (
 	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"unicode"
 )
@@ -33,15 +37,54 @@ func AssertFileMissingOptions(t *testing.T, file *File, options ...string) {
 	}
 }
 
+// AssertUsageGolden verifies that the usage instructions for cmd, as rendered
+// by WriteUsage, match the contents of the golden file at goldenPath. Usage is
+// rendered without ANSI formatting at a fixed width of 80 columns, regardless
+// of the terminal. If the MYBASE_UPDATE_GOLDEN environment variable is set to
+// a non-empty value, the golden file is written instead of compared, which is
+// useful for creating it initially or after intentional changes to usage.
+func AssertUsageGolden(t *testing.T, cmd *Command, goldenPath string) {
+	t.Helper()
+	atomic.StoreInt32(&fixedTerminalWidth, 80)
+	defer atomic.StoreInt32(&fixedTerminalWidth, 0)
+	var b strings.Builder
+	if err := cmd.renderUsage(&b, false, false); err != nil {
+		t.Fatalf("Unable to render usage for %s: %s", cmd.Name, err)
+	}
+	actual := b.String()
+	if os.Getenv("MYBASE_UPDATE_GOLDEN") != "" {
+		if err := ioutil.WriteFile(goldenPath, []byte(actual), 0666); err != nil {
+			t.Fatalf("Unable to write golden file: %s", err)
+		}
+		return
+	}
+	expected, err := ioutil.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatalf("Unable to read golden file (set MYBASE_UPDATE_GOLDEN=1 to create it): %s", err)
+	}
+	if expectedStr := strings.Replace(string(expected), "\r\n", "\n", -1); actual != expectedStr {
+		t.Errorf("Usage for %s does not match %s\nExpected:\n%s\nActual:\n%s", cmd.Name, goldenPath, expectedStr, actual)
+	}
+}
+
 // SimpleSource is the most trivial possible implementation of the OptionValuer
 // interface: it just maps option name strings to option value strings.
 type SimpleSource map[string]string
 
 // OptionValue satisfies the OptionValuer interface, allowing SimpleSource to
-// be an option source for Config methods.
+// be an option source for Config methods. As with other sources, keys are
+// matched case-insensitively, with underscores and dashes considered
+// equivalent, so a key of "Skip_Networking" supplies option skip-networking.
 func (source SimpleSource) OptionValue(optionName string) (string, bool) {
-	val, ok := source[optionName]
-	return val, ok
+	if val, ok := source[optionName]; ok {
+		return val, ok
+	}
+	for key, val := range source {
+		if canonicalOptionName(key) == optionName {
+			return val, true
+		}
+	}
+	return "", false
 }
 
 // SimpleConfig returns a stub config based on a single map of key->value string
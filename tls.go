@@ -0,0 +1,158 @@
+package mybase
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// SSLModes lists the values permitted for the ssl-mode option added by
+// Command.AddTLSOptions, in order of increasing strictness. These match the
+// MySQL client programs' --ssl-mode option.
+var SSLModes = []string{"DISABLED", "PREFERRED", "REQUIRED", "VERIFY_CA", "VERIFY_IDENTITY"}
+
+// tlsVersions maps values permitted in the tls-version option to their
+// crypto/tls constants.
+var tlsVersions = map[string]uint16{
+	"tlsv1":   tls.VersionTLS10,
+	"tlsv1.0": tls.VersionTLS10,
+	"tlsv1.1": tls.VersionTLS11,
+	"tlsv1.2": tls.VersionTLS12,
+	"tlsv1.3": tls.VersionTLS13,
+}
+
+// AddTLSOptions adds options to cmd which control the use of TLS for
+// connections to a server, mirroring the MySQL client programs' options of the
+// same names:
+//
+//	--ssl-mode: one of SSLModes, case-insensitively
+//	--ssl-ca=path: PEM file of certificate authorities to trust
+//	--ssl-cert=path: PEM file of the client's certificate
+//	--ssl-key=path: PEM file of the client's private key
+//	--tls-version=list: comma-separated protocols permitted, e.g. "TLSv1.2,TLSv1.3"
+//
+// The options are added to the "global" group. See Config.TLSConfig to obtain
+// a corresponding *tls.Config, or Config.MySQLDSN to use them with
+// go-sql-driver/mysql.
+func (cmd *Command) AddTLSOptions() {
+	cmd.AddOptions("global",
+		EnumOption("ssl-mode", 0, "", SSLModes, "Security of the connection to the server"),
+		StringOption("ssl-ca", 0, "", "File containing the certificate authorities to trust, in PEM format"),
+		StringOption("ssl-cert", 0, "", "File containing the client certificate, in PEM format"),
+		StringOption("ssl-key", 0, "", "File containing the client private key, in PEM format"),
+		StringOption("tls-version", 0, "", "Comma-separated list of permitted TLS protocols, e.g. TLSv1.2,TLSv1.3"),
+	)
+}
+
+// TLSConfig returns a *tls.Config reflecting the values of the options added by
+// Command.AddTLSOptions, for connecting to the server named by the host
+// option. Options which the command does not have are treated as having no
+// value. The verification behavior depends on the normalized ssl-mode:
+//
+//   - DISABLED: TLS should not be used, so nil is returned.
+//   - PREFERRED or REQUIRED: the server's certificate is not verified. The
+//     caller decides whether a server without TLS support is acceptable.
+//   - VERIFY_CA: the server's certificate chain is verified against ssl-ca, or
+//     the system's roots if ssl-ca has no value, but the hostname is not.
+//   - VERIFY_IDENTITY: the certificate chain and hostname are both verified.
+//
+// If ssl-mode has no value, PREFERRED is used, matching the MySQL client
+// programs. Also like the client programs, if ssl-ca has a value, an ssl-mode
+// of REQUIRED or no value is treated as VERIFY_CA. An error is returned if an
+// option has an invalid value, or if the files named by ssl-ca, ssl-cert, or
+// ssl-key cannot be loaded.
+func (cfg *Config) TLSConfig() (*tls.Config, error) {
+	mode, err := cfg.sslMode()
+	if err != nil || mode == "DISABLED" {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{ServerName: cfg.optionValue("host")}
+	if ca := cfg.optionValue("ssl-ca"); ca != "" {
+		pem, err := ioutil.ReadFile(ca)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, cfg.valueError("ssl-ca", ca, errors.New("file does not contain any PEM-encoded certificates"))
+		}
+	}
+	if cert, key := cfg.optionValue("ssl-cert"), cfg.optionValue("ssl-key"); cert != "" || key != "" {
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{pair}
+	}
+	if value := cfg.optionValue("tls-version"); value != "" {
+		for _, name := range strings.Split(value, ",") {
+			version, ok := tlsVersions[strings.ToLower(strings.TrimSpace(name))]
+			if !ok {
+				return nil, cfg.valueError("tls-version", value, fmt.Errorf("unknown protocol %q", strings.TrimSpace(name)))
+			}
+			if tlsConfig.MinVersion == 0 || version < tlsConfig.MinVersion {
+				tlsConfig.MinVersion = version
+			}
+			if version > tlsConfig.MaxVersion {
+				tlsConfig.MaxVersion = version
+			}
+		}
+	}
+	switch mode {
+	case "PREFERRED", "REQUIRED":
+		tlsConfig.InsecureSkipVerify = true
+	case "VERIFY_CA":
+		// Verify the certificate chain, but not the hostname
+		tlsConfig.InsecureSkipVerify = true
+		roots := tlsConfig.RootCAs
+		tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyCertChain(rawCerts, roots)
+		}
+	}
+	return tlsConfig, nil
+}
+
+// sslMode returns the normalized value of the ssl-mode option, which is
+// PREFERRED if it has no value. If it is REQUIRED or has no value, but ssl-ca
+// has a value, VERIFY_CA is returned, matching the MySQL client programs.
+func (cfg *Config) sslMode() (string, error) {
+	value := cfg.optionValue("ssl-mode")
+	mode := strings.ToUpper(value)
+	switch mode {
+	case "", "PREFERRED", "REQUIRED":
+		if mode != "PREFERRED" && cfg.optionValue("ssl-ca") != "" {
+			return "VERIFY_CA", nil
+		} else if mode == "" {
+			return "PREFERRED", nil
+		}
+		return mode, nil
+	case "DISABLED", "VERIFY_CA", "VERIFY_IDENTITY":
+		return mode, nil
+	}
+	return "", cfg.valueError("ssl-mode", value, fmt.Errorf("must be one of %s", strings.Join(SSLModes, ", ")))
+}
+
+// verifyCertChain verifies a server's certificate chain against roots, without
+// checking the hostname. If roots is nil, the system roots are used.
+func verifyCertChain(rawCerts [][]byte, roots *x509.CertPool) error {
+	if len(rawCerts) == 0 {
+		return errors.New("server did not supply a certificate")
+	}
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for n, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		certs[n] = cert
+	}
+	opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(opts)
+	return err
+}
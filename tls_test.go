@@ -0,0 +1,75 @@
+package mybase
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTLSConfig(t *testing.T) {
+	cmd := simpleCommand()
+	cmd.AddOption(StringOption("host", 0, "", "Database hostname"))
+	cmd.AddTLSOptions()
+	getTLSConfig := func(commandLine string) (*tls.Config, error) {
+		t.Helper()
+		return ParseFakeCLI(t, cmd, commandLine).TLSConfig()
+	}
+
+	if tlsConfig, err := getTLSConfig("mycommand arg1 --ssl-mode=disabled"); tlsConfig != nil || err != nil {
+		t.Errorf("Expected nil config for DISABLED, instead found %+v, %v", tlsConfig, err)
+	}
+	for _, commandLine := range []string{"mycommand arg1", "mycommand arg1 --ssl-mode=preferred", "mycommand arg1 --ssl-mode=REQUIRED"} {
+		if tlsConfig, err := getTLSConfig(commandLine); err != nil || tlsConfig == nil || !tlsConfig.InsecureSkipVerify || tlsConfig.VerifyPeerCertificate != nil {
+			t.Errorf("Expected unverified config for %q, instead found %+v, %v", commandLine, tlsConfig, err)
+		}
+	}
+	tlsConfig, err := getTLSConfig("mycommand arg1 --host=db1 --ssl-mode=verify_identity --tls-version=TLSv1.3,TLSv1.2")
+	if err != nil || tlsConfig.InsecureSkipVerify || tlsConfig.ServerName != "db1" {
+		t.Errorf("Unexpected config for VERIFY_IDENTITY: %+v, %v", tlsConfig, err)
+	} else if tlsConfig.MinVersion != tls.VersionTLS12 || tlsConfig.MaxVersion != tls.VersionTLS13 {
+		t.Errorf("Unexpected versions %x-%x", tlsConfig.MinVersion, tlsConfig.MaxVersion)
+	}
+
+	// ssl-ca implies VERIFY_CA, unless a different mode is requested explicitly
+	dir, err := ioutil.TempDir("", "mybasetest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	caPath := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(caPath, testCertificatePEM(t), 0644); err != nil {
+		t.Fatalf("Unable to write %s: %v", caPath, err)
+	}
+	for _, commandLine := range []string{"mycommand arg1 --ssl-ca=" + caPath, "mycommand arg1 --ssl-mode=required --ssl-ca=" + caPath} {
+		tlsConfig, err := getTLSConfig(commandLine)
+		if err != nil || tlsConfig.RootCAs == nil || tlsConfig.VerifyPeerCertificate == nil {
+			t.Errorf("Expected CA-verifying config for %q, instead found %+v, %v", commandLine, tlsConfig, err)
+		} else if err := tlsConfig.VerifyPeerCertificate(nil, nil); err == nil {
+			t.Error("Expected verification to fail without a certificate, but err was nil")
+		}
+	}
+	if tlsConfig, err := getTLSConfig("mycommand arg1 --ssl-mode=verify_identity --ssl-ca=" + caPath); err != nil || tlsConfig.InsecureSkipVerify || tlsConfig.VerifyPeerCertificate != nil {
+		t.Errorf("Unexpected config for VERIFY_IDENTITY with ssl-ca: %+v, %v", tlsConfig, err)
+	}
+
+	// Invalid values are errors
+	notPEMPath := filepath.Join(dir, "notpem.txt")
+	if err := ioutil.WriteFile(notPEMPath, []byte("hello world\n"), 0644); err != nil {
+		t.Fatalf("Unable to write %s: %v", notPEMPath, err)
+	}
+	for _, commandLine := range []string{
+		"mycommand arg1 --tls-version=SSLv3",
+		"mycommand arg1 --ssl-ca=" + filepath.Join(dir, "missing.pem"),
+		"mycommand arg1 --ssl-ca=" + notPEMPath,
+		"mycommand arg1 --ssl-cert=" + caPath,
+	} {
+		if _, err := getTLSConfig(commandLine); err == nil {
+			t.Errorf("Expected error from TLSConfig for %q, but err was nil", commandLine)
+		}
+	}
+	if _, err := ParseCLI(cmd, []string{"mycommand", "arg1", "--ssl-mode=sometimes"}); err == nil {
+		t.Error("Expected error from ParseCLI for invalid ssl-mode, but err was nil")
+	}
+}
@@ -0,0 +1,118 @@
+package mybase
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// TraceEntry records a single call to Config.Get while tracing was enabled via
+// Config.StartTrace.
+type TraceEntry struct {
+	Option     string           // canonical name of the option or positional arg
+	Value      string           // value returned by Get
+	Source     string           // description of the source which supplied the value
+	Candidates []TraceCandidate // every source which supplies a value, from highest to lowest precedence, including the default value
+}
+
+// TraceCandidate describes one source's value for an option in a TraceEntry.
+type TraceCandidate struct {
+	Source string // description of the source
+	Value  string // value supplied by the source, prior to any transform
+}
+
+// String returns a human-readable, single-line description of the entry.
+func (te TraceEntry) String() string {
+	candidates := make([]string, len(te.Candidates))
+	for n, candidate := range te.Candidates {
+		candidates[n] = fmt.Sprintf("%s=%q", candidate.Source, candidate.Value)
+	}
+	return fmt.Sprintf("%s=%q from %s (candidates: %s)", te.Option, te.Value, te.Source, strings.Join(candidates, ", "))
+}
+
+// tracer stores TraceEntries for a Config which has tracing enabled.
+type tracer struct {
+	mu      sync.Mutex
+	entries []TraceEntry
+	stream  bool
+}
+
+// StartTrace enables tracing of cfg, causing each subsequent call to Get, as
+// well as getters built on Get such as GetBool or GetInt, to be recorded as a
+// TraceEntry. This is intended to help debug why an option has a particular
+// value, by showing every source's value alongside the one which won. If
+// stream is true, each entry is also sent to the package's Logger (see
+// SetLogger) at info level as it is recorded. Any entries from a previous
+// trace are discarded. Values of options marked with Option.Sensitive are
+// redacted in all entries.
+//
+// Tracing adds overhead to every Get call, and the recorded entries are
+// retained in memory until StopTrace is called, so tracing should not be left
+// enabled in long-running processes.
+func (cfg *Config) StartTrace(stream bool) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	cfg.tracer = &tracer{stream: stream}
+}
+
+// StopTrace disables tracing of cfg, and returns the entries recorded since
+// StartTrace was called, in the order in which they occurred. It returns nil if
+// tracing was not enabled.
+func (cfg *Config) StopTrace() []TraceEntry {
+	cfg.mu.Lock()
+	t := cfg.tracer
+	cfg.tracer = nil
+	cfg.mu.Unlock()
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.entries
+}
+
+// traceGet records a call to Get, which returned value for the option or
+// positional arg with the supplied name. The caller must NOT hold a lock on
+// cfg.mu.
+func (cfg *Config) traceGet(t *tracer, name string, value string) {
+	source := cfg.Source(name)
+	opt := cfg.FindOption(name)
+	var isArg bool
+	for _, arg := range cfg.CLI.Command.args {
+		if arg.Name == canonicalOptionName(name) {
+			// Positional args can only be supplied by the CLI, and shadow any normal
+			// option of the same name when supplied
+			_, isOption := cfg.CLI.OptionValue(arg.Name)
+			if isArg = opt == nil || (source == cfg.CLI && !isOption); isArg {
+				opt = arg
+			}
+		}
+	}
+	entry := TraceEntry{
+		Option: opt.Name,
+		Value:  opt.displayValue(value),
+		Source: explainOptionSource(opt, source),
+	}
+	if isArg {
+		entry.Candidates = []TraceCandidate{{Source: entry.Source, Value: entry.Value}}
+	} else {
+		cfg.mu.RLock()
+		allSources := cfg.orderedSources()
+		cfg.mu.RUnlock()
+		for n := len(allSources) - 1; n >= 0; n-- {
+			if candidate, ok := optionValueOrAlias(allSources[n], opt); ok {
+				entry.Candidates = append(entry.Candidates, TraceCandidate{
+					Source: explainOptionSource(opt, allSources[n]),
+					Value:  opt.displayValue(unquote(candidate)),
+				})
+			}
+		}
+	}
+
+	t.mu.Lock()
+	t.entries = append(t.entries, entry)
+	t.mu.Unlock()
+	if t.stream {
+		currentLogger().Info("Resolved option value", "option", entry.Option, "value", entry.Value, "source", entry.Source, "candidates", len(entry.Candidates))
+	}
+}
@@ -0,0 +1,55 @@
+package mybase
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	cmd := simpleCommand()
+	cmd.AddOption(StringOption("password", 0, "", "dummy description").Sensitive())
+	cfg := ParseFakeCLI(t, cmd, "mycommand --hasshort=cli --password=hunter2 arg1")
+	f, err := getParsedFile(cfg, false, "hasshort=file\nvisible=file\n")
+	if err != nil {
+		t.Fatalf("Unexpected error from getParsedFile: %v", err)
+	}
+	cfg.AddSource(f)
+
+	cfg.Get("visible") // not traced
+	if entries := cfg.StopTrace(); entries != nil {
+		t.Errorf("Expected StopTrace without StartTrace to return nil, instead found %v", entries)
+	}
+
+	logger := &recordingLogger{}
+	SetLogger(logger)
+	defer SetLogger(nil)
+	cfg.StartTrace(true)
+	cfg.Get("hasshort")
+	cfg.GetBool("bool1")
+	cfg.Get("password")
+	cfg.Get("required")
+	entries := cfg.StopTrace()
+	cfg.Get("visible") // not traced
+	if len(entries) != 4 {
+		t.Fatalf("Expected 4 trace entries, instead found %d: %v", len(entries), entries)
+	}
+
+	entry := entries[0]
+	if entry.Option != "hasshort" || entry.Value != "cli" || entry.Source != "command line" || len(entry.Candidates) != 3 {
+		t.Errorf("Unexpected trace entry: %+v", entry)
+	} else if entry.Candidates[1].Source != f.Path()+" line 1" || entry.Candidates[1].Value != "file" || entry.Candidates[2].Source != "default value" {
+		t.Errorf("Unexpected candidates: %+v", entry.Candidates)
+	}
+	if entry := entries[1]; entry.Option != "bool1" || entry.Source != "default value" || len(entry.Candidates) != 1 {
+		t.Errorf("Unexpected trace entry: %+v", entry)
+	}
+	if str := entries[2].String(); strings.Contains(str, "hunter2") || !strings.HasPrefix(str, "password=") {
+		t.Errorf("Unexpected trace entry string: %s", str)
+	}
+	if entry := entries[3]; entry.Option != "required" || entry.Value != "arg1" || len(entry.Candidates) != 1 {
+		t.Errorf("Unexpected trace entry: %+v", entry)
+	}
+	if !logger.contains("INFO: Resolved option value option=hasshort value=cli source=command line candidates=3") {
+		t.Errorf("Expected streamed trace entry, instead found %v", logger.messages)
+	}
+}
@@ -0,0 +1,192 @@
+package mybase
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// OptionTransform is a function which modifies an option's value when it is
+// resolved by a Config. The value supplied to the transform has already been
+// unquoted. See Option.SetTransform.
+type OptionTransform func(value string, ctx TransformContext) (string, error)
+
+// TransformContext describes where an option value came from, for use by an
+// OptionTransform.
+type TransformContext struct {
+	Option *Option
+	Source OptionValuer // Source which supplied the value; a *Command for default values
+	Dir    string       // Dir of the *File which supplied the value; empty string for other sources
+}
+
+// SetTransform associates a transform function with an Option. Whenever a
+// Config resolves the Option's value, the transform is applied once to the
+// value from whichever source supplied it, including the Option's default.
+// Config.Get and all getters built on it return the transformed value, while
+// Config.GetRaw returns the original value. Use ChainTransforms to apply
+// several transforms in sequence.
+func (opt *Option) SetTransform(transform OptionTransform) *Option {
+	opt.transform = transform
+	return opt
+}
+
+// ChainTransforms returns an OptionTransform which applies each of the
+// supplied transforms in order, stopping at the first error.
+func ChainTransforms(transforms ...OptionTransform) OptionTransform {
+	return func(value string, ctx TransformContext) (string, error) {
+		var err error
+		for _, transform := range transforms {
+			if value, err = transform(value, ctx); err != nil {
+				return value, err
+			}
+		}
+		return value, nil
+	}
+}
+
+// TransformExpandHome is an OptionTransform which expands a leading ~ to the
+// current user's home directory, or a leading ~username to the home directory
+// of the named user. Other values are returned unchanged.
+func TransformExpandHome(value string, ctx TransformContext) (string, error) {
+	if !strings.HasPrefix(value, "~") {
+		return value, nil
+	}
+	var userName, rest string
+	if pos := strings.IndexAny(value, `/\`); pos > -1 {
+		userName, rest = value[1:pos], value[pos:]
+	} else {
+		userName = value[1:]
+	}
+	var home string
+	if userName == "" {
+		var err error
+		if home, err = os.UserHomeDir(); err != nil {
+			return value, err
+		}
+	} else {
+		u, err := user.Lookup(userName)
+		if err != nil {
+			return value, err
+		}
+		home = u.HomeDir
+	}
+	return home + rest, nil
+}
+
+// TransformAbsolutePath is an OptionTransform which converts a relative path
+// to an absolute one. If the value came from an option file, relative paths
+// are interpreted relative to the directory containing that file; otherwise
+// they are interpreted relative to the working directory. Empty values are
+// returned unchanged.
+func TransformAbsolutePath(value string, ctx TransformContext) (string, error) {
+	if value == "" || filepath.IsAbs(value) {
+		return value, nil
+	} else if ctx.Dir != "" {
+		return filepath.Join(ctx.Dir, value), nil
+	}
+	return filepath.Abs(value)
+}
+
+// TransformLower is an OptionTransform which converts a value to lowercase.
+func TransformLower(value string, ctx TransformContext) (string, error) {
+	return strings.ToLower(value), nil
+}
+
+// OptionTransformError is an error returned by Config.CheckTransforms and
+// Config.CheckValues when an option's transform function fails.
+type OptionTransformError struct {
+	Name     string
+	Value    string
+	Source   string // description of the source which supplied the value
+	FilePath string // only set if the value came from an option file
+	Err      error  // error returned by the transform function
+}
+
+// Error satisfies golang's error interface.
+func (ote OptionTransformError) Error() string {
+	return fmt.Sprintf("%s: Invalid value %q for option %s: %v", ote.Source, ote.Value, ote.Name, ote.Err)
+}
+
+// Is returns true if target is ErrInvalidValue, permitting use of errors.Is.
+func (ote OptionTransformError) Is(target error) bool { return target == ErrInvalidValue }
+
+// Unwrap returns the error from the transform function.
+func (ote OptionTransformError) Unwrap() error { return ote.Err }
+
+// OptionName satisfies the ParseError interface.
+func (ote OptionTransformError) OptionName() string { return ote.Name }
+
+// Location satisfies the ParseError interface.
+func (ote OptionTransformError) Location() string { return location(ote.FilePath, ote.Source) }
+
+// Line satisfies the ParseError interface. It always returns 0, since option
+// values are not tracked by line.
+func (ote OptionTransformError) Line() int { return 0 }
+
+// CheckTransforms returns an error if any option's transform function failed
+// when resolving its value. If there are multiple such errors, they are
+// returned as ParseErrors, ordered by option name. See also CheckValues, which
+// additionally checks validators.
+func (cfg *Config) CheckTransforms() error {
+	defer cfg.flushPending()
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	cfg.resolveCommands()
+	if len(cfg.transformErrors) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(cfg.transformErrors))
+	for name := range cfg.transformErrors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) == 1 {
+		return cfg.transformErrors[names[0]]
+	}
+	problems := make(ParseErrors, len(names))
+	for n, name := range names {
+		problems[n] = cfg.transformErrors[name]
+	}
+	return problems
+}
+
+// describeSource returns a description of source for use in error messages,
+// along with the path of the file if source is a *File.
+func describeSource(source OptionValuer) (sourceName, filePath string) {
+	switch source := source.(type) {
+	case *File:
+		return source.Path(), source.Path()
+	case *Command:
+		return "default value", ""
+	default:
+		return fmt.Sprint(source), ""
+	}
+}
+
+// applyTransform runs opt's transform function, if any, on a value supplied by
+// source. The returned bool is false if opt has no transform.
+func applyTransform(opt *Option, value string, source OptionValuer) (string, bool, error) {
+	if opt.transform == nil {
+		return value, false, nil
+	}
+	ctx := TransformContext{Option: opt, Source: source}
+	if f, ok := source.(*File); ok {
+		ctx.Dir = f.Dir
+	}
+	sourceName, filePath := describeSource(source)
+	unquoted := unquote(value)
+	transformed, err := opt.transform(unquoted, ctx)
+	if err != nil {
+		return value, true, OptionTransformError{
+			Name:     opt.Name,
+			Value:    opt.displayValue(unquoted),
+			Source:   sourceName,
+			FilePath: filePath,
+			Err:      err,
+		}
+	}
+	return transformed, true, nil
+}
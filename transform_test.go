@@ -0,0 +1,74 @@
+package mybase
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOptionTransforms(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skipf("Unable to determine home directory: %v", err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Unable to determine working directory: %v", err)
+	}
+
+	cmd := NewCommand("mycommand", "summary", "description", nil)
+	cmd.AddOption(StringOption("schema", 0, "DefaultSchema", "dummy").SetTransform(TransformLower))
+	cmd.AddOption(StringOption("homepath", 0, "", "dummy").SetTransform(TransformExpandHome))
+	cmd.AddOption(StringOption("filepath", 0, "", "dummy").SetTransform(TransformAbsolutePath))
+	cmd.AddOption(StringOption("clipath", 0, "", "dummy").SetTransform(ChainTransforms(TransformExpandHome, TransformAbsolutePath)))
+	cmd.AddOption(StringOption("strict", 0, "", "dummy").SetTransform(func(value string, ctx TransformContext) (string, error) {
+		if value == "bad" {
+			return "", errors.New("value is bad")
+		}
+		return value, nil
+	}))
+
+	file := NewFile("/etc/myconfigs", "my.cnf")
+	file.contents = "homepath=\"~/foo\"\nfilepath=../bar\n"
+	file.read = true
+	cfg := ParseFakeCLI(t, cmd, "mycommand --clipath=baz")
+	if err := file.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error from Parse: %v", err)
+	}
+	cfg.AddSource(file)
+
+	expected := map[string]string{
+		"schema":   "defaultschema",
+		"homepath": filepath.Join(home, "foo"),
+		"filepath": filepath.Join("/etc", "bar"),
+		"clipath":  filepath.Join(wd, "baz"),
+	}
+	for name, value := range expected {
+		if actual := cfg.Get(name); actual != value {
+			t.Errorf("Expected Get(%q) to return %q, instead found %q", name, value, actual)
+		}
+	}
+	if actual := cfg.GetRaw("homepath"); actual != "\"~/foo\"" {
+		t.Errorf("Expected GetRaw to bypass transform, instead found %q", actual)
+	}
+	if err := cfg.CheckTransforms(); err != nil {
+		t.Errorf("Unexpected error from CheckTransforms: %v", err)
+	}
+
+	// Transform errors are attributed to the source of the value
+	cfg = ParseFakeCLI(t, cmd, "mycommand --strict=bad")
+	err = cfg.CheckTransforms()
+	if ote, ok := err.(OptionTransformError); !ok || ote.Name != "strict" || ote.Location() != "command line" {
+		t.Errorf("Expected OptionTransformError from command line, instead found %v", err)
+	} else if !strings.Contains(err.Error(), "value is bad") {
+		t.Errorf("Expected error message to include transform's error, instead found %q", err.Error())
+	}
+	if actual := cfg.Get("strict"); actual != "bad" {
+		t.Errorf("Expected Get to return untransformed value upon transform error, instead found %q", actual)
+	}
+	if err := cfg.HandleCommand(); err == nil {
+		t.Error("Expected HandleCommand to return transform error, but err is nil")
+	}
+}
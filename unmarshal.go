@@ -0,0 +1,164 @@
+package mybase
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// structTagName is the struct field tag key used by Config.Unmarshal to map
+// fields to option names.
+const structTagName = "mybase"
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	timeType     = reflect.TypeOf(time.Time{})
+)
+
+// Unmarshal populates the fields of the struct pointed to by dest using the
+// effective values of options. Each field to populate must have a struct tag
+// of the form `mybase:"option-name"`, naming an option or positional arg of
+// cfg's command. Fields tagged with "-", and untagged fields of types other
+// than struct, are left unchanged. Untagged struct fields are populated
+// recursively, which permits grouping related options into nested structs.
+//
+// Values are converted according to the field's type:
+//
+//   - string: the value as returned by Config.Get
+//   - bool: the value as returned by Config.GetBool
+//   - time.Duration: the value as returned by Config.GetDuration
+//   - time.Time: the value as returned by Config.GetTime
+//   - other signed or unsigned integer types: for options created by
+//     SizeOption, the value as returned by Config.GetSize; otherwise the value
+//     parsed as a base-10 integer, with an empty value meaning 0
+//   - float32 or float64: the value as returned by Config.GetFloat
+//   - []string: the value as returned by Config.GetMulti
+//   - map[string]string: the value as returned by Config.GetMap
+//
+// An error is returned if a value cannot be converted to its field's type; in
+// this case, some fields may have already been populated. Panics if dest is
+// not a non-nil pointer to a struct, if a tag names a nonexistent option, or if
+// a tagged field has an unsupported type, since these indicate programmer
+// error.
+func (cfg *Config) Unmarshal(dest interface{}) error {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		panic(fmt.Errorf("Unmarshal: destination must be a non-nil pointer to a struct, instead found %T", dest))
+	}
+	return cfg.unmarshalStruct(v.Elem())
+}
+
+// unmarshalStruct implements Unmarshal for a single struct value, recursing
+// into nested structs as needed.
+func (cfg *Config) unmarshalStruct(v reflect.Value) error {
+	t := v.Type()
+	for n := 0; n < t.NumField(); n++ {
+		field := t.Field(n)
+		if field.PkgPath != "" { // unexported
+			continue
+		}
+		name, tagged := field.Tag.Lookup(structTagName)
+		if name == "-" {
+			continue
+		} else if !tagged {
+			if field.Type.Kind() == reflect.Struct {
+				if err := cfg.unmarshalStruct(v.Field(n)); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if _, _, ok := cfg.lookup(name); !ok {
+			panic(fmt.Errorf("Unmarshal: field %s of %s is tagged with nonexistent option %s", field.Name, t, name))
+		}
+		if err := cfg.unmarshalField(v.Field(n), name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unmarshalField sets fieldValue to the value of the named option, converted
+// to the field's type.
+func (cfg *Config) unmarshalField(fieldValue reflect.Value, name string) error {
+	typ := fieldValue.Type()
+	if typ == durationType {
+		d, err := cfg.GetDuration(name)
+		if err == nil {
+			fieldValue.SetInt(int64(d))
+		}
+		return err
+	} else if typ == timeType {
+		t, err := cfg.GetTime(name)
+		if err == nil {
+			fieldValue.Set(reflect.ValueOf(t))
+		}
+		return err
+	}
+	isSize := cfg.FindOption(name).Type == OptionTypeSize
+	switch typ.Kind() {
+	case reflect.String:
+		fieldValue.SetString(cfg.Get(name))
+	case reflect.Bool:
+		fieldValue.SetBool(cfg.GetBool(name))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		var err error
+		if isSize {
+			var size uint64
+			size, err = cfg.GetSize(name)
+			n = int64(size)
+		} else if value := cfg.Get(name); value != "" {
+			if n, err = strconv.ParseInt(value, 10, typ.Bits()); err != nil {
+				err = cfg.valueError(name, value, err)
+			}
+		}
+		if err == nil && fieldValue.OverflowInt(n) {
+			err = cfg.valueError(name, cfg.Get(name), fmt.Errorf("value out of range for %s", typ))
+		}
+		if err != nil {
+			return err
+		}
+		fieldValue.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var n uint64
+		var err error
+		if isSize {
+			n, err = cfg.GetSize(name)
+		} else if value := cfg.Get(name); value != "" {
+			if n, err = strconv.ParseUint(value, 10, typ.Bits()); err != nil {
+				err = cfg.valueError(name, value, err)
+			}
+		}
+		if err == nil && fieldValue.OverflowUint(n) {
+			err = cfg.valueError(name, cfg.Get(name), fmt.Errorf("value out of range for %s", typ))
+		}
+		if err != nil {
+			return err
+		}
+		fieldValue.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := cfg.GetFloat(name)
+		if err == nil && fieldValue.OverflowFloat(f) {
+			err = cfg.valueError(name, cfg.Get(name), fmt.Errorf("value out of range for %s", typ))
+		}
+		if err != nil {
+			return err
+		}
+		fieldValue.SetFloat(f)
+	case reflect.Slice:
+		if !reflect.TypeOf([]string(nil)).ConvertibleTo(typ) {
+			panic(fmt.Errorf("Unmarshal: unsupported field type %s for option %s", typ, name))
+		}
+		fieldValue.Set(reflect.ValueOf(cfg.GetMulti(name)).Convert(typ))
+	case reflect.Map:
+		if !reflect.TypeOf(map[string]string(nil)).ConvertibleTo(typ) {
+			panic(fmt.Errorf("Unmarshal: unsupported field type %s for option %s", typ, name))
+		}
+		fieldValue.Set(reflect.ValueOf(cfg.GetMap(name)).Convert(typ))
+	default:
+		panic(fmt.Errorf("Unmarshal: unsupported field type %s for option %s", typ, name))
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package mybase
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestUnmarshal(t *testing.T) {
+	cmd := simpleCommand()
+	cmd.AddOptions("conn",
+		StringOption("host", 0, "localhost", "dummy description"),
+		StringOption("port", 0, "3306", "dummy description"),
+		DurationOption("timeout", 0, 5*time.Second, "dummy description"),
+	)
+	cmd.AddOption(SizeOption("max-size", 0, 1024*1024, "dummy description"))
+	cmd.AddOption(StringOption("ratio", 0, "", "dummy description"))
+	cmd.AddOption(MultiOption("table", 0, "", "dummy description"))
+	cmd.AddOption(MapOption("var", 0, "", "dummy description"))
+	cmd.AddOption(StringOption("tiny", 0, "", "dummy description"))
+
+	type connOptions struct {
+		Host    string        `mybase:"host"`
+		Port    uint16        `mybase:"port"`
+		Timeout time.Duration `mybase:"timeout"`
+	}
+	type options struct {
+		Required   string            `mybase:"required"`
+		Bool1      bool              `mybase:"bool1"`
+		Truthy     bool              `mybase:"truthybool"`
+		MaxSize    int64             `mybase:"max-size"`
+		Ratio      float64           `mybase:"ratio"`
+		Tables     []string          `mybase:"table"`
+		Vars       map[string]string `mybase:"var"`
+		Tiny       int8              `mybase:"tiny"`
+		Conn       connOptions
+		Skipped    string `mybase:"-"`
+		Untagged   string
+		unexported string `mybase:"visible"`
+	}
+
+	cfg := ParseFakeCLI(t, cmd, "mycommand -b --port=3307 --timeout=1m --ratio=0.5 --table=a,b --table=c --var=x=1 'arg one'")
+	opts := options{Skipped: "unchanged", Untagged: "unchanged"}
+	if err := cfg.Unmarshal(&opts); err != nil {
+		t.Fatalf("Unexpected error from Unmarshal: %v", err)
+	}
+	expected := options{
+		Required: "arg one",
+		Bool1:    true,
+		Truthy:   true,
+		MaxSize:  1024 * 1024,
+		Ratio:    0.5,
+		Tables:   []string{"a", "b", "c"},
+		Vars:     map[string]string{"x": "1"},
+		Conn:     connOptions{Host: "localhost", Port: 3307, Timeout: time.Minute},
+		Skipped:  "unchanged",
+		Untagged: "unchanged",
+	}
+	if !reflect.DeepEqual(opts, expected) {
+		t.Errorf("Unexpected result from Unmarshal:\n%+v\nexpected:\n%+v", opts, expected)
+	}
+
+	// Conversion errors
+	for _, commandLine := range []string{
+		"mycommand --port=70000 arg1",
+		"mycommand --port=-1 arg1",
+		"mycommand --tiny=200 arg1",
+		"mycommand --ratio=half arg1",
+	} {
+		cfg = ParseFakeCLI(t, cmd, commandLine)
+		if err := cfg.Unmarshal(&opts); err == nil {
+			t.Errorf("Expected error from Unmarshal with %q, but err is nil", commandLine)
+		}
+	}
+
+	// Programmer errors
+	for _, dest := range []interface{}{
+		opts,
+		(*options)(nil),
+		&struct {
+			Bogus string `mybase:"doesnt-exist"`
+		}{},
+		&struct {
+			Bad []int `mybase:"table"`
+		}{},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected Unmarshal to panic with dest %T, but it did not", dest)
+				}
+			}()
+			cfg.Unmarshal(dest)
+		}()
+	}
+}
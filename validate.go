@@ -0,0 +1,429 @@
+package mybase
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OptionValidator is a function which checks whether an option's value is
+// acceptable, returning a non-nil error if not. The value supplied to the
+// validator has already been unquoted, and transformed if the option has a
+// transform. See Option.SetValidator.
+type OptionValidator func(name, value string) error
+
+// SetValidator associates a validation function with an Option. Whenever a
+// Config resolves the Option's value, the validator is called once with the
+// value from whichever source supplied it, including the Option's default.
+// Validation failures do not prevent the value from being returned by Config
+// getters; use Config.CheckValues to obtain them. The validator is not called
+// if the Option's transform failed.
+func (opt *Option) SetValidator(validator OptionValidator) *Option {
+	opt.validator = validator
+	return opt
+}
+
+// OptionValidationError is an error returned by Config.CheckValues when an
+// option's validator rejects its value.
+type OptionValidationError struct {
+	Name     string
+	Value    string
+	Source   string // description of the source which supplied the value
+	FilePath string // only set if the value came from an option file
+	Err      error  // error returned by the validator
+}
+
+// Error satisfies golang's error interface.
+func (ove OptionValidationError) Error() string {
+	return fmt.Sprintf("%s: Invalid value %q for option %s: %v", ove.Source, ove.Value, ove.Name, ove.Err)
+}
+
+// Is returns true if target is ErrInvalidValue, permitting use of errors.Is.
+func (ove OptionValidationError) Is(target error) bool { return target == ErrInvalidValue }
+
+// Unwrap returns the error from the validator.
+func (ove OptionValidationError) Unwrap() error { return ove.Err }
+
+// OptionName satisfies the ParseError interface.
+func (ove OptionValidationError) OptionName() string { return ove.Name }
+
+// Location satisfies the ParseError interface.
+func (ove OptionValidationError) Location() string { return location(ove.FilePath, ove.Source) }
+
+// Line satisfies the ParseError interface. It always returns 0, since option
+// values are not tracked by line.
+func (ove OptionValidationError) Line() int { return 0 }
+
+// CheckValues returns an error if any option's transform function or
+// validator failed when resolving its value. If there are multiple such
+// errors, they are returned as ParseErrors, ordered by option name. See also
+// Validate, which includes these errors along with several other checks.
+func (cfg *Config) CheckValues() error {
+	defer cfg.flushPending()
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	cfg.resolveCommands()
+	errs := make(map[string]error, len(cfg.transformErrors)+len(cfg.validationErrors))
+	for name, err := range cfg.validationErrors {
+		errs[name] = err
+	}
+	for name, err := range cfg.transformErrors {
+		errs[name] = err
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(errs))
+	for name := range errs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) == 1 {
+		return errs[names[0]]
+	}
+	problems := make(ParseErrors, len(names))
+	for n, name := range names {
+		problems[n] = errs[name]
+	}
+	return problems
+}
+
+// Mandatory marks an Option as needing to be supplied by some source, such as
+// the command-line or an option file; otherwise Config.Validate returns an
+// OptionRequiredError. The Option's default value does not satisfy this
+// requirement. To require a positional arg, use the requireValue arg of
+// Command.AddArg instead. See also MandatoryOnCLI.
+func (opt *Option) Mandatory() *Option {
+	opt.mandatory = true
+	return opt
+}
+
+// MandatoryOnCLI marks an Option as needing to be supplied explicitly on the
+// command-line, regardless of any option files or other sources. This is
+// useful for flags confirming a destructive action, which should never be
+// enabled persistently, such as --allow-unsafe. ParseCLI returns a UsageError
+// listing every such Option which was not supplied, unless help or version
+// output was requested. This implies Mandatory.
+func (opt *Option) MandatoryOnCLI() *Option {
+	opt.mandatory = true
+	opt.mandatoryCLI = true
+	return opt
+}
+
+// checkMandatoryCLI returns a UsageError if any options of the command-line's
+// command are marked with MandatoryOnCLI but were not supplied.
+func (cli *CommandLine) checkMandatoryCLI() error {
+	var missing []string
+	for name, opt := range cli.Command.Options() {
+		if _, supplied := cli.OptionValues[name]; opt.mandatoryCLI && !supplied {
+			missing = append(missing, "--"+name)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+	problem := fmt.Sprintf("Option %s must be supplied on the command-line", missing[0])
+	if len(missing) > 1 {
+		problem = fmt.Sprintf("Options %s must be supplied on the command-line", strings.Join(missing, ", "))
+	}
+	return UsageError{Command: cli.Command, Problem: problem}
+}
+
+// OptionRequiredError is an error returned by Config.Validate when a Mandatory
+// option has not been supplied by any source.
+type OptionRequiredError struct {
+	Name string
+}
+
+// Error satisfies golang's error interface.
+func (ore OptionRequiredError) Error() string {
+	return fmt.Sprintf("Option %s is required, but was not supplied", ore.Name)
+}
+
+// Is returns true if target is ErrInvalidValue, permitting use of errors.Is.
+func (ore OptionRequiredError) Is(target error) bool { return target == ErrInvalidValue }
+
+// ValidationRule is a function which checks a relationship between the values
+// of multiple options, returning a non-nil error if the rule is violated. See
+// Command.AddValidationRule.
+type ValidationRule func(cfg *Config) error
+
+// AddValidationRule adds a rule which Config.Validate checks for cmd, as well as
+// for any subcommand of cmd, at any depth. Rules of ancestor commands are
+// checked before rules of descendant commands; rules of the same command are
+// checked in the order added. Validation rules are not checked for --help,
+// --help-all, or --version.
+func (cmd *Command) AddValidationRule(rule ValidationRule) {
+	cmd.validationRules = append(cmd.validationRules, rule)
+}
+
+// OptionRuleError is an error returned by the ValidationRules created by
+// RequireOneOf, RequireWith, and MutuallyExclusive.
+type OptionRuleError struct {
+	Names   []string // options involved in the rule
+	Problem string
+}
+
+// Error satisfies golang's error interface.
+func (ore OptionRuleError) Error() string {
+	return ore.Problem
+}
+
+// Is returns true if target is ErrInvalidValue, permitting use of errors.Is.
+func (ore OptionRuleError) Is(target error) bool { return target == ErrInvalidValue }
+
+// RequireOneOf returns a ValidationRule which is violated unless at least one
+// of the named options has been supplied by some source. For example,
+// RequireOneOf("socket", "host") requires either --socket or --host.
+func RequireOneOf(names ...string) ValidationRule {
+	return func(cfg *Config) error {
+		for _, name := range names {
+			if cfg.Supplied(name) {
+				return nil
+			}
+		}
+		var problem string
+		if len(names) == 2 {
+			problem = fmt.Sprintf("Either option %s or option %s must be supplied", names[0], names[1])
+		} else {
+			problem = fmt.Sprintf("At least one of these options must be supplied: %s", strings.Join(names, ", "))
+		}
+		return OptionRuleError{Names: names, Problem: problem}
+	}
+}
+
+// RequireWith returns a ValidationRule which is violated if the option name
+// has been changed from its default value, but any of the dependencies have
+// not been supplied by some source. For example, RequireWith("ssl-cert",
+// "ssl-key") requires --ssl-key whenever --ssl-cert is used.
+func RequireWith(name string, dependencies ...string) ValidationRule {
+	return func(cfg *Config) error {
+		if !cfg.Changed(name) {
+			return nil
+		}
+		var missing []string
+		for _, dep := range dependencies {
+			if !cfg.Supplied(dep) {
+				missing = append(missing, dep)
+			}
+		}
+		if len(missing) == 0 {
+			return nil
+		}
+		return OptionRuleError{
+			Names:   append([]string{name}, missing...),
+			Problem: fmt.Sprintf("Option %s also requires option %s", name, strings.Join(missing, ", ")),
+		}
+	}
+}
+
+// MutuallyExclusive returns a ValidationRule which is violated if more than
+// one of the named options has been changed from its default value.
+func MutuallyExclusive(names ...string) ValidationRule {
+	return func(cfg *Config) error {
+		var changed []string
+		for _, name := range names {
+			if cfg.Changed(name) {
+				changed = append(changed, name)
+			}
+		}
+		if len(changed) < 2 {
+			return nil
+		}
+		return OptionRuleError{
+			Names:   changed,
+			Problem: fmt.Sprintf("Options %s cannot be used together", strings.Join(changed, ", ")),
+		}
+	}
+}
+
+// Validate checks the values of all options, returning an error describing
+// every problem found, rather than just the first. This includes all errors
+// reported by CheckValues, followed by an OptionRequiredError for each
+// Mandatory option which was not supplied, followed by the error from each
+// violated ValidationRule of the command and its ancestors. If there are
+// multiple problems, they are returned as ParseErrors. HandleCommand calls
+// this automatically prior to running the command's handler.
+func (cfg *Config) Validate() error {
+	var problems ParseErrors
+	if err := cfg.CheckValues(); err != nil {
+		if errs, ok := err.(ParseErrors); ok {
+			problems = append(problems, errs...)
+		} else {
+			problems = append(problems, err)
+		}
+	}
+
+	options := cfg.CLI.Command.Options()
+	names := make([]string, 0, len(options))
+	for name, opt := range options {
+		if opt.mandatory {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !cfg.Supplied(name) {
+			problems = append(problems, OptionRequiredError{Name: name})
+		}
+	}
+
+	var chain []*Command // command and its ancestors, from root to command
+	for current := cfg.CLI.Command; current != nil; current = current.ParentCommand {
+		chain = append([]*Command{current}, chain...)
+	}
+	for _, current := range chain {
+		for _, rule := range current.validationRules {
+			if err := rule(cfg); err != nil {
+				problems = append(problems, err)
+			}
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	} else if len(problems) == 1 {
+		return problems[0]
+	}
+	return problems
+}
+
+// numericRange restricts the values of a numeric Option.
+type numericRange struct {
+	min, max float64
+	step     float64 // if non-zero, values must be min plus a multiple of step
+}
+
+// SetNumericRange restricts the Option's value to numbers between min and max,
+// inclusive. Whenever a Config resolves the Option's value, a value outside of
+// this range, or a value which is not a number, is treated as a validation
+// failure, in the same manner as SetValidator: Config.CheckValues and
+// Config.Validate return an OptionValidationError identifying the source of
+// the value. Additionally, Config.GetInt returns an OptionValueError for such
+// a value. An empty value is always permitted. For size options, min and max
+// are numbers of bytes, and for duration options, numbers of seconds; values
+// with unit suffixes are converted accordingly. Panics if min exceeds max,
+// since this is indicative of programmer error.
+func (opt *Option) SetNumericRange(min, max float64) *Option {
+	if min > max {
+		panic(fmt.Errorf("SetNumericRange: option %s has min %v greater than max %v", opt.Name, min, max))
+	}
+	if opt.numRange == nil {
+		opt.numRange = &numericRange{}
+	}
+	opt.numRange.min, opt.numRange.max = min, max
+	return opt
+}
+
+// SetNumericStep restricts the Option's value to multiples of step, offset by
+// the minimum set via SetNumericRange, if any. For example, with a range of 1
+// to 9 and a step of 2, only odd numbers are permitted. Violations are handled
+// in the same manner as SetNumericRange. Panics if step is not positive, since
+// this is indicative of programmer error.
+func (opt *Option) SetNumericStep(step float64) *Option {
+	if step <= 0 {
+		panic(fmt.Errorf("SetNumericStep: option %s has non-positive step %v", opt.Name, step))
+	}
+	if opt.numRange == nil {
+		opt.numRange = &numericRange{min: math.Inf(-1), max: math.Inf(1)}
+	}
+	opt.numRange.step = step
+	return opt
+}
+
+// checkRange returns an error if value violates the restrictions set via
+// SetNumericRange or SetNumericStep. The value should already be unquoted.
+func (opt *Option) checkRange(value string) error {
+	if opt == nil || opt.numRange == nil || value == "" {
+		return nil
+	}
+	r := opt.numRange
+	f, err := opt.rangeValue(strings.TrimSpace(value))
+	if err != nil {
+		return err
+	}
+	if f < r.min || f > r.max {
+		switch {
+		case math.IsInf(r.min, -1):
+			return fmt.Errorf("must be at most %s", opt.formatRangeValue(r.max))
+		case math.IsInf(r.max, 1):
+			return fmt.Errorf("must be at least %s", opt.formatRangeValue(r.min))
+		}
+		return fmt.Errorf("must be between %s and %s", opt.formatRangeValue(r.min), opt.formatRangeValue(r.max))
+	}
+	if r.step > 0 {
+		offset := f
+		if !math.IsInf(r.min, -1) {
+			offset -= r.min
+		}
+		if steps := offset / r.step; math.Abs(steps-math.Round(steps)) > 1e-9 {
+			return fmt.Errorf("must be a multiple of %s", opt.formatRangeValue(r.step))
+		}
+	}
+	return nil
+}
+
+// rangeValue converts value to a number for comparison against opt's numeric
+// range: a number of bytes for size options, a number of seconds for duration
+// options, or the number itself for options of other types.
+func (opt *Option) rangeValue(value string) (float64, error) {
+	switch opt.Type {
+	case OptionTypeSize:
+		size, err := parseSize(value)
+		return float64(size), err
+	case OptionTypeDuration:
+		d, err := parseDuration(value)
+		return d.Seconds(), err
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, errors.New("must be a number")
+	}
+	return f, nil
+}
+
+// formatRangeValue returns a finite number from opt's numeric range formatted
+// for use in error messages, in the same units as rangeValue.
+func (opt *Option) formatRangeValue(f float64) string {
+	switch opt.Type {
+	case OptionTypeSize:
+		if f >= 0 && f == math.Trunc(f) && f < math.MaxUint64 {
+			return formatSize(uint64(f))
+		}
+	case OptionTypeDuration:
+		if math.Abs(f) < math.MaxInt64/float64(time.Second) {
+			return time.Duration(f * float64(time.Second)).String()
+		}
+	}
+	return formatFloat(f)
+}
+
+// formatFloat returns f formatted without any unnecessary trailing zeros.
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// applyValidator checks a value supplied by source against opt's numeric range
+// and validator, if any. The value should already be unquoted and transformed.
+func applyValidator(opt *Option, value string, source OptionValuer) error {
+	err := opt.checkRange(value)
+	if err == nil && opt.validator != nil {
+		err = opt.validator(opt.Name, value)
+	}
+	if err != nil {
+		sourceName, filePath := describeSource(source)
+		return OptionValidationError{
+			Name:     opt.Name,
+			Value:    opt.displayValue(value),
+			Source:   sourceName,
+			FilePath: filePath,
+			Err:      err,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+package mybase
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestOptionValidators(t *testing.T) {
+	errNotPort := errors.New("must be a port number between 1 and 65535")
+	validatePort := func(name, value string) error {
+		if port, err := strconv.Atoi(value); err != nil || port < 1 || port > 65535 {
+			return errNotPort
+		}
+		return nil
+	}
+	var validatedNames []string
+	cmd := NewCommand("mycommand", "summary", "description", nil)
+	cmd.AddOption(StringOption("port", 0, "3306", "dummy").SetValidator(validatePort))
+	cmd.AddOption(StringOption("admin-port", 0, "0", "dummy").SetValidator(validatePort))
+	cmd.AddOption(StringOption("schema", 0, "", "dummy").SetTransform(TransformLower).SetValidator(func(name, value string) error {
+		validatedNames = append(validatedNames, name)
+		if value != "" && value != "prod" {
+			return errors.New("unknown schema")
+		}
+		return nil
+	}))
+	cmd.AddArg("target", "", false)
+	cmd.args[0].SetValidator(func(name, value string) error {
+		if value == "bad" {
+			return errors.New("invalid target")
+		}
+		return nil
+	})
+
+	// Default value of admin-port is invalid; port is invalid in file; schema is
+	// validated after its transform
+	file := NewFile("/etc/myconfigs", "my.cnf")
+	file.contents = "port=99999\n"
+	file.read = true
+	cfg := ParseFakeCLI(t, cmd, "mycommand --schema=PROD")
+	if err := file.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error from Parse: %v", err)
+	}
+	cfg.AddSource(file)
+	err := cfg.CheckValues()
+	problems, ok := err.(ParseErrors)
+	if !ok || len(problems) != 2 {
+		t.Fatalf("Expected CheckValues to return 2 errors, instead found %v", err)
+	}
+	if ove, ok := problems[0].(OptionValidationError); !ok || ove.Name != "admin-port" || ove.Location() != "default value" {
+		t.Errorf("Unexpected first error: %v", problems[0])
+	}
+	if ove, ok := problems[1].(OptionValidationError); !ok || ove.Name != "port" || ove.Location() != file.Path() || ove.Value != "99999" {
+		t.Errorf("Unexpected second error: %v", problems[1])
+	} else if !errors.Is(ove, errNotPort) {
+		t.Errorf("Expected error to wrap validator's error, instead found %v", ove)
+	}
+	if len(validatedNames) != 1 || validatedNames[0] != "schema" {
+		t.Errorf("Expected validator to receive option name, instead found %v", validatedNames)
+	}
+	if actual := cfg.Get("port"); actual != "99999" {
+		t.Errorf("Expected Get to return value despite validation error, instead found %q", actual)
+	}
+	if err := cfg.CheckTransforms(); err != nil {
+		t.Errorf("Expected CheckTransforms to ignore validation errors, instead found %v", err)
+	}
+
+	// Positional args are validated too, and errors prevent the handler from
+	// running
+	cfg = ParseFakeCLI(t, cmd, "mycommand --admin-port=33062 bad")
+	err = cfg.CheckValues()
+	if ove, ok := err.(OptionValidationError); !ok || ove.Name != "target" || ove.Location() != "command line" {
+		t.Errorf("Expected OptionValidationError for target, instead found %v", err)
+	}
+	if err := cfg.HandleCommand(); err == nil {
+		t.Error("Expected HandleCommand to return validation error, but err is nil")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	var handled bool
+	cmd := NewCommand("mycommand", "summary", "description", func(*Config) error {
+		handled = true
+		return nil
+	})
+	cmd.AddOption(StringOption("host", 'h', "", "dummy"))
+	cmd.AddOption(StringOption("socket", 'S', "", "dummy"))
+	cmd.AddOption(StringOption("ssl-cert", 0, "", "dummy"))
+	cmd.AddOption(StringOption("ssl-key", 0, "", "dummy"))
+	cmd.AddOption(StringOption("user", 'u', "", "dummy").Mandatory())
+	cmd.AddOption(StringOption("port", 0, "3306", "dummy").SetValidator(func(name, value string) error {
+		if value == "0" {
+			return errors.New("must not be 0")
+		}
+		return nil
+	}))
+	cmd.AddOption(BoolOption("dry-run", 0, false, "dummy"))
+	cmd.AddOption(BoolOption("force", 0, false, "dummy"))
+	cmd.AddValidationRule(RequireOneOf("socket", "host"))
+	cmd.AddValidationRule(RequireWith("ssl-cert", "ssl-key"))
+	cmd.AddValidationRule(MutuallyExclusive("dry-run", "force"))
+
+	// All problems should be reported at once, in a consistent order
+	cfg := ParseFakeCLI(t, cmd, "mycommand --ssl-cert=x.pem --dry-run --force --port=0")
+	err := cfg.Validate()
+	problems, ok := err.(ParseErrors)
+	if !ok || len(problems) != 5 {
+		t.Fatalf("Expected Validate to return 5 errors, instead found %v", err)
+	}
+	if _, ok := problems[0].(OptionValidationError); !ok {
+		t.Errorf("Expected first error to be OptionValidationError, instead found %T %v", problems[0], problems[0])
+	}
+	if ore, ok := problems[1].(OptionRequiredError); !ok || ore.Name != "user" {
+		t.Errorf("Unexpected second error: %T %v", problems[1], problems[1])
+	}
+	expectNames := [][]string{{"socket", "host"}, {"ssl-cert", "ssl-key"}, {"dry-run", "force"}}
+	for n, expected := range expectNames {
+		ore, ok := problems[n+2].(OptionRuleError)
+		if !ok || len(ore.Names) != len(expected) || ore.Names[0] != expected[0] || ore.Names[1] != expected[1] {
+			t.Errorf("Unexpected error[%d]: %T %v", n+2, problems[n+2], problems[n+2])
+		}
+	}
+	if err := cfg.HandleCommand(); err == nil || handled {
+		t.Errorf("Expected HandleCommand to return error without running handler; err=%v handled=%t", err, handled)
+	}
+
+	// A single problem is returned directly, and subcommands inherit rules
+	suite := NewCommandSuite("mysuite", "1.0", "description")
+	suite.AddSubCommand(cmd)
+	cfg = ParseFakeCLI(t, suite, "mysuite mycommand -u root --socket=/tmp/mysql.sock --ssl-cert=x.pem")
+	if err := cfg.Validate(); err == nil || err.Error() != "Option ssl-cert also requires option ssl-key" {
+		t.Errorf("Unexpected error from Validate: %v", err)
+	}
+
+	cfg = ParseFakeCLI(t, suite, "mysuite mycommand -u root -h localhost --dry-run")
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Unexpected error from Validate: %v", err)
+	}
+	if err := cfg.HandleCommand(); err != nil || !handled {
+		t.Errorf("Expected HandleCommand to run handler; err=%v handled=%t", err, handled)
+	}
+}
+
+func TestMandatoryOnCLI(t *testing.T) {
+	suite := simpleCommandSuite()
+	suite.AddOption(BoolOption("allow-unsafe", 0, false, "dummy").MandatoryOnCLI())
+	suite.SubCommands["one"].AddOption(StringOption("reason", 0, "", "dummy").MandatoryOnCLI())
+
+	// All missing options are reported at once, as a usage error
+	_, err := ParseCLI(suite, strings.Fields("mycommand one"))
+	if ue, ok := err.(UsageError); !ok || ue.Command != suite.SubCommands["one"] || ue.Problem != "Options --allow-unsafe, --reason must be supplied on the command-line" {
+		t.Errorf("Unexpected error from ParseCLI: %T %v", err, err)
+	} else if ExitCode(err) != ExitCodeUsage {
+		t.Errorf("Unexpected exit code %d", ExitCode(err))
+	}
+	_, err = ParseCLI(suite, strings.Fields("mycommand one --reason=x"))
+	if err == nil || err.Error() != "Option --allow-unsafe must be supplied on the command-line" {
+		t.Errorf("Unexpected error from ParseCLI: %v", err)
+	}
+
+	// Help, version, and built-in subcommands are exempt
+	for _, cliArgs := range []string{"mycommand", "mycommand one --help", "mycommand help one", "mycommand --version"} {
+		if _, err := ParseCLI(suite, strings.Fields(cliArgs)); err != nil {
+			t.Errorf("Unexpected error from ParseCLI for %q: %v", cliArgs, err)
+		}
+	}
+
+	// Any value on the CLI satisfies the requirement, even a negated bool, and
+	// also satisfies Validate
+	cfg := ParseFakeCLI(t, suite, "mycommand one --skip-allow-unsafe --reason=x")
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Unexpected error from Validate: %v", err)
+	}
+}
+
+func TestSetNumericRange(t *testing.T) {
+	cmd := simpleCommand()
+	cmd.AddOption(StringOption("port", 0, "3306", "dummy description").SetNumericRange(1, 65535))
+	cmd.AddOption(StringOption("ratio", 0, "", "dummy description").SetNumericRange(0, 1).SetNumericStep(0.25))
+	cmd.AddOption(StringOption("odd", 0, "1", "dummy description").SetNumericRange(1, 9).SetNumericStep(2))
+	cmd.AddOption(StringOption("even", 0, "", "dummy description").SetNumericStep(2))
+
+	cfg := ParseFakeCLI(t, cmd, "mycommand --ratio=0.75 --odd=7 --even=-4 arg1")
+	if err := cfg.CheckValues(); err != nil {
+		t.Errorf("Unexpected error from CheckValues: %v", err)
+	}
+	if port, err := cfg.GetInt("port"); port != 3306 || err != nil {
+		t.Errorf("Unexpected result from GetInt: %d, %v", port, err)
+	}
+
+	f, err := getParsedFile(cfg, false, "port=70000\nratio=0.3\nodd=4\neven=3\n")
+	if err != nil {
+		t.Fatalf("Unexpected error from getParsedFile: %v", err)
+	}
+	cfg = ParseFakeCLI(t, cmd, "mycommand arg1", f)
+	if port, err := cfg.GetInt("port"); port != 70000 {
+		t.Errorf("Expected GetInt to still return out-of-range value, instead found %d", port)
+	} else if ove, ok := err.(OptionValueError); !ok || ove.FilePath != f.Path() || !strings.Contains(ove.Problem, "between 1 and 65535") {
+		t.Errorf("Unexpected error from GetInt: %T %v", err, err)
+	}
+	if port := cfg.GetIntOrDefault("port"); port != 3306 {
+		t.Errorf("Expected GetIntOrDefault to return default for out-of-range value, instead found %d", port)
+	}
+	errs, ok := cfg.CheckValues().(ParseErrors)
+	if !ok || len(errs) != 4 {
+		t.Fatalf("Expected 4 errors from CheckValues, instead found %v", errs)
+	}
+	for n, expected := range []string{"multiple of 2", "multiple of 2", "between 1 and 65535", "multiple of 0.25"} {
+		if ove, ok := errs[n].(OptionValidationError); !ok || !strings.Contains(ove.Error(), expected) {
+			t.Errorf("Expected error %d to mention %q, instead found %v", n, expected, errs[n])
+		}
+	}
+
+	cfg = ParseFakeCLI(t, cmd, "mycommand --port=abc --odd=0 arg1")
+	if err := cfg.CheckValues(); err == nil || !strings.Contains(err.Error(), "must be a number") || !strings.Contains(err.Error(), "between 1 and 9") {
+		t.Errorf("Unexpected error from CheckValues: %v", err)
+	}
+
+	for _, fn := range []func(){
+		func() { StringOption("bad", 0, "", "").SetNumericRange(2, 1) },
+		func() { StringOption("bad", 0, "", "").SetNumericStep(0) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("Expected panic from invalid range, but there was none")
+				}
+			}()
+			fn()
+		}()
+	}
+}
+
+func TestSetNumericRangeUnits(t *testing.T) {
+	cmd := simpleCommand()
+	cmd.AddOption(SizeOption("buffer-size", 0, 0, "dummy description").SetNumericRange(1024, 1024*1024*1024))
+	cmd.AddOption(DurationOption("timeout", 0, 0, "dummy description").SetNumericRange(0.1, 3600))
+
+	cfg := ParseFakeCLI(t, cmd, "mycommand --buffer-size=0.5M --timeout=250ms arg1")
+	if size, err := cfg.GetBytes("buffer-size"); size != 512*1024 || err != nil {
+		t.Errorf("Unexpected result from GetBytes: %d, %v", size, err)
+	}
+	if ms, err := cfg.GetMillis("timeout"); ms != 250 || err != nil {
+		t.Errorf("Unexpected result from GetMillis: %d, %v", ms, err)
+	}
+	if err := cfg.CheckValues(); err != nil {
+		t.Errorf("Unexpected error from CheckValues: %v", err)
+	}
+
+	cfg = ParseFakeCLI(t, cmd, "mycommand --buffer-size=2G --timeout=2h arg1")
+	if _, err := cfg.GetBytes("buffer-size"); err == nil || !strings.Contains(err.Error(), "between 1K and 1G") {
+		t.Errorf("Unexpected error from GetBytes: %v", err)
+	}
+	if _, err := cfg.GetMillis("timeout"); err == nil || !strings.Contains(err.Error(), "between 100ms and 1h0m0s") {
+		t.Errorf("Unexpected error from GetMillis: %v", err)
+	}
+	if errs, ok := cfg.CheckValues().(ParseErrors); !ok || len(errs) != 2 {
+		t.Errorf("Expected 2 errors from CheckValues, instead found %v", cfg.CheckValues())
+	}
+
+	// GetMillis requires a whole number of milliseconds, and accepts a bare
+	// number of seconds
+	cfg = ParseFakeCLI(t, cmd, "mycommand --timeout=1500us arg1")
+	if _, err := cfg.GetMillis("timeout"); err == nil {
+		t.Error("Expected error from GetMillis for fractional milliseconds, but err was nil")
+	}
+	cfg = ParseFakeCLI(t, cmd, "mycommand --timeout=90 arg1")
+	if ms, err := cfg.GetMillis("timeout"); ms != 90000 || err != nil {
+		t.Errorf("Unexpected result from GetMillis: %d, %v", ms, err)
+	}
+}
@@ -0,0 +1,278 @@
+// Package vault provides an option source for github.com/skeema/mybase which
+// obtains option values from HashiCorp Vault.
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skeema/mybase"
+)
+
+// Source is an option source which obtains the values of specific options
+// from a secret stored in a HashiCorp Vault KV secrets engine. The Mapping
+// field determines which options are obtained from Vault, by mapping each
+// option name to a key within the secret; all other options are left to other
+// sources. For example, the password option may be obtained from Vault, while
+// all other options come from option files. Both versions 1 and 2 of the KV
+// secrets engine are supported; with version 2, Path must include the "data/"
+// segment, e.g. "secret/data/myapp".
+//
+// Vault requests are authenticated using either a token or AppRole. If
+// RoleIDOption is set and that option has a non-empty value, AppRole login is
+// performed using it along with the value of SecretIDOption, and the resulting
+// token is reused until its lease expires. Otherwise, the value of TokenOption
+// is used as the token, or the VAULT_TOKEN environment variable if TokenOption
+// is empty or its value is empty.
+type Source struct {
+	Address        string            // base URL of the Vault server, e.g. "https://vault.example.com:8200"
+	Path           string            // path of the secret, without the "/v1/" API prefix
+	Mapping        map[string]string // option name => key within the secret
+	Namespace      string            // Vault Enterprise namespace, if any
+	TokenOption    string            // name of an option whose value is a Vault token
+	RoleIDOption   string            // name of an option whose value is an AppRole role ID
+	SecretIDOption string            // name of an option whose value is an AppRole secret ID
+	AppRoleMount   string            // mount path of the AppRole auth method; "approle" if empty
+	CacheTTL       time.Duration     // duration after a successful fetch during which Load does not re-fetch
+	Timeout        time.Duration     // maximum duration of each request to Vault
+	Client         *http.Client      // HTTP client to use; a default client is used if nil
+	mu             sync.RWMutex      // protects all unexported fields below
+	values         map[string]string
+	fetchedAt      time.Time
+	version        uint64
+	token          string    // token obtained via AppRole login
+	tokenExpires   time.Time // expiration of token; zero value if it does not expire
+}
+
+// NewSource returns a Source which obtains the options in mapping from the
+// secret at path on the Vault server at address. The source must be loaded via
+// Load prior to use.
+func NewSource(address, path string, mapping map[string]string) *Source {
+	return &Source{
+		Address: address,
+		Path:    path,
+		Mapping: mapping,
+	}
+}
+
+// Load fetches the secret from Vault, using cfg to obtain the values of any
+// authentication options. If the secret was successfully fetched within the
+// past CacheTTL, Load does nothing.
+//
+// If the request fails and the secret was previously fetched successfully, the
+// previous values continue to be used, and a warning is reported via cfg.Warn.
+// Otherwise an error is returned. If the source was already added to a Config,
+// the Config automatically reflects any changed values after a successful
+// Load.
+//
+// Panics if Mapping refers to an option which does not exist in cfg, since
+// this is indicative of programmer error.
+func (src *Source) Load(cfg *mybase.Config) error {
+	keys := make(map[string]string, len(src.Mapping)) // canonical option name => key within the secret
+	for optionName, key := range src.Mapping {
+		opt := cfg.FindOption(optionName)
+		if opt == nil {
+			panic(fmt.Errorf("vault.Source %s maps nonexistent option %s", src.Path, optionName))
+		}
+		keys[opt.Name] = key
+	}
+	src.mu.RLock()
+	previous, fetchedAt := src.values, src.fetchedAt
+	src.mu.RUnlock()
+	if previous != nil && src.CacheTTL > 0 && time.Since(fetchedAt) < src.CacheTTL {
+		return nil
+	}
+
+	secret, err := src.fetch(cfg)
+	if err != nil {
+		if previous == nil {
+			return err
+		}
+		cfg.Warn("Unable to fetch options from %s, using previously-fetched values instead: %s", src, err)
+		return nil
+	}
+	values := make(map[string]string, len(src.Mapping))
+	for name, key := range keys {
+		if value, ok := secret[key]; ok {
+			values[name] = value
+		}
+	}
+
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	if src.values == nil || !reflect.DeepEqual(values, src.values) {
+		src.version++
+	}
+	src.values = values
+	src.fetchedAt = time.Now()
+	return nil
+}
+
+// OptionValue satisfies the OptionValuer interface. Only options in Mapping
+// whose key was present in the secret are considered to be set. Values are
+// quoted if necessary, so that they are returned verbatim by Config getters.
+// Panics if the source has not been loaded yet, since this is indicative of
+// programmer error.
+func (src *Source) OptionValue(optionName string) (string, bool) {
+	src.mu.RLock()
+	defer src.mu.RUnlock()
+	if src.values == nil {
+		panic(fmt.Errorf("Call to OptionValue(\"%s\") on vault.Source %s which has not been loaded", optionName, src.Path))
+	}
+	value, ok := src.values[optionName]
+	if !ok {
+		return "", false
+	}
+	return mybase.QuoteValue(value), true
+}
+
+// OptionValuesVersion satisfies the mybase.VersionedOptionValuer interface.
+// The version changes whenever a Load results in different values.
+func (src *Source) OptionValuesVersion() uint64 {
+	src.mu.RLock()
+	defer src.mu.RUnlock()
+	return src.version
+}
+
+func (src *Source) String() string {
+	return fmt.Sprintf("Vault secret %s", src.Path)
+}
+
+// fetch requests the secret from Vault, returning its keys and values. Values
+// which are not strings are converted to their JSON representation.
+func (src *Source) fetch(cfg *mybase.Config) (map[string]string, error) {
+	token, err := src.authToken(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := src.request(http.MethodGet, src.Path, token, nil, &resp); err != nil {
+		return nil, err
+	}
+	data := resp.Data
+	// KV version 2 nests the secret's data inside of another "data" object,
+	// alongside a "metadata" object
+	if _, hasMetadata := data["metadata"]; hasMetadata && data["data"] != nil {
+		var nested map[string]json.RawMessage
+		if err := json.Unmarshal(data["data"], &nested); err != nil {
+			return nil, fmt.Errorf("Unexpected response format from %s: %s", src, err)
+		}
+		data = nested
+	}
+	secret := make(map[string]string, len(data))
+	for key, raw := range data {
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			secret[key] = s
+		} else {
+			secret[key] = string(raw)
+		}
+	}
+	return secret, nil
+}
+
+// authToken returns the token to use for reading the secret, performing an
+// AppRole login if configured and no unexpired token from a previous login is
+// available.
+func (src *Source) authToken(cfg *mybase.Config) (string, error) {
+	var roleID string
+	if src.RoleIDOption != "" {
+		roleID = cfg.Get(src.RoleIDOption)
+	}
+	if roleID == "" {
+		if src.TokenOption != "" {
+			if token := cfg.Get(src.TokenOption); token != "" {
+				return token, nil
+			}
+		}
+		return os.Getenv("VAULT_TOKEN"), nil
+	}
+
+	src.mu.RLock()
+	token, expires := src.token, src.tokenExpires
+	src.mu.RUnlock()
+	if token != "" && (expires.IsZero() || time.Now().Before(expires)) {
+		return token, nil
+	}
+	var secretID string
+	if src.SecretIDOption != "" {
+		secretID = cfg.Get(src.SecretIDOption)
+	}
+	mount := src.AppRoleMount
+	if mount == "" {
+		mount = "approle"
+	}
+	body, _ := json.Marshal(map[string]string{"role_id": roleID, "secret_id": secretID})
+	var resp struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int64  `json:"lease_duration"`
+		} `json:"auth"`
+	}
+	if err := src.request(http.MethodPost, "auth/"+strings.Trim(mount, "/")+"/login", "", body, &resp); err != nil {
+		return "", err
+	} else if resp.Auth.ClientToken == "" {
+		return "", fmt.Errorf("AppRole login to %s did not return a token", src.Address)
+	}
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	src.token = resp.Auth.ClientToken
+	src.tokenExpires = time.Time{} // zero value means the token does not expire
+	if resp.Auth.LeaseDuration > 0 {
+		src.tokenExpires = time.Now().Add(time.Duration(resp.Auth.LeaseDuration) * time.Second)
+	}
+	return src.token, nil
+}
+
+// request sends a request to the Vault API, decoding the JSON response into
+// dest.
+func (src *Source) request(method, apiPath, token string, body []byte, dest interface{}) error {
+	ctx := context.Background()
+	if src.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, src.Timeout)
+		defer cancel()
+	}
+	url := strings.TrimRight(src.Address, "/") + "/v1/" + strings.TrimLeft(apiPath, "/")
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if src.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", src.Namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := src.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unexpected HTTP response status %s from %s", resp.Status, url)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(dest); err != nil {
+		return fmt.Errorf("Unexpected response format from %s: %s", url, err)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/skeema/mybase"
+)
+
+func TestSource(t *testing.T) {
+	var secretReads, logins int32
+	var unavailable int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&unavailable) > 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/auth/approle/login":
+			var body map[string]string
+			if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&body) != nil || body["role_id"] != "myrole" || body["secret_id"] != "mysecret" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			atomic.AddInt32(&logins, 1)
+			fmt.Fprint(w, `{"auth": {"client_token": "approle-token", "lease_duration": 3600}}`)
+		case "/v1/secret/data/myapp":
+			if token := r.Header.Get("X-Vault-Token"); token != "s3cr3t" && token != "approle-token" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			atomic.AddInt32(&secretReads, 1)
+			fmt.Fprint(w, `{"data": {"data": {"pw": "it's #1", "port": 3306}, "metadata": {"version": 3}}}`)
+		case "/v1/secret/v1app":
+			fmt.Fprint(w, `{"data": {"pw": "kv1"}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	cmd := mybase.NewCommand("mycommand", "summary", "description", nil)
+	cmd.AddOption(mybase.StringOption("visible", 0, "", "dummy"))
+	cmd.AddOption(mybase.StringOption("hasshort", 's', "", "dummy"))
+	cmd.AddArg("required", "", true)
+	cmd.AddOption(mybase.StringOption("token", 0, "", "dummy"))
+	cmd.AddOption(mybase.StringOption("role-id", 0, "", "dummy"))
+	cmd.AddOption(mybase.StringOption("secret-id", 0, "", "dummy"))
+	cmd.AddOption(mybase.StringOption("port", 0, "", "dummy"))
+	mapping := map[string]string{"visible": "pw", "port": "port", "hasshort": "missing"}
+	var warnings []string
+	newConfig := func(commandLine string) *mybase.Config {
+		cfg := mybase.ParseFakeCLI(t, cmd, commandLine)
+		cfg.WarningHandler = func(message string) {
+			warnings = append(warnings, message)
+		}
+		return cfg
+	}
+
+	// Token auth, with KV version 2 response format
+	cfg := newConfig("mycommand --token=s3cr3t arg1")
+	vs := NewSource(server.URL, "secret/data/myapp", mapping)
+	vs.TokenOption = "token"
+	vs.CacheTTL = time.Hour
+	if err := vs.Load(cfg); err != nil {
+		t.Fatalf("Unexpected error from Load: %v", err)
+	}
+	cfg.AddSource(vs)
+	if actual := cfg.Get("visible"); actual != "it's #1" {
+		t.Errorf("Unexpected value for visible: %q", actual)
+	}
+	if actual := cfg.GetIntOrDefault("port"); actual != 3306 {
+		t.Errorf("Unexpected value for port: %d", actual)
+	}
+	if cfg.Changed("hasshort") {
+		t.Error("Expected option with key missing from secret to be unchanged, but it was changed")
+	}
+	if source := cfg.Source("visible"); source != vs {
+		t.Errorf("Expected value to come from Source, instead found %v", source)
+	}
+
+	// Re-loading within CacheTTL should not fetch again
+	if err := vs.Load(cfg); err != nil || atomic.LoadInt32(&secretReads) != 1 {
+		t.Errorf("Expected Load within CacheTTL to not fetch; err=%v, reads=%d", err, secretReads)
+	}
+
+	// Once CacheTTL has passed, failures fall back to previous values with a
+	// warning
+	vs.CacheTTL = 0
+	version := vs.OptionValuesVersion()
+	if err := vs.Load(cfg); err != nil || atomic.LoadInt32(&secretReads) != 2 {
+		t.Errorf("Expected Load after CacheTTL to fetch; err=%v, reads=%d", err, secretReads)
+	} else if vs.OptionValuesVersion() != version {
+		t.Error("Expected version to be unchanged after fetching identical values")
+	}
+	atomic.StoreInt32(&unavailable, 1)
+	if err := vs.Load(cfg); err != nil {
+		t.Errorf("Unexpected error from Load with previous values: %v", err)
+	} else if len(warnings) != 1 || cfg.Get("visible") != "it's #1" {
+		t.Errorf("Expected fallback to previous values with a warning; warnings=%v", warnings)
+	}
+	if err := NewSource(server.URL, "secret/data/myapp", mapping).Load(cfg); err == nil {
+		t.Error("Expected error from Load without previous values, but err is nil")
+	}
+	atomic.StoreInt32(&unavailable, 0)
+
+	// Bad token is an error
+	vs = NewSource(server.URL, "secret/data/myapp", mapping)
+	if err := vs.Load(newConfig("mycommand --token=wrong arg1")); err == nil {
+		t.Error("Expected error from Load with wrong token, but err is nil")
+	}
+
+	// VAULT_TOKEN is used if TokenOption has no value
+	defer os.Setenv("VAULT_TOKEN", os.Getenv("VAULT_TOKEN"))
+	os.Setenv("VAULT_TOKEN", "s3cr3t")
+	vs = NewSource(server.URL, "secret/data/myapp", mapping)
+	vs.TokenOption = "token"
+	if err := vs.Load(newConfig("mycommand arg1")); err != nil {
+		t.Errorf("Unexpected error from Load using VAULT_TOKEN: %v", err)
+	}
+	os.Setenv("VAULT_TOKEN", "")
+
+	// AppRole auth, with token reused across loads
+	cfg = newConfig("mycommand --role-id=myrole --secret-id=mysecret arg1")
+	vs = NewSource(server.URL, "secret/data/myapp", mapping)
+	vs.RoleIDOption, vs.SecretIDOption = "role-id", "secret-id"
+	for n := 0; n < 2; n++ {
+		if err := vs.Load(cfg); err != nil {
+			t.Fatalf("Unexpected error from Load with AppRole: %v", err)
+		}
+	}
+	if atomic.LoadInt32(&logins) != 1 {
+		t.Errorf("Expected 1 AppRole login, instead found %d", logins)
+	}
+	cfg.AddSource(vs)
+	if actual := cfg.Get("visible"); actual != "it's #1" {
+		t.Errorf("Unexpected value for visible: %q", actual)
+	}
+
+	// KV version 1 response format
+	vs = NewSource(server.URL, "secret/v1app", map[string]string{"visible": "pw"})
+	if err := vs.Load(cfg); err != nil {
+		t.Fatalf("Unexpected error from Load: %v", err)
+	}
+	if value, ok := vs.OptionValue("visible"); !ok || value != "kv1" {
+		t.Errorf("Unexpected result from OptionValue: %q, %t", value, ok)
+	}
+
+	// Programmer errors
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Expected Load to panic with mapping of nonexistent option, but it did not")
+			}
+		}()
+		NewSource(server.URL, "secret/v1app", map[string]string{"doesnt-exist": "pw"}).Load(cfg)
+	}()
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Expected OptionValue to panic before Load, but it did not")
+			}
+		}()
+		NewSource(server.URL, "secret/v1app", mapping).OptionValue("visible")
+	}()
+}
@@ -0,0 +1,100 @@
+package mybase
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"text/template"
+)
+
+// DefaultVersionTemplate is the text of the template used for --version and
+// the version subcommand, unless a different one is supplied via
+// Command.SetVersionTemplate. The template is executed with a *VersionData
+// value.
+const DefaultVersionTemplate = `{{.Name}} version {{.Version}}{{if .Commit}} ({{.Commit}}{{if .Date}}, built {{.Date}}{{end}}){{else if .Date}} (built {{.Date}}){{end}}
+`
+
+// VersionJSONTemplate is a version template which outputs all VersionData
+// fields as a single line of JSON.
+const VersionJSONTemplate = "{{.JSON}}\n"
+
+var defaultVersionTemplate = template.Must(template.New("version").Parse(DefaultVersionTemplate))
+
+// VersionData contains the information supplied to a version template.
+type VersionData struct {
+	Name      string `json:"name"`             // Name of the top-level command
+	Version   string `json:"version"`          // Version string, or "not specified" if none
+	Commit    string `json:"commit,omitempty"` // Source control revision, if supplied to SetVersion
+	Date      string `json:"date,omitempty"`   // Build date, if supplied to SetVersion
+	GoVersion string `json:"go_version"`       // Go version used to build the program
+	OS        string `json:"os"`               // Operating system target of the build
+	Arch      string `json:"arch"`             // Architecture target of the build
+}
+
+// JSON returns the version data as a JSON object.
+func (vd *VersionData) JSON() string {
+	b, _ := json.Marshal(vd) // cannot fail for a struct of strings
+	return string(b)
+}
+
+// SetVersion sets the version string of a top-level command, along with
+// optional build metadata: the source control commit and build date. These are
+// typically supplied at build time via -ldflags "-X ...". The version replaces
+// any previously supplied via NewCommand or NewCommandSuite. Panics if cmd is
+// not a top-level command, since this indicates programmer error.
+func (cmd *Command) SetVersion(version, commit, date string) {
+	if cmd.ParentCommand != nil {
+		panic(fmt.Errorf("SetVersion: command %s is not a top-level command", cmd.Name))
+	}
+	cmd.Summary = version
+	cmd.versionCommit = commit
+	cmd.versionDate = date
+}
+
+// SetVersionTemplate supplies a custom template for rendering the output of
+// --version and the version subcommand. The template is executed with a
+// *VersionData value; use VersionJSONTemplate for machine-readable output.
+// Supplying nil reverts to DefaultVersionTemplate. Panics if cmd is not a
+// top-level command, since this indicates programmer error.
+func (cmd *Command) SetVersionTemplate(tmpl *template.Template) {
+	if cmd.ParentCommand != nil {
+		panic(fmt.Errorf("SetVersionTemplate: command %s is not a top-level command", cmd.Name))
+	}
+	cmd.versionTemplate = tmpl
+}
+
+// VersionData returns the information used to render version output for the
+// program that cmd belongs to.
+func (cmd *Command) VersionData() *VersionData {
+	root := cmd.Root()
+	version := root.Summary
+	if version == "" {
+		version = "not specified"
+	}
+	return &VersionData{
+		Name:      root.Name,
+		Version:   version,
+		Commit:    root.versionCommit,
+		Date:      root.versionDate,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+	}
+}
+
+// WriteVersion renders version information for the program that cmd belongs
+// to, using the template supplied to SetVersionTemplate or
+// DefaultVersionTemplate.
+func (cmd *Command) WriteVersion(w io.Writer) error {
+	tmpl := cmd.Root().versionTemplate
+	if tmpl == nil {
+		tmpl = defaultVersionTemplate
+	}
+	return tmpl.Execute(w, cmd.VersionData())
+}
+
+func versionHandler(cfg *Config) error {
+	return cfg.CLI.Command.WriteVersion(os.Stdout)
+}
@@ -0,0 +1,52 @@
+package mybase
+
+import (
+	"bytes"
+	"encoding/json"
+	"runtime"
+	"testing"
+	"text/template"
+)
+
+func TestWriteVersion(t *testing.T) {
+	suite := simpleCommandSuite()
+	var buf bytes.Buffer
+	if err := suite.SubCommands["one"].WriteVersion(&buf); err != nil {
+		t.Fatalf("Unexpected error from WriteVersion: %v", err)
+	} else if buf.String() != "mycommand version summary\n" {
+		t.Errorf("Unexpected output from WriteVersion: %q", buf.String())
+	}
+
+	suite.SetVersion("1.2.3", "abc1234", "2020-01-02")
+	buf.Reset()
+	suite.WriteVersion(&buf)
+	if buf.String() != "mycommand version 1.2.3 (abc1234, built 2020-01-02)\n" {
+		t.Errorf("Unexpected output from WriteVersion: %q", buf.String())
+	}
+	suite.SetVersion("", "", "2020-01-02")
+	buf.Reset()
+	suite.WriteVersion(&buf)
+	if buf.String() != "mycommand version not specified (built 2020-01-02)\n" {
+		t.Errorf("Unexpected output from WriteVersion: %q", buf.String())
+	}
+
+	suite.SetVersion("1.2.3", "abc1234", "")
+	suite.SetVersionTemplate(template.Must(template.New("version").Parse(VersionJSONTemplate)))
+	buf.Reset()
+	suite.SubCommands["two"].WriteVersion(&buf)
+	var vd VersionData
+	if err := json.Unmarshal(buf.Bytes(), &vd); err != nil {
+		t.Fatalf("Unexpected error unmarshaling JSON output %q: %v", buf.String(), err)
+	}
+	expected := VersionData{Name: "mycommand", Version: "1.2.3", Commit: "abc1234", GoVersion: runtime.Version(), OS: runtime.GOOS, Arch: runtime.GOARCH}
+	if vd != expected {
+		t.Errorf("Unexpected JSON output: %s", buf.String())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected SetVersion on a subcommand to panic, but it did not")
+		}
+	}()
+	suite.SubCommands["one"].SetVersion("1.0", "", "")
+}
@@ -0,0 +1,299 @@
+package mybase
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// watchFunc is a callback registered via Config.Watch.
+type watchFunc func(oldValue, newValue string)
+
+// Watch registers callback to be called whenever the value of the named option
+// changes as a result of ReloadFiles or WatchFiles. The callback receives the
+// option's value from before and after the reload, as returned by Config.Get.
+// Callbacks are run synchronously, in the goroutine which performed the
+// reload, after all changed files have been re-parsed. Multiple callbacks may
+// be registered for the same option.
+// Panics if the option does not exist, as this indicates programmer error.
+func (cfg *Config) Watch(optionName string, callback func(oldValue, newValue string)) {
+	opt := cfg.FindOption(optionName)
+	if opt == nil {
+		panic(fmt.Errorf("Assertion failed: called Watch on unknown option %s", optionName))
+	}
+	name := opt.Name
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	if cfg.watchers == nil {
+		cfg.watchers = make(map[string][]watchFunc)
+	}
+	cfg.watchers[name] = append(cfg.watchers[name], callback)
+}
+
+// ReloadFiles re-parses each *File source which has changed on disk since it
+// was last read, as determined by File.ChangedOnDisk. Afterwards, any
+// callbacks registered via Watch are called for options whose values changed.
+// If a file cannot be re-parsed, it retains its previous values, and an error
+// is returned after all other files have been processed; if multiple files
+// had problems, a ParseErrors value is returned.
+func (cfg *Config) ReloadFiles() error {
+	cfg.assertUnsealed("ReloadFiles")
+	cfg.mu.RLock()
+	var files []*File
+	for _, source := range cfg.sources {
+		if f, ok := source.(*File); ok {
+			files = append(files, f)
+		}
+	}
+	cfg.mu.RUnlock()
+	watched, oldValues := cfg.watchedValues()
+
+	var problems ParseErrors
+	var reloaded bool
+	for _, f := range files {
+		if !f.ChangedOnDisk() {
+			continue
+		}
+		if err := f.Reload(cfg); err != nil {
+			problems = append(problems, err)
+		} else {
+			reloaded = true
+		}
+	}
+
+	if reloaded {
+		cfg.MarkDirty()
+		cfg.runWatchers(watched, oldValues)
+	}
+
+	if len(problems) == 1 {
+		return problems[0]
+	} else if len(problems) > 1 {
+		return problems
+	}
+	return nil
+}
+
+// watchedValues returns the callbacks registered via Watch, keyed by option
+// name, along with the current value of each watched option.
+func (cfg *Config) watchedValues() (map[string][]watchFunc, map[string]string) {
+	cfg.mu.RLock()
+	watched := make(map[string][]watchFunc, len(cfg.watchers))
+	for name, callbacks := range cfg.watchers {
+		watched[name] = callbacks
+	}
+	cfg.mu.RUnlock()
+
+	oldValues := make(map[string]string, len(watched))
+	for name := range watched {
+		oldValues[name] = cfg.Get(name)
+	}
+	return watched, oldValues
+}
+
+// runWatchers calls the callbacks in watched for each option whose value now
+// differs from its value in oldValues. Any hooks registered via Option.OnSet
+// are run first, even if nothing is watched.
+func (cfg *Config) runWatchers(watched map[string][]watchFunc, oldValues map[string]string) {
+	cfg.refresh()
+	for name, callbacks := range watched {
+		if newValue := cfg.Get(name); newValue != oldValues[name] {
+			for _, callback := range callbacks {
+				callback(oldValues[name], newValue)
+			}
+		}
+	}
+}
+
+// WatchFiles calls ReloadFiles every interval, until ctx is done. Any error
+// from ReloadFiles is reported via Warn, rather than stopping the loop. This
+// method blocks, so typically it should be run in a separate goroutine.
+func (cfg *Config) WatchFiles(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := cfg.ReloadFiles(); err != nil {
+				cfg.Warn("Unable to reload option file: %s", err)
+			}
+		}
+	}
+}
+
+// OnReload registers callback to be called after each attempt by
+// ReloadOnSignal to reload cfg's option files. The callback receives nil if
+// the reload was applied, or the error which caused it to be rejected, in
+// which case cfg retains its previous values. Callbacks are run synchronously,
+// after any callbacks registered via Watch. Multiple callbacks may be
+// registered.
+func (cfg *Config) OnReload(callback func(err error)) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	cfg.reloadCallbacks = append(cfg.reloadCallbacks, callback)
+}
+
+// ReloadOnSignal calls ReloadValidated whenever the process receives SIGHUP,
+// until ctx is done, following the convention for reloading the
+// configuration of a daemon. Any error from ReloadValidated is reported via
+// Warn, as well as to callbacks registered via OnReload. This method blocks,
+// so typically it should be run in a separate goroutine. SIGHUP is not
+// delivered on Windows, so there this method simply waits for ctx to be done.
+func (cfg *Config) ReloadOnSignal(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			if err := cfg.ReloadValidated(); err != nil {
+				cfg.Warn("Unable to reload configuration: %s", err)
+			}
+		}
+	}
+}
+
+// ReloadValidated re-parses every *File source from disk, regardless of
+// whether it has changed, and applies the new contents only if all files parse
+// successfully and the resulting configuration passes Validate. Otherwise, the
+// error is returned and cfg retains its previous values, as if the reload had
+// never been attempted. The new configuration is checked using a temporary
+// copy of cfg, so other goroutines using cfg never observe values which fail
+// validation. Afterwards, any callbacks registered via Watch are called for
+// options whose values changed, followed by callbacks registered via
+// OnReload.
+func (cfg *Config) ReloadValidated() (err error) {
+	cfg.assertUnsealed("ReloadValidated")
+	cfg.mu.RLock()
+	var files []*File
+	for _, source := range cfg.sources {
+		if f, ok := source.(*File); ok {
+			files = append(files, f)
+		}
+	}
+	callbacks := cfg.reloadCallbacks
+	cfg.mu.RUnlock()
+	defer func() {
+		for _, callback := range callbacks {
+			callback(err)
+		}
+	}()
+
+	fresh := make(map[*File]*File, len(files))
+	for _, f := range files {
+		if fresh[f], err = f.reparse(cfg); err != nil {
+			return err
+		}
+	}
+	candidate := cfg.Clone()
+	candidate.noHooks = true
+	candidate.WarningHandler = func(string) {} // warnings are reported by cfg once applied
+	for n, source := range candidate.sources {
+		if f, ok := source.(*File); ok {
+			candidate.sources[n] = fresh[f]
+		}
+	}
+	if err = candidate.Validate(); err != nil {
+		return err
+	}
+
+	watched, oldValues := cfg.watchedValues()
+	for _, f := range files {
+		if err = f.adopt(fresh[f]); err != nil {
+			return err
+		}
+	}
+	cfg.MarkDirty()
+	cfg.runWatchers(watched, oldValues)
+	return nil
+}
+
+// ChangedOnDisk returns true if the file's modification time or size differs
+// from when it was last read from disk. It returns false if the file has not
+// been read from disk, or if it cannot currently be examined, for example due
+// to having been deleted.
+func (f *File) ChangedOnDisk() bool {
+	f.mu.RLock()
+	prev := f.diskStat
+	f.mu.RUnlock()
+	if prev == nil {
+		return false
+	}
+	fi, err := f.stat(f.Path())
+	if err != nil {
+		return false
+	}
+	return !fi.ModTime().Equal(prev.ModTime()) || fi.Size() != prev.Size()
+}
+
+// Reload re-parses the file from disk, replacing its sections with the newly
+// parsed ones. Any sections previously selected via UseSection remain
+// selected, if they still exist. If the file cannot be parsed, an error is
+// returned and the file retains its previous sections, although ChangedOnDisk
+// will not report the failed version as a change. Modifications made via
+// SetOptionValue, UnsetOptionValue, or RenameSection since the last Write are
+// discarded.
+// After a successful Reload, call cfg.MarkDirty if the file has already been
+// added to cfg as a source; Config.ReloadFiles handles this automatically.
+func (f *File) Reload(cfg *Config) error {
+	fresh, err := f.reparse(cfg)
+	if err != nil {
+		// Track the failed version, so that it is not retried until it changes again
+		f.mu.Lock()
+		if fresh.diskStat != nil {
+			f.diskStat = fresh.diskStat
+		}
+		f.mu.Unlock()
+		return err
+	}
+	return f.adopt(fresh)
+}
+
+// reparse returns a new File with the same path and settings as f, parsed
+// from disk. f itself is not modified. If an error is returned, the new File
+// is still returned, for access to its diskStat.
+func (f *File) reparse(cfg *Config) (*File, error) {
+	f.mu.RLock()
+	fresh := NewFile(f.Path())
+	f.copySettings(fresh)
+	f.mu.RUnlock()
+	return fresh, fresh.Parse(cfg)
+}
+
+// adopt replaces f's sections and contents with those of fresh, which must have
+// been returned by f.reparse without error.
+func (f *File) adopt(fresh *File) error {
+	f.mu.Lock()
+	defer f.unlock()
+	f.assertUnsealed("Reload")
+	selected := make([]string, 0, len(f.selected))
+	for _, name := range f.selected {
+		if _, ok := fresh.sectionIndex[name]; ok {
+			selected = append(selected, name)
+		}
+	}
+	f.sections = fresh.sections
+	f.blocks = fresh.blocks
+	f.sectionIndex = fresh.sectionIndex
+	f.includes = fresh.includes
+	f.read = fresh.read
+	f.parsed = fresh.parsed
+	f.contents = fresh.contents
+	f.crlf = fresh.crlf
+	f.selected = selected
+	f.unknownLines = fresh.unknownLines
+	f.problems = fresh.problems
+	f.edited = nil
+	f.renamed = nil
+	f.diskStat = fresh.diskStat
+	f.pending, f.pendingCfg = fresh.pending, fresh.pendingCfg
+	f.bumpGeneration()
+	return f.loadPending(f.selected)
+}
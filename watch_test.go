@@ -0,0 +1,359 @@
+package mybase
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConfigReloadFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mybasetest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "mybase.cnf")
+	writeAndTouch := func(contents string, age time.Duration) {
+		t.Helper()
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatalf("Unable to write file: %v", err)
+		}
+		// Explicitly set mtime, to avoid relying on filesystem timestamp granularity
+		mtime := time.Now().Add(-age)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatalf("Unable to set mtime: %v", err)
+		}
+	}
+	writeAndTouch("visible=hello\n[foo]\nhidden=abc\n", time.Hour)
+
+	cfg := ParseFakeCLI(t, simpleCommand(), "mycommand arg1")
+	file := NewFile(path)
+	if err := file.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error parsing file: %v", err)
+	}
+	file.UseSection("foo")
+	cfg.AddSource(file)
+	if file.ChangedOnDisk() {
+		t.Error("Expected ChangedOnDisk to return false prior to any change")
+	}
+
+	var changes []string
+	cfg.Watch("visible", func(oldValue, newValue string) {
+		changes = append(changes, "visible:"+oldValue+"->"+newValue)
+	})
+	cfg.Watch("hidden", func(oldValue, newValue string) {
+		changes = append(changes, "hidden:"+oldValue+"->"+newValue)
+	})
+	cfg.Watch("hasshort", func(oldValue, newValue string) {
+		changes = append(changes, "hasshort:"+oldValue+"->"+newValue)
+	})
+
+	// No changes on disk: callbacks should not be called
+	if err := cfg.ReloadFiles(); err != nil || len(changes) > 0 {
+		t.Fatalf("Unexpected result from ReloadFiles: err=%v changes=%v", err, changes)
+	}
+
+	// Change the value of visible, and remove hidden
+	writeAndTouch("visible=goodbye\n[foo]\n", time.Minute)
+	if !file.ChangedOnDisk() {
+		t.Error("Expected ChangedOnDisk to return true after change")
+	}
+	if err := cfg.ReloadFiles(); err != nil {
+		t.Fatalf("Unexpected error from ReloadFiles: %v", err)
+	}
+	if len(changes) != 2 {
+		t.Errorf("Unexpected changes: %v", changes)
+	}
+	for _, expected := range []string{"visible:hello->goodbye", "hidden:abc->somedefault"} {
+		var found bool
+		for _, change := range changes {
+			found = found || change == expected
+		}
+		if !found {
+			t.Errorf("Expected to find change %q, but did not; changes=%v", expected, changes)
+		}
+	}
+	if cfg.Get("visible") != "goodbye" || file.ChangedOnDisk() {
+		t.Errorf("Unexpected state after reload: visible=%q changed=%t", cfg.Get("visible"), file.ChangedOnDisk())
+	}
+
+	// Invalid file contents: file should retain previous values
+	changes = nil
+	writeAndTouch("visible=whatever\ndoesntexist=1\n", 30*time.Second)
+	if err := cfg.ReloadFiles(); err == nil {
+		t.Error("Expected error from ReloadFiles, but err is nil")
+	}
+	if cfg.Get("visible") != "goodbye" || len(changes) > 0 {
+		t.Errorf("Unexpected state after failed reload: visible=%q changes=%v", cfg.Get("visible"), changes)
+	}
+	if file.ChangedOnDisk() {
+		t.Error("Expected ChangedOnDisk to return false after failed reload")
+	}
+
+	// WatchFiles should pick up changes, and stop when ctx is done
+	writeAndTouch("visible=again\n", 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	cfg.WatchFiles(ctx, 10*time.Millisecond)
+	if len(changes) != 1 || changes[0] != "visible:goodbye->again" {
+		t.Errorf("Unexpected changes from WatchFiles: %v", changes)
+	}
+}
+
+func TestConfigReloadValidated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mybasetest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "mybase.cnf")
+	write := func(contents string) {
+		t.Helper()
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatalf("Unable to write file: %v", err)
+		}
+	}
+	write("visible=hello\n")
+
+	var hookValues []string
+	cmd := simpleCommand()
+	cmd.AddOption(StringOption("level", 0, "", "dummy description").OnSet(func(cfg *Config, change OptionChange) {
+		hookValues = append(hookValues, change.NewValue)
+	}))
+	cmd.AddValidationRule(func(cfg *Config) error {
+		if cfg.Get("visible") == "bad" {
+			return errors.New("visible must not be bad")
+		}
+		return nil
+	})
+	cfg := ParseFakeCLI(t, cmd, "mycommand arg1")
+	file := NewFile(path)
+	if err := file.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error parsing file: %v", err)
+	}
+	cfg.AddSource(file)
+	cfg.Get("level")
+
+	var changes []string
+	var results []error
+	cfg.Watch("visible", func(oldValue, newValue string) {
+		changes = append(changes, oldValue+"->"+newValue)
+	})
+	cfg.OnReload(func(err error) {
+		results = append(results, err)
+	})
+
+	// A valid change is applied, even if the file's mtime and size are unchanged
+	write("visible=howdy\nlevel=1\n")
+	if err := cfg.ReloadValidated(); err != nil {
+		t.Fatalf("Unexpected error from ReloadValidated: %v", err)
+	}
+	if cfg.Get("visible") != "howdy" || len(changes) != 1 || len(results) != 1 || results[0] != nil || len(hookValues) != 1 {
+		t.Errorf("Unexpected state after reload: visible=%q changes=%v results=%v hooks=%v", cfg.Get("visible"), changes, results, hookValues)
+	}
+
+	// Changes failing validation or parsing are rejected, without running hooks
+	// or watchers
+	for _, contents := range []string{"visible=bad\nlevel=2\n", "visible=x\nlevel=2\ndoesntexist=1\n"} {
+		changes, results, hookValues = nil, nil, nil
+		write(contents)
+		if err := cfg.ReloadValidated(); err == nil {
+			t.Errorf("Expected error from ReloadValidated for contents %q, but err is nil", contents)
+		}
+		if cfg.Get("visible") != "howdy" || cfg.Get("level") != "1" || len(changes) > 0 || len(hookValues) > 0 {
+			t.Errorf("Unexpected state after rejected reload: visible=%q changes=%v hooks=%v", cfg.Get("visible"), changes, hookValues)
+		}
+		if len(results) != 1 || results[0] == nil {
+			t.Errorf("Expected OnReload callback to receive error, instead found %v", results)
+		}
+	}
+}
+
+func TestFileReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mybasetest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "mybase.cnf")
+	write := func(contents string) {
+		t.Helper()
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatalf("Unable to write file: %v", err)
+		}
+	}
+	read := func() string {
+		t.Helper()
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Unable to read file: %v", err)
+		}
+		return string(contents)
+	}
+	includePath := filepath.Join(dir, "extra.cnf")
+	if err := ioutil.WriteFile(includePath, []byte("hidden=included\n"), 0644); err != nil {
+		t.Fatalf("Unable to write file: %v", err)
+	}
+	cfg := ParseFakeCLI(t, simpleCommand(), "mycommand arg1")
+
+	// Syntax is retained, even if the file's extension does not indicate it
+	write(`{"visible": "hello"}`)
+	file := NewFile(path)
+	file.Syntax = FileFormatJSON
+	if err := file.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error parsing file: %v", err)
+	}
+	write(`{"visible": "goodbye"}`)
+	if err := file.Reload(cfg); err != nil {
+		t.Fatalf("Unexpected error from Reload: %v", err)
+	}
+	if value, _ := file.OptionValue("visible"); value != "goodbye" {
+		t.Errorf("Expected value %q after Reload, instead found %q", "goodbye", value)
+	}
+
+	// DuplicateOptions is retained, so duplicates are still an error
+	write("visible=a\n")
+	file = NewFile(path)
+	file.DuplicateOptions = DuplicateError
+	if err := file.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error parsing file: %v", err)
+	}
+	write("visible=a\nvisible=b\n")
+	if err := file.Reload(cfg); err == nil {
+		t.Error("Expected DuplicateOptionError from Reload, but err is nil")
+	} else if _, ok := err.(DuplicateOptionError); !ok {
+		t.Errorf("Expected DuplicateOptionError from Reload, instead found %T %v", err, err)
+	}
+
+	// Write after a reload uses the include directives and line endings of the
+	// reloaded contents
+	write("visible=hello\n")
+	file = NewFile(path)
+	if err := file.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error parsing file: %v", err)
+	}
+	write("visible=hello\r\n!include " + includePath + "\r\n")
+	if err := file.Reload(cfg); err != nil {
+		t.Fatalf("Unexpected error from Reload: %v", err)
+	}
+	if err := file.Write(true); err != nil {
+		t.Fatalf("Unexpected error from Write: %v", err)
+	}
+	if contents, expected := read(), "visible=hello\r\n!include "+includePath+"\r\n"; contents != expected {
+		t.Errorf("Unexpected contents after Write: expected %q, found %q", expected, contents)
+	}
+
+	// Problems reflect the reloaded contents
+	write("visible=hello\nbadoption=1\n")
+	file = NewFile(path)
+	file.Lenient = true
+	if err := file.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error parsing file: %v", err)
+	}
+	if problems := file.Problems(); len(problems) != 1 {
+		t.Errorf("Expected 1 problem, instead found %v", problems)
+	}
+	write("visible=hello\n")
+	if err := file.Reload(cfg); err != nil {
+		t.Fatalf("Unexpected error from Reload: %v", err)
+	}
+	if problems := file.Problems(); len(problems) != 0 {
+		t.Errorf("Expected no problems after Reload, instead found %v", problems)
+	}
+
+	// Sections renamed prior to a reload are not renamed by a later Write, since
+	// Reload discards unwritten modifications
+	write("[foo]\nhidden=abc\n")
+	file = NewFile(path)
+	file.PreserveFormatting = true
+	if err := file.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error parsing file: %v", err)
+	}
+	if err := file.RenameSection("foo", "bar"); err != nil {
+		t.Fatalf("Unexpected error from RenameSection: %v", err)
+	}
+	write("[foo]\nhidden=abc\n[baz]\nhidden=xyz\n")
+	if err := file.Reload(cfg); err != nil {
+		t.Fatalf("Unexpected error from Reload: %v", err)
+	}
+	file.SetOptionValue("baz", "hidden", "def")
+	if err := file.Write(true); err != nil {
+		t.Fatalf("Unexpected error from Write: %v", err)
+	}
+	if contents, expected := read(), "[foo]\nhidden=abc\n[baz]\nhidden=def\n"; contents != expected {
+		t.Errorf("Unexpected contents after Write: expected %q, found %q", expected, contents)
+	}
+}
+
+func TestFileReloadSettings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mybasetest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "mybase.cnf")
+	if err := ioutil.WriteFile(path, []byte("visible=hello\n"), 0644); err != nil {
+		t.Fatalf("Unable to write file: %v", err)
+	}
+	cfg := ParseFakeCLI(t, simpleCommand(), "mycommand arg1")
+
+	// Give every exported field a non-zero value, so that any setting which
+	// Reload fails to retain is detected, including ones added in the future
+	file := NewFile(path)
+	v := reflect.ValueOf(file).Elem()
+	for n := 0; n < v.NumField(); n++ {
+		field, sf := v.Field(n), v.Type().Field(n)
+		if sf.PkgPath != "" || sf.Name == "Dir" || sf.Name == "Name" {
+			continue
+		}
+		switch field.Kind() {
+		case reflect.Bool:
+			field.SetBool(true)
+		case reflect.Int, reflect.Int64:
+			field.SetInt(int64(n + 1))
+		case reflect.String:
+			field.SetString("\r\n")
+		case reflect.Func:
+			field.Set(reflect.MakeFunc(field.Type(), func(args []reflect.Value) []reflect.Value {
+				results := make([]reflect.Value, field.Type().NumOut())
+				for i := range results {
+					results[i] = reflect.Zero(field.Type().Out(i))
+				}
+				return results
+			}))
+		default:
+			t.Fatalf("Field %s has unexpected kind %s; update this test", sf.Name, field.Kind())
+		}
+	}
+	file.SetPermissions(0640)
+	file.IgnoreOptions("doesnt-exist")
+	file.AllowSections("foo")
+
+	// Parsing may fail due to the arbitrary settings; only the settings matter
+	fresh, _ := file.reparse(cfg)
+	fv := reflect.ValueOf(fresh).Elem()
+	for n := 0; n < v.NumField(); n++ {
+		field, freshField, sf := v.Field(n), fv.Field(n), v.Type().Field(n)
+		if sf.PkgPath != "" {
+			continue
+		}
+		if field.Kind() == reflect.Func {
+			if field.Pointer() != freshField.Pointer() {
+				t.Errorf("Field %s not retained by Reload", sf.Name)
+			}
+		} else if !reflect.DeepEqual(field.Interface(), freshField.Interface()) {
+			t.Errorf("Field %s not retained by Reload: expected %v, found %v", sf.Name, field.Interface(), freshField.Interface())
+		}
+	}
+	if perm, set := fresh.permissions(); perm != 0640 || !set {
+		t.Errorf("SetPermissions not retained by Reload: found %v, %t", perm, set)
+	}
+	if !fresh.ignoredOptionNames["doesnt-exist"] || !reflect.DeepEqual(fresh.allowedSections, []string{"foo"}) {
+		t.Errorf("IgnoreOptions or AllowSections not retained by Reload: %v, %v", fresh.ignoredOptionNames, fresh.allowedSections)
+	}
+}
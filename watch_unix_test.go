@@ -0,0 +1,65 @@
+//go:build !windows
+// +build !windows
+
+package mybase
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestConfigReloadOnSignal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mybasetest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "mybase.cnf")
+	if err := ioutil.WriteFile(path, []byte("visible=hello\n"), 0644); err != nil {
+		t.Fatalf("Unable to write file: %v", err)
+	}
+	cfg := ParseFakeCLI(t, simpleCommand(), "mycommand arg1")
+	file := NewFile(path)
+	if err := file.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error parsing file: %v", err)
+	}
+	cfg.AddSource(file)
+	reloaded := make(chan error, 10)
+	cfg.OnReload(func(err error) {
+		reloaded <- err
+	})
+	if err := ioutil.WriteFile(path, []byte("visible=signaled\n"), 0644); err != nil {
+		t.Fatalf("Unable to write file: %v", err)
+	}
+
+	// Ensure SIGHUP cannot terminate the test before ReloadOnSignal handles it
+	guard := make(chan os.Signal, 1)
+	signal.Notify(guard, syscall.SIGHUP)
+	defer signal.Stop(guard)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go cfg.ReloadOnSignal(ctx)
+	deadline := time.After(5 * time.Second)
+	for done := false; !done; {
+		syscall.Kill(os.Getpid(), syscall.SIGHUP)
+		select {
+		case err := <-reloaded:
+			if err != nil {
+				t.Errorf("Unexpected error from signal-driven reload: %v", err)
+			}
+			done = true
+		case <-time.After(20 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("Timed out waiting for signal-driven reload")
+		}
+	}
+	if cfg.Get("visible") != "signaled" {
+		t.Errorf("Unexpected value after signal-driven reload: %q", cfg.Get("visible"))
+	}
+}
@@ -0,0 +1,232 @@
+package mybase
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// diffContextLines is the number of unchanged lines shown around each change
+// by File.WriteDiff.
+const diffContextLines = 3
+
+// WriteDiff writes a unified diff to w, describing the changes that Write
+// would make to the file on disk, without modifying the file or the in-memory
+// state of f. This permits a program to show the user a pending change to
+// their option file, and ask for confirmation before calling Write. If the
+// file does not exist on disk, the diff shows it being created from
+// /dev/null. Nothing is written to w if Write would not change the file, or
+// would skip writing it due to an empty configuration.
+func (f *File) WriteDiff(w io.Writer) error {
+	f.mu.Lock()
+	if err := f.loadPending(nil); err != nil {
+		f.unlock()
+		return err
+	}
+	contents, ok := f.renderContents()
+	f.unlock()
+	if !ok {
+		return nil
+	}
+
+	path, oldPath := f.Path(), f.Path()
+	var existing string
+	if r, err := f.open(); os.IsNotExist(err) {
+		oldPath = "/dev/null"
+	} else if err != nil {
+		return err
+	} else {
+		data, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+		existing = string(data)
+	}
+	if existing == contents {
+		return nil
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "--- %s\n+++ %s\n", oldPath, path)
+	writeUnifiedHunks(bw, diffLines(splitLinesKeepEnds(existing), splitLinesKeepEnds(contents)))
+	return bw.Flush()
+}
+
+// diffOp is one line of an edit script produced by diffLines. Kind is ' ' for
+// a line present in both inputs, '-' for a deleted line, or '+' for an
+// inserted line.
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// splitLinesKeepEnds splits s into lines, each retaining its "\n" terminator.
+// Only the final line may lack a terminator.
+func splitLinesKeepEnds(s string) []string {
+	var lines []string
+	for s != "" {
+		end := strings.IndexByte(s, '\n') + 1
+		if end == 0 {
+			end = len(s)
+		}
+		lines = append(lines, s[:end])
+		s = s[end:]
+	}
+	return lines
+}
+
+// diffLines returns a minimal edit script transforming a into b, using the
+// Myers algorithm. Lines common to the start or end of both inputs are handled
+// directly, since edits to option files typically affect only a few lines.
+func diffLines(a, b []string) []diffOp {
+	var prefix, suffix int
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	ops = append(ops, myersDiff(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
+
+// myersDiff implements the core of diffLines.
+func myersDiff(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	offset := n + m
+	v := make([]int, 2*offset+2)
+	var trace [][]int // state of v at the start of each round
+	for d := 0; d <= offset; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] // step down: insertion
+			} else {
+				x = v[offset+k-1] + 1 // step right: deletion
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return myersBacktrack(a, b, trace, offset)
+			}
+		}
+	}
+	return nil // not reached, since d == n+m always suffices
+}
+
+// myersBacktrack converts the trace from myersDiff into an edit script.
+func myersBacktrack(a, b []string, trace [][]int, offset int) []diffOp {
+	var reversed []diffOp
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			reversed = append(reversed, diffOp{' ', a[x-1]})
+			x, y = x-1, y-1
+		}
+		if x == prevX {
+			reversed = append(reversed, diffOp{'+', b[y-1]})
+			y--
+		} else {
+			reversed = append(reversed, diffOp{'-', a[x-1]})
+			x--
+		}
+	}
+	for ; x > 0 && y > 0; x, y = x-1, y-1 {
+		reversed = append(reversed, diffOp{' ', a[x-1]})
+	}
+	ops := make([]diffOp, len(reversed))
+	for n := range reversed {
+		ops[n] = reversed[len(reversed)-1-n]
+	}
+	return ops
+}
+
+// writeUnifiedHunks writes the changes in ops to w as unified diff hunks, each
+// with up to diffContextLines unchanged lines of context.
+func writeUnifiedHunks(w io.Writer, ops []diffOp) {
+	// aLines[n] and bLines[n] count the lines of each input preceding ops[n]
+	aLines, bLines := make([]int, len(ops)+1), make([]int, len(ops)+1)
+	for n, op := range ops {
+		aLines[n+1], bLines[n+1] = aLines[n], bLines[n]
+		if op.kind != '+' {
+			aLines[n+1]++
+		}
+		if op.kind != '-' {
+			bLines[n+1]++
+		}
+	}
+	rangeString := func(start, count int) string {
+		if count == 1 {
+			return fmt.Sprint(start + 1)
+		} else if count == 0 {
+			return fmt.Sprintf("%d,0", start)
+		}
+		return fmt.Sprintf("%d,%d", start+1, count)
+	}
+
+	for pos := 0; pos < len(ops); {
+		for pos < len(ops) && ops[pos].kind == ' ' {
+			pos++
+		}
+		if pos == len(ops) {
+			return
+		}
+		start := pos - diffContextLines
+		if start < 0 {
+			start = 0
+		}
+		// Extend the hunk to include any later changes whose context would
+		// otherwise overlap
+		end := pos
+		for {
+			for end < len(ops) && ops[end].kind != ' ' {
+				end++
+			}
+			next := end
+			for next < len(ops) && ops[next].kind == ' ' {
+				next++
+			}
+			if next == len(ops) || next-end > 2*diffContextLines {
+				break
+			}
+			end = next
+		}
+		if end += diffContextLines; end > len(ops) {
+			end = len(ops)
+		}
+
+		fmt.Fprintf(w, "@@ -%s +%s @@\n",
+			rangeString(aLines[start], aLines[end]-aLines[start]),
+			rangeString(bLines[start], bLines[end]-bLines[start]))
+		for _, op := range ops[start:end] {
+			io.WriteString(w, string(op.kind)+op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				io.WriteString(w, "\n\\ No newline at end of file\n")
+			}
+		}
+		pos = end
+	}
+}
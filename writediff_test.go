@@ -0,0 +1,123 @@
+package mybase
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileWriteDiff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mybasetest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.cnf")
+	cfg := ParseFakeCLI(t, simpleCommand(), "mycommand arg1")
+
+	// A new file is shown being created
+	f := NewFile(path)
+	f.SetOptionValue("", "visible", "1")
+	var buf bytes.Buffer
+	if err := f.WriteDiff(&buf); err != nil {
+		t.Fatalf("Unexpected error from WriteDiff: %v", err)
+	}
+	expected := "--- /dev/null\n+++ " + path + "\n@@ -0,0 +1 @@\n+visible=1\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected diff for new file:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+	if f.Exists() {
+		t.Fatal("Expected WriteDiff not to create the file")
+	}
+
+	contents := "# comment\nvisible=1\n\n[a]\nhasshort=1\nbool1\nbool2\nhidden=x\n\n[b]\nhasshort=2\nbool1\nbool2\nhidden=y\ntruthybool=0\n"
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("Unable to write file: %v", err)
+	}
+	f = NewFile(path)
+	f.PreserveFormatting = true
+	if err := f.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error from Parse: %v", err)
+	}
+
+	// No output if nothing would change
+	buf.Reset()
+	if err := f.WriteDiff(&buf); err != nil || buf.Len() > 0 {
+		t.Errorf("Expected no diff for unmodified file, instead found %q, err=%v", buf.String(), err)
+	}
+
+	// Separate changes are shown in separate hunks, and the file is unaffected
+	f.SetOptionValue("", "visible", "2")
+	f.SetOptionValue("b", "hidden", "z")
+	f.UnsetOptionValue("b", "truthybool")
+	buf.Reset()
+	if err := f.WriteDiff(&buf); err != nil {
+		t.Fatalf("Unexpected error from WriteDiff: %v", err)
+	}
+	expected = "--- " + path + "\n+++ " + path + "\n" +
+		"@@ -1,5 +1,5 @@\n # comment\n-visible=1\n+visible=2\n \n [a]\n hasshort=1\n" +
+		"@@ -11,5 +11,4 @@\n hasshort=2\n bool1\n bool2\n-hidden=y\n-truthybool=0\n+hidden=z\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected diff:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+	if actual, _ := ioutil.ReadFile(path); string(actual) != contents {
+		t.Errorf("Expected WriteDiff not to modify the file, but contents are now %q", actual)
+	}
+
+	// After Write, there is no longer any difference
+	if err := f.Write(true); err != nil {
+		t.Fatalf("Unexpected error from Write: %v", err)
+	}
+	buf.Reset()
+	if err := f.WriteDiff(&buf); err != nil || buf.Len() > 0 {
+		t.Errorf("Expected no diff after Write, instead found %q, err=%v", buf.String(), err)
+	}
+
+	// A missing trailing newline is noted
+	if err := ioutil.WriteFile(path, []byte("visible=2"), 0644); err != nil {
+		t.Fatalf("Unable to write file: %v", err)
+	}
+	f = NewFile(path)
+	f.PreserveFormatting = true
+	if err := f.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error from Parse: %v", err)
+	}
+	f.SetOptionValue("", "bool1", "1")
+	buf.Reset()
+	if err := f.WriteDiff(&buf); err != nil {
+		t.Fatalf("Unexpected error from WriteDiff: %v", err)
+	} else if !strings.Contains(buf.String(), "-visible=2\n\\ No newline at end of file\n") {
+		t.Errorf("Unexpected diff:\n%s", buf.String())
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	cases := []struct {
+		a, b string
+	}{
+		{"", ""},
+		{"a\nb\nc\n", "a\nb\nc\n"},
+		{"a\nb\nc\n", ""},
+		{"", "x\ny\n"},
+		{"a\nb\nc\nd\n", "a\nx\nc\ny\n"},
+		{"a\nb\nc\na\nb\nb\na\n", "c\nb\na\nb\na\nc\n"},
+	}
+	for _, c := range cases {
+		ops := diffLines(splitLinesKeepEnds(c.a), splitLinesKeepEnds(c.b))
+		var a, b strings.Builder
+		for _, op := range ops {
+			if op.kind != '+' {
+				a.WriteString(op.line)
+			}
+			if op.kind != '-' {
+				b.WriteString(op.line)
+			}
+		}
+		if a.String() != c.a || b.String() != c.b {
+			t.Errorf("Edit script for %q -> %q does not reproduce inputs: %q -> %q", c.a, c.b, a.String(), b.String())
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package mybase
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// WriteSection writes the named section to disk, replacing only the lines of
+// that section in the file's existing contents on disk, and leaving all other
+// lines untouched, even if they have been modified on disk since f was parsed.
+// This permits a program to manage its own section of a shared option file
+// without reordering or reformatting sections owned by other programs. The
+// default nameless section "" may also be written in this manner.
+//
+// The section's lines begin with its header, and end with its last line which
+// is not blank or a comment; any blank lines and comments immediately prior to
+// the next section header are considered to precede the next section. The new
+// lines are rendered in the same manner as Write, including the effects of
+// f.PreserveFormatting. If the section is not present on disk, it is appended
+// to the end of the file; if the section has been removed from f, for example
+// by DeleteSection, its lines are removed from the file on disk. If the file
+// does not exist, it is created containing only the section. Any pending
+// changes to other sections of f are not written.
+//
+// Only ini-style option files are supported. An error is returned if the
+// section contains conditional directives, either on disk or in f, since its
+// lines could not be replaced safely. f.BackupOnWrite and f.AtomicWrite are
+// honored in the same manner as Write. Files returned by NewFileFS cannot be
+// written, and an error is returned.
+func (f *File) WriteSection(name string) error {
+	if f.fsys != nil {
+		return fmt.Errorf("Cannot write %s: file is from a read-only filesystem", f.Path())
+	} else if f.syntax() != FileFormatINI {
+		return fmt.Errorf("Cannot write section [%s] of %s: only ini-style option files are supported", name, f.Path())
+	}
+
+	f.mu.Lock()
+	defer f.unlock()
+	if err := f.loadPending(nil); err != nil {
+		return err
+	}
+	rendered, _ := f.renderContents()
+	ours := splitLinesKeepEnds(rendered)
+	oursStart, oursEnd, oursFound := sectionLineRange(ours, name)
+
+	// Locate the section on disk by the name of its header when f was parsed,
+	// which differs if the section has since been renamed
+	diskName := name
+	for from, to := range f.renamed {
+		if to == name {
+			diskName = from
+		}
+	}
+	var existing string
+	if data, err := ioutil.ReadFile(f.Path()); err == nil {
+		existing = string(data)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	disk := splitLinesKeepEnds(existing)
+	diskStart, diskEnd, diskFound := sectionLineRange(disk, diskName)
+	if hasConditionalLine(ours[oursStart:oursEnd]) || hasConditionalLine(disk[diskStart:diskEnd]) {
+		return fmt.Errorf("Cannot write section [%s] of %s: section contains conditional directives", name, f.Path())
+	}
+
+	var replacement string
+	if oursFound {
+		replacement = strings.Join(ours[oursStart:oursEnd], "")
+	}
+	var contents string
+	if diskFound {
+		// Retain separation from a following section header, or when removing a
+		// section, avoid leaving behind the blank lines which preceded it
+		if name == "" && replacement != "" && diskStart == diskEnd && diskEnd < len(disk) {
+			replacement += f.newline()
+		} else if replacement == "" {
+			for diskStart > 0 && strings.TrimSpace(disk[diskStart-1]) == "" {
+				diskStart--
+			}
+		}
+		contents = strings.Join(disk[:diskStart], "") + replacement + strings.Join(disk[diskEnd:], "")
+	} else if replacement != "" {
+		contents = existing
+		if contents != "" && !strings.HasSuffix(contents, "\n") {
+			contents += f.newline()
+		}
+		if contents != "" {
+			contents += f.newline()
+		}
+		contents += replacement
+	} else {
+		return nil // section is neither on disk nor in f
+	}
+
+	perm, explicitPerm := f.permissions()
+	if err := f.writeContents(contents, true, f.AtomicWrite, perm, explicitPerm); err != nil {
+		return err
+	}
+	delete(f.edited, name)
+	delete(f.renamed, diskName)
+	if f.read {
+		f.contents = contents
+	}
+	return nil
+}
+
+// sectionLineRange returns the indexes [start, end) of the lines belonging to
+// the named section, as described by WriteSection. Each line must retain its
+// terminator, as returned by splitLinesKeepEnds. For the default section "",
+// found is always true; for other sections, found is false if lines do not
+// contain a header for the section, other than a "[[name]]" header.
+func sectionLineRange(lines []string, name string) (start, end int, found bool) {
+	start = -1
+	if name == "" {
+		start = 0
+	}
+	end = len(lines)
+	var continued bool
+	for n, line := range lines {
+		text := strings.TrimRight(line, "\r\n")
+		wasContinued := continued
+		continued = continuesLine(text)
+		if wasContinued {
+			continue
+		}
+		parsed, err := parseLine(text)
+		if err != nil || parsed.kind != lineTypeSectionHeader {
+			continue
+		}
+		if start >= 0 {
+			end = n
+			break
+		} else if parsed.sectionName == name && !parsed.repeated {
+			start = n
+		}
+	}
+	if start < 0 {
+		return 0, 0, false
+	}
+	for end > start {
+		parsed, err := parseLine(strings.TrimRight(lines[end-1], "\r\n"))
+		if err != nil || (parsed.kind != lineTypeBlank && parsed.kind != lineTypeComment) {
+			break
+		}
+		end--
+	}
+	return start, end, true
+}
+
+// hasConditionalLine returns true if any of lines is a conditional directive,
+// such as "!if".
+func hasConditionalLine(lines []string) bool {
+	for _, line := range lines {
+		parsed, err := parseLine(strings.TrimRight(line, "\r\n"))
+		if err == nil && parsed.kind == lineTypeDirective && isConditionalDirective(parsed.key) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package mybase
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileWriteSection(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mybasetest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "shared.cnf")
+	cfg := ParseFakeCLI(t, simpleCommand(), "mycommand arg1")
+
+	contents := "# shared file\nvisible=1\n\n[a]\nhasshort = 1   # owned by a\nbool1\n\n# owned by another tool\n[b]\nhidden=x\n"
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("Unable to write file: %v", err)
+	}
+	f := NewFile(path)
+	if err := f.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error from Parse: %v", err)
+	}
+	f.SetOptionValue("a", "hasshort", "2")
+	f.SetOptionValue("b", "hidden", "notwritten")
+
+	// Simulate another program modifying its own section in the meantime
+	contents = "# shared file\nvisible=1\n\n[a]\nhasshort = 1   # owned by a\nbool1\n\n# owned by another tool\n[b]\nhidden  =  y\n"
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("Unable to write file: %v", err)
+	}
+	assertContents := func(expected string) {
+		t.Helper()
+		if actual, err := ioutil.ReadFile(path); err != nil {
+			t.Fatalf("Unable to read file: %v", err)
+		} else if string(actual) != expected {
+			t.Errorf("Unexpected file contents:\n%s\nexpected:\n%s", actual, expected)
+		}
+	}
+
+	// Without PreserveFormatting, only the section itself is regenerated
+	if err := f.WriteSection("a"); err != nil {
+		t.Fatalf("Unexpected error from WriteSection: %v", err)
+	}
+	assertContents("# shared file\nvisible=1\n\n[a]\nbool1\nhasshort=2\n\n# owned by another tool\n[b]\nhidden  =  y\n")
+
+	// With PreserveFormatting, the section's own comments are retained too
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("Unable to write file: %v", err)
+	}
+	f = NewFile(path)
+	f.PreserveFormatting = true
+	if err := f.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error from Parse: %v", err)
+	}
+	f.SetOptionValue("a", "hasshort", "3")
+	f.SetOptionValue("", "visible", "2")
+	if err := f.WriteSection("a"); err != nil {
+		t.Fatalf("Unexpected error from WriteSection: %v", err)
+	}
+	assertContents("# shared file\nvisible=1\n\n[a]\nhasshort=3 # owned by a\nbool1\n\n# owned by another tool\n[b]\nhidden  =  y\n")
+
+	// The default section may be written as well, and pending changes to other
+	// sections remain pending
+	if err := f.WriteSection(""); err != nil {
+		t.Fatalf("Unexpected error from WriteSection: %v", err)
+	}
+	assertContents("# shared file\nvisible=2\n\n[a]\nhasshort=3 # owned by a\nbool1\n\n# owned by another tool\n[b]\nhidden  =  y\n")
+
+	// A new section is appended, and a deleted section is removed
+	f.SetOptionValue("c", "bool2", "1")
+	if err := f.WriteSection("c"); err != nil {
+		t.Fatalf("Unexpected error from WriteSection: %v", err)
+	}
+	assertContents("# shared file\nvisible=2\n\n[a]\nhasshort=3 # owned by a\nbool1\n\n# owned by another tool\n[b]\nhidden  =  y\n\n[c]\nbool2=1\n")
+	f.DeleteSection("a")
+	if err := f.WriteSection("a"); err != nil {
+		t.Fatalf("Unexpected error from WriteSection: %v", err)
+	}
+	assertContents("# shared file\nvisible=2\n\n# owned by another tool\n[b]\nhidden  =  y\n\n[c]\nbool2=1\n")
+
+	// Conditional directives within the section are not supported
+	if err := ioutil.WriteFile(path, []byte("[a]\n!if os == nonexistent\nbool1\n!endif\n"), 0644); err != nil {
+		t.Fatalf("Unable to write file: %v", err)
+	}
+	f = NewFile(path)
+	if err := f.Parse(cfg); err != nil {
+		t.Fatalf("Unexpected error from Parse: %v", err)
+	}
+	if err := f.WriteSection("a"); err == nil {
+		t.Error("Expected error from WriteSection with conditional directives, but received nil")
+	}
+
+	// Other formats are not supported
+	f = NewFile(filepath.Join(dir, "test.json"))
+	if err := f.WriteSection(""); err == nil {
+		t.Error("Expected error from WriteSection with JSON file, but received nil")
+	}
+}